@@ -0,0 +1,102 @@
+package main
+
+// The library's C ABI. Strings are passed as NUL-terminated char*, binary
+// data as a char* with a separate length, and host callbacks as function
+// pointers carried in uintptr_t. go build -buildmode=c-shared writes the
+// matching prototypes to libgoserver.h, together with the version and
+// status codes below.
+//
+// Exports that do not return a value or a string, FreeString aside,
+// return a status: 0, or a negative GOSERVER_ERR_* code, with GetLastError
+// describing the failure.
+//
+// Version 1 took every string as a uintptr_t. The register layout is the
+// same, so binaries built against it keep working; C sources that still
+// hold strings as integers can wrap them in GOSERVER_CSTR. Version 2
+// returned nothing from most exports; callers that ignore the status
+// are unaffected.
+
+/*
+#include <stdint.h>
+
+#define GOSERVER_ABI_VERSION 3
+
+#define GOSERVER_OK 0
+#define GOSERVER_ERR_FAILED -1
+#define GOSERVER_ERR_INVALID -2
+#define GOSERVER_ERR_NOT_FOUND -3
+
+#define GOSERVER_CSTR(p) ((char*)(uintptr_t)(p))
+*/
+import "C"
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// Export status codes, as defined for C above
+const (
+	exportFailed   = int(C.GOSERVER_ERR_FAILED)    // the call could not be carried out
+	exportInvalid  = int(C.GOSERVER_ERR_INVALID)   // a parameter is nil or malformed
+	exportNotFound = int(C.GOSERVER_ERR_NOT_FOUND) // the route, group, host, or other target does not exist
+)
+
+var (
+	lastError   string
+	lastErrorMu sync.Mutex
+)
+
+// recordError logs a failed export call and keeps its message for
+// GetLastError
+func recordError(msg string, args ...any) {
+	slog.Error(msg, args...)
+	setLastError(msg, args...)
+}
+
+// setLastError keeps the message of a failed export call for GetLastError.
+// args are slog key-value pairs, appended to the message.
+func setLastError(msg string, args ...any) {
+	var b strings.Builder
+	b.WriteString(msg)
+	for i := 0; i+1 < len(args); i += 2 {
+		if i == 0 {
+			b.WriteString(": ")
+		} else {
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(&b, "%v=%v", args[i], args[i+1])
+	}
+	lastErrorMu.Lock()
+	lastError = b.String()
+	lastErrorMu.Unlock()
+}
+
+// exportError records a failed export call and returns its status code
+func exportError(code int, msg string, args ...any) int {
+	recordError(msg, args...)
+	return code
+}
+
+//go:generate go build -buildmode=c-shared -o libgoserver.so .
+
+// GetABIVersion returns the GOSERVER_ABI_VERSION the library was built
+// with, so hosts can refuse a library whose signatures they do not match
+//
+//export GetABIVersion
+func GetABIVersion() int {
+	return int(C.GOSERVER_ABI_VERSION)
+}
+
+// GetLastError describes the most recent failed export call on any
+// thread, or returns an empty string if none has failed. Successful calls
+// leave it in place, so read it right after a call reports an error. The
+// caller frees the string with FreeString.
+//
+//export GetLastError
+func GetLastError() *C.char {
+	lastErrorMu.Lock()
+	defer lastErrorMu.Unlock()
+	return C.CString(lastError)
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"C"
+	"container/list"
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaultAdmissionCapacity is the number of requests the dispatcher serves
+// concurrently before new arrivals are queued, until SetAdmissionCapacity
+// sets another
+const defaultAdmissionCapacity = 128
+
+// admissionWaiter is one queued request waiting for a dispatcher slot
+type admissionWaiter struct {
+	ready    chan struct{}
+	admitted bool
+	client   string
+	elem     *list.Element
+}
+
+// admissionController is a bounded FIFO admission queue. Waiters are kept in
+// per-client queues and slots are handed out round-robin across clients so a
+// single busy client cannot monopolize capacity.
+type admissionController struct {
+	mu        sync.Mutex
+	capacity  int
+	inFlight  int
+	queueSize int
+	maxWait   time.Duration
+	waiting   int
+	queues    map[string]*list.List
+	clients   []string // clients with queued waiters, in round-robin order
+	nextIdx   int
+}
+
+var admission = &admissionController{
+	capacity: defaultAdmissionCapacity,
+	queues:   make(map[string]*list.List),
+}
+
+// enabled reports whether queueing is configured. Must hold a.mu.
+func (a *admissionController) enabled() bool {
+	return a.queueSize > 0
+}
+
+// acquire waits for a dispatcher slot. It returns false if the queue is full
+// or the wait exceeded maxWait; otherwise the caller must call release.
+func (a *admissionController) acquire(ctx context.Context, client string) (bool, func()) {
+	a.mu.Lock()
+	if !a.enabled() {
+		a.mu.Unlock()
+		return true, func() {}
+	}
+	if a.inFlight < a.capacity && a.waiting == 0 {
+		a.inFlight++
+		a.mu.Unlock()
+		return true, a.release
+	}
+	if a.waiting >= a.queueSize {
+		a.mu.Unlock()
+		return false, nil
+	}
+	waiter := a.enqueue(client)
+	maxWait := a.maxWait
+	a.mu.Unlock()
+
+	stats.AdmissionQueued.Add(1)
+	start := time.Now()
+	timer := time.NewTimer(maxWait)
+	defer timer.Stop()
+	defer func() { stats.AdmissionWaitNanos.Add(int64(time.Since(start))) }()
+
+	select {
+	case <-waiter.ready:
+		return true, a.release
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if waiter.admitted {
+		// A slot was handed over just as the wait expired
+		return true, a.release
+	}
+	a.remove(waiter)
+	return false, nil
+}
+
+// enqueue adds a waiter to its client's queue. Must hold a.mu.
+func (a *admissionController) enqueue(client string) *admissionWaiter {
+	queue, ok := a.queues[client]
+	if !ok {
+		queue = list.New()
+		a.queues[client] = queue
+		a.clients = append(a.clients, client)
+	}
+	waiter := &admissionWaiter{ready: make(chan struct{}), client: client}
+	waiter.elem = queue.PushBack(waiter)
+	a.waiting++
+	return waiter
+}
+
+// remove drops a waiter that gave up. Must hold a.mu.
+func (a *admissionController) remove(waiter *admissionWaiter) {
+	queue := a.queues[waiter.client]
+	queue.Remove(waiter.elem)
+	a.waiting--
+	if queue.Len() == 0 {
+		a.dropClient(waiter.client)
+	}
+}
+
+// dropClient removes a client with no remaining waiters. Must hold a.mu.
+func (a *admissionController) dropClient(client string) {
+	delete(a.queues, client)
+	for i, c := range a.clients {
+		if c == client {
+			a.clients = append(a.clients[:i], a.clients[i+1:]...)
+			if a.nextIdx > i {
+				a.nextIdx--
+			}
+			break
+		}
+	}
+	if a.nextIdx >= len(a.clients) {
+		a.nextIdx = 0
+	}
+}
+
+// release frees a slot and admits the next waiter
+func (a *admissionController) release() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.inFlight--
+	a.admitWaiters()
+}
+
+// admitWaiters hands free slots to queued waiters, rotating across
+// clients. Must hold a.mu.
+func (a *admissionController) admitWaiters() {
+	for a.inFlight < a.capacity && len(a.clients) > 0 {
+		client := a.clients[a.nextIdx]
+		queue := a.queues[client]
+		waiter := queue.Remove(queue.Front()).(*admissionWaiter)
+		a.waiting--
+		a.inFlight++
+		waiter.admitted = true
+		close(waiter.ready)
+		if queue.Len() == 0 {
+			a.dropClient(client)
+		} else {
+			a.nextIdx = (a.nextIdx + 1) % len(a.clients)
+		}
+	}
+}
+
+// snapshot returns the current queue depth and in-flight count
+func (a *admissionController) snapshot() (depth int, inFlight int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.waiting, a.inFlight
+}
+
+// clientKey identifies the client used for fair queueing
+func clientKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// admissionMiddleware queues requests until a dispatcher slot is free and
+// rejects them with 503 once the wait limit is exceeded
+func admissionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, release := admission.acquire(r.Context(), clientKey(r))
+		if !ok {
+			stats.AdmissionRejected.Add(1)
+			slog.Warn("Admission rejected", "method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, `{"error": "Server is busy, please retry"}`, http.StatusServiceUnavailable)
+			return
+		}
+		release = sync.OnceFunc(release)
+		defer release()
+		if info := requestInfoFrom(r); info != nil {
+			info.ReleaseAdmission = release
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ConfigureAdmissionQueue enables fair FIFO queueing in front of the
+// dispatcher. Up to size requests wait at most maxWaitMs for a free slot
+// before receiving 503. A size of 0 disables queueing.
+//
+//export ConfigureAdmissionQueue
+func ConfigureAdmissionQueue(size int, maxWaitMs int) int {
+	if size < 0 || maxWaitMs < 0 {
+		return exportError(exportInvalid, "Invalid admission queue settings", "size", size, "max_wait_ms", maxWaitMs)
+	}
+	admission.mu.Lock()
+	admission.queueSize = size
+	admission.maxWait = time.Duration(maxWaitMs) * time.Millisecond
+	admission.mu.Unlock()
+	slog.Info("Admission queue configured", "size", size, "max_wait_ms", maxWaitMs)
+	auditConfigChange("ConfigureAdmissionQueue", map[string]string{"size": strconv.Itoa(size), "max_wait_ms": strconv.Itoa(maxWaitMs)})
+
+	return 0
+}
+
+// SetAdmissionCapacity sets how many requests the dispatcher serves at
+// once before the admission queue holds new ones, 128 by default. Raising
+// it admits waiting requests at once; lowering it lets the requests
+// already admitted finish. Returns 0, or a negative status if capacity is
+// not positive.
+//
+//export SetAdmissionCapacity
+func SetAdmissionCapacity(capacity int) int {
+	if capacity <= 0 {
+		return exportError(exportInvalid, "Invalid admission capacity", "capacity", capacity)
+	}
+	admission.mu.Lock()
+	admission.capacity = capacity
+	admission.admitWaiters()
+	admission.mu.Unlock()
+	slog.Info("Admission capacity set", "capacity", capacity)
+	auditConfigChange("SetAdmissionCapacity", map[string]string{"capacity": strconv.Itoa(capacity)})
+
+	return 0
+}
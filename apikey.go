@@ -0,0 +1,174 @@
+package main
+
+import (
+	"C"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// apiKeyOptions are the "apikey" middleware options. Query lookup is skipped
+// when Query is set to "".
+type apiKeyOptions struct {
+	Header      string   `json:"header"`
+	Query       *string  `json:"query"`
+	ExemptPaths []string `json:"exempt_paths"`
+}
+
+// Registered keys are stored by SHA-256 so the raw keys are not kept around
+var (
+	apiKeys   = make(map[[sha256.Size]byte][]string)
+	apiKeysMu sync.RWMutex
+)
+
+// apiKeyScopes returns the scopes granted to key, and whether it is known
+func apiKeyScopes(key string) ([]string, bool) {
+	apiKeysMu.RLock()
+	defer apiKeysMu.RUnlock()
+	scopes, ok := apiKeys[sha256.Sum256([]byte(key))]
+	return scopes, ok
+}
+
+// missingScopes returns the required scopes the key was not granted
+func missingScopes(granted, required []string) []string {
+	var missing []string
+	for _, scope := range required {
+		if !containsFold(granted, scope) {
+			missing = append(missing, scope)
+		}
+	}
+	return missing
+}
+
+// newAPIKeyMiddleware builds the "apikey" middleware. Requests need a
+// registered key in the header or query parameter, and the key must hold
+// every scope the matched route requires.
+func newAPIKeyMiddleware(options []byte) (func(http.Handler) http.Handler, error) {
+	defaultQuery := "api_key"
+	opts := apiKeyOptions{Header: "X-API-Key", Query: &defaultQuery}
+	if len(options) > 0 {
+		if err := json.Unmarshal(options, &opts); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Header == "" && (opts.Query == nil || *opts.Query == "") {
+		return nil, fmt.Errorf("header or query must be set")
+	}
+	query := ""
+	if opts.Query != nil {
+		query = *opts.Query
+	}
+	if opts.ExemptPaths == nil {
+		opts.ExemptPaths = authExemptPrefixes
+	}
+
+	if opts.Header != "" {
+		registerSecurityScheme("apiKeyHeader", map[string]interface{}{"type": "apiKey", "in": "header", "name": opts.Header})
+	}
+	if query != "" {
+		registerSecurityScheme("apiKeyQuery", map[string]interface{}{"type": "apiKey", "in": "query", "name": query})
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if authExempt(r.URL.Path, opts.ExemptPaths) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			var key string
+			if opts.Header != "" {
+				key = r.Header.Get(opts.Header)
+			}
+			if key == "" && query != "" {
+				key = r.URL.Query().Get(query)
+			}
+			if key == "" {
+				http.Error(w, `{"error": "Missing API key"}`, http.StatusUnauthorized)
+				return
+			}
+			granted, ok := apiKeyScopes(key)
+			if !ok {
+				slog.Debug("Unknown API key", "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+				http.Error(w, `{"error": "Invalid API key"}`, http.StatusUnauthorized)
+				return
+			}
+
+			routesMu.RLock()
+			route, _, _, found := findRoute(r.Host, r.URL.Path, r.Method)
+			routesMu.RUnlock()
+			if found {
+				if missing := missingScopes(granted, route.RequiredScopes); len(missing) > 0 {
+					http.Error(w, fmt.Sprintf(`{"error": "API key is missing required scopes: %s"}`, strings.Join(missing, ", ")), http.StatusForbidden)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+// RegisterAPIKey adds or replaces an API key for the "apikey" middleware.
+// cScopes is a JSON array of scope names granted to the key.
+//
+//export RegisterAPIKey
+func RegisterAPIKey(cKey *C.char, cScopes *C.char) int {
+	if cKey == nil || cScopes == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in RegisterAPIKey")
+	}
+	key := C.GoString(cKey)
+	if key == "" {
+		return exportError(exportInvalid, "Empty key in RegisterAPIKey")
+	}
+	var scopes []string
+	if raw := C.GoString(cScopes); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &scopes); err != nil {
+			return exportError(exportInvalid, "Invalid API key scopes", "error", err)
+		}
+	}
+
+	apiKeysMu.Lock()
+	apiKeys[sha256.Sum256([]byte(key))] = scopes
+	apiKeysMu.Unlock()
+
+	slog.Info("Registered API key", "scopes", strings.Join(scopes, ","))
+	auditConfigChange("RegisterAPIKey", map[string]string{"api_key": key, "scopes": strings.Join(scopes, ",")})
+
+	return 0
+}
+
+// SetRouteScopes sets the scopes an API key needs to call a route. cScopes
+// is a JSON array; an empty array removes the requirement.
+//
+//export SetRouteScopes
+func SetRouteScopes(cPath *C.char, cMethod *C.char, cScopes *C.char) int {
+	if cPath == nil || cMethod == nil || cScopes == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in SetRouteScopes")
+	}
+	path := C.GoString(cPath)
+	method := strings.ToUpper(C.GoString(cMethod))
+	var scopes []string
+	if err := json.Unmarshal([]byte(C.GoString(cScopes)), &scopes); err != nil {
+		return exportError(exportInvalid, "Invalid route scopes", "path", path, "method", method, "error", err)
+	}
+
+	routesMu.Lock()
+	key := path + method
+	route, exists := routes[key]
+	if !exists {
+		routesMu.Unlock()
+		return exportError(exportNotFound, "Cannot set scopes, route not found", "key", key)
+	}
+	route.RequiredScopes = scopes
+	routes[key] = route
+	routesMu.Unlock()
+
+	slog.Info("Route scopes set", "key", key, "scopes", strings.Join(scopes, ","))
+	invalidateOpenAPICache()
+	auditConfigChange("SetRouteScopes", map[string]string{"path": path, "method": method, "scopes": strings.Join(scopes, ",")})
+
+	return 0
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"C"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	auditLogCapacity = 256
+	redactedValue    = "[REDACTED]"
+)
+
+// ConfigChange is one recorded Configure*/Register* mutation
+type ConfigChange struct {
+	Time   time.Time         `json:"time"`
+	Action string            `json:"action"`
+	Params map[string]string `json:"params,omitempty"`
+}
+
+// configAuditLog is a fixed-size ring buffer of configuration changes
+type configAuditLog struct {
+	mu      sync.Mutex
+	entries [auditLogCapacity]ConfigChange
+	next    int
+	size    int
+}
+
+var auditLog configAuditLog
+
+// sensitiveParamWords mark parameters whose values are never recorded
+var sensitiveParamWords = []string{"secret", "password", "token", "credential", "private", "apikey", "api_key"}
+
+func isSensitiveParam(name string) bool {
+	name = strings.ToLower(name)
+	for _, word := range sensitiveParamWords {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *configAuditLog) record(change ConfigChange) {
+	a.mu.Lock()
+	a.entries[a.next] = change
+	a.next = (a.next + 1) % auditLogCapacity
+	if a.size < auditLogCapacity {
+		a.size++
+	}
+	a.mu.Unlock()
+}
+
+// snapshot returns the recorded changes, oldest first
+func (a *configAuditLog) snapshot() []ConfigChange {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	result := make([]ConfigChange, 0, a.size)
+	start := (a.next - a.size + auditLogCapacity) % auditLogCapacity
+	for i := 0; i < a.size; i++ {
+		result = append(result, a.entries[(start+i)%auditLogCapacity])
+	}
+	return result
+}
+
+// redactJSON replaces the values of secret-looking fields anywhere in a JSON
+// document, such as middleware options. Unparseable input is redacted whole.
+func redactJSON(raw string) string {
+	var doc interface{}
+	if err := json.Unmarshal([]byte(raw), &doc); err != nil {
+		return redactedValue
+	}
+	var walk func(v interface{}) interface{}
+	walk = func(v interface{}) interface{} {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for name, value := range v {
+				if isSensitiveParam(name) {
+					v[name] = redactedValue
+				} else {
+					v[name] = walk(value)
+				}
+			}
+		case []interface{}:
+			for i, value := range v {
+				v[i] = walk(value)
+			}
+		}
+		return v
+	}
+	clean, err := json.Marshal(walk(doc))
+	if err != nil {
+		return redactedValue
+	}
+	return string(clean)
+}
+
+// auditConfigChange records a runtime configuration mutation, redacting
+// parameters that look like secrets
+func auditConfigChange(action string, params map[string]string) {
+	var clean map[string]string
+	if len(params) > 0 {
+		clean = make(map[string]string, len(params))
+		for name, value := range params {
+			if isSensitiveParam(name) {
+				value = redactedValue
+			}
+			clean[name] = value
+		}
+	}
+	auditLog.record(ConfigChange{Time: time.Now().UTC(), Action: action, Params: clean})
+}
+
+// GetConfigAuditLog returns the recent configuration changes as a JSON array
+// C string. The caller must release it with FreeString.
+//
+//export GetConfigAuditLog
+func GetConfigAuditLog() *C.char {
+	data, err := json.Marshal(auditLog.snapshot())
+	if err != nil {
+		recordError("Error encoding config audit log", "error", err)
+		return C.CString("[]")
+	}
+	return C.CString(string(data))
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"C"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"unsafe"
+)
+
+const defaultBodyChunkSize = 64 << 10
+
+// BodyStreamOptions configures a handler route whose request body is
+// streamed rather than buffered, set with SetRouteBodyStream
+type BodyStreamOptions struct {
+	ChunkSize   int   `json:"chunk_size"`    // most bytes per ReadRequestChunk call or chunk handler call
+	MaxBodySize int64 `json:"max_body_size"` // whole body in bytes; 0 keeps the route's limit, -1 removes it
+
+	// ChunkHandler receives the body chunk by chunk before the route
+	// handler runs instead of the route handler reading it
+	ChunkHandler uintptr `json:"-"`
+}
+
+var errBodyRejected = errors.New("request body rejected by handler")
+
+// requestBodyStream is the body of a request the route handler reads with
+// ReadRequestChunk while its callback runs, or that was passed to the
+// route's chunk handler before it
+type requestBodyStream struct {
+	mu        sync.Mutex
+	body      io.Reader
+	chunkSize int
+	size      int64 // bytes read so far
+	done      bool
+	err       error // the read error ending the body early
+}
+
+var (
+	requestBodyStreams   = make(map[string]*requestBodyStream) // by request ID
+	requestBodyStreamsMu sync.Mutex
+)
+
+// openRequestBodyStream prepares a request's body for streaming. With a
+// chunk handler the body is passed to it now; otherwise the route handler
+// of requestID may read it until the returned func ends the stream.
+func openRequestBodyStream(r *http.Request, opts *BodyStreamOptions, requestID string) (*requestBodyStream, func(), error) {
+	s := &requestBodyStream{body: r.Body, chunkSize: opts.ChunkSize}
+	r.Body = http.NoBody
+	if opts.ChunkHandler != 0 {
+		return s, func() {}, s.push(r, opts.ChunkHandler, requestID)
+	}
+	requestBodyStreamsMu.Lock()
+	requestBodyStreams[requestID] = s
+	requestBodyStreamsMu.Unlock()
+	return s, func() {
+		requestBodyStreamsMu.Lock()
+		delete(requestBodyStreams, requestID)
+		requestBodyStreamsMu.Unlock()
+		s.mu.Lock()
+		s.done = true
+		s.mu.Unlock()
+	}, nil
+}
+
+func activeRequestBodyStream(requestID string) (*requestBodyStream, bool) {
+	requestBodyStreamsMu.Lock()
+	defer requestBodyStreamsMu.Unlock()
+	s, ok := requestBodyStreams[requestID]
+	return s, ok
+}
+
+// read fills buf from the body, returning 0 once it has all been read
+func (s *requestBodyStream) read(buf []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done {
+		return 0, s.err
+	}
+	if len(buf) > s.chunkSize {
+		buf = buf[:s.chunkSize]
+	}
+	n, err := io.ReadFull(s.body, buf)
+	s.size += int64(n)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		s.done = true
+		return n, nil
+	}
+	if err != nil {
+		s.done, s.err = true, err
+		return n, err
+	}
+	return n, nil
+}
+
+// failure is the error that cut the body short, if any
+func (s *requestBodyStream) failure() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// push passes the whole body to a host chunk handler, using the upload
+// handler events of multipart file parts
+func (s *requestBodyStream) push(r *http.Request, handler uintptr, requestID string) error {
+	meta, err := json.Marshal(map[string]string{
+		"request_id":     requestID,
+		"method":         r.Method,
+		"path":           r.URL.Path,
+		"content_type":   r.Header.Get("Content-Type"),
+		"content_length": strconv.FormatInt(r.ContentLength, 10),
+	})
+	if err != nil {
+		return err
+	}
+	if !callUploadHandler(handler, meta, uploadEventStart, nil) {
+		return errBodyRejected
+	}
+	buf := make([]byte, s.chunkSize)
+	for {
+		n, err := s.read(buf)
+		if n > 0 && !callUploadHandler(handler, meta, uploadEventChunk, buf[:n]) {
+			callUploadHandler(handler, meta, uploadEventAbort, nil)
+			return errBodyRejected
+		}
+		if err != nil {
+			callUploadHandler(handler, meta, uploadEventAbort, nil)
+			return err
+		}
+		if n == 0 {
+			break
+		}
+	}
+	if !callUploadHandler(handler, meta, uploadEventEnd, nil) {
+		return errBodyRejected
+	}
+	return nil
+}
+
+// writeBodyStreamError answers a request whose streamed body could not be
+// read or was rejected
+func writeBodyStreamError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errBodyRejected) {
+		http.Error(w, `{"error": "Request body rejected by handler"}`, http.StatusBadRequest)
+		return
+	}
+	writeBodyReadError(w, err)
+}
+
+// bodyStreamRequestBody documents a streamed body as raw bytes in OpenAPI
+func bodyStreamRequestBody() map[string]interface{} {
+	return map[string]interface{}{
+		"required": true,
+		"content": map[string]interface{}{
+			"application/octet-stream": map[string]interface{}{
+				"schema": map[string]interface{}{"type": "string", "format": "binary"},
+			},
+		},
+	}
+}
+
+// ReadRequestChunk reads the next bytes of the streamed body of the request
+// cRequestID into the bufLen bytes at cBuf, at most the route's chunk size.
+// It can be called while the route handler callback runs. Returns the
+// number of bytes read, 0 once the whole body has been read, or a negative
+// status if the request does not stream its body or reading fails; a body
+// over the route's limit is then answered with 413 whatever the handler
+// returns.
+//
+//export ReadRequestChunk
+func ReadRequestChunk(cRequestID *C.char, cBuf *C.char, bufLen int) int {
+	if cRequestID == nil || cBuf == nil || bufLen <= 0 {
+		return exportError(exportInvalid, "One or more parameters are nil in ReadRequestChunk")
+	}
+	requestID := C.GoString(cRequestID)
+	s, ok := activeRequestBodyStream(requestID)
+	if !ok {
+		slog.Debug("Cannot read request chunk, request not streaming its body", "request_id", requestID)
+		setLastError("Cannot read request chunk, request not streaming its body", "request_id", requestID)
+		return exportNotFound
+	}
+	n, err := s.read(unsafe.Slice((*byte)(unsafe.Pointer(cBuf)), bufLen))
+	if err != nil {
+		slog.Debug("Cannot read request chunk", "request_id", requestID, "error", err)
+		setLastError("Cannot read request chunk", "request_id", requestID, "error", err)
+		return exportFailed
+	}
+	return n
+}
+
+// SetRouteBodyStream makes a handler route stream its request body instead
+// of buffering it, so uploads larger than memory can be accepted. cOptions
+// is a JSON BodyStreamOptions object and may be empty. When cChunkHandler
+// is nonzero the body is passed to it chunk by chunk, with the upload
+// handler events of SetRouteMultipart, before the route handler runs with
+// the body's size in "body_size"; a chunk handler rejecting the body
+// answers 400. Otherwise the route handler receives "body_stream": true
+// and reads the body with ReadRequestChunk. The route may not also parse
+// forms or validate its body.
+//
+//export SetRouteBodyStream
+func SetRouteBodyStream(cPath *C.char, cMethod *C.char, cOptions *C.char, cChunkHandler uintptr) int {
+	if cPath == nil || cMethod == nil || cOptions == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in SetRouteBodyStream")
+	}
+	path := C.GoString(cPath)
+	method := strings.ToUpper(C.GoString(cMethod))
+	options := C.GoString(cOptions)
+	opts := &BodyStreamOptions{ChunkSize: defaultBodyChunkSize}
+	if options != "" {
+		if err := json.Unmarshal([]byte(options), opts); err != nil {
+			return exportError(exportInvalid, "Invalid body stream options", "path", path, "method", method, "error", err)
+		}
+	}
+	if opts.ChunkSize <= 0 || opts.MaxBodySize < -1 {
+		return exportError(exportInvalid, "Invalid body stream limits", "path", path, "method", method)
+	}
+	opts.ChunkHandler = cChunkHandler
+
+	routesMu.Lock()
+	key := path + method
+	route, exists := routes[key]
+	if !exists {
+		routesMu.Unlock()
+		return exportError(exportNotFound, "Cannot set body stream, route not found", "key", key)
+	}
+	if route.Handler == 0 {
+		routesMu.Unlock()
+		return exportError(exportFailed, "Cannot set body stream, route has no handler", "key", key)
+	}
+	if route.Multipart != nil || len(route.FormFields) > 0 || route.BodySchema != nil {
+		routesMu.Unlock()
+		return exportError(exportFailed, "Cannot set body stream, route parses or validates its body", "key", key)
+	}
+	route.BodyStream = opts
+	if opts.MaxBodySize != 0 {
+		route.MaxBodySize = opts.MaxBodySize
+	}
+	if route.MaxBodySize == -1 {
+		delete(route.Responses, http.StatusRequestEntityTooLarge)
+	} else {
+		route.Responses[http.StatusRequestEntityTooLarge] = "Request body too large"
+	}
+	if cChunkHandler != 0 {
+		route.Responses[http.StatusBadRequest] = "Request body rejected"
+	}
+	routes[key] = route
+	routesMu.Unlock()
+	invalidateOpenAPICache()
+
+	slog.Info("Route body stream set", "key", key, "chunk_size", opts.ChunkSize, "max_body_size", route.MaxBodySize, "chunk_handler", cChunkHandler != 0)
+	auditConfigChange("SetRouteBodyStream", map[string]string{
+		"path":          path,
+		"method":        method,
+		"options":       options,
+		"chunk_handler": fmt.Sprint(cChunkHandler != 0),
+	})
+	return 0
+}
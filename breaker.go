@@ -0,0 +1,286 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBreakerThreshold = 5
+	defaultBreakerOpen      = 30 * time.Second
+)
+
+// Circuit breaker states
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+var errCircuitOpen = errors.New("circuit breaker is open")
+
+// BreakerOptions configures a circuit breaker. After FailureThreshold
+// consecutive failures it opens and calls fail fast for OpenMs; then up
+// to HalfOpenProbes calls are let through, and the first to succeed closes
+// it again while a failure reopens it.
+type BreakerOptions struct {
+	FailureThreshold int `json:"failure_threshold"` // defaults to 5
+	OpenMs           int `json:"open_ms"`           // defaults to 30000
+	HalfOpenProbes   int `json:"half_open_probes"`  // defaults to 1
+}
+
+func (o BreakerOptions) validate() error {
+	if o.FailureThreshold < 0 || o.OpenMs < 0 || o.HalfOpenProbes < 0 {
+		return fmt.Errorf("circuit breaker settings must not be negative")
+	}
+	return nil
+}
+
+// circuitBreaker tracks the health of one upstream
+type circuitBreaker struct {
+	name      string
+	threshold int
+	openFor   time.Duration
+	maxProbes int
+	opts      BreakerOptions
+
+	mu       sync.Mutex
+	state    string
+	failures int // consecutive, while closed
+	openedAt time.Time
+	probes   int // in flight, while half-open
+	opened   int64
+	rejected int64
+}
+
+var (
+	breakers   = make(map[string]*circuitBreaker)
+	breakersMu sync.Mutex
+)
+
+func newCircuitBreaker(name string, opts BreakerOptions) *circuitBreaker {
+	b := &circuitBreaker{
+		name:      name,
+		threshold: opts.FailureThreshold,
+		openFor:   time.Duration(opts.OpenMs) * time.Millisecond,
+		maxProbes: opts.HalfOpenProbes,
+		opts:      opts,
+		state:     BreakerClosed,
+	}
+	if b.threshold == 0 {
+		b.threshold = defaultBreakerThreshold
+	}
+	if b.openFor == 0 {
+		b.openFor = defaultBreakerOpen
+	}
+	if b.maxProbes == 0 {
+		b.maxProbes = 1
+	}
+	return b
+}
+
+// registerBreaker makes b the breaker listed under its name, replacing
+// any earlier one
+func registerBreaker(b *circuitBreaker) {
+	breakersMu.Lock()
+	breakers[b.name] = b
+	breakersMu.Unlock()
+}
+
+// removeBreaker drops the breaker registered under name
+func removeBreaker(name string) {
+	breakersMu.Lock()
+	delete(breakers, name)
+	breakersMu.Unlock()
+}
+
+// removeBreakers drops the breakers whose names start with prefix
+func removeBreakers(prefix string) {
+	breakersMu.Lock()
+	for name := range breakers {
+		if strings.HasPrefix(name, prefix) {
+			delete(breakers, name)
+		}
+	}
+	breakersMu.Unlock()
+}
+
+// breakerFor returns the breaker registered under name, creating it, or
+// replacing one built with other options
+func breakerFor(name string, opts BreakerOptions) *circuitBreaker {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	b, ok := breakers[name]
+	if !ok || b.opts != opts {
+		b = newCircuitBreaker(name, opts)
+		breakers[name] = b
+	}
+	return b
+}
+
+// allow reports whether a call may go ahead. When it may not, retryIn is
+// how long until the breaker lets a probe through. A caller that was
+// allowed must report the outcome with done. A nil breaker allows
+// everything.
+func (b *circuitBreaker) allow() (ok bool, retryIn time.Duration) {
+	if b == nil {
+		return true, 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen {
+		if wait := b.openFor - time.Since(b.openedAt); wait > 0 {
+			b.rejected++
+			return false, wait
+		}
+		b.state, b.probes = BreakerHalfOpen, 0
+		slog.Info("Circuit breaker half-open", "breaker", b.name)
+	}
+	if b.state == BreakerHalfOpen {
+		if b.probes >= b.maxProbes {
+			b.rejected++
+			return false, time.Second
+		}
+		b.probes++
+	}
+	return true, 0
+}
+
+// done records the outcome of an allowed call. Calls abandoned by their
+// caller should be reported with cancelled, which counts neither way.
+func (b *circuitBreaker) done(success, cancelled bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerHalfOpen:
+		b.probes--
+		if cancelled {
+			return
+		}
+		if success {
+			b.state, b.failures = BreakerClosed, 0
+			slog.Info("Circuit breaker closed", "breaker", b.name)
+			return
+		}
+		b.trip()
+	case BreakerClosed:
+		if cancelled {
+			return
+		}
+		if success {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.threshold {
+			b.trip()
+		}
+	}
+}
+
+// trip opens the breaker. Must hold b.mu.
+func (b *circuitBreaker) trip() {
+	b.state, b.openedAt, b.failures = BreakerOpen, time.Now(), 0
+	b.opened++
+	slog.Warn("Circuit breaker opened", "breaker", b.name, "open_for", b.openFor)
+}
+
+// BreakerStatus is a breaker's state as listed in /debug/routes
+type BreakerStatus struct {
+	Name        string `json:"name"`
+	State       string `json:"state"`
+	Failures    int    `json:"consecutive_failures"`
+	OpenedTotal int64  `json:"opened_total"`
+	Rejected    int64  `json:"rejected_total"`
+}
+
+func (b *circuitBreaker) status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	state := b.state
+	if state == BreakerOpen && time.Since(b.openedAt) >= b.openFor {
+		// Probes are let through on the next call
+		state = BreakerHalfOpen
+	}
+	return BreakerStatus{Name: b.name, State: state, Failures: b.failures, OpenedTotal: b.opened, Rejected: b.rejected}
+}
+
+// breakerStatuses lists every breaker, ordered by name
+func breakerStatuses() []BreakerStatus {
+	breakersMu.Lock()
+	list := make([]*circuitBreaker, 0, len(breakers))
+	for _, b := range breakers {
+		list = append(list, b)
+	}
+	breakersMu.Unlock()
+	statuses := make([]BreakerStatus, len(list))
+	for i, b := range list {
+		statuses[i] = b.status()
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// retryAfterSeconds rounds a wait up to whole seconds for Retry-After
+func retryAfterSeconds(d time.Duration) string {
+	return strconv.Itoa(int((d + time.Second - 1) / time.Second))
+}
+
+// writeBreakerMetrics emits each breaker's state and counters
+func writeBreakerMetrics(p promWriter) {
+	statuses := breakerStatuses()
+	p.header("goserver_circuit_breaker_state", "gauge", "Circuit breaker state: 0 closed, 1 open, 2 half-open.")
+	for _, s := range statuses {
+		value := 0
+		switch s.State {
+		case BreakerOpen:
+			value = 1
+		case BreakerHalfOpen:
+			value = 2
+		}
+		p.sample("goserver_circuit_breaker_state", `breaker="`+escapeLabel(s.Name)+`"`, value)
+	}
+	p.header("goserver_circuit_breaker_opened_total", "counter", "Times a circuit breaker opened.")
+	for _, s := range statuses {
+		p.sample("goserver_circuit_breaker_opened_total", `breaker="`+escapeLabel(s.Name)+`"`, s.OpenedTotal)
+	}
+	p.header("goserver_circuit_breaker_rejected_total", "counter", "Calls failed fast by an open circuit breaker.")
+	for _, s := range statuses {
+		p.sample("goserver_circuit_breaker_rejected_total", `breaker="`+escapeLabel(s.Name)+`"`, s.Rejected)
+	}
+}
+
+// breakerTransport fails requests fast while its breaker is open. Network
+// errors and 5xx responses count as failures.
+type breakerTransport struct {
+	base    http.RoundTripper
+	breaker *circuitBreaker
+}
+
+// circuitOpenError carries how long until the breaker probes again
+type circuitOpenError struct {
+	retryIn time.Duration
+}
+
+func (e *circuitOpenError) Error() string { return errCircuitOpen.Error() }
+func (e *circuitOpenError) Unwrap() error { return errCircuitOpen }
+
+func (t *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ok, retryIn := t.breaker.allow()
+	if !ok {
+		return nil, &circuitOpenError{retryIn: retryIn}
+	}
+	resp, err := t.base.RoundTrip(req)
+	t.breaker.done(err == nil && resp.StatusCode < 500, err != nil && req.Context().Err() != nil)
+	return resp, err
+}
@@ -0,0 +1,571 @@
+package main
+
+import (
+	"C"
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RouteCache enables response caching for a GET route
+type RouteCache struct {
+	TTL  time.Duration
+	Vary []string // request headers that select separate entries
+}
+
+// newRouteCache validates a route cache setting; a ttl of 0 returns nil,
+// leaving the route uncached
+func newRouteCache(method string, ttlSeconds int, vary []string) (*RouteCache, error) {
+	if ttlSeconds < 0 {
+		return nil, fmt.Errorf("ttl must not be negative")
+	}
+	if ttlSeconds == 0 {
+		return nil, nil
+	}
+	if method != http.MethodGet {
+		return nil, fmt.Errorf("only GET routes can be cached")
+	}
+	cache := &RouteCache{TTL: time.Duration(ttlSeconds) * time.Second}
+	for _, name := range vary {
+		cache.Vary = append(cache.Vary, http.CanonicalHeaderKey(name))
+	}
+	return cache, nil
+}
+
+// cacheOptions configures the response cache store through ConfigureCache
+type cacheOptions struct {
+	MaxEntries    int    `json:"max_entries"`    // in-memory LRU size; defaults to 1000
+	MaxEntrySize  int    `json:"max_entry_size"` // larger responses are not cached; defaults to 1 MB
+	Redis         string `json:"redis"`          // registered client sharing entries through Redis instead of memory
+	RedisAddr     string `json:"redis_addr"`     // or a Redis address to connect to
+	RedisPassword string `json:"redis_password"`
+	RedisDB       int    `json:"redis_db"`
+	KeyPrefix     string `json:"key_prefix"`
+}
+
+// cachedResponse is one stored response
+type cachedResponse struct {
+	Status int                 `json:"status"`
+	Header map[string][]string `json:"header"`
+	Body   []byte              `json:"body"`
+	ETag   string              `json:"etag"`
+	Stored time.Time           `json:"stored"`
+
+	path    string
+	expires time.Time
+}
+
+// responseCacheStore holds cached responses by key. Keys start with the
+// request path, which invalidate matches against a pattern.
+type responseCacheStore interface {
+	get(key string) (*cachedResponse, error)
+	set(key string, entry *cachedResponse, ttl time.Duration) error
+	invalidate(pattern string) (int, error)
+}
+
+// memoryCacheStore is an LRU of cached responses
+type memoryCacheStore struct {
+	mu         sync.Mutex
+	maxEntries int
+	order      *list.List // most recently used first
+	entries    map[string]*list.Element
+}
+
+type memoryCacheItem struct {
+	key   string
+	entry *cachedResponse
+}
+
+func newMemoryCacheStore(maxEntries int) *memoryCacheStore {
+	return &memoryCacheStore{maxEntries: maxEntries, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (s *memoryCacheStore) get(key string) (*cachedResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	item := el.Value.(*memoryCacheItem)
+	if time.Now().After(item.entry.expires) {
+		s.order.Remove(el)
+		delete(s.entries, key)
+		return nil, nil
+	}
+	s.order.MoveToFront(el)
+	return item.entry, nil
+}
+
+func (s *memoryCacheStore) set(key string, entry *cachedResponse, ttl time.Duration) error {
+	entry.expires = entry.Stored.Add(ttl)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.entries[key]; ok {
+		el.Value.(*memoryCacheItem).entry = entry
+		s.order.MoveToFront(el)
+		return nil
+	}
+	s.entries[key] = s.order.PushFront(&memoryCacheItem{key: key, entry: entry})
+	for s.order.Len() > s.maxEntries {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*memoryCacheItem).key)
+	}
+	return nil
+}
+
+func (s *memoryCacheStore) invalidate(pattern string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	removed := 0
+	for key, el := range s.entries {
+		if globMatch(pattern, el.Value.(*memoryCacheItem).entry.path) {
+			s.order.Remove(el)
+			delete(s.entries, key)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// redisCacheStore keeps cached responses in Redis as JSON, expiring with
+// their TTL, so several server processes share them
+type redisCacheStore struct {
+	client *redisClient
+	prefix string
+}
+
+func (s *redisCacheStore) get(key string) (*cachedResponse, error) {
+	reply, err := s.client.do("GET", s.prefix+key)
+	if err != nil {
+		return nil, err
+	}
+	data, ok := reply.(string)
+	if !ok {
+		return nil, nil
+	}
+	var entry cachedResponse
+	if err := json.Unmarshal([]byte(data), &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (s *redisCacheStore) set(key string, entry *cachedResponse, ttl time.Duration) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.do("SET", s.prefix+key, string(data), "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	return err
+}
+
+func (s *redisCacheStore) invalidate(pattern string) (int, error) {
+	match := redisGlobEscape(s.prefix) + redisGlobEscape(pattern) + "|*"
+	removed := 0
+	cursor := "0"
+	for {
+		reply, err := s.client.do("SCAN", cursor, "MATCH", match, "COUNT", "100")
+		if err != nil {
+			return removed, err
+		}
+		parts, ok := reply.([]interface{})
+		if !ok || len(parts) != 2 {
+			return removed, fmt.Errorf("unexpected SCAN reply")
+		}
+		cursor, _ = parts[0].(string)
+		keys, _ := parts[1].([]interface{})
+		if len(keys) > 0 {
+			args := []string{"DEL"}
+			for _, key := range keys {
+				if name, ok := key.(string); ok {
+					args = append(args, name)
+				}
+			}
+			deleted, err := s.client.do(args...)
+			if err != nil {
+				return removed, err
+			}
+			if n, ok := deleted.(int64); ok {
+				removed += int(n)
+			}
+		}
+		if cursor == "0" || cursor == "" {
+			return removed, nil
+		}
+	}
+}
+
+// redisGlobEscape escapes the characters Redis MATCH treats specially,
+// other than the * and ? wildcards
+func redisGlobEscape(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if c == '[' || c == ']' || c == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// globMatch reports whether s matches pattern, where * matches any run of
+// characters, including slashes, and ? matches one character
+func globMatch(pattern, s string) bool {
+	star, match := -1, 0
+	p, i := 0, 0
+	for i < len(s) {
+		switch {
+		case p < len(pattern) && pattern[p] == '*':
+			star, match = p, i
+			p++
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == s[i]):
+			p++
+			i++
+		case star >= 0:
+			p = star + 1
+			match++
+			i = match
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+const (
+	defaultCacheEntries   = 1000
+	defaultCacheEntrySize = 1 << 20
+)
+
+var (
+	cacheStore        responseCacheStore = newMemoryCacheStore(defaultCacheEntries)
+	cacheMaxEntrySize                    = defaultCacheEntrySize
+	cacheMu           sync.RWMutex
+)
+
+func currentCacheStore() (responseCacheStore, int) {
+	cacheMu.RLock()
+	defer cacheMu.RUnlock()
+	return cacheStore, cacheMaxEntrySize
+}
+
+// cacheKey identifies the entry for r: the path, then a hash of the
+// method, sorted query, and the route's Vary header values. Handler and
+// template routes negotiate their format, so Accept is always part of it.
+func cacheKey(r *http.Request, route RouteInfo) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + "\n" + r.URL.Query().Encode() + "\n"))
+	vary := route.Cache.Vary
+	if route.Handler != 0 || route.Template != "" {
+		vary = append([]string{"Accept"}, vary...)
+	}
+	for _, name := range vary {
+		h.Write([]byte(name + ":" + strings.Join(r.Header.Values(name), ",") + "\n"))
+	}
+	return r.URL.Path + "|" + hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// etagMatches applies the weak comparison If-None-Match calls for
+func etagMatches(ifNoneMatch, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// uncachedHeaders are per-request headers never replayed from the cache
+var uncachedHeaders = []string{"Set-Cookie", "Date", "Content-Length", "Age", "X-Cache"}
+
+// cacheRecorder buffers a route's response so it can be stored and given
+// an ETag. Responses that cannot be cached (not 200, marked private or
+// no-store, setting cookies, declaring trailers, streamed, or too large)
+// pass through unchanged.
+type cacheRecorder struct {
+	http.ResponseWriter
+	limit       int
+	status      int
+	body        bytes.Buffer
+	passthrough bool
+}
+
+func (w *cacheRecorder) WriteHeader(code int) {
+	if w.status != 0 || w.passthrough {
+		if w.passthrough {
+			w.ResponseWriter.WriteHeader(code)
+		}
+		return
+	}
+	w.status = code
+	h := w.Header()
+	cacheControl := strings.ToLower(h.Get("Cache-Control"))
+	if code != http.StatusOK || h.Get("Set-Cookie") != "" || h.Get("Trailer") != "" ||
+		strings.Contains(cacheControl, "no-store") || strings.Contains(cacheControl, "private") {
+		w.passthrough = true
+		w.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (w *cacheRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.passthrough {
+		return w.ResponseWriter.Write(b)
+	}
+	if w.body.Len()+len(b) > w.limit {
+		w.release()
+		return w.ResponseWriter.Write(b)
+	}
+	return w.body.Write(b)
+}
+
+func (w *cacheRecorder) Flush() {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.release()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// release gives up on caching and sends what was buffered
+func (w *cacheRecorder) release() {
+	if w.passthrough {
+		return
+	}
+	w.passthrough = true
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(w.body.Bytes())
+	w.body.Reset()
+}
+
+func (w *cacheRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// serveCached answers GET requests for a cached route from the cache,
+// running serve and storing its response on a miss. Store errors fall back to
+// serving uncached, so a Redis outage does not take the route down.
+func serveCached(w http.ResponseWriter, r *http.Request, route RouteInfo, serve func(w http.ResponseWriter)) {
+	store, limit := currentCacheStore()
+	key := cacheKey(r, route)
+	entry, err := store.get(key)
+	if err != nil {
+		slog.Warn("Response cache store error", "error", err)
+	}
+	if entry != nil {
+		writeCachedResponse(w, r, route, entry, true)
+		return
+	}
+
+	// Headers set before the route, e.g. by CORS, depend on the request
+	// and are left out of the entry
+	before := w.Header().Clone()
+	rec := &cacheRecorder{ResponseWriter: w, limit: limit}
+	serve(rec)
+	if rec.passthrough {
+		return
+	}
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	h := w.Header()
+	for name := range h {
+		if strings.HasPrefix(name, http.TrailerPrefix) {
+			rec.release()
+			return
+		}
+	}
+	if h.Get("ETag") == "" {
+		sum := sha256.Sum256(rec.body.Bytes())
+		h.Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	}
+	if h.Get("Cache-Control") == "" {
+		h.Set("Cache-Control", "max-age="+strconv.Itoa(int(route.Cache.TTL.Seconds())))
+	}
+	entry = &cachedResponse{
+		Status: rec.status,
+		Header: h.Clone(),
+		Body:   bytes.Clone(rec.body.Bytes()),
+		ETag:   h.Get("ETag"),
+		Stored: time.Now(),
+		path:   r.URL.Path,
+	}
+	for name, values := range before {
+		if slices.Equal(entry.Header[name], values) {
+			delete(entry.Header, name)
+		}
+	}
+	for _, name := range uncachedHeaders {
+		delete(entry.Header, name)
+	}
+	if err := store.set(key, entry, route.Cache.TTL); err != nil {
+		slog.Warn("Response cache store error", "error", err)
+	}
+	writeCachedResponse(w, r, route, entry, false)
+}
+
+// writeCachedResponse sends entry, or 304 when the client already has it
+func writeCachedResponse(w http.ResponseWriter, r *http.Request, route RouteInfo, entry *cachedResponse, hit bool) {
+	h := w.Header()
+	if hit {
+		for name, values := range entry.Header {
+			h[name] = values
+		}
+		h.Set("Age", strconv.Itoa(int(time.Since(entry.Stored).Seconds())))
+		h.Set("X-Cache", "HIT")
+	} else {
+		h.Set("X-Cache", "MISS")
+	}
+	for _, name := range route.Cache.Vary {
+		h.Add("Vary", name)
+	}
+	if requestNotModified(r, entry.ETag, h.Get("Last-Modified")) {
+		for _, name := range []string{"Content-Type", "Content-Length", "Content-Encoding"} {
+			h.Del(name)
+		}
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	h.Set("Content-Length", strconv.Itoa(len(entry.Body)))
+	w.WriteHeader(entry.Status)
+	w.Write(entry.Body)
+}
+
+// ConfigureCache sets up the response cache store from JSON options:
+// max_entries and max_entry_size for the in-memory LRU, or redis naming a
+// client registered with RegisterRedis, or redis_addr, redis_password and
+// redis_db, along with key_prefix, to share entries through Redis.
+// Entries already cached are dropped.
+//
+//export ConfigureCache
+func ConfigureCache(cOptions *C.char) int {
+	if cOptions == nil {
+		return exportError(exportInvalid, "cOptions is nil in ConfigureCache")
+	}
+	options := C.GoString(cOptions)
+	opts := cacheOptions{MaxEntries: defaultCacheEntries, MaxEntrySize: defaultCacheEntrySize, KeyPrefix: "goserver:cache:"}
+	if options != "" {
+		if err := json.Unmarshal([]byte(options), &opts); err != nil {
+			return exportError(exportInvalid, "Invalid cache options", "error", err)
+		}
+	}
+	if opts.MaxEntries < 1 || opts.MaxEntrySize < 1 {
+		return exportError(exportInvalid, "Invalid cache options", "max_entries", opts.MaxEntries, "max_entry_size", opts.MaxEntrySize)
+	}
+	client, err := middlewareRedis(opts.Redis, opts.RedisAddr, opts.RedisPassword, opts.RedisDB)
+	if err != nil {
+		return exportError(exportInvalid, "Invalid cache options", "error", err)
+	}
+	var store responseCacheStore = newMemoryCacheStore(opts.MaxEntries)
+	if client != nil {
+		store = &redisCacheStore{client: client, prefix: opts.KeyPrefix}
+	}
+	cacheMu.Lock()
+	old := cacheStore
+	cacheStore, cacheMaxEntrySize = store, opts.MaxEntrySize
+	cacheMu.Unlock()
+	if rs, ok := old.(*redisCacheStore); ok && !registeredRedis(rs.client) {
+		rs.client.close()
+	}
+
+	slog.Info("Response cache configured", "redis", client != nil, "max_entries", opts.MaxEntries)
+	auditConfigChange("ConfigureCache", map[string]string{"options": redactJSON(options)})
+
+	return 0
+}
+
+// SetRouteCache caches a GET route's 200 responses for ttlSeconds, with a
+// separate entry per query string and per value of the request headers in
+// cVary, a JSON array such as ["Accept", "Authorization"]. Cached
+// responses carry an ETag and are answered with 304, without running the
+// handler, when the client sends it back in If-None-Match or its
+// If-Modified-Since is not older than the response's Last-Modified. A
+// ttlSeconds of 0 turns caching off.
+//
+//export SetRouteCache
+func SetRouteCache(cPath *C.char, cMethod *C.char, ttlSeconds int, cVary *C.char) int {
+	if cPath == nil || cMethod == nil || cVary == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in SetRouteCache")
+	}
+	path := C.GoString(cPath)
+	method := strings.ToUpper(C.GoString(cMethod))
+	varyJSON := C.GoString(cVary)
+	var vary []string
+	if varyJSON != "" {
+		if err := json.Unmarshal([]byte(varyJSON), &vary); err != nil {
+			return exportError(exportInvalid, "Invalid cache vary headers", "error", err)
+		}
+	}
+	cache, err := newRouteCache(method, ttlSeconds, vary)
+	if err != nil {
+		return exportError(exportInvalid, "Invalid route cache", "path", path, "method", method, "error", err)
+	}
+
+	routesMu.Lock()
+	key := path + method
+	route, exists := routes[key]
+	if !exists {
+		routesMu.Unlock()
+		return exportError(exportNotFound, "Cannot set cache, route not found", "key", key)
+	}
+	if route.WebSocket != 0 || route.SSE != 0 {
+		routesMu.Unlock()
+		return exportError(exportFailed, "Cannot cache streaming route", "key", key)
+	}
+	route.Cache = cache
+	if cache == nil {
+		delete(route.Responses, http.StatusNotModified)
+	} else {
+		route.Responses[http.StatusNotModified] = "Not modified"
+	}
+	routes[key] = route
+	routesMu.Unlock()
+	invalidateOpenAPICache()
+
+	slog.Info("Route cache set", "key", key, "ttl", ttlSeconds)
+	auditConfigChange("SetRouteCache", map[string]string{"path": path, "method": method, "ttl": strconv.Itoa(ttlSeconds), "vary": varyJSON})
+
+	return 0
+}
+
+// InvalidateCache drops cached responses whose request path matches
+// cPathPattern, where * matches any characters (including /) and ? one
+// character: "/users/42" drops one path, "/users/*" everything below it,
+// and "*" the whole cache. Returns how many entries were dropped, or a
+// negative status on error.
+//
+//export InvalidateCache
+func InvalidateCache(cPathPattern *C.char) int {
+	if cPathPattern == nil {
+		return exportError(exportInvalid, "cPathPattern is nil in InvalidateCache")
+	}
+	pattern := C.GoString(cPathPattern)
+	store, _ := currentCacheStore()
+	removed, err := store.invalidate(pattern)
+	if err != nil {
+		return exportError(exportFailed, "Error invalidating response cache", "pattern", pattern, "error", err)
+	}
+	slog.Info("Response cache invalidated", "pattern", pattern, "entries", removed)
+	return removed
+}
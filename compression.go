@@ -0,0 +1,420 @@
+package main
+
+import (
+	"C"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// compressionController is implemented by response writers of compressing
+// middleware. The dispatcher uses it to pass a route's compression override
+// down to the writer before the body is written.
+type compressionController interface {
+	SetCompression(enabled bool)
+}
+
+// applyRouteCompression signals the route's compression override, if any, to
+// the compression middleware wrapping w
+func applyRouteCompression(w http.ResponseWriter, route RouteInfo) {
+	if route.Compression == nil {
+		return
+	}
+	// Walk through wrapping writers (metrics, logging) to reach the compressor
+	for w != nil {
+		if cc, ok := w.(compressionController); ok {
+			cc.SetCompression(*route.Compression)
+			return
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return
+		}
+		w = u.Unwrap()
+	}
+}
+
+// SetRouteCompression overrides the global compression setting for a route.
+// Routes serving already-compressed payloads should disable it.
+//
+//export SetRouteCompression
+func SetRouteCompression(cPath *C.char, cMethod *C.char, enabled int) int {
+	if cPath == nil || cMethod == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in SetRouteCompression")
+	}
+	path := C.GoString(cPath)
+	method := strings.ToUpper(C.GoString(cMethod))
+	compress := enabled != 0
+
+	routesMu.Lock()
+	key := path + method
+	route, exists := routes[key]
+	if !exists {
+		routesMu.Unlock()
+		return exportError(exportNotFound, "Cannot set compression, route not found", "key", key)
+	}
+	route.Compression = &compress
+	routes[key] = route
+	routesMu.Unlock()
+
+	slog.Info("Route compression set", "key", key, "enabled", compress)
+	auditConfigChange("SetRouteCompression", map[string]string{"path": path, "method": method, "enabled": fmt.Sprint(compress)})
+
+	return 0
+}
+
+// compressionOptions are the "compression" middleware options
+type compressionOptions struct {
+	MinSize             int      `json:"min_size"`      // bytes buffered before deciding to compress
+	ContentTypes        []string `json:"content_types"` // allowlist; entries ending in "/" match a type prefix
+	Level               int      `json:"level"`         // gzip level; 0 uses the default
+	Brotli              *bool    `json:"brotli"`        // offer br in addition to gzip (default true)
+	DecompressRequests  *bool    `json:"decompress_requests"`
+	MaxDecompressedSize int64    `json:"max_decompressed_size"` // limit on decoded request bodies
+}
+
+var defaultCompressibleTypes = []string{
+	"text/",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"application/problem+json",
+	"image/svg+xml",
+}
+
+const (
+	defaultCompressionMinSize  = 1024
+	defaultMaxDecompressedSize = 10 << 20
+	encodingGzip               = "gzip"
+	encodingBrotli             = "br"
+)
+
+// compressible reports whether contentType is on the allowlist
+func (opts *compressionOptions) compressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	for _, allowed := range opts.ContentTypes {
+		if strings.HasSuffix(allowed, "/") && strings.HasPrefix(mediaType, allowed) || mediaType == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateEncoding picks br or gzip from Accept-Encoding by q-value,
+// preferring br on ties. It returns "" if neither is acceptable.
+func negotiateEncoding(header string, brotliEnabled bool) string {
+	best, bestQ := "", 0.0
+	wildcard := -1.0
+	seen := map[string]bool{}
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		switch name {
+		case "*":
+			wildcard = q
+			continue
+		case encodingBrotli:
+			if !brotliEnabled {
+				continue
+			}
+		case encodingGzip, "x-gzip":
+			name = encodingGzip
+		default:
+			continue
+		}
+		seen[name] = true
+		if q > bestQ || (q == bestQ && name == encodingBrotli) {
+			best, bestQ = name, q
+		}
+	}
+	if best == "" && wildcard > 0 {
+		if brotliEnabled && !seen[encodingBrotli] {
+			return encodingBrotli
+		}
+		if !seen[encodingGzip] {
+			return encodingGzip
+		}
+	}
+	return best
+}
+
+var gzipWriters sync.Pool
+
+// newEncoder returns a compressor for encoding writing to w
+func newEncoder(encoding string, w io.Writer, level int) io.WriteCloser {
+	if encoding == encodingBrotli {
+		return brotli.NewWriterLevel(w, brotli.DefaultCompression)
+	}
+	if level == 0 || level == gzip.DefaultCompression {
+		if gz, ok := gzipWriters.Get().(*gzip.Writer); ok {
+			gz.Reset(w)
+			return gz
+		}
+		level = gzip.DefaultCompression
+	}
+	gz, err := gzip.NewWriterLevel(w, level)
+	if err != nil {
+		gz = gzip.NewWriter(w)
+	}
+	return gz
+}
+
+// compressWriter buffers the start of a response to decide whether it is
+// worth compressing, then streams through the encoder
+type compressWriter struct {
+	http.ResponseWriter
+	opts     *compressionOptions
+	encoding string
+	enabled  bool
+
+	status  int
+	buf     []byte
+	decided bool
+	encoder io.WriteCloser
+}
+
+// SetCompression applies a route override; it has no effect once the first
+// bytes have been sent
+func (cw *compressWriter) SetCompression(enabled bool) {
+	if !cw.decided {
+		cw.enabled = enabled
+	}
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	// Informational responses, including a WebSocket 101, go out immediately
+	if code < 200 {
+		if code == http.StatusSwitchingProtocols {
+			cw.decided = true
+		}
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if cw.status == 0 {
+		cw.status = code
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	if cw.decided {
+		if cw.encoder != nil {
+			return cw.encoder.Write(b)
+		}
+		return cw.ResponseWriter.Write(b)
+	}
+	cw.buf = append(cw.buf, b...)
+	if len(cw.buf) >= cw.opts.MinSize {
+		if err := cw.decide(); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// decide sends the header and buffered bytes, compressing when the response
+// is large enough, of an allowed type, and not already encoded
+func (cw *compressWriter) decide() error {
+	cw.decided = true
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	h := cw.Header()
+	if h.Get("Content-Type") == "" && len(cw.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+	// Byte ranges count bytes of the identity body, so a 206 is sent as is
+	eligible := cw.opts.compressible(h.Get("Content-Type")) && h.Get("Content-Encoding") == "" && cw.status != http.StatusPartialContent
+	if eligible {
+		h.Add("Vary", "Accept-Encoding")
+	}
+	bodyAllowed := cw.status != http.StatusNoContent && cw.status != http.StatusNotModified
+	if eligible && bodyAllowed && cw.enabled && cw.encoding != "" && len(cw.buf) >= cw.opts.MinSize {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", cw.encoding)
+		// The encoded body differs byte for byte, so its validator is weak
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		cw.ResponseWriter.WriteHeader(cw.status)
+		cw.encoder = newEncoder(cw.encoding, cw.ResponseWriter, cw.opts.Level)
+		_, err := cw.encoder.Write(cw.buf)
+		cw.buf = nil
+		return err
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	_, err := cw.ResponseWriter.Write(cw.buf)
+	cw.buf = nil
+	return err
+}
+
+// Flush sends buffered data now, so streams are never held back
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+		cw.decide()
+	}
+	if gz, ok := cw.encoder.(*gzip.Writer); ok {
+		gz.Flush()
+	} else if br, ok := cw.encoder.(*brotli.Writer); ok {
+		br.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// close finishes the response once the handler returns
+func (cw *compressWriter) close() {
+	if !cw.decided {
+		if cw.status == 0 && len(cw.buf) == 0 {
+			// Nothing was written; leave the implicit 200 to net/http
+			return
+		}
+		cw.decide()
+	}
+	if cw.encoder != nil {
+		cw.encoder.Close()
+		if gz, ok := cw.encoder.(*gzip.Writer); ok && cw.opts.Level == 0 {
+			gzipWriters.Put(gz)
+		}
+	}
+}
+
+// limitedBody caps a decoded request body and closes the original
+type limitedBody struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (b *limitedBody) Close() error {
+	var err error
+	for _, c := range b.closers {
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+var errDecompressedTooLarge = errors.New("decompressed request body too large")
+
+// maxReader fails once more than n bytes have been read
+type maxReader struct {
+	r io.Reader
+	n int64
+}
+
+func (m *maxReader) Read(p []byte) (int, error) {
+	if m.n < 0 {
+		return 0, errDecompressedTooLarge
+	}
+	if int64(len(p)) > m.n+1 {
+		p = p[:m.n+1]
+	}
+	n, err := m.r.Read(p)
+	m.n -= int64(n)
+	if m.n < 0 {
+		return n, errDecompressedTooLarge
+	}
+	return n, err
+}
+
+// decompressRequest replaces a gzip or br encoded body with its decoding
+func decompressRequest(r *http.Request, limit int64) error {
+	encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+	var decoded io.Reader
+	closers := []io.Closer{r.Body}
+	switch encoding {
+	case "", "identity":
+		return nil
+	case encodingGzip, "x-gzip":
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return err
+		}
+		decoded = gz
+		closers = append(closers, gz)
+	case encodingBrotli:
+		decoded = brotli.NewReader(r.Body)
+	default:
+		return fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+	r.Body = &limitedBody{Reader: &maxReader{r: decoded, n: limit}, closers: closers}
+	r.Header.Del("Content-Encoding")
+	r.Header.Del("Content-Length")
+	r.ContentLength = -1
+	return nil
+}
+
+// newCompressionMiddleware builds the "compression" middleware
+func newCompressionMiddleware(options []byte) (func(http.Handler) http.Handler, error) {
+	opts := &compressionOptions{}
+	if len(options) > 0 {
+		if err := json.Unmarshal(options, opts); err != nil {
+			return nil, err
+		}
+	}
+	if opts.MinSize <= 0 {
+		opts.MinSize = defaultCompressionMinSize
+	}
+	if len(opts.ContentTypes) == 0 {
+		opts.ContentTypes = defaultCompressibleTypes
+	}
+	for i, ct := range opts.ContentTypes {
+		opts.ContentTypes[i] = strings.ToLower(ct)
+	}
+	if opts.Level != 0 && (opts.Level < gzip.HuffmanOnly || opts.Level > gzip.BestCompression) {
+		return nil, fmt.Errorf("invalid gzip level %d", opts.Level)
+	}
+	if opts.MaxDecompressedSize <= 0 {
+		opts.MaxDecompressedSize = defaultMaxDecompressedSize
+	}
+	brotliEnabled := opts.Brotli == nil || *opts.Brotli
+	decompress := opts.DecompressRequests == nil || *opts.DecompressRequests
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if decompress {
+				if err := decompressRequest(r, opts.MaxDecompressedSize); err != nil {
+					slog.Debug("Cannot decode request body", "error", err)
+					http.Error(w, `{"error": "Unsupported or invalid Content-Encoding"}`, http.StatusUnsupportedMediaType)
+					return
+				}
+			}
+			cw := &compressWriter{
+				ResponseWriter: w,
+				opts:           opts,
+				encoding:       negotiateEncoding(r.Header.Get("Accept-Encoding"), brotliEnabled),
+				enabled:        true,
+			}
+			defer cw.close()
+			next.ServeHTTP(cw, r)
+		})
+	}, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestRouteCompressionDisabled checks that a route with SetRouteCompression
+// off sends the same bytes, unencoded, whatever the client accepts
+func TestRouteCompressionDisabled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	startTaskPool(ctx) // static routes start a background task
+
+	const path = "/test/no-compress"
+	disabled := false
+	message := strings.Repeat("already compressed payload ", 200)
+	routesMu.Lock()
+	routes[path+http.MethodGet] = RouteInfo{
+		Path:        path,
+		Method:      http.MethodGet,
+		Message:     message,
+		ContentType: "text/plain",
+		Responses:   map[int]string{200: "Successful response"},
+		Compression: &disabled,
+	}
+	routeTree.insert(path, http.MethodGet, path+http.MethodGet)
+	routesMu.Unlock()
+	defer func() {
+		routesMu.Lock()
+		delete(routes, path+http.MethodGet)
+		routesMu.Unlock()
+	}()
+
+	compression, err := newCompressionMiddleware(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := compression(http.HandlerFunc(dispatchRoute))
+
+	var bodies [][]byte
+	for _, acceptEncoding := range []string{"gzip", "br", ""} {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if acceptEncoding != "" {
+			r.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Accept-Encoding %q: status %d, want 200", acceptEncoding, w.Code)
+		}
+		if encoding := w.Header().Get("Content-Encoding"); encoding != "" {
+			t.Errorf("Accept-Encoding %q: Content-Encoding %q, want none", acceptEncoding, encoding)
+		}
+		bodies = append(bodies, w.Body.Bytes())
+	}
+	if string(bodies[0]) != message {
+		t.Errorf("body is not the route's message")
+	}
+	for i := 1; i < len(bodies); i++ {
+		if !bytes.Equal(bodies[i], bodies[0]) {
+			t.Errorf("body %d differs from the gzip request's body", i)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"C"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// concurrencyOptions configures the "concurrency" middleware
+type concurrencyOptions struct {
+	MaxInFlight int `json:"max_in_flight"` // 0 leaves only the per-route limits
+	RetryAfter  int `json:"retry_after"`   // seconds; defaults to 1
+}
+
+// routeSlots counts the requests a route is serving against its
+// SetRouteConcurrency limit
+type routeSlots struct {
+	route    string
+	method   string
+	inFlight atomic.Int64
+}
+
+var (
+	routeSlotsByKey     = make(map[string]*routeSlots)
+	routeSlotsMu        sync.Mutex
+	concurrencyInFlight atomic.Int64 // requests holding a max_in_flight slot
+)
+
+type routeSlotKey struct{}
+
+// slotsFor returns the counter of a route key, creating it on first use
+func slotsFor(key string, route RouteInfo) *routeSlots {
+	routeSlotsMu.Lock()
+	defer routeSlotsMu.Unlock()
+	s, ok := routeSlotsByKey[key]
+	if !ok {
+		s = &routeSlots{route: route.Host + route.Path, method: route.Method}
+		routeSlotsByKey[key] = s
+	}
+	return s
+}
+
+// tryAcquire takes a slot from counter unless limit are already taken
+func tryAcquire(counter *atomic.Int64, limit int64) bool {
+	for {
+		n := counter.Load()
+		if n >= limit {
+			return false
+		}
+		if counter.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+// shedRequest answers a request turned away by the concurrency middleware
+func shedRequest(w http.ResponseWriter, r *http.Request, retryAfter int, reason string) {
+	stats.RequestsShed.Add(1)
+	slog.Warn("Request shed", "method", r.Method, "path", r.URL.Path, "limit", reason)
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	http.Error(w, `{"error": "Server is busy, please retry"}`, http.StatusServiceUnavailable)
+}
+
+// newConcurrencyMiddleware builds the "concurrency" middleware. Unlike the
+// admission queue, it never waits: a request arriving while max_in_flight
+// requests are being served, or while its route is at its
+// SetRouteConcurrency limit, gets 503 with Retry-After straight away. The
+// cap is per instance, so a group or virtual host listing the middleware
+// gets its own. WebSocket and SSE routes only count against their route
+// limit, since they hold a slot for as long as the stream is open, and the
+// health probes are never shed.
+func newConcurrencyMiddleware(options []byte) (func(http.Handler) http.Handler, error) {
+	opts := concurrencyOptions{RetryAfter: 1}
+	if len(options) > 0 {
+		if err := json.Unmarshal(options, &opts); err != nil {
+			return nil, err
+		}
+	}
+	if opts.MaxInFlight < 0 {
+		return nil, fmt.Errorf("max_in_flight must not be negative")
+	}
+	if opts.RetryAfter < 1 {
+		return nil, fmt.Errorf("retry_after must be at least 1")
+	}
+	var inFlight atomic.Int64
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/healthz" || r.URL.Path == "/readyz" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			routesMu.RLock()
+			route, _, _, found := findRoute(r.Host, r.URL.Path, r.Method)
+			routesMu.RUnlock()
+			stream := found && (route.WebSocket != 0 || route.SSE != 0)
+
+			if opts.MaxInFlight > 0 && !stream {
+				if !tryAcquire(&inFlight, int64(opts.MaxInFlight)) {
+					shedRequest(w, r, opts.RetryAfter, "max_in_flight")
+					return
+				}
+				concurrencyInFlight.Add(1)
+				defer func() {
+					inFlight.Add(-1)
+					concurrencyInFlight.Add(-1)
+				}()
+			}
+			// An outer instance may already hold the route's slot
+			if found && route.Concurrency > 0 && r.Context().Value(routeSlotKey{}) == nil {
+				slots := slotsFor(route.Host+route.Path+route.Method, route)
+				if !tryAcquire(&slots.inFlight, int64(route.Concurrency)) {
+					shedRequest(w, r, opts.RetryAfter, "route")
+					return
+				}
+				defer slots.inFlight.Add(-1)
+				r = r.WithContext(context.WithValue(r.Context(), routeSlotKey{}, true))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+// writeConcurrencyMetrics emits the concurrency middleware's in-flight
+// gauges and shed counter
+func writeConcurrencyMetrics(p promWriter) {
+	p.header("goserver_concurrency_in_flight", "gauge", "Requests holding a max_in_flight slot of the concurrency middleware.")
+	p.sample("goserver_concurrency_in_flight", "", concurrencyInFlight.Load())
+	p.header("goserver_requests_shed_total", "counter", "Requests answered with 503 by the concurrency middleware.")
+	p.sample("goserver_requests_shed_total", "", stats.RequestsShed.Load())
+
+	routeSlotsMu.Lock()
+	list := make([]*routeSlots, 0, len(routeSlotsByKey))
+	for _, s := range routeSlotsByKey {
+		list = append(list, s)
+	}
+	routeSlotsMu.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].route != list[j].route {
+			return list[i].route < list[j].route
+		}
+		return list[i].method < list[j].method
+	})
+	p.header("goserver_route_in_flight", "gauge", "Requests a route with a concurrency limit is serving.")
+	for _, s := range list {
+		p.sample("goserver_route_in_flight", fmt.Sprintf(`route="%s",method="%s"`, escapeLabel(s.route), escapeLabel(s.method)), s.inFlight.Load())
+	}
+}
+
+// SetRouteConcurrency caps how many requests one route serves at once
+// under the concurrency middleware; requests beyond it get 503. A limit of
+// 0 removes the cap.
+//
+//export SetRouteConcurrency
+func SetRouteConcurrency(cPath *C.char, cMethod *C.char, limit int) int {
+	if cPath == nil || cMethod == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in SetRouteConcurrency")
+	}
+	if limit < 0 {
+		return exportError(exportInvalid, "Invalid route concurrency limit", "limit", limit)
+	}
+	path := C.GoString(cPath)
+	method := strings.ToUpper(C.GoString(cMethod))
+
+	routesMu.Lock()
+	key := path + method
+	route, exists := routes[key]
+	if !exists {
+		routesMu.Unlock()
+		return exportError(exportNotFound, "Cannot set concurrency limit, route not found", "key", key)
+	}
+	route.Concurrency = limit
+	if limit == 0 {
+		delete(route.Responses, http.StatusServiceUnavailable)
+	} else {
+		route.Responses[http.StatusServiceUnavailable] = "Too many concurrent requests"
+	}
+	routes[key] = route
+	routesMu.Unlock()
+	invalidateOpenAPICache()
+
+	slog.Info("Route concurrency limit set", "key", key, "limit", limit)
+	auditConfigChange("SetRouteConcurrency", map[string]string{"path": path, "method": method, "limit": strconv.Itoa(limit)})
+
+	return 0
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"C"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ETag modes for ConfigureETags
+const (
+	ETagsOff    = "off" // the default; handlers set validators themselves
+	ETagsStrong = "strong"
+	ETagsWeak   = "weak"
+)
+
+// etagMode is the ConfigureETags mode, ETagsOff while unset
+var etagMode atomic.Value
+
+func currentETagMode() string {
+	if mode, ok := etagMode.Load().(string); ok {
+		return mode
+	}
+	return ETagsOff
+}
+
+// bodyETag derives a validator from a response body
+func bodyETag(body []byte, weak bool) string {
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	if weak {
+		etag = "W/" + etag
+	}
+	return etag
+}
+
+// requestNotModified evaluates a GET or HEAD request's If-None-Match, or
+// when it has none its If-Modified-Since, against a response's validators
+// as RFC 9110 section 13.2.2 orders them
+func requestNotModified(r *http.Request, etag, lastModified string) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etag != "" && etagMatches(inm, etag)
+	}
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(lastModified)
+	return err == nil && !modified.After(ims)
+}
+
+// conditionalHandlerResponse gives a 200 handler response an ETag under
+// ConfigureETags and turns it into a 304 when the client's validators
+// match. Validators the handler set, through its returned headers or
+// SetResponseValidators, take precedence.
+func conditionalHandlerResponse(w http.ResponseWriter, r *http.Request, response HandlerResponse) HandlerResponse {
+	if response.Status != http.StatusOK || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		return response
+	}
+	h := w.Header().Clone()
+	for name, value := range response.Headers {
+		h.Set(name, value)
+	}
+	etag := h.Get("ETag")
+	if mode := currentETagMode(); etag == "" && mode != ETagsOff {
+		body := []byte(response.Body)
+		if response.BodyBase64 != nil {
+			body = response.BodyBase64
+		}
+		etag = bodyETag(body, mode == ETagsWeak)
+		headers := make(map[string]string, len(response.Headers)+1)
+		for name, value := range response.Headers {
+			headers[name] = value
+		}
+		headers["ETag"] = etag
+		response.Headers = headers
+	}
+	if requestNotModified(r, etag, h.Get("Last-Modified")) {
+		response.Status = http.StatusNotModified
+	}
+	return response
+}
+
+// ConfigureETags sets whether handler responses get an ETag computed from
+// their body: cMode is "strong", "weak" or "off", the default. GET and
+// HEAD requests whose If-None-Match matches, or whose If-Modified-Since is
+// not older than the handler's Last-Modified, are answered with 304.
+// Computed ETags are not remembered: the body is needed to compute one,
+// so the handler runs for every request. Only validators set with
+// SetResponseValidators answer matching requests without calling it.
+//
+//export ConfigureETags
+func ConfigureETags(cMode *C.char) int {
+	if cMode == nil {
+		return exportError(exportInvalid, "cMode is nil in ConfigureETags")
+	}
+	mode := strings.ToLower(C.GoString(cMode))
+	switch mode {
+	case "":
+		mode = ETagsOff
+	case ETagsOff, ETagsStrong, ETagsWeak:
+	default:
+		return exportError(exportInvalid, "Unknown ETag mode", "mode", mode)
+	}
+	etagMode.Store(mode)
+
+	slog.Info("ETags configured", "mode", mode)
+	auditConfigChange("ConfigureETags", map[string]string{"mode": mode})
+	return 0
+}
+
+const (
+	defaultValidatorMaxAge = time.Minute
+	maxStoredValidators    = 10000
+)
+
+// storedValidator is what SetResponseValidators set for a URL's last 200
+// response
+type storedValidator struct {
+	etag         string
+	lastModified string
+	vary         map[string]string // the request's values of the headers the response varies on
+	expires      time.Time
+}
+
+var (
+	// storedValidators are keyed by validatorKey
+	storedValidators   = make(map[string]storedValidator)
+	storedValidatorsMu sync.Mutex
+	validatorMaxAge    atomic.Int64 // nanoseconds; 0 stops remembering validators
+)
+
+func init() {
+	validatorMaxAge.Store(int64(defaultValidatorMaxAge))
+}
+
+// validatorKey identifies the resource of a GET or HEAD request; HEAD
+// shares GET's validators
+func validatorKey(r *http.Request) string {
+	return strings.ToLower(r.Host) + r.URL.Path + "?" + r.URL.RawQuery
+}
+
+// storeValidators remembers the validators a handler set for r's URL with
+// the values of the request headers the response varies on, once it is
+// negotiated. Responses to requests with credentials or a session are
+// private to their client and are not remembered.
+func storeValidators(w http.ResponseWriter, r *http.Request, response HandlerResponse) {
+	maxAge := time.Duration(validatorMaxAge.Load())
+	if !response.validated || maxAge <= 0 || response.Status != http.StatusOK || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		return
+	}
+	etag, lastModified := w.Header().Get("ETag"), w.Header().Get("Last-Modified")
+	if etag == "" && lastModified == "" {
+		return
+	}
+	if r.Header.Get("Authorization") != "" {
+		return
+	}
+	if info := requestInfoFrom(r); info != nil && requestHasSession(info.ID) {
+		return
+	}
+	vary := make(map[string]string)
+	for _, value := range w.Header().Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "*" {
+				return
+			}
+			if name != "" {
+				vary[name] = strings.Join(r.Header.Values(name), ", ")
+			}
+		}
+	}
+
+	now := time.Now()
+	storedValidatorsMu.Lock()
+	defer storedValidatorsMu.Unlock()
+	if len(storedValidators) >= maxStoredValidators {
+		for key, v := range storedValidators {
+			if now.After(v.expires) {
+				delete(storedValidators, key)
+			}
+		}
+		// Still full: make room by dropping an arbitrary entry
+		for key := range storedValidators {
+			if len(storedValidators) < maxStoredValidators {
+				break
+			}
+			delete(storedValidators, key)
+		}
+	}
+	storedValidators[validatorKey(r)] = storedValidator{etag: etag, lastModified: lastModified, vary: vary, expires: now.Add(maxAge)}
+}
+
+// sameVariant reports whether r selects the representation v was stored
+// for
+func (v storedValidator) sameVariant(r *http.Request) bool {
+	for name, value := range v.vary {
+		if strings.Join(r.Header.Values(name), ", ") != value {
+			return false
+		}
+	}
+	return true
+}
+
+// serveStoredNotModified answers a GET or HEAD request with 304 when its
+// validators match those remembered for its URL and representation,
+// before the handler runs. Requests with credentials always reach it.
+func serveStoredNotModified(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if r.Header.Get("If-None-Match") == "" && r.Header.Get("If-Modified-Since") == "" {
+		return false
+	}
+	if r.Header.Get("Authorization") != "" {
+		return false
+	}
+	if info := requestInfoFrom(r); info != nil && requestHasSession(info.ID) {
+		return false
+	}
+	storedValidatorsMu.Lock()
+	v, ok := storedValidators[validatorKey(r)]
+	if ok && time.Now().After(v.expires) {
+		delete(storedValidators, validatorKey(r))
+		ok = false
+	}
+	storedValidatorsMu.Unlock()
+	if !ok || !v.sameVariant(r) || !requestNotModified(r, v.etag, v.lastModified) {
+		return false
+	}
+	if v.etag != "" {
+		w.Header().Set("ETag", v.etag)
+	}
+	if v.lastModified != "" {
+		w.Header().Set("Last-Modified", v.lastModified)
+	}
+	for name := range v.vary {
+		w.Header().Add("Vary", name)
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// ConfigureValidatorCache sets how long validators set with
+// SetResponseValidators answer conditional requests for their URL without
+// the handler running, in milliseconds; the default is one minute and 0
+// turns this off. A handler that runs again replaces its URL's validators.
+// The values of the request headers the response varies on, such as the
+// Accept of a negotiated format, must match too; requests with an
+// Authorization header or a session always run the handler.
+//
+//export ConfigureValidatorCache
+func ConfigureValidatorCache(maxAgeMs int) int {
+	if maxAgeMs < 0 {
+		return exportError(exportInvalid, "Invalid validator cache max age", "max_age_ms", maxAgeMs)
+	}
+	validatorMaxAge.Store(int64(time.Duration(maxAgeMs) * time.Millisecond))
+	if maxAgeMs == 0 {
+		storedValidatorsMu.Lock()
+		clear(storedValidators)
+		storedValidatorsMu.Unlock()
+	}
+
+	slog.Info("Validator cache configured", "max_age_ms", maxAgeMs)
+	auditConfigChange("ConfigureValidatorCache", map[string]string{"max_age_ms": strconv.Itoa(maxAgeMs)})
+	return 0
+}
+
+// InvalidateResponseValidators forgets the remembered validators of every
+// URL with the path cPath, whatever its host and query, or of all URLs
+// when cPath is empty, so the next conditional request runs the handler.
+// Call it when the resource changes. Returns 0, or a negative status if
+// cPath is nil.
+//
+//export InvalidateResponseValidators
+func InvalidateResponseValidators(cPath *C.char) int {
+	if cPath == nil {
+		return exportError(exportInvalid, "cPath is nil in InvalidateResponseValidators")
+	}
+	path := C.GoString(cPath)
+	storedValidatorsMu.Lock()
+	removed := 0
+	for key := range storedValidators {
+		// Keys are host + path + "?" + query, and hosts hold no "/"
+		keyPath, _, _ := strings.Cut(key, "?")
+		if i := strings.IndexByte(keyPath, '/'); path == "" || i >= 0 && keyPath[i:] == path {
+			delete(storedValidators, key)
+			removed++
+		}
+	}
+	storedValidatorsMu.Unlock()
+	slog.Debug("Response validators invalidated", "path", path, "removed", removed)
+	return 0
+}
+
+// parseLastModified accepts an HTTP date, an RFC 3339 timestamp, or Unix
+// seconds
+func parseLastModified(value string) (time.Time, bool) {
+	if t, err := http.ParseTime(value); err == nil {
+		return t, true
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, true
+	}
+	if secs, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(secs, 0), true
+	}
+	return time.Time{}, false
+}
+
+// SetResponseValidators sets the ETag and Last-Modified of the response
+// to the request cRequestID while its handler callback runs; either may
+// be empty. An ETag without quotes is quoted, and W/ marks it weak.
+// cLastModified is an HTTP date, an RFC 3339 timestamp, or Unix seconds.
+// Returns 1 if the request's If-None-Match or If-Modified-Since already
+// match, in which case the server answers 304 and the handler can return
+// without building the body, 0 if not, or a negative status on error.
+// When the handler answers a GET or HEAD with 200, the validators are
+// remembered under ConfigureValidatorCache, unless the request has
+// credentials or a session.
+//
+//export SetResponseValidators
+func SetResponseValidators(cRequestID *C.char, cETag *C.char, cLastModified *C.char) int {
+	if cRequestID == nil || cETag == nil || cLastModified == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in SetResponseValidators")
+	}
+	requestID := C.GoString(cRequestID)
+	etag := C.GoString(cETag)
+	if etag != "" && !strings.HasSuffix(etag, `"`) {
+		weak := strings.HasPrefix(etag, "W/")
+		etag = `"` + strings.TrimPrefix(etag, "W/") + `"`
+		if weak {
+			etag = "W/" + etag
+		}
+	}
+	if strings.ContainsAny(etag, "\r\n\x00") || strings.Count(etag, `"`) > 2 {
+		return exportError(exportInvalid, "Invalid ETag", "request_id", requestID, "etag", etag)
+	}
+	var lastModified string
+	if text := C.GoString(cLastModified); text != "" {
+		t, ok := parseLastModified(text)
+		if !ok {
+			return exportError(exportInvalid, "Invalid Last-Modified", "request_id", requestID, "last_modified", text)
+		}
+		lastModified = t.UTC().Format(http.TimeFormat)
+	}
+	h, ok := handlerHeadersFor(requestID)
+	if !ok {
+		return exportError(exportNotFound, "Cannot set validators, handler not running", "request_id", requestID)
+	}
+	h.mu.Lock()
+	h.validated = true
+	if etag != "" {
+		h.response.Set("ETag", etag)
+	}
+	if lastModified != "" {
+		h.response.Set("Last-Modified", lastModified)
+	}
+	matches := requestNotModified(&http.Request{Method: h.method, Header: h.request}, h.response.Get("ETag"), h.response.Get("Last-Modified"))
+	h.mu.Unlock()
+	if matches {
+		return 1
+	}
+	return 0
+}
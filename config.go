@@ -0,0 +1,179 @@
+package main
+
+import (
+	"C"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ServerConfig holds the listener addresses and HTTP timeouts
+type ServerConfig struct {
+	Address      string // a host, or a list of listeners; see listenerSpecs
+	Port         int
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+
+	ReadHeaderTimeout  time.Duration // 0 uses ReadTimeout
+	MaxHeaderBytes     int           // 0 uses net/http's default
+	MaxRequestBodySize int64         // default per-route body limit; 0 means unlimited
+}
+
+// defaultServerConfig is used for anything not set by the host, the
+// environment, or a config file
+var defaultServerConfig = ServerConfig{
+	Address:      "",
+	Port:         8080,
+	ReadTimeout:  5 * time.Second,
+	WriteTimeout: 10 * time.Second,
+	IdleTimeout:  15 * time.Second,
+}
+
+var (
+	// hostConfig holds values set through SetServerConfig; zero values are unset
+	hostConfig   ServerConfig
+	hostConfigMu sync.RWMutex
+)
+
+// Addr returns the host:port of the main TCP listener
+func (c ServerConfig) Addr() string {
+	return net.JoinHostPort(c.host(), strconv.Itoa(c.Port))
+}
+
+// URL returns a human-readable base URL for log messages
+func (c ServerConfig) URL(secure bool) string {
+	host := c.host()
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	scheme := "http://"
+	if secure {
+		scheme = "https://"
+	}
+	return scheme + net.JoinHostPort(host, strconv.Itoa(c.Port))
+}
+
+// envInt reads an integer environment variable, returning 0 if absent or invalid
+func envInt(name string) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		slog.Warn("Ignoring invalid environment variable", "name", name, "value", value, "error", err)
+		return 0
+	}
+	return n
+}
+
+// envSeconds reads a duration in seconds from the environment
+func envSeconds(name string) time.Duration {
+	return time.Duration(envInt(name)) * time.Second
+}
+
+// effectiveServerConfig merges host settings, GOSERVER_* environment
+// variables, the LoadConfig file, and defaults, in that order of precedence
+func effectiveServerConfig() ServerConfig {
+	hostConfigMu.RLock()
+	host, file := hostConfig, fileConfig
+	hostConfigMu.RUnlock()
+
+	env := ServerConfig{
+		Address:      os.Getenv("GOSERVER_ADDRESS"),
+		Port:         envInt("GOSERVER_PORT"),
+		ReadTimeout:  envSeconds("GOSERVER_READ_TIMEOUT"),
+		WriteTimeout: envSeconds("GOSERVER_WRITE_TIMEOUT"),
+		IdleTimeout:  envSeconds("GOSERVER_IDLE_TIMEOUT"),
+
+		ReadHeaderTimeout:  envSeconds("GOSERVER_READ_HEADER_TIMEOUT"),
+		MaxHeaderBytes:     envInt("GOSERVER_MAX_HEADER_BYTES"),
+		MaxRequestBodySize: int64(envInt("GOSERVER_MAX_BODY_SIZE")),
+	}
+
+	cfg := defaultServerConfig
+	for _, layer := range []ServerConfig{file, env, host} {
+		if layer.Address != "" {
+			cfg.Address = layer.Address
+		}
+		if layer.Port > 0 {
+			cfg.Port = layer.Port
+		}
+		if layer.ReadTimeout > 0 {
+			cfg.ReadTimeout = layer.ReadTimeout
+		}
+		if layer.WriteTimeout > 0 {
+			cfg.WriteTimeout = layer.WriteTimeout
+		}
+		if layer.IdleTimeout > 0 {
+			cfg.IdleTimeout = layer.IdleTimeout
+		}
+		if layer.ReadHeaderTimeout > 0 {
+			cfg.ReadHeaderTimeout = layer.ReadHeaderTimeout
+		}
+		if layer.MaxHeaderBytes > 0 {
+			cfg.MaxHeaderBytes = layer.MaxHeaderBytes
+		}
+		if layer.MaxRequestBodySize > 0 {
+			cfg.MaxRequestBodySize = layer.MaxRequestBodySize
+		}
+	}
+	return cfg
+}
+
+// SetServerConfig sets the listen address, port, and timeouts (in seconds)
+// used by the next server start. The address may list several listeners
+// separated by commas: host:port TCP addresses, unix:<path> sockets, and
+// "systemd" for socket activation. Empty or zero values fall back to the
+// GOSERVER_ADDRESS, GOSERVER_PORT, GOSERVER_READ_TIMEOUT,
+// GOSERVER_WRITE_TIMEOUT, and GOSERVER_IDLE_TIMEOUT environment variables,
+// then to the LoadConfig file, then to the defaults.
+//
+//export SetServerConfig
+func SetServerConfig(cAddress *C.char, port int, readTimeout int, writeTimeout int, idleTimeout int) int {
+	var address string
+	if cAddress != nil {
+		address = C.GoString(cAddress)
+	}
+	if port < 0 || port > 65535 || readTimeout < 0 || writeTimeout < 0 || idleTimeout < 0 {
+		return exportError(exportInvalid, "Invalid server config", "port", port, "read_timeout", readTimeout, "write_timeout", writeTimeout, "idle_timeout", idleTimeout)
+	}
+
+	if _, err := (ServerConfig{Address: address}).listenerSpecs(); err != nil {
+		return exportError(exportInvalid, "Invalid server address", "address", address, "error", err)
+	}
+
+	hostConfigMu.Lock()
+	hostConfig.Address = address
+	hostConfig.Port = port
+	hostConfig.ReadTimeout = time.Duration(readTimeout) * time.Second
+	hostConfig.WriteTimeout = time.Duration(writeTimeout) * time.Second
+	hostConfig.IdleTimeout = time.Duration(idleTimeout) * time.Second
+	hostConfigMu.Unlock()
+
+	slog.Info("Server config set", "address", address, "port", port)
+	auditConfigChange("SetServerConfig", map[string]string{
+		"address":       address,
+		"port":          strconv.Itoa(port),
+		"read_timeout":  strconv.Itoa(readTimeout),
+		"write_timeout": strconv.Itoa(writeTimeout),
+		"idle_timeout":  strconv.Itoa(idleTimeout),
+	})
+
+	return 0
+}
+
+// StartServerWithConfig applies the given config and runs the server,
+// blocking like StartServer
+//
+//export StartServerWithConfig
+func StartServerWithConfig(cAddress *C.char, port int, readTimeout int, writeTimeout int, idleTimeout int) int {
+	if status := SetServerConfig(cAddress, port, readTimeout, writeTimeout, idleTimeout); status != 0 {
+		return status
+	}
+	return StartServer()
+}
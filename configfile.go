@@ -0,0 +1,513 @@
+package main
+
+import (
+	"C"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FileConfig is the layout of a LoadConfig file. Sections and settings
+// left out keep their current values.
+type FileConfig struct {
+	Server     ConfigServer       `json:"server"`
+	TLS        *ConfigTLS         `json:"tls"`
+	Middleware []ConfigMiddleware `json:"middleware"` // applied in order, so first registration sets chain position
+	Tasks      *ConfigTasks       `json:"tasks"`
+	Logging    *ConfigLogging     `json:"logging"`
+	Metrics    *bool              `json:"metrics"`
+	Reload     *ConfigReload      `json:"reload"`
+}
+
+// ConfigServer is the listener section; it mirrors SetServerConfig and
+// SetRequestLimits
+type ConfigServer struct {
+	Address           string          `json:"address"`
+	Port              int             `json:"port"`
+	ReadTimeout       configDuration  `json:"read_timeout"`
+	WriteTimeout      configDuration  `json:"write_timeout"`
+	IdleTimeout       configDuration  `json:"idle_timeout"`
+	ReadHeaderTimeout configDuration  `json:"read_header_timeout"`
+	DrainTimeout      *configDuration `json:"drain_timeout"` // see ConfigureShutdownDrain
+	MaxHeaderBytes    int             `json:"max_header_bytes"`
+	MaxBodySize       int64           `json:"max_body_size"`
+}
+
+// ConfigTLS mirrors EnableTLS, EnableSelfSignedTLS, EnableHTTPSRedirect and
+// EnableClientAuth
+type ConfigTLS struct {
+	CertFile     string   `json:"cert_file"`
+	KeyFile      string   `json:"key_file"`
+	SelfSigned   bool     `json:"self_signed"`
+	Hosts        []string `json:"hosts"` // self-signed certificate SANs
+	RedirectPort *int     `json:"redirect_port"`
+	ClientCA     string   `json:"client_ca"`
+	ClientAuth   string   `json:"client_auth"` // verify or require
+}
+
+// ConfigTasks mirrors ConfigureTaskPool
+type ConfigTasks struct {
+	Workers    int  `json:"workers"`
+	QueueDepth *int `json:"queue_depth"`
+}
+
+// ConfigLogging mirrors SetLogLevel, SetLogFormat and SetLogOutput
+type ConfigLogging struct {
+	Level      string `json:"level"`
+	Format     string `json:"format"`
+	File       string `json:"file"`
+	MaxSizeMB  int    `json:"max_size_mb"`
+	MaxBackups int    `json:"max_backups"`
+	MaxAgeDays int    `json:"max_age_days"`
+}
+
+// ConfigReload controls watching the file for changes; see ReloadConfig
+type ConfigReload struct {
+	Watch    *bool          `json:"watch"`    // defaults to true
+	Interval configDuration `json:"interval"` // how often the file is checked; defaults to 2s
+}
+
+// ConfigMiddleware toggles a built-in middleware. An entry may be just the
+// name, which enables it.
+type ConfigMiddleware struct {
+	Name    string          `json:"name"`
+	Enabled *bool           `json:"enabled"` // defaults to true
+	Options json.RawMessage `json:"options"` // as for RegisterMiddlewareWithOptions
+}
+
+func (m *ConfigMiddleware) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &m.Name); err == nil {
+		return nil
+	}
+	type entry ConfigMiddleware
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode((*entry)(m))
+}
+
+func (m ConfigMiddleware) enabled() bool {
+	return m.Enabled == nil || *m.Enabled
+}
+
+// configDuration is a number of seconds or a Go duration string like
+// "500ms"
+type configDuration time.Duration
+
+func (d *configDuration) UnmarshalJSON(data []byte) error {
+	var seconds float64
+	if err := json.Unmarshal(data, &seconds); err == nil {
+		*d = configDuration(seconds * float64(time.Second))
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("duration must be seconds or a string like \"1.5s\"")
+	}
+	parsed, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+	*d = configDuration(parsed)
+	return nil
+}
+
+// fileConfig holds the server settings of the last LoadConfig, below the
+// environment and host settings; guarded by hostConfigMu
+var fileConfig ServerConfig
+
+// decodeConfigFile parses a config file by its extension: .json, .yaml,
+// .yml or .toml
+func decodeConfigFile(path string, data []byte) (FileConfig, string, error) {
+	var cfg FileConfig
+	format := strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	var err error
+	switch format {
+	case "json":
+	case "yaml", "yml":
+		format = "yaml"
+		var value interface{}
+		if value, err = parseYAMLConfig(string(data)); err == nil {
+			data, err = json.Marshal(value)
+		}
+	case "toml":
+		var value map[string]interface{}
+		if value, err = parseTOMLConfig(string(data)); err == nil {
+			data, err = json.Marshal(value)
+		}
+	default:
+		return cfg, format, fmt.Errorf("unknown config format %q, want .json, .yaml, .yml or .toml", filepath.Ext(path))
+	}
+	if err != nil {
+		return cfg, format, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return cfg, format, err
+	}
+	return cfg, format, nil
+}
+
+// envBool reads a boolean environment variable
+func envBool(name string) (bool, bool) {
+	value := os.Getenv(name)
+	if value == "" {
+		return false, false
+	}
+	on, err := strconv.ParseBool(value)
+	if err != nil {
+		slog.Warn("Ignoring invalid environment variable", "name", name, "value", value, "error", err)
+		return false, false
+	}
+	return on, true
+}
+
+// applyConfigEnv overrides file settings from GOSERVER_* environment
+// variables. Server addresses, ports and timeouts need no override here:
+// effectiveServerConfig reads their variables at every start.
+func applyConfigEnv(cfg *FileConfig) {
+	if os.Getenv("GOSERVER_DRAIN_TIMEOUT") != "" {
+		d := configDuration(envSeconds("GOSERVER_DRAIN_TIMEOUT"))
+		cfg.Server.DrainTimeout = &d
+	}
+
+	tls := ConfigTLS{}
+	if cfg.TLS != nil {
+		tls = *cfg.TLS
+	}
+	overridden := false
+	for name, field := range map[string]*string{
+		"GOSERVER_TLS_CERT_FILE":   &tls.CertFile,
+		"GOSERVER_TLS_KEY_FILE":    &tls.KeyFile,
+		"GOSERVER_TLS_CLIENT_CA":   &tls.ClientCA,
+		"GOSERVER_TLS_CLIENT_AUTH": &tls.ClientAuth,
+	} {
+		if value := os.Getenv(name); value != "" {
+			*field, overridden = value, true
+		}
+	}
+	if os.Getenv("GOSERVER_TLS_REDIRECT_PORT") != "" {
+		port := envInt("GOSERVER_TLS_REDIRECT_PORT")
+		tls.RedirectPort, overridden = &port, true
+	}
+	if os.Getenv("GOSERVER_TLS_CERT_FILE") != "" {
+		tls.SelfSigned = false
+	}
+	if overridden {
+		cfg.TLS = &tls
+	}
+
+	if workers := envInt("GOSERVER_TASK_WORKERS"); workers > 0 {
+		if cfg.Tasks == nil {
+			cfg.Tasks = &ConfigTasks{}
+		}
+		cfg.Tasks.Workers = workers
+	}
+	if os.Getenv("GOSERVER_TASK_QUEUE_DEPTH") != "" {
+		if cfg.Tasks == nil {
+			cfg.Tasks = &ConfigTasks{}
+		}
+		depth := envInt("GOSERVER_TASK_QUEUE_DEPTH")
+		cfg.Tasks.QueueDepth = &depth
+	}
+
+	for name, field := range map[string]func(l *ConfigLogging) *string{
+		"GOSERVER_LOG_LEVEL":  func(l *ConfigLogging) *string { return &l.Level },
+		"GOSERVER_LOG_FORMAT": func(l *ConfigLogging) *string { return &l.Format },
+		"GOSERVER_LOG_FILE":   func(l *ConfigLogging) *string { return &l.File },
+	} {
+		if value := os.Getenv(name); value != "" {
+			if cfg.Logging == nil {
+				cfg.Logging = &ConfigLogging{}
+			}
+			*field(cfg.Logging) = value
+		}
+	}
+
+	if on, ok := envBool("GOSERVER_METRICS"); ok {
+		cfg.Metrics = &on
+	}
+
+	// GOSERVER_MIDDLEWARE_<NAME> toggles a built-in middleware
+	names := make([]string, 0, len(middlewareFactories))
+	for name := range middlewareFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		on, ok := envBool("GOSERVER_MIDDLEWARE_" + strings.ToUpper(name))
+		if !ok {
+			continue
+		}
+		found := false
+		for i := range cfg.Middleware {
+			if cfg.Middleware[i].Name == name {
+				cfg.Middleware[i].Enabled, found = &on, true
+			}
+		}
+		if !found {
+			cfg.Middleware = append(cfg.Middleware, ConfigMiddleware{Name: name, Enabled: &on})
+		}
+	}
+}
+
+// builtMiddleware is a validated middleware entry; mw is nil when the
+// entry only toggles a middleware
+type builtMiddleware struct {
+	ConfigMiddleware
+	mw func(next http.Handler) http.Handler
+}
+
+// validate checks every setting so a bad file changes nothing, building
+// the configured middleware on the way
+func (cfg *FileConfig) validate() ([]builtMiddleware, error) {
+	s := cfg.Server
+	if s.Port < 0 || s.Port > 65535 {
+		return nil, fmt.Errorf("server.port %d is out of range", s.Port)
+	}
+	if s.ReadTimeout < 0 || s.WriteTimeout < 0 || s.IdleTimeout < 0 || s.ReadHeaderTimeout < 0 ||
+		(s.DrainTimeout != nil && *s.DrainTimeout < 0) || s.MaxHeaderBytes < 0 || s.MaxBodySize < 0 {
+		return nil, errors.New("server timeouts and limits must not be negative")
+	}
+	if _, err := (ServerConfig{Address: s.Address}).listenerSpecs(); err != nil {
+		return nil, fmt.Errorf("server.address: %w", err)
+	}
+
+	if t := cfg.TLS; t != nil {
+		if (t.CertFile == "") != (t.KeyFile == "") {
+			return nil, errors.New("tls.cert_file and tls.key_file must be set together")
+		}
+		if t.SelfSigned && t.CertFile != "" {
+			return nil, errors.New("tls.self_signed cannot be combined with tls.cert_file")
+		}
+		if t.RedirectPort != nil && (*t.RedirectPort < 0 || *t.RedirectPort > 65535) {
+			return nil, fmt.Errorf("tls.redirect_port %d is out of range", *t.RedirectPort)
+		}
+		t.ClientAuth = strings.ToLower(strings.TrimSpace(t.ClientAuth))
+		if t.ClientAuth != "" && t.ClientAuth != "verify" && t.ClientAuth != "require" {
+			return nil, fmt.Errorf("unknown tls.client_auth %q", t.ClientAuth)
+		}
+		if t.ClientAuth != "" && t.ClientCA == "" {
+			return nil, errors.New("tls.client_auth needs tls.client_ca")
+		}
+	}
+
+	if t := cfg.Tasks; t != nil && (t.Workers < 0 || (t.QueueDepth != nil && *t.QueueDepth < 0)) {
+		return nil, errors.New("tasks.workers and tasks.queue_depth must not be negative")
+	}
+
+	if l := cfg.Logging; l != nil {
+		if _, ok := parseLogLevel(l.Level); l.Level != "" && !ok {
+			return nil, fmt.Errorf("unknown logging.level %q", l.Level)
+		}
+		l.Format = strings.ToLower(l.Format)
+		if l.Format != "" && l.Format != "text" && l.Format != "json" {
+			return nil, fmt.Errorf("unknown logging.format %q", l.Format)
+		}
+		if l.MaxSizeMB < 0 || l.MaxBackups < 0 || l.MaxAgeDays < 0 {
+			return nil, errors.New("logging rotation settings must not be negative")
+		}
+	}
+
+	built := make([]builtMiddleware, 0, len(cfg.Middleware))
+	for _, m := range cfg.Middleware {
+		factory, ok := middlewareFactories[m.Name]
+		if !ok {
+			return nil, fmt.Errorf("unknown middleware %q", m.Name)
+		}
+		entry := builtMiddleware{ConfigMiddleware: m}
+		if m.enabled() && len(m.Options) > 0 && !bytes.Equal(m.Options, []byte("null")) {
+			mw, err := factory(m.Options)
+			if err != nil {
+				return nil, fmt.Errorf("middleware %s: %w", m.Name, err)
+			}
+			entry.mw = mw
+		}
+		built = append(built, entry)
+	}
+	return built, nil
+}
+
+// apply installs validated settings. Logging goes first so the rest is
+// logged where the file says. On reload prev is the config applied before,
+// and sections that did not change are left alone so a new file does not
+// reopen the log, replace the task pool, or reset rate limit buckets.
+func (cfg *FileConfig) apply(middleware []builtMiddleware, prev *FileConfig, fromFile bool) error {
+	if l := cfg.Logging; l != nil && (prev == nil || !reflect.DeepEqual(l, prev.Logging)) {
+		if l.File != "" {
+			if err := setLogFile(l.File, l.MaxSizeMB, l.MaxBackups, l.MaxAgeDays); err != nil {
+				return fmt.Errorf("logging.file: %w", err)
+			}
+		}
+		if level, ok := parseLogLevel(l.Level); ok {
+			logLevel.Set(level)
+		}
+		if l.Format != "" {
+			logMu.Lock()
+			logFormat = l.Format
+			logMu.Unlock()
+			installLogger()
+		}
+	}
+
+	s := cfg.Server
+	if fromFile {
+		hostConfigMu.Lock()
+		fileConfig = ServerConfig{
+			Address:            s.Address,
+			Port:               s.Port,
+			ReadTimeout:        time.Duration(s.ReadTimeout),
+			WriteTimeout:       time.Duration(s.WriteTimeout),
+			IdleTimeout:        time.Duration(s.IdleTimeout),
+			ReadHeaderTimeout:  time.Duration(s.ReadHeaderTimeout),
+			MaxHeaderBytes:     s.MaxHeaderBytes,
+			MaxRequestBodySize: s.MaxBodySize,
+		}
+		hostConfigMu.Unlock()
+	}
+	if s.DrainTimeout != nil {
+		drainTimeout.Store(int64(*s.DrainTimeout))
+	}
+
+	if t := cfg.TLS; t != nil {
+		tlsSettingsMu.Lock()
+		switch {
+		case t.CertFile != "":
+			tlsSettings.Enabled, tlsSettings.SelfSigned = true, false
+			tlsSettings.CertFile, tlsSettings.KeyFile = t.CertFile, t.KeyFile
+			tlsSettings.CertPEM, tlsSettings.KeyPEM = nil, nil
+		case t.SelfSigned:
+			tlsSettings.Enabled, tlsSettings.SelfSigned = true, true
+			tlsSettings.Hosts = t.Hosts
+			if len(t.Hosts) == 0 {
+				tlsSettings.Hosts = []string{"localhost", "127.0.0.1"}
+			}
+		}
+		if t.RedirectPort != nil {
+			tlsSettings.RedirectHTTP, tlsSettings.RedirectPort = *t.RedirectPort > 0, *t.RedirectPort
+		}
+		if t.ClientCA != "" || t.ClientAuth != "" {
+			tlsSettings.ClientAuth = t.ClientAuth
+			tlsSettings.ClientCAFile, tlsSettings.ClientCAPEM = t.ClientCA, nil
+		}
+		tlsSettingsMu.Unlock()
+	}
+
+	if t := cfg.Tasks; t != nil && (prev == nil || !reflect.DeepEqual(t, prev.Tasks)) {
+		taskPoolMu.Lock()
+		if t.Workers > 0 {
+			taskPoolWorkers = t.Workers
+		}
+		if t.QueueDepth != nil {
+			taskPoolQueueDepth = *t.QueueDepth
+		}
+		running := activePool.Load()
+		taskPoolMu.Unlock()
+		if running != nil {
+			startTaskPool(running.ctx)
+		}
+	}
+
+	if cfg.Metrics != nil {
+		metricsEnabled.Store(*cfg.Metrics)
+	}
+
+	for _, m := range middleware {
+		if prev != nil && slices.ContainsFunc(prev.Middleware, func(p ConfigMiddleware) bool { return reflect.DeepEqual(p, m.ConfigMiddleware) }) {
+			continue
+		}
+		switch {
+		case !m.enabled():
+			disableMiddleware(m.Name)
+		case m.mw != nil:
+			middlewaresMu.Lock()
+			setMiddleware(m.Name, m.mw)
+			middlewaresMu.Unlock()
+		default:
+			addMiddleware(m.Name, nil)
+		}
+	}
+	// Middleware a reloaded file no longer lists is turned off
+	if prev != nil {
+		for _, p := range prev.Middleware {
+			if p.enabled() && !slices.ContainsFunc(cfg.Middleware, func(m ConfigMiddleware) bool { return m.Name == p.Name }) {
+				disableMiddleware(p.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// loadConfig reads, validates and applies the config file at path, or the
+// environment alone for an empty path. prev is the config applied before
+// when reloading.
+func loadConfig(path string, prev *FileConfig) (FileConfig, string, error) {
+	var cfg FileConfig
+	format := "env"
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, format, err
+		}
+		if cfg, format, err = decodeConfigFile(path, data); err != nil {
+			return cfg, format, err
+		}
+	}
+	applyConfigEnv(&cfg)
+	middleware, err := cfg.validate()
+	if err != nil {
+		return cfg, format, err
+	}
+	if err := cfg.apply(middleware, prev, path != ""); err != nil {
+		return cfg, format, err
+	}
+	return cfg, format, nil
+}
+
+// LoadConfig configures the server from the JSON, YAML or TOML file at
+// cPath, chosen by its extension; see FileConfig for the layout. GOSERVER_*
+// environment variables override the file: GOSERVER_ADDRESS, GOSERVER_PORT
+// and the other server variables read at start, plus
+// GOSERVER_DRAIN_TIMEOUT, GOSERVER_TLS_CERT_FILE, GOSERVER_TLS_KEY_FILE,
+// GOSERVER_TLS_REDIRECT_PORT, GOSERVER_TLS_CLIENT_CA,
+// GOSERVER_TLS_CLIENT_AUTH, GOSERVER_TASK_WORKERS,
+// GOSERVER_TASK_QUEUE_DEPTH, GOSERVER_LOG_LEVEL, GOSERVER_LOG_FORMAT,
+// GOSERVER_LOG_FILE, GOSERVER_METRICS, and GOSERVER_MIDDLEWARE_<NAME> to
+// toggle a middleware. An empty cPath applies the environment alone.
+// Server settings made by the host through SetServerConfig or
+// SetRequestLimits take precedence over both. The file is then watched,
+// and reloaded on SIGHUP; see ReloadConfig. Returns 0, or a negative status
+// if the file cannot be read or is invalid, in which case nothing changes.
+//
+//export LoadConfig
+func LoadConfig(cPath *C.char) int {
+	if cPath == nil {
+		return exportError(exportInvalid, "cPath is nil in LoadConfig")
+	}
+	path := C.GoString(cPath)
+	configMu.Lock()
+	defer configMu.Unlock()
+	cfg, format, err := loadConfig(path, nil)
+	if err != nil {
+		return exportError(exportFailed, "Cannot load config", "path", path, "error", err)
+	}
+	if path != "" {
+		loadedConfigPath, loadedConfig = path, cfg
+		watchConfigFile()
+	}
+
+	slog.Info("Config loaded", "path", path, "format", format, "middleware", len(cfg.Middleware))
+	auditConfigChange("LoadConfig", map[string]string{"path": path, "format": format})
+	return 0
+}
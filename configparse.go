@@ -0,0 +1,989 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// The config file parsers cover the parts of YAML and TOML that
+// configuration files use. Both produce the values encoding/json decodes
+// to, so a file is re-encoded as JSON and decoded into FileConfig whatever
+// its format.
+
+// configSyntaxError reports where a config file is malformed
+func configSyntaxError(line int, format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+// --- YAML ---------------------------------------------------------------
+
+// yamlLine is one significant line: its indentation and text without the
+// trailing comment
+type yamlLine struct {
+	num    int
+	indent int
+	text   string
+}
+
+// yamlParser reads block mappings, block sequences, block scalars (| and
+// >), flow collections on one line, and quoted and plain scalars. Anchors,
+// aliases, tags, and multiple documents are rejected.
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+	raw   []string // original lines, for block scalars
+}
+
+// parseYAMLConfig parses a YAML document
+func parseYAMLConfig(src string) (interface{}, error) {
+	p := &yamlParser{raw: strings.Split(strings.ReplaceAll(strings.TrimPrefix(src, "\ufeff"), "\r\n", "\n"), "\n")}
+	documents := 0
+	for i, raw := range p.raw {
+		trimmed := strings.TrimLeft(raw, " ")
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, configSyntaxError(i+1, "tabs are not allowed in indentation")
+		}
+		if raw == "---" || strings.HasPrefix(raw, "--- ") {
+			if documents++; documents > 1 || len(p.lines) > 0 {
+				return nil, configSyntaxError(i+1, "only one document is supported")
+			}
+			continue
+		}
+		if raw == "..." {
+			break
+		}
+		text := strings.TrimRight(stripYAMLComment(trimmed), " \t")
+		if text == "" || strings.HasPrefix(trimmed, "%") {
+			continue
+		}
+		p.lines = append(p.lines, yamlLine{num: i + 1, indent: len(raw) - len(trimmed), text: text})
+	}
+	if len(p.lines) == 0 {
+		return map[string]interface{}{}, nil
+	}
+	value, err := p.block(p.lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, configSyntaxError(p.lines[p.pos].num, "unexpected %q", p.lines[p.pos].text)
+	}
+	return value, nil
+}
+
+// stripYAMLComment drops a # comment that starts a line or follows
+// whitespace outside quotes
+func stripYAMLComment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			if i == 0 || strings.ContainsRune(" \t[{,:-", rune(s[i-1])) {
+				quote = c
+			}
+		case c == '#' && (i == 0 || s[i-1] == ' ' || s[i-1] == '\t'):
+			return s[:i]
+		}
+	}
+	return s
+}
+
+// block parses the mapping or sequence whose lines start at indent
+func (p *yamlParser) block(indent int) (interface{}, error) {
+	line := p.lines[p.pos]
+	if line.text == "-" || strings.HasPrefix(line.text, "- ") {
+		return p.sequence(indent)
+	}
+	if _, _, ok := splitYAMLKey(line.text); ok {
+		return p.mapping(indent)
+	}
+	// A lone scalar, such as a document holding only a value
+	p.pos++
+	return yamlInline(line.text, line.num)
+}
+
+func (p *yamlParser) sequence(indent int) (interface{}, error) {
+	items := make([]interface{}, 0)
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent < indent {
+			break
+		}
+		if line.indent > indent {
+			return nil, configSyntaxError(line.num, "unexpected indentation")
+		}
+		if line.text != "-" && !strings.HasPrefix(line.text, "- ") {
+			break
+		}
+		rest := strings.TrimLeft(strings.TrimPrefix(line.text, "-"), " ")
+		if rest != "" && (rest[0] == '|' || rest[0] == '>') {
+			p.pos++
+			item, err := p.blockScalar(yamlLine{num: line.num, indent: indent, text: rest}, indent)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+			continue
+		}
+		if rest == "" {
+			p.pos++
+			item, err := p.nested(indent)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+			continue
+		}
+		// The item's content continues as a block at its own column, so
+		// "- key: value" can be followed by more keys aligned with key
+		column := indent + len(line.text) - len(rest)
+		p.lines[p.pos] = yamlLine{num: line.num, indent: column, text: rest}
+		item, err := p.value(column)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// value parses the block or inline value starting at the current line
+func (p *yamlParser) value(indent int) (interface{}, error) {
+	line := p.lines[p.pos]
+	if line.text == "-" || strings.HasPrefix(line.text, "- ") {
+		return p.sequence(indent)
+	}
+	if _, _, ok := splitYAMLKey(line.text); ok {
+		return p.mapping(indent)
+	}
+	p.pos++
+	return yamlInline(line.text, line.num)
+}
+
+// nested parses the value of a key or item given on the following lines,
+// which is null if they are not indented further
+func (p *yamlParser) nested(parent int) (interface{}, error) {
+	if p.pos >= len(p.lines) || p.lines[p.pos].indent <= parent {
+		return nil, nil
+	}
+	return p.block(p.lines[p.pos].indent)
+}
+
+func (p *yamlParser) mapping(indent int) (interface{}, error) {
+	values := make(map[string]interface{})
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent < indent {
+			break
+		}
+		if line.indent > indent {
+			return nil, configSyntaxError(line.num, "unexpected indentation")
+		}
+		key, rest, ok := splitYAMLKey(line.text)
+		if !ok {
+			if line.text == "-" || strings.HasPrefix(line.text, "- ") {
+				break
+			}
+			return nil, configSyntaxError(line.num, "expected key: value")
+		}
+		if _, dup := values[key]; dup {
+			return nil, configSyntaxError(line.num, "duplicate key %q", key)
+		}
+		p.pos++
+		var value interface{}
+		var err error
+		switch {
+		case rest == "":
+			// A sequence may sit at the same indentation as its key
+			if p.pos < len(p.lines) && p.lines[p.pos].indent == indent &&
+				(p.lines[p.pos].text == "-" || strings.HasPrefix(p.lines[p.pos].text, "- ")) {
+				value, err = p.sequence(indent)
+			} else {
+				value, err = p.nested(indent)
+			}
+		case rest[0] == '|' || rest[0] == '>':
+			value, err = p.blockScalar(yamlLine{num: line.num, indent: indent, text: rest}, indent)
+		default:
+			value, err = yamlInline(rest, line.num)
+		}
+		if err != nil {
+			return nil, err
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+// splitYAMLKey splits "key: value" at the first colon followed by a space
+// or the end of the line, outside quotes and flow collections
+func splitYAMLKey(text string) (string, string, bool) {
+	if text[0] == '[' || text[0] == '{' {
+		return "", "", false
+	}
+	end := -1
+	if text[0] == '"' || text[0] == '\'' {
+		if end = closingQuote(text); end < 0 {
+			return "", "", false
+		}
+	}
+	for i := end + 1; i < len(text); i++ {
+		if text[i] == ':' && (i+1 == len(text) || text[i+1] == ' ') {
+			key := strings.TrimSpace(text[:i])
+			if key == "" {
+				return "", "", false
+			}
+			if key[0] == '"' || key[0] == '\'' {
+				unquoted, err := yamlInline(key, 0)
+				if err != nil {
+					return "", "", false
+				}
+				key, _ = unquoted.(string)
+			}
+			return key, strings.TrimSpace(text[i+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+// closingQuote returns the index of the quote closing the one at text[0]
+func closingQuote(text string) int {
+	quote := text[0]
+	for i := 1; i < len(text); i++ {
+		switch {
+		case quote == '"' && text[i] == '\\':
+			i++
+		case text[i] == quote:
+			if quote == '\'' && i+1 < len(text) && text[i+1] == '\'' {
+				i++
+				continue
+			}
+			return i
+		}
+	}
+	return -1
+}
+
+// blockScalar reads a literal (|) or folded (>) block with optional -
+// and + chomping indicators from the raw lines after header
+func (p *yamlParser) blockScalar(header yamlLine, indent int) (interface{}, error) {
+	style, chomp := header.text[0], byte(0)
+	for _, c := range header.text[1:] {
+		switch {
+		case c == '-' || c == '+':
+			chomp = byte(c)
+		case c >= '1' && c <= '9':
+			return nil, configSyntaxError(header.num, "block indentation indicators are not supported")
+		default:
+			return nil, configSyntaxError(header.num, "invalid block scalar header %q", header.text)
+		}
+	}
+	var body []string
+	blockIndent := -1
+	next := header.num // raw index of the line after the header
+	for ; next < len(p.raw); next++ {
+		raw := p.raw[next]
+		trimmed := strings.TrimLeft(raw, " ")
+		if trimmed == "" {
+			body = append(body, "")
+			continue
+		}
+		lineIndent := len(raw) - len(trimmed)
+		if blockIndent < 0 {
+			blockIndent = lineIndent
+		}
+		if lineIndent <= indent || lineIndent < blockIndent {
+			break
+		}
+		body = append(body, raw[blockIndent:])
+	}
+	// Skip the significant lines the block consumed
+	for p.pos < len(p.lines) && p.lines[p.pos].num <= next {
+		p.pos++
+	}
+	trailing := 0
+	for len(body) > 0 && body[len(body)-1] == "" {
+		body = body[:len(body)-1]
+		trailing++
+	}
+	var text string
+	if style == '|' {
+		text = strings.Join(body, "\n")
+	} else {
+		var b strings.Builder
+		for i, line := range body {
+			if i > 0 {
+				if line == "" || body[i-1] == "" || strings.HasPrefix(line, " ") {
+					b.WriteByte('\n')
+				} else {
+					b.WriteByte(' ')
+				}
+			}
+			b.WriteString(line)
+		}
+		text = b.String()
+	}
+	switch {
+	case len(body) == 0:
+	case chomp == '+':
+		text += strings.Repeat("\n", trailing+1)
+	case chomp != '-':
+		text += "\n"
+	}
+	return text, nil
+}
+
+// yamlInline parses an inline value: a flow collection, a quoted string,
+// or a plain scalar
+func yamlInline(text string, num int) (interface{}, error) {
+	switch text[0] {
+	case '[', '{':
+		f := &yamlFlow{src: text, num: num}
+		value, err := f.value()
+		if err != nil {
+			return nil, err
+		}
+		if f.skipSpace(); f.pos < len(f.src) {
+			return nil, configSyntaxError(num, "unexpected %q after flow collection", f.src[f.pos:])
+		}
+		return value, nil
+	case '"', '\'':
+		end := closingQuote(text)
+		if end < 0 {
+			return nil, configSyntaxError(num, "unterminated string")
+		}
+		if strings.TrimSpace(text[end+1:]) != "" {
+			return nil, configSyntaxError(num, "unexpected %q after string", text[end+1:])
+		}
+		return yamlQuoted(text[:end+1], num)
+	case '&', '*', '!':
+		return nil, configSyntaxError(num, "anchors, aliases, and tags are not supported")
+	}
+	return yamlResolvePlain(text), nil
+}
+
+// yamlQuoted decodes a single- or double-quoted string including quotes
+func yamlQuoted(text string, num int) (string, error) {
+	inner := text[1 : len(text)-1]
+	if text[0] == '\'' {
+		return strings.ReplaceAll(inner, "''", "'"), nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(inner); i++ {
+		c := inner[i]
+		if c != '\\' {
+			b.WriteByte(c)
+			continue
+		}
+		if i++; i == len(inner) {
+			return "", configSyntaxError(num, "invalid escape")
+		}
+		switch inner[i] {
+		case '0':
+			b.WriteByte(0)
+		case 'a':
+			b.WriteByte('\a')
+		case 'b':
+			b.WriteByte('\b')
+		case 't', '\t':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'v':
+			b.WriteByte('\v')
+		case 'f':
+			b.WriteByte('\f')
+		case 'r':
+			b.WriteByte('\r')
+		case 'e':
+			b.WriteByte(0x1b)
+		case ' ', '"', '/', '\\':
+			b.WriteByte(inner[i])
+		case 'x', 'u', 'U':
+			size := map[byte]int{'x': 2, 'u': 4, 'U': 8}[inner[i]]
+			if i+size >= len(inner) {
+				return "", configSyntaxError(num, "invalid escape")
+			}
+			n, err := strconv.ParseUint(inner[i+1:i+1+size], 16, 32)
+			if err != nil || !utf8.ValidRune(rune(n)) {
+				return "", configSyntaxError(num, "invalid escape")
+			}
+			b.WriteRune(rune(n))
+			i += size
+		default:
+			return "", configSyntaxError(num, "invalid escape \\%c", inner[i])
+		}
+	}
+	return b.String(), nil
+}
+
+// yamlResolvePlain resolves a plain scalar with the YAML 1.2 core schema
+func yamlResolvePlain(text string) interface{} {
+	switch text {
+	case "~", "null", "Null", "NULL":
+		return nil
+	case "true", "True", "TRUE":
+		return true
+	case "false", "False", "FALSE":
+		return false
+	case ".inf", ".Inf", ".INF", "+.inf", "+.Inf", "+.INF":
+		return math.Inf(1)
+	case "-.inf", "-.Inf", "-.INF":
+		return math.Inf(-1)
+	case ".nan", ".NaN", ".NAN":
+		return math.NaN()
+	}
+	if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return n
+	}
+	if strings.HasPrefix(text, "0x") || strings.HasPrefix(text, "0o") {
+		if n, err := strconv.ParseInt(text, 0, 64); err == nil {
+			return n
+		}
+	}
+	if strings.ContainsAny(text, "0123456789") && !strings.ContainsAny(text, "_xX") {
+		if f, err := strconv.ParseFloat(text, 64); err == nil {
+			return f
+		}
+	}
+	return text
+}
+
+// yamlFlow parses a flow collection such as [a, {b: 1}]
+type yamlFlow struct {
+	src string
+	pos int
+	num int
+}
+
+func (f *yamlFlow) skipSpace() {
+	for f.pos < len(f.src) && (f.src[f.pos] == ' ' || f.src[f.pos] == '\t') {
+		f.pos++
+	}
+}
+
+func (f *yamlFlow) value() (interface{}, error) {
+	f.skipSpace()
+	if f.pos == len(f.src) {
+		return nil, configSyntaxError(f.num, "unterminated flow collection")
+	}
+	switch c := f.src[f.pos]; c {
+	case '[':
+		f.pos++
+		items := make([]interface{}, 0)
+		for {
+			if f.skipSpace(); f.pos < len(f.src) && f.src[f.pos] == ']' {
+				f.pos++
+				return items, nil
+			}
+			item, err := f.value()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+			if err := f.separator(']'); err != nil {
+				return nil, err
+			}
+		}
+	case '{':
+		f.pos++
+		values := make(map[string]interface{})
+		for {
+			if f.skipSpace(); f.pos < len(f.src) && f.src[f.pos] == '}' {
+				f.pos++
+				return values, nil
+			}
+			key, err := f.value()
+			if err != nil {
+				return nil, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				name = fmt.Sprint(key)
+			}
+			if f.skipSpace(); f.pos == len(f.src) || f.src[f.pos] != ':' {
+				return nil, configSyntaxError(f.num, "expected : after %q in flow mapping", name)
+			}
+			f.pos++
+			if values[name], err = f.value(); err != nil {
+				return nil, err
+			}
+			if err := f.separator('}'); err != nil {
+				return nil, err
+			}
+		}
+	case '"', '\'':
+		end := closingQuote(f.src[f.pos:])
+		if end < 0 {
+			return nil, configSyntaxError(f.num, "unterminated string")
+		}
+		s, err := yamlQuoted(f.src[f.pos:f.pos+end+1], f.num)
+		f.pos += end + 1
+		return s, err
+	default:
+		start := f.pos
+		for f.pos < len(f.src) && !strings.ContainsRune(",]}", rune(f.src[f.pos])) &&
+			!(f.src[f.pos] == ':' && (f.pos+1 == len(f.src) || f.src[f.pos+1] == ' ')) {
+			f.pos++
+		}
+		return yamlResolvePlain(strings.TrimSpace(f.src[start:f.pos])), nil
+	}
+}
+
+// separator consumes the comma after a flow entry, leaving the closing
+// bracket for the caller
+func (f *yamlFlow) separator(closing byte) error {
+	f.skipSpace()
+	switch {
+	case f.pos < len(f.src) && f.src[f.pos] == ',':
+		f.pos++
+		return nil
+	case f.pos < len(f.src) && f.src[f.pos] == closing:
+		return nil
+	}
+	return configSyntaxError(f.num, "expected , or %c in flow collection", closing)
+}
+
+// --- TOML ---------------------------------------------------------------
+
+// tomlParser reads TOML tables, arrays of tables, dotted keys, strings,
+// numbers, booleans, arrays, and inline tables. Dates and times are kept as
+// strings.
+type tomlParser struct {
+	src  string
+	pos  int
+	line int
+}
+
+// parseTOMLConfig parses a TOML document
+func parseTOMLConfig(src string) (map[string]interface{}, error) {
+	p := &tomlParser{src: strings.TrimPrefix(strings.ReplaceAll(src, "\r\n", "\n"), "\ufeff"), line: 1}
+	root := make(map[string]interface{})
+	current := root
+	for {
+		p.skipBlank()
+		if p.pos == len(p.src) {
+			return root, nil
+		}
+		var err error
+		if p.src[p.pos] == '[' {
+			current, err = p.tableHeader(root)
+		} else {
+			err = p.keyValue(current)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := p.endOfLine(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// skipBlank skips whitespace, newlines, and comments
+func (p *tomlParser) skipBlank() {
+	for p.pos < len(p.src) {
+		switch p.src[p.pos] {
+		case '\n':
+			p.line++
+		case ' ', '\t':
+		case '#':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+			continue
+		default:
+			return
+		}
+		p.pos++
+	}
+}
+
+func (p *tomlParser) skipSpace() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *tomlParser) endOfLine() error {
+	p.skipSpace()
+	if p.pos < len(p.src) && p.src[p.pos] == '#' {
+		for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+			p.pos++
+		}
+	}
+	if p.pos < len(p.src) && p.src[p.pos] != '\n' {
+		return configSyntaxError(p.line, "unexpected %q", p.rest())
+	}
+	return nil
+}
+
+// rest is the remainder of the current line, for error messages
+func (p *tomlParser) rest() string {
+	end := strings.IndexByte(p.src[p.pos:], '\n')
+	if end < 0 {
+		return p.src[p.pos:]
+	}
+	return p.src[p.pos : p.pos+end]
+}
+
+// tableHeader reads [a.b] or [[a.b]] and returns the table it opens
+func (p *tomlParser) tableHeader(root map[string]interface{}) (map[string]interface{}, error) {
+	array := strings.HasPrefix(p.src[p.pos:], "[[")
+	p.pos++
+	if array {
+		p.pos++
+	}
+	p.skipSpace()
+	keys, err := p.key()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	closing := "]"
+	if array {
+		closing = "]]"
+	}
+	if !strings.HasPrefix(p.src[p.pos:], closing) {
+		return nil, configSyntaxError(p.line, "expected %s after table name", closing)
+	}
+	p.pos += len(closing)
+
+	parent, err := p.descend(root, keys[:len(keys)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := keys[len(keys)-1]
+	if array {
+		tables, _ := parent[last].([]interface{})
+		if _, exists := parent[last]; exists && tables == nil {
+			return nil, configSyntaxError(p.line, "%q is not an array of tables", last)
+		}
+		table := make(map[string]interface{})
+		parent[last] = append(tables, table)
+		return table, nil
+	}
+	switch existing := parent[last].(type) {
+	case nil:
+		table := make(map[string]interface{})
+		parent[last] = table
+		return table, nil
+	case map[string]interface{}:
+		return existing, nil
+	}
+	return nil, configSyntaxError(p.line, "%q is already defined", last)
+}
+
+// descend walks to the table at keys, creating tables as needed; an array
+// of tables resolves to its last element
+func (p *tomlParser) descend(table map[string]interface{}, keys []string) (map[string]interface{}, error) {
+	for _, k := range keys {
+		switch next := table[k].(type) {
+		case nil:
+			child := make(map[string]interface{})
+			table[k] = child
+			table = child
+		case map[string]interface{}:
+			table = next
+		case []interface{}:
+			last, ok := next[len(next)-1].(map[string]interface{})
+			if !ok {
+				return nil, configSyntaxError(p.line, "%q is not a table", k)
+			}
+			table = last
+		default:
+			return nil, configSyntaxError(p.line, "%q is not a table", k)
+		}
+	}
+	return table, nil
+}
+
+// key reads a bare, quoted, or dotted key
+func (p *tomlParser) key() ([]string, error) {
+	var keys []string
+	for {
+		p.skipSpace()
+		if p.pos == len(p.src) {
+			return nil, configSyntaxError(p.line, "expected a key")
+		}
+		switch c := p.src[p.pos]; {
+		case c == '"' || c == '\'':
+			s, err := p.stringValue()
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, s)
+		default:
+			start := p.pos
+			for p.pos < len(p.src) && isTOMLBareKey(p.src[p.pos]) {
+				p.pos++
+			}
+			if start == p.pos {
+				return nil, configSyntaxError(p.line, "invalid key %q", p.rest())
+			}
+			keys = append(keys, p.src[start:p.pos])
+		}
+		p.skipSpace()
+		if p.pos == len(p.src) || p.src[p.pos] != '.' {
+			return keys, nil
+		}
+		p.pos++
+	}
+}
+
+func isTOMLBareKey(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+// keyValue reads key = value into table
+func (p *tomlParser) keyValue(table map[string]interface{}) error {
+	keys, err := p.key()
+	if err != nil {
+		return err
+	}
+	if p.skipSpace(); p.pos == len(p.src) || p.src[p.pos] != '=' {
+		return configSyntaxError(p.line, "expected = after key %q", strings.Join(keys, "."))
+	}
+	p.pos++
+	p.skipSpace()
+	value, err := p.value()
+	if err != nil {
+		return err
+	}
+	parent, err := p.descend(table, keys[:len(keys)-1])
+	if err != nil {
+		return err
+	}
+	last := keys[len(keys)-1]
+	if _, exists := parent[last]; exists {
+		return configSyntaxError(p.line, "%q is already defined", strings.Join(keys, "."))
+	}
+	parent[last] = value
+	return nil
+}
+
+func (p *tomlParser) value() (interface{}, error) {
+	if p.pos == len(p.src) {
+		return nil, configSyntaxError(p.line, "expected a value")
+	}
+	switch c := p.src[p.pos]; {
+	case c == '"' || c == '\'':
+		return p.stringValue()
+	case c == '[':
+		p.pos++
+		items := make([]interface{}, 0)
+		for {
+			p.skipBlank()
+			if p.pos < len(p.src) && p.src[p.pos] == ']' {
+				p.pos++
+				return items, nil
+			}
+			item, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+			p.skipBlank()
+			if p.pos < len(p.src) && p.src[p.pos] == ',' {
+				p.pos++
+			} else if p.pos == len(p.src) || p.src[p.pos] != ']' {
+				return nil, configSyntaxError(p.line, "expected , or ] in array")
+			}
+		}
+	case c == '{':
+		p.pos++
+		table := make(map[string]interface{})
+		for first := true; ; first = false {
+			p.skipSpace()
+			if p.pos < len(p.src) && p.src[p.pos] == '}' && first {
+				p.pos++
+				return table, nil
+			}
+			if err := p.keyValue(table); err != nil {
+				return nil, err
+			}
+			p.skipSpace()
+			if p.pos < len(p.src) && p.src[p.pos] == '}' {
+				p.pos++
+				return table, nil
+			}
+			if p.pos == len(p.src) || p.src[p.pos] != ',' {
+				return nil, configSyntaxError(p.line, "expected , or } in inline table")
+			}
+			p.pos++
+		}
+	}
+	start := p.pos
+	for p.pos < len(p.src) && !strings.ContainsRune(" \t\n,]}#", rune(p.src[p.pos])) {
+		p.pos++
+	}
+	// Times like 1979-05-27 07:32:00 have a space before the time part
+	if p.pos+1 < len(p.src) && p.src[p.pos] == ' ' && p.src[p.pos+1] >= '0' && p.src[p.pos+1] <= '9' && tomlIsDate(p.src[start:p.pos]) {
+		for p.pos++; p.pos < len(p.src) && !strings.ContainsRune(" \t\n,]}#", rune(p.src[p.pos])); p.pos++ {
+		}
+	}
+	return tomlAtom(p.src[start:p.pos], p.line)
+}
+
+func tomlIsDate(s string) bool {
+	return len(s) == 10 && s[4] == '-' && s[7] == '-'
+}
+
+// tomlAtom parses a boolean, number, or date
+func tomlAtom(text string, line int) (interface{}, error) {
+	switch text {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "inf", "+inf":
+		return math.Inf(1), nil
+	case "-inf":
+		return math.Inf(-1), nil
+	case "nan", "+nan", "-nan":
+		return math.NaN(), nil
+	case "":
+		return nil, configSyntaxError(line, "expected a value")
+	}
+	if len(text) >= 10 && tomlIsDate(text[:10]) || len(text) >= 8 && text[2] == ':' && text[5] == ':' {
+		return text, nil
+	}
+	digits := strings.ReplaceAll(text, "_", "")
+	unsigned := strings.TrimLeft(digits, "+-")
+	if unsigned == "" || unsigned[0] < '0' || unsigned[0] > '9' {
+		return nil, configSyntaxError(line, "invalid value %q", text)
+	}
+	if len(unsigned) > 1 && unsigned[0] == '0' {
+		switch unsigned[1] {
+		case 'x', 'o', 'b':
+			if n, err := strconv.ParseInt(digits, 0, 64); err == nil && digits == unsigned {
+				return n, nil
+			}
+			return nil, configSyntaxError(line, "invalid value %q", text)
+		case '.', 'e', 'E':
+		default:
+			return nil, configSyntaxError(line, "leading zeros are not allowed in %q", text)
+		}
+	}
+	if n, err := strconv.ParseInt(digits, 10, 64); err == nil {
+		return n, nil
+	}
+	if f, err := strconv.ParseFloat(digits, 64); err == nil {
+		return f, nil
+	}
+	return nil, configSyntaxError(line, "invalid value %q", text)
+}
+
+// stringValue reads a basic, literal, or multi-line string
+func (p *tomlParser) stringValue() (string, error) {
+	quote := p.src[p.pos]
+	delim := string(quote)
+	multiline := strings.HasPrefix(p.src[p.pos:], strings.Repeat(delim, 3))
+	if multiline {
+		delim = strings.Repeat(delim, 3)
+		p.pos += 3
+		// A newline right after the opening delimiter is trimmed
+		if p.pos < len(p.src) && p.src[p.pos] == '\n' {
+			p.pos++
+			p.line++
+		}
+	} else {
+		p.pos++
+	}
+	var b strings.Builder
+	for {
+		if p.pos == len(p.src) {
+			return "", configSyntaxError(p.line, "unterminated string")
+		}
+		if strings.HasPrefix(p.src[p.pos:], delim) {
+			// Up to two quotes may directly precede a multi-line closing delimiter
+			for multiline && p.pos+3 < len(p.src) && p.src[p.pos+3] == quote {
+				b.WriteByte(quote)
+				p.pos++
+			}
+			p.pos += len(delim)
+			return b.String(), nil
+		}
+		c := p.src[p.pos]
+		switch {
+		case c == '\n':
+			if !multiline {
+				return "", configSyntaxError(p.line, "newline in string")
+			}
+			p.line++
+			b.WriteByte(c)
+			p.pos++
+		case c == '\\' && quote == '"':
+			if err := p.escape(&b, multiline); err != nil {
+				return "", err
+			}
+		default:
+			b.WriteByte(c)
+			p.pos++
+		}
+	}
+}
+
+// escape decodes the escape sequence at the current backslash
+func (p *tomlParser) escape(b *strings.Builder, multiline bool) error {
+	p.pos++
+	if p.pos == len(p.src) {
+		return configSyntaxError(p.line, "unterminated string")
+	}
+	c := p.src[p.pos]
+	p.pos++
+	switch c {
+	case 'b':
+		b.WriteByte('\b')
+	case 't':
+		b.WriteByte('\t')
+	case 'n':
+		b.WriteByte('\n')
+	case 'f':
+		b.WriteByte('\f')
+	case 'r':
+		b.WriteByte('\r')
+	case 'e':
+		b.WriteByte(0x1b)
+	case '"', '\\':
+		b.WriteByte(c)
+	case 'u', 'U':
+		size := 4
+		if c == 'U' {
+			size = 8
+		}
+		if p.pos+size > len(p.src) {
+			return configSyntaxError(p.line, "invalid escape")
+		}
+		n, err := strconv.ParseUint(p.src[p.pos:p.pos+size], 16, 32)
+		if err != nil || !utf8.ValidRune(rune(n)) {
+			return configSyntaxError(p.line, "invalid escape")
+		}
+		b.WriteRune(rune(n))
+		p.pos += size
+	case ' ', '\t', '\n':
+		// A line-ending backslash trims the newline and following whitespace
+		if !multiline {
+			return configSyntaxError(p.line, "invalid escape")
+		}
+		p.pos--
+		for p.pos < len(p.src) && strings.ContainsRune(" \t\n", rune(p.src[p.pos])) {
+			if p.src[p.pos] == '\n' {
+				p.line++
+			}
+			p.pos++
+		}
+	default:
+		return configSyntaxError(p.line, "invalid escape \\%c", c)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"C"
+	"errors"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const defaultConfigWatchInterval = 2 * time.Second
+
+var errNoConfigFile = errors.New("no config file was loaded")
+
+var (
+	// configMu serializes loads and reloads and guards the state below
+	configMu         sync.Mutex
+	loadedConfigPath string
+	loadedConfig     FileConfig    // as last applied, environment included
+	configWatchStop  chan struct{} // stops the running file watcher
+	configSignals    sync.Once
+)
+
+// watchConfigFile (re)starts polling the loaded file per its reload
+// section and makes sure SIGHUP reloads it. Must hold configMu.
+func watchConfigFile() {
+	configSignals.Do(func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				reloadConfig("sighup")
+			}
+		}()
+	})
+	if configWatchStop != nil {
+		close(configWatchStop)
+		configWatchStop = nil
+	}
+	r := loadedConfig.Reload
+	if r != nil && r.Watch != nil && !*r.Watch {
+		return
+	}
+	interval := defaultConfigWatchInterval
+	if r != nil && r.Interval > 0 {
+		interval = time.Duration(r.Interval)
+	}
+	stop := make(chan struct{})
+	configWatchStop = stop
+	go pollConfigFile(loadedConfigPath, interval, stop)
+}
+
+// pollConfigFile reloads when the file's modification time or size
+// changes. Editors that save by renaming a new file into place are seen the
+// same way.
+func pollConfigFile(path string, interval time.Duration, stop chan struct{}) {
+	last, _ := os.Stat(path)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			// Missing for a moment while being replaced, or gone; keep the
+			// running config either way
+			continue
+		}
+		if last != nil && info.ModTime().Equal(last.ModTime()) && info.Size() == last.Size() {
+			continue
+		}
+		last = info
+		reloadConfig("watch")
+	}
+}
+
+// listenerSettings are the parts of a config that only take effect when
+// the listener is bound
+func listenerSettings(cfg FileConfig) []interface{} {
+	server := cfg.Server
+	server.DrainTimeout = nil
+	return []interface{}{server, cfg.TLS}
+}
+
+// reloadConfig applies the loaded file again. A file that no longer
+// parses or validates is rejected and the running config stays in place.
+func reloadConfig(trigger string) error {
+	configMu.Lock()
+	defer configMu.Unlock()
+	if loadedConfigPath == "" {
+		slog.Warn("Config reload requested but no config file was loaded", "trigger", trigger)
+		return errNoConfigFile
+	}
+	prev := loadedConfig
+	cfg, _, err := loadConfig(loadedConfigPath, &prev)
+	if err != nil {
+		slog.Error("Config reload failed; keeping the current config", "path", loadedConfigPath, "trigger", trigger, "error", err)
+		return err
+	}
+	loadedConfig = cfg
+	if !reflect.DeepEqual(prev.Reload, cfg.Reload) {
+		watchConfigFile()
+	}
+
+	currentMu.Lock()
+	running := current != nil
+	currentMu.Unlock()
+	if running && !reflect.DeepEqual(listenerSettings(prev), listenerSettings(cfg)) {
+		slog.Warn("Config changes to the server address, listener timeouts, and TLS take effect at the next restart", "path", loadedConfigPath)
+	}
+
+	slog.Info("Config reloaded", "path", loadedConfigPath, "trigger", trigger)
+	auditConfigChange("ReloadConfig", map[string]string{"path": loadedConfigPath, "trigger": trigger})
+	return nil
+}
+
+// ReloadConfig re-reads the file given to LoadConfig and applies what
+// changed without restarting the listener: logging, middleware such as the
+// rate limits, CORS options, and request timeouts, the shutdown drain
+// timeout, metrics, and the task pool. Middleware the file no longer lists
+// is turned off. Server address, listener timeouts, and TLS changes are
+// kept for the next start. The same reload runs on SIGHUP and, unless the
+// file sets reload.watch to false, whenever the file changes. Returns 0, or
+// a negative status if no file was loaded or the new file is invalid, in
+// which case the running config stays in place.
+//
+//export ReloadConfig
+func ReloadConfig() int {
+	if err := reloadConfig("export"); err != nil {
+		setLastError("Config reload failed", "error", err)
+		return exportFailed
+	}
+	return 0
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"C"
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// maxConnectionAge caps how long a single client connection may live, in
+// nanoseconds. Zero disables the limit.
+var maxConnectionAge atomic.Int64
+
+type connCtxKey struct{}
+
+// trackedConn records when a connection was accepted
+type trackedConn struct {
+	net.Conn
+	created time.Time
+	expired atomic.Bool
+}
+
+// expire marks the connection as closed due to age exactly once
+func (c *trackedConn) expire() bool {
+	if c.expired.CompareAndSwap(false, true) {
+		stats.ConnectionsClosedByAge.Add(1)
+		return true
+	}
+	return false
+}
+
+func (c *trackedConn) tooOld() bool {
+	limit := time.Duration(maxConnectionAge.Load())
+	return limit > 0 && time.Since(c.created) > limit
+}
+
+// trackingListener wraps accepted connections so their age can be enforced
+type trackingListener struct {
+	net.Listener
+}
+
+func (l *trackingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	stats.ConnectionsAccepted.Add(1)
+	return &trackedConn{Conn: conn, created: time.Now()}, nil
+}
+
+// asTrackedConn unwraps TLS connections to reach the tracked connection
+func asTrackedConn(conn net.Conn) (*trackedConn, bool) {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	tc, ok := conn.(*trackedConn)
+	return tc, ok
+}
+
+// connContext stores the tracked connection in each request context
+func connContext(ctx context.Context, conn net.Conn) context.Context {
+	if tc, ok := asTrackedConn(conn); ok {
+		return context.WithValue(ctx, connCtxKey{}, tc)
+	}
+	return ctx
+}
+
+// connStateHook closes expired connections as soon as they go idle
+func connStateHook(conn net.Conn, state http.ConnState) {
+	tc, ok := asTrackedConn(conn)
+	if !ok || state != http.StateIdle || !tc.tooOld() {
+		return
+	}
+	tc.expire()
+	conn.Close()
+}
+
+// connectionAgeMiddleware asks the server to close connections that have
+// outlived the limit once the current response has been written
+func connectionAgeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tc, ok := r.Context().Value(connCtxKey{}).(*trackedConn); ok && tc.tooOld() {
+			if tc.expire() {
+				slog.Debug("Closing connection after max age", "remote_addr", r.RemoteAddr)
+			}
+			w.Header().Set("Connection", "close")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ConfigureMaxConnectionAge sets the hard lifetime of client connections.
+// Connections older than the limit are closed after their current request.
+//
+//export ConfigureMaxConnectionAge
+func ConfigureMaxConnectionAge(seconds int) int {
+	if seconds < 0 {
+		return exportError(exportInvalid, "Invalid max connection age", "seconds", seconds)
+	}
+	maxConnectionAge.Store(int64(time.Duration(seconds) * time.Second))
+	slog.Info("Max connection age set", "seconds", seconds)
+	auditConfigChange("ConfigureMaxConnectionAge", map[string]string{"seconds": strconv.Itoa(seconds)})
+
+	return 0
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"C"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Cookie protection modes for SetCookie and GetCookie
+const (
+	CookiePlain     = "plain"
+	CookieSigned    = "signed"
+	CookieEncrypted = "encrypted"
+)
+
+// Prefixes of protected cookie values, so a value is only ever checked
+// against the mode it was written with
+const (
+	signedCookiePrefix    = "s1."
+	encryptedCookiePrefix = "e1."
+)
+
+var errNoCookieKeys = errors.New("no cookie keys configured; call ConfigureCookieKeys first")
+
+// cookieKey is one secret of the keyring, expanded into separate signing
+// and encryption keys
+type cookieKey struct {
+	mac  []byte
+	aead cipher.AEAD
+}
+
+// cookieKeyring holds the keys of ConfigureCookieKeys, newest first. New
+// cookies are protected with the first; values made with any of them are
+// accepted, so a key can be rotated in without logging everyone out and
+// retired once the cookies it made have expired.
+type cookieKeyring struct {
+	keys []cookieKey
+}
+
+var activeCookieKeys atomic.Pointer[cookieKeyring]
+
+func newCookieKey(secret string) (cookieKey, error) {
+	mac := sha256.Sum256([]byte("goserver-cookie-mac:" + secret))
+	enc := sha256.Sum256([]byte("goserver-cookie-enc:" + secret))
+	block, err := aes.NewCipher(enc[:])
+	if err != nil {
+		return cookieKey{}, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return cookieKey{}, err
+	}
+	return cookieKey{mac: mac[:], aead: aead}, nil
+}
+
+func (k cookieKey) sign(name, value string) string {
+	mac := hmac.New(sha256.New, k.mac)
+	mac.Write([]byte(name + "|" + value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// cookieOptions are the attributes SetCookie sends with a cookie
+type cookieOptions struct {
+	Mode     string `json:"mode"`    // plain, signed (default) or encrypted
+	MaxAge   int    `json:"max_age"` // seconds; 0 for a session cookie, negative deletes it
+	Path     string `json:"path"`    // defaults to /
+	Domain   string `json:"domain"`
+	Secure   *bool  `json:"secure"`    // defaults to true on TLS connections
+	HTTPOnly *bool  `json:"http_only"` // defaults to true
+	SameSite string `json:"same_site"` // lax (default), strict or none
+}
+
+// sealCookie protects value for the cookie name with the primary key. The
+// expiry, 0 for none, is sealed with it so a copy kept past Max-Age is
+// refused by openCookie.
+func sealCookie(mode, name, value string, expires int64) (string, error) {
+	if mode == CookiePlain {
+		return value, nil
+	}
+	ring := activeCookieKeys.Load()
+	if ring == nil {
+		return "", errNoCookieKeys
+	}
+	key := ring.keys[0]
+	payload := binary.BigEndian.AppendUint64(nil, uint64(expires))
+	payload = append(payload, value...)
+	switch mode {
+	case CookieSigned:
+		encoded := base64.RawURLEncoding.EncodeToString(payload)
+		return signedCookiePrefix + encoded + "." + key.sign(name, encoded), nil
+	case CookieEncrypted:
+		nonce := make([]byte, key.aead.NonceSize())
+		rand.Read(nonce)
+		return encryptedCookiePrefix + base64.RawURLEncoding.EncodeToString(key.aead.Seal(nonce, nonce, payload, []byte(name))), nil
+	}
+	return "", fmt.Errorf("unknown cookie mode %q", mode)
+}
+
+// openCookie checks a value written by sealCookie with the same mode
+// against every key of the keyring and returns what was sealed
+func openCookie(mode, name, raw string) (string, bool) {
+	if mode == CookiePlain {
+		return raw, true
+	}
+	ring := activeCookieKeys.Load()
+	if ring == nil {
+		return "", false
+	}
+	var payload []byte
+	switch mode {
+	case CookieSigned:
+		encoded, sig, ok := strings.Cut(strings.TrimPrefix(raw, signedCookiePrefix), ".")
+		if !ok || !strings.HasPrefix(raw, signedCookiePrefix) {
+			return "", false
+		}
+		for _, key := range ring.keys {
+			if hmac.Equal([]byte(sig), []byte(key.sign(name, encoded))) {
+				payload, _ = base64.RawURLEncoding.DecodeString(encoded)
+				break
+			}
+		}
+	case CookieEncrypted:
+		if !strings.HasPrefix(raw, encryptedCookiePrefix) {
+			return "", false
+		}
+		sealed, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(raw, encryptedCookiePrefix))
+		if err != nil {
+			return "", false
+		}
+		for _, key := range ring.keys {
+			size := key.aead.NonceSize()
+			if len(sealed) < size {
+				break
+			}
+			if opened, err := key.aead.Open(nil, sealed[:size], sealed[size:], []byte(name)); err == nil {
+				payload = opened
+				break
+			}
+		}
+	}
+	if len(payload) < 8 {
+		return "", false
+	}
+	if expires := int64(binary.BigEndian.Uint64(payload)); expires != 0 && time.Now().Unix() > expires {
+		return "", false
+	}
+	return string(payload[8:]), true
+}
+
+// ConfigureCookieKeys sets the keyring for signed and encrypted cookies
+// from a JSON array of secrets, newest first. Rotating a key means
+// prepending the new secret and dropping the oldest once the cookies it
+// protected have expired. An empty array removes the keyring. Returns 0,
+// or a negative status if the list is invalid.
+//
+//export ConfigureCookieKeys
+func ConfigureCookieKeys(cKeys *C.char) int {
+	if cKeys == nil {
+		return exportError(exportInvalid, "cKeys is nil in ConfigureCookieKeys")
+	}
+	var secrets []string
+	if err := json.Unmarshal([]byte(C.GoString(cKeys)), &secrets); err != nil {
+		return exportError(exportInvalid, "Invalid cookie keys", "error", err)
+	}
+	if len(secrets) == 0 {
+		activeCookieKeys.Store(nil)
+		slog.Info("Cookie keys removed")
+		auditConfigChange("ConfigureCookieKeys", map[string]string{"keys": "0"})
+		return 0
+	}
+	ring := &cookieKeyring{keys: make([]cookieKey, 0, len(secrets))}
+	for i, secret := range secrets {
+		if len(secret) < 16 {
+			return exportError(exportInvalid, "Cookie keys must be at least 16 bytes", "index", i)
+		}
+		key, err := newCookieKey(secret)
+		if err != nil {
+			return exportError(exportFailed, "Error preparing cookie key", "index", i, "error", err)
+		}
+		ring.keys = append(ring.keys, key)
+	}
+	activeCookieKeys.Store(ring)
+
+	slog.Info("Cookie keys configured", "keys", len(secrets))
+	auditConfigChange("ConfigureCookieKeys", map[string]string{"keys": strconv.Itoa(len(secrets))})
+	return 0
+}
+
+// SetCookie adds a Set-Cookie header for cName to the response of the
+// request cRequestID, while its handler callback runs. cOptions is a JSON
+// object of cookieOptions, or empty for a signed cookie on / with the
+// defaults; signed and encrypted cookies need ConfigureCookieKeys. Returns
+// 0, or a negative status if the options are invalid or the handler is not
+// running.
+//
+//export SetCookie
+func SetCookie(cRequestID *C.char, cName *C.char, cValue *C.char, cOptions *C.char) int {
+	if cRequestID == nil || cName == nil || cValue == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in SetCookie")
+	}
+	requestID := C.GoString(cRequestID)
+	name := C.GoString(cName)
+	value := C.GoString(cValue)
+	opts := cookieOptions{Mode: CookieSigned, Path: "/", SameSite: "lax"}
+	if cOptions != nil {
+		if raw := C.GoString(cOptions); raw != "" {
+			if err := json.Unmarshal([]byte(raw), &opts); err != nil {
+				return exportError(exportInvalid, "Invalid cookie options", "name", name, "error", err)
+			}
+		}
+	}
+	if !validHeaderName(name) {
+		return exportError(exportInvalid, "Invalid cookie name", "name", name)
+	}
+	opts.Mode = strings.ToLower(opts.Mode)
+	if opts.Mode != CookiePlain && opts.Mode != CookieSigned && opts.Mode != CookieEncrypted {
+		return exportError(exportInvalid, "Unknown cookie mode", "name", name, "mode", opts.Mode)
+	}
+
+	var expires int64
+	if opts.MaxAge > 0 {
+		expires = time.Now().Add(time.Duration(opts.MaxAge) * time.Second).Unix()
+	}
+	sealed := ""
+	if opts.MaxAge >= 0 {
+		var err error
+		if sealed, err = sealCookie(opts.Mode, name, value, expires); err != nil {
+			return exportError(exportInvalid, "Cannot protect cookie", "name", name, "error", err)
+		}
+	}
+	if len(sealed) > maxSessionCookie {
+		return exportError(exportInvalid, "Cookie too large", "name", name, "bytes", len(sealed))
+	}
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    sealed,
+		Path:     opts.Path,
+		Domain:   opts.Domain,
+		MaxAge:   opts.MaxAge,
+		HttpOnly: opts.HTTPOnly == nil || *opts.HTTPOnly,
+	}
+	switch strings.ToLower(opts.SameSite) {
+	case "lax", "":
+		cookie.SameSite = http.SameSiteLaxMode
+	case "strict":
+		cookie.SameSite = http.SameSiteStrictMode
+	case "none":
+		cookie.SameSite = http.SameSiteNoneMode
+		cookie.Secure = true // browsers reject SameSite=None without Secure
+	default:
+		return exportError(exportInvalid, "Unknown cookie same_site", "name", name, "same_site", opts.SameSite)
+	}
+	if err := cookie.Valid(); err != nil {
+		return exportError(exportInvalid, "Invalid cookie", "name", name, "error", err)
+	}
+
+	h, ok := handlerHeadersFor(requestID)
+	if !ok {
+		return exportError(exportNotFound, "Cannot set cookie, handler not running", "request_id", requestID, "name", name)
+	}
+	h.mu.Lock()
+	if opts.Secure != nil {
+		cookie.Secure = cookie.Secure || *opts.Secure
+	} else if h.tls {
+		cookie.Secure = true
+	}
+	h.response.Add("Set-Cookie", cookie.String())
+	h.mu.Unlock()
+	return 0
+}
+
+// GetCookie returns the value of the request cookie cName for the request
+// cRequestID, verified and decrypted according to cMode (plain, signed or
+// encrypted, empty meaning signed), or NULL if the cookie is absent, fails
+// verification, has expired, or the handler callback is not running. The
+// caller must release the result with FreeString.
+//
+//export GetCookie
+func GetCookie(cRequestID *C.char, cName *C.char, cMode *C.char) *C.char {
+	if cRequestID == nil || cName == nil {
+		recordError("One or more parameters are nil in GetCookie")
+		return nil
+	}
+	mode := CookieSigned
+	if cMode != nil {
+		if m := strings.ToLower(C.GoString(cMode)); m != "" {
+			mode = m
+		}
+	}
+	name := C.GoString(cName)
+	h, ok := handlerHeadersFor(C.GoString(cRequestID))
+	if !ok {
+		return nil
+	}
+	h.mu.Lock()
+	cookie, err := (&http.Request{Header: h.request}).Cookie(name)
+	h.mu.Unlock()
+	if err != nil {
+		return nil
+	}
+	value, ok := openCookie(mode, name, cookie.Value)
+	if !ok {
+		setLastError("Invalid cookie", "name", name, "mode", mode)
+		slog.Debug("Invalid cookie", "name", name, "mode", mode)
+		return nil
+	}
+	return C.CString(value)
+}
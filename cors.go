@@ -0,0 +1,177 @@
+package main
+
+import (
+	"C"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// CORSOptions configures cross-origin access, globally through the "cors"
+// middleware options or per route through SetRouteCORS
+type CORSOptions struct {
+	AllowedOrigins   []string `json:"allowed_origins"` // "*" allows any origin
+	AllowedMethods   []string `json:"allowed_methods"` // defaults to the route's registered methods
+	AllowedHeaders   []string `json:"allowed_headers"` // defaults to echoing the preflight request
+	ExposedHeaders   []string `json:"exposed_headers"`
+	AllowCredentials bool     `json:"allow_credentials"`
+	MaxAge           int      `json:"max_age"` // preflight cache lifetime in seconds
+}
+
+// parseCORSOptions decodes options JSON; nil or empty input allows any origin
+func parseCORSOptions(options []byte) (*CORSOptions, error) {
+	opts := &CORSOptions{}
+	if len(options) > 0 {
+		if err := json.Unmarshal(options, opts); err != nil {
+			return nil, err
+		}
+	}
+	if len(opts.AllowedOrigins) == 0 {
+		opts.AllowedOrigins = []string{"*"}
+	}
+	if opts.MaxAge < 0 {
+		return nil, fmt.Errorf("max_age must not be negative")
+	}
+	for i, method := range opts.AllowedMethods {
+		opts.AllowedMethods[i] = strings.ToUpper(method)
+	}
+	return opts, nil
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin value for origin, or
+// "" if the origin is not allowed. Credentialed responses may not use "*".
+func (opts *CORSOptions) allowOrigin(origin string) string {
+	for _, allowed := range opts.AllowedOrigins {
+		if allowed == "*" {
+			if opts.AllowCredentials {
+				return origin
+			}
+			return "*"
+		}
+		if strings.EqualFold(allowed, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// newCORSMiddleware builds the "cors" middleware. Routes configured with
+// SetRouteCORS use their own options instead of the middleware's.
+func newCORSMiddleware(options []byte) (func(http.Handler) http.Handler, error) {
+	defaults, err := parseCORSOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			requestMethod := r.Header.Get("Access-Control-Request-Method")
+			preflight := r.Method == http.MethodOptions && requestMethod != ""
+			method := r.Method
+			if preflight {
+				method = strings.ToUpper(requestMethod)
+			}
+
+			routesMu.RLock()
+			route, _, allowed, found := findRoute(r.Host, r.URL.Path, method)
+			routesMu.RUnlock()
+			opts := defaults
+			if found && route.CORS != nil {
+				opts = route.CORS
+			}
+
+			h := w.Header()
+			h.Add("Vary", "Origin")
+			allowOrigin := opts.allowOrigin(origin)
+
+			if !preflight {
+				if allowOrigin != "" {
+					h.Set("Access-Control-Allow-Origin", allowOrigin)
+					if opts.AllowCredentials {
+						h.Set("Access-Control-Allow-Credentials", "true")
+					}
+					if len(opts.ExposedHeaders) > 0 {
+						h.Set("Access-Control-Expose-Headers", strings.Join(opts.ExposedHeaders, ", "))
+					}
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Preflight for a path that is not registered falls through to 404
+			if !found && len(allowed) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+			methods := opts.AllowedMethods
+			if len(methods) == 0 {
+				if found {
+					methods = []string{method}
+				} else {
+					methods = allowed
+				}
+			}
+			if allowOrigin == "" || !containsFold(methods, method) {
+				slog.Debug("CORS preflight rejected", "origin", origin, "method", method, "path", r.URL.Path)
+				http.Error(w, `{"error": "CORS preflight rejected"}`, http.StatusForbidden)
+				return
+			}
+			h.Set("Access-Control-Allow-Origin", allowOrigin)
+			h.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+			if len(opts.AllowedHeaders) > 0 {
+				h.Set("Access-Control-Allow-Headers", strings.Join(opts.AllowedHeaders, ", "))
+			} else if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+				h.Set("Access-Control-Allow-Headers", requested)
+			}
+			if opts.AllowCredentials {
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+			if opts.MaxAge > 0 {
+				h.Set("Access-Control-Max-Age", strconv.Itoa(opts.MaxAge))
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}, nil
+}
+
+// SetRouteCORS overrides the "cors" middleware options for one route. The
+// middleware must be registered for the options to take effect.
+//
+//export SetRouteCORS
+func SetRouteCORS(cPath *C.char, cMethod *C.char, cOptions *C.char) int {
+	if cPath == nil || cMethod == nil || cOptions == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in SetRouteCORS")
+	}
+	path := C.GoString(cPath)
+	method := strings.ToUpper(C.GoString(cMethod))
+	options := C.GoString(cOptions)
+	opts, err := parseCORSOptions([]byte(options))
+	if err != nil {
+		return exportError(exportInvalid, "Invalid CORS options", "path", path, "method", method, "error", err)
+	}
+
+	routesMu.Lock()
+	key := path + method
+	route, exists := routes[key]
+	if !exists {
+		routesMu.Unlock()
+		return exportError(exportNotFound, "Cannot set CORS options, route not found", "key", key)
+	}
+	route.CORS = opts
+	routes[key] = route
+	routesMu.Unlock()
+
+	slog.Info("Route CORS options set", "key", key, "origins", strings.Join(opts.AllowedOrigins, ","))
+	auditConfigChange("SetRouteCORS", map[string]string{"path": path, "method": method, "options": redactJSON(options)})
+
+	return 0
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"C"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	defaultQueryTimeout = 30 * time.Second
+	defaultMaxRows      = 10000
+)
+
+// DatabaseOptions configures a database's connection pool. Zero values
+// keep database/sql's defaults.
+type DatabaseOptions struct {
+	MaxOpenConns      int   `json:"max_open_conns"`
+	MaxIdleConns      int   `json:"max_idle_conns"`
+	ConnMaxLifetimeMs int   `json:"conn_max_lifetime_ms"`
+	ConnMaxIdleTimeMs int   `json:"conn_max_idle_time_ms"`
+	QueryTimeoutMs    int   `json:"query_timeout_ms"` // per query or exec; defaults to 30000
+	MaxRows           int   `json:"max_rows"`         // rows returned by a query; defaults to 10000
+	HealthCheck       *bool `json:"health_check"`     // ping in /readyz; on by default
+}
+
+// database is a registered connection pool
+type database struct {
+	name   string
+	driver string
+	db     *sql.DB
+	opts   DatabaseOptions
+}
+
+// QueryResult is the reply of QueryDatabase
+type QueryResult struct {
+	Columns   []string        `json:"columns"`
+	Rows      [][]interface{} `json:"rows"`
+	Truncated bool            `json:"truncated,omitempty"`
+}
+
+// ExecResult is the reply of ExecDatabase
+type ExecResult struct {
+	RowsAffected int64 `json:"rows_affected"`
+	LastInsertID int64 `json:"last_insert_id"`
+}
+
+var (
+	databases   = make(map[string]*database)
+	databasesMu sync.RWMutex
+)
+
+func databaseFor(name string) (*database, bool) {
+	databasesMu.RLock()
+	defer databasesMu.RUnlock()
+	d, ok := databases[name]
+	return d, ok
+}
+
+func (d *database) timeout() time.Duration {
+	if d.opts.QueryTimeoutMs > 0 {
+		return time.Duration(d.opts.QueryTimeoutMs) * time.Millisecond
+	}
+	return defaultQueryTimeout
+}
+
+// info describes the pool to handlers resolving the database as a
+// dependency; queries go through QueryDatabase and ExecDatabase
+func (d *database) info() map[string]interface{} {
+	stats := d.db.Stats()
+	return map[string]interface{}{
+		"name":             d.name,
+		"driver":           d.driver,
+		"open_connections": stats.OpenConnections,
+		"in_use":           stats.InUse,
+	}
+}
+
+// databaseCheckName is the /readyz check of a database
+func databaseCheckName(name string) string {
+	return "database:" + name
+}
+
+// pingDatabase is the readiness check of a registered database
+func pingDatabase(name string, timeout time.Duration) CheckResult {
+	d, ok := databaseFor(name)
+	if !ok {
+		return CheckResult{Status: "fail", Message: "database not registered"}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := d.db.PingContext(ctx); err != nil {
+		return CheckResult{Status: "fail", Message: err.Error()}
+	}
+	stats := d.db.Stats()
+	return CheckResult{Status: "ok", Message: fmt.Sprintf("%d open, %d in use", stats.OpenConnections, stats.InUse)}
+}
+
+// databaseArgs decodes a JSON array of query parameters. Integers become
+// int64, other numbers float64, and objects and arrays their JSON text.
+func databaseArgs(raw string) ([]interface{}, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader([]byte(raw)))
+	dec.UseNumber()
+	var values []interface{}
+	if err := dec.Decode(&values); err != nil {
+		return nil, fmt.Errorf("parameters must be a JSON array: %w", err)
+	}
+	args := make([]interface{}, len(values))
+	for i, v := range values {
+		switch v := v.(type) {
+		case json.Number:
+			if n, err := v.Int64(); err == nil {
+				args[i] = n
+			} else if f, err := v.Float64(); err == nil {
+				args[i] = f
+			} else {
+				return nil, fmt.Errorf("parameter %d: %w", i+1, err)
+			}
+		case map[string]interface{}, []interface{}:
+			data, _ := json.Marshal(v)
+			args[i] = string(data)
+		default:
+			args[i] = v
+		}
+	}
+	return args, nil
+}
+
+// databaseValue converts a scanned column value to JSON. Text columns
+// some drivers scan as bytes come back as strings; other binary values
+// are base64.
+func databaseValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case []byte:
+		if utf8.Valid(v) {
+			return string(v)
+		}
+		return base64.StdEncoding.EncodeToString(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	}
+	return v
+}
+
+// query runs a statement returning rows
+func (d *database) query(statement string, args []interface{}) (QueryResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout())
+	defer cancel()
+	rows, err := d.db.QueryContext(ctx, statement, args...)
+	if err != nil {
+		return QueryResult{}, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return QueryResult{}, err
+	}
+	maxRows := d.opts.MaxRows
+	if maxRows <= 0 {
+		maxRows = defaultMaxRows
+	}
+	result := QueryResult{Columns: columns, Rows: make([][]interface{}, 0)}
+	for rows.Next() {
+		if len(result.Rows) == maxRows {
+			result.Truncated = true
+			break
+		}
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return QueryResult{}, err
+		}
+		for i, v := range values {
+			values[i] = databaseValue(v)
+		}
+		result.Rows = append(result.Rows, values)
+	}
+	return result, rows.Err()
+}
+
+// exec runs a statement that returns no rows
+func (d *database) exec(statement string, args []interface{}) (ExecResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout())
+	defer cancel()
+	res, err := d.db.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return ExecResult{}, err
+	}
+	var result ExecResult
+	// Drivers that cannot report these leave them at 0
+	result.RowsAffected, _ = res.RowsAffected()
+	result.LastInsertID, _ = res.LastInsertId()
+	return result, nil
+}
+
+// writeDatabaseMetrics emits connection pool gauges and counters by database
+func writeDatabaseMetrics(p promWriter) {
+	databasesMu.RLock()
+	names := make([]string, 0, len(databases))
+	stats := make(map[string]sql.DBStats, len(databases))
+	for name, d := range databases {
+		names = append(names, name)
+		stats[name] = d.db.Stats()
+	}
+	databasesMu.RUnlock()
+	sort.Strings(names)
+
+	series := []struct {
+		name, kind, help string
+		value            func(s sql.DBStats) interface{}
+	}{
+		{"goserver_db_max_open_connections", "gauge", "Connection pool size limit; 0 is unlimited.", func(s sql.DBStats) interface{} { return s.MaxOpenConnections }},
+		{"goserver_db_open_connections", "gauge", "Open database connections.", func(s sql.DBStats) interface{} { return s.OpenConnections }},
+		{"goserver_db_in_use_connections", "gauge", "Database connections in use.", func(s sql.DBStats) interface{} { return s.InUse }},
+		{"goserver_db_idle_connections", "gauge", "Idle database connections.", func(s sql.DBStats) interface{} { return s.Idle }},
+		{"goserver_db_wait_count_total", "counter", "Connections waited for because the pool was full.", func(s sql.DBStats) interface{} { return s.WaitCount }},
+		{"goserver_db_wait_duration_seconds_total", "counter", "Time spent waiting for a free connection.", func(s sql.DBStats) interface{} { return formatFloat(s.WaitDuration.Seconds()) }},
+		{"goserver_db_max_idle_closed_total", "counter", "Connections closed for exceeding the idle limit.", func(s sql.DBStats) interface{} { return s.MaxIdleClosed }},
+		{"goserver_db_max_idle_time_closed_total", "counter", "Connections closed for exceeding the idle time.", func(s sql.DBStats) interface{} { return s.MaxIdleTimeClosed }},
+		{"goserver_db_max_lifetime_closed_total", "counter", "Connections closed for exceeding their lifetime.", func(s sql.DBStats) interface{} { return s.MaxLifetimeClosed }},
+	}
+	for _, m := range series {
+		p.header(m.name, m.kind, m.help)
+		for _, name := range names {
+			p.sample(m.name, `database="`+escapeLabel(name)+`"`, m.value(stats[name]))
+		}
+	}
+}
+
+// RegisterDatabase opens a managed connection pool named cName with a
+// database/sql driver ("sqlite" is built in) and DSN. cOptions is a JSON
+// DatabaseOptions object. The pool is pinged in /readyz, exported in
+// /metrics, resolvable as a "database" dependency, and used by
+// QueryDatabase and ExecDatabase. Re-registering a name closes the old pool.
+//
+//export RegisterDatabase
+func RegisterDatabase(cName *C.char, cDriver *C.char, cDSN *C.char, cOptions *C.char) int {
+	if cName == nil || cDriver == nil || cDSN == nil || cOptions == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in RegisterDatabase")
+	}
+	name := C.GoString(cName)
+	driver := C.GoString(cDriver)
+	options := C.GoString(cOptions)
+	if name == "" || reservedCheckNames[databaseCheckName(name)] {
+		return exportError(exportInvalid, "Invalid database name", "name", name)
+	}
+	var opts DatabaseOptions
+	if options != "" {
+		if err := json.Unmarshal([]byte(options), &opts); err != nil {
+			return exportError(exportInvalid, "Invalid database options", "name", name, "error", err)
+		}
+	}
+	db, err := sql.Open(driver, C.GoString(cDSN))
+	if err != nil {
+		return exportError(exportFailed, "Cannot open database", "name", name, "driver", driver, "error", err)
+	}
+	db.SetMaxOpenConns(opts.MaxOpenConns)
+	if opts.MaxIdleConns != 0 {
+		db.SetMaxIdleConns(opts.MaxIdleConns)
+	}
+	db.SetConnMaxLifetime(time.Duration(opts.ConnMaxLifetimeMs) * time.Millisecond)
+	db.SetConnMaxIdleTime(time.Duration(opts.ConnMaxIdleTimeMs) * time.Millisecond)
+
+	databasesMu.Lock()
+	previous := databases[name]
+	databases[name] = &database{name: name, driver: driver, db: db, opts: opts}
+	databasesMu.Unlock()
+	if previous != nil {
+		previous.db.Close()
+	}
+
+	checkName := databaseCheckName(name)
+	if opts.HealthCheck == nil || *opts.HealthCheck {
+		addHealthCheck(healthCheck{name: checkName, probe: func(timeout time.Duration) CheckResult {
+			return pingDatabase(name, timeout)
+		}}, "database")
+	} else {
+		removeHealthCheck(checkName)
+	}
+	if result := pingDatabase(name, defaultHealthCheckTimeout); result.Status != "ok" {
+		slog.Warn("Database is not reachable yet", "name", name, "error", result.Message)
+	}
+
+	slog.Info("Database registered", "name", name, "driver", driver, "max_open_conns", opts.MaxOpenConns)
+	auditConfigChange("RegisterDatabase", map[string]string{"name": name, "driver": driver, "options": options})
+
+	return 0
+}
+
+// QueryDatabase runs a parameterized query on a registered database. cArgs
+// is a JSON array bound to the statement's placeholders. Returns a
+// QueryResult, or {"error": ...}. The caller must release the result with
+// FreeString.
+//
+//export QueryDatabase
+func QueryDatabase(cName *C.char, cQuery *C.char, cArgs *C.char) *C.char {
+	return databaseCall("QueryDatabase", cName, cQuery, cArgs, func(d *database, statement string, args []interface{}) (interface{}, error) {
+		return d.query(statement, args)
+	})
+}
+
+// ExecDatabase runs a parameterized statement that returns no rows, such
+// as INSERT or UPDATE. Returns an ExecResult, or {"error": ...}. The caller
+// must release the result with FreeString.
+//
+//export ExecDatabase
+func ExecDatabase(cName *C.char, cQuery *C.char, cArgs *C.char) *C.char {
+	return databaseCall("ExecDatabase", cName, cQuery, cArgs, func(d *database, statement string, args []interface{}) (interface{}, error) {
+		return d.exec(statement, args)
+	})
+}
+
+// databaseCall decodes the parameters shared by the query exports and
+// encodes the reply
+func databaseCall(export string, cName, cQuery, cArgs *C.char, run func(d *database, statement string, args []interface{}) (interface{}, error)) *C.char {
+	if cName == nil || cQuery == nil || cArgs == nil {
+		recordError("One or more parameters are nil in " + export)
+		return C.CString(`{"error": "missing parameters"}`)
+	}
+	name := C.GoString(cName)
+	d, ok := databaseFor(name)
+	if !ok {
+		data, _ := json.Marshal(ErrorResponse{Error: "database " + strconv.Quote(name) + " not registered"})
+		return C.CString(string(data))
+	}
+	args, err := databaseArgs(C.GoString(cArgs))
+	if err == nil {
+		var result interface{}
+		if result, err = run(d, C.GoString(cQuery), args); err == nil {
+			data, err := json.Marshal(result)
+			if err != nil {
+				return C.CString(`{"error": "result is not serializable"}`)
+			}
+			return C.CString(string(data))
+		}
+	}
+	slog.Debug("Database call failed", "export", export, "database", name, "error", err)
+	data, _ := json.Marshal(ErrorResponse{Error: err.Error()})
+	return C.CString(string(data))
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"C"
+	"crypto/subtle"
+	"encoding/json"
+	"expvar"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// debugSettings guards the /debug endpoints
+type debugSettings struct {
+	enabled bool
+	token   string // required as a bearer token when set
+}
+
+var debugConfig atomic.Pointer[debugSettings]
+
+func init() {
+	debugConfig.Store(&debugSettings{})
+	expvar.Publish("goserver", expvar.Func(func() any { return statsSnapshot() }))
+}
+
+// debugRoute is one entry of the /debug/routes dump
+type debugRoute struct {
+	Method    string   `json:"method"`
+	Path      string   `json:"path"`
+	Host      string   `json:"host,omitempty"`
+	Kind      string   `json:"kind"`
+	Group     int      `json:"group,omitempty"`
+	Version   int      `json:"version,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+	Cached    bool     `json:"cached,omitempty"`
+	TimeoutMs int64    `json:"timeout_ms,omitempty"`
+	BodyLimit int64    `json:"max_body_size,omitempty"`
+	Validated bool     `json:"validated,omitempty"`
+	Template  string   `json:"template,omitempty"`
+	Task      string   `json:"task,omitempty"`
+	Formats   []string `json:"formats,omitempty"`
+	Overrides []string `json:"overrides,omitempty"` // middleware settings the route overrides
+}
+
+// routeKind names how a route is served
+func routeKind(route RouteInfo) string {
+	switch {
+	case route.WebSocket != 0:
+		return "websocket"
+	case route.SSE != 0:
+		return "sse"
+	case route.Template != "":
+		return "template"
+	case route.Handler != 0:
+		return "handler"
+	case route.Gateway != nil:
+		return "grpc-gateway"
+	case route.Redirect != nil:
+		return "redirect"
+	}
+	return "static"
+}
+
+// routeOverrides lists the middleware settings a route overrides
+func routeOverrides(route RouteInfo) []string {
+	var overrides []string
+	if route.CORS != nil {
+		overrides = append(overrides, "cors")
+	}
+	if route.SecureHeaders != nil {
+		overrides = append(overrides, "secureheaders")
+	}
+	if route.RateLimit != nil {
+		overrides = append(overrides, "ratelimit")
+	}
+	if len(route.RequiredScopes) > 0 {
+		overrides = append(overrides, "apikey")
+	}
+	if route.Compression != nil {
+		overrides = append(overrides, "compression")
+	}
+	if route.Timeout != 0 {
+		overrides = append(overrides, "timeout")
+	}
+	if route.Concurrency != 0 {
+		overrides = append(overrides, "concurrency")
+	}
+	return overrides
+}
+
+// routingTable snapshots the routes, mounts, and middleware chain
+func routingTable() map[string]interface{} {
+	routesMu.RLock()
+	list := make([]debugRoute, 0, len(routes))
+	for _, route := range routes {
+		list = append(list, debugRoute{
+			Method:    route.Method,
+			Path:      route.Path,
+			Host:      route.Host,
+			Kind:      routeKind(route),
+			Group:     route.Group,
+			Version:   route.Version,
+			Tags:      route.Tags,
+			Cached:    route.Cache != nil,
+			TimeoutMs: route.Timeout.Milliseconds(),
+			BodyLimit: route.MaxBodySize,
+			Validated: route.BodySchema != nil,
+			Template:  route.Template,
+			Task:      route.Task,
+			Formats:   route.Formats,
+			Overrides: routeOverrides(route),
+		})
+	}
+	routesMu.RUnlock()
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Host != list[j].Host {
+			return list[i].Host < list[j].Host
+		}
+		if list[i].Path != list[j].Path {
+			return list[i].Path < list[j].Path
+		}
+		return list[i].Method < list[j].Method
+	})
+
+	proxyRoutesMu.RLock()
+	proxies := make([]map[string]string, 0, len(proxyRoutes))
+	for _, p := range proxyRoutes {
+		proxy := map[string]string{"prefix": p.prefix, "upstream": p.upstream.String()}
+		if p.breaker != nil {
+			proxy["circuit"] = p.breaker.status().State
+		}
+		proxies = append(proxies, proxy)
+	}
+	proxyRoutesMu.RUnlock()
+
+	staticDirsMu.RLock()
+	statics := make([]map[string]string, 0, len(staticDirs))
+	for _, d := range staticDirs {
+		statics = append(statics, map[string]string{"prefix": d.prefix, "root": d.root})
+	}
+	staticDirsMu.RUnlock()
+
+	middlewaresMu.RLock()
+	chain := make([]map[string]interface{}, 0, len(middlewares))
+	for _, m := range middlewares {
+		chain = append(chain, map[string]interface{}{"name": m.name, "enabled": m.enabled})
+	}
+	middlewaresMu.RUnlock()
+
+	return map[string]interface{}{
+		"routes":     list,
+		"proxies":    proxies,
+		"static":     statics,
+		"middleware": chain,
+		"breakers":   breakerStatuses(),
+	}
+}
+
+// serveDebugRoutes dumps the current routing table as JSON
+func serveDebugRoutes(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(routingTable()); err != nil {
+		slog.Error("Error encoding routing table", "error", err)
+	}
+}
+
+// debugHandler serves /debug/pprof, /debug/vars, and /debug/routes while
+// the debug endpoints are enabled, and hands /debug paths to the routes
+// otherwise
+func debugHandler(fallback http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/debug/routes", serveDebugRoutes)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := debugConfig.Load()
+		if _, pattern := mux.Handler(r); !cfg.enabled || pattern == "" {
+			fallback.ServeHTTP(w, r)
+			return
+		}
+		if cfg.token != "" {
+			token := r.Header.Get("X-Debug-Token")
+			if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+				token = strings.TrimPrefix(auth, "Bearer ")
+			}
+			if subtle.ConstantTimeCompare([]byte(token), []byte(cfg.token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="debug"`)
+				http.Error(w, `{"error": "Invalid debug token"}`, http.StatusUnauthorized)
+				return
+			}
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// EnableDebugEndpoints mounts /debug/pprof (Go profiling), /debug/vars
+// (expvar, including the server counters), and /debug/routes (the current
+// routing table). A non-empty cToken must be sent as a bearer token or in
+// X-Debug-Token. While disabled, /debug paths are served by the routes as
+// usual. These endpoints expose internals; keep them off or behind a token
+// in production.
+//
+//export EnableDebugEndpoints
+func EnableDebugEndpoints(enabled int, cToken *C.char) int {
+	if cToken == nil {
+		return exportError(exportInvalid, "cToken is nil in EnableDebugEndpoints")
+	}
+	token := C.GoString(cToken)
+	on := enabled != 0
+	debugConfig.Store(&debugSettings{enabled: on, token: token})
+
+	tokenValue := ""
+	if token != "" {
+		tokenValue = redactedValue
+	}
+	slog.Info("Debug endpoints toggled", "enabled", on, "token", token != "")
+	auditConfigChange("EnableDebugEndpoints", map[string]string{"enabled": strconv.FormatBool(on), "token": tokenValue})
+
+	return 0
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"C"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RouteDeprecation marks a route deprecated. Dates are RFC 3339 timestamps
+// or YYYY-MM-DD days in UTC.
+type RouteDeprecation struct {
+	Since  string `json:"since"`  // when the route became deprecated; defaults to when it was marked
+	Sunset string `json:"sunset"` // when the route is expected to stop working; optional
+	Link   string `json:"link"`   // page describing the deprecation and the replacement; optional
+}
+
+// routeDeprecation is a RouteDeprecation with its response headers
+// prepared, so serving a deprecated route only copies strings
+type routeDeprecation struct {
+	since      time.Time
+	sunset     time.Time // zero when there is none
+	link       string
+	deprecated string // Deprecation header, RFC 9745
+	sunsetAt   string // Sunset header, RFC 8594
+	linkHeader string
+}
+
+// parseDeprecationDate accepts an RFC 3339 timestamp or a YYYY-MM-DD day
+func parseDeprecationDate(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+	return time.Parse(time.DateOnly, value)
+}
+
+// prepare validates the options and builds the route's headers
+func (opts RouteDeprecation) prepare() (*routeDeprecation, error) {
+	d := &routeDeprecation{since: time.Now().UTC().Truncate(time.Second), link: opts.Link}
+	if opts.Since != "" {
+		since, err := parseDeprecationDate(opts.Since)
+		if err != nil {
+			return nil, fmt.Errorf("invalid since date %q", opts.Since)
+		}
+		d.since = since
+	}
+	if opts.Sunset != "" {
+		sunset, err := parseDeprecationDate(opts.Sunset)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sunset date %q", opts.Sunset)
+		}
+		if sunset.Before(d.since) {
+			return nil, fmt.Errorf("sunset must not be before since")
+		}
+		d.sunset = sunset
+		d.sunsetAt = sunset.Format(http.TimeFormat)
+	}
+	if strings.ContainsAny(opts.Link, "<>\r\n\x00 ") {
+		return nil, fmt.Errorf("invalid link %q", opts.Link)
+	}
+	d.deprecated = "@" + strconv.FormatInt(d.since.Unix(), 10)
+	if opts.Link != "" {
+		d.linkHeader = "<" + opts.Link + `>; rel="deprecation"`
+		if !d.sunset.IsZero() {
+			d.linkHeader += ", <" + opts.Link + `>; rel="sunset"`
+		}
+	}
+	return d, nil
+}
+
+// deprecatedCalls counts requests to deprecated routes, by route key
+type deprecatedCalls struct {
+	route  string
+	method string
+	count  atomic.Int64
+}
+
+var (
+	deprecatedCallsByKey = make(map[string]*deprecatedCalls)
+	deprecatedCallsMu    sync.Mutex
+)
+
+// serveDeprecation adds the deprecation headers of a matched route to the
+// response and counts the call
+func serveDeprecation(w http.ResponseWriter, route RouteInfo) {
+	d := route.Deprecation
+	h := w.Header()
+	h.Set("Deprecation", d.deprecated)
+	if d.sunsetAt != "" {
+		h.Set("Sunset", d.sunsetAt)
+	}
+	if d.linkHeader != "" {
+		h.Add("Link", d.linkHeader)
+	}
+
+	key := route.Host + route.Path + route.Method
+	deprecatedCallsMu.Lock()
+	calls, ok := deprecatedCallsByKey[key]
+	if !ok {
+		calls = &deprecatedCalls{route: route.Host + route.Path, method: route.Method}
+		deprecatedCallsByKey[key] = calls
+	}
+	deprecatedCallsMu.Unlock()
+	calls.count.Add(1)
+}
+
+// deprecationOperation adds the deprecation of a route to its OpenAPI
+// operation
+func deprecationOperation(operation map[string]interface{}, d *routeDeprecation) {
+	operation["deprecated"] = true
+	if !d.sunset.IsZero() {
+		operation["x-sunset"] = d.sunset.Format(time.RFC3339)
+	}
+	if d.link != "" {
+		operation["externalDocs"] = map[string]string{"description": "Deprecation notice", "url": d.link}
+	}
+}
+
+// writeDeprecationMetrics emits the calls made to deprecated routes
+func writeDeprecationMetrics(p promWriter) {
+	deprecatedCallsMu.Lock()
+	list := make([]*deprecatedCalls, 0, len(deprecatedCallsByKey))
+	for _, calls := range deprecatedCallsByKey {
+		list = append(list, calls)
+	}
+	deprecatedCallsMu.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].route != list[j].route {
+			return list[i].route < list[j].route
+		}
+		return list[i].method < list[j].method
+	})
+	p.header("goserver_deprecated_requests_total", "counter", "Requests served by routes marked deprecated.")
+	for _, calls := range list {
+		p.sample("goserver_deprecated_requests_total", fmt.Sprintf(`route="%s",method="%s"`, escapeLabel(calls.route), escapeLabel(calls.method)), calls.count.Load())
+	}
+}
+
+// SetRouteDeprecation marks a route deprecated. cOptions is a JSON
+// RouteDeprecation object and may be empty; "null" clears the mark.
+// Responses then carry Deprecation and, when set, Sunset and Link headers,
+// the OpenAPI operation is flagged deprecated, and calls are counted in
+// goserver_deprecated_requests_total. The route keeps serving after its
+// sunset date.
+//
+//export SetRouteDeprecation
+func SetRouteDeprecation(cPath *C.char, cMethod *C.char, cOptions *C.char) int {
+	if cPath == nil || cMethod == nil || cOptions == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in SetRouteDeprecation")
+	}
+	path := C.GoString(cPath)
+	method := strings.ToUpper(C.GoString(cMethod))
+	options := strings.TrimSpace(C.GoString(cOptions))
+	var d *routeDeprecation
+	if options != "null" {
+		var opts RouteDeprecation
+		if options != "" {
+			if err := json.Unmarshal([]byte(options), &opts); err != nil {
+				return exportError(exportInvalid, "Invalid route deprecation", "path", path, "method", method, "error", err)
+			}
+		}
+		var err error
+		if d, err = opts.prepare(); err != nil {
+			return exportError(exportInvalid, "Invalid route deprecation", "path", path, "method", method, "error", err)
+		}
+	}
+
+	routesMu.Lock()
+	key := path + method
+	route, exists := routes[key]
+	if !exists {
+		routesMu.Unlock()
+		return exportError(exportNotFound, "Cannot set deprecation, route not found", "key", key)
+	}
+	route.Deprecation = d
+	routes[key] = route
+	routesMu.Unlock()
+	invalidateOpenAPICache()
+
+	if d == nil {
+		slog.Info("Route deprecation cleared", "key", key)
+	} else {
+		slog.Info("Route deprecated", "key", key, "since", d.since, "sunset", d.sunsetAt)
+	}
+	auditConfigChange("SetRouteDeprecation", map[string]string{"path": path, "method": method, "options": options})
+	return 0
+}
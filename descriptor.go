@@ -0,0 +1,501 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// Protobuf wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// FieldDescriptorProto.Type values
+const (
+	protoTypeDouble   = 1
+	protoTypeFloat    = 2
+	protoTypeInt64    = 3
+	protoTypeUint64   = 4
+	protoTypeInt32    = 5
+	protoTypeFixed64  = 6
+	protoTypeFixed32  = 7
+	protoTypeBool     = 8
+	protoTypeString   = 9
+	protoTypeGroup    = 10
+	protoTypeMessage  = 11
+	protoTypeBytes    = 12
+	protoTypeUint32   = 13
+	protoTypeEnum     = 14
+	protoTypeSfixed32 = 15
+	protoTypeSfixed64 = 16
+	protoTypeSint32   = 17
+	protoTypeSint64   = 18
+)
+
+// httpRuleExtension is the field number of the google.api.http method option
+const httpRuleExtension = 72295728
+
+var errProtoTruncated = errors.New("truncated protobuf message")
+
+// protoFields walks the fields of an encoded protobuf message, calling fn
+// with each field number, wire type, and value: the integer for varint and
+// fixed-width fields, the payload for length-delimited ones
+func protoFields(data []byte, fn func(num int, wire int, v uint64, payload []byte) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errProtoTruncated
+		}
+		data = data[n:]
+		num, wire := int(key>>3), int(key&7)
+		var v uint64
+		var payload []byte
+		switch wire {
+		case wireVarint:
+			if v, n = binary.Uvarint(data); n <= 0 {
+				return errProtoTruncated
+			}
+			data = data[n:]
+		case wireFixed64:
+			if len(data) < 8 {
+				return errProtoTruncated
+			}
+			v, data = binary.LittleEndian.Uint64(data), data[8:]
+		case wireFixed32:
+			if len(data) < 4 {
+				return errProtoTruncated
+			}
+			v, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+		case wireBytes:
+			l, n := binary.Uvarint(data)
+			if n <= 0 || l > uint64(len(data)-n) {
+				return errProtoTruncated
+			}
+			payload = data[n : n+int(l)]
+			data = data[n+int(l):]
+		default:
+			return fmt.Errorf("unsupported protobuf wire type %d", wire)
+		}
+		if err := fn(num, wire, v, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// grpcMethod is an RPC declared in the descriptor set
+type grpcMethod struct {
+	Service         string
+	Name            string
+	InputType       string
+	OutputType      string
+	ClientStreaming bool
+	ServerStreaming bool
+	Rules           []httpRule // google.api.http bindings, including additional ones
+	Comment         string     // leading comment, when protoc kept source info
+}
+
+// httpRule is one google.api.http binding of a method
+type httpRule struct {
+	Method       string
+	Path         string
+	Body         string // "*" for the whole message, a field path, or empty
+	ResponseBody string // field path of the reply sent as the body; empty sends it all
+}
+
+// protoField is a message field declared in a descriptor set
+type protoField struct {
+	Name     string
+	JSONName string
+	Number   int
+	Type     int
+	TypeName string // message or enum type
+	Repeated bool
+}
+
+// protoMessage is a message type declared in a descriptor set
+type protoMessage struct {
+	Name     string
+	Fields   []*protoField
+	MapEntry bool // synthesized entry type of a map field
+	byNumber map[int]*protoField
+	byName   map[string]*protoField // by proto and JSON name
+}
+
+// protoEnum is an enum type declared in a descriptor set
+type protoEnum struct {
+	Name    string
+	Values  []string // in declaration order
+	numbers map[string]int32
+	names   map[int32]string
+}
+
+// descriptorSet holds the services and types of a FileDescriptorSet, by
+// full name without the leading dot
+type descriptorSet struct {
+	methods  map[string]grpcMethod // by full method, e.g. /pkg.Service/Method
+	messages map[string]*protoMessage
+	enums    map[string]*protoEnum
+}
+
+// field looks a field up by its proto or JSON name
+func (m *protoMessage) field(name string) (*protoField, bool) {
+	f, ok := m.byName[name]
+	return f, ok
+}
+
+// isMap reports whether a field is a map field
+func (d *descriptorSet) isMap(f *protoField) bool {
+	if !f.Repeated || f.Type != protoTypeMessage {
+		return false
+	}
+	entry, ok := d.messages[f.TypeName]
+	return ok && entry.MapEntry
+}
+
+// jsonName is the lowerCamelCase JSON name protoc derives for a field
+func jsonName(name string) string {
+	var b strings.Builder
+	upper := false
+	for _, r := range name {
+		if r == '_' {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// loadDescriptorSet reads a descriptor set file
+func loadDescriptorSet(path string) (*descriptorSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseDescriptorSet(data)
+}
+
+// parseDescriptorSet reads a FileDescriptorSet, as written by
+// protoc --descriptor_set_out
+func parseDescriptorSet(data []byte) (*descriptorSet, error) {
+	set := &descriptorSet{
+		methods:  make(map[string]grpcMethod),
+		messages: make(map[string]*protoMessage),
+		enums:    make(map[string]*protoEnum),
+	}
+	err := protoFields(data, func(num int, _ int, _ uint64, file []byte) error {
+		if num != 1 { // FileDescriptorSet.file
+			return nil
+		}
+		return set.parseFile(file)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(set.methods) == 0 {
+		return nil, fmt.Errorf("descriptor set declares no services")
+	}
+	return set, nil
+}
+
+func (d *descriptorSet) parseFile(data []byte) error {
+	var pkg string
+	var messages, enums, services [][]byte
+	comments := make(map[string]string) // by source location path
+	err := protoFields(data, func(num int, _ int, _ uint64, payload []byte) error {
+		switch num {
+		case 2: // FileDescriptorProto.package
+			pkg = string(payload)
+		case 4:
+			messages = append(messages, payload)
+		case 5:
+			enums = append(enums, payload)
+		case 6:
+			services = append(services, payload)
+		case 9: // source_code_info
+			return parseSourceComments(payload, comments)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, message := range messages {
+		if err := d.parseMessage(pkg, message); err != nil {
+			return err
+		}
+	}
+	for _, enum := range enums {
+		if err := d.parseEnum(pkg, enum); err != nil {
+			return err
+		}
+	}
+	for i, service := range services {
+		if err := d.parseService(pkg, service, i, comments); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseSourceComments collects leading comments by location path, such as
+// "6.0.2.1" for the second method of the first service
+func parseSourceComments(data []byte, comments map[string]string) error {
+	return protoFields(data, func(num int, _ int, _ uint64, location []byte) error {
+		if num != 1 { // SourceCodeInfo.location
+			return nil
+		}
+		var path []string
+		var comment string
+		err := protoFields(location, func(num int, wire int, v uint64, payload []byte) error {
+			switch {
+			case num == 1 && wire == wireVarint:
+				path = append(path, fmt.Sprint(v))
+			case num == 1 && wire == wireBytes: // packed path
+				for len(payload) > 0 {
+					v, n := binary.Uvarint(payload)
+					if n <= 0 {
+						return errProtoTruncated
+					}
+					path = append(path, fmt.Sprint(v))
+					payload = payload[n:]
+				}
+			case num == 3:
+				comment = strings.TrimSpace(string(payload))
+			}
+			return nil
+		})
+		if err == nil && comment != "" {
+			comments[strings.Join(path, ".")] = comment
+		}
+		return err
+	})
+}
+
+func qualify(scope, name string) string {
+	if scope == "" {
+		return name
+	}
+	return scope + "." + name
+}
+
+func (d *descriptorSet) parseMessage(scope string, data []byte) error {
+	m := &protoMessage{byNumber: make(map[int]*protoField), byName: make(map[string]*protoField)}
+	var nested, enums [][]byte
+	err := protoFields(data, func(num int, _ int, _ uint64, payload []byte) error {
+		switch num {
+		case 1: // DescriptorProto.name
+			m.Name = string(payload)
+		case 2:
+			f, err := parseFieldDescriptor(payload)
+			if err != nil {
+				return err
+			}
+			m.Fields = append(m.Fields, f)
+		case 3:
+			nested = append(nested, payload)
+		case 4:
+			enums = append(enums, payload)
+		case 7: // MessageOptions
+			return protoFields(payload, func(num int, _ int, v uint64, _ []byte) error {
+				if num == 7 { // map_entry
+					m.MapEntry = v != 0
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	m.Name = qualify(scope, m.Name)
+	for _, f := range m.Fields {
+		m.byNumber[f.Number] = f
+		m.byName[f.Name] = f
+		m.byName[f.JSONName] = f
+	}
+	d.messages[m.Name] = m
+	for _, message := range nested {
+		if err := d.parseMessage(m.Name, message); err != nil {
+			return err
+		}
+	}
+	for _, enum := range enums {
+		if err := d.parseEnum(m.Name, enum); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseFieldDescriptor(data []byte) (*protoField, error) {
+	f := &protoField{}
+	err := protoFields(data, func(num int, _ int, v uint64, payload []byte) error {
+		switch num {
+		case 1:
+			f.Name = string(payload)
+		case 3:
+			f.Number = int(v)
+		case 4:
+			f.Repeated = v == 3 // LABEL_REPEATED
+		case 5:
+			f.Type = int(v)
+		case 6:
+			f.TypeName = strings.TrimPrefix(string(payload), ".")
+		case 10:
+			f.JSONName = string(payload)
+		}
+		return nil
+	})
+	if f.JSONName == "" {
+		f.JSONName = jsonName(f.Name)
+	}
+	return f, err
+}
+
+func (d *descriptorSet) parseEnum(scope string, data []byte) error {
+	e := &protoEnum{numbers: make(map[string]int32), names: make(map[int32]string)}
+	err := protoFields(data, func(num int, _ int, _ uint64, payload []byte) error {
+		switch num {
+		case 1:
+			e.Name = string(payload)
+		case 2: // EnumValueDescriptorProto
+			var name string
+			var number int32
+			if err := protoFields(payload, func(num int, _ int, v uint64, payload []byte) error {
+				switch num {
+				case 1:
+					name = string(payload)
+				case 2:
+					number = int32(v)
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			e.Values = append(e.Values, name)
+			e.numbers[name] = number
+			if _, exists := e.names[number]; !exists {
+				e.names[number] = name
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	e.Name = qualify(scope, e.Name)
+	d.enums[e.Name] = e
+	return nil
+}
+
+func (d *descriptorSet) parseService(pkg string, data []byte, index int, comments map[string]string) error {
+	var name string
+	var rpcs []grpcMethod
+	err := protoFields(data, func(num int, _ int, _ uint64, payload []byte) error {
+		switch num {
+		case 1: // ServiceDescriptorProto.name
+			name = string(payload)
+		case 2: // ServiceDescriptorProto.method
+			m, err := parseMethodDescriptor(payload)
+			if err != nil {
+				return err
+			}
+			m.Comment = comments[fmt.Sprintf("6.%d.2.%d", index, len(rpcs))]
+			rpcs = append(rpcs, m)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	service := qualify(pkg, name)
+	for _, m := range rpcs {
+		m.Service = service
+		d.methods["/"+service+"/"+m.Name] = m
+	}
+	return nil
+}
+
+func parseMethodDescriptor(data []byte) (grpcMethod, error) {
+	var m grpcMethod
+	err := protoFields(data, func(num int, _ int, v uint64, payload []byte) error {
+		switch num {
+		case 1:
+			m.Name = string(payload)
+		case 2:
+			m.InputType = strings.TrimPrefix(string(payload), ".")
+		case 3:
+			m.OutputType = strings.TrimPrefix(string(payload), ".")
+		case 4: // MethodOptions
+			return protoFields(payload, func(num int, _ int, _ uint64, payload []byte) error {
+				if num != httpRuleExtension {
+					return nil
+				}
+				rules, err := parseHTTPRule(payload)
+				m.Rules = append(m.Rules, rules...)
+				return err
+			})
+		case 5:
+			m.ClientStreaming = v != 0
+		case 6:
+			m.ServerStreaming = v != 0
+		}
+		return nil
+	})
+	return m, err
+}
+
+// parseHTTPRule reads a google.api.HttpRule and its additional bindings
+func parseHTTPRule(data []byte) ([]httpRule, error) {
+	var rule httpRule
+	var additional []httpRule
+	verbs := map[int]string{2: "GET", 3: "PUT", 4: "POST", 5: "DELETE", 6: "PATCH"}
+	err := protoFields(data, func(num int, _ int, _ uint64, payload []byte) error {
+		if verb, ok := verbs[num]; ok {
+			rule.Method, rule.Path = verb, string(payload)
+			return nil
+		}
+		switch num {
+		case 7:
+			rule.Body = string(payload)
+		case 8: // CustomHttpPattern
+			return protoFields(payload, func(num int, _ int, _ uint64, payload []byte) error {
+				switch num {
+				case 1:
+					rule.Method = strings.ToUpper(string(payload))
+				case 2:
+					rule.Path = string(payload)
+				}
+				return nil
+			})
+		case 11:
+			rules, err := parseHTTPRule(payload)
+			additional = append(additional, rules...)
+			return err
+		case 12:
+			rule.ResponseBody = string(payload)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if rule.Path == "" {
+		return additional, nil
+	}
+	return append([]httpRule{rule}, additional...), nil
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"C"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Provider scopes
+const (
+	ScopeSingleton = 0 // provided once and shared until shutdown
+	ScopeRequest   = 1 // provided once per request
+)
+
+// Type tags a provider declares for its values. Resolving with a different
+// tag fails rather than handing the caller a value it cannot use.
+var dependencyTypes = map[string]bool{"string": true, "int": true, "float": true, "bool": true, "json": true}
+
+var (
+	errDependencyNotFound = errors.New("dependency not found")
+	errDependencyType     = errors.New("dependency type mismatch")
+	errRequestScope       = errors.New("request-scoped dependency needs an active request ID")
+	errProviderFailed     = errors.New("provider returned no value")
+)
+
+// provider is a dependency declared with a host callback. Providers use the
+// route handler ABI: they receive a JSON providerContext and return a JSON
+// value of their declared type.
+type provider struct {
+	name  string
+	typ   string
+	scope int
+	fn    uintptr
+
+	mu       sync.Mutex // guards the singleton value; held while providing
+	resolved bool
+	value    interface{}
+}
+
+// providerContext is passed to provider callbacks
+type providerContext struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Scope     string `json:"scope"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// lifecycleHook is a host callback run at server startup or shutdown
+type lifecycleHook struct {
+	phase string
+	order int
+	fn    uintptr
+}
+
+var (
+	providers   = make(map[string]*provider)
+	providersMu sync.RWMutex
+
+	// Per-request values keyed by request ID, for requests being served
+	requestScopes   = make(map[string]map[string]interface{})
+	requestScopesMu sync.Mutex
+
+	lifecycleHooks   []lifecycleHook
+	lifecycleHooksMu sync.Mutex
+)
+
+func scopeName(scope int) string {
+	if scope == ScopeRequest {
+		return "request"
+	}
+	return "singleton"
+}
+
+// decodeDependency checks a provider's JSON result against its type tag
+func decodeDependency(typ string, raw []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return nil, fmt.Errorf("invalid provider result: %w", err)
+	}
+	switch typ {
+	case "string":
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+	case "int":
+		if n, ok := value.(json.Number); ok {
+			if i, err := n.Int64(); err == nil {
+				return i, nil
+			}
+		}
+	case "float":
+		if n, ok := value.(json.Number); ok {
+			if f, err := n.Float64(); err == nil && !math.IsInf(f, 0) {
+				return f, nil
+			}
+		}
+	case "bool":
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+	case "json":
+		return value, nil
+	}
+	return nil, fmt.Errorf("%w: provider result is not %s", errDependencyType, typ)
+}
+
+// provide calls the provider's host callback
+func (p *provider) provide(requestID string) (interface{}, error) {
+	ctx, err := json.Marshal(providerContext{Name: p.name, Type: p.typ, Scope: scopeName(p.scope), RequestID: requestID})
+	if err != nil {
+		return nil, err
+	}
+	raw, ok := callRouteHandler(p.fn, ctx)
+	if !ok {
+		return nil, errProviderFailed
+	}
+	return decodeDependency(p.typ, raw)
+}
+
+// singleton returns the provider's shared value, providing it on first use.
+// Failures are not cached so a later resolve can retry.
+func (p *provider) singleton() (interface{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.resolved {
+		return p.value, nil
+	}
+	value, err := p.provide("")
+	if err != nil {
+		return nil, err
+	}
+	p.value, p.resolved = value, true
+	return value, nil
+}
+
+// beginRequestScope makes a request ID resolvable for request-scoped
+// dependencies and returns the ID to use. Client-supplied IDs may repeat,
+// so a busy ID gets a generated suffix. endRequestScope discards the values
+// provided for it.
+func beginRequestScope(requestID string) string {
+	requestScopesMu.Lock()
+	defer requestScopesMu.Unlock()
+	if _, busy := requestScopes[requestID]; busy {
+		requestID += "." + newRequestID()
+	}
+	requestScopes[requestID] = nil
+	return requestID
+}
+
+func endRequestScope(requestID string) {
+	requestScopesMu.Lock()
+	delete(requestScopes, requestID)
+	requestScopesMu.Unlock()
+}
+
+// perRequest returns the provider's value for a request. The provider runs
+// without the scope lock held so it may resolve other dependencies.
+func (p *provider) perRequest(requestID string) (interface{}, error) {
+	requestScopesMu.Lock()
+	values, active := requestScopes[requestID]
+	value, cached := values[p.name]
+	requestScopesMu.Unlock()
+	if !active {
+		return nil, errRequestScope
+	}
+	if cached {
+		return value, nil
+	}
+
+	value, err := p.provide(requestID)
+	if err != nil {
+		return nil, err
+	}
+	requestScopesMu.Lock()
+	defer requestScopesMu.Unlock()
+	values, active = requestScopes[requestID]
+	if !active {
+		return nil, errRequestScope
+	}
+	if existing, ok := values[p.name]; ok {
+		return existing, nil
+	}
+	if values == nil {
+		values = make(map[string]interface{})
+		requestScopes[requestID] = values
+	}
+	values[p.name] = value
+	return value, nil
+}
+
+// resolveDependency looks up a dependency by name. Values stored with
+// RegisterDependency are type "string" singletons, and registered databases
+// resolve to a "database" description of their pool. An empty typ skips the
+// type check.
+func resolveDependency(name, typ, requestID string) (interface{}, string, error) {
+	depsMu.RLock()
+	value, exists := dependencies[name]
+	depsMu.RUnlock()
+	if exists {
+		if typ != "" && typ != "string" {
+			return nil, "", errDependencyType
+		}
+		return value, "string", nil
+	}
+	if d, exists := databaseFor(name); exists {
+		if typ != "" && typ != "database" {
+			return nil, "", errDependencyType
+		}
+		return d.info(), "database", nil
+	}
+
+	providersMu.RLock()
+	p, exists := providers[name]
+	providersMu.RUnlock()
+	if !exists {
+		return nil, "", errDependencyNotFound
+	}
+	if typ != "" && typ != p.typ {
+		return nil, "", errDependencyType
+	}
+	if p.scope == ScopeRequest {
+		value, err := p.perRequest(requestID)
+		return value, p.typ, err
+	}
+	value, err := p.singleton()
+	return value, p.typ, err
+}
+
+// resetSingletons discards provided singleton values so the next start
+// provides fresh ones
+func resetSingletons() {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	for _, p := range providers {
+		p.mu.Lock()
+		p.resolved, p.value = false, nil
+		p.mu.Unlock()
+	}
+}
+
+// runLifecycleHooks calls the hooks for a phase. Startup hooks run in
+// ascending order and shutdown hooks in descending order, so teardown
+// mirrors setup.
+func runLifecycleHooks(phase string) {
+	lifecycleHooksMu.Lock()
+	var hooks []lifecycleHook
+	for _, hook := range lifecycleHooks {
+		if hook.phase == phase {
+			hooks = append(hooks, hook)
+		}
+	}
+	lifecycleHooksMu.Unlock()
+
+	sort.SliceStable(hooks, func(i, j int) bool {
+		if phase == "shutdown" {
+			return hooks[i].order > hooks[j].order
+		}
+		return hooks[i].order < hooks[j].order
+	})
+	for _, hook := range hooks {
+		slog.Debug("Running lifecycle hook", "phase", phase, "order", hook.order)
+		data, _ := json.Marshal(map[string]interface{}{"phase": phase, "order": hook.order})
+		callReportHandler(hook.fn, data)
+	}
+}
+
+// RegisterProvider declares a dependency whose value comes from a host
+// callback. cType is one of string, int, float, bool, or json; scope is
+// ScopeSingleton or ScopeRequest. Re-registering a name replaces it.
+//
+//export RegisterProvider
+func RegisterProvider(cName *C.char, cType *C.char, scope int, cProvider uintptr) int {
+	if cName == nil || cType == nil || cProvider == 0 {
+		return exportError(exportInvalid, "One or more parameters are nil in RegisterProvider")
+	}
+	name := C.GoString(cName)
+	typ := strings.ToLower(C.GoString(cType))
+	if name == "" {
+		return exportError(exportInvalid, "Empty name in RegisterProvider")
+	}
+	if !dependencyTypes[typ] {
+		return exportError(exportInvalid, "Unknown dependency type", "name", name, "type", typ)
+	}
+	if scope != ScopeSingleton && scope != ScopeRequest {
+		return exportError(exportInvalid, "Unknown dependency scope", "name", name, "scope", scope)
+	}
+
+	providersMu.Lock()
+	providers[name] = &provider{name: name, typ: typ, scope: scope, fn: cProvider}
+	providersMu.Unlock()
+
+	slog.Info("Provider registered", "name", name, "type", typ, "scope", scopeName(scope))
+	auditConfigChange("RegisterProvider", map[string]string{"name": name, "type": typ, "scope": scopeName(scope)})
+
+	return 0
+}
+
+// ResolveDependency returns {"value": ..., "type": ...} for a dependency, or
+// {"error": ...}. Handlers pass the request_id they were called with to
+// resolve request-scoped dependencies; cRequestID may be empty for
+// singletons. An empty cType accepts any type. The caller must release the
+// result with FreeString.
+//
+//export ResolveDependency
+func ResolveDependency(cName *C.char, cRequestID *C.char, cType *C.char) *C.char {
+	if cName == nil || cRequestID == nil || cType == nil {
+		recordError("One or more parameters are nil in ResolveDependency")
+		return C.CString(`{"error": "missing parameters"}`)
+	}
+	name := C.GoString(cName)
+	value, typ, err := resolveDependency(name, strings.ToLower(C.GoString(cType)), C.GoString(cRequestID))
+	if err != nil {
+		slog.Debug("Dependency resolution failed", "name", name, "error", err)
+		data, _ := json.Marshal(ErrorResponse{Error: err.Error()})
+		return C.CString(string(data))
+	}
+	data, err := json.Marshal(map[string]interface{}{"value": value, "type": typ})
+	if err != nil {
+		return C.CString(`{"error": "dependency value is not serializable"}`)
+	}
+	return C.CString(string(data))
+}
+
+// RegisterLifecycleHook adds a host callback run at "startup", before the
+// listener opens, or at "shutdown", after in-flight work drains. The callback
+// receives {"phase": ..., "order": ...}. Hooks must not start or stop the
+// server themselves.
+//
+//export RegisterLifecycleHook
+func RegisterLifecycleHook(cPhase *C.char, order int, cHandler uintptr) int {
+	if cPhase == nil || cHandler == 0 {
+		return exportError(exportInvalid, "One or more parameters are nil in RegisterLifecycleHook")
+	}
+	phase := strings.ToLower(C.GoString(cPhase))
+	if phase != "startup" && phase != "shutdown" {
+		return exportError(exportInvalid, "Unknown lifecycle phase", "phase", phase)
+	}
+
+	lifecycleHooksMu.Lock()
+	lifecycleHooks = append(lifecycleHooks, lifecycleHook{phase: phase, order: order, fn: cHandler})
+	lifecycleHooksMu.Unlock()
+
+	slog.Info("Lifecycle hook registered", "phase", phase, "order", order)
+	auditConfigChange("RegisterLifecycleHook", map[string]string{"phase": phase, "order": strconv.Itoa(order)})
+
+	return 0
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"C"
+	"embed"
+	"encoding/json"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Swagger UI 5.17.14 dist assets, served under /swagger/ so the docs work
+// without any files next to the shared library
+//
+//go:embed assets/swagger-ui
+var swaggerAssets embed.FS
+
+const defaultReDocScriptURL = "https://cdn.jsdelivr.net/npm/redoc@2/bundles/redoc.standalone.js"
+
+// DocsOptions configures the Swagger UI and ReDoc pages
+type DocsOptions struct {
+	Enabled   *bool  `json:"enabled"`    // defaults to true
+	Title     string `json:"title"`      // defaults to the OpenAPI info title
+	Theme     string `json:"theme"`      // light (default) or dark
+	SpecURL   string `json:"spec_url"`   // defaults to /openapi.json
+	ScriptURL string `json:"script_url"` // ReDoc only: where to load the standalone bundle from
+}
+
+var (
+	swaggerOptions DocsOptions
+	redocOptions   = DocsOptions{ScriptURL: defaultReDocScriptURL}
+	docsMu         sync.RWMutex
+)
+
+// docsPage holds the resolved values rendered into a docs page
+type docsPage struct {
+	Title     string
+	SpecURL   string
+	Dark      bool
+	ScriptURL string
+	ReDocJSON template.JS
+}
+
+var swaggerPage = template.Must(template.New("swagger").Parse(`<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<link rel="stylesheet" href="swagger-ui.css">
+<link rel="icon" type="image/png" href="favicon-32x32.png">
+<style>
+body { margin: 0; }
+{{if .Dark}}html { background: #1b1b1b; } .swagger-ui { filter: invert(88%) hue-rotate(180deg); } .swagger-ui .microlight, .swagger-ui img { filter: invert(100%) hue-rotate(180deg); }{{end}}
+</style>
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="swagger-ui-bundle.js"></script>
+<script>
+window.ui = SwaggerUIBundle({
+  url: {{.SpecURL}},
+  dom_id: "#swagger-ui",
+  deepLinking: true,
+  oauth2RedirectUrl: window.location.origin + window.location.pathname.replace(/[^/]*$/, "") + "oauth2-redirect.html",
+  presets: [SwaggerUIBundle.presets.apis],
+  layout: "BaseLayout"
+});
+</script>
+</body>
+</html>
+`))
+
+var redocPage = template.Must(template.New("redoc").Parse(`<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<style>body { margin: 0; padding: 0; }</style>
+</head>
+<body>
+<div id="redoc"></div>
+<script src="{{.ScriptURL}}"></script>
+<script>Redoc.init({{.SpecURL}}, {{.ReDocJSON}}, document.getElementById("redoc"));</script>
+</body>
+</html>
+`))
+
+// redocDarkTheme is passed to Redoc.init for the dark theme
+var redocDarkTheme = map[string]interface{}{
+	"theme": map[string]interface{}{
+		"colors": map[string]interface{}{
+			"text":    map[string]string{"primary": "#e0e0e0"},
+			"primary": map[string]string{"main": "#7fb3ff"},
+		},
+		"typography": map[string]interface{}{"code": map[string]string{"backgroundColor": "#2a2a2a"}},
+		"sidebar":    map[string]string{"backgroundColor": "#222222", "textColor": "#e0e0e0"},
+		"rightPanel": map[string]string{"backgroundColor": "#181818"},
+		"schema":     map[string]string{"nestedBackground": "#262626"},
+	},
+}
+
+// resolve fills in defaults for rendering
+func (opts DocsOptions) resolve() docsPage {
+	page := docsPage{Title: opts.Title, SpecURL: opts.SpecURL, Dark: opts.Theme == "dark", ScriptURL: opts.ScriptURL}
+	if page.Title == "" {
+		page.Title = "GoServer API"
+		if info, ok := localizedInfoFor(""); ok && info.Title != "" {
+			page.Title = info.Title
+		}
+	}
+	if page.SpecURL == "" {
+		page.SpecURL = "/openapi.json"
+	}
+	redocConfig := map[string]interface{}{}
+	if page.Dark {
+		redocConfig = redocDarkTheme
+	}
+	data, _ := json.Marshal(redocConfig)
+	page.ReDocJSON = template.JS(data)
+	return page
+}
+
+func (opts DocsOptions) enabled() bool {
+	return opts.Enabled == nil || *opts.Enabled
+}
+
+// serveDocsPage renders a docs page template, or 404s when it is disabled
+func serveDocsPage(w http.ResponseWriter, r *http.Request, tmpl *template.Template, opts DocsOptions) {
+	if !opts.enabled() {
+		http.Error(w, `{"error": "Route not found for `+r.Method+` `+r.URL.Path+`"}`, http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	if err := tmpl.Execute(w, opts.resolve()); err != nil {
+		slog.Error("Error rendering docs page", "page", tmpl.Name(), "error", err)
+	}
+}
+
+// swaggerHandler serves the Swagger UI page and its embedded assets
+func swaggerHandler() http.Handler {
+	assets, err := fs.Sub(swaggerAssets, "assets/swagger-ui")
+	if err != nil {
+		panic(err)
+	}
+	files := http.StripPrefix("/swagger/", http.FileServer(http.FS(assets)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		docsMu.RLock()
+		opts := swaggerOptions
+		docsMu.RUnlock()
+		if r.URL.Path == "/swagger/" || r.URL.Path == "/swagger/index.html" {
+			serveDocsPage(w, r, swaggerPage, opts)
+			return
+		}
+		if !opts.enabled() {
+			http.Error(w, `{"error": "Route not found for `+r.Method+` `+r.URL.Path+`"}`, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+		files.ServeHTTP(w, r)
+	})
+}
+
+// ServeReDoc serves the ReDoc page
+func ServeReDoc(w http.ResponseWriter, r *http.Request) {
+	docsMu.RLock()
+	opts := redocOptions
+	docsMu.RUnlock()
+	serveDocsPage(w, r, redocPage, opts)
+}
+
+// parseDocsOptions decodes docs options JSON over a default
+func parseDocsOptions(raw string, opts DocsOptions) (DocsOptions, bool) {
+	if raw != "" {
+		if err := json.Unmarshal([]byte(raw), &opts); err != nil {
+			recordError("Invalid docs options", "error", err)
+			return opts, false
+		}
+	}
+	opts.Theme = strings.ToLower(opts.Theme)
+	if opts.Theme != "" && opts.Theme != "light" && opts.Theme != "dark" {
+		recordError("Unknown docs theme", "theme", opts.Theme)
+		return opts, false
+	}
+	return opts, true
+}
+
+// ConfigureSwaggerUI sets the /swagger/ page options: title, theme, spec
+// URL, and whether it is served. cOptions is a JSON DocsOptions object.
+//
+//export ConfigureSwaggerUI
+func ConfigureSwaggerUI(cOptions *C.char) int {
+	if cOptions == nil {
+		return exportError(exportInvalid, "cOptions is nil in ConfigureSwaggerUI")
+	}
+	options := C.GoString(cOptions)
+	opts, ok := parseDocsOptions(options, DocsOptions{})
+	if !ok {
+		return exportInvalid
+	}
+	docsMu.Lock()
+	swaggerOptions = opts
+	docsMu.Unlock()
+
+	slog.Info("Swagger UI configured", "enabled", opts.enabled(), "theme", opts.Theme)
+	auditConfigChange("ConfigureSwaggerUI", map[string]string{"options": options})
+
+	return 0
+}
+
+// ConfigureReDoc sets the /redoc page options. ReDoc's standalone bundle is
+// not embedded; script_url points at it and defaults to the jsDelivr CDN.
+//
+//export ConfigureReDoc
+func ConfigureReDoc(cOptions *C.char) int {
+	if cOptions == nil {
+		return exportError(exportInvalid, "cOptions is nil in ConfigureReDoc")
+	}
+	options := C.GoString(cOptions)
+	opts, ok := parseDocsOptions(options, DocsOptions{ScriptURL: defaultReDocScriptURL})
+	if !ok {
+		return exportInvalid
+	}
+	if opts.ScriptURL == "" {
+		opts.ScriptURL = defaultReDocScriptURL
+	}
+	docsMu.Lock()
+	redocOptions = opts
+	docsMu.Unlock()
+
+	slog.Info("ReDoc configured", "enabled", opts.enabled(), "theme", opts.Theme)
+	auditConfigChange("ConfigureReDoc", map[string]string{"options": options})
+
+	return 0
+}
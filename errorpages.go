@@ -0,0 +1,276 @@
+package main
+
+import (
+	"C"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// maxErrorBody bounds how much of a default error body is kept while its
+// replacement is built
+const maxErrorBody = 64 << 10
+
+// ErrorContext describes a server-generated error response for host error
+// handlers and error page templates
+type ErrorContext struct {
+	Status    int    `json:"status"`
+	Error     string `json:"error"` // message from the default body
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	RequestID string `json:"request_id,omitempty"`
+	TraceID   string `json:"trace_id,omitempty"`
+}
+
+// newErrorContext describes an error response with status to r
+func newErrorContext(r *http.Request, status int) ErrorContext {
+	ctx := ErrorContext{Status: status, Error: http.StatusText(status), Method: r.Method, Path: r.URL.Path, TraceID: traceIDFrom(r.Context())}
+	if info := requestInfoFrom(r); info != nil {
+		ctx.RequestID = info.ID
+	}
+	return ctx
+}
+
+// errorPage replaces the default body for one status, from a host callback,
+// a RegisterTemplateDir template, or the problem+json format
+type errorPage struct {
+	handler  uintptr
+	template string
+	problem  bool
+}
+
+var (
+	errorPages   = make(map[int]errorPage) // by status; 0 covers every error status without its own page
+	errorPagesMu sync.RWMutex
+)
+
+func errorPageFor(status int) (errorPage, bool) {
+	errorPagesMu.RLock()
+	defer errorPagesMu.RUnlock()
+	if page, ok := errorPages[status]; ok {
+		return page, true
+	}
+	if page, ok := errorPages[0]; ok {
+		return page, true
+	}
+	return errorPage{problem: true}, problemErrorsEnabled()
+}
+
+func errorPagesEnabled() bool {
+	if problemErrorsEnabled() {
+		return true
+	}
+	errorPagesMu.RLock()
+	defer errorPagesMu.RUnlock()
+	return len(errorPages) > 0
+}
+
+// errorPageWriter holds back error responses that have a replacement page.
+// Responses written from host handlers and proxied upstreams are marked as
+// passthrough and left alone; only the server's own errors are replaced.
+type errorPageWriter struct {
+	http.ResponseWriter
+	passthrough bool
+	started     bool
+	page        *errorPage // set once an error response is held back
+	status      int
+	body        bytes.Buffer
+}
+
+func (w *errorPageWriter) WriteHeader(code int) {
+	if w.page != nil {
+		return
+	}
+	if !w.started && code >= 400 && !w.passthrough && !isProblemResponse(w.Header()) {
+		if page, ok := errorPageFor(code); ok {
+			w.page, w.status = &page, code
+			return
+		}
+	}
+	if code >= 200 {
+		w.started = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *errorPageWriter) Write(b []byte) (int, error) {
+	if w.page != nil {
+		if room := maxErrorBody - w.body.Len(); room > 0 {
+			w.body.Write(b[:min(len(b), room)])
+		}
+		return len(b), nil
+	}
+	w.started = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *errorPageWriter) Flush() {
+	if w.page != nil {
+		return
+	}
+	w.started = true
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *errorPageWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// setErrorPassthrough marks the response on w as written by the host or an
+// upstream, so its error statuses keep their bodies
+func setErrorPassthrough(w http.ResponseWriter, on bool) {
+	for w != nil {
+		if ew, ok := w.(*errorPageWriter); ok {
+			ew.passthrough = on
+			return
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return
+		}
+		w = u.Unwrap()
+	}
+}
+
+// errorPageMiddleware replaces the bodies of server-generated error
+// responses with the registered error pages
+func errorPageMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !errorPagesEnabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ew := &errorPageWriter{ResponseWriter: w}
+		next.ServeHTTP(ew, r)
+		if ew.page != nil {
+			ew.writeErrorPage(r)
+		}
+	})
+}
+
+// writeErrorPage sends the replacement for the held-back error, or the
+// default body if the replacement fails
+func (w *errorPageWriter) writeErrorPage(r *http.Request) {
+	ctx := newErrorContext(r, w.status)
+	var parsed ErrorResponse
+	if w.Header().Get("Content-Encoding") == "" && json.Unmarshal(bytes.TrimSpace(w.body.Bytes()), &parsed) == nil && parsed.Error != "" {
+		ctx.Error = parsed.Error
+	}
+
+	response, ok := w.page.response(ctx)
+	if !ok {
+		w.ResponseWriter.WriteHeader(w.status)
+		w.ResponseWriter.Write(w.body.Bytes())
+		return
+	}
+	for _, name := range []string{"Content-Type", "Content-Length", "Content-Encoding", "X-Content-Type-Options"} {
+		w.Header().Del(name)
+	}
+	writeHandlerResponse(w.ResponseWriter, RouteInfo{}, response)
+}
+
+// response builds the replacement response for ctx
+func (p *errorPage) response(ctx ErrorContext) (HandlerResponse, bool) {
+	if p.problem {
+		return problemResponse(ctx)
+	}
+	if p.handler != 0 {
+		request, err := json.Marshal(ctx)
+		if err != nil {
+			return HandlerResponse{}, false
+		}
+		raw, ok := callRouteHandler(p.handler, request)
+		if !ok {
+			slog.Error("Error handler returned no response", "status", ctx.Status, "path", ctx.Path)
+			return HandlerResponse{}, false
+		}
+		var response HandlerResponse
+		if err := json.Unmarshal(raw, &response); err != nil {
+			slog.Error("Error decoding error handler response", "status", ctx.Status, "error", err)
+			return HandlerResponse{}, false
+		}
+		if response.Status == 0 {
+			response.Status = ctx.Status
+		}
+		return response, true
+	}
+
+	templatesMu.RLock()
+	dir := templates
+	templatesMu.RUnlock()
+	if dir == nil {
+		slog.Error("Error page template used before RegisterTemplateDir", "status", ctx.Status, "template", p.template)
+		return HandlerResponse{}, false
+	}
+	page, err := dir.render(p.template, ctx)
+	if err != nil {
+		slog.Error("Error rendering error page", "status", ctx.Status, "template", p.template, "error", err)
+		return HandlerResponse{}, false
+	}
+	return HandlerResponse{
+		Status:  ctx.Status,
+		Headers: map[string]string{"Content-Type": "text/html; charset=utf-8"},
+		Body:    string(page),
+	}, true
+}
+
+func validErrorStatus(status int) bool {
+	return status == 0 || (status >= 400 && status <= 599)
+}
+
+func setErrorPage(status int, page errorPage, remove bool) {
+	errorPagesMu.Lock()
+	if remove {
+		delete(errorPages, status)
+	} else {
+		errorPages[status] = page
+	}
+	errorPagesMu.Unlock()
+}
+
+// RegisterErrorHandler replaces the server's own body for statusCode (4xx
+// or 5xx, or 0 for every error status without a handler of its own) with
+// the response of a host callback. The callback uses the route handler ABI,
+// receives a JSON ErrorContext and returns a response like a route handler;
+// a missing status keeps statusCode. Responses from host handlers and
+// proxied upstreams are not replaced. NULL removes the handler.
+//
+//export RegisterErrorHandler
+func RegisterErrorHandler(statusCode int, cCallback uintptr) int {
+	if !validErrorStatus(statusCode) {
+		return exportError(exportInvalid, "Invalid error handler status", "status", statusCode)
+	}
+	setErrorPage(statusCode, errorPage{handler: cCallback}, cCallback == 0)
+
+	slog.Info("Error handler registered", "status", statusCode, "enabled", cCallback != 0)
+	auditConfigChange("RegisterErrorHandler", map[string]string{"status": strconv.Itoa(statusCode), "enabled": fmt.Sprint(cCallback != 0)})
+
+	return 0
+}
+
+// RegisterErrorPage is like RegisterErrorHandler but renders cTemplate, a
+// RegisterTemplateDir template, as an HTML page with the ErrorContext as
+// data. An empty cTemplate removes the page.
+//
+//export RegisterErrorPage
+func RegisterErrorPage(statusCode int, cTemplate *C.char) int {
+	if cTemplate == nil {
+		return exportError(exportInvalid, "cTemplate is nil in RegisterErrorPage")
+	}
+	name := C.GoString(cTemplate)
+	if !validErrorStatus(statusCode) || (name != "" && !validTemplateName(name)) {
+		return exportError(exportInvalid, "Invalid error page", "status", statusCode, "template", name)
+	}
+	setErrorPage(statusCode, errorPage{template: name}, name == "")
+
+	slog.Info("Error page registered", "status", statusCode, "template", name)
+	auditConfigChange("RegisterErrorPage", map[string]string{"status": strconv.Itoa(statusCode), "template": name})
+
+	return 0
+}
@@ -0,0 +1,139 @@
+package main
+
+// This file holds the C side of host callbacks. It has no //export
+// functions, so the preamble may contain definitions.
+
+/*
+#include <stdint.h>
+#include <stdlib.h>
+
+// A route handler receives the serialized request and returns a pointer to a
+// NUL-terminated JSON response. The host keeps ownership of the returned
+// buffer; Go copies it before the callback's thread runs anything else.
+typedef const char* (*goserver_route_handler)(const char* request, int request_len);
+
+static const char* goserver_call_route_handler(uintptr_t fn, const char* request, int request_len) {
+	return ((goserver_route_handler)fn)(request, request_len);
+}
+
+// A connection handler is told about WebSocket and SSE connection events.
+// data is only set for message events and is not NUL-terminated; it is freed
+// when the call returns.
+typedef void (*goserver_conn_handler)(const char* conn_id, int event, const char* data, int data_len);
+
+static void goserver_call_conn_handler(uintptr_t fn, const char* conn_id, int event, const char* data, int data_len) {
+	((goserver_conn_handler)fn)(conn_id, event, data, data_len);
+}
+
+// A report handler receives a JSON report, such as a recovered panic or a
+// shutdown summary. The report is freed when the call returns.
+typedef void (*goserver_report_handler)(const char* report, int report_len);
+
+static void goserver_call_report_handler(uintptr_t fn, const char* report, int report_len) {
+	((goserver_report_handler)fn)(report, report_len);
+}
+
+// A lifespan handler runs at server startup or shutdown. event is JSON and
+// is freed when the call returns. A nonzero return reports a failure, which
+// aborts startup.
+typedef int (*goserver_lifespan_handler)(const char* event, int event_len);
+
+static int goserver_call_lifespan_handler(uintptr_t fn, const char* event, int event_len) {
+	return ((goserver_lifespan_handler)fn)(event, event_len);
+}
+
+// An upload handler receives multipart file parts chunk by chunk. upload is
+// the part's JSON metadata; data is only set for chunk events and is freed
+// when the call returns. A nonzero return rejects the upload.
+typedef int (*goserver_upload_handler)(const char* upload, int event, const char* data, int data_len);
+
+static int goserver_call_upload_handler(uintptr_t fn, const char* upload, int event, const char* data, int data_len) {
+	return ((goserver_upload_handler)fn)(upload, event, data, data_len);
+}
+
+// A task handler runs one background task. task is the task's JSON
+// descriptor and payload the bytes it was submitted with, not NUL-terminated;
+// both are freed when the call returns. The handler returns a NUL-terminated
+// result that Go copies, setting *failed to report it as an error message
+// instead. NULL fails the task without a message.
+typedef const char* (*goserver_task_handler)(const char* task, int task_len, const char* payload, int payload_len, int* failed);
+
+static const char* goserver_call_task_handler(uintptr_t fn, const char* task, int task_len, const char* payload, int payload_len, int* failed) {
+	return ((goserver_task_handler)fn)(task, task_len, payload, payload_len, failed);
+}
+*/
+import "C"
+
+import "unsafe"
+
+// callRouteHandler invokes a host route handler with the serialized request
+// and returns a copy of its response. ok is false if the callback returned NULL.
+func callRouteHandler(fn uintptr, request []byte) (response []byte, ok bool) {
+	cRequest := C.CBytes(request)
+	defer C.free(cRequest)
+	cResponse := C.goserver_call_route_handler(C.uintptr_t(fn), (*C.char)(cRequest), C.int(len(request)))
+	if cResponse == nil {
+		return nil, false
+	}
+	return []byte(C.GoString(cResponse)), true
+}
+
+// callConnHandler notifies a host connection handler of an event
+func callConnHandler(fn uintptr, connID string, event int, data []byte) {
+	cConnID := C.CString(connID)
+	defer C.free(unsafe.Pointer(cConnID))
+	var cData unsafe.Pointer
+	if len(data) > 0 {
+		cData = C.CBytes(data)
+		defer C.free(cData)
+	}
+	C.goserver_call_conn_handler(C.uintptr_t(fn), cConnID, C.int(event), (*C.char)(cData), C.int(len(data)))
+}
+
+// callReportHandler passes a JSON report to a host report callback
+func callReportHandler(fn uintptr, report []byte) {
+	cReport := C.CBytes(report)
+	defer C.free(cReport)
+	C.goserver_call_report_handler(C.uintptr_t(fn), (*C.char)(cReport), C.int(len(report)))
+}
+
+// callLifespanHandler runs a host lifespan handler and reports whether it
+// succeeded
+func callLifespanHandler(fn uintptr, event []byte) bool {
+	cEvent := C.CBytes(event)
+	defer C.free(cEvent)
+	return C.goserver_call_lifespan_handler(C.uintptr_t(fn), (*C.char)(cEvent), C.int(len(event))) == 0
+}
+
+// callUploadHandler passes one upload event to a host upload handler and
+// reports whether the host accepted it
+func callUploadHandler(fn uintptr, upload []byte, event int, data []byte) bool {
+	cUpload := C.CString(string(upload))
+	defer C.free(unsafe.Pointer(cUpload))
+	var cData unsafe.Pointer
+	if len(data) > 0 {
+		cData = C.CBytes(data)
+		defer C.free(cData)
+	}
+	return C.goserver_call_upload_handler(C.uintptr_t(fn), cUpload, C.int(event), (*C.char)(cData), C.int(len(data))) == 0
+}
+
+// callTaskHandler runs a host task handler and returns a copy of its result.
+// failed is set when the host reported the result as an error; ok is false
+// if the callback returned NULL.
+func callTaskHandler(fn uintptr, task []byte, payload []byte) (result []byte, failed bool, ok bool) {
+	cTask := C.CBytes(task)
+	defer C.free(cTask)
+	var cPayload unsafe.Pointer
+	if len(payload) > 0 {
+		cPayload = C.CBytes(payload)
+		defer C.free(cPayload)
+	}
+	cFailed := (*C.int)(C.calloc(1, C.size_t(unsafe.Sizeof(C.int(0)))))
+	defer C.free(unsafe.Pointer(cFailed))
+	cResult := C.goserver_call_task_handler(C.uintptr_t(fn), (*C.char)(cTask), C.int(len(task)), (*C.char)(cPayload), C.int(len(payload)), cFailed)
+	if cResult == nil {
+		return nil, false, false
+	}
+	return []byte(C.GoString(cResult)), *cFailed != 0, true
+}
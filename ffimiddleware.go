@@ -0,0 +1,195 @@
+package main
+
+import (
+	"C"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// MiddlewareRequest is the request snapshot passed to host middleware
+// callbacks. The body is not included, so the handler can still read it.
+type MiddlewareRequest struct {
+	RequestID       string                     `json:"request_id"`
+	Method          string                     `json:"method"`
+	Path            string                     `json:"path"`
+	Headers         map[string][]string        `json:"headers"`
+	Query           map[string][]string        `json:"query"`
+	RemoteAddr      string                     `json:"remote_addr"`
+	Claims          map[string]interface{}     `json:"claims,omitempty"`           // verified JWT claims
+	ClientCert      *ClientCertInfo            `json:"client_cert,omitempty"`      // verified mutual TLS client certificate
+	Context         map[string]json.RawMessage `json:"context,omitempty"`          // values set for the request so far
+	Status          int                        `json:"status,omitempty"`           // post-response only
+	ResponseHeaders map[string][]string        `json:"response_headers,omitempty"` // post-response only
+}
+
+// MiddlewareAction is what host middleware callbacks return. NULL or an
+// empty object lets the request continue unchanged.
+type MiddlewareAction struct {
+	Respond       *HandlerResponse           `json:"respond"`        // pre-request only: answer without running the route
+	SetHeaders    map[string]string          `json:"set_headers"`    // request headers before the route, response headers after it
+	RemoveHeaders []string                   `json:"remove_headers"` // likewise
+	SetContext    map[string]json.RawMessage `json:"set_context"`    // request values for later middleware, the handler, and its tasks
+}
+
+// callMiddlewareHook passes the snapshot to a host callback and decodes its
+// action. A callback error lets the request continue, so a broken hook
+// cannot take the server down; the error is logged.
+func callMiddlewareHook(name string, fn uintptr, snapshot MiddlewareRequest) (MiddlewareAction, bool) {
+	var action MiddlewareAction
+	request, err := json.Marshal(snapshot)
+	if err != nil {
+		slog.Error("Error encoding middleware request", "middleware", name, "error", err)
+		return action, false
+	}
+	raw, ok := callRouteHandler(fn, request)
+	if !ok {
+		return action, false
+	}
+	if err := json.Unmarshal(raw, &action); err != nil {
+		slog.Error("Error decoding middleware action", "middleware", name, "error", err)
+		return action, false
+	}
+	return action, true
+}
+
+func applyHeaderAction(header http.Header, action MiddlewareAction) {
+	for _, name := range action.RemoveHeaders {
+		header.Del(name)
+	}
+	for name, value := range action.SetHeaders {
+		header.Set(name, value)
+	}
+}
+
+// applyContextAction stores the values a pre-request callback set
+func applyContextAction(r *http.Request, action MiddlewareAction) {
+	info := requestInfoFrom(r)
+	if info == nil {
+		return
+	}
+	for key, value := range action.SetContext {
+		info.Values.set(key, value)
+	}
+}
+
+func middlewareSnapshot(r *http.Request) MiddlewareRequest {
+	snapshot := MiddlewareRequest{
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		Headers:    r.Header,
+		Query:      r.URL.Query(),
+		RemoteAddr: r.RemoteAddr,
+		ClientCert: clientCertFrom(r),
+	}
+	if info := requestInfoFrom(r); info != nil {
+		snapshot.RequestID = info.ID
+		snapshot.Claims = info.Claims
+		snapshot.Context = info.Values.snapshot()
+	}
+	return snapshot
+}
+
+// postHookWriter runs the post-response hook just before the status line is
+// sent, while response headers can still change
+type postHookWriter struct {
+	http.ResponseWriter
+	hook  func(status int)
+	fired bool
+}
+
+func (w *postHookWriter) fire(status int) {
+	if !w.fired {
+		w.fired = true
+		w.hook(status)
+	}
+}
+
+func (w *postHookWriter) WriteHeader(code int) {
+	if code >= 200 {
+		w.fire(code)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *postHookWriter) Write(b []byte) (int, error) {
+	w.fire(http.StatusOK)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *postHookWriter) Flush() {
+	w.fire(http.StatusOK)
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *postHookWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// callbackMiddleware runs host pre-request and post-response callbacks
+// around the rest of the chain; either may be 0
+func callbackMiddleware(name string, pre, post uintptr) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if pre != 0 {
+				if action, ok := callMiddlewareHook(name, pre, middlewareSnapshot(r)); ok {
+					if action.Respond != nil {
+						slog.Debug("Middleware callback responded", "middleware", name, "status", action.Respond.Status)
+						writeHandlerResponse(w, RouteInfo{}, *action.Respond)
+						return
+					}
+					applyHeaderAction(r.Header, action)
+					applyContextAction(r, action)
+				}
+			}
+			if post == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			pw := &postHookWriter{ResponseWriter: w}
+			pw.hook = func(status int) {
+				snapshot := middlewareSnapshot(r)
+				snapshot.Status = status
+				snapshot.ResponseHeaders = w.Header()
+				if action, ok := callMiddlewareHook(name, post, snapshot); ok {
+					applyHeaderAction(w.Header(), action)
+				}
+			}
+			next.ServeHTTP(pw, r)
+			pw.fire(http.StatusOK)
+		})
+	}
+}
+
+// RegisterMiddlewareCallback adds host middleware to the chain under cName,
+// after the middlewares registered before it. Both callbacks use the route
+// handler ABI and receive a JSON MiddlewareRequest. cPre runs before the
+// route and may answer the request itself (e.g. custom auth) or change
+// request headers; cPost runs once the route has chosen its status, before
+// the response is sent, and may change response headers. Either callback
+// may be NULL. Registering a name again replaces its callbacks in place;
+// RegisterMiddleware toggles it like a built-in middleware.
+//
+//export RegisterMiddlewareCallback
+func RegisterMiddlewareCallback(cName *C.char, cPre uintptr, cPost uintptr) int {
+	if cName == nil || (cPre == 0 && cPost == 0) {
+		return exportError(exportInvalid, "One or more parameters are nil in RegisterMiddlewareCallback")
+	}
+	name := C.GoString(cName)
+	if name == "" {
+		return exportError(exportInvalid, "Empty name in RegisterMiddlewareCallback")
+	}
+	if _, builtin := middlewareFactories[name]; builtin {
+		return exportError(exportInvalid, "Middleware callback name is taken by a built-in middleware", "name", name)
+	}
+	middlewaresMu.Lock()
+	setMiddleware(name, callbackMiddleware(name, cPre, cPost))
+	middlewaresMu.Unlock()
+
+	slog.Info("Registered middleware callback", "name", name, "pre", cPre != 0, "post", cPost != 0)
+	auditConfigChange("RegisterMiddlewareCallback", map[string]string{"name": name})
+
+	return 0
+}
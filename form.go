@@ -0,0 +1,166 @@
+package main
+
+import (
+	"C"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const formContentType = "application/x-www-form-urlencoded"
+
+type formParamsKey struct{}
+
+// isFormRequest reports whether the body is application/x-www-form-urlencoded
+func isFormRequest(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == formContentType
+}
+
+// parseFormFields coerces the route's declared form fields from the
+// urlencoded body, applying defaults. It answers 415 for other content
+// types and 422 for missing or invalid fields; on success the body is left
+// readable for the handler.
+func parseFormFields(w http.ResponseWriter, r *http.Request, route RouteInfo) (map[string]interface{}, bool) {
+	if !isFormRequest(r) {
+		http.Error(w, `{"error": "Expected application/x-www-form-urlencoded"}`, http.StatusUnsupportedMediaType)
+		return nil, false
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeBodyReadError(w, err)
+		return nil, false
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		writeValidationErrors(w, r, []ValidationError{{
+			Loc:  []interface{}{"body"},
+			Msg:  "Form decode error: " + err.Error(),
+			Type: "form_invalid",
+		}})
+		return nil, false
+	}
+	values, errs := coerceDeclared(route.FormFields, "body", form)
+	if len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return nil, false
+	}
+	return values, true
+}
+
+// withFormParams stores the coerced form fields in the request context
+func withFormParams(r *http.Request, values map[string]interface{}) *http.Request {
+	if values == nil {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), formParamsKey{}, values))
+}
+
+// FormParams returns the coerced values of a route's declared form fields
+func FormParams(r *http.Request) map[string]interface{} {
+	values, _ := r.Context().Value(formParamsKey{}).(map[string]interface{})
+	return values
+}
+
+// formRequestBody documents declared form fields as a urlencoded request
+// body
+func formRequestBody(fields []ParameterInfo) map[string]interface{} {
+	properties := make(map[string]interface{}, len(fields))
+	var required []string
+	for _, field := range fields {
+		schema := map[string]interface{}{"type": openAPITypes[field.Type]}
+		if len(field.Enum) > 0 {
+			schema["enum"] = field.Enum
+		}
+		if field.Default != nil {
+			schema["default"] = field.Default
+		}
+		if field.Description != "" {
+			schema["description"] = field.Description
+		}
+		properties[field.Name] = schema
+		if field.Required {
+			required = append(required, field.Name)
+		}
+	}
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return map[string]interface{}{
+		"required": len(required) > 0,
+		"content": map[string]interface{}{
+			formContentType: map[string]interface{}{"schema": schema},
+		},
+	}
+}
+
+// RegisterFormFields declares the fields of a route's
+// application/x-www-form-urlencoded body. cFields is a JSON array of the
+// same objects RegisterQueryParams takes and replaces any earlier
+// declaration; an empty array removes it. Requests with another content
+// type are rejected with 415 and those with missing or invalid fields with
+// 422. Handlers receive the coerced values in "form_params".
+//
+//export RegisterFormFields
+func RegisterFormFields(cPath *C.char, cMethod *C.char, cFields *C.char) int {
+	if cPath == nil || cMethod == nil || cFields == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in RegisterFormFields")
+	}
+	path := C.GoString(cPath)
+	method := strings.ToUpper(C.GoString(cMethod))
+	var declared []QueryParam
+	if err := json.Unmarshal([]byte(C.GoString(cFields)), &declared); err != nil {
+		return exportError(exportInvalid, "Invalid form fields", "path", path, "method", method, "error", err)
+	}
+	fields, err := parseDeclarations(declared, "body", "form field")
+	if err != nil {
+		return exportError(exportInvalid, "Invalid form fields", "path", path, "method", method, "error", err)
+	}
+
+	routesMu.Lock()
+	key := path + method
+	route, exists := routes[key]
+	if !exists {
+		routesMu.Unlock()
+		return exportError(exportNotFound, "Cannot set form fields, route not found", "key", key)
+	}
+	if route.Multipart != nil {
+		routesMu.Unlock()
+		return exportError(exportFailed, "Cannot set form fields, route accepts multipart/form-data", "key", key)
+	}
+	setFormFields(&route, fields)
+	routes[key] = route
+	routesMu.Unlock()
+
+	names := make([]string, len(fields))
+	for i, field := range fields {
+		names[i] = field.Name
+	}
+	slog.Info("Route form fields set", "key", key, "fields", strings.Join(names, ","))
+	invalidateOpenAPICache()
+	auditConfigChange("RegisterFormFields", map[string]string{"path": path, "method": method, "fields": strings.Join(names, ",")})
+
+	return 0
+}
+
+// setFormFields replaces a route's form declaration and its documented
+// error responses
+func setFormFields(route *RouteInfo, fields []ParameterInfo) {
+	if len(fields) == 0 {
+		route.FormFields = nil
+		delete(route.Responses, http.StatusUnsupportedMediaType)
+		return
+	}
+	route.FormFields = fields
+	route.Responses[http.StatusUnsupportedMediaType] = "Unsupported media type"
+	route.Responses[http.StatusUnprocessableEntity] = "Validation error"
+}
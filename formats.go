@@ -0,0 +1,399 @@
+package main
+
+import (
+	"C"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log/slog"
+	"math"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Response formats handler routes can be serialized as
+const (
+	FormatJSON    = "json"
+	FormatXML     = "xml"
+	FormatMsgPack = "msgpack"
+	FormatYAML    = "yaml"
+)
+
+// responseFormat maps a format to the media types that select it; the first
+// is sent as Content-Type
+type responseFormat struct {
+	name       string
+	mediaTypes []string
+}
+
+// responseFormats is in preference order for Accept ties
+var responseFormats = []responseFormat{
+	{FormatJSON, []string{"application/json"}},
+	{FormatXML, []string{"application/xml", "text/xml"}},
+	{FormatMsgPack, []string{"application/msgpack", "application/x-msgpack", "application/vnd.msgpack"}},
+	{FormatYAML, []string{"application/yaml", "application/x-yaml", "text/yaml"}},
+}
+
+func knownFormat(name string) bool {
+	for _, format := range responseFormats {
+		if format.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateFormat picks the response format for an Accept header among
+// allowed (every format when empty). An empty header means JSON. It reports
+// false when the client accepts none of them.
+func negotiateFormat(accept string, allowed []string) (responseFormat, bool) {
+	var best responseFormat
+	bestQ := 0.0
+	for _, format := range responseFormats {
+		if len(allowed) > 0 && !slices.Contains(allowed, format.name) {
+			continue
+		}
+		if accept == "" {
+			return format, true
+		}
+		for _, mediaType := range format.mediaTypes {
+			if q := acceptQuality(accept, mediaType); q > bestQ {
+				best, bestQ = format, q
+			}
+		}
+	}
+	return best, bestQ > 0
+}
+
+// negotiateHandlerResponse re-encodes a JSON handler response in the format
+// the client asked for. Responses with another content type pass through.
+// It reports false after answering 406 when no allowed format is acceptable.
+func negotiateHandlerResponse(w http.ResponseWriter, r *http.Request, route RouteInfo, response HandlerResponse) (HandlerResponse, bool) {
+	contentType := ""
+	for name, value := range response.Headers {
+		if strings.EqualFold(name, "Content-Type") {
+			contentType = value
+			delete(response.Headers, name)
+		}
+	}
+	if contentType != "" {
+		if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || mediaType != "application/json" {
+			response.Headers["Content-Type"] = contentType
+			return response, true
+		}
+	}
+	w.Header().Add("Vary", "Accept")
+	format, ok := negotiateFormat(r.Header.Get("Accept"), route.Formats)
+	if !ok {
+		supported := make([]string, 0, len(responseFormats))
+		for _, f := range responseFormats {
+			if len(route.Formats) == 0 || slices.Contains(route.Formats, f.name) {
+				supported = append(supported, f.mediaTypes[0])
+			}
+		}
+		slog.Debug("No acceptable response format", "route", route.Path, "accept", r.Header.Get("Accept"))
+		http.Error(w, fmt.Sprintf(`{"error": "Not acceptable - supported types: %s"}`, strings.Join(supported, ", ")), http.StatusNotAcceptable)
+		return response, false
+	}
+	if response.Headers == nil {
+		response.Headers = make(map[string]string)
+	}
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	response.Headers["Content-Type"] = contentType
+	if format.name == FormatJSON {
+		return response, true
+	}
+
+	body := []byte(response.Body)
+	if response.BodyBase64 != nil {
+		body = response.BodyBase64
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		response.Headers["Content-Type"] = format.mediaTypes[0]
+		return response, true
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		// Not actually JSON; send it as the handler wrote it
+		slog.Debug("Handler body is not JSON, skipping format negotiation", "route", route.Path, "error", err)
+		return response, true
+	}
+	var encoded []byte
+	switch format.name {
+	case FormatXML:
+		encoded = encodeXML(value)
+	case FormatMsgPack:
+		encoded = encodeMsgPack(nil, value)
+	case FormatYAML:
+		encoded = encodeYAML(value)
+	}
+	response.Body, response.BodyBase64 = "", encoded
+	response.Headers["Content-Type"] = format.mediaTypes[0]
+	return response, true
+}
+
+// encodeXML writes a decoded JSON value under a <response> root. Object
+// keys become elements, or <entry key="..."> when they are not valid XML
+// names; array items become <item> elements.
+func encodeXML(value interface{}) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	writeXMLElement(&buf, "response", value)
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+func validXMLName(name string) bool {
+	if name == "" || strings.HasPrefix(strings.ToLower(name), "xml") {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case unicode.IsLetter(r) || r == '_':
+		case i > 0 && (unicode.IsDigit(r) || r == '-' || r == '.'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func writeXMLElement(buf *bytes.Buffer, name string, value interface{}) {
+	tag := name
+	if validXMLName(name) {
+		buf.WriteString("<" + name + ">")
+	} else {
+		tag = "entry"
+		buf.WriteString(`<entry key="`)
+		xml.EscapeText(buf, []byte(name))
+		buf.WriteString(`">`)
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, key := range sortedKeys(v) {
+			writeXMLElement(buf, key, v[key])
+		}
+	case []interface{}:
+		for _, item := range v {
+			writeXMLElement(buf, "item", item)
+		}
+	case nil:
+	default:
+		xml.EscapeText(buf, []byte(fmt.Sprint(v)))
+	}
+	buf.WriteString("</" + tag + ">")
+}
+
+// encodeMsgPack appends a decoded JSON value in MessagePack
+func encodeMsgPack(buf []byte, value interface{}) []byte {
+	switch v := value.(type) {
+	case nil:
+		return append(buf, 0xc0)
+	case bool:
+		if v {
+			return append(buf, 0xc3)
+		}
+		return append(buf, 0xc2)
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			switch {
+			case i >= 0 && i < 128:
+				return append(buf, byte(i))
+			case i < 0 && i >= -32:
+				return append(buf, byte(int8(i)))
+			}
+			return binary.BigEndian.AppendUint64(append(buf, 0xd3), uint64(i))
+		}
+		if u, err := strconv.ParseUint(v.String(), 10, 64); err == nil {
+			return binary.BigEndian.AppendUint64(append(buf, 0xcf), u)
+		}
+		f, _ := v.Float64()
+		return binary.BigEndian.AppendUint64(append(buf, 0xcb), math.Float64bits(f))
+	case string:
+		n := len(v)
+		switch {
+		case n < 32:
+			buf = append(buf, 0xa0|byte(n))
+		case n < 1<<8:
+			buf = append(buf, 0xd9, byte(n))
+		case n < 1<<16:
+			buf = binary.BigEndian.AppendUint16(append(buf, 0xda), uint16(n))
+		default:
+			buf = binary.BigEndian.AppendUint32(append(buf, 0xdb), uint32(n))
+		}
+		return append(buf, v...)
+	case []interface{}:
+		n := len(v)
+		switch {
+		case n < 16:
+			buf = append(buf, 0x90|byte(n))
+		case n < 1<<16:
+			buf = binary.BigEndian.AppendUint16(append(buf, 0xdc), uint16(n))
+		default:
+			buf = binary.BigEndian.AppendUint32(append(buf, 0xdd), uint32(n))
+		}
+		for _, item := range v {
+			buf = encodeMsgPack(buf, item)
+		}
+		return buf
+	case map[string]interface{}:
+		n := len(v)
+		switch {
+		case n < 16:
+			buf = append(buf, 0x80|byte(n))
+		case n < 1<<16:
+			buf = binary.BigEndian.AppendUint16(append(buf, 0xde), uint16(n))
+		default:
+			buf = binary.BigEndian.AppendUint32(append(buf, 0xdf), uint32(n))
+		}
+		for _, key := range sortedKeys(v) {
+			buf = encodeMsgPack(buf, key)
+			buf = encodeMsgPack(buf, v[key])
+		}
+		return buf
+	}
+	return append(buf, 0xc0)
+}
+
+// encodeYAML writes a decoded JSON value as a block-style YAML document
+func encodeYAML(value interface{}) []byte {
+	var buf bytes.Buffer
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 {
+			buf.WriteString("{}\n")
+			break
+		}
+		writeYAMLMap(&buf, v, 0)
+	case []interface{}:
+		if len(v) == 0 {
+			buf.WriteString("[]\n")
+			break
+		}
+		writeYAMLList(&buf, v, 0)
+	default:
+		buf.WriteString(yamlScalar(v) + "\n")
+	}
+	return buf.Bytes()
+}
+
+func writeYAMLMap(buf *bytes.Buffer, m map[string]interface{}, indent int) {
+	for _, key := range sortedKeys(m) {
+		buf.WriteString(strings.Repeat(" ", indent) + yamlScalar(key) + ":")
+		writeYAMLValue(buf, m[key], indent+2)
+	}
+}
+
+func writeYAMLList(buf *bytes.Buffer, list []interface{}, indent int) {
+	for _, item := range list {
+		buf.WriteString(strings.Repeat(" ", indent) + "-")
+		writeYAMLValue(buf, item, indent+2)
+	}
+}
+
+// writeYAMLValue writes a value after its "key:" or "-"
+func writeYAMLValue(buf *bytes.Buffer, value interface{}, indent int) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 {
+			buf.WriteString(" {}\n")
+			return
+		}
+		buf.WriteByte('\n')
+		writeYAMLMap(buf, v, indent)
+	case []interface{}:
+		if len(v) == 0 {
+			buf.WriteString(" []\n")
+			return
+		}
+		buf.WriteByte('\n')
+		writeYAMLList(buf, v, indent)
+	default:
+		buf.WriteString(" " + yamlScalar(v) + "\n")
+	}
+}
+
+// yamlReserved are plain scalars YAML would not read back as strings
+var yamlReserved = map[string]bool{"": true, "~": true, "null": true, "true": true, "false": true, "yes": true, "no": true, "on": true, "off": true, "y": true, "n": true}
+
+// yamlScalar formats a scalar, quoting strings unless they are plainly safe
+func yamlScalar(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(v)
+	case json.Number:
+		return v.String()
+	case string:
+		if yamlReserved[strings.ToLower(v)] || !yamlPlain(v) {
+			return strconv.Quote(v)
+		}
+		return v
+	}
+	return strconv.Quote(fmt.Sprint(value))
+}
+
+func yamlPlain(s string) bool {
+	if s != strings.TrimSpace(s) {
+		return false
+	}
+	for i, r := range s {
+		switch {
+		case unicode.IsLetter(r) || r == '_':
+		case i > 0 && (unicode.IsDigit(r) || strings.ContainsRune(" ./-", r)):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// SetRouteFormats restricts the response formats a handler route may be
+// negotiated into. cFormats is a JSON array of "json", "xml", "msgpack" and
+// "yaml"; an empty array allows all of them.
+//
+//export SetRouteFormats
+func SetRouteFormats(cPath *C.char, cMethod *C.char, cFormats *C.char) int {
+	if cPath == nil || cMethod == nil || cFormats == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in SetRouteFormats")
+	}
+	path := C.GoString(cPath)
+	method := strings.ToUpper(C.GoString(cMethod))
+	var formats []string
+	if err := json.Unmarshal([]byte(C.GoString(cFormats)), &formats); err != nil {
+		return exportError(exportInvalid, "Invalid route formats", "path", path, "method", method, "error", err)
+	}
+	for i, format := range formats {
+		formats[i] = strings.ToLower(format)
+		if !knownFormat(formats[i]) {
+			return exportError(exportInvalid, "Unknown response format", "path", path, "method", method, "format", format)
+		}
+	}
+
+	routesMu.Lock()
+	key := path + method
+	route, exists := routes[key]
+	if !exists {
+		routesMu.Unlock()
+		return exportError(exportNotFound, "Cannot set formats, route not found", "key", key)
+	}
+	route.Formats = formats
+	routes[key] = route
+	routesMu.Unlock()
+
+	slog.Info("Route formats set", "key", key, "formats", strings.Join(formats, ","))
+	auditConfigChange("SetRouteFormats", map[string]string{"path": path, "method": method, "formats": strings.Join(formats, ",")})
+
+	return 0
+}
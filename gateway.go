@@ -0,0 +1,695 @@
+package main
+
+import (
+	"C"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// grpcHTTPStatus maps gRPC status codes to the HTTP status transcoded
+// routes answer with, as grpc-gateway does
+var grpcHTTPStatus = map[int]int{
+	grpcOK:                http.StatusOK,
+	grpcCanceled:          499,
+	grpcUnknown:           http.StatusInternalServerError,
+	3:                     http.StatusBadRequest, // INVALID_ARGUMENT
+	grpcDeadlineExceeded:  http.StatusGatewayTimeout,
+	5:                     http.StatusNotFound, // NOT_FOUND
+	6:                     http.StatusConflict, // ALREADY_EXISTS
+	7:                     http.StatusForbidden,
+	grpcResourceExhausted: http.StatusTooManyRequests,
+	9:                     http.StatusBadRequest, // FAILED_PRECONDITION
+	10:                    http.StatusConflict,   // ABORTED
+	11:                    http.StatusBadRequest, // OUT_OF_RANGE
+	grpcUnimplemented:     http.StatusNotImplemented,
+	grpcInternal:          http.StatusInternalServerError,
+	grpcUnavailable:       http.StatusServiceUnavailable,
+	15:                    http.StatusInternalServerError, // DATA_LOSS
+	16:                    http.StatusUnauthorized,
+}
+
+// gatewayMetadataPrefix marks headers passed to and from the gRPC call as
+// metadata, following grpc-gateway
+const gatewayMetadataPrefix = "Grpc-Metadata-"
+
+// GatewayOptions configures RegisterGRPCGateway
+type GatewayOptions struct {
+	Backend   string   `json:"backend"`    // gRPC server URL, http:// for h2c or https://; empty calls RegisterGRPCHandler callbacks
+	TimeoutMs int      `json:"timeout_ms"` // deadline of each call; 0 leaves it to the route timeout
+	Services  []string `json:"services"`   // services to expose; empty exposes every annotated one
+}
+
+// gatewayBinding transcodes one REST route to a gRPC method
+type gatewayBinding struct {
+	fullMethod string
+	method     grpcMethod
+	rule       httpRule
+	set        *descriptorSet
+	variables  []pathVariable
+	backend    *url.URL // nil calls host handlers
+	client     *http.Client
+	timeout    time.Duration
+}
+
+// pathVariable is a path template variable. Its value is rebuilt from
+// parts: literal segments, and {param} or *param references to the route
+// parameters capturing its wildcards.
+type pathVariable struct {
+	field string
+	parts []string
+}
+
+// value rebuilds the variable's value from the captured route parameters
+func (v pathVariable) value(params map[string]string) string {
+	values := make([]string, len(v.parts))
+	for i, part := range v.parts {
+		if name, ok := paramName(part); ok {
+			values[i] = params[name]
+		} else if name, ok := wildcardName(part); ok {
+			values[i] = params[name]
+		} else {
+			values[i] = part
+		}
+	}
+	return strings.Join(values, "/")
+}
+
+// gatewayRoutePath converts a google.api.http path template to a route
+// path. A variable's * segments become {param} segments and a trailing **
+// a *param wildcard, so the router checks its pattern. Custom verbs are not
+// supported.
+func gatewayRoutePath(template string) (string, []pathVariable, error) {
+	if !strings.HasPrefix(template, "/") {
+		return "", nil, fmt.Errorf("path %q must start with /", template)
+	}
+	var segments []string
+	var variables []pathVariable
+	rest := template[1:]
+	for rest != "" {
+		if rest[0] == '{' {
+			end := strings.IndexByte(rest, '}')
+			if end < 0 {
+				return "", nil, fmt.Errorf("unclosed variable in %q", template)
+			}
+			field, pattern, _ := strings.Cut(rest[1:end], "=")
+			rest = rest[end+1:]
+			if pattern == "" {
+				pattern = "*"
+			}
+			v := pathVariable{field: field}
+			patternSegments := strings.Split(pattern, "/")
+			stars := strings.Count(pattern, "*") - 2*strings.Count(pattern, "**")
+			for i, seg := range patternSegments {
+				part := seg
+				switch seg {
+				case "*":
+					name := field
+					if stars > 1 {
+						name = field + strconv.Itoa(i)
+					}
+					part = "{" + name + "}"
+				case "**":
+					if i != len(patternSegments)-1 || rest != "" {
+						return "", nil, fmt.Errorf("** must end the path in %q", template)
+					}
+					part = "*" + field
+				}
+				v.parts = append(v.parts, part)
+			}
+			segments = append(segments, v.parts...)
+			variables = append(variables, v)
+		} else {
+			end := strings.IndexByte(rest, '/')
+			if end < 0 {
+				end = len(rest)
+			}
+			segment := rest[:end]
+			rest = rest[end:]
+			if segment == "*" || segment == "**" {
+				return "", nil, fmt.Errorf("anonymous wildcards are not supported in %q", template)
+			}
+			segments = append(segments, segment)
+		}
+		if rest != "" {
+			if rest[0] != '/' {
+				return "", nil, fmt.Errorf("invalid path template %q", template)
+			}
+			rest = rest[1:]
+			if rest == "" {
+				segments = append(segments, "")
+			}
+		}
+	}
+	for _, segment := range segments {
+		if strings.Contains(segment, ":") {
+			return "", nil, fmt.Errorf("custom verbs are not supported in %q", template)
+		}
+	}
+	return "/" + strings.Join(segments, "/"), variables, nil
+}
+
+// fieldAt resolves a dotted field path of a message type
+func (d *descriptorSet) fieldAt(message, path string) (*protoField, bool) {
+	var f *protoField
+	for _, name := range strings.Split(path, ".") {
+		m, ok := d.messages[message]
+		if !ok {
+			return nil, false
+		}
+		if f, ok = m.field(name); !ok {
+			return nil, false
+		}
+		message = f.TypeName
+	}
+	return f, f != nil
+}
+
+// setFieldPath stores a value at a dotted field path of a JSON object
+func setFieldPath(object map[string]interface{}, path string, value interface{}) error {
+	names := strings.Split(path, ".")
+	for _, name := range names[:len(names)-1] {
+		child, exists := object[name]
+		if !exists || child == nil {
+			next := make(map[string]interface{})
+			object[name] = next
+			object = next
+			continue
+		}
+		next, ok := child.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("field %q is not a message", name)
+		}
+		object = next
+	}
+	object[names[len(names)-1]] = value
+	return nil
+}
+
+// requestMessage builds the JSON form of the request message from the body,
+// path parameters, and query string
+func (b *gatewayBinding) requestMessage(r *http.Request) (map[string]interface{}, error) {
+	message := make(map[string]interface{})
+	if b.rule.Body != "" {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		if len(bytes.TrimSpace(data)) > 0 {
+			decoder := json.NewDecoder(bytes.NewReader(data))
+			decoder.UseNumber()
+			var body interface{}
+			if err := decoder.Decode(&body); err != nil {
+				return nil, fmt.Errorf("invalid JSON body: %w", err)
+			}
+			if b.rule.Body == "*" {
+				object, ok := body.(map[string]interface{})
+				if !ok {
+					return nil, fmt.Errorf("body must be a JSON object")
+				}
+				message = object
+			} else if err := setFieldPath(message, b.rule.Body, body); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	params := PathParams(r)
+	bound := make(map[string]bool, len(b.variables))
+	for _, v := range b.variables {
+		if err := setFieldPath(message, v.field, v.value(params)); err != nil {
+			return nil, err
+		}
+		bound[v.field] = true
+	}
+	if b.rule.Body == "*" {
+		return message, nil
+	}
+	// Query parameters fill the remaining fields; unknown ones are left to
+	// middleware such as API key auth
+	for name, values := range r.URL.Query() {
+		if bound[name] || (b.rule.Body != "" && (name == b.rule.Body || strings.HasPrefix(name, b.rule.Body+"."))) {
+			continue
+		}
+		if _, ok := b.set.fieldAt(b.method.InputType, name); !ok {
+			continue
+		}
+		var value interface{} = values[0]
+		if len(values) > 1 {
+			items := make([]interface{}, len(values))
+			for i, v := range values {
+				items[i] = v
+			}
+			value = items
+		}
+		if err := setFieldPath(message, name, value); err != nil {
+			return nil, err
+		}
+	}
+	return message, nil
+}
+
+// gatewayMetadata selects the request headers passed to the call: the
+// Authorization header, Grpc-Metadata-* headers without their prefix, and
+// the request ID
+func gatewayMetadata(r *http.Request) map[string][]string {
+	metadata := make(map[string][]string)
+	for name, values := range r.Header {
+		switch {
+		case name == "Authorization":
+			metadata["authorization"] = values
+		case strings.HasPrefix(name, gatewayMetadataPrefix):
+			metadata[strings.ToLower(strings.TrimPrefix(name, gatewayMetadataPrefix))] = values
+		}
+	}
+	if info := requestInfoFrom(r); info != nil {
+		metadata["x-request-id"] = []string{info.ID}
+	}
+	return metadata
+}
+
+// call runs the method with an encoded request, returning the encoded
+// replies and the response metadata
+func (b *gatewayBinding) call(ctx context.Context, r *http.Request, message []byte) ([][]byte, map[string]string, grpcStatus) {
+	if b.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.timeout)
+		defer cancel()
+	}
+	metadata := gatewayMetadata(r)
+	if b.backend != nil {
+		return b.callBackend(ctx, metadata, message)
+	}
+
+	handler, ok := grpcHandlerFor(b.fullMethod, b.method.Service)
+	if !ok {
+		return nil, nil, grpcStatus{grpcUnimplemented, "no handler for " + b.fullMethod}
+	}
+	call := GRPCCall{
+		Method:     b.fullMethod,
+		Service:    b.method.Service,
+		RPC:        b.method.Name,
+		InputType:  b.method.InputType,
+		OutputType: b.method.OutputType,
+		Metadata:   metadata,
+		Message:    message,
+		Messages:   [][]byte{message},
+		RemoteAddr: r.RemoteAddr,
+		Deadline:   deadlineFrom(ctx),
+		ClientCert: clientCertFrom(r),
+	}
+	if info := requestInfoFrom(r); info != nil {
+		call.RequestID = info.ID
+	}
+	response, status := invokeGRPCHandler(ctx, handler, call)
+	if status != nil {
+		return nil, nil, *status
+	}
+	returned := make(map[string]string, len(response.Metadata)+len(response.Trailers))
+	for name, value := range response.Metadata {
+		returned[name] = value
+	}
+	for name, value := range response.Trailers {
+		returned[name] = value
+	}
+	return response.replies(b.method), returned, grpcStatus{response.Status, response.Error}
+}
+
+// callBackend makes the call to the configured gRPC server
+func (b *gatewayBinding) callBackend(ctx context.Context, metadata map[string][]string, message []byte) ([][]byte, map[string]string, grpcStatus) {
+	body := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(body[1:], uint32(len(message)))
+	body = append(body, message...)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.backend.JoinPath(b.fullMethod).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, grpcStatus{grpcInternal, err.Error()}
+	}
+	for name, values := range metadata {
+		req.Header[http.CanonicalHeaderKey(name)] = values
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Header.Set("Grpc-Timeout", strconv.FormatInt(max(time.Until(deadline).Milliseconds(), 1), 10)+"m")
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, nil, grpcStatus{grpcDeadlineExceeded, "deadline exceeded"}
+		}
+		return nil, nil, grpcStatus{grpcUnavailable, err.Error()}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, grpcStatus{grpcUnknown, fmt.Sprintf("backend answered HTTP %d", resp.StatusCode)}
+	}
+	replies, status := readGRPCMessages(resp.Body, defaultGRPCMaxMessage, resp.Header.Get("Grpc-Encoding"))
+	if status != nil {
+		return nil, nil, *status
+	}
+	returned := make(map[string]string)
+	for _, header := range []http.Header{resp.Header, resp.Trailer} {
+		for name, values := range header {
+			if !grpcReservedHeaders[name] && !strings.HasPrefix(name, "Grpc-") && name != "Date" && name != "Content-Length" && len(values) > 0 {
+				returned[name] = values[0]
+			}
+		}
+	}
+	// Trailers-only responses carry the status in the headers
+	code := resp.Trailer.Get("Grpc-Status")
+	msg := resp.Trailer.Get("Grpc-Message")
+	if code == "" {
+		code, msg = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+	n, err := strconv.Atoi(code)
+	if err != nil {
+		return nil, nil, grpcStatus{grpcInternal, "backend sent no grpc-status"}
+	}
+	if unescaped, err := url.PathUnescape(msg); err == nil {
+		msg = unescaped
+	}
+	return replies, returned, grpcStatus{n, msg}
+}
+
+// writeGatewayError answers with the HTTP status a gRPC code maps to
+func writeGatewayError(w http.ResponseWriter, status grpcStatus) {
+	code, ok := grpcHTTPStatus[status.code]
+	if !ok {
+		code = http.StatusInternalServerError
+	}
+	body, _ := json.Marshal(map[string]interface{}{"error": status.msg, "code": status.code})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(append(body, '\n'))
+}
+
+// responseValue decodes a reply, narrowed to the rule's response_body field
+func (b *gatewayBinding) responseValue(reply []byte) (interface{}, error) {
+	value, err := b.set.decodeMessage(b.method.OutputType, reply)
+	if err != nil || b.rule.ResponseBody == "" {
+		return value, err
+	}
+	message := b.method.OutputType
+	for _, name := range strings.Split(b.rule.ResponseBody, ".") {
+		m, ok := b.set.messages[message]
+		var f *protoField
+		if ok {
+			f, ok = m.field(name)
+		}
+		if !ok {
+			return nil, fmt.Errorf("unknown response_body %s", b.rule.ResponseBody)
+		}
+		object, _ := value.(map[string]interface{})
+		if value = object[f.JSONName]; value == nil {
+			switch {
+			case b.set.isMap(f) || (f.Type == protoTypeMessage && !f.Repeated):
+				value = map[string]interface{}{}
+			case f.Repeated:
+				value = []interface{}{}
+			default:
+				value = zeroJSON(f.Type)
+			}
+		}
+		message = f.TypeName
+	}
+	return value, nil
+}
+
+// serveGateway transcodes a REST request to its gRPC method. Server
+// streaming replies are sent as newline-delimited {"result": ...} objects.
+func serveGateway(w http.ResponseWriter, r *http.Request, route RouteInfo) {
+	b := route.Gateway
+	request, err := b.requestMessage(r)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeBodyReadError(w, err)
+			return
+		}
+		writeGatewayError(w, grpcStatus{3, err.Error()})
+		return
+	}
+	message, err := b.set.encodeMessage(b.method.InputType, request)
+	if err != nil {
+		writeGatewayError(w, grpcStatus{3, err.Error()})
+		return
+	}
+
+	replies, metadata, status := b.call(r.Context(), r, message)
+	for name, value := range metadata {
+		w.Header().Set(gatewayMetadataPrefix+name, value)
+	}
+	if status.code != grpcOK {
+		writeGatewayError(w, status)
+		return
+	}
+	values := make([]interface{}, len(replies))
+	for i, reply := range replies {
+		if values[i], err = b.responseValue(reply); err != nil {
+			slog.Error("Error decoding gRPC reply", "method", b.fullMethod, "error", err)
+			writeGatewayError(w, grpcStatus{grpcInternal, "invalid reply"})
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !b.method.ServerStreaming {
+		body, err := json.Marshal(values[0])
+		if err != nil {
+			writeGatewayError(w, grpcStatus{grpcInternal, "invalid reply"})
+			return
+		}
+		w.Write(append(body, '\n'))
+		return
+	}
+	for _, value := range values {
+		line, err := json.Marshal(map[string]interface{}{"result": value})
+		if err != nil {
+			return
+		}
+		if _, err := w.Write(append(line, '\n')); err != nil {
+			return
+		}
+	}
+}
+
+// gatewayRoute builds the route of one binding, documented from the
+// method's messages
+func gatewayRoute(path string, b *gatewayBinding, schemas map[string]map[string]interface{}) RouteInfo {
+	description := b.method.Comment
+	if description == "" {
+		description = "Transcoded to " + b.fullMethod
+	}
+	route := RouteInfo{
+		Path:        path,
+		Method:      b.rule.Method,
+		Description: description,
+		Parameters:  pathParameters(path),
+		Responses: map[int]string{
+			200: "Successful response",
+			400: "Invalid request",
+		},
+		Tags:           []string{b.method.Service},
+		ResponseModels: make(map[int]string),
+		Gateway:        b,
+	}
+	b.set.messageSchemas(b.method.InputType, schemas)
+	b.set.messageSchemas(b.method.OutputType, schemas)
+	switch b.rule.Body {
+	case "*":
+		route.RequestModel = b.method.InputType
+	case "":
+	default:
+		if f, ok := b.set.fieldAt(b.method.InputType, b.rule.Body); ok && f.Type == protoTypeMessage && !f.Repeated {
+			route.RequestModel = f.TypeName
+		}
+	}
+	response := b.method.OutputType
+	if b.rule.ResponseBody != "" {
+		response = ""
+		if f, ok := b.set.fieldAt(b.method.OutputType, b.rule.ResponseBody); ok && f.Type == protoTypeMessage && !f.Repeated {
+			response = f.TypeName
+		}
+	}
+	if response != "" && !b.method.ServerStreaming {
+		route.ResponseModels[200] = response
+	}
+
+	// Top-level scalar fields not bound elsewhere are query parameters
+	if input, ok := b.set.messages[b.method.InputType]; ok && b.rule.Body != "*" {
+		bound := make(map[string]bool, len(b.variables))
+		for _, v := range b.variables {
+			bound[v.field] = true
+		}
+		pathParams := len(route.Parameters)
+		for _, f := range input.Fields {
+			if bound[f.Name] || bound[f.JSONName] || f.Name == b.rule.Body || f.Type == protoTypeMessage {
+				continue
+			}
+			param := ParameterInfo{Name: f.Name, In: "query", Type: "string"}
+			switch f.Type {
+			case protoTypeBool:
+				param.Type = "bool"
+			case protoTypeInt32, protoTypeSint32, protoTypeSfixed32, protoTypeUint32, protoTypeFixed32:
+				param.Type = "int"
+			case protoTypeDouble, protoTypeFloat:
+				param.Type = "float"
+			case protoTypeEnum:
+				if e, ok := b.set.enums[f.TypeName]; ok {
+					param.Type, param.Enum = "enum", e.Values
+				}
+			}
+			if f.Repeated {
+				// Coercion only checks single values
+				param.Type, param.Enum = "string", nil
+			}
+			route.Parameters = append(route.Parameters, param)
+		}
+		if len(route.Parameters) > pathParams {
+			route.Responses[422] = "Validation error"
+		}
+	}
+	for code, status := range map[int]string{404: "Not found", 501: "Not implemented", 503: "Backend unavailable"} {
+		route.Responses[code] = status
+	}
+	return route
+}
+
+// RegisterGRPCGateway adds REST routes for the google.api.http annotated
+// methods of a descriptor set, transcoding JSON requests to gRPC calls.
+// Path variables and, unless the body is "*", query parameters fill the
+// request message; replies are sent as proto3 JSON, with gRPC errors mapped
+// to HTTP statuses. cOptions is a JSON GatewayOptions object and may be
+// empty: without a backend, calls go to the RegisterGRPCHandler callbacks.
+// Client-streaming methods are skipped. The request and reply messages are
+// published under components/schemas by their full names.
+//
+//export RegisterGRPCGateway
+func RegisterGRPCGateway(cDescriptorSet *C.char, cOptions *C.char) int {
+	if cDescriptorSet == nil || cOptions == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in RegisterGRPCGateway")
+	}
+	descriptorPath := C.GoString(cDescriptorSet)
+	options := C.GoString(cOptions)
+	var opts GatewayOptions
+	if options != "" {
+		if err := json.Unmarshal([]byte(options), &opts); err != nil {
+			return exportError(exportInvalid, "Invalid gateway options", "error", err)
+		}
+	}
+	if opts.TimeoutMs < 0 {
+		return exportError(exportInvalid, "Invalid gateway options", "timeout_ms", opts.TimeoutMs)
+	}
+	var backend *url.URL
+	var client *http.Client
+	if opts.Backend != "" {
+		var err error
+		backend, err = url.Parse(opts.Backend)
+		if err != nil || (backend.Scheme != "http" && backend.Scheme != "https") || backend.Host == "" {
+			return exportError(exportInvalid, "Invalid gateway backend URL", "backend", opts.Backend)
+		}
+		protocols := new(http.Protocols)
+		if backend.Scheme == "http" {
+			protocols.SetUnencryptedHTTP2(true)
+		} else {
+			protocols.SetHTTP2(true)
+		}
+		client = &http.Client{Transport: &http.Transport{Protocols: protocols}}
+	}
+	set, err := loadDescriptorSet(descriptorPath)
+	if err != nil {
+		return exportError(exportFailed, "Cannot load gateway descriptor set", "path", descriptorPath, "error", err)
+	}
+	exposed := make(map[string]bool, len(opts.Services))
+	for _, service := range opts.Services {
+		exposed[service] = true
+	}
+
+	fullMethods := make([]string, 0, len(set.methods))
+	for fullMethod := range set.methods {
+		fullMethods = append(fullMethods, fullMethod)
+	}
+	sort.Strings(fullMethods)
+	schemas := make(map[string]map[string]interface{})
+	var registered []RouteInfo
+	for _, fullMethod := range fullMethods {
+		method := set.methods[fullMethod]
+		if len(method.Rules) == 0 || (len(exposed) > 0 && !exposed[method.Service]) {
+			continue
+		}
+		if method.ClientStreaming {
+			slog.Warn("Skipping client-streaming method in gateway", "method", fullMethod)
+			continue
+		}
+		for _, rule := range method.Rules {
+			path, variables, err := gatewayRoutePath(rule.Path)
+			if err == nil && rule.Method == "" {
+				err = fmt.Errorf("binding has no HTTP method")
+			}
+			for _, v := range variables {
+				if _, ok := set.fieldAt(method.InputType, v.field); !ok && err == nil {
+					err = fmt.Errorf("unknown path field %s", v.field)
+				}
+			}
+			if err != nil {
+				slog.Error("Skipping gateway binding", "method", fullMethod, "path", rule.Path, "error", err)
+				continue
+			}
+			b := &gatewayBinding{
+				fullMethod: fullMethod,
+				method:     method,
+				rule:       rule,
+				set:        set,
+				variables:  variables,
+				backend:    backend,
+				client:     client,
+				timeout:    time.Duration(opts.TimeoutMs) * time.Millisecond,
+			}
+			registered = append(registered, gatewayRoute(path, b, schemas))
+		}
+	}
+	if len(registered) == 0 {
+		return exportError(exportFailed, "Descriptor set has no google.api.http bindings to register", "path", descriptorPath)
+	}
+
+	modelsMu.Lock()
+	for name, schema := range schemas {
+		models[name] = schema
+	}
+	modelsMu.Unlock()
+	routesMu.Lock()
+	for _, route := range registered {
+		key := route.Path + route.Method
+		if existing, exists := routes[key]; exists && existing.Gateway == nil {
+			slog.Warn("Gateway route shadows a registered route, skipping", "key", key)
+			continue
+		}
+		routes[key] = route
+		routeTree.insert(route.Path, route.Method, key)
+		slog.Info("Gateway route registered", "key", key, "grpc_method", route.Gateway.fullMethod)
+	}
+	routesMu.Unlock()
+	invalidateOpenAPICache()
+
+	target := "handlers"
+	if backend != nil {
+		target = backend.Redacted()
+	}
+	auditConfigChange("RegisterGRPCGateway", map[string]string{
+		"descriptor_set": descriptorPath,
+		"backend":        target,
+		"routes":         strconv.Itoa(len(registered)),
+	})
+
+	return 0
+}
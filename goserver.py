@@ -1,33 +1,1617 @@
-from ctypes import cdll, c_char_p, c_int
+from ctypes import CFUNCTYPE, POINTER, addressof, cdll, c_char_p, c_double, c_int, c_int64, c_void_p, create_string_buffer, string_at
+import base64
+import inspect
+import json
 import os
+import threading
+
+# GOSERVER_ABI_VERSION in libgoserver.h
+ABI_VERSION = 3
+
+# Exports returning 0 or a negative GOSERVER_ERR_* status, raised as GoServerError
+STATUS_EXPORTS = (
+    "AddResponseHeader", "ClearSession", "ConfigureAdmissionQueue", "ConfigureCache",
+    "ConfigureCookieKeys", "ConfigureETags", "ConfigureGRPC", "ConfigureGraphQL",
+    "ConfigureHTTP2", "ConfigureHTTP3", "ConfigureHealthChecks", "ConfigureMaxConnectionAge",
+    "ConfigureOpenAPIInfoLocalized", "ConfigureReDoc", "ConfigureRequestID", "ConfigureRouter",
+    "ConfigureShutdownDrain", "ConfigureSwaggerUI", "ConfigureTaskBackend",
+    "ConfigureTaskContext", "ConfigureTaskPool", "ConfigureTaskRetry", "ConfigureTaskTimeout",
+    "ConfigureTrustedProxies", "ConfigureValidatorCache", "ConfigureVersioning",
+    "ConfigureWebhooks", "DeleteRequestValue", "DeleteSessionValue", "EnableClientAuth",
+    "EnableClientAuthFromPEM", "EnableDebugEndpoints", "EnableHTTPSRedirect", "EnableMetrics",
+    "EnableSelfSignedTLS", "EnableTLS", "EnableTLSFromPEM", "EnableTracing",
+    "InvalidateResponseValidators", "PushEvent", "RegisterAPIKey", "RegisterDatabase",
+    "RegisterDependency", "RegisterErrorCallback", "RegisterErrorHandler", "RegisterErrorPage",
+    "RegisterFormFields", "RegisterGRPCGateway", "RegisterGRPCHandler",
+    "RegisterGraphQLResolver", "RegisterGraphQLSchema", "RegisterGroupRoute",
+    "RegisterGroupRouteHandler", "RegisterHealthCheck", "RegisterHealthPing", "RegisterHook",
+    "RegisterLifecycleHook", "RegisterMiddleware", "RegisterMiddlewareCallback",
+    "RegisterMiddlewareWithOptions", "RegisterModel", "RegisterProvider", "RegisterProxyRoute",
+    "RegisterQueryParams", "RegisterRPCMethod", "RegisterRedirect", "RegisterRedis",
+    "RegisterRoute", "RegisterRouteDescription", "RegisterRouteFull", "RegisterRouteHandler",
+    "RegisterRouteSchema", "RegisterRouteTrailer", "RegisterRoutesJSON", "RegisterSSERoute",
+    "RegisterShutdownCallback", "RegisterShutdownHandler", "RegisterStartupHandler",
+    "RegisterStaticDir", "RegisterTag", "RegisterTask", "RegisterTaskHandler",
+    "RegisterTemplateDir", "RegisterTemplateRoute", "RegisterVersionedRoute",
+    "RegisterVersionedRouteHandler", "RegisterVirtualHostRoute",
+    "RegisterVirtualHostRouteHandler", "RegisterWebSocketRoute", "RegisterWebhook",
+    "ReplaceRoute", "RestartServer", "ScheduleTask", "SendRedirect", "SendWebSocketMessage",
+    "SetAdmissionCapacity", "SetCookie", "SetErrorFormat", "SetLogFormat", "SetLogLevel",
+    "SetLogOutput", "SetReady", "SetRequestLimits", "SetRequestValue", "SetResponseHeader",
+    "SetRouteBodyLimit", "SetRouteBodyStream", "SetRouteCORS", "SetRouteCache",
+    "SetRouteCompression", "SetRouteConcurrency", "SetRouteDeprecation", "SetRouteFormats",
+    "SetRouteModels", "SetRouteMultipart", "SetRouteRanges", "SetRouteRateLimit",
+    "SetRouteResponses", "SetRouteScopes", "SetRouteSecureHeaders", "SetRouteTags",
+    "SetRouteTask", "SetRouteTaskBackpressure", "SetRouteTimeout", "SetServerConfig",
+    "SetSessionValue", "SetValidationErrorFormat", "StartServer", "StartServerAsync",
+    "StartServerWithConfig", "StopServer", "UnregisterRoute",
+)
+
+# const char* handler(const char* request, int request_len)
+ROUTE_HANDLER = CFUNCTYPE(c_void_p, c_void_p, c_int)
+CONN_HANDLER = CFUNCTYPE(None, c_char_p, c_int, c_void_p, c_int)
+REPORT_HANDLER = CFUNCTYPE(None, c_void_p, c_int)
+UPLOAD_HANDLER = CFUNCTYPE(c_int, c_char_p, c_int, c_void_p, c_int)
+# int lifespan_handler(const char* event, int event_len), nonzero on failure
+LIFESPAN_HANDLER = CFUNCTYPE(c_int, c_void_p, c_int)
+# const char* handler(const char* task, int task_len, const char* payload, int payload_len, int* failed)
+TASK_HANDLER = CFUNCTYPE(c_void_p, c_void_p, c_int, c_void_p, c_int, POINTER(c_int))
+CONN_EVENTS = {0: "open", 1: "message", 2: "close"}
+UPLOAD_EVENTS = {0: "start", 1: "chunk", 2: "end", 3: "abort"}
+
+
+class StreamingResponse:
+    # Returned by a handler to stream chunks from an iterable; see GoServer.stream
+    def __init__(self, server, request, chunks, status=200, headers=None):
+        self.server = server
+        self.request = request
+        self.chunks = chunks
+        self.status = status
+        self.headers = headers or {}
+
+    def start(self):
+        def send():
+            try:
+                for chunk in self.chunks:
+                    if not self.server.write_chunk(self.request, chunk):
+                        return
+            finally:
+                self.server.finish_response(self.request)
+        threading.Thread(target=send, daemon=True).start()
+
+class GoServerError(Exception):
+    # Raised when an export reports a failure; code is its GOSERVER_ERR_* status
+    ERR_FAILED, ERR_INVALID, ERR_NOT_FOUND = -1, -2, -3
+
+    def __init__(self, code, message):
+        super().__init__(message)
+        self.code = code
+        self.message = message
+
+
+class RPCError(Exception):
+    # Raised by an rpc_method to answer with a JSON-RPC error object
+    def __init__(self, code, message, data=None):
+        super().__init__(message)
+        self.code = code
+        self.message = message
+        self.data = data
+
 
 class GoServer:
     def __init__(self):
+        # Keep callbacks alive for the lifetime of the library
+        self._callbacks = []
+        # Last response buffer per OS thread; Go copies it before that thread
+        # runs another callback. threading.local() would not survive the call
+        # because ctypes tears down its thread state on Go-owned threads.
+        self._responses = {}
         try:
             self.lib = cdll.LoadLibrary("./libgoserver.so")
-            # No need to set argtypes for uintptr explicitly as c_char_p works as a pointer
+            self.lib.GetABIVersion.restype = c_int
+            if self.lib.GetABIVersion() != ABI_VERSION:
+                raise OSError(f"libgoserver.so has ABI version {self.lib.GetABIVersion()}, expected {ABI_VERSION}")
+            self.lib.GetLastError.restype = c_void_p
+            for name in STATUS_EXPORTS:
+                export = getattr(self.lib, name)
+                export.restype = c_int
+                export.errcheck = self._check_status
+            # Strings are char* in the C ABI, which c_char_p maps to directly
             self.lib.RegisterRoute.argtypes = [c_char_p, c_char_p, c_char_p, c_char_p]
+            self.lib.UnregisterRoute.argtypes = [c_char_p, c_char_p]
+            self.lib.ReplaceRoute.argtypes = [c_char_p, c_char_p, c_char_p, c_char_p]
+            self.lib.RegisterRouteFull.argtypes = [c_char_p, c_char_p, c_char_p, c_char_p, c_int, c_char_p, c_char_p]
+            self.lib.RegisterRoutesJSON.argtypes = [c_char_p]
+            self.lib.RegisterQueryParams.argtypes = [c_char_p, c_char_p, c_char_p]
+            self.lib.RegisterFormFields.argtypes = [c_char_p, c_char_p, c_char_p]
+            self.lib.ConfigureSwaggerUI.argtypes = [c_char_p]
+            self.lib.RegisterHealthCheck.argtypes = [c_char_p, ROUTE_HANDLER]
+            self.lib.RegisterHealthPing.argtypes = [c_char_p, c_char_p]
+            self.lib.ConfigureHealthChecks.argtypes = [c_char_p]
+            self.lib.SetReady.argtypes = [c_int]
+            self.lib.ConfigureRequestID.argtypes = [c_char_p, c_int]
+            self.lib.ConfigureTrustedProxies.argtypes = [c_char_p]
+            self.lib.EnableDebugEndpoints.argtypes = [c_int, c_char_p]
+            self.lib.EnableTracing.argtypes = [c_char_p, c_char_p]
+            self.lib.ConfigureHTTP2.argtypes = [c_char_p]
+            self.lib.ConfigureHTTP3.argtypes = [c_char_p]
+            self.lib.ConfigureGRPC.argtypes = [c_char_p]
+            self.lib.RegisterGRPCHandler.argtypes = [c_char_p, ROUTE_HANDLER]
+            self.lib.RegisterGRPCGateway.argtypes = [c_char_p, c_char_p]
+            self.lib.RegisterGraphQLSchema.argtypes = [c_char_p]
+            self.lib.RegisterGraphQLResolver.argtypes = [c_char_p, ROUTE_HANDLER]
+            self.lib.ConfigureGraphQL.argtypes = [c_char_p]
+            self.lib.RegisterRPCMethod.argtypes = [c_char_p, ROUTE_HANDLER]
+            self.lib.RegisterProxyRoute.argtypes = [c_char_p, c_char_p, c_char_p]
+            self.lib.ConfigureReDoc.argtypes = [c_char_p]
+            self.lib.RegisterModel.argtypes = [c_char_p, c_char_p]
+            self.lib.SetRouteModels.argtypes = [c_char_p, c_char_p, c_char_p, c_char_p]
+            self.lib.SetRouteResponses.argtypes = [c_char_p, c_char_p, c_int, c_char_p]
+            self.lib.SetRouteDeprecation.argtypes = [c_char_p, c_char_p, c_char_p]
+            self.lib.RegisterStaticDir.argtypes = [c_char_p, c_char_p, c_char_p]
             self.lib.RegisterMiddleware.argtypes = [c_char_p, c_int]
+            self.lib.RegisterMiddlewareWithOptions.argtypes = [c_char_p, c_char_p]
+            self.lib.RegisterMiddlewareCallback.argtypes = [c_char_p, ROUTE_HANDLER, ROUTE_HANDLER]
+            self.lib.SetRouteCORS.argtypes = [c_char_p, c_char_p, c_char_p]
+            self.lib.SetRouteSecureHeaders.argtypes = [c_char_p, c_char_p, c_char_p]
+            self.lib.SetRouteCache.argtypes = [c_char_p, c_char_p, c_int, c_char_p]
+            self.lib.SetRouteTimeout.argtypes = [c_char_p, c_char_p, c_int]
+            self.lib.SetRouteConcurrency.argtypes = [c_char_p, c_char_p, c_int]
+            self.lib.ConfigureCache.argtypes = [c_char_p]
+            self.lib.InvalidateCache.argtypes = [c_char_p]
+            self.lib.RegisterAPIKey.argtypes = [c_char_p, c_char_p]
+            self.lib.SetRouteScopes.argtypes = [c_char_p, c_char_p, c_char_p]
+            self.lib.RegisterTag.argtypes = [c_char_p, c_char_p]
+            self.lib.SetRouteTags.argtypes = [c_char_p, c_char_p, c_char_p]
+            self.lib.SetRouteRateLimit.argtypes = [c_char_p, c_char_p, c_double, c_int]
             self.lib.RegisterDependency.argtypes = [c_char_p, c_char_p]
+            self.lib.RegisterRouteTrailer.argtypes = [c_char_p, c_char_p, c_char_p, c_char_p]
+            self.lib.ConfigureMaxConnectionAge.argtypes = [c_int]
+            self.lib.RegisterRouteDescription.argtypes = [c_char_p, c_char_p, c_char_p, c_char_p]
+            self.lib.ConfigureOpenAPIInfoLocalized.argtypes = [c_char_p, c_char_p, c_char_p]
+            self.lib.WriteOpenAPIFile.argtypes = [c_char_p, c_char_p, c_char_p]
+            self.lib.WriteOpenAPIFile.restype = c_int
+            self.lib.GetServerStats.restype = c_void_p
+            self.lib.GetConfigAuditLog.restype = c_void_p
+            self.lib.SetRouteCompression.argtypes = [c_char_p, c_char_p, c_int]
+            self.lib.SetRouteRanges.argtypes = [c_char_p, c_char_p, c_int]
+            self.lib.ConfigureAdmissionQueue.argtypes = [c_int, c_int]
+            self.lib.SetAdmissionCapacity.argtypes = [c_int]
+            self.lib.FreeString.argtypes = [c_void_p]
+            self.lib.SetServerConfig.argtypes = [c_char_p, c_int, c_int, c_int, c_int]
+            self.lib.LoadConfig.argtypes = [c_char_p]
+            self.lib.LoadConfig.restype = c_int
+            self.lib.ReloadConfig.restype = c_int
+            self.lib.SetJSONEngine.argtypes = [c_char_p]
+            self.lib.SetJSONEngine.restype = c_int
+            self.lib.GetJSONEngines.restype = c_void_p
+            self.lib.SetRequestLimits.argtypes = [c_int64, c_int, c_int]
+            self.lib.SetRouteBodyLimit.argtypes = [c_char_p, c_char_p, c_int64]
+            self.lib.EnableTLS.argtypes = [c_char_p, c_char_p]
+            self.lib.EnableTLSFromPEM.argtypes = [c_char_p, c_int, c_char_p, c_int]
+            self.lib.EnableClientAuth.argtypes = [c_char_p, c_char_p]
+            self.lib.EnableClientAuthFromPEM.argtypes = [c_char_p, c_int, c_char_p]
+            self.lib.EnableSelfSignedTLS.argtypes = [c_char_p]
+            self.lib.EnableHTTPSRedirect.argtypes = [c_int]
+            self.lib.RegisterRouteSchema.argtypes = [c_char_p, c_char_p, c_char_p]
+            self.lib.SetValidationErrorFormat.argtypes = [c_char_p, c_char_p]
+            self.lib.SetErrorFormat.argtypes = [c_char_p, c_char_p]
+            self.lib.ConfigureRouter.argtypes = [c_char_p]
+            self.lib.RegisterRedirect.argtypes = [c_char_p, c_char_p, c_int]
+            self.lib.SendRedirect.argtypes = [c_char_p, c_char_p, c_int]
+            self.lib.ConfigureETags.argtypes = [c_char_p]
+            self.lib.SetResponseValidators.argtypes = [c_char_p, c_char_p, c_char_p]
+            self.lib.SetResponseValidators.restype = c_int
+            self.lib.ConfigureValidatorCache.argtypes = [c_int]
+            self.lib.InvalidateResponseValidators.argtypes = [c_char_p]
+            self.lib.GetTaskStatus.argtypes = [c_char_p]
+            self.lib.GetTaskStatus.restype = c_void_p
+            self.lib.ConfigureTaskPool.argtypes = [c_int, c_int]
+            self.lib.SetRouteTaskBackpressure.argtypes = [c_char_p, c_char_p, c_char_p]
+            self.lib.RegisterTask.argtypes = [c_char_p, ROUTE_HANDLER]
+            self.lib.ScheduleTask.argtypes = [c_char_p, c_char_p, c_char_p]
+            self.lib.RunTaskAt.argtypes = [c_int64, c_char_p]
+            self.lib.RunTaskAt.restype = c_void_p
+            self.lib.GetTaskSchedules.restype = c_void_p
+            self.lib.ConfigureTaskRetry.argtypes = [c_char_p, c_char_p]
+            self.lib.DrainDeadTasks.restype = c_void_p
+            self.lib.ConfigureTaskBackend.argtypes = [c_char_p, c_char_p]
+            self.lib.RegisterTaskHandler.argtypes = [c_char_p, TASK_HANDLER]
+            self.lib.ConfigureTaskTimeout.argtypes = [c_char_p, c_int]
+            self.lib.ConfigureTaskContext.argtypes = [c_char_p, c_char_p]
+            self.lib.SubmitTask.argtypes = [c_char_p, c_char_p, c_int]
+            self.lib.SubmitTask.restype = c_void_p
+            self.lib.SubmitTaskForRequest.argtypes = [c_char_p, c_char_p, c_char_p, c_int]
+            self.lib.SubmitTaskForRequest.restype = c_void_p
+            self.lib.RegisterWebhook.argtypes = [c_char_p, c_char_p, c_char_p]
+            self.lib.ConfigureWebhooks.argtypes = [c_char_p]
+            self.lib.EmitEvent.argtypes = [c_char_p, c_char_p]
+            self.lib.EmitEvent.restype = c_void_p
+            self.lib.RegisterDatabase.argtypes = [c_char_p, c_char_p, c_char_p, c_char_p]
+            self.lib.QueryDatabase.argtypes = [c_char_p, c_char_p, c_char_p]
+            self.lib.QueryDatabase.restype = c_void_p
+            self.lib.ExecDatabase.argtypes = [c_char_p, c_char_p, c_char_p]
+            self.lib.ExecDatabase.restype = c_void_p
+            self.lib.RegisterRedis.argtypes = [c_char_p, c_char_p, c_char_p]
+            self.lib.RedisGet.argtypes = [c_char_p, c_char_p]
+            self.lib.RedisGet.restype = c_void_p
+            self.lib.RedisSet.argtypes = [c_char_p, c_char_p, c_char_p, c_int]
+            self.lib.RedisSet.restype = c_int
+            self.lib.RedisDel.argtypes = [c_char_p, c_char_p]
+            self.lib.RedisDel.restype = c_int
+            self.lib.SetRouteTask.argtypes = [c_char_p, c_char_p, c_char_p]
+            self.lib.RegisterTemplateDir.argtypes = [c_char_p, c_char_p]
+            self.lib.RegisterTemplateRoute.argtypes = [c_char_p, c_char_p, c_char_p, c_char_p, ROUTE_HANDLER]
+            self.lib.GetSessionValue.argtypes = [c_char_p, c_char_p]
+            self.lib.GetSessionValue.restype = c_void_p
+            self.lib.SetSessionValue.argtypes = [c_char_p, c_char_p, c_char_p]
+            self.lib.DeleteSessionValue.argtypes = [c_char_p, c_char_p]
+            self.lib.ClearSession.argtypes = [c_char_p]
+            self.lib.WriteResponseChunk.argtypes = [c_char_p, c_char_p, c_int]
+            self.lib.WriteResponseChunk.restype = c_int
+            self.lib.FinishResponse.argtypes = [c_char_p]
+            self.lib.FinishResponse.restype = c_int
+            self.lib.SetResponseTrailer.argtypes = [c_char_p, c_char_p, c_char_p]
+            self.lib.SetResponseTrailer.restype = c_int
+            self.lib.GetRequestValue.argtypes = [c_char_p, c_char_p]
+            self.lib.GetRequestValue.restype = c_void_p
+            self.lib.SetRequestValue.argtypes = [c_char_p, c_char_p, c_char_p]
+            self.lib.SetResponseHeader.argtypes = [c_char_p, c_char_p, c_char_p]
+            self.lib.AddResponseHeader.argtypes = [c_char_p, c_char_p, c_char_p]
+            self.lib.ReadRequestHeader.argtypes = [c_char_p, c_char_p]
+            self.lib.ReadRequestHeader.restype = c_void_p
+            self.lib.ConfigureCookieKeys.argtypes = [c_char_p]
+            self.lib.SetCookie.argtypes = [c_char_p, c_char_p, c_char_p, c_char_p]
+            self.lib.GetCookie.argtypes = [c_char_p, c_char_p, c_char_p]
+            self.lib.GetCookie.restype = c_void_p
+            self.lib.DeleteRequestValue.argtypes = [c_char_p, c_char_p]
+            self.lib.RegisterErrorHandler.argtypes = [c_int, ROUTE_HANDLER]
+            self.lib.RegisterErrorPage.argtypes = [c_int, c_char_p]
+            self.lib.SetRouteFormats.argtypes = [c_char_p, c_char_p, c_char_p]
+            self.lib.CreateRouteGroup.argtypes = [c_char_p, c_char_p, c_char_p]
+            self.lib.CreateRouteGroup.restype = c_int
+            self.lib.RegisterGroupRoute.argtypes = [c_int, c_char_p, c_char_p, c_char_p, c_char_p]
+            self.lib.RegisterGroupRouteHandler.argtypes = [c_int, c_char_p, c_char_p, c_char_p, ROUTE_HANDLER]
+            self.lib.ConfigureVersioning.argtypes = [c_char_p]
+            self.lib.RegisterVersionedRoute.argtypes = [c_char_p, c_char_p, c_char_p, c_char_p, c_char_p]
+            self.lib.RegisterVersionedRouteHandler.argtypes = [c_char_p, c_char_p, c_char_p, c_char_p, ROUTE_HANDLER]
+            self.lib.RegisterVirtualHost.argtypes = [c_char_p, c_char_p]
+            self.lib.RegisterVirtualHost.restype = c_int
+            self.lib.RegisterVirtualHostRoute.argtypes = [c_int, c_char_p, c_char_p, c_char_p, c_char_p]
+            self.lib.RegisterVirtualHostRouteHandler.argtypes = [c_int, c_char_p, c_char_p, c_char_p, ROUTE_HANDLER]
+            self.lib.EnableMetrics.argtypes = [c_int]
+            self.lib.SetLogLevel.argtypes = [c_char_p]
+            self.lib.SetLogFormat.argtypes = [c_char_p]
+            self.lib.SetLogOutput.argtypes = [c_char_p, c_int, c_int, c_int]
+            self.lib.ReloadServer.argtypes = [c_char_p, c_int]
+            self.lib.RegisterRouteHandler.argtypes = [c_char_p, c_char_p, c_char_p, ROUTE_HANDLER]
+            self.lib.SetRouteMultipart.argtypes = [c_char_p, c_char_p, c_char_p, UPLOAD_HANDLER]
+            self.lib.SetRouteBodyStream.argtypes = [c_char_p, c_char_p, c_char_p, UPLOAD_HANDLER]
+            self.lib.ReadRequestChunk.argtypes = [c_char_p, c_void_p, c_int]
+            self.lib.ReadRequestChunk.restype = c_int
+            self.lib.RegisterWebSocketRoute.argtypes = [c_char_p, c_char_p, CONN_HANDLER]
+            self.lib.SendWebSocketMessage.argtypes = [c_char_p, c_char_p, c_int]
+            self.lib.RegisterErrorCallback.argtypes = [REPORT_HANDLER]
+            self.lib.RegisterShutdownCallback.argtypes = [REPORT_HANDLER]
+            self.lib.ConfigureShutdownDrain.argtypes = [c_int]
+            self.lib.RegisterSSERoute.argtypes = [c_char_p, c_char_p, CONN_HANDLER]
+            self.lib.PushEvent.argtypes = [c_char_p, c_char_p, c_char_p]
+            self.lib.RegisterProvider.argtypes = [c_char_p, c_char_p, c_int, ROUTE_HANDLER]
+            self.lib.ResolveDependency.argtypes = [c_char_p, c_char_p, c_char_p]
+            self.lib.ResolveDependency.restype = c_void_p
+            self.lib.RegisterLifecycleHook.argtypes = [c_char_p, c_int, REPORT_HANDLER]
+            self.lib.RegisterHook.argtypes = [c_char_p, REPORT_HANDLER]
+            self.lib.RegisterStartupHandler.argtypes = [LIFESPAN_HANDLER, c_int]
+            self.lib.RegisterShutdownHandler.argtypes = [LIFESPAN_HANDLER, c_int]
         except OSError as e:
             raise RuntimeError(f"Failed to load libgoserver.so: {e}")
 
-    def route(self, path, method="GET", description=""):
+    def _check_status(self, result, func, args):
+        if result != 0:
+            raise GoServerError(result, self.last_error() or f"{func.__name__} failed")
+        return result
+
+    def last_error(self):
+        # Message of the most recent failed call
+        return self._take_string(self.lib.GetLastError())
+
+    def route(self, path, method="GET", description="", descriptions=None, status=0, content_type="", headers=None):
+        def decorator(func):
+            if status or content_type or headers:
+                self.lib.RegisterRouteFull(
+                    path.encode('utf-8'),
+                    method.encode('utf-8'),
+                    func().encode('utf-8'),
+                    description.encode('utf-8'),
+                    c_int(status),
+                    content_type.encode('utf-8'),
+                    json.dumps(headers or {}).encode('utf-8')
+                )
+            else:
+                self.lib.RegisterRoute(
+                    path.encode('utf-8'),
+                    method.encode('utf-8'),
+                    func().encode('utf-8'),
+                    description.encode('utf-8')
+                )
+            # Localized descriptions keyed by language tag, e.g. {"fr": "..."}
+            for lang, text in (descriptions or {}).items():
+                self.lib.RegisterRouteDescription(
+                    path.encode('utf-8'),
+                    method.encode('utf-8'),
+                    lang.encode('utf-8'),
+                    text.encode('utf-8')
+                )
+            return func
+        return decorator
+
+    def routes(self, manifest):
+        # manifest is a list of route dicts or {"routes": [...]}
+        if not isinstance(manifest, (str, bytes)):
+            manifest = json.dumps(manifest)
+        if isinstance(manifest, str):
+            manifest = manifest.encode('utf-8')
+        self.lib.RegisterRoutesJSON(manifest)
+
+    def static(self, prefix, directory, **options):
+        # Options: cache_control, index_cache_control, index, listing, spa, precompressed
+        self.lib.RegisterStaticDir(
+            prefix.encode('utf-8'),
+            directory.encode('utf-8'),
+            json.dumps(options).encode('utf-8') if options else b""
+        )
+
+    def unregister(self, path, method="GET"):
+        self.lib.UnregisterRoute(path.encode('utf-8'), method.encode('utf-8'))
+
+    def replace(self, path, message, method="GET", description=""):
+        self.lib.ReplaceRoute(
+            path.encode('utf-8'),
+            method.encode('utf-8'),
+            message.encode('utf-8'),
+            description.encode('utf-8')
+        )
+
+    @staticmethod
+    def _encode_response(result):
+        # Handlers may return a body, (body, status) or (body, status, headers); a bare
+        # body goes out with the route's success status, 200 unless set with responses()
+        status, headers = 0, {}
+        if isinstance(result, StreamingResponse):
+            result.start()
+            return json.dumps({"status": result.status, "headers": result.headers, "stream": True}).encode('utf-8')
+        if isinstance(result, tuple):
+            if len(result) > 2:
+                headers = result[2]
+            result, status = result[0], result[1]
+        response = {"status": status, "headers": headers}
+        if isinstance(result, bytes):
+            response["body_base64"] = base64.b64encode(result).decode('ascii')
+        elif isinstance(result, str):
+            response["body"] = result
+        else:
+            response["body"] = json.dumps(result)
+        return json.dumps(response).encode('utf-8')
+
+    def _handler_callback(self, func):
+        def callback(request_ptr, request_len):
+            try:
+                request = json.loads(string_at(request_ptr, request_len))
+                request["body"] = base64.b64decode(request.get("body") or "")
+                payload = self._encode_response(func(request))
+            except Exception as e:
+                payload = json.dumps({"status": 500, "body": json.dumps({"error": str(e)})}).encode('utf-8')
+            buf = create_string_buffer(payload)
+            self._responses[threading.get_ident()] = buf
+            return addressof(buf)
+
+        cb = ROUTE_HANDLER(callback)
+        self._callbacks.append(cb)
+        return cb
+
+    def handler(self, path, method="GET", description=""):
+        # The decorated function receives the request dict and returns the response
         def decorator(func):
-            self.lib.RegisterRoute(
+            self.lib.RegisterRouteHandler(
                 path.encode('utf-8'),
                 method.encode('utf-8'),
-                func().encode('utf-8'),
-                description.encode('utf-8')
+                description.encode('utf-8'),
+                self._handler_callback(func)
+            )
+            return func
+        return decorator
+
+    # Session helpers take the handler's request dict (or its request_id) and need
+    # the "session" middleware; changes are saved when the handler returns
+    @staticmethod
+    def _request_id(request):
+        if isinstance(request, dict):
+            request = request["request_id"]
+        return request.encode('utf-8')
+
+    def session_get(self, request, key, default=None):
+        value = self._take_string(self.lib.GetSessionValue(self._request_id(request), key.encode('utf-8')))
+        return default if value is None else json.loads(value)
+
+    def session_set(self, request, key, value):
+        self.lib.SetSessionValue(self._request_id(request), key.encode('utf-8'), json.dumps(value).encode('utf-8'))
+
+    def session_delete(self, request, key):
+        self.lib.DeleteSessionValue(self._request_id(request), key.encode('utf-8'))
+
+    def session_clear(self, request):
+        self.lib.ClearSession(self._request_id(request))
+
+    def stream(self, request, chunks, status=200, headers=None):
+        # Return from a handler to send each item of chunks as it is produced
+        return StreamingResponse(self, request, chunks, status, headers)
+
+    def write_chunk(self, request, data):
+        # Streams a chunk of a response the handler returned with "stream": True;
+        # returns False once the client has gone away
+        if isinstance(data, str):
+            data = data.encode('utf-8')
+        return self.lib.WriteResponseChunk(self._request_id(request), data, c_int(len(data))) == 0
+
+    def finish_response(self, request):
+        return self.lib.FinishResponse(self._request_id(request)) == 0
+
+    def set_trailer(self, request, name, value):
+        # Until finish_response for a streamed response; once its header is sent
+        # only trailers already declared can be set. Returns False otherwise
+        return self.lib.SetResponseTrailer(
+            self._request_id(request), name.encode('utf-8'), value.encode('utf-8')
+        ) == 0
+
+    def context_get(self, request, key, default=None):
+        # Values shared by middleware, handlers, and tasks of one request
+        value = self._take_string(self.lib.GetRequestValue(self._request_id(request), key.encode('utf-8')))
+        return default if value is None else json.loads(value)
+
+    def context_set(self, request, key, value):
+        self.lib.SetRequestValue(self._request_id(request), key.encode('utf-8'), json.dumps(value).encode('utf-8'))
+
+    def context_delete(self, request, key):
+        self.lib.DeleteRequestValue(self._request_id(request), key.encode('utf-8'))
+
+    # Header helpers work while the handler runs; headers in its returned dict win
+    def set_header(self, request, name, value):
+        self.lib.SetResponseHeader(self._request_id(request), name.encode('utf-8'), str(value).encode('utf-8'))
+
+    def add_header(self, request, name, value):
+        # For headers sent more than once, such as Set-Cookie
+        self.lib.AddResponseHeader(self._request_id(request), name.encode('utf-8'), str(value).encode('utf-8'))
+
+    def request_header(self, request, name, default=None):
+        value = self._take_string(self.lib.ReadRequestHeader(self._request_id(request), name.encode('utf-8')))
+        return default if value is None else value
+
+    def cookie_keys(self, keys):
+        # Secrets for signed and encrypted cookies, newest first: new cookies use keys[0], and
+        # cookies made with any listed key are still accepted, so rotate by prepending a key
+        self.lib.ConfigureCookieKeys(json.dumps(list(keys)).encode('utf-8'))
+
+    def set_cookie(self, request, name, value, mode="signed", max_age=0, path="/", domain="",
+                   secure=None, http_only=True, same_site="lax"):
+        # mode is "plain", "signed" or "encrypted"; a negative max_age deletes the cookie
+        options = {"mode": mode, "max_age": max_age, "path": path, "domain": domain,
+                   "secure": secure, "http_only": http_only, "same_site": same_site}
+        self.lib.SetCookie(self._request_id(request), name.encode('utf-8'), str(value).encode('utf-8'),
+                           json.dumps(options).encode('utf-8'))
+
+    def get_cookie(self, request, name, mode="signed", default=None):
+        # default when the cookie is missing, tampered with, or expired
+        value = self._take_string(self.lib.GetCookie(self._request_id(request), name.encode('utf-8'),
+                                                     mode.encode('utf-8')))
+        return default if value is None else value
+
+    def error_handler(self, status=0):
+        # The decorated function receives {"status", "error", "method", "path",
+        # "request_id"} and returns a response like a handler; status 0 covers
+        # every error status without its own handler
+        def decorator(func):
+            def callback(request_ptr, request_len):
+                try:
+                    error = json.loads(string_at(request_ptr, request_len))
+                    result = func(error)
+                    if not isinstance(result, tuple):
+                        result = (result, error["status"])
+                    payload = self._encode_response(result)
+                except Exception:
+                    return None
+                buf = create_string_buffer(payload)
+                self._responses[threading.get_ident()] = buf
+                return addressof(buf)
+
+            cb = ROUTE_HANDLER(callback)
+            self._callbacks.append(cb)
+            self.lib.RegisterErrorHandler(c_int(status), cb)
+            return func
+        return decorator
+
+    def error_page(self, status, template):
+        # Renders a templates() file with the error context; "" removes it
+        self.lib.RegisterErrorPage(c_int(status), template.encode('utf-8'))
+
+    def route_formats(self, path, formats, method="GET"):
+        # Restrict handler response negotiation to e.g. ["json", "xml"]; [] allows
+        # json, xml, msgpack and yaml
+        self.lib.SetRouteFormats(path.encode('utf-8'), method.encode('utf-8'), json.dumps(list(formats)).encode('utf-8'))
+
+    def templates(self, directory, **options):
+        # Options: layout, partials, extension, cache
+        self.lib.RegisterTemplateDir(
+            directory.encode('utf-8'),
+            json.dumps(options).encode('utf-8') if options else b""
+        )
+
+    def template_route(self, path, template, method="GET", description=""):
+        # The decorated function receives the request dict and returns the template
+        # context, optionally as (context, status) or (context, status, headers)
+        def decorator(func):
+            self.lib.RegisterTemplateRoute(
+                path.encode('utf-8'),
+                method.encode('utf-8'),
+                template.encode('utf-8'),
+                description.encode('utf-8'),
+                self._handler_callback(func)
+            )
+            return func
+        return decorator
+
+    def template_page(self, path, template, method="GET", description=""):
+        # Renders template with only the path and query parameters as context
+        self.lib.RegisterTemplateRoute(
+            path.encode('utf-8'),
+            method.encode('utf-8'),
+            template.encode('utf-8'),
+            description.encode('utf-8'),
+            ROUTE_HANDLER()
+        )
+
+    def group(self, prefix, tags=(), middleware=()):
+        # Routes registered on the returned group live under prefix, carry tags in
+        # OpenAPI, and run middleware (names or {"name", "options"} dicts) after the
+        # global chain
+        handle = self.lib.CreateRouteGroup(
+            prefix.encode('utf-8'),
+            json.dumps(list(tags)).encode('utf-8'),
+            json.dumps(list(middleware)).encode('utf-8')
+        )
+        if not handle:
+            raise ValueError(f"Invalid route group {prefix!r}")
+        return RouteGroup(self, handle)
+
+    def versioning(self, **options):
+        # Options: strategy ("path", "header" or "media_type"), header, default
+        # (e.g. "v1") and fallback to earlier versions lacking a route
+        self.lib.ConfigureVersioning(json.dumps(options).encode('utf-8'))
+
+    def version(self, name):
+        # Routes registered on the returned version are served under /{name}, or
+        # selected by header or media type, and documented at /openapi/{name}.json
+        return APIVersion(self, name)
+
+    def virtual_host(self, host, middleware=(), **options):
+        # Routes registered on the returned host only answer requests whose Host
+        # header matches host ("api.example.com" or "*.example.com"); options are
+        # cert_file/key_file or cert_pem/key_pem for SNI, and title, version, and
+        # description for the host's /openapi.json
+        options["middleware"] = list(middleware)
+        handle = self.lib.RegisterVirtualHost(host.encode('utf-8'), json.dumps(options).encode('utf-8'))
+        if not handle:
+            raise ValueError(f"Invalid virtual host {host!r}")
+        return VirtualHost(self, handle, host)
+
+    def websocket(self, path, description=""):
+        # The decorated function receives (conn_id, event, data) where event is
+        # "open", "message", or "close" and data is bytes for messages
+        def decorator(func):
+            def callback(conn_id, event, data_ptr, data_len):
+                data = string_at(data_ptr, data_len) if data_ptr else b""
+                try:
+                    func(conn_id.decode('utf-8'), CONN_EVENTS.get(event, event), data)
+                except Exception as e:
+                    print(f"WebSocket handler error: {e}")
+
+            cb = CONN_HANDLER(callback)
+            self._callbacks.append(cb)
+            self.lib.RegisterWebSocketRoute(path.encode('utf-8'), description.encode('utf-8'), cb)
+            return func
+        return decorator
+
+    def ws_send(self, conn_id, data):
+        if isinstance(data, str):
+            data = data.encode('utf-8')
+        self.lib.SendWebSocketMessage(conn_id.encode('utf-8'), data, c_int(len(data)))
+
+    def sse(self, path, description=""):
+        # The decorated function receives (conn_id, event) with event "open" or "close"
+        def decorator(func):
+            def callback(conn_id, event, data_ptr, data_len):
+                try:
+                    func(conn_id.decode('utf-8'), CONN_EVENTS.get(event, event))
+                except Exception as e:
+                    print(f"SSE handler error: {e}")
+
+            cb = CONN_HANDLER(callback)
+            self._callbacks.append(cb)
+            self.lib.RegisterSSERoute(path.encode('utf-8'), description.encode('utf-8'), cb)
+            return func
+        return decorator
+
+    def push_event(self, path, data, conn_id=""):
+        # An empty conn_id broadcasts to every stream on the route
+        self.lib.PushEvent(path.encode('utf-8'), conn_id.encode('utf-8'), data.encode('utf-8'))
+
+    def multipart(self, path, method="POST", on_chunk=None, **options):
+        # Accept multipart/form-data on a handler route. Options: max_upload_size,
+        # max_field_size, temp_dir, chunk_size, fields, files. on_chunk(upload,
+        # event, data) streams file parts instead of writing temp files; return
+        # False to reject the upload.
+        cb = UPLOAD_HANDLER(0)
+        if on_chunk:
+            def callback(upload, event, data_ptr, data_len):
+                try:
+                    data = string_at(data_ptr, data_len) if data_ptr else b""
+                    return 0 if on_chunk(json.loads(upload), UPLOAD_EVENTS.get(event, event), data) is not False else 1
+                except Exception as e:
+                    print(f"Upload handler failed: {e}")
+                    return 1
+
+            cb = UPLOAD_HANDLER(callback)
+            self._callbacks.append(cb)
+        self.lib.SetRouteMultipart(
+            path.encode('utf-8'),
+            method.encode('utf-8'),
+            json.dumps(options).encode('utf-8') if options else b"",
+            cb
+        )
+
+
+    def body_stream(self, path, method="POST", on_chunk=None, **options):
+        # Stream a handler route's request body instead of buffering it. Options:
+        # chunk_size, max_body_size. on_chunk(info, event, data) receives the body
+        # before the handler runs; return False to reject it. Without on_chunk the
+        # handler reads it with read_chunk or iter_body.
+        cb = UPLOAD_HANDLER(0)
+        if on_chunk:
+            def callback(info, event, data_ptr, data_len):
+                try:
+                    data = string_at(data_ptr, data_len) if data_ptr else b""
+                    return 0 if on_chunk(json.loads(info), UPLOAD_EVENTS.get(event, event), data) is not False else 1
+                except Exception as e:
+                    print(f"Body chunk handler failed: {e}")
+                    return 1
+
+            cb = UPLOAD_HANDLER(callback)
+            self._callbacks.append(cb)
+        self.lib.SetRouteBodyStream(
+            path.encode('utf-8'),
+            method.encode('utf-8'),
+            json.dumps(options).encode('utf-8') if options else b"",
+            cb
+        )
+
+    def read_chunk(self, request, size=65536):
+        # Next bytes of a streamed request body, b"" at its end
+        buf = create_string_buffer(size)
+        n = self.lib.ReadRequestChunk(self._request_id(request), buf, c_int(size))
+        if n < 0:
+            raise GoServerError(n, self.last_error() or "ReadRequestChunk failed")
+        return buf.raw[:n]
+
+    def iter_body(self, request, size=65536):
+        while True:
+            chunk = self.read_chunk(request, size)
+            if not chunk:
+                return
+            yield chunk
+    def on_error(self, func):
+        # Decorator: func receives a dict describing a recovered panic
+        def callback(report_ptr, report_len):
+            try:
+                func(json.loads(string_at(report_ptr, report_len)))
+            except Exception as e:
+                print(f"Error callback failed: {e}")
+
+        cb = REPORT_HANDLER(callback)
+        self._callbacks.append(cb)
+        self.lib.RegisterErrorCallback(cb)
+        return func
+
+    def on_shutdown(self, func):
+        # Decorator: func receives the drain report dict after each shutdown
+        def callback(report_ptr, report_len):
+            try:
+                func(json.loads(string_at(report_ptr, report_len)))
+            except Exception as e:
+                print(f"Shutdown callback failed: {e}")
+
+        cb = REPORT_HANDLER(callback)
+        self._callbacks.append(cb)
+        self.lib.RegisterShutdownCallback(cb)
+        return func
+
+    def provider(self, name, type="json", scope="singleton"):
+        # Decorator: func receives the provider context dict and returns the
+        # dependency value; scope is "singleton" or "request"
+        def decorator(func):
+            def callback(context_ptr, context_len):
+                try:
+                    payload = json.dumps(func(json.loads(string_at(context_ptr, context_len)))).encode('utf-8')
+                except Exception as e:
+                    print(f"Provider {name} failed: {e}")
+                    return None
+                buf = create_string_buffer(payload)
+                self._responses[threading.get_ident()] = buf
+                return addressof(buf)
+
+            cb = ROUTE_HANDLER(callback)
+            self._callbacks.append(cb)
+            self.lib.RegisterProvider(
+                name.encode('utf-8'),
+                type.encode('utf-8'),
+                c_int(1 if scope == "request" else 0),
+                cb
             )
             return func
         return decorator
 
-    def middleware(self, name, enabled=True):
-        self.lib.RegisterMiddleware(name.encode('utf-8'), c_int(1 if enabled else 0))
+    def resolve(self, name, request=None, type=""):
+        # request is the handler's request dict, needed for request-scoped dependencies
+        request_id = (request or {}).get("request_id", "")
+        result = json.loads(self._take_string(self.lib.ResolveDependency(
+            name.encode('utf-8'),
+            request_id.encode('utf-8'),
+            type.encode('utf-8')
+        )))
+        if "error" in result:
+            raise LookupError(f"{name}: {result['error']}")
+        return result["value"]
+
+    def on_startup(self, order=0):
+        return self._lifecycle_hook("startup", order)
+
+    def on_stop(self, order=0):
+        # Runs after the shutdown drain, in descending order
+        return self._lifecycle_hook("shutdown", order)
+
+    def hook(self, phase):
+        # phase is on_request, before_handler, after_handler, on_response, on_error or
+        # on_shutdown; the decorated function receives the event dict and runs on the
+        # request's path, so keep it quick
+        def decorator(func):
+            def callback(event_ptr, event_len):
+                try:
+                    func(json.loads(string_at(event_ptr, event_len)))
+                except Exception as e:
+                    print(f"{phase} hook failed: {e}")
+
+            cb = REPORT_HANDLER(callback)
+            self._callbacks.append(cb)
+            self.lib.RegisterHook(phase.encode('utf-8'), cb)
+            return func
+        return decorator
+
+    def startup_handler(self, timeout_ms=0):
+        # Runs in registration order before traffic is accepted; raising, or overrunning
+        # timeout_ms (0 for 30 seconds), aborts the start
+        return self._lifespan_handler(self.lib.RegisterStartupHandler, timeout_ms)
+
+    def shutdown_handler(self, timeout_ms=0):
+        # Runs in reverse registration order after the shutdown drain
+        return self._lifespan_handler(self.lib.RegisterShutdownHandler, timeout_ms)
+
+    def _lifespan_handler(self, register, timeout_ms):
+        def decorator(func):
+            def callback(event_ptr, event_len):
+                event = json.loads(string_at(event_ptr, event_len))
+                try:
+                    func()
+                    return 0
+                except Exception as e:
+                    print(f"{event['phase'].capitalize()} handler failed: {e}")
+                    return 1
+
+            cb = LIFESPAN_HANDLER(callback)
+            self._callbacks.append(cb)
+            register(cb, c_int(timeout_ms))
+            return func
+        return decorator
+
+    def _lifecycle_hook(self, phase, order):
+        def decorator(func):
+            def callback(event_ptr, event_len):
+                try:
+                    func()
+                except Exception as e:
+                    print(f"{phase.capitalize()} hook failed: {e}")
+
+            cb = REPORT_HANDLER(callback)
+            self._callbacks.append(cb)
+            self.lib.RegisterLifecycleHook(phase.encode('utf-8'), c_int(order), cb)
+            return func
+        return decorator
+
+    def health_check(self, name):
+        # Decorator: func returns True/False or (healthy, message) for /readyz
+        def decorator(func):
+            def callback(request_ptr, request_len):
+                try:
+                    result = func()
+                    healthy, message = result if isinstance(result, tuple) else (result, "")
+                    payload = json.dumps({"healthy": bool(healthy), "message": message})
+                except Exception as e:
+                    payload = json.dumps({"healthy": False, "message": str(e)})
+                buf = create_string_buffer(payload.encode('utf-8'))
+                self._responses[threading.get_ident()] = buf
+                return addressof(buf)
+
+            cb = ROUTE_HANDLER(callback)
+            self._callbacks.append(cb)
+            self.lib.RegisterHealthCheck(name.encode('utf-8'), cb)
+            return func
+        return decorator
+
+    def health_ping(self, name, addr):
+        # Readiness fails while host:port refuses connections
+        self.lib.RegisterHealthPing(name.encode('utf-8'), addr.encode('utf-8'))
+
+    def health_config(self, timeout_ms=None, task_queue_threshold=None):
+        options = {}
+        if timeout_ms is not None:
+            options["timeout_ms"] = timeout_ms
+        if task_queue_threshold is not None:
+            options["task_queue_threshold"] = task_queue_threshold
+        self.lib.ConfigureHealthChecks(json.dumps(options).encode('utf-8'))
+
+    def proxy(self, prefix, upstream, **options):
+        # Forward requests under prefix that no local route handles to upstream;
+        # circuit_breaker={"failure_threshold", "open_ms", "half_open_probes"} fails
+        # fast with 503 while it keeps failing
+        self.lib.RegisterProxyRoute(prefix.encode('utf-8'), upstream.encode('utf-8'), json.dumps(options).encode('utf-8'))
+
+    def http2(self, enabled=True, h2c=False, max_concurrent_streams=0):
+        # HTTP/2 over TLS is on by default; h2c serves cleartext HTTP/2 with prior knowledge
+        options = {"enabled": enabled, "h2c": h2c, "max_concurrent_streams": max_concurrent_streams}
+        self.lib.ConfigureHTTP2(json.dumps(options).encode('utf-8'))
+
+    def http3(self, enabled=True, port=0, only=False):
+        # QUIC listener; needs TLS. port defaults to the TCP port, only drops the TCP listener
+        options = {"enabled": enabled, "port": port, "only": only}
+        self.lib.ConfigureHTTP3(json.dumps(options).encode('utf-8'))
+
+    def grpc(self, port, descriptor_set, max_message_size=0, plaintext=False):
+        # gRPC listener for the services in a protoc --descriptor_set_out file; port 0 turns it off
+        options = {"port": port, "descriptor_set": descriptor_set, "max_message_size": max_message_size, "plaintext": plaintext}
+        self.lib.ConfigureGRPC(json.dumps(options).encode('utf-8'))
+
+    def grpc_handler(self, method):
+        # Decorator for "/pkg.Service/Method" or a whole "pkg.Service": func(call) gets
+        # {"method", "metadata", "message", "messages"} with messages as serialized
+        # protobuf bytes and returns the reply bytes, a list of replies for server
+        # streaming, or a dict with "message"/"messages", "status", "error",
+        # "metadata" and "trailers"; an exception answers UNKNOWN
+        def decorator(func):
+            def callback(request_ptr, request_len):
+                try:
+                    call = json.loads(string_at(request_ptr, request_len))
+                    call["message"] = base64.b64decode(call.get("message") or "")
+                    call["messages"] = [base64.b64decode(m) for m in call.get("messages") or []]
+                    result = func(call)
+                    if isinstance(result, (bytes, bytearray)):
+                        result = {"message": result}
+                    elif isinstance(result, list):
+                        result = {"messages": result}
+                    result = dict(result)
+                    if result.get("message") is not None:
+                        result["message"] = base64.b64encode(result["message"]).decode('ascii')
+                    result["messages"] = [base64.b64encode(m).decode('ascii') for m in result.get("messages") or []]
+                except Exception as e:
+                    result = {"status": 2, "error": str(e)}
+                buf = create_string_buffer(json.dumps(result).encode('utf-8'))
+                self._responses[threading.get_ident()] = buf
+                return addressof(buf)
+
+            cb = ROUTE_HANDLER(callback)
+            self._callbacks.append(cb)
+            self.lib.RegisterGRPCHandler(method.encode('utf-8'), cb)
+            return func
+        return decorator
+
+    def grpc_gateway(self, descriptor_set, backend="", timeout_ms=0, services=None):
+        # REST routes for the google.api.http annotated methods; without a backend
+        # URL calls go to grpc_handler callbacks
+        options = {"backend": backend, "timeout_ms": timeout_ms, "services": list(services or [])}
+        self.lib.RegisterGRPCGateway(descriptor_set.encode('utf-8'), json.dumps(options).encode('utf-8'))
+
+    def graphql_schema(self, sdl):
+        # Serve POST /graphql (and GET for queries) against a schema in SDL
+        self.lib.RegisterGraphQLSchema(sdl.encode('utf-8'))
+
+    def graphql(self, graphiql=False, introspection=True, max_depth=0):
+        # graphiql serves the IDE at /graphiql; max_depth 0 leaves nesting unlimited
+        options = {"graphiql": graphiql, "introspection": introspection, "max_depth": max_depth}
+        self.lib.ConfigureGraphQL(json.dumps(options).encode('utf-8'))
+
+    def resolver(self, field):
+        # Decorator for "Type.field", or "Type" for all its fields: func(parent, args, info)
+        # returns the field's value, with info holding "type", "field", "path",
+        # "operation" and "request_id"; an exception becomes a field error
+        def decorator(func):
+            def callback(request_ptr, request_len):
+                try:
+                    info = json.loads(string_at(request_ptr, request_len))
+                    result = {"value": func(info.pop("parent"), info.pop("args"), info)}
+                except Exception as e:
+                    result = {"error": str(e) or type(e).__name__}
+                buf = create_string_buffer(json.dumps(result).encode('utf-8'))
+                self._responses[threading.get_ident()] = buf
+                return addressof(buf)
+
+            cb = ROUTE_HANDLER(callback)
+            self._callbacks.append(cb)
+            self.lib.RegisterGraphQLResolver(field.encode('utf-8'), cb)
+            return func
+        return decorator
+
+    def rpc_method(self, name):
+        # Decorator serving a JSON-RPC method at /rpc: list params are passed
+        # positionally and object params as keywords. Raise RPCError for an
+        # error reply; a call that does not fit the signature answers -32602
+        def decorator(func):
+            def callback(request_ptr, request_len):
+                try:
+                    call = json.loads(string_at(request_ptr, request_len))
+                    params = call.get("params")
+                    try:
+                        inspect.signature(func).bind(*(params if isinstance(params, list) else []),
+                                                     **(params if isinstance(params, dict) else {}))
+                    except TypeError as e:
+                        raise RPCError(-32602, "Invalid params", str(e))
+                    if isinstance(params, dict):
+                        result = {"result": func(**params)}
+                    else:
+                        result = {"result": func(*(params or []))}
+                except RPCError as e:
+                    result = {"error": {"code": e.code, "message": e.message}}
+                    if e.data is not None:
+                        result["error"]["data"] = e.data
+                except Exception as e:
+                    result = {"error": {"code": -32000, "message": str(e) or type(e).__name__}}
+                buf = create_string_buffer(json.dumps(result).encode('utf-8'))
+                self._responses[threading.get_ident()] = buf
+                return addressof(buf)
+
+            cb = ROUTE_HANDLER(callback)
+            self._callbacks.append(cb)
+            self.lib.RegisterRPCMethod(name.encode('utf-8'), cb)
+            return func
+        return decorator
+
+    def tracing(self, endpoint, **options):
+        # Export spans to an OTLP/HTTP collector; an empty endpoint turns tracing off
+        self.lib.EnableTracing(endpoint.encode('utf-8'), json.dumps(options).encode('utf-8'))
+
+    def request_id(self, header="X-Request-ID", trust_incoming=True):
+        # Header request IDs are read from and echoed in
+        self.lib.ConfigureRequestID(header.encode('utf-8'), c_int(1 if trust_incoming else 0))
+
+    def trusted_proxies(self, proxies):
+        # CIDRs or IPs whose X-Forwarded-For / X-Real-IP headers set the client address
+        self.lib.ConfigureTrustedProxies(json.dumps(list(proxies)).encode('utf-8'))
+
+    def debug_endpoints(self, enabled=True, token=""):
+        # /debug/pprof, /debug/vars and /debug/routes; send the token as a bearer token
+        self.lib.EnableDebugEndpoints(c_int(1 if enabled else 0), token.encode('utf-8'))
+
+    def ready(self, ready=True):
+        # Opens the /readyz startup gate once routes are registered
+        self.lib.SetReady(c_int(1 if ready else 0))
+
+    def shutdown_drain(self, seconds):
+        self.lib.ConfigureShutdownDrain(c_int(seconds))
+
+    def query_params(self, path, params, method="GET"):
+        # params: list of {"name", "type", "description", "required", "default", "enum"}
+        self.lib.RegisterQueryParams(
+            path.encode('utf-8'),
+            method.encode('utf-8'),
+            json.dumps(params).encode('utf-8')
+        )
+
+    def form_fields(self, path, fields, method="POST"):
+        # fields: like query_params, for an application/x-www-form-urlencoded body;
+        # handlers get the coerced values in request["form_params"]
+        self.lib.RegisterFormFields(
+            path.encode('utf-8'),
+            method.encode('utf-8'),
+            json.dumps(fields).encode('utf-8')
+        )
+
+    def model(self, name, schema):
+        # A JSON Schema published under components/schemas
+        self.lib.RegisterModel(name.encode('utf-8'), json.dumps(schema).encode('utf-8'))
+
+    def responses(self, path, responses=None, status=0, method="GET"):
+        # responses maps status codes to a description or {"description", "model"};
+        # status, when set, is the code the route answers with on success
+        self.lib.SetRouteResponses(
+            path.encode('utf-8'),
+            method.encode('utf-8'),
+            c_int(status),
+            json.dumps({str(code): doc for code, doc in (responses or {}).items()}).encode('utf-8')
+        )
+
+    def deprecate(self, path, method="GET", since="", sunset="", link="", enabled=True):
+        # Dates are RFC 3339 timestamps or YYYY-MM-DD; enabled=False clears the mark
+        options = json.dumps({"since": since, "sunset": sunset, "link": link}) if enabled else "null"
+        self.lib.SetRouteDeprecation(path.encode('utf-8'), method.encode('utf-8'), options.encode('utf-8'))
+
+    def models(self, path, method="GET", request=None, responses=None):
+        # request is a model name; responses maps status codes to model names
+        self.lib.SetRouteModels(
+            path.encode('utf-8'),
+            method.encode('utf-8'),
+            (request or "").encode('utf-8'),
+            json.dumps({str(code): name for code, name in (responses or {}).items()}).encode('utf-8')
+        )
+
+    def swagger_ui(self, **options):
+        # Options: enabled, title, theme ("light" or "dark"), spec_url
+        self.lib.ConfigureSwaggerUI(json.dumps(options).encode('utf-8'))
+
+    def redoc(self, **options):
+        # Options: enabled, title, theme, spec_url, script_url
+        self.lib.ConfigureReDoc(json.dumps(options).encode('utf-8'))
+
+    def schema(self, path, schema, method="POST"):
+        # A JSON Schema dict, or a dict of field names to validator tags
+        self.lib.RegisterRouteSchema(
+            path.encode('utf-8'),
+            method.encode('utf-8'),
+            json.dumps(schema).encode('utf-8')
+        )
+
+    def validation_errors(self, format="detail", **options):
+        # format is "detail", "problem" (options: type, title) or "template"
+        # (options: template, a text/template given .Errors, .Count, .Path, .Method,
+        # .RequestID and a json function, and content_type)
+        self.lib.SetValidationErrorFormat(format.encode('utf-8'), json.dumps(options).encode('utf-8'))
+
+    def redirect(self, path, target, status=0):
+        # Answered in Go; target may use the path's {params}; status 0 means 308
+        self.lib.RegisterRedirect(path.encode('utf-8'), target.encode('utf-8'), status)
+
+    def send_redirect(self, request, url, status=0):
+        # Called from a handler; its returned response is replaced. status 0 means 302
+        self.lib.SendRedirect(self._request_id(request), url.encode('utf-8'), status)
+
+    def etags(self, mode="strong"):
+        # "strong", "weak" or "off": ETags computed from handler response bodies.
+        # The handler still runs for every request; set_validators lets it be skipped
+        self.lib.ConfigureETags(mode.encode('utf-8'))
+
+    def set_validators(self, request, etag="", last_modified=""):
+        # last_modified is an HTTP date, RFC 3339 string or Unix seconds. Returns
+        # True when the client is current: the server answers 304 and the handler
+        # can skip building the body
+        if isinstance(last_modified, (int, float)):
+            last_modified = str(int(last_modified))
+        return self.lib.SetResponseValidators(
+            self._request_id(request),
+            etag.encode('utf-8'),
+            last_modified.encode('utf-8')
+        ) == 1
+
+    def validator_cache(self, max_age_ms=60000):
+        # How long set_validators answers matching conditional requests without
+        # the handler running; 0 turns this off. Requests with an Authorization
+        # header or a session, or another value of a Vary header, always run it
+        self.lib.ConfigureValidatorCache(max_age_ms)
+
+    def invalidate_validators(self, path=""):
+        # Call when the resource at path changes; "" forgets every URL's validators
+        self.lib.InvalidateResponseValidators(path.encode('utf-8'))
+
+    def router(self, trailing_slash="strict", case_insensitive=False):
+        # trailing_slash is "strict", "redirect" (308 to the registered path) or
+        # "rewrite"; case_insensitive matches /Users to /users
+        self.lib.ConfigureRouter(json.dumps({
+            "trailing_slash": trailing_slash,
+            "case_insensitive": case_insensitive,
+        }).encode('utf-8'))
+
+    def error_format(self, format="problem", type_base=""):
+        # "problem" sends the server's own errors as RFC 7807 problem+json, with type
+        # URIs under type_base; "legacy" restores {"error": "..."}
+        self.lib.SetErrorFormat(format.encode('utf-8'), json.dumps({"type_base": type_base}).encode('utf-8'))
+
+    def middleware(self, name, enabled=True, **options):
+        # Keyword options are passed to the middleware as JSON, e.g.
+        # middleware("cors", allowed_origins=["https://example.com"])
+        if options and enabled:
+            self.lib.RegisterMiddlewareWithOptions(name.encode('utf-8'), json.dumps(options).encode('utf-8'))
+        else:
+            self.lib.RegisterMiddleware(name.encode('utf-8'), c_int(1 if enabled else 0))
+
+    def middleware_callback(self, name, pre=None, post=None):
+        # pre(request) runs before the route and may return {"respond": {...}} to answer
+        # itself, or {"set_headers": {...}, "remove_headers": [...]} to change request
+        # headers, and {"set_context": {...}} to pass values to the handler;
+        # post(request) sees request["status"] and request["response_headers"]
+        # and may return set_headers/remove_headers for the response. None continues.
+        def wrap(func):
+            if func is None:
+                return ROUTE_HANDLER()
+
+            def callback(request_ptr, request_len):
+                try:
+                    action = func(json.loads(string_at(request_ptr, request_len)))
+                except Exception as e:
+                    print(f"Middleware callback {name} failed: {e}")
+                    return None
+                if not action:
+                    return None
+                buf = create_string_buffer(json.dumps(action).encode('utf-8'))
+                self._responses[threading.get_ident()] = buf
+                return addressof(buf)
+
+            cb = ROUTE_HANDLER(callback)
+            self._callbacks.append(cb)
+            return cb
+
+        self.lib.RegisterMiddlewareCallback(name.encode('utf-8'), wrap(pre), wrap(post))
+
+    def api_key(self, key, scopes=()):
+        self.lib.RegisterAPIKey(key.encode('utf-8'), json.dumps(list(scopes)).encode('utf-8'))
+
+    def scopes(self, path, scopes, method="GET"):
+        # Requires middleware("apikey") to be registered
+        self.lib.SetRouteScopes(path.encode('utf-8'), method.encode('utf-8'), json.dumps(list(scopes)).encode('utf-8'))
+
+    def tag(self, name, description=""):
+        # Swagger UI lists tag sections in the order they are declared here
+        self.lib.RegisterTag(name.encode('utf-8'), description.encode('utf-8'))
+
+    def tags(self, path, tags, method="GET"):
+        # Replaces the tags a route got from its group
+        self.lib.SetRouteTags(path.encode('utf-8'), method.encode('utf-8'), json.dumps(list(tags)).encode('utf-8'))
+
+    def rate_limit(self, path, rate, burst, method="GET"):
+        # Requires middleware("ratelimit", ...) to be registered
+        self.lib.SetRouteRateLimit(path.encode('utf-8'), method.encode('utf-8'), c_double(rate), c_int(burst))
+
+    def cors(self, path, method="GET", **options):
+        self.lib.SetRouteCORS(path.encode('utf-8'), method.encode('utf-8'), json.dumps(options).encode('utf-8'))
+
+    def secure_headers(self, path, method="GET", **options):
+        # Per-route secureheaders options, e.g. frame_options="SAMEORIGIN"
+        self.lib.SetRouteSecureHeaders(path.encode('utf-8'), method.encode('utf-8'), json.dumps(options).encode('utf-8'))
+
+    def route_timeout(self, path, timeout_ms, method="GET"):
+        # Deadline under the timeout middleware; 0 restores its default, -1 removes it
+        self.lib.SetRouteTimeout(path.encode('utf-8'), method.encode('utf-8'), c_int(timeout_ms))
+
+    def route_concurrency(self, path, limit, method="GET"):
+        # Requests served at once under the concurrency middleware; 0 removes the cap
+        self.lib.SetRouteConcurrency(path.encode('utf-8'), method.encode('utf-8'), c_int(limit))
+
+    def cache(self, path, ttl, vary=None, method="GET"):
+        # Cache 200 responses for ttl seconds per query string and vary header values; 0 turns it off
+        self.lib.SetRouteCache(path.encode('utf-8'), method.encode('utf-8'), c_int(ttl), json.dumps(vary or []).encode('utf-8'))
+
+    def cache_store(self, **options):
+        # max_entries / max_entry_size for the in-memory LRU, or redis_addr to share entries
+        self.lib.ConfigureCache(json.dumps(options).encode('utf-8'))
+
+    def invalidate_cache(self, pattern="*"):
+        # * matches any characters, including /; returns the number of entries dropped
+        return self.lib.InvalidateCache(pattern.encode('utf-8'))
 
     def dependency(self, name, value):
         self.lib.RegisterDependency(name.encode('utf-8'), value.encode('utf-8'))
 
+    def trailer(self, path, name, value, method="GET"):
+        # Trailers are only delivered to HTTP/1.1+ clients
+        self.lib.RegisterRouteTrailer(
+            path.encode('utf-8'),
+            method.encode('utf-8'),
+            name.encode('utf-8'),
+            value.encode('utf-8')
+        )
+
+    def openapi_info(self, lang, title, description=""):
+        self.lib.ConfigureOpenAPIInfoLocalized(
+            lang.encode('utf-8'),
+            title.encode('utf-8'),
+            description.encode('utf-8')
+        )
+
+    def write_openapi(self, path, host="", lang=""):
+        # Dumps the spec /openapi.json serves; works without starting the server
+        if self.lib.WriteOpenAPIFile(path.encode('utf-8'), host.encode('utf-8'), lang.encode('utf-8')) != 0:
+            raise ValueError(f"cannot write OpenAPI document to {path!r}, see the server log")
+
+    def compression(self, path, enabled, method="GET"):
+        self.lib.SetRouteCompression(path.encode('utf-8'), method.encode('utf-8'), c_int(1 if enabled else 0))
+
+    def ranges(self, path, enabled=True, method="GET"):
+        # Streamed responses need a Content-Length header to be served in ranges
+        self.lib.SetRouteRanges(path.encode('utf-8'), method.encode('utf-8'), c_int(1 if enabled else 0))
+
+    def admission_queue(self, size, max_wait_ms):
+        self.lib.ConfigureAdmissionQueue(c_int(size), c_int(max_wait_ms))
+
+    def admission_capacity(self, capacity):
+        # Requests served at once before the admission queue holds others
+        self.lib.SetAdmissionCapacity(c_int(capacity))
+
+    def metrics(self, enabled=True):
+        self.lib.EnableMetrics(c_int(1 if enabled else 0))
+
+    def log_level(self, level):
+        self.lib.SetLogLevel(level.encode('utf-8'))
+
+    def log_format(self, fmt):
+        self.lib.SetLogFormat(fmt.encode('utf-8'))
+
+    def log_output(self, path, max_size_mb=100, max_backups=0, max_age_days=0):
+        # Rotated files are gzipped; an empty path logs to stderr again
+        self.lib.SetLogOutput(path.encode('utf-8'), c_int(max_size_mb), c_int(max_backups), c_int(max_age_days))
+
+    def max_connection_age(self, seconds):
+        self.lib.ConfigureMaxConnectionAge(c_int(seconds))
+
+    def _take_string(self, ptr):
+        # Copy a Go-allocated C string and release it
+        if not ptr:
+            return None
+        try:
+            return string_at(ptr).decode('utf-8')
+        finally:
+            self.lib.FreeString(ptr)
+
+    def stats(self):
+        return json.loads(self._take_string(self.lib.GetServerStats()))
+
+    def config_audit_log(self):
+        return json.loads(self._take_string(self.lib.GetConfigAuditLog()))
+
+    def config(self, address="", port=0, read_timeout=0, write_timeout=0, idle_timeout=0):
+        # Zero/empty values fall back to GOSERVER_* environment variables, then load_config, then defaults.
+        # address may be a list of listeners: "host:port", "unix:/path", or "systemd"
+        if isinstance(address, (list, tuple)):
+            address = ",".join(address)
+        self.lib.SetServerConfig(
+            address.encode('utf-8'),
+            c_int(port),
+            c_int(read_timeout),
+            c_int(write_timeout),
+            c_int(idle_timeout)
+        )
+
+    def load_config(self, path=""):
+        # A .json, .yaml/.yml or .toml file, overridden by GOSERVER_* variables;
+        # "" applies the variables alone. Nothing changes if the file is invalid.
+        if self.lib.LoadConfig(path.encode('utf-8')) != 0:
+            raise ValueError(f"cannot load config {path!r}, see the server log")
+
+    def reload_config(self):
+        # Also runs on SIGHUP and, unless reload.watch is false, when the file changes
+        if self.lib.ReloadConfig() != 0:
+            raise ValueError("config reload failed, see the server log")
+
+    def json_engine(self, name=None):
+        # "std" by default; "sonic" and "go-json" need the library built with goserver_sonic / goserver_gojson.
+        # With no name, returns {"active": ..., "available": [...]}
+        if name is None:
+            return json.loads(self._take_string(self.lib.GetJSONEngines()))
+        if self.lib.SetJSONEngine(name.encode()) != 0:
+            raise ValueError(f"JSON engine {name!r} is unavailable, see the server log")
+
+    def limits(self, max_body_size=0, max_header_bytes=0, read_header_timeout=0):
+        # Sizes in bytes, timeout in seconds; zero falls back to GOSERVER_* variables, then defaults
+        self.lib.SetRequestLimits(c_int64(max_body_size), c_int(max_header_bytes), c_int(read_header_timeout))
+
+    def route_body_limit(self, path, max_bytes, method="POST"):
+        # 0 restores the default limit, -1 removes it for this route
+        self.lib.SetRouteBodyLimit(path.encode('utf-8'), method.encode('utf-8'), c_int64(max_bytes))
+
+    def tls(self, cert_path=None, key_path=None, cert_pem=None, key_pem=None, self_signed_hosts=None, redirect_http_port=0):
+        # Pass file paths, PEM bytes, or self_signed_hosts (e.g. "localhost") for development
+        if self_signed_hosts is not None:
+            self.lib.EnableSelfSignedTLS(self_signed_hosts.encode('utf-8'))
+        elif cert_pem is not None:
+            self.lib.EnableTLSFromPEM(cert_pem, c_int(len(cert_pem)), key_pem, c_int(len(key_pem)))
+        else:
+            self.lib.EnableTLS(cert_path.encode('utf-8'), key_path.encode('utf-8'))
+        if redirect_http_port:
+            self.lib.EnableHTTPSRedirect(c_int(redirect_http_port))
+
+    def client_auth(self, ca_path=None, ca_pem=None, mode="require"):
+        # Mutual TLS: "require" a client certificate or only "verify" one when sent;
+        # handlers see the verified certificate as request["client_cert"]
+        if ca_pem is not None:
+            self.lib.EnableClientAuthFromPEM(ca_pem, c_int(len(ca_pem)), mode.encode('utf-8'))
+        else:
+            self.lib.EnableClientAuth((ca_path or "").encode('utf-8'), mode.encode('utf-8'))
+
+    def task_status(self, task_id):
+        # Returns None for unknown tasks
+        data = self._take_string(self.lib.GetTaskStatus(task_id.encode('utf-8')))
+        return json.loads(data) if data else None
+
+    def task_pool(self, workers, queue_depth):
+        self.lib.ConfigureTaskPool(c_int(workers), c_int(queue_depth))
+
+    def task_backpressure(self, path, mode, method="GET"):
+        # mode is "queue" (wait for space) or "reject" (503 when full)
+        self.lib.SetRouteTaskBackpressure(path.encode('utf-8'), method.encode('utf-8'), mode.encode('utf-8'))
+
+    def task(self, name):
+        # Decorator: func(task) receives {"task_id", "name", "scheduled_at"}, plus
+        # "request_id" and "context" when submitted from a request, and
+        # "deadline" and "traceparent" under task_context("inherit");
+        # its return value is the task result and an exception fails the run
+        def decorator(func):
+            def callback(request_ptr, request_len):
+                try:
+                    task = json.loads(string_at(request_ptr, request_len))
+                    result = func(task)
+                    payload = result if isinstance(result, str) else json.dumps(result)
+                except Exception:
+                    return None
+                buf = create_string_buffer(payload.encode('utf-8'))
+                self._responses[threading.get_ident()] = buf
+                return addressof(buf)
+
+            cb = ROUTE_HANDLER(callback)
+            self._callbacks.append(cb)
+            self.lib.RegisterTask(name.encode('utf-8'), cb)
+            return func
+        return decorator
+
+    def task_handler(self, name):
+        # Decorator: func(task, payload) gets the task descriptor and the submitted
+        # bytes; its return value is the result and an exception fails the attempt
+        def decorator(func):
+            def callback(task_ptr, task_len, payload_ptr, payload_len, failed):
+                try:
+                    task = json.loads(string_at(task_ptr, task_len))
+                    payload = string_at(payload_ptr, payload_len) if payload_ptr else b""
+                    result = func(task, payload)
+                    out = result if isinstance(result, str) else json.dumps(result)
+                except Exception as e:
+                    failed[0] = 1
+                    out = str(e) or type(e).__name__
+                buf = create_string_buffer(out.encode('utf-8'))
+                self._responses[threading.get_ident()] = buf
+                return addressof(buf)
+
+            cb = TASK_HANDLER(callback)
+            self._callbacks.append(cb)
+            self.lib.RegisterTaskHandler(name.encode('utf-8'), cb)
+            return func
+        return decorator
+
+    def task_timeout(self, name, timeout_ms):
+        # name "" sets the default for all named tasks; 0 removes the limit
+        self.lib.ConfigureTaskTimeout(name.encode('utf-8'), c_int(timeout_ms))
+
+    def task_context(self, name, mode="inherit"):
+        # "inherit" hands request-submitted tasks the request's deadline and
+        # trace context; "detached", the default, lets them outlive it. name ""
+        # sets the default for all named tasks
+        self.lib.ConfigureTaskContext(name.encode('utf-8'), mode.encode('utf-8'))
+
+    def submit_task(self, name, payload=b""):
+        # Returns the task ID, or None if the task is unknown or the queue is full
+        if isinstance(payload, str):
+            payload = payload.encode('utf-8')
+        return self._take_string(self.lib.SubmitTask(name.encode('utf-8'), payload, c_int(len(payload))))
+
+    def submit_request_task(self, request, name, payload=b""):
+        # Like submit_task; the task also receives the request ID and context
+        if isinstance(payload, str):
+            payload = payload.encode('utf-8')
+        return self._take_string(self.lib.SubmitTaskForRequest(self._request_id(request), name.encode('utf-8'), payload, c_int(len(payload))))
+
+    def webhook(self, event, url, secret=""):
+        # Deliver event ("*" for all) to url; a secret signs deliveries in X-Webhook-Signature
+        self.lib.RegisterWebhook(event.encode('utf-8'), url.encode('utf-8'), secret.encode('utf-8'))
+
+    def webhooks(self, max_attempts=0, timeout_ms=0, circuit_breaker=None, **retry):
+        # Delivery retry policy (max_attempts, backoff, initial_delay_ms, max_delay_ms, jitter);
+        # circuit_breaker takes the same settings as for proxy, per receiver URL
+        retry["max_attempts"] = max_attempts
+        options = {"retry": retry, "timeout_ms": timeout_ms, "circuit_breaker": circuit_breaker}
+        self.lib.ConfigureWebhooks(json.dumps(options).encode('utf-8'))
+
+    def emit(self, event, payload=None):
+        # Returns the event ID, or None if the payload cannot be sent as JSON
+        return self._take_string(self.lib.EmitEvent(event.encode('utf-8'), json.dumps(payload).encode('utf-8')))
+
+    def database(self, name, driver, dsn, **pool):
+        # pool: max_open_conns, max_idle_conns, conn_max_lifetime_ms,
+        # conn_max_idle_time_ms, query_timeout_ms, max_rows, health_check
+        self.lib.RegisterDatabase(name.encode('utf-8'), driver.encode('utf-8'), dsn.encode('utf-8'), json.dumps(pool).encode('utf-8'))
+
+    def _database_call(self, fn, name, sql, args):
+        result = json.loads(self._take_string(fn(name.encode('utf-8'), sql.encode('utf-8'), json.dumps(list(args)).encode('utf-8'))))
+        if "error" in result:
+            raise RuntimeError(f"{name}: {result['error']}")
+        return result
+
+    def query(self, name, sql, *args):
+        # Returns the rows as dicts keyed by column name
+        result = self._database_call(self.lib.QueryDatabase, name, sql, args)
+        return [dict(zip(result["columns"], row)) for row in result["rows"]]
+
+    def execute(self, name, sql, *args):
+        # Returns {"rows_affected": n, "last_insert_id": id}
+        return self._database_call(self.lib.ExecDatabase, name, sql, args)
+
+    def redis(self, name, url, **options):
+        # options: timeout_ms, health_check; middleware use it with {"redis": name}
+        self.lib.RegisterRedis(name.encode('utf-8'), url.encode('utf-8'), json.dumps(options).encode('utf-8'))
+
+    def redis_get(self, name, key):
+        # Returns the string at key, or None if it does not exist
+        result = json.loads(self._take_string(self.lib.RedisGet(name.encode('utf-8'), key.encode('utf-8'))))
+        if "error" in result:
+            raise RuntimeError(f"{name}: {result['error']}")
+        return result["value"]
+
+    def redis_set(self, name, key, value, ttl_ms=0):
+        if self.lib.RedisSet(name.encode('utf-8'), key.encode('utf-8'), value.encode('utf-8'), ttl_ms) != 0:
+            raise RuntimeError(f"{name}: cannot set {key}")
+
+    def redis_delete(self, name, key):
+        # Returns whether the key existed
+        n = self.lib.RedisDel(name.encode('utf-8'), key.encode('utf-8'))
+        if n < 0:
+            raise RuntimeError(f"{name}: cannot delete {key}")
+        return n > 0
+
+    def route_task(self, path, task, method="GET"):
+        # The static route runs the task with the request body as payload
+        self.lib.SetRouteTask(path.encode('utf-8'), method.encode('utf-8'), task.encode('utf-8'))
+
+    def schedule(self, cron, name, **options):
+        # cron is five fields, a macro like "@daily", or "@every 30s"; "" removes it
+        # options: overlap ("skip", "queue", "parallel"), jitter_ms, timezone
+        self.lib.ScheduleTask(cron.encode('utf-8'), name.encode('utf-8'), json.dumps(options).encode('utf-8'))
+
+    def run_at(self, timestamp, name):
+        # timestamp is Unix seconds; returns the task ID, or None on error
+        return self._take_string(self.lib.RunTaskAt(c_int64(int(timestamp * 1000)), name.encode('utf-8')))
+
+    def schedules(self):
+        return json.loads(self._take_string(self.lib.GetTaskSchedules()))
+
+    def task_retry(self, name="", **policy):
+        # policy: max_attempts, backoff ("exponential" or "fixed"), initial_delay_ms,
+        # max_delay_ms, jitter; name "" sets the default for all tasks
+        self.lib.ConfigureTaskRetry(name.encode('utf-8'), json.dumps(policy).encode('utf-8'))
+
+    def task_backend(self, driver, dsn=""):
+        # driver: "memory", "sqlite" (dsn is a file path) or "redis" (dsn is a redis:// URL);
+        # call before start() so unfinished tasks resume
+        self.lib.ConfigureTaskBackend(driver.encode('utf-8'), dsn.encode('utf-8'))
+
+    def drain_dead_tasks(self):
+        # Returns and clears the tasks that failed their last attempt
+        return json.loads(self._take_string(self.lib.DrainDeadTasks()))
+
     def start(self):
-        self.lib.StartServer()
\ No newline at end of file
+        # Blocks until SIGINT/SIGTERM or stop()
+        self.lib.StartServer()
+
+    def start_async(self):
+        self.lib.StartServerAsync()
+
+    def stop(self):
+        self.lib.StopServer()
+
+    def restart(self):
+        self.lib.RestartServer()
+
+    def reload(self, command=None, timeout=0):
+        # Hands the sockets to a new process running command (default: this
+        # one again); returns its PID, or -1 with this server still running
+        argv = json.dumps(command).encode('utf-8') if command else b""
+        return self.lib.ReloadServer(argv, c_int(timeout))
+
+class VirtualHost:
+    # Created with GoServer.virtual_host; per-route settings address these routes
+    # as host + path, e.g. server.cors("api.example.com/users", ...)
+    def __init__(self, server, handle, host):
+        self.server = server
+        self.handle = handle
+        self.host = host
+
+    def route(self, path, method="GET", description=""):
+        def decorator(func):
+            self.server.lib.RegisterVirtualHostRoute(
+                self.handle,
+                path.encode('utf-8'),
+                method.encode('utf-8'),
+                func().encode('utf-8'),
+                description.encode('utf-8')
+            )
+            return func
+        return decorator
+
+    def handler(self, path, method="GET", description=""):
+        def decorator(func):
+            self.server.lib.RegisterVirtualHostRouteHandler(
+                self.handle,
+                path.encode('utf-8'),
+                method.encode('utf-8'),
+                description.encode('utf-8'),
+                self.server._handler_callback(func)
+            )
+            return func
+        return decorator
+
+class RouteGroup:
+    # Created with GoServer.group; paths are relative to the group prefix
+    def __init__(self, server, handle):
+        self.server = server
+        self.handle = handle
+
+    def route(self, path, method="GET", description=""):
+        def decorator(func):
+            self.server.lib.RegisterGroupRoute(
+                self.handle,
+                path.encode('utf-8'),
+                method.encode('utf-8'),
+                func().encode('utf-8'),
+                description.encode('utf-8')
+            )
+            return func
+        return decorator
+
+    def handler(self, path, method="GET", description=""):
+        def decorator(func):
+            self.server.lib.RegisterGroupRouteHandler(
+                self.handle,
+                path.encode('utf-8'),
+                method.encode('utf-8'),
+                description.encode('utf-8'),
+                self.server._handler_callback(func)
+            )
+            return func
+        return decorator
+
+class APIVersion:
+    # Created with GoServer.version; paths are relative to the version prefix
+    def __init__(self, server, name):
+        self.server = server
+        self.name = name
+
+    def route(self, path, method="GET", description=""):
+        def decorator(func):
+            self.server.lib.RegisterVersionedRoute(
+                self.name.encode('utf-8'),
+                path.encode('utf-8'),
+                method.encode('utf-8'),
+                func().encode('utf-8'),
+                description.encode('utf-8')
+            )
+            return func
+        return decorator
+
+    def handler(self, path, method="GET", description=""):
+        def decorator(func):
+            self.server.lib.RegisterVersionedRouteHandler(
+                self.name.encode('utf-8'),
+                path.encode('utf-8'),
+                method.encode('utf-8'),
+                description.encode('utf-8'),
+                self.server._handler_callback(func)
+            )
+            return func
+        return decorator
@@ -0,0 +1,689 @@
+package main
+
+import (
+	"C"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// GraphQLOptions configures the /graphql endpoint
+type GraphQLOptions struct {
+	// GraphiQL serves the GraphiQL IDE at /graphiql
+	GraphiQL bool `json:"graphiql"`
+	// Introspection allows __schema and __type queries; on by default
+	Introspection *bool `json:"introspection"`
+	// MaxDepth rejects operations nesting fields deeper than this; 0 for
+	// no limit
+	MaxDepth int `json:"max_depth"`
+}
+
+func (opts GraphQLOptions) introspectionEnabled() bool {
+	return opts.Introspection == nil || *opts.Introspection
+}
+
+var (
+	graphqlSchema    *gqlSchema
+	graphqlOptions   GraphQLOptions
+	graphqlResolvers = make(map[string]uintptr) // "Type.field" or "Type" -> host callback
+	graphqlMu        sync.RWMutex
+)
+
+// GraphQLResolve is the JSON sent to a resolver callback
+type GraphQLResolve struct {
+	Type      string                 `json:"type"`
+	Field     string                 `json:"field"`
+	Args      map[string]interface{} `json:"args"`
+	Parent    interface{}            `json:"parent"`
+	Path      []interface{}          `json:"path"`
+	Operation string                 `json:"operation,omitempty"`
+	RequestID string                 `json:"request_id,omitempty"`
+}
+
+// GraphQLResult is a resolver callback's reply: the field's value, or an
+// error message to report for it
+type GraphQLResult struct {
+	Value interface{} `json:"value"`
+	Error string      `json:"error"`
+}
+
+// gqlRequest is a GraphQL-over-HTTP request
+type gqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// gqlObject is a result object, marshalled with its fields in the order
+// they were selected
+type gqlObject struct {
+	keys   []string
+	values []interface{}
+}
+
+func (o *gqlObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(key)
+		buf.Write(name)
+		buf.WriteByte(':')
+		value, err := json.Marshal(o.values[i])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// gqlExecution runs one operation. Fields resolve one after another, so
+// host resolvers are never called concurrently for a request.
+type gqlExecution struct {
+	ctx       context.Context
+	schema    *gqlSchema
+	fragments map[string]*gqlFragment
+	operation *gqlOperation
+	variables map[string]interface{}
+	resolvers map[string]uintptr
+	requestID string
+	errors    []*gqlError
+}
+
+func (e *gqlExecution) fieldError(sel *gqlSelection, path []interface{}, message string) {
+	e.errors = append(e.errors, &gqlError{Message: message, Locations: []gqlLocation{sel.Loc}, Path: path})
+}
+
+func appendPath(path []interface{}, segment interface{}) []interface{} {
+	next := make([]interface{}, len(path), len(path)+1)
+	copy(next, path)
+	return append(next, segment)
+}
+
+// execute runs the operation and returns its data, nil when a non-null
+// root field failed
+func (e *gqlExecution) execute() interface{} {
+	root := e.schema.types[e.schema.roots[e.operation.Kind]]
+	data, ok := e.selectionSet(root, e.operation.Selections, nil, nil)
+	if !ok {
+		return nil
+	}
+	return data
+}
+
+// collectFields groups the fields selected on an object type by response
+// key, applying @skip, @include, and fragment type conditions
+func (e *gqlExecution) collectFields(t *gqlTypeDef, selections []*gqlSelection, keys *[]string, groups map[string][]*gqlSelection, visited map[string]bool) {
+	for _, sel := range selections {
+		if e.skipped(sel.Directives) {
+			continue
+		}
+		switch sel.Kind {
+		case "Field":
+			key := sel.responseKey()
+			if _, ok := groups[key]; !ok {
+				*keys = append(*keys, key)
+			}
+			groups[key] = append(groups[key], sel)
+		case "InlineFragment":
+			if sel.TypeCondition == "" || e.schema.possibleOf(sel.TypeCondition)[t.Name] {
+				e.collectFields(t, sel.Selections, keys, groups, visited)
+			}
+		case "FragmentSpread":
+			f := e.fragments[sel.Name]
+			if visited[sel.Name] || f == nil || !e.schema.possibleOf(f.TypeCondition)[t.Name] {
+				continue
+			}
+			visited[sel.Name] = true
+			e.collectFields(t, f.Selections, keys, groups, visited)
+		}
+	}
+}
+
+func (e *gqlExecution) skipped(directives []gqlDirective) bool {
+	for _, d := range directives {
+		if d.Name != "skip" && d.Name != "include" {
+			continue
+		}
+		for _, arg := range d.Args {
+			if arg.Name != "if" {
+				continue
+			}
+			v, _ := e.schema.coerceLiteral(arg.Value, gqlNonNull(gqlNamed("Boolean")), e.variables)
+			if (v == true) == (d.Name == "skip") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// selectionSet executes selections on an object. It reports false when a
+// non-null field came back null, making the whole object null.
+func (e *gqlExecution) selectionSet(t *gqlTypeDef, selections []*gqlSelection, parent interface{}, path []interface{}) (*gqlObject, bool) {
+	var keys []string
+	groups := make(map[string][]*gqlSelection)
+	e.collectFields(t, selections, &keys, groups, make(map[string]bool))
+	object := &gqlObject{keys: keys, values: make([]interface{}, len(keys))}
+	for i, key := range keys {
+		value, ok := e.field(t, groups[key], parent, appendPath(path, key))
+		if !ok {
+			return nil, false
+		}
+		object.values[i] = value
+	}
+	return object, true
+}
+
+func (e *gqlExecution) field(t *gqlTypeDef, fields []*gqlSelection, parent interface{}, path []interface{}) (interface{}, bool) {
+	sel := fields[0]
+	def := e.schema.field(t, sel.Name)
+	args := make(map[string]interface{})
+	for _, argDef := range def.Args {
+		var literal *gqlValue
+		for _, arg := range sel.Args {
+			if arg.Name == argDef.Name {
+				literal = arg.Value
+			}
+		}
+		if literal != nil && literal.Kind == "Variable" {
+			if _, ok := e.variables[literal.Raw]; !ok {
+				literal = nil
+			}
+		}
+		if literal == nil {
+			literal = argDef.Default
+		}
+		if literal == nil {
+			if argDef.Type.Kind == gqlNonNullType {
+				e.fieldError(sel, path, fmt.Sprintf("Argument %q of required type %q was not provided.", argDef.Name, argDef.Type))
+				return nil, def.Type.Kind != gqlNonNullType
+			}
+			continue
+		}
+		v, err := e.schema.coerceLiteral(literal, argDef.Type, e.variables)
+		if err != nil {
+			e.fieldError(sel, path, fmt.Sprintf("Argument %q has an invalid value: %s", argDef.Name, err))
+			return nil, def.Type.Kind != gqlNonNullType
+		}
+		args[argDef.Name] = v
+	}
+	value, err := e.resolve(t, def, parent, args, path)
+	if err != nil {
+		e.fieldError(sel, path, err.Error())
+		return nil, def.Type.Kind != gqlNonNullType
+	}
+	return e.complete(t, def, def.Type, fields, value, path)
+}
+
+// resolve produces a field's raw value: meta fields and introspection are
+// answered here, registered host resolvers are called, and any other field
+// is read from the parent object
+func (e *gqlExecution) resolve(t *gqlTypeDef, def *gqlFieldDef, parent interface{}, args map[string]interface{}, path []interface{}) (interface{}, error) {
+	switch def {
+	case gqlTypenameField:
+		return t.Name, nil
+	case gqlSchemaField:
+		return e.schema.introspection, nil
+	case gqlTypeField:
+		if named, ok := e.schema.introspectionTypes[args["name"].(string)]; ok {
+			return named, nil
+		}
+		return nil, nil
+	}
+	parentObject, _ := parent.(map[string]interface{})
+	if fn, ok := gqlIntrospectionResolvers[t.Name+"."+def.Name]; ok {
+		return fn(parentObject, args), nil
+	}
+	handler, ok := e.resolvers[t.Name+"."+def.Name]
+	if !ok {
+		handler, ok = e.resolvers[t.Name]
+	}
+	if !ok {
+		return parentObject[def.Name], nil
+	}
+	return e.callResolver(handler, GraphQLResolve{
+		Type:      t.Name,
+		Field:     def.Name,
+		Args:      args,
+		Parent:    parent,
+		Path:      path,
+		Operation: e.operation.Name,
+		RequestID: e.requestID,
+	})
+}
+
+func (e *gqlExecution) callResolver(handler uintptr, call GraphQLResolve) (interface{}, error) {
+	if err := e.ctx.Err(); err != nil {
+		return nil, errors.New("request canceled")
+	}
+	request, err := json.Marshal(call)
+	if err != nil {
+		return nil, errors.New("encoding resolver call")
+	}
+	done := make(chan []byte, 1)
+	go func() {
+		raw, ok := callRouteHandler(handler, request)
+		if !ok {
+			raw = nil
+		}
+		done <- raw
+	}()
+	var raw []byte
+	select {
+	case raw = <-done:
+	case <-e.ctx.Done():
+		return nil, errors.New("request canceled")
+	}
+	if raw == nil {
+		slog.Error("GraphQL resolver returned no response", "field", call.Type+"."+call.Field)
+		return nil, errors.New("resolver returned no response")
+	}
+	var result GraphQLResult
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&result); err != nil {
+		slog.Error("Error decoding GraphQL resolver response", "field", call.Type+"."+call.Field, "error", err)
+		return nil, errors.New("invalid resolver response")
+	}
+	if result.Error != "" {
+		return nil, errors.New(result.Error)
+	}
+	return result.Value, nil
+}
+
+// complete shapes a resolved value to the field's type. It reports false
+// when a null must propagate to the nearest nullable parent.
+func (e *gqlExecution) complete(parent *gqlTypeDef, def *gqlFieldDef, t *gqlTypeRef, fields []*gqlSelection, value interface{}, path []interface{}) (interface{}, bool) {
+	if t.Kind == gqlNonNullType {
+		v, ok := e.completeValue(parent, def, t.OfType, fields, value, path)
+		if !ok {
+			return nil, false
+		}
+		if v == nil {
+			e.fieldError(fields[0], path, fmt.Sprintf("Cannot return null for non-nullable field %s.%s.", parent.Name, def.Name))
+			return nil, false
+		}
+		return v, true
+	}
+	v, ok := e.completeValue(parent, def, t, fields, value, path)
+	if !ok {
+		return nil, true
+	}
+	return v, true
+}
+
+func (e *gqlExecution) completeValue(parent *gqlTypeDef, def *gqlFieldDef, t *gqlTypeRef, fields []*gqlSelection, value interface{}, path []interface{}) (interface{}, bool) {
+	if value == nil {
+		return nil, true
+	}
+	if t.Kind == gqlListType {
+		list, ok := value.([]interface{})
+		if !ok {
+			e.fieldError(fields[0], path, fmt.Sprintf("Expected a list for field %s.%s, got %s.", parent.Name, def.Name, gqlJSONText(value)))
+			return nil, false
+		}
+		items := make([]interface{}, len(list))
+		for i, item := range list {
+			v, ok := e.complete(parent, def, t.OfType, fields, item, appendPath(path, i))
+			if !ok {
+				return nil, false
+			}
+			items[i] = v
+		}
+		return items, true
+	}
+	named := e.schema.types[t.Name]
+	switch named.Kind {
+	case "SCALAR":
+		v, err := serializeGQLScalar(t.Name, value)
+		if err != nil {
+			e.fieldError(fields[0], path, err.Error())
+			return nil, false
+		}
+		return v, true
+	case "ENUM":
+		name, ok := value.(string)
+		if !ok || named.enumValue(name) == nil {
+			e.fieldError(fields[0], path, fmt.Sprintf("Enum %q cannot represent value: %s", t.Name, gqlJSONText(value)))
+			return nil, false
+		}
+		return name, true
+	}
+	object := named
+	if named.Kind != "OBJECT" {
+		object = e.runtimeType(named, value)
+		if object == nil {
+			e.fieldError(fields[0], path, fmt.Sprintf("Abstract type %q must resolve to an object type at runtime for field %s.%s; return its name as \"__typename\".", t.Name, parent.Name, def.Name))
+			return nil, false
+		}
+	}
+	if _, ok := value.(map[string]interface{}); !ok {
+		e.fieldError(fields[0], path, fmt.Sprintf("Expected an object for field %s.%s, got %s.", parent.Name, def.Name, gqlJSONText(value)))
+		return nil, false
+	}
+	var selections []*gqlSelection
+	for _, f := range fields {
+		selections = append(selections, f.Selections...)
+	}
+	v, ok := e.selectionSet(object, selections, value, path)
+	if !ok {
+		return nil, false
+	}
+	return v, true
+}
+
+// runtimeType picks the object type of an interface or union value from
+// its __typename, or the only possible type when there is just one
+func (e *gqlExecution) runtimeType(abstract *gqlTypeDef, value interface{}) *gqlTypeDef {
+	possible := e.schema.possibleOf(abstract.Name)
+	if object, ok := value.(map[string]interface{}); ok {
+		if name, ok := object["__typename"].(string); ok {
+			if possible[name] {
+				return e.schema.types[name]
+			}
+			return nil
+		}
+	}
+	if len(possible) == 1 {
+		for name := range possible {
+			return e.schema.types[name]
+		}
+	}
+	return nil
+}
+
+// writeGQLErrors answers a request that failed before execution
+func writeGQLErrors(w http.ResponseWriter, status int, errs ...*gqlError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	writeJSON(w, map[string]interface{}{"errors": errs})
+}
+
+// decodeGQLJSON decodes JSON keeping numbers exact
+func decodeGQLJSON(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// ServeGraphQL answers GraphQL requests against the registered schema:
+// POST with a JSON body, or GET with query parameters for queries
+func ServeGraphQL(w http.ResponseWriter, r *http.Request) {
+	graphqlMu.RLock()
+	schema, opts := graphqlSchema, graphqlOptions
+	resolvers := make(map[string]uintptr, len(graphqlResolvers))
+	for name, handler := range graphqlResolvers {
+		resolvers[name] = handler
+	}
+	graphqlMu.RUnlock()
+	if schema == nil {
+		http.Error(w, `{"error": "Route not found for `+r.Method+` `+r.URL.Path+`"}`, http.StatusNotFound)
+		return
+	}
+
+	var req gqlRequest
+	switch r.Method {
+	case http.MethodGet:
+		query := r.URL.Query()
+		req.Query = query.Get("query")
+		req.OperationName = query.Get("operationName")
+		if raw := query.Get("variables"); raw != "" {
+			if err := decodeGQLJSON([]byte(raw), &req.Variables); err != nil {
+				writeGQLErrors(w, http.StatusBadRequest, &gqlError{Message: "Variables are invalid JSON."})
+				return
+			}
+		}
+	case http.MethodPost:
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mediaType != "application/json" {
+			http.Error(w, `{"error": "Content-Type must be application/json"}`, http.StatusUnsupportedMediaType)
+			return
+		}
+		if !limitRequestBody(w, r, 0) {
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeBodyReadError(w, err)
+			return
+		}
+		if err := decodeGQLJSON(body, &req); err != nil {
+			writeGQLErrors(w, http.StatusBadRequest, &gqlError{Message: "Request body is not a valid GraphQL request: " + err.Error()})
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		writeGQLErrors(w, http.StatusBadRequest, &gqlError{Message: "Must provide query string."})
+		return
+	}
+
+	doc, parseErr := gqlParse(req.Query, (*gqlParser).parseDocument)
+	if parseErr != nil {
+		writeGQLErrors(w, http.StatusBadRequest, parseErr)
+		return
+	}
+	if errs := validateGQLDocument(schema, doc, opts); len(errs) > 0 {
+		writeGQLErrors(w, http.StatusBadRequest, errs...)
+		return
+	}
+	var operation *gqlOperation
+	for _, op := range doc.Operations {
+		if req.OperationName == "" || op.Name == req.OperationName {
+			if operation != nil {
+				writeGQLErrors(w, http.StatusBadRequest, &gqlError{Message: "Must provide operation name if query contains multiple operations."})
+				return
+			}
+			operation = op
+		}
+	}
+	if operation == nil {
+		message := "Must provide an operation."
+		if req.OperationName != "" {
+			message = fmt.Sprintf("Unknown operation named %q.", req.OperationName)
+		}
+		writeGQLErrors(w, http.StatusBadRequest, &gqlError{Message: message})
+		return
+	}
+	if r.Method == http.MethodGet && operation.Kind != "query" {
+		w.Header().Set("Allow", "POST")
+		writeGQLErrors(w, http.StatusMethodNotAllowed, &gqlError{Message: fmt.Sprintf("Can only perform a %s operation from a POST request.", operation.Kind)})
+		return
+	}
+
+	variables := make(map[string]interface{})
+	for _, def := range operation.Variables {
+		value, provided := req.Variables[def.Name]
+		if !provided {
+			if def.Default != nil {
+				variables[def.Name], _ = schema.coerceLiteral(def.Default, def.Type, variables)
+			} else if def.Type.Kind == gqlNonNullType {
+				writeGQLErrors(w, http.StatusBadRequest, gqlErrorf(def.Loc, "Variable \"$%s\" of required type %q was not provided.", def.Name, def.Type))
+				return
+			}
+			continue
+		}
+		coerced, err := schema.coerceInput(value, def.Type)
+		if err != nil {
+			writeGQLErrors(w, http.StatusBadRequest, gqlErrorf(def.Loc, "Variable \"$%s\" got invalid value %s; %s", def.Name, gqlJSONText(value), err))
+			return
+		}
+		variables[def.Name] = coerced
+	}
+
+	fragments := make(map[string]*gqlFragment, len(doc.Fragments))
+	for _, f := range doc.Fragments {
+		fragments[f.Name] = f
+	}
+	e := &gqlExecution{
+		ctx:       r.Context(),
+		schema:    schema,
+		fragments: fragments,
+		operation: operation,
+		variables: variables,
+		resolvers: resolvers,
+	}
+	if info := requestInfoFrom(r); info != nil {
+		e.requestID = info.ID
+	}
+	response := struct {
+		Data   interface{} `json:"data"`
+		Errors []*gqlError `json:"errors,omitempty"`
+	}{Data: e.execute()}
+	response.Errors = e.errors
+	w.Header().Set("Content-Type", "application/json")
+	if err := writeJSON(w, response); err != nil {
+		slog.Error("Error writing GraphQL response", "error", err)
+	}
+}
+
+var graphiqlPage = template.Must(template.New("graphiql").Parse(`<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>GraphiQL</title>
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<link rel="stylesheet" href="https://unpkg.com/graphiql@3/graphiql.min.css">
+<style>body { margin: 0; height: 100vh; } #graphiql { height: 100vh; }</style>
+</head>
+<body>
+<div id="graphiql"></div>
+<script crossorigin src="https://unpkg.com/react@18/umd/react.production.min.js"></script>
+<script crossorigin src="https://unpkg.com/react-dom@18/umd/react-dom.production.min.js"></script>
+<script crossorigin src="https://unpkg.com/graphiql@3/graphiql.min.js"></script>
+<script>
+ReactDOM.createRoot(document.getElementById("graphiql")).render(
+  React.createElement(GraphiQL, {fetcher: GraphiQL.createFetcher({url: {{.Endpoint}}})})
+);
+</script>
+</body>
+</html>
+`))
+
+// ServeGraphiQL serves the GraphiQL IDE when it is enabled
+func ServeGraphiQL(w http.ResponseWriter, r *http.Request) {
+	graphqlMu.RLock()
+	enabled := graphqlSchema != nil && graphqlOptions.GraphiQL
+	graphqlMu.RUnlock()
+	if !enabled {
+		http.Error(w, `{"error": "Route not found for `+r.Method+` `+r.URL.Path+`"}`, http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	if err := graphiqlPage.Execute(w, map[string]string{"Endpoint": "/graphql"}); err != nil {
+		slog.Error("Error rendering GraphiQL page", "error", err)
+	}
+}
+
+// graphqlHandler serves the GraphQL endpoints while they are enabled, and
+// otherwise leaves their paths to registered routes
+func graphqlHandler(dispatch http.Handler) http.Handler {
+	endpoint := admissionMiddleware(http.HandlerFunc(ServeGraphQL))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		graphqlMu.RLock()
+		registered, graphiql := graphqlSchema != nil, graphqlOptions.GraphiQL
+		graphqlMu.RUnlock()
+		switch {
+		case r.URL.Path == "/graphql" && registered:
+			endpoint.ServeHTTP(w, r)
+		case r.URL.Path == "/graphiql" && registered && graphiql && r.Method == http.MethodGet:
+			ServeGraphiQL(w, r)
+		default:
+			dispatch.ServeHTTP(w, r)
+		}
+	})
+}
+
+// RegisterGraphQLSchema sets the schema served at /graphql from its SDL,
+// replacing any earlier one. A schema that does not parse or check is
+// logged and leaves the current one in place.
+//
+//export RegisterGraphQLSchema
+func RegisterGraphQLSchema(cSDL *C.char) int {
+	if cSDL == nil {
+		return exportError(exportInvalid, "cSDL is nil in RegisterGraphQLSchema")
+	}
+	schema, err := buildGQLSchema(C.GoString(cSDL))
+	if err != nil {
+		return exportError(exportInvalid, "Invalid GraphQL schema", "error", err)
+	}
+	graphqlMu.Lock()
+	graphqlSchema = schema
+	graphqlMu.Unlock()
+
+	slog.Info("GraphQL schema registered", "types", len(schema.types))
+	auditConfigChange("RegisterGraphQLSchema", map[string]string{"query": schema.roots["query"], "mutation": schema.roots["mutation"]})
+
+	return 0
+}
+
+// RegisterGraphQLResolver resolves a field through a host callback. cField
+// is "Type.field", or "Type" to resolve all of the type's fields that have
+// no resolver of their own; fields without any resolver read the parent
+// object's property of the same name. The callback gets a GraphQLResolve
+// JSON object and returns a GraphQLResult.
+//
+//export RegisterGraphQLResolver
+func RegisterGraphQLResolver(cField *C.char, cHandler uintptr) int {
+	if cField == nil || cHandler == 0 {
+		return exportError(exportInvalid, "One or more parameters are nil in RegisterGraphQLResolver")
+	}
+	field := C.GoString(cField)
+	if field == "" || strings.HasPrefix(field, "__") || strings.Count(field, ".") > 1 {
+		return exportError(exportInvalid, "Invalid field in RegisterGraphQLResolver", "field", field)
+	}
+	graphqlMu.Lock()
+	graphqlResolvers[field] = cHandler
+	graphqlMu.Unlock()
+
+	slog.Info("GraphQL resolver registered", "field", field)
+	auditConfigChange("RegisterGraphQLResolver", map[string]string{"field": field})
+
+	return 0
+}
+
+// ConfigureGraphQL sets the /graphql endpoint options. cOptions is a JSON
+// GraphQLOptions object.
+//
+//export ConfigureGraphQL
+func ConfigureGraphQL(cOptions *C.char) int {
+	if cOptions == nil {
+		return exportError(exportInvalid, "cOptions is nil in ConfigureGraphQL")
+	}
+	options := C.GoString(cOptions)
+	var opts GraphQLOptions
+	if options != "" {
+		if err := json.Unmarshal([]byte(options), &opts); err != nil {
+			return exportError(exportInvalid, "Invalid GraphQL options", "error", err)
+		}
+	}
+	if opts.MaxDepth < 0 {
+		return exportError(exportInvalid, "Invalid GraphQL options", "max_depth", opts.MaxDepth)
+	}
+	graphqlMu.Lock()
+	graphqlOptions = opts
+	graphqlMu.Unlock()
+
+	slog.Info("GraphQL configured", "graphiql", opts.GraphiQL, "introspection", opts.introspectionEnabled(), "max_depth", opts.MaxDepth)
+	auditConfigChange("ConfigureGraphQL", map[string]string{"options": options})
+
+	return 0
+}
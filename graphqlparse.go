@@ -0,0 +1,948 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// gqlLocation is a 1-based line and column in a GraphQL source
+type gqlLocation struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// gqlError is a GraphQL error as sent in the "errors" list
+type gqlError struct {
+	Message   string        `json:"message"`
+	Locations []gqlLocation `json:"locations,omitempty"`
+	Path      []interface{} `json:"path,omitempty"`
+}
+
+func (e *gqlError) Error() string {
+	if len(e.Locations) == 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("%s (line %d, column %d)", e.Message, e.Locations[0].Line, e.Locations[0].Column)
+}
+
+func gqlErrorf(loc gqlLocation, format string, args ...interface{}) *gqlError {
+	return &gqlError{Message: fmt.Sprintf(format, args...), Locations: []gqlLocation{loc}}
+}
+
+type gqlTokenKind int
+
+const (
+	gqlEOF gqlTokenKind = iota
+	gqlPunct
+	gqlName
+	gqlInt
+	gqlFloat
+	gqlString
+)
+
+type gqlToken struct {
+	kind  gqlTokenKind
+	value string
+	loc   gqlLocation
+}
+
+// gqlLexer splits a GraphQL source into tokens, skipping whitespace,
+// commas, and comments
+type gqlLexer struct {
+	src       string
+	pos       int
+	line      int
+	lineStart int
+}
+
+func (l *gqlLexer) loc() gqlLocation {
+	return gqlLocation{Line: l.line, Column: l.pos - l.lineStart + 1}
+}
+
+func (l *gqlLexer) newline() {
+	l.line++
+	l.lineStart = l.pos
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z')
+}
+
+func isNameChar(c byte) bool {
+	return isNameStart(c) || (c >= '0' && c <= '9')
+}
+
+func (l *gqlLexer) next() gqlToken {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '\n':
+			l.pos++
+			l.newline()
+		case c == '\r':
+			l.pos++
+			if l.pos < len(l.src) && l.src[l.pos] == '\n' {
+				l.pos++
+			}
+			l.newline()
+		case c == ' ' || c == '\t' || c == ',':
+			l.pos++
+		case strings.HasPrefix(l.src[l.pos:], "\ufeff"):
+			l.pos += 3
+		case c == '#':
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' && l.src[l.pos] != '\r' {
+				l.pos++
+			}
+		default:
+			return l.token()
+		}
+	}
+	return gqlToken{kind: gqlEOF, loc: l.loc()}
+}
+
+func (l *gqlLexer) token() gqlToken {
+	loc := l.loc()
+	c := l.src[l.pos]
+	switch {
+	case strings.HasPrefix(l.src[l.pos:], "..."):
+		l.pos += 3
+		return gqlToken{kind: gqlPunct, value: "...", loc: loc}
+	case strings.IndexByte("!$&():=@[]{|}", c) >= 0:
+		l.pos++
+		return gqlToken{kind: gqlPunct, value: string(c), loc: loc}
+	case isNameStart(c):
+		start := l.pos
+		for l.pos < len(l.src) && isNameChar(l.src[l.pos]) {
+			l.pos++
+		}
+		return gqlToken{kind: gqlName, value: l.src[start:l.pos], loc: loc}
+	case c == '-' || (c >= '0' && c <= '9'):
+		return l.number(loc)
+	case strings.HasPrefix(l.src[l.pos:], `"""`):
+		return l.blockString(loc)
+	case c == '"':
+		return l.string(loc)
+	}
+	r, _ := utf8.DecodeRuneInString(l.src[l.pos:])
+	panic(gqlErrorf(loc, "Syntax Error: Unexpected character %q.", r))
+}
+
+func (l *gqlLexer) digits(loc gqlLocation) {
+	start := l.pos
+	for l.pos < len(l.src) && l.src[l.pos] >= '0' && l.src[l.pos] <= '9' {
+		l.pos++
+	}
+	if l.pos == start {
+		panic(gqlErrorf(loc, "Syntax Error: Invalid number, expected digit."))
+	}
+}
+
+func (l *gqlLexer) number(loc gqlLocation) gqlToken {
+	start := l.pos
+	kind := gqlInt
+	if l.src[l.pos] == '-' {
+		l.pos++
+	}
+	if l.pos < len(l.src) && l.src[l.pos] == '0' {
+		l.pos++
+		if l.pos < len(l.src) && l.src[l.pos] >= '0' && l.src[l.pos] <= '9' {
+			panic(gqlErrorf(loc, "Syntax Error: Invalid number, unexpected digit after 0."))
+		}
+	} else {
+		l.digits(loc)
+	}
+	if l.pos < len(l.src) && l.src[l.pos] == '.' {
+		kind = gqlFloat
+		l.pos++
+		l.digits(loc)
+	}
+	if l.pos < len(l.src) && (l.src[l.pos] == 'e' || l.src[l.pos] == 'E') {
+		kind = gqlFloat
+		l.pos++
+		if l.pos < len(l.src) && (l.src[l.pos] == '+' || l.src[l.pos] == '-') {
+			l.pos++
+		}
+		l.digits(loc)
+	}
+	if l.pos < len(l.src) && (l.src[l.pos] == '.' || isNameStart(l.src[l.pos])) {
+		panic(gqlErrorf(loc, "Syntax Error: Invalid number %q.", l.src[start:l.pos+1]))
+	}
+	return gqlToken{kind: kind, value: l.src[start:l.pos], loc: loc}
+}
+
+func (l *gqlLexer) string(loc gqlLocation) gqlToken {
+	l.pos++
+	var b strings.Builder
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '"':
+			l.pos++
+			return gqlToken{kind: gqlString, value: b.String(), loc: loc}
+		case c == '\n' || c == '\r':
+			panic(gqlErrorf(loc, "Syntax Error: Unterminated string."))
+		case c == '\\':
+			if l.pos+1 >= len(l.src) {
+				panic(gqlErrorf(loc, "Syntax Error: Unterminated string."))
+			}
+			escape := l.src[l.pos+1]
+			l.pos += 2
+			switch escape {
+			case '"', '\\', '/':
+				b.WriteByte(escape)
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				b.WriteRune(l.unicodeEscape(loc))
+			default:
+				panic(gqlErrorf(loc, "Syntax Error: Invalid character escape sequence \\%c.", escape))
+			}
+		default:
+			b.WriteByte(c)
+			l.pos++
+		}
+	}
+	panic(gqlErrorf(loc, "Syntax Error: Unterminated string."))
+}
+
+func (l *gqlLexer) unicodeEscape(loc gqlLocation) rune {
+	end := l.pos + 4
+	if l.pos < len(l.src) && l.src[l.pos] == '{' {
+		end = strings.IndexByte(l.src[l.pos:], '}')
+		if end < 0 {
+			panic(gqlErrorf(loc, "Syntax Error: Invalid Unicode escape sequence."))
+		}
+		end += l.pos
+		n, err := strconv.ParseUint(l.src[l.pos+1:end], 16, 32)
+		l.pos = end + 1
+		if err != nil || !utf8.ValidRune(rune(n)) {
+			panic(gqlErrorf(loc, "Syntax Error: Invalid Unicode escape sequence."))
+		}
+		return rune(n)
+	}
+	if end > len(l.src) {
+		panic(gqlErrorf(loc, "Syntax Error: Invalid Unicode escape sequence."))
+	}
+	n, err := strconv.ParseUint(l.src[l.pos:end], 16, 16)
+	l.pos = end
+	if err != nil {
+		panic(gqlErrorf(loc, "Syntax Error: Invalid Unicode escape sequence."))
+	}
+	r := rune(n)
+	// Surrogate pairs spell characters outside the BMP
+	if r >= 0xd800 && r < 0xdc00 && strings.HasPrefix(l.src[l.pos:], `\u`) && l.pos+6 <= len(l.src) {
+		if low, err := strconv.ParseUint(l.src[l.pos+2:l.pos+6], 16, 16); err == nil && low >= 0xdc00 && low < 0xe000 {
+			l.pos += 6
+			return (r-0xd800)<<10 + rune(low) - 0xdc00 + 0x10000
+		}
+	}
+	return r
+}
+
+func (l *gqlLexer) blockString(loc gqlLocation) gqlToken {
+	l.pos += 3
+	var b strings.Builder
+	for l.pos < len(l.src) {
+		switch {
+		case strings.HasPrefix(l.src[l.pos:], `"""`):
+			l.pos += 3
+			return gqlToken{kind: gqlString, value: blockStringValue(b.String()), loc: loc}
+		case strings.HasPrefix(l.src[l.pos:], `\"""`):
+			b.WriteString(`"""`)
+			l.pos += 4
+		case l.src[l.pos] == '\n' || l.src[l.pos] == '\r':
+			if l.src[l.pos] == '\r' && l.pos+1 < len(l.src) && l.src[l.pos+1] == '\n' {
+				l.pos++
+			}
+			b.WriteByte('\n')
+			l.pos++
+			l.newline()
+		default:
+			b.WriteByte(l.src[l.pos])
+			l.pos++
+		}
+	}
+	panic(gqlErrorf(loc, "Syntax Error: Unterminated string."))
+}
+
+// blockStringValue strips a block string's common indentation and its
+// leading and trailing blank lines
+func blockStringValue(raw string) string {
+	lines := strings.Split(raw, "\n")
+	indent := -1
+	for _, line := range lines[1:] {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed == "" {
+			continue
+		}
+		if n := len(line) - len(trimmed); indent < 0 || n < indent {
+			indent = n
+		}
+	}
+	if indent > 0 {
+		for i := 1; i < len(lines); i++ {
+			if len(lines[i]) >= indent {
+				lines[i] = lines[i][indent:]
+			} else {
+				lines[i] = strings.TrimLeft(lines[i], " \t")
+			}
+		}
+	}
+	for len(lines) > 0 && strings.TrimLeft(lines[0], " \t") == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimLeft(lines[len(lines)-1], " \t") == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+const (
+	gqlNamedType = iota
+	gqlListType
+	gqlNonNullType
+)
+
+// gqlTypeRef is a type as written in a schema or document: a named type,
+// or a list or non-null wrapper around another
+type gqlTypeRef struct {
+	Kind   int
+	Name   string
+	OfType *gqlTypeRef
+}
+
+func (t *gqlTypeRef) String() string {
+	switch t.Kind {
+	case gqlListType:
+		return "[" + t.OfType.String() + "]"
+	case gqlNonNullType:
+		return t.OfType.String() + "!"
+	}
+	return t.Name
+}
+
+// named is the type inside any wrappers
+func (t *gqlTypeRef) named() string {
+	for t.Kind != gqlNamedType {
+		t = t.OfType
+	}
+	return t.Name
+}
+
+// gqlValue is a literal or variable in a document
+type gqlValue struct {
+	Kind   string // Variable, Int, Float, String, Boolean, Null, Enum, List, or Object
+	Raw    string // variable or enum name, number text, or string value
+	List   []*gqlValue
+	Fields []gqlArgument
+	Loc    gqlLocation
+}
+
+// gqlArgument is a name and value, as in arguments and object literals
+type gqlArgument struct {
+	Name  string
+	Value *gqlValue
+	Loc   gqlLocation
+}
+
+type gqlDirective struct {
+	Name string
+	Args []gqlArgument
+	Loc  gqlLocation
+}
+
+// gqlSelection is a field, fragment spread, or inline fragment
+type gqlSelection struct {
+	Kind          string // Field, FragmentSpread, or InlineFragment
+	Alias         string
+	Name          string // field or fragment name
+	Args          []gqlArgument
+	Directives    []gqlDirective
+	Selections    []*gqlSelection
+	TypeCondition string // inline fragments; empty for none
+	Loc           gqlLocation
+}
+
+// responseKey is the name a field is returned under
+func (s *gqlSelection) responseKey() string {
+	if s.Alias != "" {
+		return s.Alias
+	}
+	return s.Name
+}
+
+type gqlVariableDef struct {
+	Name    string
+	Type    *gqlTypeRef
+	Default *gqlValue
+	Loc     gqlLocation
+}
+
+type gqlOperation struct {
+	Kind       string // query, mutation, or subscription
+	Name       string
+	Variables  []gqlVariableDef
+	Directives []gqlDirective
+	Selections []*gqlSelection
+	Loc        gqlLocation
+}
+
+type gqlFragment struct {
+	Name          string
+	TypeCondition string
+	Directives    []gqlDirective
+	Selections    []*gqlSelection
+	Loc           gqlLocation
+}
+
+// gqlDocument is a parsed query document
+type gqlDocument struct {
+	Operations []*gqlOperation
+	Fragments  []*gqlFragment
+}
+
+// gqlTypeDef is a type declared in a schema
+type gqlTypeDef struct {
+	Kind        string // SCALAR, OBJECT, INTERFACE, UNION, ENUM, or INPUT_OBJECT
+	Name        string
+	Description string
+	Interfaces  []string
+	Fields      []*gqlFieldDef // object and interface fields, or input object fields
+	Members     []string       // union member types
+	EnumValues  []*gqlEnumValue
+	Loc         gqlLocation
+}
+
+func (t *gqlTypeDef) field(name string) *gqlFieldDef {
+	for _, f := range t.Fields {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+func (t *gqlTypeDef) enumValue(name string) *gqlEnumValue {
+	for _, v := range t.EnumValues {
+		if v.Name == name {
+			return v
+		}
+	}
+	return nil
+}
+
+// gqlFieldDef is a field, argument, or input field declared in a schema
+type gqlFieldDef struct {
+	Name              string
+	Description       string
+	Args              []*gqlFieldDef
+	Type              *gqlTypeRef
+	Default           *gqlValue
+	Deprecated        bool
+	DeprecationReason string
+	Loc               gqlLocation
+}
+
+func (f *gqlFieldDef) arg(name string) *gqlFieldDef {
+	for _, a := range f.Args {
+		if a.Name == name {
+			return a
+		}
+	}
+	return nil
+}
+
+type gqlEnumValue struct {
+	Name              string
+	Description       string
+	Deprecated        bool
+	DeprecationReason string
+}
+
+type gqlDirectiveDef struct {
+	Name        string
+	Description string
+	Args        []*gqlFieldDef
+	Locations   []string
+	Repeatable  bool
+}
+
+// gqlSchemaDoc is a parsed schema definition document
+type gqlSchemaDoc struct {
+	Types      []*gqlTypeDef
+	Extensions []*gqlTypeDef
+	Roots      map[string]string // operation kind -> root type name
+	Directives []*gqlDirectiveDef
+}
+
+type gqlParser struct {
+	lex *gqlLexer
+	tok gqlToken
+}
+
+func newGQLParser(src string) *gqlParser {
+	p := &gqlParser{lex: &gqlLexer{src: src, line: 1}}
+	p.tok = p.lex.next()
+	return p
+}
+
+// gqlParse runs a parse, turning syntax errors into a returned error
+func gqlParse[T any](src string, parse func(p *gqlParser) T) (result T, err *gqlError) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*gqlError)
+			if !ok {
+				panic(r)
+			}
+			err = e
+		}
+	}()
+	return parse(newGQLParser(src)), nil
+}
+
+func (p *gqlParser) advance() gqlToken {
+	tok := p.tok
+	p.tok = p.lex.next()
+	return tok
+}
+
+func (p *gqlParser) unexpected() {
+	if p.tok.kind == gqlEOF {
+		panic(gqlErrorf(p.tok.loc, "Syntax Error: Unexpected <EOF>."))
+	}
+	panic(gqlErrorf(p.tok.loc, "Syntax Error: Unexpected %q.", p.tok.value))
+}
+
+func (p *gqlParser) is(punct string) bool {
+	return p.tok.kind == gqlPunct && p.tok.value == punct
+}
+
+func (p *gqlParser) isKeyword(name string) bool {
+	return p.tok.kind == gqlName && p.tok.value == name
+}
+
+func (p *gqlParser) skip(punct string) bool {
+	if p.is(punct) {
+		p.advance()
+		return true
+	}
+	return false
+}
+
+func (p *gqlParser) expect(punct string) gqlToken {
+	if !p.is(punct) {
+		if p.tok.kind == gqlEOF {
+			panic(gqlErrorf(p.tok.loc, "Syntax Error: Expected %q, found <EOF>.", punct))
+		}
+		panic(gqlErrorf(p.tok.loc, "Syntax Error: Expected %q, found %q.", punct, p.tok.value))
+	}
+	return p.advance()
+}
+
+func (p *gqlParser) name() gqlToken {
+	if p.tok.kind != gqlName {
+		if p.tok.kind == gqlEOF {
+			panic(gqlErrorf(p.tok.loc, "Syntax Error: Expected Name, found <EOF>."))
+		}
+		panic(gqlErrorf(p.tok.loc, "Syntax Error: Expected Name, found %q.", p.tok.value))
+	}
+	return p.advance()
+}
+
+func (p *gqlParser) keyword(name string) {
+	if !p.isKeyword(name) {
+		panic(gqlErrorf(p.tok.loc, "Syntax Error: Expected %q, found %q.", name, p.tok.value))
+	}
+	p.advance()
+}
+
+// parseDocument parses an executable document: operations and fragments
+func (p *gqlParser) parseDocument() *gqlDocument {
+	doc := &gqlDocument{}
+	for p.tok.kind != gqlEOF {
+		switch {
+		case p.is("{"):
+			loc := p.tok.loc
+			doc.Operations = append(doc.Operations, &gqlOperation{Kind: "query", Selections: p.selectionSet(), Loc: loc})
+		case p.isKeyword("query") || p.isKeyword("mutation") || p.isKeyword("subscription"):
+			doc.Operations = append(doc.Operations, p.operation())
+		case p.isKeyword("fragment"):
+			doc.Fragments = append(doc.Fragments, p.fragment())
+		default:
+			p.unexpected()
+		}
+	}
+	if len(doc.Operations) == 0 && len(doc.Fragments) == 0 {
+		p.unexpected()
+	}
+	return doc
+}
+
+func (p *gqlParser) operation() *gqlOperation {
+	tok := p.advance()
+	op := &gqlOperation{Kind: tok.value, Loc: tok.loc}
+	if p.tok.kind == gqlName {
+		op.Name = p.advance().value
+	}
+	if p.skip("(") {
+		for !p.skip(")") {
+			loc := p.expect("$").loc
+			v := gqlVariableDef{Name: p.name().value, Loc: loc}
+			p.expect(":")
+			v.Type = p.typeRef()
+			if p.skip("=") {
+				v.Default = p.value(true)
+			}
+			p.directives(true)
+			op.Variables = append(op.Variables, v)
+		}
+	}
+	op.Directives = p.directives(false)
+	op.Selections = p.selectionSet()
+	return op
+}
+
+func (p *gqlParser) fragment() *gqlFragment {
+	loc := p.advance().loc
+	f := &gqlFragment{Loc: loc}
+	f.Name = p.name().value
+	if f.Name == "on" {
+		panic(gqlErrorf(loc, "Syntax Error: Unexpected Name \"on\"."))
+	}
+	p.keyword("on")
+	f.TypeCondition = p.name().value
+	f.Directives = p.directives(false)
+	f.Selections = p.selectionSet()
+	return f
+}
+
+func (p *gqlParser) selectionSet() []*gqlSelection {
+	p.expect("{")
+	var selections []*gqlSelection
+	for !p.skip("}") {
+		selections = append(selections, p.selection())
+	}
+	if len(selections) == 0 {
+		p.unexpected()
+	}
+	return selections
+}
+
+func (p *gqlParser) selection() *gqlSelection {
+	if p.is("...") {
+		loc := p.advance().loc
+		if p.tok.kind == gqlName && p.tok.value != "on" {
+			return &gqlSelection{Kind: "FragmentSpread", Name: p.advance().value, Directives: p.directives(false), Loc: loc}
+		}
+		s := &gqlSelection{Kind: "InlineFragment", Loc: loc}
+		if p.isKeyword("on") {
+			p.advance()
+			s.TypeCondition = p.name().value
+		}
+		s.Directives = p.directives(false)
+		s.Selections = p.selectionSet()
+		return s
+	}
+	tok := p.name()
+	s := &gqlSelection{Kind: "Field", Name: tok.value, Loc: tok.loc}
+	if p.skip(":") {
+		s.Alias, s.Name = s.Name, p.name().value
+	}
+	s.Args = p.arguments(false)
+	s.Directives = p.directives(false)
+	if p.is("{") {
+		s.Selections = p.selectionSet()
+	}
+	return s
+}
+
+func (p *gqlParser) arguments(constant bool) []gqlArgument {
+	if !p.skip("(") {
+		return nil
+	}
+	var args []gqlArgument
+	for !p.skip(")") {
+		tok := p.name()
+		p.expect(":")
+		args = append(args, gqlArgument{Name: tok.value, Value: p.value(constant), Loc: tok.loc})
+	}
+	if len(args) == 0 {
+		p.unexpected()
+	}
+	return args
+}
+
+func (p *gqlParser) directives(constant bool) []gqlDirective {
+	var directives []gqlDirective
+	for p.is("@") {
+		loc := p.advance().loc
+		directives = append(directives, gqlDirective{Name: p.name().value, Args: p.arguments(constant), Loc: loc})
+	}
+	return directives
+}
+
+func (p *gqlParser) typeRef() *gqlTypeRef {
+	var t *gqlTypeRef
+	if p.skip("[") {
+		t = &gqlTypeRef{Kind: gqlListType, OfType: p.typeRef()}
+		p.expect("]")
+	} else {
+		t = &gqlTypeRef{Kind: gqlNamedType, Name: p.name().value}
+	}
+	if p.skip("!") {
+		t = &gqlTypeRef{Kind: gqlNonNullType, OfType: t}
+	}
+	return t
+}
+
+// value parses a value literal; constant values may not hold variables
+func (p *gqlParser) value(constant bool) *gqlValue {
+	tok := p.tok
+	switch {
+	case p.is("$") && !constant:
+		p.advance()
+		return &gqlValue{Kind: "Variable", Raw: p.name().value, Loc: tok.loc}
+	case p.is("["):
+		p.advance()
+		v := &gqlValue{Kind: "List", Loc: tok.loc}
+		for !p.skip("]") {
+			v.List = append(v.List, p.value(constant))
+		}
+		return v
+	case p.is("{"):
+		p.advance()
+		v := &gqlValue{Kind: "Object", Loc: tok.loc}
+		for !p.skip("}") {
+			name := p.name()
+			p.expect(":")
+			v.Fields = append(v.Fields, gqlArgument{Name: name.value, Value: p.value(constant), Loc: name.loc})
+		}
+		return v
+	case tok.kind == gqlInt:
+		p.advance()
+		return &gqlValue{Kind: "Int", Raw: tok.value, Loc: tok.loc}
+	case tok.kind == gqlFloat:
+		p.advance()
+		return &gqlValue{Kind: "Float", Raw: tok.value, Loc: tok.loc}
+	case tok.kind == gqlString:
+		p.advance()
+		return &gqlValue{Kind: "String", Raw: tok.value, Loc: tok.loc}
+	case tok.kind == gqlName:
+		p.advance()
+		switch tok.value {
+		case "true", "false":
+			return &gqlValue{Kind: "Boolean", Raw: tok.value, Loc: tok.loc}
+		case "null":
+			return &gqlValue{Kind: "Null", Loc: tok.loc}
+		}
+		return &gqlValue{Kind: "Enum", Raw: tok.value, Loc: tok.loc}
+	}
+	p.unexpected()
+	return nil
+}
+
+// printGQLValue renders a value literal, as introspection reports defaults
+func printGQLValue(v *gqlValue) string {
+	switch v.Kind {
+	case "Variable":
+		return "$" + v.Raw
+	case "String":
+		return strconv.Quote(v.Raw)
+	case "Null":
+		return "null"
+	case "List":
+		items := make([]string, len(v.List))
+		for i, item := range v.List {
+			items[i] = printGQLValue(item)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case "Object":
+		fields := make([]string, len(v.Fields))
+		for i, f := range v.Fields {
+			fields[i] = f.Name + ": " + printGQLValue(f.Value)
+		}
+		return "{" + strings.Join(fields, ", ") + "}"
+	}
+	return v.Raw
+}
+
+// parseSchemaDocument parses a schema definition document
+func (p *gqlParser) parseSchemaDocument() *gqlSchemaDoc {
+	doc := &gqlSchemaDoc{Roots: make(map[string]string)}
+	for p.tok.kind != gqlEOF {
+		description := p.description()
+		if p.isKeyword("extend") {
+			p.advance()
+			if p.isKeyword("schema") {
+				p.schemaDefinition(doc)
+				continue
+			}
+			doc.Extensions = append(doc.Extensions, p.typeDefinition(""))
+			continue
+		}
+		switch {
+		case p.isKeyword("schema"):
+			p.schemaDefinition(doc)
+		case p.isKeyword("directive"):
+			doc.Directives = append(doc.Directives, p.directiveDefinition(description))
+		default:
+			doc.Types = append(doc.Types, p.typeDefinition(description))
+		}
+	}
+	return doc
+}
+
+func (p *gqlParser) description() string {
+	if p.tok.kind == gqlString {
+		return p.advance().value
+	}
+	return ""
+}
+
+func (p *gqlParser) schemaDefinition(doc *gqlSchemaDoc) {
+	p.advance()
+	p.directives(true)
+	if !p.is("{") {
+		return
+	}
+	p.advance()
+	for !p.skip("}") {
+		kind := p.name()
+		if kind.value != "query" && kind.value != "mutation" && kind.value != "subscription" {
+			panic(gqlErrorf(kind.loc, "Syntax Error: Unexpected %q.", kind.value))
+		}
+		p.expect(":")
+		doc.Roots[kind.value] = p.name().value
+	}
+}
+
+func (p *gqlParser) directiveDefinition(description string) *gqlDirectiveDef {
+	p.advance()
+	p.expect("@")
+	d := &gqlDirectiveDef{Name: p.name().value, Description: description}
+	d.Args = p.inputValueDefs("(", ")")
+	if p.isKeyword("repeatable") {
+		p.advance()
+		d.Repeatable = true
+	}
+	p.keyword("on")
+	p.skip("|")
+	for {
+		d.Locations = append(d.Locations, p.name().value)
+		if !p.skip("|") {
+			return d
+		}
+	}
+}
+
+var gqlTypeKeywords = map[string]string{
+	"scalar": "SCALAR", "type": "OBJECT", "interface": "INTERFACE",
+	"union": "UNION", "enum": "ENUM", "input": "INPUT_OBJECT",
+}
+
+func (p *gqlParser) typeDefinition(description string) *gqlTypeDef {
+	kind, ok := gqlTypeKeywords[p.tok.value]
+	if p.tok.kind != gqlName || !ok {
+		p.unexpected()
+	}
+	p.advance()
+	tok := p.name()
+	t := &gqlTypeDef{Kind: kind, Name: tok.value, Description: description, Loc: tok.loc}
+	if (kind == "OBJECT" || kind == "INTERFACE") && p.isKeyword("implements") {
+		p.advance()
+		p.skip("&")
+		for {
+			t.Interfaces = append(t.Interfaces, p.name().value)
+			if !p.skip("&") {
+				break
+			}
+		}
+	}
+	p.directives(true)
+	switch kind {
+	case "OBJECT", "INTERFACE":
+		if p.skip("{") {
+			for !p.skip("}") {
+				t.Fields = append(t.Fields, p.fieldDefinition())
+			}
+		}
+	case "INPUT_OBJECT":
+		t.Fields = p.inputValueDefs("{", "}")
+	case "UNION":
+		if p.skip("=") {
+			p.skip("|")
+			for {
+				t.Members = append(t.Members, p.name().value)
+				if !p.skip("|") {
+					break
+				}
+			}
+		}
+	case "ENUM":
+		if p.skip("{") {
+			for !p.skip("}") {
+				v := &gqlEnumValue{Description: p.description(), Name: p.name().value}
+				v.Deprecated, v.DeprecationReason = deprecation(p.directives(true))
+				t.EnumValues = append(t.EnumValues, v)
+			}
+		}
+	}
+	return t
+}
+
+func (p *gqlParser) fieldDefinition() *gqlFieldDef {
+	description := p.description()
+	tok := p.name()
+	f := &gqlFieldDef{Name: tok.value, Description: description, Loc: tok.loc}
+	f.Args = p.inputValueDefs("(", ")")
+	p.expect(":")
+	f.Type = p.typeRef()
+	f.Deprecated, f.DeprecationReason = deprecation(p.directives(true))
+	return f
+}
+
+func (p *gqlParser) inputValueDefs(open, close string) []*gqlFieldDef {
+	if !p.skip(open) {
+		return nil
+	}
+	var defs []*gqlFieldDef
+	for !p.skip(close) {
+		description := p.description()
+		tok := p.name()
+		f := &gqlFieldDef{Name: tok.value, Description: description, Loc: tok.loc}
+		p.expect(":")
+		f.Type = p.typeRef()
+		if p.skip("=") {
+			f.Default = p.value(true)
+		}
+		f.Deprecated, f.DeprecationReason = deprecation(p.directives(true))
+		defs = append(defs, f)
+	}
+	return defs
+}
+
+// deprecation reads an @deprecated directive
+func deprecation(directives []gqlDirective) (bool, string) {
+	for _, d := range directives {
+		if d.Name != "deprecated" {
+			continue
+		}
+		for _, arg := range d.Args {
+			if arg.Name == "reason" && arg.Value.Kind == "String" {
+				return true, arg.Value.Raw
+			}
+		}
+		return true, "No longer supported"
+	}
+	return false, ""
+}
@@ -0,0 +1,491 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// gqlBuiltins declares the standard scalars and directives and the
+// introspection types every schema carries
+const gqlBuiltins = `
+"The ` + "`Int`" + ` scalar type represents non-fractional signed whole numeric values between -(2^31) and 2^31 - 1."
+scalar Int
+"The ` + "`Float`" + ` scalar type represents signed double-precision fractional values."
+scalar Float
+"The ` + "`String`" + ` scalar type represents textual data as UTF-8 character sequences."
+scalar String
+"The ` + "`Boolean`" + ` scalar type represents ` + "`true`" + ` or ` + "`false`" + `."
+scalar Boolean
+"The ` + "`ID`" + ` scalar type represents a unique identifier, serialized as a string."
+scalar ID
+
+"Directs the executor to include this field or fragment only when the ` + "`if`" + ` argument is true."
+directive @include(if: Boolean!) on FIELD | FRAGMENT_SPREAD | INLINE_FRAGMENT
+"Directs the executor to skip this field or fragment when the ` + "`if`" + ` argument is true."
+directive @skip(if: Boolean!) on FIELD | FRAGMENT_SPREAD | INLINE_FRAGMENT
+"Marks an element of a GraphQL schema as no longer supported."
+directive @deprecated(reason: String = "No longer supported") on FIELD_DEFINITION | ARGUMENT_DEFINITION | INPUT_FIELD_DEFINITION | ENUM_VALUE
+"Exposes a URL that specifies the behavior of this scalar."
+directive @specifiedBy(url: String!) on SCALAR
+
+type __Schema {
+  description: String
+  types: [__Type!]!
+  queryType: __Type!
+  mutationType: __Type
+  subscriptionType: __Type
+  directives: [__Directive!]!
+}
+
+type __Type {
+  kind: __TypeKind!
+  name: String
+  description: String
+  specifiedByURL: String
+  fields(includeDeprecated: Boolean = false): [__Field!]
+  interfaces: [__Type!]
+  possibleTypes: [__Type!]
+  enumValues(includeDeprecated: Boolean = false): [__EnumValue!]
+  inputFields(includeDeprecated: Boolean = false): [__InputValue!]
+  ofType: __Type
+  isOneOf: Boolean
+}
+
+type __Field {
+  name: String!
+  description: String
+  args(includeDeprecated: Boolean = false): [__InputValue!]!
+  type: __Type!
+  isDeprecated: Boolean!
+  deprecationReason: String
+}
+
+type __InputValue {
+  name: String!
+  description: String
+  type: __Type!
+  defaultValue: String
+  isDeprecated: Boolean!
+  deprecationReason: String
+}
+
+type __EnumValue {
+  name: String!
+  description: String
+  isDeprecated: Boolean!
+  deprecationReason: String
+}
+
+type __Directive {
+  name: String!
+  description: String
+  isRepeatable: Boolean!
+  locations: [__DirectiveLocation!]!
+  args(includeDeprecated: Boolean = false): [__InputValue!]!
+}
+
+enum __TypeKind { SCALAR OBJECT INTERFACE UNION ENUM INPUT_OBJECT LIST NON_NULL }
+
+enum __DirectiveLocation {
+  QUERY MUTATION SUBSCRIPTION FIELD FRAGMENT_DEFINITION FRAGMENT_SPREAD INLINE_FRAGMENT VARIABLE_DEFINITION
+  SCHEMA SCALAR OBJECT FIELD_DEFINITION ARGUMENT_DEFINITION INTERFACE UNION ENUM ENUM_VALUE INPUT_OBJECT INPUT_FIELD_DEFINITION
+}
+`
+
+// Meta fields every selection set may query; __schema and __type only on
+// the query root
+var (
+	gqlTypenameField = &gqlFieldDef{Name: "__typename", Type: gqlNonNull(gqlNamed("String"))}
+	gqlSchemaField   = &gqlFieldDef{Name: "__schema", Type: gqlNonNull(gqlNamed("__Schema"))}
+	gqlTypeField     = &gqlFieldDef{Name: "__type", Type: gqlNamed("__Type"),
+		Args: []*gqlFieldDef{{Name: "name", Type: gqlNonNull(gqlNamed("String"))}}}
+)
+
+func gqlNamed(name string) *gqlTypeRef { return &gqlTypeRef{Kind: gqlNamedType, Name: name} }
+
+func gqlNonNull(t *gqlTypeRef) *gqlTypeRef { return &gqlTypeRef{Kind: gqlNonNullType, OfType: t} }
+
+// gqlSchema is a registered schema, checked and ready to execute against
+type gqlSchema struct {
+	types      map[string]*gqlTypeDef
+	roots      map[string]string // operation kind -> root type name
+	directives []*gqlDirectiveDef
+	possible   map[string]map[string]bool // abstract type -> its object types
+
+	// introspection is the __schema value, and introspectionTypes the
+	// __Type values by name. They are built once; the maps refer to each
+	// other, so they are only walked through selection sets.
+	introspection      map[string]interface{}
+	introspectionTypes map[string]map[string]interface{}
+}
+
+// buildGQLSchema parses and checks a schema definition
+func buildGQLSchema(sdl string) (*gqlSchema, error) {
+	builtins, err := gqlParse(gqlBuiltins, (*gqlParser).parseSchemaDocument)
+	if err != nil {
+		panic(err)
+	}
+	doc, err := gqlParse(sdl, (*gqlParser).parseSchemaDocument)
+	if err != nil {
+		return nil, err
+	}
+	s := &gqlSchema{
+		types:      make(map[string]*gqlTypeDef),
+		roots:      doc.Roots,
+		directives: builtins.Directives,
+		possible:   make(map[string]map[string]bool),
+	}
+	for _, t := range builtins.Types {
+		s.types[t.Name] = t
+	}
+	for _, t := range doc.Types {
+		if strings.HasPrefix(t.Name, "__") {
+			return nil, gqlErrorf(t.Loc, "Name %q must not begin with \"__\", which is reserved by GraphQL introspection.", t.Name)
+		}
+		if _, ok := s.types[t.Name]; ok {
+			return nil, gqlErrorf(t.Loc, "There can be only one type named %q.", t.Name)
+		}
+		s.types[t.Name] = t
+	}
+	for _, ext := range doc.Extensions {
+		t, ok := s.types[ext.Name]
+		if !ok || strings.HasPrefix(ext.Name, "__") || t.Kind != ext.Kind {
+			return nil, gqlErrorf(ext.Loc, "Cannot extend type %q: no %s of that name is defined.", ext.Name, strings.ToLower(ext.Kind))
+		}
+		t.Interfaces = append(t.Interfaces, ext.Interfaces...)
+		t.Fields = append(t.Fields, ext.Fields...)
+		t.Members = append(t.Members, ext.Members...)
+		t.EnumValues = append(t.EnumValues, ext.EnumValues...)
+	}
+	for _, d := range doc.Directives {
+		for _, existing := range s.directives {
+			if existing.Name == d.Name {
+				return nil, fmt.Errorf("There can be only one directive named \"@%s\".", d.Name)
+			}
+		}
+		s.directives = append(s.directives, d)
+	}
+	if len(s.roots) == 0 {
+		for kind, name := range map[string]string{"query": "Query", "mutation": "Mutation", "subscription": "Subscription"} {
+			if _, ok := s.types[name]; ok {
+				s.roots[kind] = name
+			}
+		}
+	}
+	if s.roots["query"] == "" {
+		return nil, fmt.Errorf("Query root type must be provided.")
+	}
+	for kind, name := range s.roots {
+		if t, ok := s.types[name]; !ok || t.Kind != "OBJECT" {
+			return nil, fmt.Errorf("%s root type must be an object type, found %q.", strings.ToUpper(kind[:1])+kind[1:], name)
+		}
+	}
+	// Fill the possible types cache up front so execution only reads it
+	for name := range s.types {
+		s.possibleOf(name)
+	}
+	for _, t := range s.types {
+		if err := s.checkType(t); err != nil {
+			return nil, err
+		}
+	}
+	for _, d := range s.directives {
+		for _, arg := range d.Args {
+			if err := s.checkInputValue(arg, "@"+d.Name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	s.buildIntrospection()
+	return s, nil
+}
+
+// checkType verifies a type's references: field types exist and are
+// outputs or inputs as fits, and interfaces and union members are sound
+func (s *gqlSchema) checkType(t *gqlTypeDef) error {
+	seen := make(map[string]bool)
+	for _, f := range t.Fields {
+		if seen[f.Name] {
+			return gqlErrorf(f.Loc, "Field %q can only be defined once.", t.Name+"."+f.Name)
+		}
+		seen[f.Name] = true
+	}
+	switch t.Kind {
+	case "OBJECT", "INTERFACE":
+		if len(t.Fields) == 0 {
+			return gqlErrorf(t.Loc, "Type %s must define one or more fields.", t.Name)
+		}
+		for _, f := range t.Fields {
+			ft, ok := s.types[f.Type.named()]
+			if !ok {
+				return gqlErrorf(f.Loc, "Unknown type %q.", f.Type.named())
+			}
+			if ft.Kind == "INPUT_OBJECT" {
+				return gqlErrorf(f.Loc, "The type of %s.%s must be Output Type but got: %s.", t.Name, f.Name, f.Type)
+			}
+			for _, arg := range f.Args {
+				if err := s.checkInputValue(arg, t.Name+"."+f.Name); err != nil {
+					return err
+				}
+			}
+		}
+		for _, name := range t.Interfaces {
+			iface, ok := s.types[name]
+			if !ok || iface.Kind != "INTERFACE" {
+				return gqlErrorf(t.Loc, "Type %s must only implement Interface types, it cannot implement %s.", t.Name, name)
+			}
+			for _, f := range iface.Fields {
+				impl := t.field(f.Name)
+				if impl == nil {
+					return gqlErrorf(t.Loc, "Interface field %s.%s expected but %s does not provide it.", name, f.Name, t.Name)
+				}
+				if impl.Type.named() != f.Type.named() && !s.possibleOf(f.Type.named())[impl.Type.named()] {
+					return gqlErrorf(impl.Loc, "Interface field %s.%s expects type %s but %s.%s is type %s.", name, f.Name, f.Type, t.Name, f.Name, impl.Type)
+				}
+				for _, arg := range f.Args {
+					if impl.arg(arg.Name) == nil {
+						return gqlErrorf(impl.Loc, "Interface field argument %s.%s(%s:) expected but %s.%s does not provide it.", name, f.Name, arg.Name, t.Name, f.Name)
+					}
+				}
+			}
+		}
+	case "UNION":
+		if len(t.Members) == 0 {
+			return gqlErrorf(t.Loc, "Union type %s must define one or more member types.", t.Name)
+		}
+		for _, name := range t.Members {
+			if member, ok := s.types[name]; !ok || member.Kind != "OBJECT" {
+				return gqlErrorf(t.Loc, "Union type %s can only include Object types, it cannot include %s.", t.Name, name)
+			}
+		}
+	case "ENUM":
+		if len(t.EnumValues) == 0 {
+			return gqlErrorf(t.Loc, "Enum type %s must define one or more values.", t.Name)
+		}
+	case "INPUT_OBJECT":
+		if len(t.Fields) == 0 {
+			return gqlErrorf(t.Loc, "Input Object type %s must define one or more fields.", t.Name)
+		}
+		for _, f := range t.Fields {
+			if err := s.checkInputValue(f, t.Name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *gqlSchema) checkInputValue(f *gqlFieldDef, owner string) error {
+	ft, ok := s.types[f.Type.named()]
+	if !ok {
+		return gqlErrorf(f.Loc, "Unknown type %q.", f.Type.named())
+	}
+	if !isGQLInputType(ft) {
+		return gqlErrorf(f.Loc, "The type of %s(%s:) must be Input Type but got: %s.", owner, f.Name, f.Type)
+	}
+	if f.Default != nil {
+		if _, err := s.coerceLiteral(f.Default, f.Type, nil); err != nil {
+			return gqlErrorf(f.Default.Loc, "Invalid default value for %s(%s:): %s", owner, f.Name, err)
+		}
+	}
+	return nil
+}
+
+func isGQLInputType(t *gqlTypeDef) bool {
+	return t.Kind == "SCALAR" || t.Kind == "ENUM" || t.Kind == "INPUT_OBJECT"
+}
+
+func isGQLCompositeType(t *gqlTypeDef) bool {
+	return t.Kind == "OBJECT" || t.Kind == "INTERFACE" || t.Kind == "UNION"
+}
+
+// possibleOf returns the object types a type may be at runtime
+func (s *gqlSchema) possibleOf(name string) map[string]bool {
+	if set, ok := s.possible[name]; ok {
+		return set
+	}
+	set := make(map[string]bool)
+	t := s.types[name]
+	if t == nil {
+		return set
+	}
+	switch {
+	case t.Kind == "OBJECT":
+		set[name] = true
+	case t.Kind == "UNION":
+		for _, member := range t.Members {
+			set[member] = true
+		}
+	case t.Kind == "INTERFACE":
+		for _, candidate := range s.types {
+			if candidate.Kind != "OBJECT" {
+				continue
+			}
+			for _, iface := range candidate.Interfaces {
+				if iface == name {
+					set[candidate.Name] = true
+				}
+			}
+		}
+	}
+	s.possible[name] = set
+	return set
+}
+
+// field returns the definition of a field on a type, including the meta
+// fields, or nil when the type has no such field
+func (s *gqlSchema) field(t *gqlTypeDef, name string) *gqlFieldDef {
+	switch {
+	case name == "__typename":
+		return gqlTypenameField
+	case name == "__schema" && t.Name == s.roots["query"]:
+		return gqlSchemaField
+	case name == "__type" && t.Name == s.roots["query"]:
+		return gqlTypeField
+	case t.Kind == "OBJECT" || t.Kind == "INTERFACE":
+		return t.field(name)
+	}
+	return nil
+}
+
+// buildIntrospection prepares the __schema and __type values
+func (s *gqlSchema) buildIntrospection() {
+	names := make([]string, 0, len(s.types))
+	for name := range s.types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	named := make(map[string]map[string]interface{}, len(s.types))
+	for _, name := range names {
+		t := s.types[name]
+		named[name] = map[string]interface{}{
+			"kind": t.Kind, "name": name, "description": gqlDescription(t.Description),
+		}
+	}
+	var typeRef func(t *gqlTypeRef) map[string]interface{}
+	typeRef = func(t *gqlTypeRef) map[string]interface{} {
+		switch t.Kind {
+		case gqlListType:
+			return map[string]interface{}{"kind": "LIST", "ofType": typeRef(t.OfType)}
+		case gqlNonNullType:
+			return map[string]interface{}{"kind": "NON_NULL", "ofType": typeRef(t.OfType)}
+		}
+		return named[t.Name]
+	}
+	inputValues := func(defs []*gqlFieldDef) []interface{} {
+		values := make([]interface{}, 0, len(defs))
+		for _, f := range defs {
+			var defaultValue interface{}
+			if f.Default != nil {
+				defaultValue = printGQLValue(f.Default)
+			}
+			values = append(values, map[string]interface{}{
+				"name": f.Name, "description": gqlDescription(f.Description), "type": typeRef(f.Type),
+				"defaultValue": defaultValue, "isDeprecated": f.Deprecated, "deprecationReason": gqlDeprecationReason(f.Deprecated, f.DeprecationReason),
+			})
+		}
+		return values
+	}
+	typeList := func(typeNames []string) []interface{} {
+		list := make([]interface{}, 0, len(typeNames))
+		for _, name := range typeNames {
+			list = append(list, named[name])
+		}
+		return list
+	}
+	for _, name := range names {
+		t, m := s.types[name], named[name]
+		switch t.Kind {
+		case "OBJECT", "INTERFACE":
+			fields := make([]interface{}, 0, len(t.Fields))
+			for _, f := range t.Fields {
+				fields = append(fields, map[string]interface{}{
+					"name": f.Name, "description": gqlDescription(f.Description), "args": inputValues(f.Args), "type": typeRef(f.Type),
+					"isDeprecated": f.Deprecated, "deprecationReason": gqlDeprecationReason(f.Deprecated, f.DeprecationReason),
+				})
+			}
+			m["fields"] = fields
+			m["interfaces"] = typeList(t.Interfaces)
+		case "ENUM":
+			values := make([]interface{}, 0, len(t.EnumValues))
+			for _, v := range t.EnumValues {
+				values = append(values, map[string]interface{}{
+					"name": v.Name, "description": gqlDescription(v.Description),
+					"isDeprecated": v.Deprecated, "deprecationReason": gqlDeprecationReason(v.Deprecated, v.DeprecationReason),
+				})
+			}
+			m["enumValues"] = values
+		case "INPUT_OBJECT":
+			m["inputFields"] = inputValues(t.Fields)
+			m["isOneOf"] = false
+		}
+		if t.Kind == "INTERFACE" || t.Kind == "UNION" {
+			possible := make([]string, 0)
+			for member := range s.possibleOf(name) {
+				possible = append(possible, member)
+			}
+			sort.Strings(possible)
+			m["possibleTypes"] = typeList(possible)
+		}
+	}
+	directives := make([]interface{}, 0, len(s.directives))
+	for _, d := range s.directives {
+		locations := make([]interface{}, len(d.Locations))
+		for i, loc := range d.Locations {
+			locations[i] = loc
+		}
+		directives = append(directives, map[string]interface{}{
+			"name": d.Name, "description": gqlDescription(d.Description), "isRepeatable": d.Repeatable,
+			"locations": locations, "args": inputValues(d.Args),
+		})
+	}
+	schema := map[string]interface{}{"types": typeList(names), "directives": directives}
+	for kind, key := range map[string]string{"query": "queryType", "mutation": "mutationType", "subscription": "subscriptionType"} {
+		if name, ok := s.roots[kind]; ok {
+			schema[key] = named[name]
+		}
+	}
+	s.introspection = schema
+	s.introspectionTypes = named
+}
+
+func gqlDescription(description string) interface{} {
+	if description == "" {
+		return nil
+	}
+	return description
+}
+
+func gqlDeprecationReason(deprecated bool, reason string) interface{} {
+	if !deprecated {
+		return nil
+	}
+	return reason
+}
+
+// gqlIntrospectionResolvers filter deprecated elements out of introspection
+// lists unless includeDeprecated is set
+var gqlIntrospectionResolvers = map[string]func(parent map[string]interface{}, args map[string]interface{}) interface{}{
+	"__Type.fields":      gqlDeprecatedFilter("fields"),
+	"__Type.enumValues":  gqlDeprecatedFilter("enumValues"),
+	"__Type.inputFields": gqlDeprecatedFilter("inputFields"),
+	"__Field.args":       gqlDeprecatedFilter("args"),
+	"__Directive.args":   gqlDeprecatedFilter("args"),
+}
+
+func gqlDeprecatedFilter(key string) func(parent map[string]interface{}, args map[string]interface{}) interface{} {
+	return func(parent map[string]interface{}, args map[string]interface{}) interface{} {
+		items, ok := parent[key].([]interface{})
+		if !ok || args["includeDeprecated"] == true {
+			return parent[key]
+		}
+		kept := make([]interface{}, 0, len(items))
+		for _, item := range items {
+			if item.(map[string]interface{})["isDeprecated"] != true {
+				kept = append(kept, item)
+			}
+		}
+		return kept
+	}
+}
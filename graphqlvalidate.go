@@ -0,0 +1,697 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// gqlValidator checks a document against a schema before it runs
+type gqlValidator struct {
+	schema        *gqlSchema
+	fragments     map[string]*gqlFragment
+	fragmentDepth map[string]int
+	spread        map[string]bool // fragments reached from any operation
+	maxDepth      int
+	introspection bool
+	errors        []*gqlError
+}
+
+// gqlWalk is the state of one pass over a selection set. Operation passes
+// follow fragment spreads to collect variable uses and depth; field rules
+// are only reported where the selections are written.
+type gqlWalk struct {
+	op      *gqlOperation
+	vars    map[string]*gqlVariableDef
+	used    map[string]bool
+	entered map[string]bool
+	report  bool
+}
+
+func (v *gqlValidator) errorf(loc gqlLocation, format string, args ...interface{}) {
+	v.errors = append(v.errors, gqlErrorf(loc, format, args...))
+}
+
+// validateGQLDocument returns the document's validation errors, if any
+func validateGQLDocument(schema *gqlSchema, doc *gqlDocument, opts GraphQLOptions) []*gqlError {
+	v := &gqlValidator{
+		schema:        schema,
+		fragments:     make(map[string]*gqlFragment),
+		fragmentDepth: make(map[string]int),
+		spread:        make(map[string]bool),
+		maxDepth:      opts.MaxDepth,
+		introspection: opts.introspectionEnabled(),
+	}
+	for _, f := range doc.Fragments {
+		if _, ok := v.fragments[f.Name]; ok {
+			v.errorf(f.Loc, "There can be only one fragment named %q.", f.Name)
+			continue
+		}
+		v.fragments[f.Name] = f
+	}
+	for _, f := range doc.Fragments {
+		if v.fragments[f.Name] != f {
+			continue
+		}
+		t, ok := schema.types[f.TypeCondition]
+		if !ok {
+			v.errorf(f.Loc, "Unknown type %q.", f.TypeCondition)
+			continue
+		}
+		if !isGQLCompositeType(t) {
+			v.errorf(f.Loc, "Fragment %q cannot condition on non composite type %q.", f.Name, f.TypeCondition)
+			continue
+		}
+		v.directives(f.Directives, "FRAGMENT_DEFINITION", &gqlWalk{report: true})
+		v.selections(f.Selections, t, &gqlWalk{report: true})
+	}
+	v.fragmentCycles()
+
+	names := make(map[string]bool)
+	for _, op := range doc.Operations {
+		if op.Name == "" && len(doc.Operations) > 1 {
+			v.errorf(op.Loc, "This anonymous operation must be the only defined operation.")
+		}
+		if op.Name != "" {
+			if names[op.Name] {
+				v.errorf(op.Loc, "There can be only one operation named %q.", op.Name)
+			}
+			names[op.Name] = true
+		}
+		v.operation(op)
+	}
+	for _, f := range doc.Fragments {
+		if !v.spread[f.Name] && v.fragments[f.Name] == f {
+			v.errorf(f.Loc, "Fragment %q is never used.", f.Name)
+		}
+	}
+	return v.errors
+}
+
+func (v *gqlValidator) operation(op *gqlOperation) {
+	if op.Kind == "subscription" {
+		v.errorf(op.Loc, "Subscriptions are not supported.")
+		return
+	}
+	rootName, ok := v.schema.roots[op.Kind]
+	if !ok {
+		v.errorf(op.Loc, "Schema is not configured for %ss.", op.Kind)
+		return
+	}
+	w := &gqlWalk{op: op, vars: make(map[string]*gqlVariableDef), used: make(map[string]bool), entered: make(map[string]bool), report: true}
+	for i := range op.Variables {
+		def := &op.Variables[i]
+		if w.vars[def.Name] != nil {
+			v.errorf(def.Loc, "There can be only one variable named \"$%s\".", def.Name)
+			continue
+		}
+		w.vars[def.Name] = def
+		t, ok := v.schema.types[def.Type.named()]
+		if !ok {
+			v.errorf(def.Loc, "Unknown type %q.", def.Type.named())
+			continue
+		}
+		if !isGQLInputType(t) {
+			v.errorf(def.Loc, "Variable \"$%s\" cannot be non-input type %q.", def.Name, def.Type)
+			continue
+		}
+		if def.Default != nil {
+			if _, err := v.schema.coerceLiteral(def.Default, def.Type, nil); err != nil {
+				v.errorf(def.Default.Loc, "Variable \"$%s\" has an invalid default value: %s", def.Name, err)
+			}
+		}
+	}
+	location := map[string]string{"query": "QUERY", "mutation": "MUTATION"}[op.Kind]
+	v.directives(op.Directives, location, w)
+	depth := v.selections(op.Selections, v.schema.types[rootName], w)
+	if v.maxDepth > 0 && depth > v.maxDepth {
+		v.errorf(op.Loc, "Query depth %d exceeds the limit of %d.", depth, v.maxDepth)
+	}
+	for _, def := range op.Variables {
+		if !w.used[def.Name] {
+			if op.Name != "" {
+				v.errorf(def.Loc, "Variable \"$%s\" is never used in operation %q.", def.Name, op.Name)
+			} else {
+				v.errorf(def.Loc, "Variable \"$%s\" is never used.", def.Name)
+			}
+		}
+	}
+}
+
+// selections checks a selection set on parent and returns how many levels
+// of fields it nests
+func (v *gqlValidator) selections(selections []*gqlSelection, parent *gqlTypeDef, w *gqlWalk) int {
+	depth := 0
+	for _, sel := range selections {
+		switch sel.Kind {
+		case "Field":
+			v.directives(sel.Directives, "FIELD", w)
+			if d := v.field(sel, parent, w); d > depth {
+				depth = d
+			}
+		case "InlineFragment":
+			v.directives(sel.Directives, "INLINE_FRAGMENT", w)
+			t := parent
+			if sel.TypeCondition != "" {
+				cond, ok := v.schema.types[sel.TypeCondition]
+				if !ok || !isGQLCompositeType(cond) {
+					if w.report && !ok {
+						v.errorf(sel.Loc, "Unknown type %q.", sel.TypeCondition)
+					} else if w.report {
+						v.errorf(sel.Loc, "Fragment cannot condition on non composite type %q.", sel.TypeCondition)
+					}
+					continue
+				}
+				if w.report && !v.overlap(parent, cond) {
+					v.errorf(sel.Loc, "Fragment cannot be spread here as objects of type %q can never be of type %q.", parent.Name, cond.Name)
+				}
+				t = cond
+			}
+			if d := v.selections(sel.Selections, t, w); d > depth {
+				depth = d
+			}
+		case "FragmentSpread":
+			v.directives(sel.Directives, "FRAGMENT_SPREAD", w)
+			f, ok := v.fragments[sel.Name]
+			if !ok {
+				if w.report {
+					v.errorf(sel.Loc, "Unknown fragment %q.", sel.Name)
+				}
+				continue
+			}
+			cond, ok := v.schema.types[f.TypeCondition]
+			if !ok || !isGQLCompositeType(cond) {
+				continue
+			}
+			if w.report && !v.overlap(parent, cond) {
+				v.errorf(sel.Loc, "Fragment %q cannot be spread here as objects of type %q can never be of type %q.", f.Name, parent.Name, cond.Name)
+			}
+			if w.op == nil {
+				continue
+			}
+			if !w.entered[f.Name] {
+				w.entered[f.Name] = true
+				v.spread[f.Name] = true
+				inner := &gqlWalk{op: w.op, vars: w.vars, used: w.used, entered: w.entered}
+				v.fragmentDepth[f.Name] = v.selections(f.Selections, cond, inner)
+			}
+			if d := v.fragmentDepth[f.Name]; d > depth {
+				depth = d
+			}
+		}
+	}
+	return depth
+}
+
+func (v *gqlValidator) field(sel *gqlSelection, parent *gqlTypeDef, w *gqlWalk) int {
+	for _, arg := range sel.Args {
+		v.useVariables(arg.Value, w)
+	}
+	def := v.schema.field(parent, sel.Name)
+	if def == nil {
+		if w.report {
+			v.errorf(sel.Loc, "Cannot query field %q on type %q.", sel.Name, parent.Name)
+		}
+		return 1
+	}
+	if w.report && !v.introspection && (def == gqlSchemaField || def == gqlTypeField) {
+		v.errorf(sel.Loc, "GraphQL introspection is not allowed, but the query contained %s.", sel.Name)
+	}
+	if w.report {
+		v.arguments(sel.Args, def.Args, fmt.Sprintf("field %q", parent.Name+"."+sel.Name), sel.Loc)
+	}
+	t := v.schema.types[def.Type.named()]
+	if !isGQLCompositeType(t) {
+		if w.report && len(sel.Selections) > 0 {
+			v.errorf(sel.Loc, "Field %q must not have a selection since type %q has no subfields.", sel.Name, def.Type)
+		}
+		return 1
+	}
+	if len(sel.Selections) == 0 {
+		if w.report {
+			v.errorf(sel.Loc, "Field %q of type %q must have a selection of subfields. Did you mean \"%s { ... }\"?", sel.Name, def.Type, sel.Name)
+		}
+		return 1
+	}
+	return 1 + v.selections(sel.Selections, t, w)
+}
+
+// arguments checks given arguments against their definitions: each is
+// known, given once, and fits its type, and required ones are present
+func (v *gqlValidator) arguments(args []gqlArgument, defs []*gqlFieldDef, owner string, loc gqlLocation) {
+	seen := make(map[string]bool)
+	for _, arg := range args {
+		if seen[arg.Name] {
+			v.errorf(arg.Loc, "There can be only one argument named %q.", arg.Name)
+			continue
+		}
+		seen[arg.Name] = true
+		var def *gqlFieldDef
+		for _, d := range defs {
+			if d.Name == arg.Name {
+				def = d
+			}
+		}
+		if def == nil {
+			v.errorf(arg.Loc, "Unknown argument %q on %s.", arg.Name, owner)
+			continue
+		}
+		if _, err := v.schema.coerceLiteral(arg.Value, def.Type, nil); err != nil {
+			v.errorf(arg.Value.Loc, "%s", err)
+		}
+	}
+	for _, def := range defs {
+		if def.Type.Kind == gqlNonNullType && def.Default == nil && !seen[def.Name] {
+			v.errorf(loc, "Argument %q of type %q is required on %s, but it was not provided.", def.Name, def.Type, owner)
+		}
+	}
+}
+
+// directives checks directives used in a document at location
+func (v *gqlValidator) directives(directives []gqlDirective, location string, w *gqlWalk) {
+	for _, d := range directives {
+		for _, arg := range d.Args {
+			v.useVariables(arg.Value, w)
+		}
+		if !w.report {
+			continue
+		}
+		var def *gqlDirectiveDef
+		for _, candidate := range v.schema.directives {
+			if candidate.Name == d.Name {
+				def = candidate
+			}
+		}
+		if def == nil {
+			v.errorf(d.Loc, "Unknown directive \"@%s\".", d.Name)
+			continue
+		}
+		allowed := false
+		for _, loc := range def.Locations {
+			allowed = allowed || loc == location
+		}
+		if !allowed {
+			v.errorf(d.Loc, "Directive \"@%s\" may not be used on %s.", d.Name, location)
+			continue
+		}
+		v.arguments(d.Args, def.Args, "directive \"@"+d.Name+"\"", d.Loc)
+	}
+}
+
+// useVariables records the variables a value refers to, reporting ones the
+// operation does not define
+func (v *gqlValidator) useVariables(value *gqlValue, w *gqlWalk) {
+	if w.op == nil {
+		return
+	}
+	switch value.Kind {
+	case "Variable":
+		if w.vars[value.Raw] == nil {
+			if !w.used[value.Raw] && w.op.Name != "" {
+				v.errorf(value.Loc, "Variable \"$%s\" is not defined by operation %q.", value.Raw, w.op.Name)
+			} else if !w.used[value.Raw] {
+				v.errorf(value.Loc, "Variable \"$%s\" is not defined.", value.Raw)
+			}
+		}
+		w.used[value.Raw] = true
+	case "List":
+		for _, item := range value.List {
+			v.useVariables(item, w)
+		}
+	case "Object":
+		for _, f := range value.Fields {
+			v.useVariables(f.Value, w)
+		}
+	}
+}
+
+// overlap reports whether some object type can be both a and b
+func (v *gqlValidator) overlap(a, b *gqlTypeDef) bool {
+	pb := v.schema.possibleOf(b.Name)
+	for name := range v.schema.possibleOf(a.Name) {
+		if pb[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// fragmentCycles reports fragments that spread themselves
+func (v *gqlValidator) fragmentCycles() {
+	state := make(map[string]int) // 1 while being visited, 2 once done
+	var visit func(f *gqlFragment)
+	var spreads func(selections []*gqlSelection, fn func(*gqlSelection))
+	spreads = func(selections []*gqlSelection, fn func(*gqlSelection)) {
+		for _, sel := range selections {
+			if sel.Kind == "FragmentSpread" {
+				fn(sel)
+			} else {
+				spreads(sel.Selections, fn)
+			}
+		}
+	}
+	visit = func(f *gqlFragment) {
+		state[f.Name] = 1
+		spreads(f.Selections, func(sel *gqlSelection) {
+			next, ok := v.fragments[sel.Name]
+			switch {
+			case !ok:
+			case state[sel.Name] == 1:
+				v.errorf(sel.Loc, "Cannot spread fragment %q within itself.", sel.Name)
+			case state[sel.Name] == 0:
+				visit(next)
+			}
+		})
+		state[f.Name] = 2
+	}
+	for _, f := range v.fragments {
+		if state[f.Name] == 0 {
+			visit(f)
+		}
+	}
+}
+
+// coerceLiteral turns a value literal into the input value of type t.
+// With nil vars it only checks the literal, taking variables as valid.
+func (s *gqlSchema) coerceLiteral(value *gqlValue, t *gqlTypeRef, vars map[string]interface{}) (interface{}, error) {
+	if value.Kind == "Variable" {
+		if vars == nil {
+			return nil, nil
+		}
+		v := vars[value.Raw]
+		if v == nil && t.Kind == gqlNonNullType {
+			return nil, fmt.Errorf("Variable \"$%s\" of required type %q was not provided.", value.Raw, t)
+		}
+		return v, nil
+	}
+	if t.Kind == gqlNonNullType {
+		if value.Kind == "Null" {
+			return nil, fmt.Errorf("Expected value of type %q, found null.", t)
+		}
+		return s.coerceLiteral(value, t.OfType, vars)
+	}
+	if value.Kind == "Null" {
+		return nil, nil
+	}
+	if t.Kind == gqlListType {
+		if value.Kind != "List" {
+			item, err := s.coerceLiteral(value, t.OfType, vars)
+			if err != nil {
+				return nil, err
+			}
+			return []interface{}{item}, nil
+		}
+		items := make([]interface{}, len(value.List))
+		for i, item := range value.List {
+			v, err := s.coerceLiteral(item, t.OfType, vars)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = v
+		}
+		return items, nil
+	}
+	def := s.types[t.Name]
+	switch def.Kind {
+	case "ENUM":
+		if value.Kind != "Enum" {
+			return nil, fmt.Errorf("Enum %q cannot represent non-enum value: %s.", t.Name, printGQLValue(value))
+		}
+		if def.enumValue(value.Raw) == nil {
+			return nil, fmt.Errorf("Value %q does not exist in %q enum.", value.Raw, t.Name)
+		}
+		return value.Raw, nil
+	case "INPUT_OBJECT":
+		if value.Kind != "Object" {
+			return nil, fmt.Errorf("Expected value of type %q, found %s.", t.Name, printGQLValue(value))
+		}
+		given := make(map[string]*gqlValue, len(value.Fields))
+		for _, f := range value.Fields {
+			if def.field(f.Name) == nil {
+				return nil, fmt.Errorf("Field %q is not defined by type %q.", f.Name, t.Name)
+			}
+			given[f.Name] = f.Value
+		}
+		object := make(map[string]interface{}, len(def.Fields))
+		for _, f := range def.Fields {
+			literal, ok := given[f.Name]
+			if ok && literal.Kind == "Variable" && vars != nil {
+				if _, provided := vars[literal.Raw]; !provided {
+					ok = false
+				}
+			}
+			if !ok {
+				literal = f.Default
+			}
+			if literal == nil {
+				if f.Type.Kind == gqlNonNullType {
+					return nil, fmt.Errorf("Field %q of required type %q was not provided.", t.Name+"."+f.Name, f.Type)
+				}
+				continue
+			}
+			v, err := s.coerceLiteral(literal, f.Type, vars)
+			if err != nil {
+				return nil, err
+			}
+			object[f.Name] = v
+		}
+		return object, nil
+	}
+	return coerceScalarLiteral(t.Name, value, vars)
+}
+
+func coerceScalarLiteral(name string, value *gqlValue, vars map[string]interface{}) (interface{}, error) {
+	switch name {
+	case "Int":
+		if value.Kind == "Int" {
+			n, err := strconv.ParseInt(value.Raw, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("Int cannot represent non 32-bit signed integer value: %s", value.Raw)
+			}
+			return n, nil
+		}
+	case "Float":
+		if value.Kind == "Int" || value.Kind == "Float" {
+			f, err := strconv.ParseFloat(value.Raw, 64)
+			if err != nil {
+				return nil, fmt.Errorf("Float cannot represent value: %s", value.Raw)
+			}
+			return f, nil
+		}
+	case "String":
+		if value.Kind == "String" {
+			return value.Raw, nil
+		}
+	case "Boolean":
+		if value.Kind == "Boolean" {
+			return value.Raw == "true", nil
+		}
+	case "ID":
+		if value.Kind == "String" || value.Kind == "Int" {
+			return value.Raw, nil
+		}
+	default:
+		// Custom scalars take any literal, passed on as plain JSON
+		return gqlLiteralJSON(value, vars), nil
+	}
+	return nil, fmt.Errorf("%s cannot represent value: %s", name, printGQLValue(value))
+}
+
+// gqlLiteralJSON converts a literal to its plain JSON value
+func gqlLiteralJSON(value *gqlValue, vars map[string]interface{}) interface{} {
+	switch value.Kind {
+	case "Variable":
+		return vars[value.Raw]
+	case "Int", "Float":
+		return json.Number(value.Raw)
+	case "String", "Enum":
+		return value.Raw
+	case "Boolean":
+		return value.Raw == "true"
+	case "List":
+		items := make([]interface{}, len(value.List))
+		for i, item := range value.List {
+			items[i] = gqlLiteralJSON(item, vars)
+		}
+		return items
+	case "Object":
+		object := make(map[string]interface{}, len(value.Fields))
+		for _, f := range value.Fields {
+			object[f.Name] = gqlLiteralJSON(f.Value, vars)
+		}
+		return object
+	}
+	return nil
+}
+
+// coerceInput turns a JSON variable value into the input value of type t
+func (s *gqlSchema) coerceInput(value interface{}, t *gqlTypeRef) (interface{}, error) {
+	if t.Kind == gqlNonNullType {
+		if value == nil {
+			return nil, fmt.Errorf("Expected non-nullable type %q not to be null.", t)
+		}
+		return s.coerceInput(value, t.OfType)
+	}
+	if value == nil {
+		return nil, nil
+	}
+	if t.Kind == gqlListType {
+		list, ok := value.([]interface{})
+		if !ok {
+			item, err := s.coerceInput(value, t.OfType)
+			if err != nil {
+				return nil, err
+			}
+			return []interface{}{item}, nil
+		}
+		items := make([]interface{}, len(list))
+		for i, item := range list {
+			v, err := s.coerceInput(item, t.OfType)
+			if err != nil {
+				return nil, fmt.Errorf("At index %d: %s", i, err)
+			}
+			items[i] = v
+		}
+		return items, nil
+	}
+	def := s.types[t.Name]
+	switch def.Kind {
+	case "ENUM":
+		name, ok := value.(string)
+		if !ok || def.enumValue(name) == nil {
+			return nil, fmt.Errorf("Value %s does not exist in %q enum.", gqlJSONText(value), t.Name)
+		}
+		return name, nil
+	case "INPUT_OBJECT":
+		given, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Expected type %q to be an object.", t.Name)
+		}
+		for name := range given {
+			if def.field(name) == nil {
+				return nil, fmt.Errorf("Field %q is not defined by type %q.", name, t.Name)
+			}
+		}
+		object := make(map[string]interface{}, len(def.Fields))
+		for _, f := range def.Fields {
+			v, ok := given[f.Name]
+			if !ok {
+				if f.Default != nil {
+					object[f.Name], _ = s.coerceLiteral(f.Default, f.Type, map[string]interface{}{})
+				} else if f.Type.Kind == gqlNonNullType {
+					return nil, fmt.Errorf("Field %q of required type %q was not provided.", f.Name, f.Type)
+				}
+				continue
+			}
+			coerced, err := s.coerceInput(v, f.Type)
+			if err != nil {
+				return nil, fmt.Errorf("At %q: %s", f.Name, err)
+			}
+			object[f.Name] = coerced
+		}
+		return object, nil
+	}
+	switch t.Name {
+	case "Int":
+		if n, ok := value.(json.Number); ok {
+			if i, err := strconv.ParseInt(n.String(), 10, 32); err == nil {
+				return i, nil
+			}
+		}
+	case "Float":
+		if n, ok := value.(json.Number); ok {
+			if f, err := n.Float64(); err == nil {
+				return f, nil
+			}
+		}
+	case "String":
+		if str, ok := value.(string); ok {
+			return str, nil
+		}
+	case "Boolean":
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+	case "ID":
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case json.Number:
+			if _, err := strconv.ParseInt(v.String(), 10, 64); err == nil {
+				return v.String(), nil
+			}
+		}
+	default:
+		return value, nil
+	}
+	return nil, fmt.Errorf("%s cannot represent value: %s", t.Name, gqlJSONText(value))
+}
+
+// serializeGQLScalar converts a resolved value to a built-in scalar's
+// output form; custom scalars pass through
+func serializeGQLScalar(name string, value interface{}) (interface{}, error) {
+	var number float64
+	isNumber := true
+	switch v := value.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		number, isNumber = f, err == nil
+	case float64:
+		number = v
+	case int:
+		number = float64(v)
+	case int64:
+		number = float64(v)
+	default:
+		isNumber = false
+	}
+	switch name {
+	case "Int":
+		if isNumber && number == math.Trunc(number) && number >= math.MinInt32 && number <= math.MaxInt32 {
+			return int64(number), nil
+		}
+		if b, ok := value.(bool); ok {
+			return map[bool]int64{false: 0, true: 1}[b], nil
+		}
+	case "Float":
+		if isNumber && !math.IsInf(number, 0) && !math.IsNaN(number) {
+			return number, nil
+		}
+	case "String":
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case bool:
+			return strconv.FormatBool(v), nil
+		}
+		if isNumber {
+			return gqlJSONText(value), nil
+		}
+	case "Boolean":
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+		if isNumber {
+			return number != 0, nil
+		}
+	case "ID":
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+		if isNumber && number == math.Trunc(number) {
+			return gqlJSONText(value), nil
+		}
+	default:
+		return value, nil
+	}
+	return nil, fmt.Errorf("%s cannot represent value: %s", name, gqlJSONText(value))
+}
+
+func gqlJSONText(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
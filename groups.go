@@ -0,0 +1,246 @@
+package main
+
+import (
+	"C"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// routeGroup is a set of routes sharing a path prefix, OpenAPI tags, and
+// middleware that runs only for them, after the global chain
+type routeGroup struct {
+	id     int
+	prefix string
+	tags   []string
+	chain  func(http.Handler) http.Handler
+}
+
+// groupMiddleware is one entry of a group's middleware list: a built-in
+// name with optional options, or the name of a RegisterMiddlewareCallback
+// middleware
+type groupMiddleware struct {
+	Name    string          `json:"name"`
+	Options json.RawMessage `json:"options"`
+}
+
+func (m *groupMiddleware) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		m.Options = nil
+		return json.Unmarshal(data, &m.Name)
+	}
+	type plain groupMiddleware
+	return json.Unmarshal(data, (*plain)(m))
+}
+
+var (
+	routeGroups   = make(map[int]*routeGroup)
+	routeGroupsMu sync.RWMutex
+	nextGroupID   = 1
+)
+
+func routeGroupFor(id int) (*routeGroup, bool) {
+	routeGroupsMu.RLock()
+	defer routeGroupsMu.RUnlock()
+	g, ok := routeGroups[id]
+	return g, ok
+}
+
+// buildGroupChain instantiates the group's middleware. Built-ins get their
+// own instance, so e.g. a group rate limit does not share buckets with the
+// global one.
+func buildGroupChain(list []groupMiddleware) (func(http.Handler) http.Handler, []string, error) {
+	var mws []func(http.Handler) http.Handler
+	var names []string
+	for _, item := range list {
+		if item.Name == "" {
+			return nil, nil, fmt.Errorf("middleware entry without a name")
+		}
+		var mw func(http.Handler) http.Handler
+		if factory, ok := middlewareFactories[item.Name]; ok {
+			var options []byte
+			if len(item.Options) > 0 && string(item.Options) != "null" {
+				options = item.Options
+			}
+			var err error
+			if mw, err = factory(options); err != nil {
+				return nil, nil, fmt.Errorf("middleware %s: %w", item.Name, err)
+			}
+		} else {
+			middlewaresMu.RLock()
+			if i := middlewareIndex(item.Name); i >= 0 {
+				mw = middlewares[i].mw
+			}
+			middlewaresMu.RUnlock()
+			if mw == nil {
+				return nil, nil, fmt.Errorf("unknown middleware %q", item.Name)
+			}
+		}
+		mws = append(mws, traceMiddleware(item.Name, mw))
+		names = append(names, item.Name)
+	}
+	return func(next http.Handler) http.Handler {
+		for i := len(mws) - 1; i >= 0; i-- {
+			next = mws[i](next)
+		}
+		return next
+	}, names, nil
+}
+
+// serveGrouped runs serve behind the route's group middleware, if any
+func serveGrouped(w http.ResponseWriter, r *http.Request, route RouteInfo, serve http.HandlerFunc) {
+	if route.Group == 0 {
+		serve(w, r)
+		return
+	}
+	g, ok := routeGroupFor(route.Group)
+	if !ok {
+		serve(w, r)
+		return
+	}
+	g.chain(serve).ServeHTTP(w, r)
+}
+
+// groupPath joins a group prefix and a route path
+func groupPath(prefix, path string) string {
+	if path == "" || path == "/" {
+		if prefix == "" {
+			return "/"
+		}
+		return prefix
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return prefix + path
+}
+
+// addGroupRoute stores a route under the group's prefix and tags
+func addGroupRoute(g *routeGroup, route RouteInfo, export string) {
+	route.Path = groupPath(g.prefix, route.Path)
+	route.Parameters = pathParameters(route.Path)
+	route.Group = g.id
+	route.Tags = g.tags
+
+	routesMu.Lock()
+	key := route.Path + route.Method
+	routes[key] = route
+	routeTree.insert(route.Path, route.Method, key)
+	routesMu.Unlock()
+	invalidateOpenAPICache()
+
+	slog.Info("Group route registered", "key", key, "group", g.id)
+	auditConfigChange(export, map[string]string{"group": g.prefix, "path": route.Path, "method": route.Method, "description": route.Description})
+}
+
+// lookupGroup resolves a handle passed by the host
+func lookupGroup(export string, handle int) (*routeGroup, bool) {
+	g, ok := routeGroupFor(handle)
+	if !ok {
+		recordError("Unknown route group", "export", export, "group", handle)
+	}
+	return g, ok
+}
+
+// CreateRouteGroup starts a group of routes, like FastAPI's APIRouter.
+// Routes registered with the returned handle are served under cPrefix,
+// tagged with cTags (a JSON array of strings) in OpenAPI, and run the
+// group's middleware after the global chain. cMiddleware is a JSON array
+// of built-in middleware names, {"name", "options"} objects, or names of
+// callback middlewares. Either array may be empty. Returns 0 on error.
+//
+//export CreateRouteGroup
+func CreateRouteGroup(cPrefix *C.char, cTags *C.char, cMiddleware *C.char) int {
+	if cPrefix == nil || cTags == nil || cMiddleware == nil {
+		recordError("One or more parameters are nil in CreateRouteGroup")
+		return 0
+	}
+	prefix := strings.TrimSuffix(C.GoString(cPrefix), "/")
+	if prefix != "" && !strings.HasPrefix(prefix, "/") {
+		recordError("Route group prefix must start with /", "prefix", prefix)
+		return 0
+	}
+	var tags []string
+	if raw := C.GoString(cTags); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &tags); err != nil {
+			recordError("Invalid route group tags", "prefix", prefix, "error", err)
+			return 0
+		}
+	}
+	var list []groupMiddleware
+	if raw := C.GoString(cMiddleware); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &list); err != nil {
+			recordError("Invalid route group middleware", "prefix", prefix, "error", err)
+			return 0
+		}
+	}
+	chain, names, err := buildGroupChain(list)
+	if err != nil {
+		recordError("Invalid route group middleware", "prefix", prefix, "error", err)
+		return 0
+	}
+
+	routeGroupsMu.Lock()
+	g := &routeGroup{id: nextGroupID, prefix: prefix, tags: tags, chain: chain}
+	routeGroups[g.id] = g
+	nextGroupID++
+	routeGroupsMu.Unlock()
+
+	slog.Info("Route group created", "group", g.id, "prefix", prefix, "tags", tags, "middleware", names)
+	auditConfigChange("CreateRouteGroup", map[string]string{
+		"group":      fmt.Sprint(g.id),
+		"prefix":     prefix,
+		"tags":       strings.Join(tags, ","),
+		"middleware": strings.Join(names, ","),
+	})
+	return g.id
+}
+
+// RegisterGroupRoute registers a static route in a group; cPath is
+// relative to the group prefix
+//
+//export RegisterGroupRoute
+func RegisterGroupRoute(handle int, cPath *C.char, cMethod *C.char, cMessage *C.char, cDesc *C.char) int {
+	if cPath == nil || cMethod == nil || cMessage == nil || cDesc == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in RegisterGroupRoute")
+	}
+	g, ok := lookupGroup("RegisterGroupRoute", handle)
+	if !ok {
+		return exportNotFound
+	}
+	addGroupRoute(g, RouteInfo{
+		Path:        C.GoString(cPath),
+		Method:      strings.ToUpper(C.GoString(cMethod)),
+		Message:     C.GoString(cMessage),
+		Description: C.GoString(cDesc),
+		Responses:   map[int]string{200: "Successful response"},
+	}, "RegisterGroupRoute")
+
+	return 0
+}
+
+// RegisterGroupRouteHandler registers a host-handled route in a group, as
+// RegisterRouteHandler does; cPath is relative to the group prefix
+//
+//export RegisterGroupRouteHandler
+func RegisterGroupRouteHandler(handle int, cPath *C.char, cMethod *C.char, cDesc *C.char, cHandler uintptr) int {
+	if cPath == nil || cMethod == nil || cDesc == nil || cHandler == 0 {
+		return exportError(exportInvalid, "One or more parameters are nil in RegisterGroupRouteHandler")
+	}
+	g, ok := lookupGroup("RegisterGroupRouteHandler", handle)
+	if !ok {
+		return exportNotFound
+	}
+	addGroupRoute(g, RouteInfo{
+		Path:        C.GoString(cPath),
+		Method:      strings.ToUpper(C.GoString(cMethod)),
+		Description: C.GoString(cDesc),
+		Responses:   map[int]string{200: "Successful response"},
+		Handler:     cHandler,
+	}, "RegisterGroupRouteHandler")
+
+	return 0
+}
@@ -0,0 +1,506 @@
+package main
+
+import (
+	"C"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gRPC status codes the server answers with
+const (
+	grpcOK                = 0
+	grpcCanceled          = 1
+	grpcUnknown           = 2
+	grpcDeadlineExceeded  = 4
+	grpcResourceExhausted = 8
+	grpcUnimplemented     = 12
+	grpcInternal          = 13
+	grpcUnavailable       = 14
+)
+
+const defaultGRPCMaxMessage = 4 << 20
+
+// GRPCOptions configures the gRPC listener, set with ConfigureGRPC
+type GRPCOptions struct {
+	Port           int    `json:"port"`             // 0 disables the listener
+	DescriptorSet  string `json:"descriptor_set"`   // file written by protoc --descriptor_set_out declaring the services
+	MaxMessageSize int    `json:"max_message_size"` // bytes per message; defaults to 4 MiB
+	Plaintext      bool   `json:"plaintext"`        // serve h2c even when TLS is configured
+}
+
+var (
+	grpcOptions  GRPCOptions
+	grpcMethods  map[string]grpcMethod      // by full method, e.g. /pkg.Service/Method
+	grpcHandlers = make(map[string]uintptr) // by full method, or by service for all its methods
+	grpcMu       sync.RWMutex
+	grpcMetrics  = &requestMetrics{series: make(map[seriesKey]*requestSeries)}
+)
+
+func currentGRPCOptions() GRPCOptions {
+	grpcMu.RLock()
+	defer grpcMu.RUnlock()
+	return grpcOptions
+}
+
+// grpcStatus is a gRPC status code with its message
+type grpcStatus struct {
+	code int
+	msg  string
+}
+
+// GRPCCall is the call snapshot passed to host gRPC handlers. Messages are
+// the raw protobuf encodings; the descriptor set's type names say how to
+// decode them.
+type GRPCCall struct {
+	RequestID  string              `json:"request_id"`
+	Method     string              `json:"method"` // full method, /package.Service/Method
+	Service    string              `json:"service"`
+	RPC        string              `json:"rpc"`
+	InputType  string              `json:"input_type"`
+	OutputType string              `json:"output_type"`
+	Metadata   map[string][]string `json:"metadata"`
+	Message    []byte              `json:"message"`  // first request message, base64-encoded in JSON
+	Messages   [][]byte            `json:"messages"` // every request message, for client-streaming RPCs
+	RemoteAddr string              `json:"remote_addr"`
+	Deadline   *time.Time          `json:"deadline,omitempty"`
+	ClientCert *ClientCertInfo     `json:"client_cert,omitempty"`
+}
+
+// GRPCResponse is what host gRPC handlers return
+type GRPCResponse struct {
+	Message  []byte            `json:"message"`  // reply message, base64-encoded in JSON
+	Messages [][]byte          `json:"messages"` // further replies, for server-streaming RPCs
+	Status   int               `json:"status"`   // gRPC status code; 0 is OK
+	Error    string            `json:"error"`    // status message
+	Metadata map[string]string `json:"metadata"` // response headers
+	Trailers map[string]string `json:"trailers"`
+}
+
+// grpcTimeout parses a grpc-timeout header such as "100m"
+func grpcTimeout(value string) (time.Duration, bool) {
+	if len(value) < 2 || len(value) > 9 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	units := map[byte]time.Duration{'H': time.Hour, 'M': time.Minute, 'S': time.Second, 'm': time.Millisecond, 'u': time.Microsecond, 'n': time.Nanosecond}
+	unit, ok := units[value[len(value)-1]]
+	if !ok {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
+
+// readGRPCMessages splits a request body into its length-prefixed messages
+func readGRPCMessages(body io.Reader, limit int, encoding string) ([][]byte, *grpcStatus) {
+	var messages [][]byte
+	header := make([]byte, 5)
+	for {
+		if _, err := io.ReadFull(body, header); err != nil {
+			if err == io.EOF {
+				return messages, nil
+			}
+			return nil, &grpcStatus{grpcInternal, "reading request: " + err.Error()}
+		}
+		size := binary.BigEndian.Uint32(header[1:])
+		if int64(size) > int64(limit) {
+			return nil, &grpcStatus{grpcResourceExhausted, fmt.Sprintf("request message larger than %d bytes", limit)}
+		}
+		message := make([]byte, size)
+		if _, err := io.ReadFull(body, message); err != nil {
+			return nil, &grpcStatus{grpcInternal, "reading request: " + err.Error()}
+		}
+		if header[0] != 0 {
+			if encoding != "gzip" {
+				return nil, &grpcStatus{grpcInternal, "compressed message without a supported grpc-encoding"}
+			}
+			zr, err := gzip.NewReader(bytes.NewReader(message))
+			if err != nil {
+				return nil, &grpcStatus{grpcInternal, "decompressing request: " + err.Error()}
+			}
+			message, err = io.ReadAll(io.LimitReader(zr, int64(limit)+1))
+			if err != nil {
+				return nil, &grpcStatus{grpcInternal, "decompressing request: " + err.Error()}
+			}
+			if len(message) > limit {
+				return nil, &grpcStatus{grpcResourceExhausted, fmt.Sprintf("request message larger than %d bytes", limit)}
+			}
+		}
+		messages = append(messages, message)
+	}
+}
+
+// grpcMessageEscape percent-encodes a status message for grpc-message
+func grpcMessageEscape(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// writeGRPCStatus answers with a trailers-only response
+func writeGRPCStatus(w http.ResponseWriter, status grpcStatus) {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Grpc-Status", strconv.Itoa(status.code))
+	if status.msg != "" {
+		w.Header().Set("Grpc-Message", grpcMessageEscape(status.msg))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// replies lists the messages a response answers with: exactly one for a
+// successful unary call, none for a failed one
+func (response GRPCResponse) replies(method grpcMethod) [][]byte {
+	if response.Status != grpcOK {
+		return nil
+	}
+	messages := response.Messages
+	if response.Message != nil || !method.ServerStreaming {
+		messages = append([][]byte{response.Message}, messages...)
+	}
+	if !method.ServerStreaming {
+		messages = messages[:1]
+	}
+	return messages
+}
+
+// writeGRPCResponse sends the host's reply messages followed by the status
+// trailers
+func writeGRPCResponse(w http.ResponseWriter, method grpcMethod, response GRPCResponse) {
+	for name, value := range response.Metadata {
+		w.Header().Set(name, value)
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	w.WriteHeader(http.StatusOK)
+	frame := make([]byte, 5)
+	for _, message := range response.replies(method) {
+		binary.BigEndian.PutUint32(frame[1:], uint32(len(message)))
+		if _, err := w.Write(frame); err != nil {
+			return
+		}
+		if _, err := w.Write(message); err != nil {
+			return
+		}
+	}
+	for name, value := range response.Trailers {
+		w.Header().Set(http.TrailerPrefix+name, value)
+	}
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(response.Status))
+	if response.Error != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", grpcMessageEscape(response.Error))
+	}
+}
+
+// grpcReservedHeaders are transport headers not passed on as metadata
+var grpcReservedHeaders = map[string]bool{
+	"Content-Type": true, "Te": true, "Grpc-Timeout": true, "Grpc-Encoding": true, "Grpc-Accept-Encoding": true,
+}
+
+// serveGRPC dispatches a gRPC call to its host handler, then records its
+// metrics and access log line
+func serveGRPC(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	code := serveGRPCCall(w, r)
+	if metricsEnabled.Load() {
+		// Undeclared methods share one series so clients cannot grow the label set
+		label := "unmatched"
+		if info := requestInfoFrom(r); info != nil && info.Route != "" {
+			label = info.Route
+		}
+		grpcMetrics.observe("grpc", label, code, time.Since(start))
+	}
+	if middlewareEnabled("logging") {
+		var requestID string
+		if info := requestInfoFrom(r); info != nil {
+			requestID = info.ID
+		}
+		slog.Info("gRPC call served",
+			"request_id", requestID,
+			"method", r.URL.Path,
+			"code", code,
+			"latency", time.Since(start),
+			"remote_addr", r.RemoteAddr,
+		)
+	}
+}
+
+// serveGRPCCall answers one call and returns its gRPC status code
+func serveGRPCCall(w http.ResponseWriter, r *http.Request) int {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, `{"error": "gRPC requires POST"}`, http.StatusMethodNotAllowed)
+		return grpcUnimplemented
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "application/grpc" && !strings.HasPrefix(ct, "application/grpc+") && !strings.HasPrefix(ct, "application/grpc;") {
+		http.Error(w, `{"error": "Expected application/grpc"}`, http.StatusUnsupportedMediaType)
+		return grpcUnknown
+	}
+
+	fullMethod := r.URL.Path
+	grpcMu.RLock()
+	method, declared := grpcMethods[fullMethod]
+	limit := grpcOptions.MaxMessageSize
+	grpcMu.RUnlock()
+	handler, ok := grpcHandlerFor(fullMethod, method.Service)
+	info := requestInfoFrom(r)
+	if info != nil && declared {
+		info.Route = fullMethod
+	}
+	if !declared || !ok {
+		writeGRPCStatus(w, grpcStatus{grpcUnimplemented, "unknown method " + fullMethod})
+		return grpcUnimplemented
+	}
+
+	ctx := r.Context()
+	if timeout, ok := grpcTimeout(r.Header.Get("Grpc-Timeout")); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	w.Header().Set("Grpc-Accept-Encoding", "gzip")
+	encoding := r.Header.Get("Grpc-Encoding")
+	if encoding != "" && encoding != "identity" && encoding != "gzip" {
+		writeGRPCStatus(w, grpcStatus{grpcUnimplemented, "unsupported grpc-encoding " + encoding})
+		return grpcUnimplemented
+	}
+	messages, status := readGRPCMessages(r.Body, limit, encoding)
+	if status != nil {
+		writeGRPCStatus(w, *status)
+		return status.code
+	}
+	if !method.ClientStreaming && len(messages) != 1 {
+		writeGRPCStatus(w, grpcStatus{grpcInternal, fmt.Sprintf("unary request carried %d messages", len(messages))})
+		return grpcInternal
+	}
+
+	call := GRPCCall{
+		Method:     fullMethod,
+		Service:    method.Service,
+		RPC:        method.Name,
+		InputType:  method.InputType,
+		OutputType: method.OutputType,
+		Metadata:   make(map[string][]string),
+		Messages:   messages,
+		RemoteAddr: r.RemoteAddr,
+		Deadline:   deadlineFrom(ctx),
+		ClientCert: clientCertFrom(r),
+	}
+	if info != nil {
+		call.RequestID = info.ID
+	}
+	if len(messages) > 0 {
+		call.Message = messages[0]
+	}
+	for name, values := range r.Header {
+		if !grpcReservedHeaders[name] {
+			call.Metadata[strings.ToLower(name)] = values
+		}
+	}
+	response, status := invokeGRPCHandler(ctx, handler, call)
+	if status != nil {
+		if status.code != grpcCanceled {
+			writeGRPCStatus(w, *status)
+		}
+		return status.code
+	}
+	writeGRPCResponse(w, method, response)
+	return response.Status
+}
+
+// invokeGRPCHandler runs a host gRPC handler. Host code cannot be
+// interrupted; one still running when ctx ends is left to finish and its
+// reply discarded.
+func invokeGRPCHandler(ctx context.Context, handler uintptr, call GRPCCall) (GRPCResponse, *grpcStatus) {
+	request, err := json.Marshal(call)
+	if err != nil {
+		return GRPCResponse{}, &grpcStatus{grpcInternal, "encoding call"}
+	}
+	done := make(chan []byte, 1)
+	go func() {
+		raw, ok := callRouteHandler(handler, request)
+		if !ok {
+			raw = nil
+		}
+		done <- raw
+	}()
+	var raw []byte
+	select {
+	case raw = <-done:
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return GRPCResponse{}, &grpcStatus{grpcDeadlineExceeded, "deadline exceeded"}
+		}
+		return GRPCResponse{}, &grpcStatus{grpcCanceled, "call canceled"}
+	}
+	if raw == nil {
+		slog.Error("gRPC handler returned no response", "method", call.Method)
+		return GRPCResponse{}, &grpcStatus{grpcInternal, "handler returned no response"}
+	}
+	var response GRPCResponse
+	if err := json.Unmarshal(raw, &response); err != nil {
+		slog.Error("Error decoding gRPC handler response", "method", call.Method, "error", err)
+		return GRPCResponse{}, &grpcStatus{grpcInternal, "invalid handler response"}
+	}
+	return response, nil
+}
+
+// grpcHandlerFor returns the host handler of a method, falling back to its
+// service's
+func grpcHandlerFor(fullMethod, service string) (uintptr, bool) {
+	grpcMu.RLock()
+	defer grpcMu.RUnlock()
+	if handler, ok := grpcHandlers[fullMethod]; ok {
+		return handler, true
+	}
+	handler, ok := grpcHandlers[service]
+	return handler, ok
+}
+
+// middlewareEnabled reports whether a built-in middleware is on
+func middlewareEnabled(name string) bool {
+	middlewaresMu.RLock()
+	defer middlewaresMu.RUnlock()
+	for _, m := range middlewares {
+		if m.name == name {
+			return m.enabled
+		}
+	}
+	return false
+}
+
+// startGRPCServer serves the declared services on their own listener. Calls
+// share the request IDs, tracing, and in-flight accounting of the HTTP
+// server, and drain with it on shutdown. HTTP/2 runs over the server's TLS
+// config when there is one, and as h2c otherwise.
+func startGRPCServer(cfg ServerConfig, tlsConfig *tls.Config, o GRPCOptions) (*http.Server, net.Listener, error) {
+	addr := net.JoinHostPort(cfg.host(), strconv.Itoa(o.Port))
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           realIPMiddleware(activeRequestsMiddleware(requestInfoMiddleware(tracingMiddleware(http.HandlerFunc(serveGRPC))))),
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		ConnContext:       connContext,
+	}
+	protocols := new(http.Protocols)
+	secure := tlsConfig != nil && !o.Plaintext
+	if secure {
+		protocols.SetHTTP2(true)
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.NextProtos = []string{"h2"}
+	} else {
+		protocols.SetUnencryptedHTTP2(true)
+	}
+	server.Protocols = protocols
+
+	listener, err := listenTCP("grpc:"+addr, addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	serving := listener
+	if secure {
+		serving = tls.NewListener(listener, tlsConfig)
+	}
+	go func() {
+		slog.Info("gRPC listener running", "addr", addr, "tls", secure)
+		if err := server.Serve(serving); err != nil && err != http.ErrServerClosed {
+			slog.Error("gRPC server error", "error", err)
+		}
+	}()
+	return server, listener, nil
+}
+
+// ConfigureGRPC sets the gRPC listener used by the next server start.
+// cOptions is a JSON GRPCOptions object; the descriptor set is read now and
+// names the services calls may be made to. A port of 0 turns the listener
+// off.
+//
+//export ConfigureGRPC
+func ConfigureGRPC(cOptions *C.char) int {
+	if cOptions == nil {
+		return exportError(exportInvalid, "cOptions is nil in ConfigureGRPC")
+	}
+	options := C.GoString(cOptions)
+	var opts GRPCOptions
+	if options != "" {
+		if err := json.Unmarshal([]byte(options), &opts); err != nil {
+			return exportError(exportInvalid, "Invalid gRPC options", "error", err)
+		}
+	}
+	if opts.Port < 0 || opts.Port > 65535 || opts.MaxMessageSize < 0 {
+		return exportError(exportInvalid, "Invalid gRPC options", "port", opts.Port, "max_message_size", opts.MaxMessageSize)
+	}
+	if opts.MaxMessageSize == 0 {
+		opts.MaxMessageSize = defaultGRPCMaxMessage
+	}
+	var methods map[string]grpcMethod
+	if opts.Port > 0 {
+		if opts.DescriptorSet == "" {
+			return exportError(exportInvalid, "gRPC needs a descriptor_set")
+		}
+		set, err := loadDescriptorSet(opts.DescriptorSet)
+		if err != nil {
+			return exportError(exportFailed, "Cannot load gRPC descriptor set", "path", opts.DescriptorSet, "error", err)
+		}
+		methods = set.methods
+	}
+	grpcMu.Lock()
+	grpcOptions = opts
+	grpcMethods = methods
+	grpcMu.Unlock()
+
+	slog.Info("gRPC configured", "port", opts.Port, "methods", len(methods))
+	auditConfigChange("ConfigureGRPC", map[string]string{"options": options})
+
+	return 0
+}
+
+// RegisterGRPCHandler routes calls to a host callback. cMethod is a full
+// method (/package.Service/Method) or a service name (package.Service) to
+// handle all of its methods; a method handler wins over its service's. The
+// callback uses the route handler ABI: it receives a JSON GRPCCall and returns
+// a JSON GRPCResponse. Client-streaming calls are delivered once the client
+// has sent every message, and server-streaming replies are sent together.
+//
+//export RegisterGRPCHandler
+func RegisterGRPCHandler(cMethod *C.char, cHandler uintptr) int {
+	if cMethod == nil || cHandler == 0 {
+		return exportError(exportInvalid, "One or more parameters are nil in RegisterGRPCHandler")
+	}
+	name := strings.TrimPrefix(C.GoString(cMethod), "/")
+	if name == "" {
+		return exportError(exportInvalid, "Empty method in RegisterGRPCHandler")
+	}
+	if strings.Contains(name, "/") {
+		name = "/" + name
+	}
+	grpcMu.Lock()
+	grpcHandlers[name] = cHandler
+	grpcMu.Unlock()
+
+	slog.Info("gRPC handler registered", "method", name)
+	auditConfigChange("RegisterGRPCHandler", map[string]string{"method": name})
+
+	return 0
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"C"
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// handlerHeaders gives the header exports access to a request while its
+// host callback runs. The dispatcher is blocked in the callback then, so
+// the exports are the only ones touching the response headers; mu
+// serializes host threads calling them at once.
+type handlerHeaders struct {
+	mu        sync.Mutex
+	ctx       context.Context // the handler call's, for the tasks it submits
+	method    string
+	request   http.Header
+	response  http.Header
+	tls       bool // the request arrived over TLS, for the defaults of SetCookie
+	redirect  int  // status set by SendRedirect; 0 for none
+	location  string
+	validated bool // SetResponseValidators was called
+}
+
+var (
+	activeHandlerHeaders   = make(map[string]*handlerHeaders) // by request ID
+	activeHandlerHeadersMu sync.Mutex
+)
+
+// exposeHandlerHeaders makes a request's headers reachable by its ID for
+// the duration of a host callback; the returned func withdraws them. The
+// window is nil for requests without an ID.
+func exposeHandlerHeaders(r *http.Request, w http.ResponseWriter) (*handlerHeaders, func()) {
+	info := requestInfoFrom(r)
+	if info == nil {
+		return nil, func() {}
+	}
+	h := &handlerHeaders{ctx: r.Context(), method: r.Method, request: r.Header, response: w.Header(), tls: r.TLS != nil}
+	activeHandlerHeadersMu.Lock()
+	activeHandlerHeaders[info.ID] = h
+	activeHandlerHeadersMu.Unlock()
+	return h, func() {
+		activeHandlerHeadersMu.Lock()
+		delete(activeHandlerHeaders, info.ID)
+		activeHandlerHeadersMu.Unlock()
+	}
+}
+
+func handlerHeadersFor(requestID string) (*handlerHeaders, bool) {
+	activeHandlerHeadersMu.Lock()
+	defer activeHandlerHeadersMu.Unlock()
+	h, ok := activeHandlerHeaders[requestID]
+	return h, ok
+}
+
+// validHeaderName reports whether name is an RFC 9110 token
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte(`"(),/:;<=>?@[\]{}`, c) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// editResponseHeader applies SetResponseHeader or AddResponseHeader
+func editResponseHeader(export string, cRequestID, cName, cValue *C.char, add bool) int {
+	if cRequestID == nil || cName == nil || cValue == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in "+export)
+	}
+	requestID := C.GoString(cRequestID)
+	name := C.GoString(cName)
+	value := C.GoString(cValue)
+	if !validHeaderName(name) {
+		return exportError(exportInvalid, "Invalid header name", "export", export, "name", name)
+	}
+	if strings.ContainsAny(value, "\r\n\x00") {
+		return exportError(exportInvalid, "Invalid header value", "export", export, "name", name)
+	}
+	h, ok := handlerHeadersFor(requestID)
+	if !ok {
+		return exportError(exportNotFound, "Cannot set response header, handler not running", "request_id", requestID, "name", name)
+	}
+	h.mu.Lock()
+	if add {
+		h.response.Add(name, value)
+	} else {
+		h.response.Set(name, value)
+	}
+	h.mu.Unlock()
+	return 0
+}
+
+// SetResponseHeader sets the response header cName to cValue for the
+// request cRequestID, replacing any values it has, e.g. Cache-Control or
+// Content-Disposition. It may be called while the request's handler
+// callback runs, from any thread; headers in the response the handler
+// returns are applied afterwards and take precedence.
+//
+//export SetResponseHeader
+func SetResponseHeader(cRequestID *C.char, cName *C.char, cValue *C.char) int {
+	return editResponseHeader("SetResponseHeader", cRequestID, cName, cValue, false)
+}
+
+// AddResponseHeader appends a value to the response header cName for the
+// request cRequestID, for headers sent more than once such as Set-Cookie.
+// Like SetResponseHeader, it may be called while the handler callback runs.
+//
+//export AddResponseHeader
+func AddResponseHeader(cRequestID *C.char, cName *C.char, cValue *C.char) int {
+	return editResponseHeader("AddResponseHeader", cRequestID, cName, cValue, true)
+}
+
+// ReadRequestHeader returns the values of the request header cName for the
+// request cRequestID, joined with ", ", or NULL if the header is absent or
+// the handler callback is not running. The caller must release the result
+// with FreeString.
+//
+//export ReadRequestHeader
+func ReadRequestHeader(cRequestID *C.char, cName *C.char) *C.char {
+	if cRequestID == nil || cName == nil {
+		recordError("One or more parameters are nil in ReadRequestHeader")
+		return nil
+	}
+	h, ok := handlerHeadersFor(C.GoString(cRequestID))
+	if !ok {
+		return nil
+	}
+	h.mu.Lock()
+	values := h.request.Values(C.GoString(cName))
+	h.mu.Unlock()
+	if len(values) == 0 {
+		return nil
+	}
+	return C.CString(strings.Join(values, ", "))
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"C"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// HandlerRequest is the request snapshot passed to host route handlers
+type HandlerRequest struct {
+	RequestID   string                     `json:"request_id"`
+	Method      string                     `json:"method"`
+	Path        string                     `json:"path"`
+	PathParams  map[string]string          `json:"path_params"`
+	Headers     map[string][]string        `json:"headers"`
+	Query       map[string][]string        `json:"query"`
+	QueryParams map[string]interface{}     `json:"query_params,omitempty"` // declared query parameters, coerced
+	Body        []byte                     `json:"body"`                   // base64-encoded in JSON
+	RemoteAddr  string                     `json:"remote_addr"`
+	Claims      map[string]interface{}     `json:"claims,omitempty"`      // verified JWT claims
+	Form        map[string][]string        `json:"form,omitempty"`        // multipart or urlencoded form fields
+	FormParams  map[string]interface{}     `json:"form_params,omitempty"` // declared form fields, coerced
+	Files       []UploadedFile             `json:"files,omitempty"`       // multipart file parts
+	Traceparent string                     `json:"traceparent,omitempty"` // W3C trace context of the handler span
+	Session     map[string]json.RawMessage `json:"session,omitempty"`     // session values under the session middleware
+	ClientCert  *ClientCertInfo            `json:"client_cert,omitempty"` // verified mutual TLS client certificate
+	Deadline    *time.Time                 `json:"deadline,omitempty"`    // when the timeout middleware gives up on the request
+	Context     map[string]json.RawMessage `json:"context,omitempty"`     // values set for the request by middleware
+	BodyStream  bool                       `json:"body_stream,omitempty"` // the body is read with ReadRequestChunk
+	BodySize    int64                      `json:"body_size,omitempty"`   // bytes passed to the route's chunk handler
+}
+
+// HandlerResponse is what host route handlers return. Body is sent as-is;
+// BodyBase64 takes precedence for binary payloads.
+type HandlerResponse struct {
+	Status     int               `json:"status"`
+	Headers    map[string]string `json:"headers"`
+	Body       string            `json:"body"`
+	BodyBase64 []byte            `json:"body_base64"`
+	Trailers   map[string]string `json:"trailers"` // HTTP/1.1+ only
+	Stream     bool              `json:"stream"`   // body continues with WriteResponseChunk until FinishResponse
+
+	validated bool // the handler called SetResponseValidators
+}
+
+var requestCounter atomic.Uint64
+
+// newRequestID returns a process-unique request identifier
+func newRequestID() string {
+	return fmt.Sprintf("req-%d", requestCounter.Add(1))
+}
+
+// buildHandlerRequest marshals the request for a host callback. A parsed
+// multipart upload or a streamed body replaces the raw body.
+func buildHandlerRequest(r *http.Request, upload *multipartUpload, streamed *requestBodyStream) ([]byte, error) {
+	var body []byte
+	if upload == nil && streamed == nil {
+		var err error
+		if body, err = io.ReadAll(r.Body); err != nil {
+			return nil, err
+		}
+	}
+	requestID := newRequestID()
+	var claims map[string]interface{}
+	if info := requestInfoFrom(r); info != nil {
+		requestID = info.ID
+		claims = info.Claims
+	}
+	request := HandlerRequest{
+		RequestID:   requestID,
+		Method:      r.Method,
+		Path:        r.URL.Path,
+		PathParams:  PathParams(r),
+		Headers:     r.Header,
+		Query:       r.URL.Query(),
+		QueryParams: QueryParams(r),
+		Body:        body,
+		RemoteAddr:  r.RemoteAddr,
+		Claims:      claims,
+		Traceparent: traceparentFrom(r.Context()),
+		Session:     sessionSnapshot(requestID),
+		ClientCert:  clientCertFrom(r),
+		FormParams:  FormParams(r),
+		Deadline:    deadlineFrom(r.Context()),
+		Context:     requestValuesSnapshot(requestID),
+	}
+	if upload != nil {
+		request.Form, request.Files = upload.Form, upload.Files
+	} else if streamed != nil {
+		request.BodyStream, request.BodySize = !streamed.done, streamed.size
+	} else if isFormRequest(r) {
+		if form, err := url.ParseQuery(string(body)); err == nil && len(form) > 0 {
+			request.Form = form
+		}
+	}
+	return currentJSON().marshal(request)
+}
+
+// serveHandlerRoute invokes the route's host callback and writes back the
+// status, headers, body, and trailers it returns, in the format negotiated
+// from the Accept header
+func serveHandlerRoute(w http.ResponseWriter, r *http.Request, route RouteInfo) {
+	if serveStoredNotModified(w, r) {
+		return
+	}
+	var stream *responseStream
+	if info := requestInfoFrom(r); info != nil {
+		var end func()
+		stream, end = openResponseStream(info.ID)
+		defer end()
+	}
+	response, ok := callHandlerRoute(w, r, route)
+	if !ok {
+		return
+	}
+	if response.Stream && stream != nil {
+		if w, response, ok = rangeHandlerResponse(w, r, route, response, handlerBodySize(response)); ok {
+			stream.declareTrailers(route, &response)
+			storeValidators(w, r, response)
+			writeStreamedResponse(w, r, route, response, stream)
+		}
+		return
+	}
+	if stream != nil && stream.pending() {
+		slog.Warn("Handler wrote response chunks without streaming; discarded", "method", route.Method, "route", route.Path)
+	}
+	if response, ok = negotiateHandlerResponse(w, r, route, response); !ok {
+		return
+	}
+	storeValidators(w, r, response)
+	response = conditionalHandlerResponse(w, r, response)
+	if w, response, ok = rangeHandlerResponse(w, r, route, response, handlerBodySize(response)); ok {
+		if stream != nil {
+			stream.declareTrailers(route, &response)
+		}
+		writeHandlerResponse(w, route, response)
+	}
+}
+
+// callHandlerRoute invokes the route's host callback and decodes its
+// response. On failure it has already answered the request.
+func callHandlerRoute(w http.ResponseWriter, r *http.Request, route RouteInfo) (HandlerResponse, bool) {
+	var upload *multipartUpload
+	if route.Multipart != nil {
+		var err error
+		if upload, err = readMultipart(w, r, route.Multipart); err != nil {
+			upErr := uploadFailure(err).(*uploadError)
+			slog.Debug("Multipart upload failed", "method", route.Method, "route", route.Path, "status", upErr.status, "error", err)
+			body, _ := json.Marshal(ErrorResponse{Error: upErr.msg})
+			http.Error(w, string(body), upErr.status)
+			return HandlerResponse{}, false
+		}
+		defer upload.cleanup()
+	}
+
+	var streamed *requestBodyStream
+	if info := requestInfoFrom(r); route.BodyStream != nil && info != nil {
+		var end func()
+		var err error
+		streamed, end, err = openRequestBodyStream(r, route.BodyStream, info.ID)
+		defer end()
+		if err != nil {
+			slog.Debug("Streamed request body failed", "method", route.Method, "route", route.Path, "error", err)
+			writeBodyStreamError(w, err)
+			return HandlerResponse{}, false
+		}
+	}
+
+	ctx, sp := startSpan(r.Context(), "handler "+route.Path, spanKindInternal)
+	defer sp.end()
+	r = r.WithContext(ctx)
+	request, err := buildHandlerRequest(r, upload, streamed)
+	if err != nil {
+		writeBodyReadError(w, err)
+		return HandlerResponse{}, false
+	}
+
+	window, withdrawHeaders := exposeHandlerHeaders(r, w)
+	raw, ok := callRouteHandler(route.Handler, request)
+	withdrawHeaders()
+	if streamed != nil && streamed.failure() != nil {
+		// The handler hit a broken or oversized body; its response is moot
+		writeBodyStreamError(w, streamed.failure())
+		return HandlerResponse{}, false
+	}
+	if window != nil && window.redirect != 0 {
+		// SendRedirect replaces whatever the handler returned
+		return HandlerResponse{Status: window.redirect, Headers: map[string]string{"Location": window.location}}, true
+	}
+	if !ok {
+		sp.setError("handler returned no response")
+		slog.Error("Handler returned no response", "method", route.Method, "route", route.Path)
+		http.Error(w, `{"error": "Internal server error"}`, http.StatusInternalServerError)
+		return HandlerResponse{}, false
+	}
+	var response HandlerResponse
+	if err := currentJSON().unmarshal(raw, &response); err != nil {
+		slog.Error("Error decoding handler response", "method", route.Method, "route", route.Path, "error", err)
+		http.Error(w, `{"error": "Internal server error"}`, http.StatusInternalServerError)
+		return HandlerResponse{}, false
+	}
+	if response.Status == 0 {
+		response.Status = route.successStatus()
+	}
+	response.validated = window != nil && window.validated
+	return response, true
+}
+
+// writeHandlerResponse writes a decoded host response to the client
+func writeHandlerResponse(w http.ResponseWriter, route RouteInfo, response HandlerResponse) {
+	trailers, trailerValues := writeHandlerHeader(w, route, response)
+	if !writeHandlerBody(w, response) {
+		return
+	}
+	setTrailers(w, trailers, trailerValues)
+}
+
+// writeStreamedResponse writes a host response followed by the chunks the
+// handler streams. Its trailers are sent once it finishes, with the values
+// it set with SetResponseTrailer while streaming.
+func writeStreamedResponse(w http.ResponseWriter, r *http.Request, route RouteInfo, response HandlerResponse, stream *responseStream) {
+	trailers, trailerValues := writeHandlerHeader(w, route, response)
+	if !writeHandlerBody(w, response) || !sendStream(w, r, route, stream) {
+		return
+	}
+	maps.Copy(trailerValues, stream.finalTrailers())
+	setTrailers(w, trailers, trailerValues)
+}
+
+// writeHandlerHeader sends a host response's status and headers and returns
+// the trailers to set after the body
+func writeHandlerHeader(w http.ResponseWriter, route RouteInfo, response HandlerResponse) ([]string, map[string]string) {
+	setErrorPassthrough(w, true)
+	if response.Status == 0 {
+		response.Status = http.StatusOK
+	}
+	for name, value := range response.Headers {
+		w.Header().Set(name, value)
+	}
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	applyRouteCompression(w, route)
+
+	// Route-level trailers are merged with those returned by the handler
+	trailerValues := make(map[string]string, len(route.Trailers)+len(response.Trailers))
+	for name, value := range route.Trailers {
+		trailerValues[name] = value
+	}
+	for name, value := range response.Trailers {
+		trailerValues[http.CanonicalHeaderKey(name)] = value
+	}
+	trailers := trailerNames(trailerValues)
+	declareTrailers(w, trailers)
+
+	w.WriteHeader(response.Status)
+	return trailers, trailerValues
+}
+
+// writeHandlerBody writes a host response's body, reporting whether it
+// was sent
+func writeHandlerBody(w http.ResponseWriter, response HandlerResponse) bool {
+	if response.Status == http.StatusNoContent || response.Status == http.StatusNotModified {
+		return true // these statuses never carry a body
+	}
+	body := []byte(response.Body)
+	if response.BodyBase64 != nil {
+		body = response.BodyBase64
+	}
+	if _, err := w.Write(body); err != nil {
+		if err != http.ErrHandlerTimeout { // already answered by the timeout middleware
+			slog.Error("Error writing handler response", "error", err)
+		}
+		return false
+	}
+	return true
+}
+
+// RegisterRouteHandler registers a route served by a host callback. The
+// callback receives the request as JSON (method, path, headers, query, and
+// base64 body) and returns a JSON response with status, headers, and body.
+//
+//export RegisterRouteHandler
+func RegisterRouteHandler(cPath *C.char, cMethod *C.char, cDesc *C.char, cHandler uintptr) int {
+	if cPath == nil || cMethod == nil || cDesc == nil || cHandler == 0 {
+		return exportError(exportInvalid, "One or more parameters are nil in RegisterRouteHandler")
+	}
+	path := C.GoString(cPath)
+	method := strings.ToUpper(C.GoString(cMethod))
+	desc := C.GoString(cDesc)
+
+	slog.Debug("Registering handler route", "path", path, "method", method)
+
+	routesMu.Lock()
+	key := path + method
+	routes[key] = RouteInfo{
+		Path:        path,
+		Method:      method,
+		Description: desc,
+		Parameters:  pathParameters(path),
+		Responses: map[int]string{
+			200: "Successful response",
+		},
+		Handler: cHandler,
+	}
+	routeTree.insert(path, method, key)
+	slog.Info("Handler route registered", "key", key)
+	routesMu.Unlock()
+	invalidateOpenAPICache()
+	auditConfigChange("RegisterRouteHandler", map[string]string{"path": path, "method": method, "description": desc})
+
+	return 0
+}
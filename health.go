@@ -0,0 +1,302 @@
+package main
+
+import (
+	"C"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultHealthCheckTimeout = 2 * time.Second
+	defaultTaskQueueThreshold = 0.9
+)
+
+// HealthOptions configures readiness checking
+type HealthOptions struct {
+	TimeoutMs          int     `json:"timeout_ms"`           // per check; defaults to 2000
+	TaskQueueThreshold float64 `json:"task_queue_threshold"` // queue fill fraction that fails readiness; defaults to 0.9
+}
+
+// healthCheck is one named readiness check. Host checks use the route
+// handler ABI: they receive {"name": ...} and return {"healthy": bool,
+// "message": ...}; NULL counts as unhealthy.
+type healthCheck struct {
+	name string
+	fn   uintptr // host callback, or 0 for a TCP ping
+	addr string  // host:port dialed by TCP pings
+
+	// probe, if set, replaces the callback or ping, e.g. for registered
+	// databases and Redis clients
+	probe func(timeout time.Duration) CheckResult
+}
+
+// CheckResult is one check's outcome in a /readyz response
+type CheckResult struct {
+	Status     string  `json:"status"` // ok or fail
+	Message    string  `json:"message,omitempty"`
+	DurationMs float64 `json:"duration_ms"`
+}
+
+// HealthResponse is the /healthz and /readyz body
+type HealthResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+var (
+	healthChecks   = make(map[string]healthCheck)
+	healthOptions  = HealthOptions{TimeoutMs: int(defaultHealthCheckTimeout / time.Millisecond), TaskQueueThreshold: defaultTaskQueueThreshold}
+	healthChecksMu sync.RWMutex
+
+	// The startup gate stays closed until the host calls SetReady, so load
+	// balancers are not sent traffic while routes are still being registered
+	startupReady atomic.Bool
+	// draining fails readiness while the server shuts down
+	draining atomic.Bool
+)
+
+// run performs the check, giving up once the timeout passes
+func (c healthCheck) run(timeout time.Duration) CheckResult {
+	start := time.Now()
+	done := make(chan CheckResult, 1)
+	go func() {
+		if c.probe != nil {
+			done <- c.probe(timeout)
+			return
+		}
+		if c.fn == 0 {
+			done <- pingTCP(c.addr, timeout)
+			return
+		}
+		done <- callHealthCheck(c)
+	}()
+	var result CheckResult
+	select {
+	case result = <-done:
+	case <-time.After(timeout):
+		result = CheckResult{Status: "fail", Message: "check timed out"}
+	}
+	result.DurationMs = float64(time.Since(start).Microseconds()) / 1000
+	return result
+}
+
+// pingTCP checks that a dependency accepts connections
+func pingTCP(addr string, timeout time.Duration) CheckResult {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return CheckResult{Status: "fail", Message: err.Error()}
+	}
+	conn.Close()
+	return CheckResult{Status: "ok"}
+}
+
+// callHealthCheck invokes a host check callback
+func callHealthCheck(c healthCheck) CheckResult {
+	request, _ := json.Marshal(map[string]string{"name": c.name})
+	raw, ok := callRouteHandler(c.fn, request)
+	if !ok {
+		return CheckResult{Status: "fail", Message: "check returned no result"}
+	}
+	var reply struct {
+		Healthy bool   `json:"healthy"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(raw, &reply); err != nil {
+		return CheckResult{Status: "fail", Message: fmt.Sprintf("invalid check result: %v", err)}
+	}
+	if !reply.Healthy {
+		return CheckResult{Status: "fail", Message: reply.Message}
+	}
+	return CheckResult{Status: "ok", Message: reply.Message}
+}
+
+// taskQueueCheck fails when the background task queue is nearly full
+func taskQueueCheck(threshold float64) CheckResult {
+	pool := activePool.Load()
+	if pool == nil || cap(pool.jobs) == 0 {
+		return CheckResult{Status: "ok"}
+	}
+	queued, capacity := len(pool.jobs), cap(pool.jobs)
+	message := fmt.Sprintf("%d of %d queued", queued, capacity)
+	if float64(queued) >= threshold*float64(capacity) {
+		return CheckResult{Status: "fail", Message: message}
+	}
+	return CheckResult{Status: "ok", Message: message}
+}
+
+// writeHealth writes a health response with 200 when healthy, 503 otherwise
+func writeHealth(w http.ResponseWriter, response HealthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if response.Status == "ok" {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := writeJSON(w, response); err != nil {
+		slog.Error("Error encoding health response", "error", err)
+	}
+}
+
+// ServeHealthz is the liveness probe: it reports ok whenever the server can
+// answer at all
+func ServeHealthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, HealthResponse{Status: "ok"})
+}
+
+// ServeReadyz is the readiness probe. It runs every registered check in
+// parallel along with the startup gate, shutdown, and task queue checks.
+func ServeReadyz(w http.ResponseWriter, r *http.Request) {
+	healthChecksMu.RLock()
+	checks := make([]healthCheck, 0, len(healthChecks))
+	for _, check := range healthChecks {
+		checks = append(checks, check)
+	}
+	opts := healthOptions
+	healthChecksMu.RUnlock()
+	sort.Slice(checks, func(i, j int) bool { return checks[i].name < checks[j].name })
+
+	results := make([]CheckResult, len(checks))
+	var wg sync.WaitGroup
+	timeout := time.Duration(opts.TimeoutMs) * time.Millisecond
+	for i, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = check.run(timeout)
+		}()
+	}
+	wg.Wait()
+
+	response := HealthResponse{Status: "ok", Checks: make(map[string]CheckResult, len(checks)+3)}
+	gate := CheckResult{Status: "ok"}
+	if !startupReady.Load() {
+		gate = CheckResult{Status: "fail", Message: "startup not complete"}
+	}
+	response.Checks["startup"] = gate
+	if draining.Load() {
+		response.Checks["shutdown"] = CheckResult{Status: "fail", Message: "server is shutting down"}
+	}
+	response.Checks["task_queue"] = taskQueueCheck(opts.TaskQueueThreshold)
+	for i, check := range checks {
+		response.Checks[check.name] = results[i]
+	}
+	for _, result := range response.Checks {
+		if result.Status != "ok" {
+			response.Status = "fail"
+		}
+	}
+	if response.Status != "ok" {
+		slog.Debug("Readiness check failed", "checks", len(response.Checks))
+	}
+	writeHealth(w, response)
+}
+
+// addHealthCheck registers or replaces a named check
+func addHealthCheck(check healthCheck, kind string) {
+	healthChecksMu.Lock()
+	healthChecks[check.name] = check
+	healthChecksMu.Unlock()
+	slog.Info("Health check registered", "name", check.name, "kind", kind)
+}
+
+// removeHealthCheck drops a named check, if registered
+func removeHealthCheck(name string) {
+	healthChecksMu.Lock()
+	delete(healthChecks, name)
+	healthChecksMu.Unlock()
+}
+
+// reservedCheckNames are used by the built-in readiness checks
+var reservedCheckNames = map[string]bool{"startup": true, "shutdown": true, "task_queue": true}
+
+// RegisterHealthCheck adds a host readiness check callback. Re-registering
+// a name replaces it.
+//
+//export RegisterHealthCheck
+func RegisterHealthCheck(cName *C.char, cHandler uintptr) int {
+	if cName == nil || cHandler == 0 {
+		return exportError(exportInvalid, "One or more parameters are nil in RegisterHealthCheck")
+	}
+	name := C.GoString(cName)
+	if name == "" || reservedCheckNames[name] {
+		return exportError(exportInvalid, "Invalid health check name", "name", name)
+	}
+	addHealthCheck(healthCheck{name: name, fn: cHandler}, "callback")
+	auditConfigChange("RegisterHealthCheck", map[string]string{"name": name})
+
+	return 0
+}
+
+// RegisterHealthPing adds a readiness check that dials a dependency such as
+// a database or cache at cAddr (host:port).
+//
+//export RegisterHealthPing
+func RegisterHealthPing(cName *C.char, cAddr *C.char) int {
+	if cName == nil || cAddr == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in RegisterHealthPing")
+	}
+	name := C.GoString(cName)
+	addr := C.GoString(cAddr)
+	if name == "" || reservedCheckNames[name] {
+		return exportError(exportInvalid, "Invalid health check name", "name", name)
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return exportError(exportInvalid, "Invalid health ping address", "name", name, "addr", addr, "error", err)
+	}
+	addHealthCheck(healthCheck{name: name, addr: addr}, "tcp")
+	auditConfigChange("RegisterHealthPing", map[string]string{"name": name, "addr": addr})
+
+	return 0
+}
+
+// ConfigureHealthChecks sets the per-check timeout and the task queue fill
+// fraction at which readiness fails. cOptions is a JSON HealthOptions object.
+//
+//export ConfigureHealthChecks
+func ConfigureHealthChecks(cOptions *C.char) int {
+	if cOptions == nil {
+		return exportError(exportInvalid, "cOptions is nil in ConfigureHealthChecks")
+	}
+	options := C.GoString(cOptions)
+	healthChecksMu.RLock()
+	opts := healthOptions
+	healthChecksMu.RUnlock()
+	if err := json.Unmarshal([]byte(options), &opts); err != nil {
+		return exportError(exportInvalid, "Invalid health check options", "error", err)
+	}
+	if opts.TimeoutMs <= 0 || opts.TaskQueueThreshold <= 0 || opts.TaskQueueThreshold > 1 {
+		return exportError(exportInvalid, "Invalid health check options", "timeout_ms", opts.TimeoutMs, "task_queue_threshold", opts.TaskQueueThreshold)
+	}
+	healthChecksMu.Lock()
+	healthOptions = opts
+	healthChecksMu.Unlock()
+
+	slog.Info("Health checks configured", "timeout_ms", opts.TimeoutMs, "task_queue_threshold", opts.TaskQueueThreshold)
+	auditConfigChange("ConfigureHealthChecks", map[string]string{
+		"timeout_ms":           strconv.Itoa(opts.TimeoutMs),
+		"task_queue_threshold": strconv.FormatFloat(opts.TaskQueueThreshold, 'f', -1, 64),
+	})
+
+	return 0
+}
+
+// SetReady opens (1) or closes (0) the startup gate reported by /readyz.
+// The gate starts closed; hosts open it once route registration is done.
+//
+//export SetReady
+func SetReady(ready int) int {
+	startupReady.Store(ready != 0)
+	slog.Info("Readiness gate set", "ready", ready != 0)
+	auditConfigChange("SetReady", map[string]string{"ready": strconv.FormatBool(ready != 0)})
+
+	return 0
+}
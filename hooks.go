@@ -0,0 +1,168 @@
+package main
+
+import (
+	"C"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Hook phases for RegisterHook
+const (
+	HookOnRequest     = "on_request"
+	HookBeforeHandler = "before_handler"
+	HookAfterHandler  = "after_handler"
+	HookOnResponse    = "on_response"
+	HookOnError       = "on_error"
+	HookOnShutdown    = "on_shutdown"
+)
+
+var hookPhases = map[string]bool{
+	HookOnRequest: true, HookBeforeHandler: true, HookAfterHandler: true,
+	HookOnResponse: true, HookOnError: true, HookOnShutdown: true,
+}
+
+// hookEvent is the JSON passed to hook callbacks. Request phases fill in
+// the request fields; status is set from after_handler on, and bytes and
+// duration_ms for on_response and on_error.
+type hookEvent struct {
+	Phase      string  `json:"phase"`
+	RequestID  string  `json:"request_id,omitempty"`
+	Method     string  `json:"method,omitempty"`
+	Path       string  `json:"path,omitempty"`
+	Route      string  `json:"route,omitempty"`
+	Host       string  `json:"host,omitempty"`
+	RemoteAddr string  `json:"remote_addr,omitempty"`
+	Status     int     `json:"status,omitempty"`
+	Bytes      int64   `json:"bytes,omitempty"`
+	DurationMs float64 `json:"duration_ms,omitempty"`
+}
+
+var (
+	// hooks holds the callbacks of each phase, in registration order. It is
+	// replaced rather than changed so requests read it without locking.
+	hooks   atomic.Pointer[map[string][]uintptr]
+	hooksMu sync.Mutex
+)
+
+// hooksFor returns the callbacks registered for phase
+func hooksFor(phase string) []uintptr {
+	if m := hooks.Load(); m != nil {
+		return (*m)[phase]
+	}
+	return nil
+}
+
+// runHooks passes the event to every callback of its phase
+func runHooks(event hookEvent) {
+	fns := hooksFor(event.Phase)
+	if len(fns) == 0 {
+		return
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	for _, fn := range fns {
+		callReportHandler(fn, data)
+	}
+}
+
+// requestHookEvent describes r for a request phase
+func requestHookEvent(phase string, r *http.Request) hookEvent {
+	event := hookEvent{Phase: phase, Method: r.Method, Path: r.URL.Path, Host: r.Host, RemoteAddr: r.RemoteAddr}
+	if info := requestInfoFrom(r); info != nil {
+		event.RequestID, event.Route = info.ID, info.Route
+	}
+	return event
+}
+
+// hookMiddleware runs the on_request, on_response and on_error hooks
+// around the rest of the chain. on_error follows on_response for requests
+// answered with a 5xx status, recovered panics included.
+func hookMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hooks.Load() == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		runHooks(requestHookEvent(HookOnRequest, r))
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		event := requestHookEvent(HookOnResponse, r)
+		event.Status, event.Bytes = rec.statusCode(), rec.bytes
+		event.DurationMs = float64(time.Since(start).Microseconds()) / 1000
+		runHooks(event)
+		if event.Status >= 500 {
+			event.Phase = HookOnError
+			runHooks(event)
+		}
+	})
+}
+
+// serveWithHandlerHooks runs the before_handler and after_handler hooks
+// around serve, which produces a matched route's response once the request
+// has been validated
+func serveWithHandlerHooks(w http.ResponseWriter, r *http.Request, serve func(w http.ResponseWriter)) {
+	if len(hooksFor(HookBeforeHandler)) == 0 && len(hooksFor(HookAfterHandler)) == 0 {
+		serve(w)
+		return
+	}
+	runHooks(requestHookEvent(HookBeforeHandler, r))
+	rec := &statusRecorder{ResponseWriter: w}
+	serve(rec)
+	event := requestHookEvent(HookAfterHandler, r)
+	event.Status = rec.statusCode()
+	runHooks(event)
+}
+
+// RegisterHook adds a host callback for a lifecycle phase: on_request when
+// a request arrives, before_handler and after_handler around a matched
+// route's handler, on_response once the response is written, on_error after
+// on_response for 5xx responses, and on_shutdown after in-flight work
+// drains. The callback receives a JSON event and runs on the request's
+// path, so it should be quick, e.g. audit logging or metrics enrichment.
+// Passing 0 as cHook removes the phase's hooks. Returns 0, or a negative
+// status if the phase is unknown.
+//
+//export RegisterHook
+func RegisterHook(cPhase *C.char, cHook uintptr) int {
+	if cPhase == nil {
+		return exportError(exportInvalid, "cPhase is nil in RegisterHook")
+	}
+	phase := strings.ToLower(C.GoString(cPhase))
+	if !hookPhases[phase] {
+		return exportError(exportInvalid, "Unknown hook phase", "phase", phase)
+	}
+
+	hooksMu.Lock()
+	next := make(map[string][]uintptr)
+	if m := hooks.Load(); m != nil {
+		for p, fns := range *m {
+			next[p] = fns
+		}
+	}
+	if cHook == 0 {
+		delete(next, phase)
+	} else {
+		next[phase] = append(append([]uintptr(nil), next[phase]...), cHook)
+	}
+	if len(next) == 0 {
+		hooks.Store(nil)
+	} else {
+		hooks.Store(&next)
+	}
+	count := len(next[phase])
+	hooksMu.Unlock()
+
+	slog.Info("Hook registered", "phase", phase, "hooks", count)
+	auditConfigChange("RegisterHook", map[string]string{"phase": phase, "enabled": fmt.Sprint(cHook != 0)})
+	return 0
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"C"
+	"crypto/tls"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// HTTP2Options configures HTTP/2 support
+type HTTP2Options struct {
+	Enabled              *bool `json:"enabled"`                // HTTP/2 over TLS via ALPN; defaults to true
+	H2C                  bool  `json:"h2c"`                    // cleartext HTTP/2 with prior knowledge on plain listeners
+	MaxConcurrentStreams int   `json:"max_concurrent_streams"` // per connection; 0 keeps the Go default (250)
+}
+
+var (
+	http2Options   HTTP2Options
+	http2OptionsMu sync.RWMutex
+)
+
+func currentHTTP2Options() HTTP2Options {
+	http2OptionsMu.RLock()
+	defer http2OptionsMu.RUnlock()
+	return http2Options
+}
+
+func (o HTTP2Options) enabled() bool {
+	return o.Enabled == nil || *o.Enabled
+}
+
+// configureProtocols selects the protocols the server speaks. HTTP/2 over
+// TLS needs "h2" offered through ALPN on the listener's TLS config, since
+// the server is handed an already-wrapped listener.
+func configureProtocols(server *http.Server, tlsConfig *tls.Config, o HTTP2Options) {
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(o.enabled() && tlsConfig != nil)
+	protocols.SetUnencryptedHTTP2(o.H2C && tlsConfig == nil)
+	server.Protocols = protocols
+	if o.MaxConcurrentStreams > 0 {
+		server.HTTP2 = &http.HTTP2Config{MaxConcurrentStreams: o.MaxConcurrentStreams}
+	}
+	if tlsConfig != nil {
+		tlsConfig.NextProtos = []string{"http/1.1"}
+		if protocols.HTTP2() {
+			tlsConfig.NextProtos = []string{"h2", "http/1.1"}
+		}
+	}
+}
+
+// protocolLogAttrs describes how a request's protocol was negotiated, for
+// access logs
+func protocolLogAttrs(r *http.Request) []any {
+	attrs := []any{"proto", r.Proto}
+	if r.TLS != nil {
+		attrs = append(attrs, "tls_version", tls.VersionName(r.TLS.Version), "alpn", r.TLS.NegotiatedProtocol)
+	} else if r.ProtoMajor == 2 {
+		attrs = append(attrs, "h2c", true)
+	}
+	return attrs
+}
+
+// ConfigureHTTP2 sets HTTP/2 options used by the next server start.
+// HTTP/2 is negotiated automatically on TLS listeners unless disabled; h2c
+// serves cleartext HTTP/2 to clients that speak it with prior knowledge,
+// such as gRPC clients or reverse proxies. cOptions is a JSON HTTP2Options
+// object.
+//
+//export ConfigureHTTP2
+func ConfigureHTTP2(cOptions *C.char) int {
+	if cOptions == nil {
+		return exportError(exportInvalid, "cOptions is nil in ConfigureHTTP2")
+	}
+	options := C.GoString(cOptions)
+	var opts HTTP2Options
+	if options != "" {
+		if err := json.Unmarshal([]byte(options), &opts); err != nil {
+			return exportError(exportInvalid, "Invalid HTTP/2 options", "error", err)
+		}
+	}
+	if opts.MaxConcurrentStreams < 0 {
+		return exportError(exportInvalid, "Invalid HTTP/2 max concurrent streams", "max_concurrent_streams", opts.MaxConcurrentStreams)
+	}
+	http2OptionsMu.Lock()
+	http2Options = opts
+	http2OptionsMu.Unlock()
+
+	slog.Info("HTTP/2 configured", "enabled", opts.enabled(), "h2c", opts.H2C, "max_concurrent_streams", opts.MaxConcurrentStreams)
+	auditConfigChange("ConfigureHTTP2", map[string]string{
+		"enabled":                strconv.FormatBool(opts.enabled()),
+		"h2c":                    strconv.FormatBool(opts.H2C),
+		"max_concurrent_streams": strconv.Itoa(opts.MaxConcurrentStreams),
+	})
+
+	return 0
+}
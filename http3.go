@@ -0,0 +1,136 @@
+package main
+
+import (
+	"C"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// altSvcMaxAge is how long, in seconds, clients may remember the HTTP/3
+// endpoint advertised in Alt-Svc
+const altSvcMaxAge = 86400
+
+var errHTTP3NeedsTLS = errors.New("HTTP/3 requires TLS to be enabled")
+
+// HTTP3Options configures the QUIC listener
+type HTTP3Options struct {
+	Enabled bool `json:"enabled"`
+	Port    int  `json:"port"` // UDP port; defaults to the TCP port
+	Only    bool `json:"only"` // serve HTTP/3 without the TCP listener
+}
+
+var (
+	http3Options   HTTP3Options
+	http3OptionsMu sync.RWMutex
+)
+
+func currentHTTP3Options() HTTP3Options {
+	http3OptionsMu.RLock()
+	defer http3OptionsMu.RUnlock()
+	return http3Options
+}
+
+// listenHTTP3 binds the UDP socket for a QUIC server sharing the TCP
+// server's handler chain. The caller starts serving on the returned conn.
+func listenHTTP3(cfg ServerConfig, handler http.Handler, tlsConfig *tls.Config, o HTTP3Options) (*http3.Server, net.PacketConn, error) {
+	if tlsConfig == nil {
+		return nil, nil, errHTTP3NeedsTLS
+	}
+	port := o.Port
+	if port == 0 {
+		port = cfg.Port
+	}
+	addr := net.JoinHostPort(cfg.host(), strconv.Itoa(port))
+	conn, err := listenUDP("udp:"+addr, addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	server := &http3.Server{
+		Addr:        addr,
+		Port:        port,
+		Handler:     handler,
+		TLSConfig:   tlsConfig,
+		IdleTimeout: cfg.IdleTimeout,
+	}
+	return server, conn, nil
+}
+
+// altSvcMiddleware advertises the HTTP/3 endpoint on TCP responses so
+// clients can switch to QUIC for later requests
+func altSvcMiddleware(port int, next http.Handler) http.Handler {
+	value := fmt.Sprintf(`h3=":%d"; ma=%d`, port, altSvcMaxAge)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Alt-Svc", value)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// shutdownHTTP3 drains the QUIC listener and releases its socket. quic-go
+// also waits for clients to close idle connections, so once no requests are
+// in flight the remaining connections are closed instead.
+func shutdownHTTP3(ctx context.Context, server *http3.Server, conn net.PacketConn) error {
+	defer conn.Close()
+	done := make(chan error, 1)
+	go func() { done <- server.Shutdown(ctx) }()
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				server.Close()
+			}
+			return err
+		case <-ticker.C:
+			if activeRequests.Load() == 0 {
+				return server.Close()
+			}
+		}
+	}
+}
+
+// ConfigureHTTP3 sets the QUIC listener options used by the next server
+// start. HTTP/3 needs TLS; the TCP listeners advertise it with Alt-Svc
+// unless "only" disables them. cOptions is a JSON HTTP3Options object.
+//
+//export ConfigureHTTP3
+func ConfigureHTTP3(cOptions *C.char) int {
+	if cOptions == nil {
+		return exportError(exportInvalid, "cOptions is nil in ConfigureHTTP3")
+	}
+	var opts HTTP3Options
+	if options := C.GoString(cOptions); options != "" {
+		if err := json.Unmarshal([]byte(options), &opts); err != nil {
+			return exportError(exportInvalid, "Invalid HTTP/3 options", "error", err)
+		}
+	}
+	if opts.Port < 0 || opts.Port > 65535 {
+		return exportError(exportInvalid, "Invalid HTTP/3 port", "port", opts.Port)
+	}
+	if opts.Only && !opts.Enabled {
+		return exportError(exportInvalid, "HTTP/3-only mode needs HTTP/3 enabled")
+	}
+	http3OptionsMu.Lock()
+	http3Options = opts
+	http3OptionsMu.Unlock()
+
+	slog.Info("HTTP/3 configured", "enabled", opts.Enabled, "port", opts.Port, "only", opts.Only)
+	auditConfigChange("ConfigureHTTP3", map[string]string{
+		"enabled": strconv.FormatBool(opts.Enabled),
+		"port":    strconv.Itoa(opts.Port),
+		"only":    strconv.FormatBool(opts.Only),
+	})
+
+	return 0
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"C"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+)
+
+// parsePrefixes parses CIDRs and bare IPs, which cover a single address
+func parsePrefixes(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if strings.Contains(value, "/") {
+			prefix, err := netip.ParsePrefix(value)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return nil, err
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+func prefixesContain(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteAddrIP returns the IP of r.RemoteAddr, with or without a port
+func remoteAddrIP(remoteAddr string) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// trustedProxies holds the ConfigureTrustedProxies prefixes; empty trusts
+// no one, so forwarding headers are ignored
+var trustedProxies atomic.Pointer[[]netip.Prefix]
+
+func init() {
+	trustedProxies.Store(&[]netip.Prefix{})
+}
+
+// forwardedClient returns the client address a trusted peer reports. The
+// X-Forwarded-For chain is walked from the right, skipping further trusted
+// hops, so entries a client prepended itself are never used; X-Real-IP is
+// the fallback for proxies that only set that.
+func forwardedClient(r *http.Request, trusted []netip.Prefix) (netip.Addr, bool) {
+	if values := r.Header.Values("X-Forwarded-For"); len(values) > 0 {
+		hops := strings.Split(strings.Join(values, ","), ",")
+		var client netip.Addr
+		for i := len(hops) - 1; i >= 0; i-- {
+			addr, ok := remoteAddrIP(strings.TrimSpace(hops[i]))
+			if !ok {
+				break
+			}
+			client = addr
+			if !prefixesContain(trusted, addr) {
+				break
+			}
+		}
+		if client.IsValid() {
+			return client, true
+		}
+	}
+	return remoteAddrIP(strings.TrimSpace(r.Header.Get("X-Real-IP")))
+}
+
+// realIPMiddleware replaces r.RemoteAddr with the forwarded client address
+// when the connection comes from a trusted proxy, so logs, rate limits, and
+// the ipfilter middleware see the client rather than the proxy
+func realIPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if trusted := *trustedProxies.Load(); len(trusted) > 0 {
+			if peer, ok := remoteAddrIP(r.RemoteAddr); ok && prefixesContain(trusted, peer) {
+				if client, ok := forwardedClient(r, trusted); ok {
+					r.RemoteAddr = net.JoinHostPort(client.String(), "0")
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ipFilterOptions configures the "ipfilter" middleware
+type ipFilterOptions struct {
+	Allow []string `json:"allow"` // when set, only these CIDRs or IPs are let in
+	Deny  []string `json:"deny"`  // rejected even when also allowed
+}
+
+// newIPFilterMiddleware builds the "ipfilter" middleware, which answers
+// clients outside the allowlist or inside the denylist with 403
+func newIPFilterMiddleware(options []byte) (func(http.Handler) http.Handler, error) {
+	var opts ipFilterOptions
+	if len(options) > 0 {
+		if err := json.Unmarshal(options, &opts); err != nil {
+			return nil, err
+		}
+	}
+	allow, err := parsePrefixes(opts.Allow)
+	if err != nil {
+		return nil, fmt.Errorf("invalid allow entry: %w", err)
+	}
+	deny, err := parsePrefixes(opts.Deny)
+	if err != nil {
+		return nil, fmt.Errorf("invalid deny entry: %w", err)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			addr, ok := remoteAddrIP(r.RemoteAddr)
+			if !ok || prefixesContain(deny, addr) || (len(allow) > 0 && !prefixesContain(allow, addr)) {
+				slog.Debug("Client address rejected", "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+				http.Error(w, `{"error": "Forbidden"}`, http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+// ConfigureTrustedProxies sets the proxies, as a JSON array of CIDRs or
+// IPs, whose X-Forwarded-For and X-Real-IP headers are believed. Requests
+// from other peers keep their connection address. An empty array (the
+// default) trusts no proxy.
+//
+//export ConfigureTrustedProxies
+func ConfigureTrustedProxies(cProxies *C.char) int {
+	if cProxies == nil {
+		return exportError(exportInvalid, "cProxies is nil in ConfigureTrustedProxies")
+	}
+	raw := C.GoString(cProxies)
+	var values []string
+	if err := json.Unmarshal([]byte(raw), &values); err != nil {
+		return exportError(exportInvalid, "Invalid trusted proxies", "error", err)
+	}
+	prefixes, err := parsePrefixes(values)
+	if err != nil {
+		return exportError(exportInvalid, "Invalid trusted proxies", "error", err)
+	}
+	trustedProxies.Store(&prefixes)
+
+	slog.Info("Trusted proxies configured", "proxies", len(prefixes))
+	auditConfigChange("ConfigureTrustedProxies", map[string]string{"proxies": raw})
+
+	return 0
+}
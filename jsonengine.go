@@ -0,0 +1,97 @@
+package main
+
+import (
+	"C"
+	"encoding/json"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// jsonEngine is a JSON implementation the hot paths can run on: the
+// OpenAPI document, the requests passed to host handlers and the responses
+// they return, and the bodies written through writeJSON. Engines must
+// produce the same bytes as encoding/json, HTML escaping and sorted map
+// keys included.
+type jsonEngine struct {
+	name      string
+	marshal   func(v interface{}) ([]byte, error)
+	unmarshal func(data []byte, v interface{}) error
+}
+
+var stdJSON = &jsonEngine{name: "std", marshal: json.Marshal, unmarshal: json.Unmarshal}
+
+var (
+	// jsonEngines lists the engines compiled in; files behind build tags add
+	// theirs from init
+	jsonEngines   = map[string]*jsonEngine{"std": stdJSON}
+	jsonEnginesMu sync.Mutex
+	activeJSON    atomic.Pointer[jsonEngine]
+)
+
+func init() {
+	activeJSON.Store(stdJSON)
+}
+
+// registerJSONEngine makes an engine available to SetJSONEngine
+func registerJSONEngine(e *jsonEngine) {
+	jsonEnginesMu.Lock()
+	jsonEngines[e.name] = e
+	jsonEnginesMu.Unlock()
+}
+
+// currentJSON is the engine selected with SetJSONEngine
+func currentJSON() *jsonEngine {
+	return activeJSON.Load()
+}
+
+// jsonEngineNames lists the compiled-in engines
+func jsonEngineNames() []string {
+	jsonEnginesMu.Lock()
+	defer jsonEnginesMu.Unlock()
+	names := make([]string, 0, len(jsonEngines))
+	for name := range jsonEngines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetJSONEngine selects the JSON implementation used for the OpenAPI
+// document, host handler requests and responses, and built-in JSON
+// responses. "std" is encoding/json and the default; "sonic" and "go-json"
+// are available when the library is built with the goserver_sonic or
+// goserver_gojson tag; the tests of each tag check that its engine
+// matches encoding/json. Returns 0, or a negative status if the engine is
+// unknown.
+//
+//export SetJSONEngine
+func SetJSONEngine(cName *C.char) int {
+	if cName == nil {
+		return exportError(exportInvalid, "cName is nil in SetJSONEngine")
+	}
+	name := strings.ToLower(strings.TrimSpace(C.GoString(cName)))
+	jsonEnginesMu.Lock()
+	engine, ok := jsonEngines[name]
+	jsonEnginesMu.Unlock()
+	if !ok {
+		return exportError(exportInvalid, "Unknown JSON engine", "engine", name, "available", jsonEngineNames())
+	}
+	activeJSON.Store(engine)
+	invalidateOpenAPICache()
+
+	slog.Info("JSON engine set", "engine", name)
+	auditConfigChange("SetJSONEngine", map[string]string{"engine": name})
+	return 0
+}
+
+// GetJSONEngines returns {"active": name, "available": [names]}. The
+// caller frees the string with FreeString.
+//
+//export GetJSONEngines
+func GetJSONEngines() *C.char {
+	out, _ := json.Marshal(map[string]interface{}{"active": currentJSON().name, "available": jsonEngineNames()})
+	return C.CString(string(out))
+}
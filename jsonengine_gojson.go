@@ -0,0 +1,11 @@
+//go:build goserver_gojson
+
+package main
+
+// Built with -tags goserver_gojson; go.mod requires github.com/goccy/go-json.
+
+import gojson "github.com/goccy/go-json"
+
+func init() {
+	registerJSONEngine(&jsonEngine{name: "go-json", marshal: gojson.Marshal, unmarshal: gojson.Unmarshal})
+}
@@ -0,0 +1,9 @@
+//go:build goserver_gojson
+
+package main
+
+import "testing"
+
+func TestGoJSONJSONEngine(t *testing.T) {
+	testJSONEngineMatchesStd(t, "go-json")
+}
@@ -0,0 +1,12 @@
+//go:build goserver_sonic
+
+package main
+
+// Built with -tags goserver_sonic; go.mod requires github.com/bytedance/sonic.
+
+import "github.com/bytedance/sonic"
+
+func init() {
+	// ConfigStd escapes HTML and sorts map keys as encoding/json does
+	registerJSONEngine(&jsonEngine{name: "sonic", marshal: sonic.ConfigStd.Marshal, unmarshal: sonic.ConfigStd.Unmarshal})
+}
@@ -0,0 +1,9 @@
+//go:build goserver_sonic
+
+package main
+
+import "testing"
+
+func TestSonicJSONEngine(t *testing.T) {
+	testJSONEngineMatchesStd(t, "sonic")
+}
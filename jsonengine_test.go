@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+// jsonEngineCases are values every engine must encode byte for byte as
+// encoding/json does: escaping, map key order, raw messages, byte slices,
+// number formatting, and the OpenAPI document
+var jsonEngineCases = []struct {
+	name  string
+	value func() interface{}
+}{
+	{"html and control escapes", func() interface{} {
+		return ApiResponse{Message: `<a href="x">&amp;</a> "quoted" \ é ` + " \n\t\x01 ", BackgroundTask: TaskResponse{Message: "Task started in background: task-1", TaskID: "task-1"}}
+	}},
+	{"handler request", func() interface{} {
+		return HandlerRequest{
+			Method:  "POST",
+			Path:    "/users/42",
+			Headers: map[string][]string{"Content-Type": {"application/json"}, "X-B": {"2"}, "X-A": {"1", "&"}},
+			Query:   map[string][]string{"q": {"<script>"}},
+			Body:    []byte{0, 1, 2, 0xff, 'x'},
+			Context: map[string]json.RawMessage{"user": json.RawMessage(`{"id": 1, "roles": ["a"]}`)},
+		}
+	}},
+	{"handler response", func() interface{} {
+		return HandlerResponse{Status: 201, Headers: map[string]string{"X-Z": "1", "Location": "/a?b=<c>"}, BodyBase64: []byte("binary\x00")}
+	}},
+	{"numbers and nested maps", func() interface{} {
+		return map[string]interface{}{"z": 1.5, "a": []interface{}{nil, true, int64(1) << 53, 1e21, 0.000001, -0.0}, "m": map[int]string{10: "x", 2: "y"}}
+	}},
+	{"empty values", func() interface{} {
+		return map[string]interface{}{"slice": []string{}, "nil": []string(nil), "map": map[string]int{}, "string": ""}
+	}},
+	{"openapi document", func() interface{} { return openAPIDocument("", "") }},
+}
+
+// testJSONEngineMatchesStd checks an engine against encoding/json on
+// jsonEngineCases, encoding and decoding
+func testJSONEngineMatchesStd(t *testing.T, name string) {
+	t.Helper()
+	jsonEnginesMu.Lock()
+	engine, ok := jsonEngines[name]
+	jsonEnginesMu.Unlock()
+	if !ok {
+		t.Fatalf("JSON engine %q is not registered", name)
+	}
+	for _, tc := range jsonEngineCases {
+		t.Run(tc.name, func(t *testing.T) {
+			value := tc.value()
+			want, err := json.Marshal(value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := engine.marshal(value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("encoded\n%s\nencoding/json\n%s", got, want)
+			}
+
+			var viaStd, viaEngine interface{}
+			if err := json.Unmarshal(want, &viaStd); err != nil {
+				t.Fatal(err)
+			}
+			if err := engine.unmarshal(want, &viaEngine); err != nil {
+				t.Fatal(err)
+			}
+			a, _ := json.Marshal(viaStd)
+			b, _ := json.Marshal(viaEngine)
+			if !bytes.Equal(a, b) {
+				t.Errorf("decoded to\n%s\nencoding/json\n%s", b, a)
+			}
+		})
+	}
+}
+
+func TestStdJSONEngine(t *testing.T) {
+	testJSONEngineMatchesStd(t, "std")
+}
+
+// TestJSONEngineHandlerResponseRoundTrip checks that handler responses
+// decode the same through every compiled-in engine
+func TestJSONEngineHandlerResponseRoundTrip(t *testing.T) {
+	raw := []byte(`{"status": 200, "headers": {"Content-Type": "text/plain"}, "body": "hi <", "body_base64": "AAE=", "stream": false}`)
+	var want HandlerResponse
+	if err := json.Unmarshal(raw, &want); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range jsonEngineNames() {
+		jsonEnginesMu.Lock()
+		engine := jsonEngines[name]
+		jsonEnginesMu.Unlock()
+		var got HandlerResponse
+		if err := engine.unmarshal(raw, &got); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		a, _ := json.Marshal(got)
+		b, _ := json.Marshal(want)
+		if !bytes.Equal(a, b) {
+			t.Errorf("%s decoded %s, want %s", name, a, b)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Dependencies consulted for JWT keys when the middleware options omit them,
+// so keys can be rotated with RegisterDependency
+const (
+	jwtSecretDependency    = "jwt_secret"
+	jwtPublicKeyDependency = "jwt_public_key"
+)
+
+// jwtOptions are the "jwt" middleware options
+type jwtOptions struct {
+	Algorithm   string   `json:"algorithm"`  // HS256 (default) or RS256
+	Secret      string   `json:"secret"`     // HS256 shared secret
+	PublicKey   string   `json:"public_key"` // RS256 PEM public key or certificate
+	Issuer      string   `json:"issuer"`
+	Audience    string   `json:"audience"`
+	Leeway      int      `json:"leeway"` // clock skew allowance in seconds
+	ExemptPaths []string `json:"exempt_paths"`
+}
+
+var (
+	errJWTMalformed = errors.New("malformed token")
+	errJWTAlgorithm = errors.New("unexpected signing algorithm")
+	errJWTSignature = errors.New("invalid signature")
+	errJWTExpired   = errors.New("token is expired")
+	errJWTNotYet    = errors.New("token is not valid yet")
+	errJWTIssuer    = errors.New("invalid issuer")
+	errJWTAudience  = errors.New("invalid audience")
+	errJWTNoKey     = errors.New("no verification key configured")
+)
+
+// jwtVerifier checks tokens against the configured algorithm and key
+type jwtVerifier struct {
+	opts jwtOptions
+
+	mu        sync.Mutex // guards the parsed public key cache
+	keyPEM    string
+	publicKey *rsa.PublicKey
+}
+
+// dependencyString returns a registered dependency if it is a string
+func dependencyString(name string) string {
+	value, ok := GetDependency(name)
+	if !ok {
+		return ""
+	}
+	s, _ := value.(string)
+	return s
+}
+
+// parseRSAPublicKey accepts PKIX, PKCS#1, and certificate PEM blocks
+func parseRSAPublicKey(data string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("no PEM block in public key")
+	}
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if key, ok := cert.PublicKey.(*rsa.PublicKey); ok {
+			return key, nil
+		}
+	default:
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if key, ok := key.(*rsa.PublicKey); ok {
+			return key, nil
+		}
+	}
+	return nil, errors.New("public key is not RSA")
+}
+
+// rsaKey returns the RS256 key, re-parsing only when the PEM changes
+func (v *jwtVerifier) rsaKey() (*rsa.PublicKey, error) {
+	pemData := v.opts.PublicKey
+	if pemData == "" {
+		pemData = dependencyString(jwtPublicKeyDependency)
+	}
+	if pemData == "" {
+		return nil, errJWTNoKey
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if pemData != v.keyPEM {
+		key, err := parseRSAPublicKey(pemData)
+		if err != nil {
+			return nil, err
+		}
+		v.keyPEM, v.publicKey = pemData, key
+	}
+	return v.publicKey, nil
+}
+
+// verifySignature checks the signature over the token's signing input
+func (v *jwtVerifier) verifySignature(signingInput string, signature []byte) error {
+	switch v.opts.Algorithm {
+	case "HS256":
+		secret := v.opts.Secret
+		if secret == "" {
+			secret = dependencyString(jwtSecretDependency)
+		}
+		if secret == "" {
+			return errJWTNoKey
+		}
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(signingInput))
+		if subtle.ConstantTimeCompare(mac.Sum(nil), signature) != 1 {
+			return errJWTSignature
+		}
+		return nil
+	case "RS256":
+		key, err := v.rsaKey()
+		if err != nil {
+			return err
+		}
+		digest := sha256.Sum256([]byte(signingInput))
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) != nil {
+			return errJWTSignature
+		}
+		return nil
+	}
+	return errJWTAlgorithm
+}
+
+// numericClaim reads a NumericDate claim
+func numericClaim(claims map[string]interface{}, name string) (time.Time, bool) {
+	n, ok := claims[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(n), 0), true
+}
+
+// audienceMatches handles aud as a string or an array of strings
+func audienceMatches(aud interface{}, want string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == want
+	case []interface{}:
+		for _, a := range aud {
+			if s, ok := a.(string); ok && s == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// verify validates a compact JWS token and returns its claims
+func (v *jwtVerifier) verify(token string, now time.Time) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errJWTMalformed
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errJWTMalformed
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, errJWTMalformed
+	}
+	// The configured algorithm is enforced so tokens cannot pick a weaker one
+	if header.Alg != v.opts.Algorithm {
+		return nil, errJWTAlgorithm
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errJWTMalformed
+	}
+	if err := v.verifySignature(parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errJWTMalformed
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, errJWTMalformed
+	}
+	leeway := time.Duration(v.opts.Leeway) * time.Second
+	if exp, ok := numericClaim(claims, "exp"); ok && now.After(exp.Add(leeway)) {
+		return nil, errJWTExpired
+	}
+	if nbf, ok := numericClaim(claims, "nbf"); ok && now.Add(leeway).Before(nbf) {
+		return nil, errJWTNotYet
+	}
+	if v.opts.Issuer != "" && claims["iss"] != v.opts.Issuer {
+		return nil, errJWTIssuer
+	}
+	if v.opts.Audience != "" && !audienceMatches(claims["aud"], v.opts.Audience) {
+		return nil, errJWTAudience
+	}
+	return claims, nil
+}
+
+// bearerToken extracts the token from an Authorization: Bearer header
+func bearerToken(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	scheme, token, ok := strings.Cut(auth, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// newJWTMiddleware builds the "jwt" middleware. Valid tokens' claims are
+// stored on the request for handlers; anything else is rejected with 401.
+func newJWTMiddleware(options []byte) (func(http.Handler) http.Handler, error) {
+	opts := jwtOptions{Algorithm: "HS256"}
+	if len(options) > 0 {
+		if err := json.Unmarshal(options, &opts); err != nil {
+			return nil, err
+		}
+	}
+	opts.Algorithm = strings.ToUpper(opts.Algorithm)
+	if opts.Algorithm != "HS256" && opts.Algorithm != "RS256" {
+		return nil, fmt.Errorf("unsupported algorithm %q", opts.Algorithm)
+	}
+	if opts.PublicKey != "" {
+		if _, err := parseRSAPublicKey(opts.PublicKey); err != nil {
+			return nil, err
+		}
+	}
+	if opts.ExemptPaths == nil {
+		opts.ExemptPaths = authExemptPrefixes
+	}
+	verifier := &jwtVerifier{opts: opts}
+
+	registerSecurityScheme("bearerAuth", map[string]interface{}{
+		"type":         "http",
+		"scheme":       "bearer",
+		"bearerFormat": "JWT",
+	})
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if authExempt(r.URL.Path, opts.ExemptPaths) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			token, ok := bearerToken(r)
+			if !ok {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, `{"error": "Missing bearer token"}`, http.StatusUnauthorized)
+				return
+			}
+			claims, err := verifier.verify(token, time.Now())
+			if err != nil {
+				slog.Debug("JWT rejected", "path", r.URL.Path, "error", err)
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				body, _ := json.Marshal(ErrorResponse{Error: "Invalid token: " + err.Error()})
+				http.Error(w, string(body), http.StatusUnauthorized)
+				return
+			}
+			if info := requestInfoFrom(r); info != nil {
+				info.Claims = claims
+			}
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
@@ -2,20 +2,27 @@ package main
 
 import (
 	"C"
+	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
-	"unsafe"
 
 	"github.com/go-playground/validator/v10"
+	"github.com/quic-go/quic-go/http3"
 )
 
 // TaskResponse for JSON response
@@ -32,128 +39,305 @@ type ApiResponse struct {
 
 // ErrorResponse for structured error responses
 type ErrorResponse struct {
-	Error string `json:"error"`
+	Error     string `json:"error"`
+	RequestID string `json:"request_id,omitempty"`
 }
 
 // RouteInfo stores route metadata for OpenAPI and handling
 type RouteInfo struct {
-	Path        string
-	Method      string
-	Message     string // Store the message directly instead of a handler for simplicity
-	Description string
-	Parameters  []ParameterInfo
-	Responses   map[int]string
+	Path             string
+	Method           string
+	Message          string // Store the message directly instead of a handler for simplicity
+	Description      string
+	Parameters       []ParameterInfo
+	Responses        map[int]string
+	Trailers         map[string]string     // Sent after the body; HTTP/1.1+ only
+	Descriptions     map[string]string     // Localized descriptions keyed by language tag
+	Compression      *bool                 // Overrides the global compression setting when set
+	Handler          uintptr               // Host callback invoked for each request; 0 serves Message
+	BodySchema       *BodySchema           // Validates JSON request bodies when set
+	TaskBackpressure string                // BackpressureQueue (default) or BackpressureReject
+	Task             string                // Registered task static responses start; empty runs the placeholder task
+	WebSocket        uintptr               // Host callback for WebSocket events; upgrades the request when set
+	SSE              uintptr               // Host callback for SSE stream open/close; streams events when set
+	Status           int                   // Success status for static routes; 0 means 200
+	ContentType      string                // Static response content type; non-JSON types send Message as the raw body
+	Headers          map[string]string     // Extra headers sent with static responses
+	CORS             *CORSOptions          // Overrides the cors middleware options when set
+	SecureHeaders    *SecureHeadersOptions // Overrides the secureheaders middleware options when set
+	RateLimit        *RateLimit            // Per-client limit for this route under the ratelimit middleware
+	RequiredScopes   []string              // Scopes an API key needs under the apikey middleware
+	Multipart        *MultipartOptions     // Parses multipart/form-data bodies for handler routes when set
+	FormFields       []ParameterInfo       // Declared application/x-www-form-urlencoded fields, coerced and validated
+	RequestModel     string                // Registered model documenting the request body
+	ResponseModels   map[int]string        // Registered models documenting response bodies by status
+	Tags             []string              // OpenAPI tags, from the route's group
+	Group            int                   // Route group handle whose middleware runs for this route; 0 for none
+	Host             string                // Virtual host pattern the route is served under; empty for the default host
+	Template         string                // RegisterTemplateDir template rendered with the handler's JSON context
+	Formats          []string              // Response formats handler responses may be negotiated into; empty allows all
+	MaxBodySize      int64                 // Request body limit in bytes; 0 uses the server default, -1 is unlimited
+	Cache            *RouteCache           // Caches GET responses when set
+	Timeout          time.Duration         // Deadline under the timeout middleware; 0 uses its default, -1 is none
+	Concurrency      int                   // Requests served at once under the concurrency middleware; 0 is unlimited
+	Gateway          *gatewayBinding       // Transcodes requests to a gRPC method when set, see RegisterGRPCGateway
+	Deprecation      *routeDeprecation     // Marks the route deprecated when set, see SetRouteDeprecation
+	Version          int                   // API version the route was registered under; 0 for unversioned routes
+	Redirect         *routeRedirect        // Answers with a redirect when set, see RegisterRedirect
+	Ranges           bool                  // Serves byte ranges of 200 handler responses, see SetRouteRanges
+	BodyStream       *BodyStreamOptions    // Streams the request body to the handler when set, see SetRouteBodyStream
 }
 
-// ParameterInfo for OpenAPI documentation
+// successStatus is the status a static route responds with
+func (route RouteInfo) successStatus() int {
+	if route.Status == 0 {
+		return http.StatusOK
+	}
+	return route.Status
+}
+
+// contentType is the static response content type, JSON by default
+func (route RouteInfo) contentType() string {
+	if route.ContentType == "" {
+		return "application/json"
+	}
+	return route.ContentType
+}
+
+// ParameterInfo for OpenAPI documentation; query parameters are also
+// coerced and validated by the dispatcher
 type ParameterInfo struct {
-	Name        string `json:"name"`
-	In          string `json:"in"` // e.g., "query", "path"
-	Description string `json:"description"`
-	Required    bool   `json:"required"`
-	Type        string `json:"type"`
+	Name        string      `json:"name"`
+	In          string      `json:"in"` // e.g., "query", "path"
+	Description string      `json:"description"`
+	Required    bool        `json:"required"`
+	Type        string      `json:"type"`    // int, float, bool, string, or enum
+	Enum        []string    `json:"enum"`    // allowed values for enum parameters
+	Default     interface{} `json:"default"` // applied when an optional query parameter is absent
 }
 
 // OpenAPI structure for API documentation
 type OpenAPI struct {
-	OpenAPI    string                         `json:"openapi"`
-	Info       map[string]string              `json:"info"`
+	OpenAPI    string                            `json:"openapi"`
+	Info       map[string]string                 `json:"info"`
 	Paths      map[string]map[string]interface{} `json:"paths"`
-	Components map[string]interface{}         `json:"components"`
+	Components map[string]interface{}            `json:"components"`
+	Security   []map[string][]string             `json:"security,omitempty"`
+	Tags       []OpenAPITag                      `json:"tags,omitempty"`
 }
 
 // Global variables with thread-safe access
 var (
-	routes         = make(map[string]RouteInfo)
-	routesMu       sync.RWMutex
-	taskPool       = sync.Pool{New: func() interface{} { return make(chan struct{}, 10) }}
-	taskCtx        context.Context
-	taskCancel     context.CancelFunc
-	validate       = validator.New()
-	middlewares    = []func(http.Handler) http.Handler{}
-	middlewaresMu  sync.RWMutex
-	dependencies   = make(map[string]interface{})
-	depsMu         sync.RWMutex
+	routes        = make(map[string]RouteInfo)
+	routesMu      sync.RWMutex
+	taskCtx       context.Context
+	taskCancel    context.CancelFunc
+	validate      = validator.New()
+	middlewares   []middlewareEntry // in registration order, outermost first
+	middlewaresMu sync.RWMutex
+	chainBase     http.Handler // router the chain wraps; nil until the first server start
+	chain         atomic.Value // http.Handler: the enabled middlewares around chainBase
+	dependencies  = make(map[string]interface{})
+	depsMu        sync.RWMutex
 )
 
-// Middleware registration
-//export RegisterMiddleware
-func RegisterMiddleware(cName uintptr, cEnabled int) {
-	namePtr := (*C.char)(unsafe.Pointer(cName))
-	if namePtr == nil {
-		log.Println("Error: cName is nil in RegisterMiddleware")
+// middlewareFactories builds the built-in middleware from JSON options; nil
+// options select the defaults
+var middlewareFactories = map[string]func(options []byte) (func(http.Handler) http.Handler, error){
+	"logging":       func([]byte) (func(http.Handler) http.Handler, error) { return loggingMiddleware, nil },
+	"cors":          newCORSMiddleware,
+	"ratelimit":     newRateLimitMiddleware,
+	"jwt":           newJWTMiddleware,
+	"apikey":        newAPIKeyMiddleware,
+	"compression":   newCompressionMiddleware,
+	"session":       newSessionMiddleware,
+	"secureheaders": newSecureHeadersMiddleware,
+	"ipfilter":      newIPFilterMiddleware,
+	"timeout":       newTimeoutMiddleware,
+	"concurrency":   newConcurrencyMiddleware,
+}
+
+// middlewareEntry is one registered middleware; disabled entries keep
+// their place in the chain for when they are enabled again
+type middlewareEntry struct {
+	name    string
+	mw      func(http.Handler) http.Handler
+	enabled bool
+}
+
+// addMiddleware enables a built-in middleware, appending it to the chain on
+// first registration and replacing its options when options is non-nil. The
+// chain of a running server is rebuilt. Returns an export status.
+func addMiddleware(name string, options []byte) int {
+	middlewaresMu.Lock()
+	defer middlewaresMu.Unlock()
+	if i := middlewareIndex(name); i >= 0 && options == nil {
+		middlewares[i].enabled = true
+		rebuildChain()
+		slog.Info("Registered middleware", "name", name)
+		return 0
+	}
+	factory, ok := middlewareFactories[name]
+	if !ok {
+		return exportError(exportNotFound, "Unknown middleware", "name", name)
+	}
+	mw, err := factory(options)
+	if err != nil {
+		return exportError(exportInvalid, "Invalid middleware options", "name", name, "error", err)
+	}
+	setMiddleware(name, mw)
+	slog.Info("Registered middleware", "name", name)
+	return 0
+}
+
+// middlewareIndex returns the chain position of a middleware, or -1. Must
+// hold middlewaresMu.
+func middlewareIndex(name string) int {
+	return slices.IndexFunc(middlewares, func(e middlewareEntry) bool { return e.name == name })
+}
+
+// setMiddleware enables mw under name, replacing a middleware of the same
+// name in place or appending it. Must hold middlewaresMu.
+func setMiddleware(name string, mw func(http.Handler) http.Handler) {
+	entry := middlewareEntry{name: name, mw: traceMiddleware(name, mw), enabled: true}
+	if i := middlewareIndex(name); i >= 0 {
+		middlewares[i] = entry
+	} else {
+		middlewares = append(middlewares, entry)
+	}
+	rebuildChain()
+}
+
+// disableMiddleware takes a middleware out of the chain
+func disableMiddleware(name string) {
+	middlewaresMu.Lock()
+	defer middlewaresMu.Unlock()
+	for i := range middlewares {
+		if middlewares[i].name == name {
+			middlewares[i].enabled = false
+		}
+	}
+	rebuildChain()
+}
+
+// rebuildChain wraps chainBase in the enabled middlewares and swaps the
+// result in; requests already in flight finish on the old chain. Must hold
+// middlewaresMu.
+func rebuildChain() {
+	if chainBase == nil {
 		return
 	}
-	name := C.GoString(namePtr)
+	handler := chainBase
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		if middlewares[i].enabled {
+			handler = middlewares[i].mw(handler)
+		}
+	}
+	chain.Store(handler)
+}
+
+// serveChain runs a request through the current middleware chain
+func serveChain(w http.ResponseWriter, r *http.Request) {
+	chain.Load().(http.Handler).ServeHTTP(w, r)
+}
+
+// RegisterMiddleware enables or disables a built-in middleware. It takes
+// effect immediately on a running server; a middleware enabled again keeps
+// its place in the chain and its options.
+//
+//export RegisterMiddleware
+func RegisterMiddleware(cName *C.char, cEnabled int) int {
+	if cName == nil {
+		return exportError(exportInvalid, "cName is nil in RegisterMiddleware")
+	}
+	name := C.GoString(cName)
 
 	enabled := cEnabled != 0
+	auditConfigChange("RegisterMiddleware", map[string]string{"name": name, "enabled": fmt.Sprint(enabled)})
 	if !enabled {
-		log.Printf("Middleware %s is disabled", name)
-		return
+		disableMiddleware(name)
+		slog.Info("Middleware is disabled", "name", name)
+		return 0
 	}
+	return addMiddleware(name, nil)
+}
 
-	middlewaresMu.Lock()
-	defer middlewaresMu.Unlock()
-	switch name {
-	case "logging":
-		middlewares = append(middlewares, loggingMiddleware)
-		log.Printf("Registered middleware: %s", name)
-	default:
-		log.Printf("Unknown middleware: %s", name)
+// RegisterMiddlewareWithOptions enables a built-in middleware configured by
+// a JSON options object, e.g. allowed origins for "cors"
+//
+//export RegisterMiddlewareWithOptions
+func RegisterMiddlewareWithOptions(cName *C.char, cOptions *C.char) int {
+	if cName == nil || cOptions == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in RegisterMiddlewareWithOptions")
 	}
+	name := C.GoString(cName)
+	options := C.GoString(cOptions)
+
+	auditConfigChange("RegisterMiddlewareWithOptions", map[string]string{"name": name, "options": redactJSON(options)})
+	return addMiddleware(name, []byte(options))
 }
 
-// Logging middleware
+// Logging middleware writes one access log entry per request
 func loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		next.ServeHTTP(w, r)
-		log.Printf("%s %s from %s in %v", r.Method, r.URL.Path, r.RemoteAddr, time.Since(start))
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		var requestID string
+		if info := requestInfoFrom(r); info != nil {
+			requestID = info.ID
+		}
+		attrs := []any{
+			"request_id", requestID,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.statusCode(),
+			"bytes", rec.bytes,
+			"latency", time.Since(start),
+			"remote_addr", r.RemoteAddr,
+		}
+		slog.Info("Request served", append(attrs, protocolLogAttrs(r)...)...)
 	})
 }
 
 // Dependency injection context (e.g., for auth or DB)
+//
 //export RegisterDependency
-func RegisterDependency(cName uintptr, cValue uintptr) {
-	namePtr := (*C.char)(unsafe.Pointer(cName))
-	valuePtr := (*C.char)(unsafe.Pointer(cValue))
-	if namePtr == nil || valuePtr == nil {
-		log.Println("Error: One or more parameters are nil in RegisterDependency")
-		return
+func RegisterDependency(cName *C.char, cValue *C.char) int {
+	if cName == nil || cValue == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in RegisterDependency")
 	}
-	name := C.GoString(namePtr)
-	value := C.GoString(valuePtr)
+	name := C.GoString(cName)
+	value := C.GoString(cValue)
 	depsMu.Lock()
 	dependencies[name] = value
 	depsMu.Unlock()
+	// Dependency values are often credentials, so they are never audited
+	auditConfigChange("RegisterDependency", map[string]string{"name": name, "value": redactedValue})
+
+	return 0
 }
 
-// GetDependency retrieves a dependency by name
+// GetDependency retrieves a dependency by name. Singleton providers are
+// resolved on demand; request-scoped ones need ResolveDependency.
 func GetDependency(name string) (interface{}, bool) {
-	depsMu.RLock()
-	defer depsMu.RUnlock()
-	val, exists := dependencies[name]
-	return val, exists
+	val, _, err := resolveDependency(name, "", "")
+	return val, err == nil
 }
 
 //export RegisterRoute
-func RegisterRoute(cPath uintptr, cMethod uintptr, cMessage uintptr, cDesc uintptr) {
-	pathPtr := (*C.char)(unsafe.Pointer(cPath))
-	methodPtr := (*C.char)(unsafe.Pointer(cMethod))
-	messagePtr := (*C.char)(unsafe.Pointer(cMessage))
-	descPtr := (*C.char)(unsafe.Pointer(cDesc))
-
-	if pathPtr == nil || methodPtr == nil || messagePtr == nil || descPtr == nil {
-		log.Println("Error: One or more parameters are nil in RegisterRoute")
-		return
+func RegisterRoute(cPath *C.char, cMethod *C.char, cMessage *C.char, cDesc *C.char) int {
+
+	if cPath == nil || cMethod == nil || cMessage == nil || cDesc == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in RegisterRoute")
 	}
 
-	path := C.GoString(pathPtr)
-	method := strings.ToUpper(C.GoString(methodPtr))
-	message := C.GoString(messagePtr)
-	desc := C.GoString(descPtr)
+	path := C.GoString(cPath)
+	method := strings.ToUpper(C.GoString(cMethod))
+	message := C.GoString(cMessage)
+	desc := C.GoString(cDesc)
 
-	log.Printf("Registering route: %s for method: %s with message: %s", path, method, message)
+	slog.Debug("Registering route", "path", path, "method", method, "message", message)
 
 	routesMu.Lock()
 	key := path + method
@@ -162,34 +346,162 @@ func RegisterRoute(cPath uintptr, cMethod uintptr, cMessage uintptr, cDesc uintp
 		Method:      method,
 		Message:     message,
 		Description: desc,
-		Parameters:  []ParameterInfo{},
+		Parameters:  pathParameters(path),
 		Responses: map[int]string{
 			200: "Successful response",
 		},
 	}
-	log.Printf("Route registered with key: %s", key)
+	routeTree.insert(path, method, key)
+	slog.Info("Route registered", "key", key)
 	routesMu.Unlock()
+	invalidateOpenAPICache()
+	auditConfigChange("RegisterRoute", map[string]string{"path": path, "method": method, "description": desc})
+
+	return 0
 }
 
-// TaskManager handles background tasks with limited concurrency
-func TaskManager(ctx context.Context, taskID string, taskChan chan struct{}) {
-	defer func() { <-taskChan }()
-	select {
-	case taskChan <- struct{}{}:
-		log.Printf("Starting background task %s", taskID)
-		select {
-		case <-time.After(2 * time.Second):
-			log.Printf("Completed background task %s", taskID)
-		case <-ctx.Done():
-			log.Printf("Cancelled background task %s", taskID)
+// RegisterRouteFull registers a static route with a custom success status,
+// content type, and response headers. cHeaders is a JSON object of header
+// names to values and may be empty. A status of 0 means 200 and an empty
+// content type means application/json.
+//
+//export RegisterRouteFull
+func RegisterRouteFull(cPath *C.char, cMethod *C.char, cMessage *C.char, cDesc *C.char, status int, cContentType *C.char, cHeaders *C.char) int {
+
+	if cPath == nil || cMethod == nil || cMessage == nil || cDesc == nil || cContentType == nil || cHeaders == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in RegisterRouteFull")
+	}
+	if status != 0 && (status < 200 || status > 599) {
+		return exportError(exportInvalid, "Invalid route status", "status", status)
+	}
+
+	path := C.GoString(cPath)
+	method := strings.ToUpper(C.GoString(cMethod))
+	message := C.GoString(cMessage)
+	desc := C.GoString(cDesc)
+	contentType := C.GoString(cContentType)
+	var headers map[string]string
+	if raw := C.GoString(cHeaders); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &headers); err != nil {
+			return exportError(exportInvalid, "Invalid route headers", "path", path, "method", method, "error", err)
 		}
-	case <-ctx.Done():
-		log.Printf("Task %s not started due to shutdown", taskID)
 	}
+	canonical := make(map[string]string, len(headers))
+	for name, value := range headers {
+		canonical[http.CanonicalHeaderKey(name)] = value
+	}
+
+	route := RouteInfo{
+		Path:        path,
+		Method:      method,
+		Message:     message,
+		Description: desc,
+		Parameters:  pathParameters(path),
+		Status:      status,
+		ContentType: contentType,
+		Headers:     canonical,
+	}
+	route.Responses = map[int]string{route.successStatus(): "Successful response"}
+
+	slog.Debug("Registering route", "path", path, "method", method, "status", route.successStatus(), "content_type", route.contentType())
+
+	routesMu.Lock()
+	key := path + method
+	routes[key] = route
+	routeTree.insert(path, method, key)
+	slog.Info("Route registered", "key", key)
+	routesMu.Unlock()
+	invalidateOpenAPICache()
+	auditConfigChange("RegisterRouteFull", map[string]string{
+		"path":         path,
+		"method":       method,
+		"description":  desc,
+		"status":       strconv.Itoa(route.successStatus()),
+		"content_type": route.contentType(),
+		"headers":      strings.Join(sortedKeys(canonical), ","),
+	})
+
+	return 0
 }
 
-// ServeOpenAPI generates the OpenAPI JSON
-func ServeOpenAPI(w http.ResponseWriter, r *http.Request) {
+// UnregisterRoute removes a route at runtime. Requests already being served
+// by it complete normally.
+//
+//export UnregisterRoute
+func UnregisterRoute(cPath *C.char, cMethod *C.char) int {
+	if cPath == nil || cMethod == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in UnregisterRoute")
+	}
+	path := C.GoString(cPath)
+	method := strings.ToUpper(C.GoString(cMethod))
+
+	routesMu.Lock()
+	key := path + method
+	if _, exists := routes[key]; !exists {
+		routesMu.Unlock()
+		return exportError(exportNotFound, "Cannot unregister, route not found", "key", key)
+	}
+	delete(routes, key)
+	rebuildRouteTree()
+	routesMu.Unlock()
+
+	slog.Info("Route unregistered", "key", key)
+	invalidateOpenAPICache()
+	auditConfigChange("UnregisterRoute", map[string]string{"path": path, "method": method})
+
+	return 0
+}
+
+// ReplaceRoute swaps an existing route for a static route with a new message
+// and description. Per-route settings such as schemas and trailers are
+// dropped, as if the route had been unregistered and registered again.
+//
+//export ReplaceRoute
+func ReplaceRoute(cPath *C.char, cMethod *C.char, cMessage *C.char, cDesc *C.char) int {
+	if cPath == nil || cMethod == nil || cMessage == nil || cDesc == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in ReplaceRoute")
+	}
+	path := C.GoString(cPath)
+	method := strings.ToUpper(C.GoString(cMethod))
+	message := C.GoString(cMessage)
+	desc := C.GoString(cDesc)
+
+	routesMu.Lock()
+	key := path + method
+	existing, exists := routes[key]
+	if !exists {
+		routesMu.Unlock()
+		return exportError(exportNotFound, "Cannot replace, route not found", "key", key)
+	}
+	// The tree still maps path and method to key, so only the entry changes
+	routes[key] = RouteInfo{
+		Path:        existing.Path,
+		Method:      method,
+		Message:     message,
+		Description: desc,
+		Host:        existing.Host,
+		Parameters:  pathParameters(existing.Path),
+		Responses: map[int]string{
+			200: "Successful response",
+		},
+	}
+	routesMu.Unlock()
+
+	slog.Info("Route replaced", "key", key)
+	invalidateOpenAPICache()
+	auditConfigChange("ReplaceRoute", map[string]string{"path": path, "method": method, "description": desc})
+
+	return 0
+}
+
+// buildOpenAPI generates the OpenAPI document of a host pattern ("" for
+// the default host) localized for lang
+func buildOpenAPI(host, lang string) ([]byte, error) {
+	return currentJSON().marshal(openAPIDocument(host, lang))
+}
+
+// openAPIDocument assembles the document buildOpenAPI encodes
+func openAPIDocument(host, lang string) OpenAPI {
 	openapi := OpenAPI{
 		OpenAPI: "3.0.0",
 		Info: map[string]string{
@@ -199,123 +511,663 @@ func ServeOpenAPI(w http.ResponseWriter, r *http.Request) {
 		Paths:      make(map[string]map[string]interface{}),
 		Components: make(map[string]interface{}),
 	}
+	if info, ok := localizedInfoFor(lang); ok {
+		openapi.Info["title"] = info.Title
+		if info.Description != "" {
+			openapi.Info["description"] = info.Description
+		}
+	}
+	if host != "" {
+		openapi.Info["title"] = host
+		if vh := virtualHostFor(host); vh != nil {
+			if vh.opts.Title != "" {
+				openapi.Info["title"] = vh.opts.Title
+			}
+			if vh.opts.Version != "" {
+				openapi.Info["version"] = vh.opts.Version
+			}
+			if vh.opts.Description != "" {
+				openapi.Info["description"] = vh.opts.Description
+			} else {
+				delete(openapi.Info, "description")
+			}
+		}
+	}
+
+	if schemes, security := openAPISecurity(); schemes != nil {
+		openapi.Components["securitySchemes"] = schemes
+		openapi.Security = security
+	}
+
+	schemas := openAPIComponentSchemas()
+	openapi.Components["schemas"] = schemas
 
+	usedTags := make(map[string]bool)
 	routesMu.RLock()
 	for _, route := range routes {
-		if _, exists := openapi.Paths[route.Path]; !exists {
-			openapi.Paths[route.Path] = make(map[string]interface{})
+		if route.Host != host {
+			continue
+		}
+		path := openAPIPath(route.Path)
+		if _, exists := openapi.Paths[path]; !exists {
+			openapi.Paths[path] = make(map[string]interface{})
+		}
+		responses := make(map[string]interface{}, len(route.Responses))
+		for code, desc := range route.Responses {
+			response := map[string]interface{}{"description": desc}
+			static := route.Handler == 0 && route.Gateway == nil && route.WebSocket == 0 && route.SSE == 0 && route.Template == "" && code == route.successStatus() && code != http.StatusNoContent
+			if schema := openAPIResponseSchema(route, code); schema != nil {
+				mediaType := "application/json"
+				if static {
+					mediaType = route.contentType()
+				} else if _, modelled := route.ResponseModels[code]; code >= 400 && !modelled {
+					mediaType = errorContentType(code)
+				}
+				response["content"] = map[string]interface{}{mediaType: map[string]interface{}{"schema": schema}}
+			} else if route.Template != "" && code == route.successStatus() {
+				response["content"] = map[string]interface{}{
+					"text/html":        map[string]interface{}{"schema": map[string]string{"type": "string"}},
+					"application/json": map[string]interface{}{"schema": map[string]string{"type": "object"}},
+				}
+			} else if static {
+				response["content"] = map[string]interface{}{route.contentType(): map[string]interface{}{
+					"schema": map[string]interface{}{"type": "string", "example": route.Message},
+				}}
+			}
+			if route.Redirect != nil && code == route.Redirect.status {
+				redirectResponse(response, route.Redirect)
+			}
+			if static && len(route.Headers) > 0 {
+				headers := make(map[string]interface{}, len(route.Headers))
+				for name, value := range route.Headers {
+					headers[name] = map[string]interface{}{
+						"schema": map[string]string{"type": "string", "example": value},
+					}
+				}
+				response["headers"] = headers
+			}
+			responses[strconv.Itoa(code)] = response
 		}
-		openapi.Paths[route.Path][strings.ToLower(route.Method)] = map[string]interface{}{
-			"summary":     route.Description,
-			"responses":   map[string]interface{}{"200": map[string]string{"description": route.Responses[200]}},
+		operation := map[string]interface{}{
+			"operationId": operationID(route),
+			"summary":     localizedDescription(route, lang),
+			"responses":   responses,
 			"parameters":  route.Parameters,
 		}
+		if len(route.Tags) > 0 {
+			operation["tags"] = route.Tags
+			for _, tag := range route.Tags {
+				usedTags[tag] = true
+			}
+		}
+		if len(route.RequiredScopes) > 0 {
+			operation["x-required-scopes"] = route.RequiredScopes
+		}
+		if route.Deprecation != nil {
+			deprecationOperation(operation, route.Deprecation)
+		}
+		if body := openAPIRequestBody(route, schemas); body != nil {
+			operation["requestBody"] = body
+		}
+		openapi.Paths[path][strings.ToLower(route.Method)] = operation
 	}
 	routesMu.RUnlock()
+	openapi.Tags = openAPITags(usedTags)
+
+	return openapi
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(openapi); err != nil {
+// ServeOpenAPI serves the OpenAPI JSON of the requested host, localized by
+// Accept-Language. The encoded spec is cached until the routes change, and
+// clients revalidating with its ETag get 304.
+func ServeOpenAPI(w http.ResponseWriter, r *http.Request) {
+	lang := negotiateLanguage(r.Header.Get("Accept-Language"))
+	entry, err := cachedOpenAPI(virtualHostPattern(r.Host), lang, buildOpenAPI)
+	if err != nil {
+		slog.Error("Error generating OpenAPI", "error", err)
 		http.Error(w, `{"error": "Failed to generate OpenAPI"}`, http.StatusInternalServerError)
+		return
 	}
+	writeOpenAPIEntry(w, r, entry, lang)
 }
 
-//export StartServer
-func StartServer() {
-	taskCtx, taskCancel = context.WithCancel(context.Background())
-	defer taskCancel()
+// writeOpenAPIEntry sends a cached document, or 304 when the client's copy
+// is current
+func writeOpenAPIEntry(w http.ResponseWriter, r *http.Request, entry openAPIEntry, lang string) {
+	h := w.Header()
+	h.Set("Vary", "Accept-Language")
+	h.Set("ETag", entry.etag)
+	h.Set("Cache-Control", "no-cache")
+	if lang != "" {
+		h.Set("Content-Language", lang)
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, entry.etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	h.Set("Content-Type", "application/json")
+	w.Write(entry.data)
+}
 
-	server := &http.Server{
-		Addr:         ":8080",
-		Handler:      nil,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		IdleTimeout:  15 * time.Second,
+// WriteOpenAPIFile writes the OpenAPI document to cPath, indented, as
+// /openapi.json would serve it, for generating clients or publishing the
+// spec at build time. It does not need the server to be running. cHost
+// selects a virtual host's document, as registered with RegisterVirtualHost,
+// and cLang a language configured with ConfigureOpenAPIInfoLocalized; both
+// may be empty for the defaults. Returns 0, or a negative status on error.
+//
+//export WriteOpenAPIFile
+func WriteOpenAPIFile(cPath *C.char, cHost *C.char, cLang *C.char) int {
+	if cPath == nil || cHost == nil || cLang == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in WriteOpenAPIFile")
+	}
+	path := C.GoString(cPath)
+	host := strings.ToLower(C.GoString(cHost))
+	lang := normalizeLanguage(C.GoString(cLang))
+	if host != "" {
+		virtualHostsMu.RLock()
+		_, ok := virtualHosts[host]
+		virtualHostsMu.RUnlock()
+		if !ok {
+			return exportError(exportNotFound, "Unknown virtual host", "export", "WriteOpenAPIFile", "host", host)
+		}
+	}
+	entry, err := cachedOpenAPI(host, lang, buildOpenAPI)
+	if err != nil {
+		return exportError(exportFailed, "Error generating OpenAPI", "error", err)
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, entry.data, "", "  "); err != nil {
+		return exportError(exportFailed, "Error generating OpenAPI", "error", err)
 	}
+	out.WriteByte('\n')
+	if err := os.WriteFile(path, out.Bytes(), 0o644); err != nil {
+		return exportError(exportFailed, "Cannot write OpenAPI file", "path", path, "error", err)
+	}
+	slog.Info("OpenAPI document written", "path", path, "host", host, "lang", lang, "etag", entry.etag)
+	return 0
+}
 
-	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+// dispatchRoute serves registered routes, either from a host callback or
+// the route's static message
+func dispatchRoute(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Path + r.Method
+	routesMu.RLock()
+	route, params, allowed, exists := findRoute(r.Host, r.URL.Path, r.Method)
+	routesMu.RUnlock()
+	if !exists {
+		if len(allowed) > 0 && r.Method == http.MethodOptions {
+			// Answer OPTIONS from the routing table unless a route handles it
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if len(allowed) > 0 {
+			// The path exists under a different method
+			allow := strings.Join(allowed, ", ")
+			slog.Debug("Method not allowed", "key", key, "allow", allow)
+			w.Header().Set("Allow", allow)
+			http.Error(w, fmt.Sprintf(`{"error": "Method %s not allowed for %s - Try using method %s"}`, r.Method, r.URL.Path, allow), http.StatusMethodNotAllowed)
+			return
+		}
+		if !limitRequestBody(w, r, 0) {
+			return
+		}
+		if serveProxy(w, r) || serveStatic(w, r) {
+			return
+		}
+		slog.Debug("Route not found", "key", key, "path", r.URL.Path, "method", r.Method)
+		http.Error(w, fmt.Sprintf(`{"error": "Route not found for %s %s"}`, r.Method, r.URL.Path), http.StatusNotFound)
+		return
+	}
+	if prefix, ok := catchAllPrefix(route.Path); ok {
+		// Static directories and proxies under the catch-all take precedence
+		if mount, ok := mountPrefixFor(r.URL.Path); ok && len(mount) > len(prefix) {
+			if !limitRequestBody(w, r, 0) {
+				return
+			}
+			if serveProxy(w, r) || serveStatic(w, r) {
+				return
+			}
+		}
+	}
+	slog.Debug("Route found, serving response", "key", key)
+	if info := requestInfoFrom(r); info != nil {
+		info.Route = route.Path
+	}
+	if route.Deprecation != nil {
+		serveDeprecation(w, route)
+	}
+	serveGrouped(w, r, route, func(w http.ResponseWriter, r *http.Request) {
+		serveRoute(w, r, route, params, key)
+	})
+}
+
+// serveRoute serves a matched route once any group middleware has run
+func serveRoute(w http.ResponseWriter, r *http.Request, route RouteInfo, params map[string]string, key string) {
+	if !limitRequestBody(w, r, route.MaxBodySize) {
+		return
+	}
+	ctx, sp := startSpan(r.Context(), "dispatch "+route.Path, spanKindInternal)
+	defer sp.end()
+	r = r.WithContext(ctx)
+	r = withPathParams(r, params)
+	queryValues, queryErrs := parseQueryParams(r, route)
+	if len(queryErrs) > 0 {
+		writeValidationErrors(w, r, queryErrs)
+		return
+	}
+	r = withQueryParams(r, queryValues)
+	if len(route.FormFields) > 0 {
+		formValues, ok := parseFormFields(w, r, route)
+		if !ok {
+			return
+		}
+		r = withFormParams(r, formValues)
+	}
+	if route.BodySchema != nil && !validateRequestBody(w, r, route.BodySchema) {
+		return
+	}
+	if route.Cache != nil && r.Method == http.MethodGet {
+		serveCached(w, r, route, func(w http.ResponseWriter) {
+			serveWithHandlerHooks(w, r, func(w http.ResponseWriter) { serveRouteResponse(w, r, route, key) })
+		})
+		return
+	}
+	serveWithHandlerHooks(w, r, func(w http.ResponseWriter) { serveRouteResponse(w, r, route, key) })
+}
+
+// submitRouteTask starts the background task of a static route. On
+// failure it has already answered the request.
+func submitRouteTask(w http.ResponseWriter, r *http.Request, route RouteInfo, key string) (string, bool) {
+	mode := route.TaskBackpressure
+	if mode == "" {
+		mode = BackpressureQueue
+	}
+	var taskID string
+	var err error
+	if route.Task != "" {
+		payload, ok := readTaskPayload(w, r)
+		if !ok {
+			return "", false
+		}
+		taskID, err = submitNamedTask(r.Context(), mode, route.Task, payload)
+	} else {
+		taskID, err = submitTask(r.Context(), mode, sleepTask)
+	}
+	if errors.Is(err, errTaskNotRegistered) {
+		slog.Error("Route task is not registered", "key", key, "task", route.Task)
+		http.Error(w, `{"error": "Internal server error"}`, http.StatusInternalServerError)
+		return "", false
+	}
+	if err != nil {
+		slog.Warn("Background task not queued", "key", key, "error", err)
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, `{"error": "Task queue is full, please retry"}`, http.StatusServiceUnavailable)
+		return "", false
+	}
+	return taskID, true
+}
+
+// serveRouteResponse runs the route's handler, template, stream, or static
+// response once the request has been validated
+func serveRouteResponse(w http.ResponseWriter, r *http.Request, route RouteInfo, key string) {
+	if route.Redirect != nil {
+		serveRedirect(w, r, route)
+		return
+	}
+	if route.WebSocket != 0 {
+		serveWebSocket(w, r, route)
+		return
+	}
+	if route.SSE != 0 {
+		serveSSE(w, r, route)
+		return
+	}
+	if route.Template != "" {
+		serveTemplateRoute(w, r, route)
+		return
+	}
+	if route.Handler != 0 {
+		serveHandlerRoute(w, r, route)
+		return
+	}
+	if route.Gateway != nil {
+		serveGateway(w, r, route)
+		return
+	}
+	// Start background task before responding so backpressure can reject.
+	// HEAD gets the same headers without starting one, so probes cost no
+	// worker.
+	var taskID string
+	if r.Method != http.MethodHead {
+		var ok bool
+		if taskID, ok = submitRouteTask(w, r, route, key); !ok {
+			return
+		}
+	}
+	for name, value := range route.Headers {
+		w.Header().Set(name, value)
+	}
+	contentType := route.contentType()
+	w.Header().Set("Content-Type", contentType)
+	applyRouteCompression(w, route)
+	trailers := trailerNames(route.Trailers)
+	declareTrailers(w, trailers)
+
+	// JSON routes keep the ApiResponse envelope, written from a pooled
+	// buffer; other content types send the message as-is
+	var body []byte
+	if strings.Contains(contentType, "json") {
+		buf := getJSONBuffer()
+		defer putJSONBuffer(buf)
+		appendStaticResponse(buf, route, taskID)
+		body = buf.Bytes()
+	} else {
+		body = []byte(route.Message)
+	}
+	status := route.successStatus()
+	w.WriteHeader(status)
+	if status != http.StatusNoContent && status != http.StatusNotModified {
+		if _, err := w.Write(body); err != nil {
+			slog.Error("Error writing response", "error", err)
+			return
+		}
+	}
+	setTrailers(w, trailers, route.Trailers)
+}
+
+// serverState tracks the running server so it can be stopped from the host
+type serverState struct {
+	server   *http.Server
+	redirect *http.Server  // optional HTTP-to-HTTPS redirect listener
+	h3       *http3.Server // optional QUIC listener sharing the handler chain
+	h3Conn   net.PacketConn
+	grpc     *http.Server   // optional gRPC listener, see ConfigureGRPC
+	sockets  []reloadSocket // bound sockets, handed on by ReloadServer
+	done     chan struct{}  // closed once the server has shut down
+}
 
+var (
+	current   *serverState
+	currentMu sync.Mutex
+)
+
+// buildHandler assembles the router and middleware chain
+func buildHandler() http.Handler {
 	// Create a router with middleware support
 	mux := http.NewServeMux()
-	middlewaresMu.RLock()
-	handler := http.Handler(mux)
-	for i := len(middlewares) - 1; i >= 0; i-- {
-		handler = middlewares[i](handler)
-	}
-	middlewaresMu.RUnlock()
+	middlewaresMu.Lock()
+	chainBase = recordMuxPattern(mux)
+	rebuildChain()
+	middlewaresMu.Unlock()
+	handler := http.HandlerFunc(serveChain)
 
 	// Register OpenAPI and Swagger UI endpoints
 	mux.HandleFunc("/openapi.json", ServeOpenAPI)
-	mux.HandleFunc("/swagger/", http.StripPrefix("/swagger/", http.FileServer(http.Dir("swagger-ui"))).ServeHTTP)
-
-	
-	// Dynamic route handling with method support
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		key := r.URL.Path + r.Method
-		routesMu.RLock()
-		route, exists := routes[key]
-		routesMu.RUnlock()
-		if !exists {
-			// Check if the path exists with a different method
-			var supportedMethod string
-			routesMu.RLock()
-			for _, rt := range routes {
-				if rt.Path == r.URL.Path {
-					supportedMethod = rt.Method
-					break
-				}
-			}
-			routesMu.RUnlock()
-			errorMsg := fmt.Sprintf("Route not found for %s %s", r.Method, r.URL.Path)
-			if supportedMethod != "" {
-				errorMsg = fmt.Sprintf("%s - Try using method %s", errorMsg, supportedMethod)
+	mux.HandleFunc("GET /openapi/{file}", ServeVersionedOpenAPI)
+	mux.Handle("/swagger/", swaggerHandler())
+	mux.HandleFunc("/redoc", ServeReDoc)
+
+	// Prometheus scrape endpoint, served when EnableMetrics is on
+	mux.HandleFunc("/metrics", ServeMetrics)
+
+	// Liveness and readiness probes
+	mux.HandleFunc("/healthz", ServeHealthz)
+	mux.HandleFunc("/readyz", ServeReadyz)
+
+	// Background task status endpoints
+	mux.HandleFunc("GET /tasks", ServeTaskList)
+	mux.HandleFunc("GET /tasks/schedules", ServeTaskSchedules)
+	mux.HandleFunc("GET /tasks/dead", ServeDeadTasks)
+	mux.HandleFunc("GET /tasks/{id}", ServeTaskStatus)
+
+	// Dynamic route handling with method support, behind the admission queue
+	dispatch := admissionMiddleware(virtualHostMiddleware(http.HandlerFunc(dispatchRoute)))
+	mux.Handle("/", dispatch)
+
+	// GraphQL endpoint and IDE, served once a schema is registered
+	graphql := graphqlHandler(dispatch)
+	mux.Handle("/graphql", graphql)
+	mux.Handle("/graphiql", graphql)
+
+	// JSON-RPC endpoint, served once a method is registered
+	mux.Handle("/rpc", rpcHandler(dispatch))
+
+	// Webhook delivery history, served once a webhook is registered
+	mux.Handle("GET /webhooks", webhooksHandler(dispatch))
+
+	// Profiling and routing table, served when EnableDebugEndpoints is on
+	mux.Handle("/debug/", debugHandler(dispatch))
+
+	return realIPMiddleware(activeRequestsMiddleware(requestInfoMiddleware(hookMiddleware(tracingMiddleware(metricsMiddleware(connectionAgeMiddleware(errorPageMiddleware(recoveryMiddleware(headerLimitMiddleware(versionMiddleware(normalizePathMiddleware(handler))))))))))))
+}
+
+// startServer binds the listener and serves in the background. It returns
+// once the listener is accepting connections.
+func startServer() (*serverState, error) {
+	currentMu.Lock()
+	defer currentMu.Unlock()
+	if current != nil {
+		return nil, fmt.Errorf("server is already running")
+	}
+
+	runLifecycleHooks("startup")
+	if err := runStartupHandlers(); err != nil {
+		return nil, err
+	}
+
+	cfg := effectiveServerConfig()
+	applyRequestLimits(cfg)
+	server := &http.Server{
+		Addr:              cfg.Addr(),
+		Handler:           buildHandler(),
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    serverHeaderCap(cfg),
+		ConnState:         connStateHook,
+		ConnContext:       connContext,
+	}
+
+	tlsCfg := currentTLSSettings()
+	tlsConfig, err := buildTLSConfig(tlsCfg)
+	if err != nil {
+		return nil, err
+	}
+	configureProtocols(server, tlsConfig, currentHTTP2Options())
+
+	state := &serverState{server: server, done: make(chan struct{})}
+	h3Opts := currentHTTP3Options()
+	var udpConn net.PacketConn
+	if h3Opts.Enabled {
+		if state.h3, udpConn, err = listenHTTP3(cfg, server.Handler, tlsConfig, h3Opts); err != nil {
+			return nil, err
+		}
+		state.h3Conn = udpConn
+		state.sockets = socketFor("udp:"+state.h3.Addr, udpConn)
+		server.Handler = altSvcMiddleware(state.h3.Port, server.Handler)
+	}
+
+	closeAll := func(listeners []net.Listener) {
+		for _, l := range listeners {
+			l.Close()
+		}
+		if udpConn != nil {
+			udpConn.Close()
+		}
+	}
+
+	// HTTP/3-only servers never bind the stream listeners
+	var listeners []net.Listener
+	if !h3Opts.Only {
+		specs, err := cfg.listenerSpecs()
+		var sockets []reloadSocket
+		if err == nil {
+			listeners, sockets, err = openListeners(specs)
+		}
+		if err != nil {
+			closeAll(nil)
+			return nil, err
+		}
+		for i, l := range listeners {
+			listeners[i] = &trackingListener{Listener: l}
+			if tlsConfig != nil {
+				listeners[i] = tls.NewListener(listeners[i], tlsConfig)
 			}
-			log.Printf("Route not found for key: %s (Path: %s, Method: %s)", key, r.URL.Path, r.Method)
-			http.Error(w, fmt.Sprintf(`{"error": "%s"}`, errorMsg), http.StatusNotFound)
-			return
 		}
-		log.Printf("Route found for key: %s, serving response", key)
-		taskID := fmt.Sprintf("task-%d", time.Now().UnixNano())
-		response := ApiResponse{
-			Message: route.Message,
-			BackgroundTask: TaskResponse{
-				Message: fmt.Sprintf("Task started in background: %s", taskID),
-				TaskID:  taskID,
-			},
-		}
-		w.Header().Set("Content-Type", "application/json")
-		if err := json.NewEncoder(w).Encode(response); err != nil {
-			log.Printf("Error encoding response: %v", err)
-			http.Error(w, `{"error": "Internal server error"}`, http.StatusInternalServerError)
-			return
+		server.TLSConfig = tlsConfig
+		state.sockets = append(state.sockets, sockets...)
+	}
+
+	if tlsConfig != nil && tlsCfg.RedirectHTTP {
+		var redirectListener net.Listener
+		if state.redirect, redirectListener, err = startRedirectServer(cfg, tlsCfg); err != nil {
+			closeAll(listeners)
+			return nil, err
 		}
-		// Start background task
-		taskChan := taskPool.Get().(chan struct{})
-		go TaskManager(taskCtx, taskID, taskChan)
-	})
+		state.sockets = append(state.sockets, socketFor("redirect:"+state.redirect.Addr, redirectListener)...)
+	}
 
-	// Set the server handler
-	server.Handler = handler
+	if grpcOpts := currentGRPCOptions(); grpcOpts.Port > 0 {
+		var grpcListener net.Listener
+		if state.grpc, grpcListener, err = startGRPCServer(cfg, tlsConfig, grpcOpts); err != nil {
+			closeAll(listeners)
+			if state.redirect != nil {
+				state.redirect.Close()
+			}
+			return nil, err
+		}
+		state.sockets = append(state.sockets, socketFor("grpc:"+state.grpc.Addr, grpcListener)...)
+	}
+
+	taskCtx, taskCancel = context.WithCancel(context.Background())
+	startTaskPool(taskCtx)
+	startScheduler(taskCtx)
+	warmOpenAPICache()
+	draining.Store(false)
+	current = state
+	signalReloadReady()
+
+	url := cfg.URL(tlsConfig != nil)
+	if state.h3 != nil {
+		go func() {
+			slog.Info("HTTP/3 listener running", "addr", udpConn.LocalAddr().String())
+			if err := state.h3.Serve(udpConn); err != nil && err != http.ErrServerClosed {
+				slog.Error("HTTP/3 server error", "error", err)
+			}
+		}()
+	}
+	if len(listeners) == 0 {
+		slog.Info("API docs available over HTTP/3", "url", url+"/swagger/")
+		return state, nil
+	}
+	slog.Info("Go server running", "url", url)
+	slog.Info("API docs available", "url", url+"/swagger/")
+	for _, l := range listeners {
+		go func() {
+			slog.Info("Listening", "network", l.Addr().Network(), "addr", l.Addr().String())
+			if err := server.Serve(l); err != nil && err != http.ErrServerClosed {
+				slog.Error("Server error", "error", err)
+				os.Exit(1)
+			}
+		}()
+	}
+	return state, nil
+}
 
-	go func() {
-		log.Printf("Go server running on http://localhost:8080")
-		log.Printf("API docs available at http://localhost:8080/swagger/")
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server error: %v", err)
+// stopServer gracefully shuts down the running server, if any
+func stopServer() {
+	currentMu.Lock()
+	state := current
+	current = nil
+	currentMu.Unlock()
+	if state == nil {
+		return
+	}
+
+	slog.Info("Shutting down server...")
+	draining.Store(true)
+	// Streams never finish on their own, so they are ended before draining
+	closeSSEStreams()
+	closeWebSockets()
+	drainServer(state)
+	if state.redirect != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := state.redirect.Shutdown(ctx); err != nil {
+			slog.Error("Redirect server shutdown error", "error", err)
 		}
-	}()
+	}
+	runShutdownHandlers()
+	runLifecycleHooks("shutdown")
+	runHooks(hookEvent{Phase: HookOnShutdown})
+	resetSingletons()
+	flushCtx, flushCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	flushTraces(flushCtx)
+	flushCancel()
+	close(state.done)
+	slog.Info("Server stopped")
+}
 
-	<-stop
-	log.Println("Shutting down server...")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	taskCancel()
-	if err := server.Shutdown(ctx); err != nil {
-		log.Printf("Server shutdown error: %v", err)
+// StartServer runs the server and blocks until SIGINT/SIGTERM or StopServer
+//
+//export StartServer
+func StartServer() int {
+	state, err := startServer()
+	if err != nil {
+		return exportError(exportFailed, "Server error", "error", err)
 	}
-	log.Println("Server stopped")
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	select {
+	case <-stop:
+		stopServer()
+	case <-state.done:
+	}
+
+	return 0
+}
+
+// StartServerAsync starts the server and returns once the listener is up
+//
+//export StartServerAsync
+func StartServerAsync() int {
+	if _, err := startServer(); err != nil {
+		return exportError(exportFailed, "Server error", "error", err)
+	}
+
+	return 0
+}
+
+// StopServer gracefully shuts down the server started by StartServer or
+// StartServerAsync
+//
+//export StopServer
+func StopServer() int {
+	stopServer()
+
+	return 0
+}
+
+// RestartServer shuts the server down and binds it again, picking up
+// middleware registered since the last start
+//
+//export RestartServer
+func RestartServer() int {
+	stopServer()
+	if _, err := startServer(); err != nil {
+		return exportError(exportFailed, "Server error", "error", err)
+	}
+	slog.Info("Server restarted")
+
+	return 0
 }
 
 func main() {
 	// Required for shared library
-}
\ No newline at end of file
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"C"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaultLifespanTimeout bounds a lifespan handler registered without a
+// timeout of its own
+const defaultLifespanTimeout = 30 * time.Second
+
+// lifespanHandler is a host callback run when the server starts or stops,
+// like the lifespan events of ASGI frameworks
+type lifespanHandler struct {
+	fn      uintptr
+	timeout time.Duration
+}
+
+// lifespanEvent is the JSON passed to lifespan handlers
+type lifespanEvent struct {
+	Phase     string `json:"phase"`
+	Index     int    `json:"index"` // position in registration order
+	TimeoutMs int64  `json:"timeout_ms"`
+}
+
+var (
+	startupHandlers  []lifespanHandler
+	shutdownHandlers []lifespanHandler
+	lifespanMu       sync.Mutex
+)
+
+// runLifespanHandler calls h and waits for it up to its timeout. A handler
+// that overruns keeps running on its host thread; only the wait stops.
+func runLifespanHandler(phase string, index int, h lifespanHandler) error {
+	data, _ := json.Marshal(lifespanEvent{Phase: phase, Index: index, TimeoutMs: h.timeout.Milliseconds()})
+	done := make(chan bool, 1)
+	go func() { done <- callLifespanHandler(h.fn, data) }()
+	timer := time.NewTimer(h.timeout)
+	defer timer.Stop()
+	select {
+	case ok := <-done:
+		if !ok {
+			return fmt.Errorf("%s handler %d failed", phase, index)
+		}
+		return nil
+	case <-timer.C:
+		return fmt.Errorf("%s handler %d did not finish within %s", phase, index, h.timeout)
+	}
+}
+
+// runStartupHandlers runs the startup handlers one after another in
+// registration order and stops at the first that fails or times out, so
+// the server does not accept traffic half set up
+func runStartupHandlers() error {
+	lifespanMu.Lock()
+	handlers := append([]lifespanHandler(nil), startupHandlers...)
+	lifespanMu.Unlock()
+	for i, h := range handlers {
+		slog.Debug("Running startup handler", "index", i)
+		if err := runLifespanHandler("startup", i, h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runShutdownHandlers runs the shutdown handlers in reverse registration
+// order, so teardown mirrors setup. Every handler runs even if an earlier
+// one fails or times out.
+func runShutdownHandlers() {
+	lifespanMu.Lock()
+	handlers := append([]lifespanHandler(nil), shutdownHandlers...)
+	lifespanMu.Unlock()
+	for i := len(handlers) - 1; i >= 0; i-- {
+		slog.Debug("Running shutdown handler", "index", i)
+		if err := runLifespanHandler("shutdown", i, handlers[i]); err != nil {
+			slog.Error("Shutdown handler error", "index", i, "error", err)
+		}
+	}
+}
+
+// addLifespanHandler registers a handler for RegisterStartupHandler or
+// RegisterShutdownHandler
+func addLifespanHandler(export string, list *[]lifespanHandler, cHandler uintptr, timeoutMs int) int {
+	if cHandler == 0 {
+		return exportError(exportInvalid, "cHandler is nil in "+export)
+	}
+	if timeoutMs < 0 {
+		return exportError(exportInvalid, "Invalid lifespan handler timeout", "export", export, "timeout_ms", timeoutMs)
+	}
+	timeout := time.Duration(timeoutMs) * time.Millisecond
+	if timeout == 0 {
+		timeout = defaultLifespanTimeout
+	}
+	lifespanMu.Lock()
+	*list = append(*list, lifespanHandler{fn: cHandler, timeout: timeout})
+	index := len(*list) - 1
+	lifespanMu.Unlock()
+
+	slog.Info("Lifespan handler registered", "export", export, "index", index, "timeout", timeout)
+	auditConfigChange(export, map[string]string{"index": strconv.Itoa(index), "timeout_ms": strconv.FormatInt(timeout.Milliseconds(), 10)})
+	return 0
+}
+
+// RegisterStartupHandler adds a host callback that StartServer runs before
+// the listeners accept traffic, after the "startup" lifecycle hooks.
+// Handlers run one at a time in registration order; one that returns
+// nonzero, or runs past timeoutMs (0 for 30 seconds), aborts the start.
+// The callback receives {"phase", "index", "timeout_ms"} and must not
+// start or stop the server itself.
+//
+//export RegisterStartupHandler
+func RegisterStartupHandler(cHandler uintptr, timeoutMs int) int {
+	return addLifespanHandler("RegisterStartupHandler", &startupHandlers, cHandler, timeoutMs)
+}
+
+// RegisterShutdownHandler adds a host callback run during graceful
+// shutdown, once in-flight requests and tasks have drained and before the
+// "shutdown" lifecycle hooks. Handlers run in reverse registration order,
+// each waited on for at most timeoutMs (0 for 30 seconds); a failure or
+// timeout is logged and the remaining handlers still run.
+//
+//export RegisterShutdownHandler
+func RegisterShutdownHandler(cHandler uintptr, timeoutMs int) int {
+	return addLifespanHandler("RegisterShutdownHandler", &shutdownHandlers, cHandler, timeoutMs)
+}
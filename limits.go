@@ -0,0 +1,158 @@
+package main
+
+import (
+	"C"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Limits of the running server, copied from its config at start
+var (
+	maxBodySize    atomic.Int64 // 0 means unlimited
+	maxHeaderBytes atomic.Int64 // 0 leaves the check to net/http
+)
+
+func applyRequestLimits(cfg ServerConfig) {
+	maxBodySize.Store(cfg.MaxRequestBodySize)
+	maxHeaderBytes.Store(int64(cfg.MaxHeaderBytes))
+}
+
+// serverHeaderCap is the hard header limit given to net/http. It sits above
+// the configured limit so most oversized requests reach
+// headerLimitMiddleware and get a JSON 431 instead of net/http's plain one.
+func serverHeaderCap(cfg ServerConfig) int {
+	if cfg.MaxHeaderBytes <= 0 {
+		return 0
+	}
+	return 2 * cfg.MaxHeaderBytes
+}
+
+// requestHeaderSize approximates the size of the request line and headers
+// as they were sent
+func requestHeaderSize(r *http.Request) int {
+	size := len(r.Method) + len(r.RequestURI) + len(r.Proto) + 4
+	for name, values := range r.Header {
+		for _, value := range values {
+			size += len(name) + len(value) + 4
+		}
+	}
+	return size + len(r.Host) + 8
+}
+
+// headerLimitMiddleware rejects requests whose headers exceed
+// MaxHeaderBytes
+func headerLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if limit := maxHeaderBytes.Load(); limit > 0 && int64(requestHeaderSize(r)) > limit {
+			slog.Warn("Request headers too large", "path", r.URL.Path, "limit", limit)
+			w.Header().Set("Connection", "close")
+			http.Error(w, `{"error": "Request header fields too large"}`, http.StatusRequestHeaderFieldsTooLarge)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitRequestBody caps r's body at the route's limit, or the global one
+// when the route has none. A declared length over the limit is answered
+// with 413 right away; chunked bodies fail when the handler reads past it.
+func limitRequestBody(w http.ResponseWriter, r *http.Request, routeLimit int64) bool {
+	limit := routeLimit
+	if limit == 0 {
+		limit = maxBodySize.Load()
+	}
+	if limit <= 0 || r.Body == nil || r.Body == http.NoBody {
+		return true
+	}
+	if r.ContentLength > limit {
+		slog.Debug("Request body too large", "path", r.URL.Path, "length", r.ContentLength, "limit", limit)
+		w.Header().Set("Connection", "close")
+		http.Error(w, fmt.Sprintf(`{"error": "Request body too large - limit is %d bytes"}`, limit), http.StatusRequestEntityTooLarge)
+		return false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	return true
+}
+
+// writeBodyReadError answers a failed request body read, with 413 when the
+// body went over its limit
+func writeBodyReadError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, fmt.Sprintf(`{"error": "Request body too large - limit is %d bytes"}`, tooLarge.Limit), http.StatusRequestEntityTooLarge)
+		return
+	}
+	slog.Error("Error reading request body", "error", err)
+	http.Error(w, `{"error": "Failed to read request body"}`, http.StatusBadRequest)
+}
+
+// SetRequestLimits sets the default request body limit in bytes, the
+// request header limit in bytes, and how long clients get to send their
+// headers in seconds, for the next server start. 0 leaves a value to the
+// GOSERVER_MAX_BODY_SIZE, GOSERVER_MAX_HEADER_BYTES, and
+// GOSERVER_READ_HEADER_TIMEOUT environment variables, then to the defaults:
+// no body limit, net/http's 1 MB header limit, and the read timeout.
+//
+//export SetRequestLimits
+func SetRequestLimits(maxBody int64, maxHeader int, readHeaderTimeout int) int {
+	if maxBody < 0 || maxHeader < 0 || readHeaderTimeout < 0 {
+		return exportError(exportInvalid, "Invalid request limits", "max_body_size", maxBody, "max_header_bytes", maxHeader, "read_header_timeout", readHeaderTimeout)
+	}
+	hostConfigMu.Lock()
+	hostConfig.MaxRequestBodySize = maxBody
+	hostConfig.MaxHeaderBytes = maxHeader
+	hostConfig.ReadHeaderTimeout = time.Duration(readHeaderTimeout) * time.Second
+	hostConfigMu.Unlock()
+
+	slog.Info("Request limits set", "max_body_size", maxBody, "max_header_bytes", maxHeader, "read_header_timeout", readHeaderTimeout)
+	auditConfigChange("SetRequestLimits", map[string]string{
+		"max_body_size":       strconv.FormatInt(maxBody, 10),
+		"max_header_bytes":    strconv.Itoa(maxHeader),
+		"read_header_timeout": strconv.Itoa(readHeaderTimeout),
+	})
+
+	return 0
+}
+
+// SetRouteBodyLimit overrides the request body limit for one route, above
+// or below the default. 0 restores the default and -1 removes the limit.
+//
+//export SetRouteBodyLimit
+func SetRouteBodyLimit(cPath *C.char, cMethod *C.char, maxBytes int64) int {
+	if cPath == nil || cMethod == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in SetRouteBodyLimit")
+	}
+	if maxBytes < -1 {
+		return exportError(exportInvalid, "Invalid route body limit", "max_bytes", maxBytes)
+	}
+	path := C.GoString(cPath)
+	method := strings.ToUpper(C.GoString(cMethod))
+
+	routesMu.Lock()
+	key := path + method
+	route, exists := routes[key]
+	if !exists {
+		routesMu.Unlock()
+		return exportError(exportNotFound, "Cannot set body limit, route not found", "key", key)
+	}
+	route.MaxBodySize = maxBytes
+	if maxBytes == -1 {
+		delete(route.Responses, http.StatusRequestEntityTooLarge)
+	} else {
+		route.Responses[http.StatusRequestEntityTooLarge] = "Request body too large"
+	}
+	routes[key] = route
+	routesMu.Unlock()
+	invalidateOpenAPICache()
+
+	slog.Info("Route body limit set", "key", key, "max_bytes", maxBytes)
+	auditConfigChange("SetRouteBodyLimit", map[string]string{"path": path, "method": method, "max_bytes": strconv.FormatInt(maxBytes, 10)})
+
+	return 0
+}
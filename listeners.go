@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// systemdListenFDsStart is the first file descriptor passed by systemd
+// socket activation (SD_LISTEN_FDS_START)
+const systemdListenFDsStart = 3
+
+// listenerSpec is one address the server binds
+type listenerSpec struct {
+	network string // tcp, unix, or systemd
+	addr    string
+}
+
+func (s listenerSpec) String() string {
+	if s.network == "systemd" {
+		return "systemd"
+	}
+	return s.network + ":" + s.addr
+}
+
+// listenerSpecs parses the configured address. Besides a plain host it may
+// be a comma-separated list of host:port TCP addresses, unix:<path> socket
+// paths, and "systemd" for socket-activated descriptors. Entries without a
+// port use the configured port.
+func (c ServerConfig) listenerSpecs() ([]listenerSpec, error) {
+	var specs []listenerSpec
+	for _, part := range strings.Split(c.Address, ",") {
+		part = strings.TrimSpace(part)
+		switch {
+		case part == "":
+		case part == "systemd":
+			specs = append(specs, listenerSpec{network: "systemd"})
+		case strings.HasPrefix(part, "unix:"):
+			path := strings.TrimPrefix(part, "unix:")
+			if path == "" {
+				return nil, fmt.Errorf("empty unix socket path")
+			}
+			specs = append(specs, listenerSpec{network: "unix", addr: path})
+		default:
+			if _, _, err := net.SplitHostPort(part); err == nil {
+				specs = append(specs, listenerSpec{network: "tcp", addr: part})
+			} else {
+				specs = append(specs, listenerSpec{network: "tcp", addr: net.JoinHostPort(part, strconv.Itoa(c.Port))})
+			}
+		}
+	}
+	if len(specs) == 0 {
+		specs = append(specs, listenerSpec{network: "tcp", addr: net.JoinHostPort("", strconv.Itoa(c.Port))})
+	}
+	return specs, nil
+}
+
+// host returns the host of the first TCP listener. URLs in log messages,
+// the HTTPS redirect, and HTTP/3 bind to it.
+func (c ServerConfig) host() string {
+	specs, err := c.listenerSpecs()
+	if err != nil {
+		return ""
+	}
+	for _, spec := range specs {
+		if spec.network == "tcp" {
+			host, _, _ := net.SplitHostPort(spec.addr)
+			return host
+		}
+	}
+	return ""
+}
+
+// openListeners binds every listener, closing those already open if one
+// fails. Sockets passed by a reloading predecessor are adopted instead of
+// bound again. The returned sockets are the raw listeners for handing on
+// at the next reload.
+func openListeners(specs []listenerSpec) ([]net.Listener, []reloadSocket, error) {
+	var listeners []net.Listener
+	var sockets []reloadSocket
+	fail := func(err error) ([]net.Listener, []reloadSocket, error) {
+		for _, l := range listeners {
+			l.Close()
+		}
+		return nil, nil, err
+	}
+	for _, spec := range specs {
+		tag := spec.String()
+		opened, err := inheritedListeners(tag)
+		if err != nil {
+			return fail(err)
+		}
+		if len(opened) == 0 {
+			switch spec.network {
+			case "systemd":
+				opened, err = systemdListeners()
+			case "unix":
+				removeStaleSocket(spec.addr)
+				var l net.Listener
+				if l, err = net.Listen("unix", spec.addr); err == nil {
+					opened = []net.Listener{l}
+				}
+			default:
+				var l net.Listener
+				if l, err = net.Listen(spec.network, spec.addr); err == nil {
+					opened = []net.Listener{l}
+				}
+			}
+			if err != nil {
+				return fail(err)
+			}
+		}
+		for _, l := range opened {
+			listeners = append(listeners, l)
+			sockets = append(sockets, socketFor(tag, l)...)
+		}
+	}
+	return listeners, sockets, nil
+}
+
+// removeStaleSocket deletes a socket file left behind by a previous process
+// that did not shut down cleanly. Other file types are left for Listen to
+// report.
+func removeStaleSocket(path string) {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		// Someone is still serving on it
+		conn.Close()
+		return
+	}
+	os.Remove(path)
+}
+
+var (
+	// systemdFiles are the socket-activated descriptors, kept open so the
+	// listeners can be recreated by RestartServer
+	systemdFiles     []*os.File
+	systemdFilesErr  error
+	systemdFilesOnce sync.Once
+)
+
+// systemdListeners returns listeners for the descriptors passed through
+// LISTEN_FDS, after checking LISTEN_PID names this process
+func systemdListeners() ([]net.Listener, error) {
+	systemdFilesOnce.Do(func() {
+		if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+			systemdFilesErr = fmt.Errorf("no sockets passed by systemd for this process")
+			return
+		}
+		count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+		if err != nil || count <= 0 {
+			systemdFilesErr = fmt.Errorf("invalid LISTEN_FDS %q", os.Getenv("LISTEN_FDS"))
+			return
+		}
+		names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+		for i := 0; i < count; i++ {
+			fd := systemdListenFDsStart + i
+			syscall.CloseOnExec(fd)
+			name := "systemd-" + strconv.Itoa(fd)
+			if i < len(names) && names[i] != "" {
+				name = names[i]
+			}
+			systemdFiles = append(systemdFiles, os.NewFile(uintptr(fd), name))
+		}
+	})
+	if systemdFilesErr != nil {
+		return nil, systemdFilesErr
+	}
+	listeners := make([]net.Listener, 0, len(systemdFiles))
+	for _, f := range systemdFiles {
+		// FileListener duplicates the descriptor, leaving f open for restarts
+		l, err := net.FileListener(f)
+		if err != nil {
+			for _, opened := range listeners {
+				opened.Close()
+			}
+			return nil, fmt.Errorf("systemd socket %s: %w", f.Name(), err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
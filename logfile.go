@@ -0,0 +1,218 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat stamps rotated log files; it sorts chronologically and
+// avoids characters that are invalid in Windows file names
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// rotatingFile is a log file that is renamed aside once it reaches maxSize.
+// Rotated files are gzipped in the background, and those beyond maxBackups
+// or older than maxAge are removed.
+type rotatingFile struct {
+	path       string
+	maxSize    int64         // 0 never rotates
+	maxBackups int           // 0 keeps every backup
+	maxAge     time.Duration // 0 keeps backups regardless of age
+
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+	cleanup sync.Mutex // serializes background compression and removal
+}
+
+func openRotatingFile(path string, maxSizeMB, maxBackups, maxAgeDays int) (*rotatingFile, error) {
+	f := &rotatingFile{
+		path:       path,
+		maxSize:    int64(maxSizeMB) << 20,
+		maxBackups: maxBackups,
+		maxAge:     time.Duration(maxAgeDays) * 24 * time.Hour,
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	go f.prune()
+	return f, nil
+}
+
+// open opens the log file for appending; f.mu must be held
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "goserver: rotating log file %s: %v\n", f.path, err)
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate renames the current file aside and starts a new one; f.mu must
+// be held
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	ext := filepath.Ext(f.path)
+	backup := strings.TrimSuffix(f.path, ext) + "-" + time.Now().Format(backupTimeFormat) + ext
+	renameErr := os.Rename(f.path, backup)
+	if err := f.open(); err != nil {
+		f.file = nil
+		return err
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+	go f.prune()
+	return nil
+}
+
+// logBackup is one rotated log file
+type logBackup struct {
+	name    string
+	rotated time.Time
+}
+
+// backups lists the rotated files, newest first
+func (f *rotatingFile) backups() ([]logBackup, error) {
+	ext := filepath.Ext(f.path)
+	prefix := filepath.Base(strings.TrimSuffix(f.path, ext)) + "-"
+	entries, err := os.ReadDir(filepath.Dir(f.path))
+	if err != nil {
+		return nil, err
+	}
+	var backups []logBackup
+	for _, entry := range entries {
+		name := entry.Name()
+		stamp, ok := strings.CutPrefix(name, prefix)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		stamp = strings.TrimSuffix(strings.TrimSuffix(stamp, ".gz"), ext)
+		if rotated, err := time.ParseInLocation(backupTimeFormat, stamp, time.Local); err == nil {
+			backups = append(backups, logBackup{name: name, rotated: rotated})
+		}
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].rotated.After(backups[j].rotated) })
+	return backups, nil
+}
+
+// prune compresses uncompressed backups and removes expired ones
+func (f *rotatingFile) prune() {
+	f.cleanup.Lock()
+	defer f.cleanup.Unlock()
+	backups, err := f.backups()
+	if err != nil {
+		return
+	}
+	dir := filepath.Dir(f.path)
+	for i, backup := range backups {
+		path := filepath.Join(dir, backup.name)
+		if (f.maxBackups > 0 && i >= f.maxBackups) || (f.maxAge > 0 && time.Since(backup.rotated) > f.maxAge) {
+			os.Remove(path)
+			continue
+		}
+		if !strings.HasSuffix(backup.name, ".gz") {
+			if err := gzipFile(path); err != nil {
+				fmt.Fprintf(os.Stderr, "goserver: compressing log file %s: %v\n", path, err)
+			}
+		}
+	}
+}
+
+// gzipFile replaces path with path.gz
+func gzipFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		zw.Close()
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	src.Close()
+	return os.Remove(path)
+}
+
+func (f *rotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// setLogFile points the logger at a rotating file, or back at stderr for
+// an empty path, closing any previous file
+func setLogFile(path string, maxSizeMB, maxBackups, maxAgeDays int) error {
+	var output io.Writer = os.Stderr
+	var file *rotatingFile
+	if path != "" {
+		var err error
+		if file, err = openRotatingFile(path, maxSizeMB, maxBackups, maxAgeDays); err != nil {
+			return err
+		}
+		output = file
+	}
+	logMu.Lock()
+	previous := logFile
+	logOutput, logFile = output, file
+	logMu.Unlock()
+	installLogger()
+	if previous != nil {
+		previous.Close()
+	}
+	return nil
+}
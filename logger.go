@@ -0,0 +1,122 @@
+package main
+
+import (
+	"C"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	logLevel                = new(slog.LevelVar) // defaults to info
+	logFormat               = "text"
+	logOutput io.Writer     = os.Stderr
+	logFile   *rotatingFile // set while logging to a file
+	logMu     sync.Mutex    // guards logFormat, logOutput, and logFile
+)
+
+func init() {
+	installLogger()
+}
+
+// installLogger rebuilds the default slog logger from the current settings.
+// slog.SetDefault also routes the standard log package through it.
+func installLogger() {
+	logMu.Lock()
+	defer logMu.Unlock()
+	opts := &slog.HandlerOptions{Level: logLevel}
+	var handler slog.Handler
+	if logFormat == "json" {
+		handler = slog.NewJSONHandler(logOutput, opts)
+	} else {
+		handler = slog.NewTextHandler(logOutput, opts)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+// parseLogLevel maps debug/info/warn/error to a slog level
+func parseLogLevel(name string) (slog.Level, bool) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return slog.LevelDebug, true
+	case "info":
+		return slog.LevelInfo, true
+	case "warn", "warning":
+		return slog.LevelWarn, true
+	case "error":
+		return slog.LevelError, true
+	}
+	return 0, false
+}
+
+// SetLogLevel sets the minimum level logged: debug, info, warn, or error
+//
+//export SetLogLevel
+func SetLogLevel(cLevel *C.char) int {
+	if cLevel == nil {
+		return exportError(exportInvalid, "cLevel is nil in SetLogLevel")
+	}
+	name := C.GoString(cLevel)
+	level, ok := parseLogLevel(name)
+	if !ok {
+		return exportError(exportInvalid, "Unknown log level", "level", name)
+	}
+	logLevel.Set(level)
+	slog.Info("Log level set", "level", level.String())
+	auditConfigChange("SetLogLevel", map[string]string{"level": level.String()})
+
+	return 0
+}
+
+// SetLogFormat switches log output between "text" and "json"
+//
+//export SetLogFormat
+func SetLogFormat(cFormat *C.char) int {
+	if cFormat == nil {
+		return exportError(exportInvalid, "cFormat is nil in SetLogFormat")
+	}
+	format := strings.ToLower(C.GoString(cFormat))
+	if format != "text" && format != "json" {
+		return exportError(exportInvalid, "Unknown log format", "format", format)
+	}
+	logMu.Lock()
+	logFormat = format
+	logMu.Unlock()
+	installLogger()
+	slog.Info("Log format set", "format", format)
+	auditConfigChange("SetLogFormat", map[string]string{"format": format})
+
+	return 0
+}
+
+// SetLogOutput writes access and application logs to the file at cPath
+// instead of stderr. The file is rotated once it exceeds maxSizeMB (0
+// never rotates); rotated files are gzipped, and only the newest
+// maxBackups no older than maxAgeDays are kept (0 keeps all). An empty
+// cPath goes back to stderr.
+//
+//export SetLogOutput
+func SetLogOutput(cPath *C.char, maxSizeMB int, maxBackups int, maxAgeDays int) int {
+	if cPath == nil {
+		return exportError(exportInvalid, "cPath is nil in SetLogOutput")
+	}
+	if maxSizeMB < 0 || maxBackups < 0 || maxAgeDays < 0 {
+		return exportError(exportInvalid, "Invalid log rotation settings", "max_size_mb", maxSizeMB, "max_backups", maxBackups, "max_age_days", maxAgeDays)
+	}
+	path := C.GoString(cPath)
+	if err := setLogFile(path, maxSizeMB, maxBackups, maxAgeDays); err != nil {
+		return exportError(exportFailed, "Error opening log file", "path", path, "error", err)
+	}
+	slog.Info("Log output set", "path", path, "max_size_mb", maxSizeMB, "max_backups", maxBackups, "max_age_days", maxAgeDays)
+	auditConfigChange("SetLogOutput", map[string]string{
+		"path":         path,
+		"max_size_mb":  strconv.Itoa(maxSizeMB),
+		"max_backups":  strconv.Itoa(maxBackups),
+		"max_age_days": strconv.Itoa(maxAgeDays),
+	})
+
+	return 0
+}
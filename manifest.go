@@ -0,0 +1,250 @@
+package main
+
+import (
+	"C"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ManifestRoute describes one static route in a RegisterRoutesJSON manifest.
+// Optional fields mirror the per-route Register*/Set* exports.
+type ManifestRoute struct {
+	Path          string            `json:"path"`
+	Method        string            `json:"method"` // defaults to GET
+	Message       string            `json:"message"`
+	Description   string            `json:"description"`
+	Descriptions  map[string]string `json:"descriptions"` // localized, keyed by language tag
+	Status        int               `json:"status"`
+	ContentType   string            `json:"content_type"`
+	Headers       map[string]string `json:"headers"`
+	Trailers      map[string]string `json:"trailers"`
+	Schema        json.RawMessage   `json:"schema"` // JSON Schema or validator rules
+	Compression   *bool             `json:"compression"`
+	Backpressure  string            `json:"backpressure"`
+	Task          string            `json:"task"`
+	Template      string            `json:"template"` // renders with RegisterTemplateDir templates instead of sending message
+	CORS          json.RawMessage   `json:"cors"`
+	SecureHeaders json.RawMessage   `json:"secure_headers"`
+	RateLimit     *RateLimit        `json:"rate_limit"`
+	Scopes        []string          `json:"scopes"`
+	Tags          []string          `json:"tags"`
+	Responses     responseDocs      `json:"responses"` // as SetRouteResponses
+	Deprecation   *RouteDeprecation `json:"deprecation"`
+	Query         []QueryParam      `json:"query"`
+	Form          []QueryParam      `json:"form"` // application/x-www-form-urlencoded fields
+	Cache         *ManifestCache    `json:"cache"`
+	TimeoutMs     int               `json:"timeout_ms"` // -1 exempts the route from the timeout middleware
+}
+
+// ManifestCache mirrors SetRouteCache for a manifest route
+type ManifestCache struct {
+	TTL  int      `json:"ttl"` // seconds
+	Vary []string `json:"vary"`
+}
+
+// routeInfo validates the entry and builds its RouteInfo
+func (m ManifestRoute) routeInfo() (RouteInfo, error) {
+	if !strings.HasPrefix(m.Path, "/") {
+		return RouteInfo{}, fmt.Errorf("path %q must start with /", m.Path)
+	}
+	method := strings.ToUpper(m.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+	if m.Status != 0 && (m.Status < 200 || m.Status > 599) {
+		return RouteInfo{}, fmt.Errorf("invalid status %d", m.Status)
+	}
+	route := RouteInfo{
+		Path:           m.Path,
+		Method:         method,
+		Message:        m.Message,
+		Description:    m.Description,
+		Parameters:     pathParameters(m.Path),
+		Status:         m.Status,
+		ContentType:    m.ContentType,
+		Compression:    m.Compression,
+		RequiredScopes: m.Scopes,
+		Tags:           m.Tags,
+		Task:           m.Task,
+	}
+	route.Responses = map[int]string{route.successStatus(): "Successful response"}
+
+	if len(m.Headers) > 0 {
+		route.Headers = make(map[string]string, len(m.Headers))
+		for name, value := range m.Headers {
+			route.Headers[http.CanonicalHeaderKey(name)] = value
+		}
+	}
+	if len(m.Trailers) > 0 {
+		route.Trailers = make(map[string]string, len(m.Trailers))
+		for name, value := range m.Trailers {
+			route.Trailers[http.CanonicalHeaderKey(name)] = value
+		}
+	}
+	if m.Deprecation != nil {
+		d, err := m.Deprecation.prepare()
+		if err != nil {
+			return RouteInfo{}, fmt.Errorf("invalid deprecation: %w", err)
+		}
+		route.Deprecation = d
+	}
+	if len(m.Responses) > 0 {
+		documented, err := parseDocumentedResponses(m.Responses)
+		if err != nil {
+			return RouteInfo{}, err
+		}
+		documentResponses(&route, documented)
+	}
+	if len(m.Descriptions) > 0 {
+		route.Descriptions = make(map[string]string, len(m.Descriptions))
+		for lang, desc := range m.Descriptions {
+			if lang = normalizeLanguage(lang); lang == "" {
+				return RouteInfo{}, fmt.Errorf("empty language in descriptions")
+			}
+			route.Descriptions[lang] = desc
+		}
+	}
+	if len(m.Schema) > 0 && !bytes.Equal(m.Schema, []byte("null")) {
+		schema, err := parseBodySchema(m.Schema)
+		if err != nil {
+			return RouteInfo{}, fmt.Errorf("invalid schema: %w", err)
+		}
+		route.BodySchema = schema
+		route.Responses[http.StatusUnprocessableEntity] = "Validation error"
+	}
+	if m.Backpressure != "" {
+		mode := strings.ToLower(m.Backpressure)
+		if mode != BackpressureQueue && mode != BackpressureReject {
+			return RouteInfo{}, fmt.Errorf("unknown backpressure mode %q", m.Backpressure)
+		}
+		route.TaskBackpressure = mode
+	}
+	if len(m.CORS) > 0 && !bytes.Equal(m.CORS, []byte("null")) {
+		opts, err := parseCORSOptions(m.CORS)
+		if err != nil {
+			return RouteInfo{}, fmt.Errorf("invalid cors options: %w", err)
+		}
+		route.CORS = opts
+	}
+	if len(m.SecureHeaders) > 0 && !bytes.Equal(m.SecureHeaders, []byte("null")) {
+		opts, err := parseSecureHeadersOptions(m.SecureHeaders)
+		if err != nil {
+			return RouteInfo{}, fmt.Errorf("invalid secure_headers options: %w", err)
+		}
+		route.SecureHeaders = opts
+	}
+	if m.TimeoutMs != 0 {
+		if m.TimeoutMs < -1 {
+			return RouteInfo{}, fmt.Errorf("invalid timeout_ms %d", m.TimeoutMs)
+		}
+		route.Timeout = routeTimeout(m.TimeoutMs)
+		if m.TimeoutMs > 0 {
+			route.Responses[http.StatusGatewayTimeout] = "Request timed out"
+		}
+	}
+	if m.Cache != nil {
+		cache, err := newRouteCache(method, m.Cache.TTL, m.Cache.Vary)
+		if err != nil {
+			return RouteInfo{}, fmt.Errorf("invalid cache: %w", err)
+		}
+		if route.Cache = cache; cache != nil {
+			route.Responses[http.StatusNotModified] = "Not modified"
+		}
+	}
+	if len(m.Query) > 0 {
+		query, err := parseQueryDeclarations(m.Query)
+		if err != nil {
+			return RouteInfo{}, err
+		}
+		route.Parameters = withQueryParameters(route.Parameters, query)
+		route.Responses[http.StatusUnprocessableEntity] = "Validation error"
+	}
+	if len(m.Form) > 0 {
+		fields, err := parseDeclarations(m.Form, "body", "form field")
+		if err != nil {
+			return RouteInfo{}, err
+		}
+		setFormFields(&route, fields)
+	}
+	if m.RateLimit != nil {
+		if !m.RateLimit.valid() {
+			return RouteInfo{}, fmt.Errorf("invalid rate limit")
+		}
+		route.RateLimit = m.RateLimit
+	}
+	if m.Template != "" {
+		if !validTemplateName(m.Template) {
+			return RouteInfo{}, fmt.Errorf("invalid template name %q", m.Template)
+		}
+		route.Template = m.Template
+	}
+	return route, nil
+}
+
+// parseManifest accepts either a JSON array of routes or an object with a
+// "routes" array
+func parseManifest(doc []byte) ([]ManifestRoute, error) {
+	doc = bytes.TrimSpace(doc)
+	var entries []ManifestRoute
+	if len(doc) > 0 && doc[0] == '[' {
+		if err := json.Unmarshal(doc, &entries); err != nil {
+			return nil, err
+		}
+		return entries, nil
+	}
+	var manifest struct {
+		Routes []ManifestRoute `json:"routes"`
+	}
+	if err := json.Unmarshal(doc, &manifest); err != nil {
+		return nil, err
+	}
+	return manifest.Routes, nil
+}
+
+// RegisterRoutesJSON installs every route in a JSON manifest in one call.
+// All entries are validated first; if any is invalid nothing is registered.
+//
+//export RegisterRoutesJSON
+func RegisterRoutesJSON(cManifest *C.char) int {
+	if cManifest == nil {
+		return exportError(exportInvalid, "cManifest is nil in RegisterRoutesJSON")
+	}
+	entries, err := parseManifest([]byte(C.GoString(cManifest)))
+	if err != nil {
+		return exportError(exportInvalid, "Invalid route manifest", "error", err)
+	}
+
+	built := make([]RouteInfo, 0, len(entries))
+	seen := make(map[string]bool, len(entries))
+	for i, entry := range entries {
+		route, err := entry.routeInfo()
+		if err != nil {
+			return exportError(exportInvalid, "Invalid route in manifest", "index", i, "path", entry.Path, "error", err)
+		}
+		key := route.Path + route.Method
+		if seen[key] {
+			return exportError(exportInvalid, "Duplicate route in manifest", "index", i, "key", key)
+		}
+		seen[key] = true
+		built = append(built, route)
+	}
+
+	routesMu.Lock()
+	for _, route := range built {
+		key := route.Path + route.Method
+		routes[key] = route
+		routeTree.insert(route.Path, route.Method, key)
+	}
+	routesMu.Unlock()
+
+	slog.Info("Registered routes from manifest", "count", len(built))
+	invalidateOpenAPICache()
+	auditConfigChange("RegisterRoutesJSON", map[string]string{"count": strconv.Itoa(len(built))})
+
+	return 0
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"C"
+	"bufio"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the request histogram
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+var metricsEnabled atomic.Bool
+
+// requestSeries is the per route/method/status request counter and histogram
+type requestSeries struct {
+	count   uint64
+	sum     float64
+	buckets []uint64 // cumulative counts per latencyBuckets entry
+}
+
+type seriesKey struct {
+	method string
+	route  string
+	status int
+}
+
+// requestMetrics aggregates request counts and latencies
+type requestMetrics struct {
+	mu       sync.Mutex
+	series   map[seriesKey]*requestSeries
+	inFlight atomic.Int64
+}
+
+var httpMetrics = &requestMetrics{series: make(map[seriesKey]*requestSeries)}
+
+func (m *requestMetrics) observe(method, route string, status int, elapsed time.Duration) {
+	seconds := elapsed.Seconds()
+	key := seriesKey{method: method, route: route, status: status}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.series[key]
+	if !ok {
+		s = &requestSeries{buckets: make([]uint64, len(latencyBuckets))}
+		m.series[key] = s
+	}
+	s.count++
+	s.sum += seconds
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			s.buckets[i]++
+		}
+	}
+}
+
+// statusRecorder captures the status code and size of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (rec *statusRecorder) WriteHeader(code int) {
+	if rec.status == 0 {
+		rec.status = code
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+func (rec *statusRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+func (rec *statusRecorder) statusCode() int {
+	if rec.status == 0 {
+		return http.StatusOK
+	}
+	return rec.status
+}
+
+// metricsMiddleware records request counts, latency, and in-flight requests
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !metricsEnabled.Load() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		httpMetrics.inFlight.Add(1)
+		defer httpMetrics.inFlight.Add(-1)
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		httpMetrics.observe(r.Method, metricsRouteLabel(r), rec.statusCode(), time.Since(start))
+	})
+}
+
+// metricsRouteLabel uses the registered route pattern rather than the raw
+// path to keep label cardinality bounded
+func metricsRouteLabel(r *http.Request) string {
+	if info := requestInfoFrom(r); info != nil && info.Route != "" {
+		return info.Route
+	}
+	return "unmatched"
+}
+
+// promWriter writes the Prometheus text exposition format
+type promWriter struct {
+	*bufio.Writer
+}
+
+func (p promWriter) header(name, kind, help string) {
+	fmt.Fprintf(p, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (p promWriter) sample(name string, labels string, value interface{}) {
+	if labels != "" {
+		fmt.Fprintf(p, "%s{%s} %v\n", name, labels, value)
+	} else {
+		fmt.Fprintf(p, "%s %v\n", name, value)
+	}
+}
+
+// escapeLabel escapes a Prometheus label value
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// snapshot copies the series, ordered by route, method, and status
+func (m *requestMetrics) snapshot() ([]seriesKey, []requestSeries) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := make([]seriesKey, 0, len(m.series))
+	for k := range m.series {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		if keys[i].method != keys[j].method {
+			return keys[i].method < keys[j].method
+		}
+		return keys[i].status < keys[j].status
+	})
+	snapshot := make([]requestSeries, len(keys))
+	for i, k := range keys {
+		s := m.series[k]
+		snapshot[i] = requestSeries{count: s.count, sum: s.sum, buckets: append([]uint64(nil), s.buckets...)}
+	}
+	return keys, snapshot
+}
+
+// writeRequestMetrics emits request counters and latency histograms
+func writeRequestMetrics(p promWriter) {
+	keys, snapshot := httpMetrics.snapshot()
+
+	p.header("goserver_http_requests_total", "counter", "Total HTTP requests by route, method, and status.")
+	for i, k := range keys {
+		p.sample("goserver_http_requests_total", seriesLabels(k), snapshot[i].count)
+	}
+	p.header("goserver_http_request_duration_seconds", "histogram", "HTTP request latency by route, method, and status.")
+	for i, k := range keys {
+		labels := seriesLabels(k)
+		for b, bound := range latencyBuckets {
+			p.sample("goserver_http_request_duration_seconds_bucket", labels+`,le="`+formatFloat(bound)+`"`, snapshot[i].buckets[b])
+		}
+		p.sample("goserver_http_request_duration_seconds_bucket", labels+`,le="+Inf"`, snapshot[i].count)
+		p.sample("goserver_http_request_duration_seconds_sum", labels, formatFloat(snapshot[i].sum))
+		p.sample("goserver_http_request_duration_seconds_count", labels, snapshot[i].count)
+	}
+	p.header("goserver_http_requests_in_flight", "gauge", "HTTP requests currently being served.")
+	p.sample("goserver_http_requests_in_flight", "", httpMetrics.inFlight.Load())
+}
+
+// writeGRPCMetrics emits gRPC call counters and latency histograms by
+// method and status code
+func writeGRPCMetrics(p promWriter) {
+	keys, snapshot := grpcMetrics.snapshot()
+	p.header("goserver_grpc_requests_total", "counter", "Total gRPC calls by method and status code.")
+	for i, k := range keys {
+		p.sample("goserver_grpc_requests_total", grpcSeriesLabels(k), snapshot[i].count)
+	}
+	p.header("goserver_grpc_request_duration_seconds", "histogram", "gRPC call latency by method and status code.")
+	for i, k := range keys {
+		labels := grpcSeriesLabels(k)
+		for b, bound := range latencyBuckets {
+			p.sample("goserver_grpc_request_duration_seconds_bucket", labels+`,le="`+formatFloat(bound)+`"`, snapshot[i].buckets[b])
+		}
+		p.sample("goserver_grpc_request_duration_seconds_bucket", labels+`,le="+Inf"`, snapshot[i].count)
+		p.sample("goserver_grpc_request_duration_seconds_sum", labels, formatFloat(snapshot[i].sum))
+		p.sample("goserver_grpc_request_duration_seconds_count", labels, snapshot[i].count)
+	}
+}
+
+func grpcSeriesLabels(k seriesKey) string {
+	return fmt.Sprintf(`method="%s",code="%d"`, escapeLabel(k.route), k.status)
+}
+
+func seriesLabels(k seriesKey) string {
+	return fmt.Sprintf(`route="%s",method="%s",status="%d"`, escapeLabel(k.route), escapeLabel(k.method), k.status)
+}
+
+// writeServerMetrics emits task, connection, and admission metrics
+func writeServerMetrics(p promWriter) {
+	counts := make(map[TaskState]int)
+	for _, task := range tasks.list() {
+		counts[task.State]++
+	}
+	p.header("goserver_tasks", "gauge", "Tracked background tasks by state.")
+	for _, state := range []TaskState{TaskScheduled, TaskPending, TaskRunning, TaskRetrying, TaskDone, TaskFailed} {
+		p.sample("goserver_tasks", `state="`+string(state)+`"`, counts[state])
+	}
+	workers, queued := taskPoolSnapshot()
+	p.header("goserver_task_workers", "gauge", "Background task worker goroutines.")
+	p.sample("goserver_task_workers", "", workers)
+	p.header("goserver_task_queue_depth", "gauge", "Background tasks waiting for a worker.")
+	p.sample("goserver_task_queue_depth", "", queued)
+	p.header("goserver_tasks_rejected_total", "counter", "Background tasks rejected because the queue was full.")
+	p.sample("goserver_tasks_rejected_total", "", stats.TasksRejected.Load())
+	p.header("goserver_tasks_retried_total", "counter", "Failed background task attempts scheduled to run again.")
+	p.sample("goserver_tasks_retried_total", "", stats.TasksRetried.Load())
+	p.header("goserver_tasks_dead_lettered_total", "counter", "Background tasks moved to the dead-letter list after their last attempt.")
+	p.sample("goserver_tasks_dead_lettered_total", "", stats.TasksDeadLettered.Load())
+
+	p.header("goserver_connections_accepted_total", "counter", "Client connections accepted.")
+	p.sample("goserver_connections_accepted_total", "", stats.ConnectionsAccepted.Load())
+	p.header("goserver_connections_closed_by_age_total", "counter", "Connections closed for exceeding the max connection age.")
+	p.sample("goserver_connections_closed_by_age_total", "", stats.ConnectionsClosedByAge.Load())
+
+	depth, inFlight := admission.snapshot()
+	p.header("goserver_admission_queue_depth", "gauge", "Requests waiting in the admission queue.")
+	p.sample("goserver_admission_queue_depth", "", depth)
+	p.header("goserver_admission_in_flight", "gauge", "Requests admitted to the dispatcher.")
+	p.sample("goserver_admission_in_flight", "", inFlight)
+	p.header("goserver_admission_rejected_total", "counter", "Requests rejected by the admission queue.")
+	p.sample("goserver_admission_rejected_total", "", stats.AdmissionRejected.Load())
+	p.header("goserver_admission_wait_seconds_total", "counter", "Total time requests spent in the admission queue.")
+	p.sample("goserver_admission_wait_seconds_total", "", formatFloat(time.Duration(stats.AdmissionWaitNanos.Load()).Seconds()))
+	p.header("goserver_requests_timed_out_total", "counter", "Requests answered with 504 by the timeout middleware.")
+	p.sample("goserver_requests_timed_out_total", "", stats.RequestsTimedOut.Load())
+}
+
+// writeRuntimeMetrics emits Go runtime metrics
+func writeRuntimeMetrics(p promWriter) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	p.header("go_info", "gauge", "Go runtime version.")
+	p.sample("go_info", `version="`+runtime.Version()+`"`, 1)
+	p.header("go_goroutines", "gauge", "Number of goroutines.")
+	p.sample("go_goroutines", "", runtime.NumGoroutine())
+	p.header("go_memstats_alloc_bytes", "gauge", "Bytes of allocated heap objects.")
+	p.sample("go_memstats_alloc_bytes", "", mem.Alloc)
+	p.header("go_memstats_heap_inuse_bytes", "gauge", "Bytes in in-use heap spans.")
+	p.sample("go_memstats_heap_inuse_bytes", "", mem.HeapInuse)
+	p.header("go_memstats_sys_bytes", "gauge", "Bytes of memory obtained from the OS.")
+	p.sample("go_memstats_sys_bytes", "", mem.Sys)
+	p.header("go_gc_cycles_total", "counter", "Completed GC cycles.")
+	p.sample("go_gc_cycles_total", "", mem.NumGC)
+	p.header("go_gc_pause_seconds_total", "counter", "Total GC stop-the-world pause time.")
+	p.sample("go_gc_pause_seconds_total", "", formatFloat(time.Duration(mem.PauseTotalNs).Seconds()))
+}
+
+// ServeMetrics serves the Prometheus scrape endpoint when metrics are enabled
+func ServeMetrics(w http.ResponseWriter, r *http.Request) {
+	if !metricsEnabled.Load() {
+		http.Error(w, `{"error": "Metrics are disabled"}`, http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	p := promWriter{bufio.NewWriter(w)}
+	writeRequestMetrics(p)
+	writeGRPCMetrics(p)
+	writeServerMetrics(p)
+	writeConcurrencyMetrics(p)
+	writeBreakerMetrics(p)
+	writeDeprecationMetrics(p)
+	writeDatabaseMetrics(p)
+	writeRuntimeMetrics(p)
+	if err := p.Flush(); err != nil {
+		slog.Error("Error writing metrics", "error", err)
+	}
+}
+
+// EnableMetrics toggles request instrumentation and the /metrics endpoint
+//
+//export EnableMetrics
+func EnableMetrics(enabled int) int {
+	on := enabled != 0
+	metricsEnabled.Store(on)
+	slog.Info("Metrics toggled", "enabled", on)
+	auditConfigChange("EnableMetrics", map[string]string{"enabled": strconv.FormatBool(on)})
+
+	return 0
+}
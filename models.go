@@ -0,0 +1,357 @@
+package main
+
+import (
+	"C"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+const schemaRefPrefix = "#/components/schemas/"
+
+// maxModelRefDepth bounds $ref inlining for validation, so recursive models
+// stop being checked past this depth instead of looping
+const maxModelRefDepth = 8
+
+var modelNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// Registered models are JSON Schemas published under components/schemas
+var (
+	models   = make(map[string]map[string]interface{})
+	modelsMu sync.RWMutex
+)
+
+// builtinSchemas describe the server's own response bodies
+var builtinSchemas = map[string]interface{}{
+	"ErrorResponse": map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"error":      map[string]interface{}{"type": "string"},
+			"request_id": map[string]interface{}{"type": "string"},
+		},
+		"required": []string{"error"},
+	},
+	"ValidationError": map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"loc":  map[string]interface{}{"type": "array", "items": map[string]interface{}{}},
+			"msg":  map[string]interface{}{"type": "string"},
+			"type": map[string]interface{}{"type": "string"},
+		},
+		"required": []string{"loc", "msg", "type"},
+	},
+	"HTTPValidationError": map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"detail": map[string]interface{}{"type": "array", "items": schemaRef("ValidationError")},
+		},
+	},
+	"TaskResponse": map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"message": map[string]interface{}{"type": "string"},
+			"task_id": map[string]interface{}{"type": "string"},
+		},
+	},
+}
+
+func schemaRef(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": schemaRefPrefix + name}
+}
+
+// operationID derives a stable identifier such as getUsersId from a route
+func operationID(route RouteInfo) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(route.Method))
+	upper := true
+	for _, r := range route.Path {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// rulesSchema approximates a validator tag rule map as a JSON Schema
+func rulesSchema(rules map[string]interface{}) map[string]interface{} {
+	properties := make(map[string]interface{}, len(rules))
+	var required []string
+	for _, field := range sortedKeys(rules) {
+		prop := map[string]interface{}{}
+		switch rule := rules[field].(type) {
+		case map[string]interface{}:
+			prop = rulesSchema(rule)
+		case string:
+			for _, tag := range strings.Split(rule, ",") {
+				switch name, param, _ := strings.Cut(tag, "="); name {
+				case "required":
+					required = append(required, field)
+				case "email", "uuid", "uri", "url":
+					prop["type"], prop["format"] = "string", name
+				case "oneof":
+					prop["enum"] = strings.Fields(param)
+				case "numeric", "number":
+					prop["type"] = "number"
+				case "boolean":
+					prop["type"] = "boolean"
+				}
+			}
+		}
+		properties[field] = prop
+	}
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// staticResponseSchema documents the envelope static JSON routes send
+func staticResponseSchema(route RouteInfo) map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"message":         map[string]interface{}{"type": "string", "example": route.Message},
+			"background_task": schemaRef("TaskResponse"),
+		},
+	}
+}
+
+// openAPIComponentSchemas returns the built-in and registered model schemas
+func openAPIComponentSchemas() map[string]interface{} {
+	schemas := make(map[string]interface{}, len(builtinSchemas))
+	for name, schema := range builtinSchemas {
+		schemas[name] = schema
+	}
+	schemas["HTTPValidationError"] = validationErrorSchema()
+	if problemErrorsEnabled() {
+		schemas["ProblemDetails"] = problemDetailsSchema
+	}
+	modelsMu.RLock()
+	for name, schema := range models {
+		schemas[name] = schema
+	}
+	modelsMu.RUnlock()
+	return schemas
+}
+
+// openAPIRequestBody documents a route's request body. Inline schemas are
+// published as components named after the operation so every body is a
+// reference.
+func openAPIRequestBody(route RouteInfo, schemas map[string]interface{}) map[string]interface{} {
+	if route.Multipart != nil {
+		return route.Multipart.openAPISchema()
+	}
+	if len(route.FormFields) > 0 {
+		return formRequestBody(route.FormFields)
+	}
+	if route.BodyStream != nil && route.RequestModel == "" {
+		return bodyStreamRequestBody()
+	}
+	var ref map[string]interface{}
+	switch {
+	case route.RequestModel != "":
+		ref = schemaRef(route.RequestModel)
+	case route.BodySchema != nil:
+		name := strings.ToUpper(operationID(route)[:1]) + operationID(route)[1:] + "Body"
+		if route.BodySchema.JSONSchema != nil {
+			schemas[name] = route.BodySchema.JSONSchema
+		} else {
+			schemas[name] = rulesSchema(route.BodySchema.Rules)
+		}
+		ref = schemaRef(name)
+	default:
+		return nil
+	}
+	return map[string]interface{}{
+		"required": true,
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": ref},
+		},
+	}
+}
+
+// openAPIResponseSchema returns the schema for one documented response, or
+// nil if the body is not described
+func openAPIResponseSchema(route RouteInfo, code int) map[string]interface{} {
+	if name, ok := route.ResponseModels[code]; ok {
+		return schemaRef(name)
+	}
+	switch {
+	case code == http.StatusUnprocessableEntity:
+		return schemaRef("HTTPValidationError")
+	case code >= 400 && problemErrorsEnabled():
+		return schemaRef("ProblemDetails")
+	case code >= 400:
+		return schemaRef("ErrorResponse")
+	case route.Handler == 0 && route.Gateway == nil && route.WebSocket == 0 && route.SSE == 0 && code == route.successStatus() && code != http.StatusNoContent && strings.Contains(route.contentType(), "json"):
+		return staticResponseSchema(route)
+	}
+	return nil
+}
+
+// inlineModelRefs copies a schema, replacing references to registered
+// models with their schemas so the validator can follow them
+func inlineModelRefs(node interface{}, depth int) interface{} {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if ref, ok := n["$ref"].(string); ok && strings.HasPrefix(ref, schemaRefPrefix) {
+			if depth >= maxModelRefDepth {
+				return map[string]interface{}{}
+			}
+			modelsMu.RLock()
+			model, exists := models[strings.TrimPrefix(ref, schemaRefPrefix)]
+			modelsMu.RUnlock()
+			if !exists {
+				return map[string]interface{}{}
+			}
+			return inlineModelRefs(model, depth+1)
+		}
+		out := make(map[string]interface{}, len(n))
+		for key, value := range n {
+			out[key] = inlineModelRefs(value, depth)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(n))
+		for i, value := range n {
+			out[i] = inlineModelRefs(value, depth)
+		}
+		return out
+	}
+	return node
+}
+
+// modelExists reports whether a model is registered
+func modelExists(name string) bool {
+	modelsMu.RLock()
+	defer modelsMu.RUnlock()
+	_, exists := models[name]
+	return exists
+}
+
+// RegisterModel publishes a named JSON Schema under components/schemas.
+// Models may reference each other with "#/components/schemas/<name>" and
+// carry "example" values. Re-registering a name replaces it.
+//
+//export RegisterModel
+func RegisterModel(cName *C.char, cSchema *C.char) int {
+	if cName == nil || cSchema == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in RegisterModel")
+	}
+	name := C.GoString(cName)
+	if !modelNamePattern.MatchString(name) {
+		return exportError(exportInvalid, "Invalid model name", "name", name)
+	}
+	if _, builtin := builtinSchemas[name]; builtin {
+		return exportError(exportInvalid, "Model name is reserved", "name", name)
+	}
+	var schema map[string]interface{}
+	if err := json.Unmarshal([]byte(C.GoString(cSchema)), &schema); err != nil {
+		return exportError(exportInvalid, "Invalid model schema", "name", name, "error", err)
+	}
+
+	modelsMu.Lock()
+	models[name] = schema
+	modelsMu.Unlock()
+
+	slog.Info("Model registered", "name", name)
+	invalidateOpenAPICache()
+	auditConfigChange("RegisterModel", map[string]string{"name": name})
+
+	return 0
+}
+
+// SetRouteModels documents a route's request and response bodies with
+// registered models. cRequestModel may be empty; when set it also replaces
+// the route's body schema, so requests are validated against the model.
+// cResponseModels is a JSON object of status codes to model names.
+//
+//export SetRouteModels
+func SetRouteModels(cPath *C.char, cMethod *C.char, cRequestModel *C.char, cResponseModels *C.char) int {
+	if cPath == nil || cMethod == nil || cRequestModel == nil || cResponseModels == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in SetRouteModels")
+	}
+	path := C.GoString(cPath)
+	method := strings.ToUpper(C.GoString(cMethod))
+	requestModel := C.GoString(cRequestModel)
+
+	var rawResponses map[string]string
+	if raw := C.GoString(cResponseModels); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &rawResponses); err != nil {
+			return exportError(exportInvalid, "Invalid response models", "path", path, "method", method, "error", err)
+		}
+	}
+	responseModels := make(map[int]string, len(rawResponses))
+	for codeText, name := range rawResponses {
+		code, err := strconv.Atoi(codeText)
+		if err != nil || code < 100 || code > 599 {
+			return exportError(exportInvalid, "Invalid response model status", "path", path, "method", method, "status", codeText)
+		}
+		if !modelExists(name) {
+			return exportError(exportNotFound, "Cannot set response model, model not found", "model", name)
+		}
+		responseModels[code] = name
+	}
+
+	var bodySchema *BodySchema
+	if requestModel != "" {
+		modelsMu.RLock()
+		model, exists := models[requestModel]
+		modelsMu.RUnlock()
+		if !exists {
+			return exportError(exportNotFound, "Cannot set request model, model not found", "model", requestModel)
+		}
+		doc, err := json.Marshal(inlineModelRefs(model, 0))
+		if err == nil {
+			bodySchema, err = parseBodySchema(doc)
+		}
+		if err != nil {
+			return exportError(exportInvalid, "Request model cannot be used for validation", "model", requestModel, "error", err)
+		}
+	}
+
+	routesMu.Lock()
+	key := path + method
+	route, exists := routes[key]
+	if !exists {
+		routesMu.Unlock()
+		return exportError(exportNotFound, "Cannot set models, route not found", "key", key)
+	}
+	route.RequestModel = requestModel
+	route.ResponseModels = responseModels
+	if bodySchema != nil {
+		route.BodySchema = bodySchema
+		route.Responses[http.StatusUnprocessableEntity] = "Validation error"
+	}
+	for code := range responseModels {
+		if _, documented := route.Responses[code]; !documented {
+			route.Responses[code] = http.StatusText(code)
+		}
+	}
+	routes[key] = route
+	routesMu.Unlock()
+
+	slog.Info("Route models set", "key", key, "request", requestModel, "responses", len(responseModels))
+	invalidateOpenAPICache()
+	auditConfigChange("SetRouteModels", map[string]string{
+		"path":      path,
+		"method":    method,
+		"request":   requestModel,
+		"responses": fmt.Sprint(rawResponses),
+	})
+
+	return 0
+}
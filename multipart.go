@@ -0,0 +1,320 @@
+package main
+
+import (
+	"C"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Upload handler events
+const (
+	uploadEventStart = 0
+	uploadEventChunk = 1
+	uploadEventEnd   = 2
+	uploadEventAbort = 3 // the request failed after the part started
+)
+
+const (
+	defaultMaxUploadSize   = 32 << 20
+	defaultMaxFieldSize    = 1 << 20
+	defaultUploadChunkSize = 64 << 10
+)
+
+// MultipartField documents a form field or file part in OpenAPI
+type MultipartField struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Required    bool   `json:"required"`
+	Multiple    bool   `json:"multiple"` // more than one value or file may be sent
+}
+
+// MultipartOptions configures a handler route that accepts
+// multipart/form-data, set with SetRouteMultipart
+type MultipartOptions struct {
+	MaxUploadSize int64            `json:"max_upload_size"` // whole request body in bytes
+	MaxFieldSize  int64            `json:"max_field_size"`  // each non-file field in bytes
+	TempDir       string           `json:"temp_dir"`        // defaults to the OS temp directory
+	ChunkSize     int              `json:"chunk_size"`      // bytes per upload handler call
+	Fields        []MultipartField `json:"fields"`
+	Files         []MultipartField `json:"files"`
+
+	// UploadHandler receives file parts chunk by chunk instead of them
+	// being written to TempDir
+	UploadHandler uintptr `json:"-"`
+}
+
+// UploadedFile describes a file part passed to the route handler. Path is
+// set when the file was written to disk; it is removed once the handler
+// returns, so handlers must move or copy it during the call.
+type UploadedFile struct {
+	Field       string `json:"field"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	Path        string `json:"path,omitempty"`
+}
+
+// multipartUpload is a parsed multipart request
+type multipartUpload struct {
+	Form  map[string][]string
+	Files []UploadedFile
+}
+
+// cleanup removes the temp files written for the upload
+func (u *multipartUpload) cleanup() {
+	for _, file := range u.Files {
+		if file.Path != "" {
+			os.Remove(file.Path)
+		}
+	}
+}
+
+// uploadError carries the status to answer a failed upload with
+type uploadError struct {
+	status int
+	msg    string
+}
+
+func (e *uploadError) Error() string { return e.msg }
+
+// uploadFailure maps a read error to an uploadError
+func uploadFailure(err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return &uploadError{http.StatusRequestEntityTooLarge, fmt.Sprintf("Upload exceeds %d bytes", tooLarge.Limit)}
+	}
+	var upErr *uploadError
+	if errors.As(err, &upErr) {
+		return upErr
+	}
+	return &uploadError{http.StatusBadRequest, "Malformed multipart body"}
+}
+
+// readMultipart streams a multipart/form-data body. Fields are collected in
+// memory up to MaxFieldSize; files go to TempDir or the upload handler.
+func readMultipart(w http.ResponseWriter, r *http.Request, opts *MultipartOptions) (*multipartUpload, error) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		return nil, &uploadError{http.StatusUnsupportedMediaType, "Expected multipart/form-data"}
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, opts.MaxUploadSize)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, uploadFailure(err)
+	}
+
+	upload := &multipartUpload{Form: make(map[string][]string)}
+	requestID := ""
+	if info := requestInfoFrom(r); info != nil {
+		requestID = info.ID
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return upload, nil
+		}
+		if err != nil {
+			upload.cleanup()
+			return nil, uploadFailure(err)
+		}
+		name := part.FormName()
+		if name == "" {
+			part.Close()
+			continue
+		}
+		if part.FileName() == "" {
+			value, err := io.ReadAll(io.LimitReader(part, opts.MaxFieldSize+1))
+			part.Close()
+			if err != nil {
+				upload.cleanup()
+				return nil, uploadFailure(err)
+			}
+			if int64(len(value)) > opts.MaxFieldSize {
+				upload.cleanup()
+				return nil, &uploadError{http.StatusRequestEntityTooLarge, fmt.Sprintf("Field %s exceeds %d bytes", name, opts.MaxFieldSize)}
+			}
+			upload.Form[name] = append(upload.Form[name], string(value))
+			continue
+		}
+
+		file := UploadedFile{Field: name, Filename: part.FileName(), ContentType: part.Header.Get("Content-Type")}
+		if opts.UploadHandler != 0 {
+			err = streamUploadPart(part, opts, requestID, &file)
+		} else {
+			err = saveUploadPart(part, opts, &file)
+		}
+		part.Close()
+		if err != nil {
+			upload.cleanup()
+			return nil, uploadFailure(err)
+		}
+		upload.Files = append(upload.Files, file)
+	}
+}
+
+// saveUploadPart writes a file part to a temp file
+func saveUploadPart(part *multipart.Part, opts *MultipartOptions, file *UploadedFile) error {
+	f, err := os.CreateTemp(opts.TempDir, "goserver-upload-*")
+	if err != nil {
+		slog.Error("Cannot create upload temp file", "dir", opts.TempDir, "error", err)
+		return &uploadError{http.StatusInternalServerError, "Failed to store upload"}
+	}
+	file.Path = f.Name()
+	file.Size, err = io.Copy(f, part)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Path)
+	}
+	return err
+}
+
+// streamUploadPart passes a file part to the host upload handler
+func streamUploadPart(part *multipart.Part, opts *MultipartOptions, requestID string, file *UploadedFile) error {
+	meta, err := json.Marshal(map[string]string{
+		"request_id":   requestID,
+		"field":        file.Field,
+		"filename":     file.Filename,
+		"content_type": file.ContentType,
+	})
+	if err != nil {
+		return err
+	}
+	rejected := &uploadError{http.StatusBadRequest, "Upload rejected by handler"}
+	if !callUploadHandler(opts.UploadHandler, meta, uploadEventStart, nil) {
+		return rejected
+	}
+	buf := make([]byte, opts.ChunkSize)
+	for {
+		n, err := io.ReadFull(part, buf)
+		if n > 0 {
+			file.Size += int64(n)
+			if !callUploadHandler(opts.UploadHandler, meta, uploadEventChunk, buf[:n]) {
+				callUploadHandler(opts.UploadHandler, meta, uploadEventAbort, nil)
+				return rejected
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			callUploadHandler(opts.UploadHandler, meta, uploadEventAbort, nil)
+			return err
+		}
+	}
+	if !callUploadHandler(opts.UploadHandler, meta, uploadEventEnd, nil) {
+		return rejected
+	}
+	return nil
+}
+
+// openAPISchema documents the form as a multipart/form-data request body
+func (opts *MultipartOptions) openAPISchema() map[string]interface{} {
+	properties := make(map[string]interface{}, len(opts.Fields)+len(opts.Files))
+	var required []string
+	add := func(field MultipartField, schema map[string]interface{}) {
+		if field.Description != "" {
+			schema["description"] = field.Description
+		}
+		if field.Multiple {
+			schema = map[string]interface{}{"type": "array", "items": schema}
+		}
+		properties[field.Name] = schema
+		if field.Required {
+			required = append(required, field.Name)
+		}
+	}
+	for _, field := range opts.Fields {
+		add(field, map[string]interface{}{"type": "string"})
+	}
+	for _, file := range opts.Files {
+		add(file, map[string]interface{}{"type": "string", "format": "binary"})
+	}
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return map[string]interface{}{
+		"required": true,
+		"content": map[string]interface{}{
+			"multipart/form-data": map[string]interface{}{"schema": schema},
+		},
+	}
+}
+
+// SetRouteMultipart makes a handler route accept multipart/form-data.
+// cOptions is a JSON MultipartOptions object and may be empty. When
+// cUploadHandler is nonzero, file parts are streamed to it chunk by chunk
+// instead of being written to temp files. The route handler receives form
+// fields and file metadata in the request's "form" and "files".
+//
+//export SetRouteMultipart
+func SetRouteMultipart(cPath *C.char, cMethod *C.char, cOptions *C.char, cUploadHandler uintptr) int {
+	if cPath == nil || cMethod == nil || cOptions == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in SetRouteMultipart")
+	}
+	path := C.GoString(cPath)
+	method := strings.ToUpper(C.GoString(cMethod))
+	options := C.GoString(cOptions)
+	opts := &MultipartOptions{
+		MaxUploadSize: defaultMaxUploadSize,
+		MaxFieldSize:  defaultMaxFieldSize,
+		ChunkSize:     defaultUploadChunkSize,
+	}
+	if options != "" {
+		if err := json.Unmarshal([]byte(options), opts); err != nil {
+			return exportError(exportInvalid, "Invalid multipart options", "path", path, "method", method, "error", err)
+		}
+	}
+	if opts.MaxUploadSize <= 0 || opts.MaxFieldSize <= 0 || opts.ChunkSize <= 0 {
+		return exportError(exportInvalid, "Multipart limits must be positive", "path", path, "method", method)
+	}
+	if opts.TempDir == "" {
+		opts.TempDir = os.TempDir()
+	}
+	opts.UploadHandler = cUploadHandler
+
+	routesMu.Lock()
+	key := path + method
+	route, exists := routes[key]
+	if !exists {
+		routesMu.Unlock()
+		return exportError(exportNotFound, "Cannot set multipart options, route not found", "key", key)
+	}
+	if route.Handler == 0 {
+		routesMu.Unlock()
+		return exportError(exportFailed, "Cannot set multipart options, route has no handler", "key", key)
+	}
+	if len(route.FormFields) > 0 {
+		routesMu.Unlock()
+		return exportError(exportFailed, "Cannot set multipart options, route has urlencoded form fields", "key", key)
+	}
+	if route.BodyStream != nil {
+		routesMu.Unlock()
+		return exportError(exportFailed, "Cannot set multipart options, route streams its body", "key", key)
+	}
+	route.Multipart = opts
+	route.Responses[http.StatusRequestEntityTooLarge] = "Upload too large"
+	routes[key] = route
+	routesMu.Unlock()
+
+	slog.Info("Route multipart options set", "key", key, "max_upload_size", opts.MaxUploadSize, "streaming", cUploadHandler != 0)
+	invalidateOpenAPICache()
+	auditConfigChange("SetRouteMultipart", map[string]string{
+		"path":      path,
+		"method":    method,
+		"options":   options,
+		"streaming": fmt.Sprint(cUploadHandler != 0),
+	})
+
+	return 0
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"C"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// localizedInfo holds the translated OpenAPI info block for one language
+type localizedInfo struct {
+	Title       string
+	Description string
+}
+
+var (
+	openAPIInfoLocales = make(map[string]localizedInfo)
+	openAPICache       = make(map[string]openAPIEntry) // keyed by host pattern and language ("" is the default)
+	openAPIGeneration  atomic.Uint64
+	openAPIMu          sync.RWMutex
+)
+
+// openAPIEntry is an encoded spec with the ETag it is served under
+type openAPIEntry struct {
+	data []byte
+	etag string
+}
+
+// invalidateOpenAPICache drops every cached spec after a route or info change
+func invalidateOpenAPICache() {
+	openAPIMu.Lock()
+	openAPIGeneration.Add(1)
+	openAPICache = make(map[string]openAPIEntry)
+	openAPIMu.Unlock()
+}
+
+// cachedOpenAPI returns the encoded spec for a host pattern and lang,
+// building it on a miss
+func cachedOpenAPI(host, lang string, build func(host, lang string) ([]byte, error)) (openAPIEntry, error) {
+	key := host + " " + lang
+	openAPIMu.RLock()
+	entry, ok := openAPICache[key]
+	openAPIMu.RUnlock()
+	if ok {
+		return entry, nil
+	}
+
+	generation := openAPIGeneration.Load()
+	data, err := build(host, lang)
+	if err != nil {
+		return openAPIEntry{}, err
+	}
+	sum := sha256.Sum256(data)
+	entry = openAPIEntry{data: data, etag: `"` + hex.EncodeToString(sum[:16]) + `"`}
+	openAPIMu.Lock()
+	// Only keep the result if nothing changed while it was being built
+	if openAPIGeneration.Load() == generation {
+		openAPICache[key] = entry
+	}
+	openAPIMu.Unlock()
+	return entry, nil
+}
+
+// warmOpenAPICache builds the default host's spec when the server starts,
+// so the first request for it is served from the cache
+func warmOpenAPICache() {
+	if _, err := cachedOpenAPI("", "", buildOpenAPI); err != nil {
+		slog.Error("Error generating OpenAPI", "error", err)
+	}
+}
+
+// normalizeLanguage lower-cases a language tag and uses "-" as separator
+func normalizeLanguage(tag string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(tag), "_", "-"))
+}
+
+// parseAcceptLanguage returns the requested language tags ordered by q-value
+func parseAcceptLanguage(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var tags []weighted
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		tag := normalizeLanguage(fields[0])
+		if tag == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > 0 {
+			tags = append(tags, weighted{tag, q})
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+	result := make([]string, len(tags))
+	for i, t := range tags {
+		result[i] = t.tag
+	}
+	return result
+}
+
+// availableLanguages lists every language with at least one localization
+func availableLanguages() map[string]bool {
+	langs := make(map[string]bool)
+	openAPIMu.RLock()
+	for lang := range openAPIInfoLocales {
+		langs[lang] = true
+	}
+	openAPIMu.RUnlock()
+	routesMu.RLock()
+	for _, route := range routes {
+		for lang := range route.Descriptions {
+			langs[lang] = true
+		}
+	}
+	routesMu.RUnlock()
+	return langs
+}
+
+// negotiateLanguage picks the best available language for an Accept-Language
+// header, matching exact tags first and then the primary subtag. An empty
+// result means the default (unlocalized) spec.
+func negotiateLanguage(header string) string {
+	if header == "" {
+		return ""
+	}
+	available := availableLanguages()
+	if len(available) == 0 {
+		return ""
+	}
+	for _, tag := range parseAcceptLanguage(header) {
+		if tag == "*" {
+			return ""
+		}
+		if available[tag] {
+			return tag
+		}
+		if base, _, found := strings.Cut(tag, "-"); found && available[base] {
+			return base
+		}
+	}
+	return ""
+}
+
+// localizedDescription returns the route description for lang, falling back
+// to the primary subtag and then to the default description
+func localizedDescription(route RouteInfo, lang string) string {
+	if lang != "" {
+		if desc, ok := route.Descriptions[lang]; ok {
+			return desc
+		}
+		if base, _, found := strings.Cut(lang, "-"); found {
+			if desc, ok := route.Descriptions[base]; ok {
+				return desc
+			}
+		}
+	}
+	return route.Description
+}
+
+// localizedInfoFor returns the translated info block for lang, if any
+func localizedInfoFor(lang string) (localizedInfo, bool) {
+	openAPIMu.RLock()
+	defer openAPIMu.RUnlock()
+	info, ok := openAPIInfoLocales[lang]
+	return info, ok
+}
+
+// RegisterRouteDescription adds a localized description for a registered route
+//
+//export RegisterRouteDescription
+func RegisterRouteDescription(cPath *C.char, cMethod *C.char, cLang *C.char, cDesc *C.char) int {
+	if cPath == nil || cMethod == nil || cLang == nil || cDesc == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in RegisterRouteDescription")
+	}
+	path := C.GoString(cPath)
+	method := strings.ToUpper(C.GoString(cMethod))
+	lang := normalizeLanguage(C.GoString(cLang))
+	desc := C.GoString(cDesc)
+	if lang == "" {
+		return exportError(exportInvalid, "Empty language in RegisterRouteDescription")
+	}
+
+	routesMu.Lock()
+	key := path + method
+	route, exists := routes[key]
+	if !exists {
+		routesMu.Unlock()
+		return exportError(exportNotFound, "Cannot localize description, route not found", "key", key)
+	}
+	if route.Descriptions == nil {
+		route.Descriptions = make(map[string]string)
+	}
+	route.Descriptions[lang] = desc
+	routes[key] = route
+	routesMu.Unlock()
+
+	invalidateOpenAPICache()
+	slog.Info("Registered localized description", "lang", lang, "key", key)
+	auditConfigChange("RegisterRouteDescription", map[string]string{"path": path, "method": method, "lang": lang})
+
+	return 0
+}
+
+// ConfigureOpenAPIInfoLocalized sets the OpenAPI title and description served
+// to clients whose Accept-Language matches lang
+//
+//export ConfigureOpenAPIInfoLocalized
+func ConfigureOpenAPIInfoLocalized(cLang *C.char, cTitle *C.char, cDesc *C.char) int {
+	if cLang == nil || cTitle == nil || cDesc == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in ConfigureOpenAPIInfoLocalized")
+	}
+	lang := normalizeLanguage(C.GoString(cLang))
+	if lang == "" {
+		return exportError(exportInvalid, "Empty language in ConfigureOpenAPIInfoLocalized")
+	}
+
+	openAPIMu.Lock()
+	openAPIInfoLocales[lang] = localizedInfo{
+		Title:       C.GoString(cTitle),
+		Description: C.GoString(cDesc),
+	}
+	openAPIMu.Unlock()
+
+	invalidateOpenAPICache()
+	slog.Info("Configured localized OpenAPI info", "lang", lang)
+	auditConfigChange("ConfigureOpenAPIInfoLocalized", map[string]string{"lang": lang})
+
+	return 0
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"C"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// Trailing slash behaviours for ConfigureRouter
+const (
+	TrailingSlashStrict   = "strict"   // /foo and /foo/ are distinct routes, the default
+	TrailingSlashRedirect = "redirect" // 308 to the registered form
+	TrailingSlashRewrite  = "rewrite"  // serve the registered form directly
+)
+
+// RouterOptions configures how request paths are matched to routes
+type RouterOptions struct {
+	TrailingSlash   string `json:"trailing_slash"`   // strict (default), redirect or rewrite
+	CaseInsensitive bool   `json:"case_insensitive"` // match static path segments regardless of case
+}
+
+// activeRouterOptions is nil while paths are matched exactly
+var activeRouterOptions atomic.Pointer[RouterOptions]
+
+// canonical returns the segments with each static segment spelled as
+// registered, matching them case-insensitively, or nil if no route
+// matches. Exact spellings are preferred; parameter and wildcard segments
+// keep the request's spelling.
+func (n *routeNode) canonical(segments []string) []string {
+	if len(segments) == 0 {
+		if n.methods != nil {
+			return []string{}
+		}
+		return nil
+	}
+	seg, rest := segments[0], segments[1:]
+	if child, ok := n.static[seg]; ok {
+		if tail := child.canonical(rest); tail != nil {
+			return append([]string{seg}, tail...)
+		}
+	}
+	for name, child := range n.static {
+		if name != seg && strings.EqualFold(name, seg) {
+			if tail := child.canonical(rest); tail != nil {
+				return append([]string{name}, tail...)
+			}
+		}
+	}
+	if n.param != nil && seg != "" {
+		if tail := n.param.canonical(rest); tail != nil {
+			return append([]string{seg}, tail...)
+		}
+	}
+	if n.wildcard != nil && n.wildcard.methods != nil {
+		return segments
+	}
+	return nil
+}
+
+// normalizedPath returns the registered form of a request path under the
+// router options: the path itself when it matches a route under any
+// method, else the path with its trailing slash toggled and, when case
+// insensitive, its static segments respelled. It reports false when no
+// form matches.
+func normalizedPath(host, path string, opts *RouterOptions) (string, bool) {
+	routesMu.RLock()
+	defer routesMu.RUnlock()
+	tree := routeTreeFor(host)
+	candidates := []string{path}
+	if opts.TrailingSlash != TrailingSlashStrict && path != "/" {
+		if strings.HasSuffix(path, "/") {
+			candidates = append(candidates, strings.TrimSuffix(path, "/"))
+		} else {
+			candidates = append(candidates, path+"/")
+		}
+	}
+	for _, candidate := range candidates {
+		if tree.lookup(treeSegments(candidate)) != nil {
+			return candidate, true
+		}
+	}
+	if opts.CaseInsensitive {
+		for _, candidate := range candidates {
+			if segments := tree.canonical(treeSegments(candidate)); segments != nil {
+				return "/" + strings.Join(segments, "/"), true
+			}
+		}
+	}
+	return "", false
+}
+
+// normalizePathMiddleware maps request paths that differ from a route's
+// only by a trailing slash or letter case onto the route, redirecting or
+// rewriting as configured. Case differences are always rewritten.
+func normalizePathMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		opts := activeRouterOptions.Load()
+		if opts == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		path, ok := normalizedPath(r.Host, r.URL.Path, opts)
+		if !ok || path == r.URL.Path {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if opts.TrailingSlash == TrailingSlashRedirect && strings.HasSuffix(path, "/") != strings.HasSuffix(r.URL.Path, "/") {
+			target := *r.URL
+			target.Path, target.RawPath = path, ""
+			http.Redirect(w, r, target.RequestURI(), http.StatusPermanentRedirect)
+			return
+		}
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = path
+		r2.URL.RawPath = ""
+		next.ServeHTTP(w, r2)
+	})
+}
+
+// ConfigureRouter sets how request paths are matched to routes. cOptions
+// is a JSON RouterOptions object; empty restores exact matching. With
+// trailing_slash "redirect", a request for /foo/ when only /foo is
+// registered (or the reverse) gets a 308 to the registered path, and with
+// "rewrite" it is served directly. case_insensitive matches /Users to
+// /users. A route registered in the exact requested form always wins.
+//
+//export ConfigureRouter
+func ConfigureRouter(cOptions *C.char) int {
+	if cOptions == nil {
+		return exportError(exportInvalid, "cOptions is nil in ConfigureRouter")
+	}
+	var opts RouterOptions
+	if text := C.GoString(cOptions); text != "" {
+		if err := json.Unmarshal([]byte(text), &opts); err != nil {
+			return exportError(exportInvalid, "Invalid router options", "error", err)
+		}
+	}
+	opts.TrailingSlash = strings.ToLower(opts.TrailingSlash)
+	switch opts.TrailingSlash {
+	case "":
+		opts.TrailingSlash = TrailingSlashStrict
+	case TrailingSlashStrict, TrailingSlashRedirect, TrailingSlashRewrite:
+	default:
+		return exportError(exportInvalid, "Unknown trailing slash behaviour", "trailing_slash", opts.TrailingSlash)
+	}
+	if opts.TrailingSlash == TrailingSlashStrict && !opts.CaseInsensitive {
+		activeRouterOptions.Store(nil)
+	} else {
+		activeRouterOptions.Store(&opts)
+	}
+
+	slog.Info("Router configured", "trailing_slash", opts.TrailingSlash, "case_insensitive", opts.CaseInsensitive)
+	auditConfigChange("ConfigureRouter", map[string]string{"trailing_slash": opts.TrailingSlash, "case_insensitive": fmt.Sprint(opts.CaseInsensitive)})
+	return 0
+}
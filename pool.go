@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// maxPooledBuffer keeps unusually large responses from pinning memory in
+// the pools
+const maxPooledBuffer = 64 << 10
+
+// jsonBuffer is a response buffer with an encoder writing into it, reused
+// across requests
+type jsonBuffer struct {
+	bytes.Buffer
+	enc *json.Encoder
+}
+
+var jsonBuffers = sync.Pool{New: func() any {
+	b := &jsonBuffer{}
+	b.enc = json.NewEncoder(&b.Buffer)
+	return b
+}}
+
+func getJSONBuffer() *jsonBuffer {
+	return jsonBuffers.Get().(*jsonBuffer)
+}
+
+func putJSONBuffer(b *jsonBuffer) {
+	if b.Cap() > maxPooledBuffer {
+		return
+	}
+	b.Reset()
+	jsonBuffers.Put(b)
+}
+
+// writeJSON encodes v as json.NewEncoder(w).Encode would, newline
+// included, through a pooled buffer so the body goes out in one write
+func writeJSON(w io.Writer, v interface{}) error {
+	if e := currentJSON(); e != stdJSON {
+		out, err := e.marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append(out, '\n'))
+		return err
+	}
+	b := getJSONBuffer()
+	defer putJSONBuffer(b)
+	if err := b.enc.Encode(v); err != nil {
+		return err
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// staticRouteKey identifies a route without building its string key
+type staticRouteKey struct {
+	host, path, method string
+}
+
+// staticMessage is a static route's message encoded as a JSON string
+type staticMessage struct {
+	message string
+	encoded []byte
+}
+
+// staticMessages caches the encoded messages of static JSON routes. An
+// entry whose message no longer matches the route's is encoded again.
+var (
+	staticMessages   = make(map[staticRouteKey]staticMessage)
+	staticMessagesMu sync.RWMutex
+)
+
+// staticMessageJSON returns the route's message encoded as a JSON string
+func staticMessageJSON(route RouteInfo) []byte {
+	key := staticRouteKey{route.Host, route.Path, route.Method}
+	staticMessagesMu.RLock()
+	cached, ok := staticMessages[key]
+	staticMessagesMu.RUnlock()
+	if ok && cached.message == route.Message {
+		return cached.encoded
+	}
+	encoded, _ := json.Marshal(route.Message)
+	staticMessagesMu.Lock()
+	staticMessages[key] = staticMessage{message: route.Message, encoded: encoded}
+	staticMessagesMu.Unlock()
+	return encoded
+}
+
+// appendStaticResponse appends the ApiResponse body of a static JSON route
+// to b, byte for byte what json.Marshal produces for it, plus a newline.
+// Task IDs are generated without characters JSON would escape.
+func appendStaticResponse(b *jsonBuffer, route RouteInfo, taskID string) {
+	b.WriteString(`{"message":`)
+	b.Write(staticMessageJSON(route))
+	b.WriteString(`,"background_task":{"message":"Task started in background: `)
+	b.WriteString(taskID)
+	b.WriteString(`","task_id":"`)
+	b.WriteString(taskID)
+	b.WriteString("\"}}\n")
+}
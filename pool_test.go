@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+)
+
+var benchStaticRoute = RouteInfo{
+	Path:    "/bench/static",
+	Method:  http.MethodGet,
+	Message: `Hello, "world" <with> characters JSON escapes & more`,
+}
+
+const benchTaskID = "task-1700000000000000000"
+
+// BenchmarkStaticResponsePooled writes a static route's body the way
+// serveRouteResponse does, from a pooled buffer and the cached message
+func BenchmarkStaticResponsePooled(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf := getJSONBuffer()
+		appendStaticResponse(buf, benchStaticRoute, benchTaskID)
+		io.Discard.Write(buf.Bytes())
+		putJSONBuffer(buf)
+	}
+}
+
+// BenchmarkStaticResponseMarshal is the unpooled baseline: an ApiResponse
+// built and marshaled per request
+func BenchmarkStaticResponseMarshal(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		body, _ := json.Marshal(ApiResponse{
+			Message:        benchStaticRoute.Message,
+			BackgroundTask: TaskResponse{Message: "Task started in background: " + benchTaskID, TaskID: benchTaskID},
+		})
+		io.Discard.Write(append(body, '\n'))
+	}
+}
+
+var benchJSONValue = map[string]interface{}{
+	"status": "ok",
+	"checks": []string{"database", "cache", "queue"},
+	"uptime": 12345,
+}
+
+// BenchmarkWriteJSONPooled encodes through writeJSON's pooled encoder
+func BenchmarkWriteJSONPooled(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := writeJSON(io.Discard, benchJSONValue); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkWriteJSONEncoder is the unpooled baseline writeJSON replaced
+func BenchmarkWriteJSONEncoder(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := json.NewEncoder(io.Discard).Encode(benchJSONValue); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkStaticMessageJSON measures the cached message lookup
+func BenchmarkStaticMessageJSON(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		staticMessageJSON(benchStaticRoute)
+	}
+}
+
+// TestStaticResponseMatchesMarshal checks the pooled body is byte for byte
+// what json.Marshal produces for the route's ApiResponse
+func TestStaticResponseMatchesMarshal(t *testing.T) {
+	buf := getJSONBuffer()
+	defer putJSONBuffer(buf)
+	appendStaticResponse(buf, benchStaticRoute, benchTaskID)
+	want, _ := json.Marshal(ApiResponse{
+		Message:        benchStaticRoute.Message,
+		BackgroundTask: TaskResponse{Message: "Task started in background: " + benchTaskID, TaskID: benchTaskID},
+	})
+	if got := buf.String(); got != string(want)+"\n" {
+		t.Errorf("pooled body %q, want %q", got, want)
+	}
+}
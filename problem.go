@@ -0,0 +1,173 @@
+package main
+
+import (
+	"C"
+	"encoding/json"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// Error body formats for SetErrorFormat
+const (
+	ErrorFormatLegacy  = "legacy"  // {"error": "..."}, the default
+	ErrorFormatProblem = "problem" // RFC 7807 application/problem+json
+)
+
+// ErrorFormatOptions configures the problem format
+type ErrorFormatOptions struct {
+	// TypeBase prefixes problem type URIs, which end with the status
+	// text, e.g. https://example.com/problems/ gives .../not-found.
+	// Empty uses about:blank.
+	TypeBase string `json:"type_base"`
+}
+
+// ProblemDetails is an RFC 7807 problem body. request_id and trace_id are
+// extension members tying the response to logs and traces.
+type ProblemDetails struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+	TraceID   string `json:"trace_id,omitempty"`
+}
+
+// problemFormat is the active problem configuration
+type problemFormat struct {
+	typeBase   string
+	validation *validationFormat // used for validation failures unless SetValidationErrorFormat chose another
+}
+
+// activeProblemFormat is nil while the legacy format is used
+var activeProblemFormat atomic.Pointer[problemFormat]
+
+// problemErrorsEnabled reports whether error bodies are problem+json
+func problemErrorsEnabled() bool {
+	return activeProblemFormat.Load() != nil
+}
+
+// problemType is the type URI of a status under base
+func problemType(base string, status int) string {
+	if base == "" {
+		return "about:blank"
+	}
+	return base + strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "-")
+}
+
+// problem describes the error as a problem of type typeURI
+func (ctx ErrorContext) problem(typeURI string) ProblemDetails {
+	p := ProblemDetails{
+		Type:      typeURI,
+		Title:     http.StatusText(ctx.Status),
+		Status:    ctx.Status,
+		Instance:  ctx.Path,
+		RequestID: ctx.RequestID,
+		TraceID:   ctx.TraceID,
+	}
+	if ctx.Error != p.Title {
+		p.Detail = ctx.Error
+	}
+	return p
+}
+
+// isProblemResponse reports whether a response is already problem+json,
+// so the error page writer leaves it alone
+func isProblemResponse(h http.Header) bool {
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	return mediaType == "application/problem+json"
+}
+
+// problemResponse builds the problem+json replacement for an error
+func problemResponse(ctx ErrorContext) (HandlerResponse, bool) {
+	f := activeProblemFormat.Load()
+	if f == nil {
+		return HandlerResponse{}, false
+	}
+	body, err := json.Marshal(ctx.problem(problemType(f.typeBase, ctx.Status)))
+	if err != nil {
+		return HandlerResponse{}, false
+	}
+	return HandlerResponse{
+		Status:  ctx.Status,
+		Headers: map[string]string{"Content-Type": "application/problem+json"},
+		Body:    string(body),
+	}, true
+}
+
+// errorContentType is the media type documented for a server error status
+func errorContentType(status int) string {
+	if status == http.StatusUnprocessableEntity {
+		return validationErrorContentType()
+	}
+	if problemErrorsEnabled() {
+		return "application/problem+json"
+	}
+	return "application/json"
+}
+
+var problemDetailsSchema = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"type":       map[string]interface{}{"type": "string"},
+		"title":      map[string]interface{}{"type": "string"},
+		"status":     map[string]interface{}{"type": "integer"},
+		"detail":     map[string]interface{}{"type": "string"},
+		"instance":   map[string]interface{}{"type": "string"},
+		"request_id": map[string]interface{}{"type": "string"},
+		"trace_id":   map[string]interface{}{"type": "string"},
+	},
+	"required": []string{"type", "title", "status"},
+}
+
+// SetErrorFormat chooses the body of the server's own error responses:
+// "legacy" for {"error": "..."} or "problem" for RFC 7807
+// application/problem+json with type, title, status, detail, instance,
+// request_id, and, when tracing is on, trace_id. It covers 404s, 405s,
+// recovered panics, timeouts, rejected requests, and validation failures,
+// which keep their errors list; host handler and proxied responses are
+// left as they are, and pages from RegisterErrorHandler and
+// RegisterErrorPage take precedence. cOptions is a JSON ErrorFormatOptions
+// object and may be empty. Returns 0, or a negative status if the format
+// is unknown.
+//
+//export SetErrorFormat
+func SetErrorFormat(cFormat *C.char, cOptions *C.char) int {
+	if cFormat == nil || cOptions == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in SetErrorFormat")
+	}
+	name := strings.ToLower(C.GoString(cFormat))
+	var opts ErrorFormatOptions
+	if text := C.GoString(cOptions); text != "" {
+		if err := json.Unmarshal([]byte(text), &opts); err != nil {
+			return exportError(exportInvalid, "Invalid error format options", "format", name, "error", err)
+		}
+	}
+	switch name {
+	case "", ErrorFormatLegacy:
+		name = ErrorFormatLegacy
+		activeProblemFormat.Store(nil)
+	case ErrorFormatProblem:
+		if strings.ContainsAny(opts.TypeBase, " \r\n\x00") {
+			return exportError(exportInvalid, "Invalid problem type base", "type_base", opts.TypeBase)
+		}
+		activeProblemFormat.Store(&problemFormat{
+			typeBase: opts.TypeBase,
+			validation: &validationFormat{
+				problemType: problemType(opts.TypeBase, http.StatusUnprocessableEntity),
+				title:       http.StatusText(http.StatusUnprocessableEntity),
+				contentType: "application/problem+json",
+			},
+		})
+	default:
+		return exportError(exportInvalid, "Unknown error format", "format", name)
+	}
+	invalidateOpenAPICache()
+
+	slog.Info("Error format set", "format", name, "type_base", opts.TypeBase)
+	auditConfigChange("SetErrorFormat", map[string]string{"format": name, "type_base": opts.TypeBase})
+	return 0
+}
@@ -0,0 +1,585 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Well-known types with their own JSON form. Struct, Value, and Any are not
+// supported.
+const (
+	wktTimestamp = "google.protobuf.Timestamp"
+	wktDuration  = "google.protobuf.Duration"
+)
+
+// wrapperTypes are the google.protobuf wrappers, sent in JSON as their value
+var wrapperTypes = map[string]int{
+	"google.protobuf.DoubleValue": protoTypeDouble,
+	"google.protobuf.FloatValue":  protoTypeFloat,
+	"google.protobuf.Int64Value":  protoTypeInt64,
+	"google.protobuf.UInt64Value": protoTypeUint64,
+	"google.protobuf.Int32Value":  protoTypeInt32,
+	"google.protobuf.UInt32Value": protoTypeUint32,
+	"google.protobuf.BoolValue":   protoTypeBool,
+	"google.protobuf.StringValue": protoTypeString,
+	"google.protobuf.BytesValue":  protoTypeBytes,
+}
+
+var unsupportedTypes = map[string]bool{
+	"google.protobuf.Any":       true,
+	"google.protobuf.Struct":    true,
+	"google.protobuf.Value":     true,
+	"google.protobuf.ListValue": true,
+}
+
+func appendTag(buf []byte, num int, wire int) []byte {
+	return binary.AppendUvarint(buf, uint64(num)<<3|uint64(wire))
+}
+
+func appendBytesField(buf []byte, num int, payload []byte) []byte {
+	buf = appendTag(buf, num, wireBytes)
+	buf = binary.AppendUvarint(buf, uint64(len(payload)))
+	return append(buf, payload...)
+}
+
+// encodeMessage encodes a proto3 JSON value, decoded with UseNumber, as a
+// message of the named type. Path and query values arrive as strings, which
+// proto3 JSON accepts for every scalar.
+func (d *descriptorSet) encodeMessage(name string, value interface{}) ([]byte, error) {
+	switch {
+	case unsupportedTypes[name]:
+		return nil, fmt.Errorf("%s is not supported", name)
+	case name == wktTimestamp:
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("timestamp must be an RFC 3339 string")
+		}
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp %q", s)
+		}
+		return encodeSecondsNanos(t.Unix(), int64(t.Nanosecond())), nil
+	case name == wktDuration:
+		s, ok := value.(string)
+		if !ok || !strings.HasSuffix(s, "s") {
+			return nil, fmt.Errorf("duration must be a string such as \"1.5s\"")
+		}
+		dur, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration %q", s)
+		}
+		return encodeSecondsNanos(int64(dur/time.Second), int64(dur%time.Second)), nil
+	}
+	if kind, ok := wrapperTypes[name]; ok {
+		if value == nil {
+			return nil, nil
+		}
+		return d.appendValue(nil, &protoField{Name: "value", Number: 1, Type: kind}, value)
+	}
+
+	m, ok := d.messages[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown message type %s", name)
+	}
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s must be a JSON object", name)
+	}
+	var buf []byte
+	for key, v := range object {
+		f, ok := m.field(key)
+		if !ok {
+			return nil, fmt.Errorf("unknown field %q in %s", key, name)
+		}
+		if v == nil {
+			continue
+		}
+		var err error
+		switch {
+		case d.isMap(f):
+			buf, err = d.appendMap(buf, f, v)
+		case f.Repeated:
+			items, isList := v.([]interface{})
+			if !isList {
+				items = []interface{}{v}
+			}
+			for _, item := range items {
+				if buf, err = d.appendValue(buf, f, item); err != nil {
+					break
+				}
+			}
+		default:
+			if _, isList := v.([]interface{}); isList {
+				return nil, fmt.Errorf("field %q does not repeat", key)
+			}
+			buf, err = d.appendValue(buf, f, v)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %w", key, err)
+		}
+	}
+	return buf, nil
+}
+
+func encodeSecondsNanos(seconds, nanos int64) []byte {
+	var buf []byte
+	if seconds != 0 {
+		buf = binary.AppendUvarint(appendTag(buf, 1, wireVarint), uint64(seconds))
+	}
+	if nanos != 0 {
+		buf = binary.AppendUvarint(appendTag(buf, 2, wireVarint), uint64(nanos))
+	}
+	return buf
+}
+
+func (d *descriptorSet) appendMap(buf []byte, f *protoField, v interface{}) ([]byte, error) {
+	object, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("map must be a JSON object")
+	}
+	entry := d.messages[f.TypeName]
+	keyField, valueField := entry.byNumber[1], entry.byNumber[2]
+	if keyField == nil || valueField == nil {
+		return nil, fmt.Errorf("malformed map entry %s", f.TypeName)
+	}
+	for key, value := range object {
+		payload, err := d.appendValue(nil, keyField, key)
+		if err != nil {
+			return nil, err
+		}
+		if value != nil {
+			if payload, err = d.appendValue(payload, valueField, value); err != nil {
+				return nil, err
+			}
+		}
+		buf = appendBytesField(buf, f.Number, payload)
+	}
+	return buf, nil
+}
+
+// appendValue encodes a single value of a field
+func (d *descriptorSet) appendValue(buf []byte, f *protoField, v interface{}) ([]byte, error) {
+	switch f.Type {
+	case protoTypeMessage:
+		payload, err := d.encodeMessage(f.TypeName, v)
+		if err != nil {
+			return nil, err
+		}
+		return appendBytesField(buf, f.Number, payload), nil
+	case protoTypeString:
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected a string")
+		}
+		return appendBytesField(buf, f.Number, []byte(s)), nil
+	case protoTypeBytes:
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected a base64 string")
+		}
+		data, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			if data, err = base64.URLEncoding.DecodeString(s); err != nil {
+				return nil, fmt.Errorf("invalid base64")
+			}
+		}
+		return appendBytesField(buf, f.Number, data), nil
+	case protoTypeBool:
+		var b bool
+		switch t := v.(type) {
+		case bool:
+			b = t
+		case string:
+			var err error
+			if b, err = strconv.ParseBool(t); err != nil {
+				return nil, fmt.Errorf("expected a boolean")
+			}
+		default:
+			return nil, fmt.Errorf("expected a boolean")
+		}
+		var n uint64
+		if b {
+			n = 1
+		}
+		return binary.AppendUvarint(appendTag(buf, f.Number, wireVarint), n), nil
+	case protoTypeEnum:
+		n, err := d.enumNumber(f.TypeName, v)
+		if err != nil {
+			return nil, err
+		}
+		return binary.AppendUvarint(appendTag(buf, f.Number, wireVarint), uint64(int64(n))), nil
+	case protoTypeDouble, protoTypeFloat:
+		x, err := jsonFloat(v)
+		if err != nil {
+			return nil, err
+		}
+		if f.Type == protoTypeFloat {
+			return binary.LittleEndian.AppendUint32(appendTag(buf, f.Number, wireFixed32), math.Float32bits(float32(x))), nil
+		}
+		return binary.LittleEndian.AppendUint64(appendTag(buf, f.Number, wireFixed64), math.Float64bits(x)), nil
+	case protoTypeGroup:
+		return nil, fmt.Errorf("groups are not supported")
+	}
+
+	signed := f.Type == protoTypeInt32 || f.Type == protoTypeInt64 || f.Type == protoTypeSint32 || f.Type == protoTypeSint64 || f.Type == protoTypeSfixed32 || f.Type == protoTypeSfixed64
+	bits := 64
+	if f.Type == protoTypeInt32 || f.Type == protoTypeUint32 || f.Type == protoTypeSint32 || f.Type == protoTypeFixed32 || f.Type == protoTypeSfixed32 {
+		bits = 32
+	}
+	var s string
+	switch t := v.(type) {
+	case json.Number:
+		s = t.String()
+	case string:
+		s = t
+	default:
+		return nil, fmt.Errorf("expected an integer")
+	}
+	var n uint64
+	if signed {
+		i, err := strconv.ParseInt(s, 10, bits)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer %q", s)
+		}
+		n = uint64(i)
+		if f.Type == protoTypeSint32 || f.Type == protoTypeSint64 {
+			n = uint64(i<<1) ^ uint64(i>>63)
+		}
+	} else {
+		u, err := strconv.ParseUint(s, 10, bits)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer %q", s)
+		}
+		n = u
+	}
+	switch f.Type {
+	case protoTypeFixed32, protoTypeSfixed32:
+		return binary.LittleEndian.AppendUint32(appendTag(buf, f.Number, wireFixed32), uint32(n)), nil
+	case protoTypeFixed64, protoTypeSfixed64:
+		return binary.LittleEndian.AppendUint64(appendTag(buf, f.Number, wireFixed64), n), nil
+	}
+	return binary.AppendUvarint(appendTag(buf, f.Number, wireVarint), n), nil
+}
+
+func jsonFloat(v interface{}) (float64, error) {
+	var s string
+	switch t := v.(type) {
+	case json.Number:
+		s = t.String()
+	case string:
+		s = t
+	default:
+		return 0, fmt.Errorf("expected a number")
+	}
+	switch s {
+	case "NaN":
+		return math.NaN(), nil
+	case "Infinity":
+		return math.Inf(1), nil
+	case "-Infinity":
+		return math.Inf(-1), nil
+	}
+	x, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	return x, nil
+}
+
+func (d *descriptorSet) enumNumber(name string, v interface{}) (int32, error) {
+	e, ok := d.enums[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown enum type %s", name)
+	}
+	switch t := v.(type) {
+	case string:
+		if n, ok := e.numbers[t]; ok {
+			return n, nil
+		}
+		if n, err := strconv.ParseInt(t, 10, 32); err == nil {
+			return int32(n), nil
+		}
+		return 0, fmt.Errorf("unknown %s value %q", name, t)
+	case json.Number:
+		n, err := strconv.ParseInt(t.String(), 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid enum number %s", t)
+		}
+		return int32(n), nil
+	}
+	return 0, fmt.Errorf("expected an enum name")
+}
+
+// decodeMessage decodes a message of the named type into its proto3 JSON
+// form. Fields left at their default are omitted.
+func (d *descriptorSet) decodeMessage(name string, data []byte) (interface{}, error) {
+	switch {
+	case unsupportedTypes[name]:
+		return nil, fmt.Errorf("%s is not supported", name)
+	case name == wktTimestamp || name == wktDuration:
+		var seconds, nanos int64
+		if err := protoFields(data, func(num int, _ int, v uint64, _ []byte) error {
+			switch num {
+			case 1:
+				seconds = int64(v)
+			case 2:
+				nanos = int64(int32(v))
+			}
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+		if name == wktTimestamp {
+			return time.Unix(seconds, nanos).UTC().Format(time.RFC3339Nano), nil
+		}
+		return strconv.FormatFloat((time.Duration(seconds)*time.Second+time.Duration(nanos)).Seconds(), 'f', -1, 64) + "s", nil
+	}
+	if kind, ok := wrapperTypes[name]; ok {
+		f := &protoField{Name: "value", Number: 1, Type: kind}
+		var value interface{} = zeroJSON(kind)
+		err := protoFields(data, func(num int, wire int, v uint64, payload []byte) error {
+			if num == 1 {
+				var err error
+				value, err = d.decodeValue(f, wire, v, payload)
+				return err
+			}
+			return nil
+		})
+		return value, err
+	}
+
+	m, ok := d.messages[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown message type %s", name)
+	}
+	object := make(map[string]interface{})
+	err := protoFields(data, func(num int, wire int, v uint64, payload []byte) error {
+		f, ok := m.byNumber[num]
+		if !ok {
+			return nil // unknown fields are dropped
+		}
+		switch {
+		case d.isMap(f):
+			entries, _ := object[f.JSONName].(map[string]interface{})
+			if entries == nil {
+				entries = make(map[string]interface{})
+				object[f.JSONName] = entries
+			}
+			return d.decodeMapEntry(f, payload, entries)
+		case f.Repeated && wire == wireBytes && f.Type != protoTypeString && f.Type != protoTypeBytes && f.Type != protoTypeMessage:
+			// Packed scalars
+			items, _ := object[f.JSONName].([]interface{})
+			for len(payload) > 0 {
+				var n int
+				switch f.Type {
+				case protoTypeDouble, protoTypeFixed64, protoTypeSfixed64:
+					if len(payload) < 8 {
+						return errProtoTruncated
+					}
+					v, n = binary.LittleEndian.Uint64(payload), 8
+				case protoTypeFloat, protoTypeFixed32, protoTypeSfixed32:
+					if len(payload) < 4 {
+						return errProtoTruncated
+					}
+					v, n = uint64(binary.LittleEndian.Uint32(payload)), 4
+				default:
+					if v, n = binary.Uvarint(payload); n <= 0 {
+						return errProtoTruncated
+					}
+				}
+				payload = payload[n:]
+				item, err := d.decodeValue(f, wireVarint, v, nil)
+				if err != nil {
+					return err
+				}
+				items = append(items, item)
+			}
+			object[f.JSONName] = items
+		default:
+			value, err := d.decodeValue(f, wire, v, payload)
+			if err != nil {
+				return err
+			}
+			if f.Repeated {
+				items, _ := object[f.JSONName].([]interface{})
+				object[f.JSONName] = append(items, value)
+			} else {
+				object[f.JSONName] = value
+			}
+		}
+		return nil
+	})
+	return object, err
+}
+
+func (d *descriptorSet) decodeMapEntry(f *protoField, data []byte, entries map[string]interface{}) error {
+	entry := d.messages[f.TypeName]
+	keyField, valueField := entry.byNumber[1], entry.byNumber[2]
+	if keyField == nil || valueField == nil {
+		return fmt.Errorf("malformed map entry %s", f.TypeName)
+	}
+	var key, value interface{} = zeroJSON(keyField.Type), zeroJSON(valueField.Type)
+	err := protoFields(data, func(num int, wire int, v uint64, payload []byte) error {
+		var err error
+		switch num {
+		case 1:
+			key, err = d.decodeValue(keyField, wire, v, payload)
+		case 2:
+			value, err = d.decodeValue(valueField, wire, v, payload)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if value == nil && valueField.Type == protoTypeMessage {
+		value = map[string]interface{}{}
+	}
+	entries[fmt.Sprint(key)] = value
+	return nil
+}
+
+// zeroJSON is the JSON form of a scalar's default value
+func zeroJSON(kind int) interface{} {
+	switch kind {
+	case protoTypeString, protoTypeBytes:
+		return ""
+	case protoTypeBool:
+		return false
+	case protoTypeInt64, protoTypeUint64, protoTypeFixed64, protoTypeSfixed64, protoTypeSint64:
+		return "0"
+	case protoTypeMessage:
+		return nil
+	}
+	return 0
+}
+
+// decodeValue decodes one field value into its JSON form. 64-bit integers
+// are strings, as proto3 JSON requires.
+func (d *descriptorSet) decodeValue(f *protoField, wire int, v uint64, payload []byte) (interface{}, error) {
+	if (wire == wireBytes) != (f.Type == protoTypeString || f.Type == protoTypeBytes || f.Type == protoTypeMessage) {
+		return nil, fmt.Errorf("field %s has wire type %d", f.Name, wire)
+	}
+	switch f.Type {
+	case protoTypeMessage:
+		return d.decodeMessage(f.TypeName, payload)
+	case protoTypeString:
+		return string(payload), nil
+	case protoTypeBytes:
+		return base64.StdEncoding.EncodeToString(payload), nil
+	case protoTypeBool:
+		return v != 0, nil
+	case protoTypeEnum:
+		if e, ok := d.enums[f.TypeName]; ok {
+			if name, ok := e.names[int32(v)]; ok {
+				return name, nil
+			}
+		}
+		return int32(v), nil
+	case protoTypeDouble:
+		return jsonFloatValue(math.Float64frombits(v)), nil
+	case protoTypeFloat:
+		return jsonFloatValue(float64(math.Float32frombits(uint32(v)))), nil
+	case protoTypeInt32, protoTypeSfixed32:
+		return int32(v), nil
+	case protoTypeUint32, protoTypeFixed32:
+		return uint32(v), nil
+	case protoTypeSint32:
+		return int32(uint32(v)>>1) ^ -int32(v&1), nil
+	case protoTypeInt64, protoTypeSfixed64:
+		return strconv.FormatInt(int64(v), 10), nil
+	case protoTypeUint64, protoTypeFixed64:
+		return strconv.FormatUint(v, 10), nil
+	case protoTypeSint64:
+		return strconv.FormatInt(int64(v>>1)^-int64(v&1), 10), nil
+	}
+	return nil, fmt.Errorf("field %s has unsupported type %d", f.Name, f.Type)
+}
+
+// jsonFloatValue spells out the floats JSON numbers cannot hold
+func jsonFloatValue(x float64) interface{} {
+	switch {
+	case math.IsNaN(x):
+		return "NaN"
+	case math.IsInf(x, 1):
+		return "Infinity"
+	case math.IsInf(x, -1):
+		return "-Infinity"
+	}
+	return x
+}
+
+// messageSchemas publishes the JSON Schema of a message type, and of every
+// type it refers to, under its full name
+func (d *descriptorSet) messageSchemas(name string, schemas map[string]map[string]interface{}) {
+	m, ok := d.messages[name]
+	if !ok || schemas[name] != nil {
+		return
+	}
+	properties := make(map[string]interface{}, len(m.Fields))
+	schemas[name] = map[string]interface{}{"type": "object", "properties": properties}
+	for _, f := range m.Fields {
+		properties[f.JSONName] = d.fieldSchema(f, schemas)
+	}
+}
+
+func (d *descriptorSet) fieldSchema(f *protoField, schemas map[string]map[string]interface{}) map[string]interface{} {
+	if d.isMap(f) {
+		entry := d.messages[f.TypeName]
+		value := map[string]interface{}{}
+		if valueField := entry.byNumber[2]; valueField != nil {
+			value = d.fieldSchema(valueField, schemas)
+		}
+		return map[string]interface{}{"type": "object", "additionalProperties": value}
+	}
+	var schema map[string]interface{}
+	switch f.Type {
+	case protoTypeMessage:
+		switch {
+		case f.TypeName == wktTimestamp:
+			schema = map[string]interface{}{"type": "string", "format": "date-time"}
+		case f.TypeName == wktDuration:
+			schema = map[string]interface{}{"type": "string", "example": "1.5s"}
+		case wrapperTypes[f.TypeName] != 0:
+			schema = scalarSchema(wrapperTypes[f.TypeName])
+		case unsupportedTypes[f.TypeName]:
+			schema = map[string]interface{}{}
+		default:
+			d.messageSchemas(f.TypeName, schemas)
+			schema = schemaRef(f.TypeName)
+		}
+	case protoTypeEnum:
+		schema = map[string]interface{}{"type": "string"}
+		if e, ok := d.enums[f.TypeName]; ok {
+			schema["enum"] = e.Values
+		}
+	default:
+		schema = scalarSchema(f.Type)
+	}
+	if f.Repeated {
+		return map[string]interface{}{"type": "array", "items": schema}
+	}
+	return schema
+}
+
+func scalarSchema(kind int) map[string]interface{} {
+	switch kind {
+	case protoTypeString:
+		return map[string]interface{}{"type": "string"}
+	case protoTypeBytes:
+		return map[string]interface{}{"type": "string", "format": "byte"}
+	case protoTypeBool:
+		return map[string]interface{}{"type": "boolean"}
+	case protoTypeDouble, protoTypeFloat:
+		return map[string]interface{}{"type": "number"}
+	case protoTypeInt64, protoTypeUint64, protoTypeFixed64, protoTypeSfixed64, protoTypeSint64:
+		return map[string]interface{}{"type": "string", "format": "int64"}
+	}
+	return map[string]interface{}{"type": "integer", "format": "int32"}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"C"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultProxyTimeout = 30 * time.Second
+	proxyRetryBackoff   = 50 * time.Millisecond
+)
+
+// ProxyOptions configures how a proxy route forwards to its upstream
+type ProxyOptions struct {
+	StripPrefix           *bool             `json:"strip_prefix"` // drop the route prefix from the upstream path; defaults to true
+	PathPrefix            string            `json:"path_prefix"`  // prepended to the upstream path
+	PreserveHost          bool              `json:"preserve_host"`
+	SetHeaders            map[string]string `json:"set_headers"` // request headers set before forwarding
+	RemoveHeaders         []string          `json:"remove_headers"`
+	ResponseHeaders       map[string]string `json:"response_headers"` // set on the upstream response
+	RemoveResponseHeaders []string          `json:"remove_response_headers"`
+	TimeoutMs             int               `json:"timeout_ms"` // connect and response header timeout per attempt; defaults to 30000
+	Retries               int               `json:"retries"`    // extra attempts for bodiless idempotent requests
+	RetryOn               []int             `json:"retry_on"`   // upstream statuses retried; defaults to 502, 503, 504
+	FlushIntervalMs       int               `json:"flush_interval_ms"`
+	CircuitBreaker        *BreakerOptions   `json:"circuit_breaker"` // fails fast with 503 while the upstream keeps failing
+}
+
+// proxyRoute forwards every request under prefix to an upstream
+type proxyRoute struct {
+	prefix   string // without a trailing slash, except for "/"
+	upstream *url.URL
+	opts     ProxyOptions
+	handler  *httputil.ReverseProxy
+	breaker  *circuitBreaker // nil unless the options ask for one
+}
+
+var (
+	proxyRoutes   []*proxyRoute // longest prefix first
+	proxyRoutesMu sync.RWMutex
+)
+
+// idempotentMethods may be retried when they carry no body
+var idempotentMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodOptions: true,
+	http.MethodPut: true, http.MethodDelete: true, http.MethodTrace: true,
+}
+
+func (p *proxyRoute) matches(urlPath string) bool {
+	return p.prefix == "/" || urlPath == p.prefix || strings.HasPrefix(urlPath, p.prefix+"/")
+}
+
+// findProxyRoute returns the proxy route serving urlPath, if any
+func findProxyRoute(urlPath string) (*proxyRoute, bool) {
+	proxyRoutesMu.RLock()
+	defer proxyRoutesMu.RUnlock()
+	for _, p := range proxyRoutes {
+		if p.matches(urlPath) {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// mountPrefixFor returns the prefix, ending in /, of the proxy route or
+// static directory that would serve urlPath
+func mountPrefixFor(urlPath string) (string, bool) {
+	if p, ok := findProxyRoute(urlPath); ok {
+		return strings.TrimSuffix(p.prefix, "/") + "/", true
+	}
+	if dir, ok := findStaticDir(urlPath); ok {
+		return dir.prefix, true
+	}
+	return "", false
+}
+
+// serveProxy forwards r to a registered upstream. It returns false if no
+// proxy route covers the path.
+func serveProxy(w http.ResponseWriter, r *http.Request) bool {
+	p, ok := findProxyRoute(r.URL.Path)
+	if !ok {
+		return false
+	}
+	if info := requestInfoFrom(r); info != nil {
+		info.Route = p.prefix
+	}
+	slog.Debug("Proxying request", "prefix", p.prefix, "upstream", p.upstream.Redacted(), "path", r.URL.Path)
+	// Upstream error responses are passed on as they are
+	setErrorPassthrough(w, true)
+	p.handler.ServeHTTP(w, r)
+	return true
+}
+
+// rewrite builds the outbound request
+func (p *proxyRoute) rewrite(pr *httputil.ProxyRequest) {
+	out := pr.Out
+	if p.opts.StripPrefix == nil || *p.opts.StripPrefix {
+		out.URL.Path = strings.TrimPrefix(out.URL.Path, strings.TrimSuffix(p.prefix, "/"))
+		out.URL.RawPath = ""
+		if !strings.HasPrefix(out.URL.Path, "/") {
+			out.URL.Path = "/" + out.URL.Path
+		}
+	}
+	if p.opts.PathPrefix != "" {
+		out.URL.Path = strings.TrimSuffix(p.opts.PathPrefix, "/") + out.URL.Path
+	}
+	pr.SetURL(p.upstream)
+	pr.SetXForwarded()
+	if p.opts.PreserveHost {
+		out.Host = pr.In.Host
+	}
+	for _, name := range p.opts.RemoveHeaders {
+		out.Header.Del(name)
+	}
+	for name, value := range p.opts.SetHeaders {
+		out.Header.Set(name, value)
+	}
+	if info := requestInfoFrom(pr.In); info != nil {
+		out.Header.Set(requestIDConfig.Load().header, info.ID)
+	}
+}
+
+// modifyResponse applies the configured response header changes
+func (p *proxyRoute) modifyResponse(resp *http.Response) error {
+	for _, name := range p.opts.RemoveResponseHeaders {
+		resp.Header.Del(name)
+	}
+	for name, value := range p.opts.ResponseHeaders {
+		resp.Header.Set(name, value)
+	}
+	return nil
+}
+
+// proxyError answers with 503 while the circuit breaker is open, 504 when
+// the upstream timed out, and 502 otherwise
+func (p *proxyRoute) proxyError(w http.ResponseWriter, r *http.Request, err error) {
+	setErrorPassthrough(w, false)
+	var open *circuitOpenError
+	if errors.As(err, &open) {
+		slog.Debug("Upstream circuit open", "prefix", p.prefix, "upstream", p.upstream.Redacted())
+		w.Header().Set("Retry-After", retryAfterSeconds(open.retryIn))
+		http.Error(w, `{"error": "Upstream unavailable"}`, http.StatusServiceUnavailable)
+		return
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		slog.Warn("Upstream timed out", "prefix", p.prefix, "upstream", p.upstream.Redacted(), "error", err)
+		http.Error(w, `{"error": "Upstream timed out"}`, http.StatusGatewayTimeout)
+		return
+	}
+	if errors.Is(err, context.Canceled) && r.Context().Err() != nil {
+		// The client went away; there is no one to answer
+		return
+	}
+	slog.Warn("Upstream request failed", "prefix", p.prefix, "upstream", p.upstream.Redacted(), "error", err)
+	http.Error(w, `{"error": "Bad gateway"}`, http.StatusBadGateway)
+}
+
+// retryTransport retries bodiless idempotent requests on connection
+// errors and the configured upstream statuses, backing off between attempts
+type retryTransport struct {
+	base    http.RoundTripper
+	retries int
+	retryOn map[int]bool
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	retryable := idempotentMethods[req.Method] && (req.Body == nil || req.Body == http.NoBody)
+	backoff := proxyRetryBackoff
+	for attempt := 0; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+		if !retryable || attempt >= t.retries || (err == nil && !t.retryOn[resp.StatusCode]) {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		slog.Debug("Retrying upstream request", "url", req.URL.Redacted(), "attempt", attempt+1, "error", err)
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// newProxyRoute builds the forwarding handler for an upstream
+func newProxyRoute(prefix string, upstream *url.URL, opts ProxyOptions) *proxyRoute {
+	timeout := defaultProxyTimeout
+	if opts.TimeoutMs > 0 {
+		timeout = time.Duration(opts.TimeoutMs) * time.Millisecond
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.ResponseHeaderTimeout = timeout
+	retryOn := map[int]bool{http.StatusBadGateway: true, http.StatusServiceUnavailable: true, http.StatusGatewayTimeout: true}
+	if len(opts.RetryOn) > 0 {
+		retryOn = make(map[int]bool, len(opts.RetryOn))
+		for _, code := range opts.RetryOn {
+			retryOn[code] = true
+		}
+	}
+
+	p := &proxyRoute{prefix: prefix, upstream: upstream, opts: opts}
+	// The breaker sees one outcome per request, after its retries
+	var roundTripper http.RoundTripper = &retryTransport{base: newTracingTransport(transport), retries: opts.Retries, retryOn: retryOn}
+	if opts.CircuitBreaker != nil {
+		p.breaker = newCircuitBreaker("proxy:"+prefix, *opts.CircuitBreaker)
+		roundTripper = &breakerTransport{base: roundTripper, breaker: p.breaker}
+	}
+	p.handler = &httputil.ReverseProxy{
+		Rewrite:        p.rewrite,
+		Transport:      roundTripper,
+		FlushInterval:  time.Duration(opts.FlushIntervalMs) * time.Millisecond,
+		ModifyResponse: p.modifyResponse,
+		ErrorHandler:   p.proxyError,
+		ErrorLog:       slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+	}
+	return p
+}
+
+// RegisterProxyRoute forwards requests under cPrefix that no local route
+// handles to cUpstreamURL, streaming bodies both ways; catch-all routes
+// with a shorter prefix do not count. cOptions is a JSON ProxyOptions
+// object and may be empty. Re-registering a prefix replaces it.
+//
+//export RegisterProxyRoute
+func RegisterProxyRoute(cPrefix *C.char, cUpstreamURL *C.char, cOptions *C.char) int {
+	if cPrefix == nil || cUpstreamURL == nil || cOptions == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in RegisterProxyRoute")
+	}
+	prefix := C.GoString(cPrefix)
+	rawUpstream := C.GoString(cUpstreamURL)
+	options := C.GoString(cOptions)
+	if !strings.HasPrefix(prefix, "/") {
+		return exportError(exportInvalid, "Proxy prefix must start with /", "prefix", prefix)
+	}
+	if prefix != "/" {
+		prefix = strings.TrimSuffix(prefix, "/")
+	}
+	upstream, err := url.Parse(rawUpstream)
+	if err != nil || (upstream.Scheme != "http" && upstream.Scheme != "https") || upstream.Host == "" {
+		return exportError(exportInvalid, "Invalid proxy upstream URL", "prefix", prefix, "upstream", rawUpstream)
+	}
+	var opts ProxyOptions
+	if options != "" {
+		if err := json.Unmarshal([]byte(options), &opts); err != nil {
+			return exportError(exportInvalid, "Invalid proxy options", "prefix", prefix, "error", err)
+		}
+	}
+	if opts.TimeoutMs < 0 || opts.Retries < 0 {
+		return exportError(exportInvalid, "Invalid proxy options", "prefix", prefix, "timeout_ms", opts.TimeoutMs, "retries", opts.Retries)
+	}
+	if opts.CircuitBreaker != nil {
+		if err := opts.CircuitBreaker.validate(); err != nil {
+			return exportError(exportInvalid, "Invalid proxy options", "prefix", prefix, "error", err)
+		}
+	}
+	route := newProxyRoute(prefix, upstream, opts)
+
+	proxyRoutesMu.Lock()
+	mounts := proxyRoutes[:0:0]
+	for _, p := range proxyRoutes {
+		if p.prefix != prefix {
+			mounts = append(mounts, p)
+		}
+	}
+	mounts = append(mounts, route)
+	sort.SliceStable(mounts, func(i, j int) bool { return len(mounts[i].prefix) > len(mounts[j].prefix) })
+	proxyRoutes = mounts
+	proxyRoutesMu.Unlock()
+	if route.breaker != nil {
+		registerBreaker(route.breaker)
+	} else {
+		removeBreaker("proxy:" + prefix)
+	}
+
+	slog.Info("Proxy route registered", "prefix", prefix, "upstream", upstream.Redacted(), "retries", opts.Retries)
+	// Injected headers often carry upstream credentials
+	auditConfigChange("RegisterProxyRoute", map[string]string{
+		"prefix":      prefix,
+		"upstream":    upstream.Redacted(),
+		"set_headers": strings.Join(sortedKeys(opts.SetHeaders), ","),
+		"retries":     strconv.Itoa(opts.Retries),
+	})
+
+	return 0
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"C"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+type queryParamsKey struct{}
+
+// QueryParam declares a typed query parameter for RegisterQueryParams
+type QueryParam struct {
+	Name        string      `json:"name"`
+	Type        string      `json:"type"` // int, float, bool, string, or enum
+	Description string      `json:"description"`
+	Required    bool        `json:"required"`
+	Default     interface{} `json:"default"`
+	Enum        []string    `json:"enum"` // allowed values for enum parameters
+}
+
+// queryTypeAliases accepts OpenAPI type names alongside the short tags
+var queryTypeAliases = map[string]string{
+	"int": "int", "integer": "int",
+	"float": "float", "number": "float",
+	"bool": "bool", "boolean": "bool",
+	"string": "string", "str": "string",
+	"enum": "enum",
+}
+
+// openAPITypes maps parameter type tags to OpenAPI schema types
+var openAPITypes = map[string]string{"int": "integer", "float": "number", "bool": "boolean", "string": "string", "enum": "string"}
+
+// MarshalJSON writes the parameter in OpenAPI 3 form, with the type, enum,
+// and default under "schema"
+func (p ParameterInfo) MarshalJSON() ([]byte, error) {
+	schema := map[string]interface{}{"type": openAPITypes[p.Type]}
+	if schema["type"] == "" {
+		schema["type"] = "string"
+	}
+	if len(p.Enum) > 0 {
+		schema["enum"] = p.Enum
+	}
+	if p.Default != nil {
+		schema["default"] = p.Default
+	}
+	param := map[string]interface{}{
+		"name":     p.Name,
+		"in":       p.In,
+		"required": p.Required,
+		"schema":   schema,
+	}
+	if p.Description != "" {
+		param["description"] = p.Description
+	}
+	return json.Marshal(param)
+}
+
+// parameterInfo validates the declaration and converts it for the route
+func (q QueryParam) parameterInfo() (ParameterInfo, error) {
+	return q.declare("query", "query parameter")
+}
+
+// declare converts the declaration for a value found in the given part of
+// the request; label names it in errors
+func (q QueryParam) declare(in, label string) (ParameterInfo, error) {
+	if q.Name == "" {
+		return ParameterInfo{}, fmt.Errorf("%s name is empty", label)
+	}
+	typ := queryTypeAliases[strings.ToLower(q.Type)]
+	if q.Type == "" {
+		typ = "string"
+	}
+	if typ == "" {
+		return ParameterInfo{}, fmt.Errorf("%s %s has unknown type %q", label, q.Name, q.Type)
+	}
+	if typ == "string" && len(q.Enum) > 0 {
+		typ = "enum"
+	}
+	if typ == "enum" && len(q.Enum) == 0 {
+		return ParameterInfo{}, fmt.Errorf("enum %s %s has no values", label, q.Name)
+	}
+	param := ParameterInfo{
+		Name:        q.Name,
+		In:          in,
+		Description: q.Description,
+		Required:    q.Required,
+		Type:        typ,
+		Enum:        q.Enum,
+	}
+	if q.Default != nil {
+		value, verr := param.coerce(fmt.Sprint(q.Default))
+		if verr != nil {
+			return ParameterInfo{}, fmt.Errorf("%s %s default: %s", label, q.Name, verr.Msg)
+		}
+		param.Default = value
+	}
+	return param, nil
+}
+
+// coerce converts a raw query or form value to the parameter's type
+func (p ParameterInfo) coerce(raw string) (interface{}, *ValidationError) {
+	loc := []interface{}{p.In, p.Name}
+	switch p.Type {
+	case "int":
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, &ValidationError{Loc: loc, Msg: "Input should be a valid integer", Type: "int_parsing"}
+		}
+		return n, nil
+	case "float":
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, &ValidationError{Loc: loc, Msg: "Input should be a valid number", Type: "float_parsing"}
+		}
+		return f, nil
+	case "bool":
+		switch strings.ToLower(raw) {
+		case "1", "true", "yes", "on":
+			return true, nil
+		case "0", "false", "no", "off":
+			return false, nil
+		}
+		return nil, &ValidationError{Loc: loc, Msg: "Input should be a valid boolean", Type: "bool_parsing"}
+	case "enum":
+		for _, allowed := range p.Enum {
+			if raw == allowed {
+				return raw, nil
+			}
+		}
+		return nil, &ValidationError{Loc: loc, Msg: fmt.Sprintf("Input should be one of %s", strings.Join(p.Enum, ", ")), Type: "enum"}
+	}
+	return raw, nil
+}
+
+// parseQueryParams coerces the route's declared query parameters, applying
+// defaults. Undeclared parameters are left to the handler.
+func parseQueryParams(r *http.Request, route RouteInfo) (map[string]interface{}, []ValidationError) {
+	return coerceDeclared(route.Parameters, "query", r.URL.Query())
+}
+
+// coerceDeclared coerces the declared parameters found in the given part
+// of the request from raw, applying defaults
+func coerceDeclared(params []ParameterInfo, in string, raw url.Values) (map[string]interface{}, []ValidationError) {
+	var values map[string]interface{}
+	var errs []ValidationError
+	for _, param := range params {
+		if param.In != in {
+			continue
+		}
+		if values == nil {
+			values = make(map[string]interface{})
+		}
+		given, present := raw[param.Name]
+		if !present || len(given) == 0 {
+			if param.Required {
+				errs = append(errs, ValidationError{Loc: []interface{}{in, param.Name}, Msg: "Field required", Type: "missing"})
+			} else if param.Default != nil {
+				values[param.Name] = param.Default
+			}
+			continue
+		}
+		value, verr := param.coerce(given[0])
+		if verr != nil {
+			errs = append(errs, *verr)
+			continue
+		}
+		values[param.Name] = value
+	}
+	return values, errs
+}
+
+// withQueryParams stores the coerced query parameters in the request context
+func withQueryParams(r *http.Request, values map[string]interface{}) *http.Request {
+	if values == nil {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), queryParamsKey{}, values))
+}
+
+// QueryParams returns the coerced values of a route's declared query
+// parameters
+func QueryParams(r *http.Request) map[string]interface{} {
+	values, _ := r.Context().Value(queryParamsKey{}).(map[string]interface{})
+	return values
+}
+
+// parseQueryDeclarations converts declarations, rejecting duplicate names
+func parseQueryDeclarations(declared []QueryParam) ([]ParameterInfo, error) {
+	return parseDeclarations(declared, "query", "query parameter")
+}
+
+func parseDeclarations(declared []QueryParam, in, label string) ([]ParameterInfo, error) {
+	params := make([]ParameterInfo, 0, len(declared))
+	seen := make(map[string]bool, len(declared))
+	for _, q := range declared {
+		param, err := q.declare(in, label)
+		if err != nil {
+			return nil, err
+		}
+		if seen[param.Name] {
+			return nil, fmt.Errorf("duplicate %s %s", label, param.Name)
+		}
+		seen[param.Name] = true
+		params = append(params, param)
+	}
+	return params, nil
+}
+
+// withQueryParameters replaces a parameter list's query entries
+func withQueryParameters(params []ParameterInfo, query []ParameterInfo) []ParameterInfo {
+	merged := make([]ParameterInfo, 0, len(params)+len(query))
+	for _, param := range params {
+		if param.In != "query" {
+			merged = append(merged, param)
+		}
+	}
+	return append(merged, query...)
+}
+
+// RegisterQueryParams declares a route's query parameters. cParams is a JSON
+// array of QueryParam objects and replaces any earlier declaration. Requests
+// with missing or invalid values are rejected with 422; handlers receive the
+// coerced values in "query_params".
+//
+//export RegisterQueryParams
+func RegisterQueryParams(cPath *C.char, cMethod *C.char, cParams *C.char) int {
+	if cPath == nil || cMethod == nil || cParams == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in RegisterQueryParams")
+	}
+	path := C.GoString(cPath)
+	method := strings.ToUpper(C.GoString(cMethod))
+	var declared []QueryParam
+	if err := json.Unmarshal([]byte(C.GoString(cParams)), &declared); err != nil {
+		return exportError(exportInvalid, "Invalid query parameters", "path", path, "method", method, "error", err)
+	}
+	query, err := parseQueryDeclarations(declared)
+	if err != nil {
+		return exportError(exportInvalid, "Invalid query parameters", "path", path, "method", method, "error", err)
+	}
+
+	routesMu.Lock()
+	key := path + method
+	route, exists := routes[key]
+	if !exists {
+		routesMu.Unlock()
+		return exportError(exportNotFound, "Cannot set query parameters, route not found", "key", key)
+	}
+	route.Parameters = withQueryParameters(route.Parameters, query)
+	if len(query) > 0 {
+		route.Responses[http.StatusUnprocessableEntity] = "Validation error"
+	}
+	routes[key] = route
+	routesMu.Unlock()
+
+	names := make([]string, len(query))
+	for i, param := range query {
+		names[i] = param.Name
+	}
+	slog.Info("Route query parameters set", "key", key, "params", strings.Join(names, ","))
+	invalidateOpenAPICache()
+	auditConfigChange("RegisterQueryParams", map[string]string{"path": path, "method": method, "params": strings.Join(names, ",")})
+
+	return 0
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"C"
+	"fmt"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// byteRange is one satisfiable range-spec of a Range header
+type byteRange struct {
+	start, length int64
+}
+
+func (br byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", br.start, br.start+br.length-1, size)
+}
+
+// parseByteRanges resolves a bytes Range header against a body of size
+// bytes. It returns nil without an error when the header should be
+// ignored: it is malformed, or its ranges are not ascending and disjoint,
+// which a server may decline to serve. An error means no range is
+// satisfiable.
+func parseByteRanges(header string, size int64) ([]byteRange, error) {
+	specs, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return nil, nil
+	}
+	var ranges []byteRange
+	for _, spec := range strings.Split(specs, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		first, last, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, nil
+		}
+		var br byteRange
+		if first == "" {
+			// A suffix range: the last n bytes
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, nil
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			n = min(n, size)
+			br = byteRange{start: size - n, length: n}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, nil
+			}
+			end := size - 1
+			if last != "" {
+				if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+					return nil, nil
+				}
+				end = min(end, size-1)
+			}
+			if start >= size {
+				continue
+			}
+			br = byteRange{start: start, length: end - start + 1}
+		}
+		if n := len(ranges); n > 0 && br.start < ranges[n-1].start+ranges[n-1].length {
+			return nil, nil
+		}
+		ranges = append(ranges, br)
+	}
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("no satisfiable range in %q", header)
+	}
+	return ranges, nil
+}
+
+// ifRangeMatches reports whether a request's If-Range, if any, still names
+// the representation; a stale one asks for the whole body. ETags compare
+// strongly and dates exactly, as RFC 9110 section 13.1.5 requires.
+func ifRangeMatches(r *http.Request, etag, lastModified string) bool {
+	ifRange := r.Header.Get("If-Range")
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		return !strings.HasPrefix(etag, "W/") && ifRange == etag
+	}
+	since, err := http.ParseTime(ifRange)
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(lastModified)
+	return err == nil && modified.Equal(since)
+}
+
+// rangeWriter passes through only the bytes of a body that fall in the
+// requested ranges, framing them as multipart/byteranges when there are
+// several. Writes past the last range are discarded, so a stream may keep
+// writing its whole body.
+type rangeWriter struct {
+	http.ResponseWriter
+	ranges      []byteRange
+	size        int64
+	contentType string
+	offset      int64
+	next        int // index of the range being written
+	parts       *multipart.Writer
+}
+
+func (rw *rangeWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+func (rw *rangeWriter) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 && rw.next < len(rw.ranges) {
+		br := rw.ranges[rw.next]
+		if skip := br.start - rw.offset; skip > 0 {
+			if skip >= int64(len(p)) {
+				rw.offset += int64(len(p))
+				return written, nil
+			}
+			p, rw.offset = p[skip:], br.start
+		}
+		if rw.offset == br.start && rw.parts != nil {
+			part := textproto.MIMEHeader{}
+			part.Set("Content-Type", rw.contentType)
+			part.Set("Content-Range", br.contentRange(rw.size))
+			if _, err := rw.parts.CreatePart(part); err != nil {
+				return 0, err
+			}
+		}
+		n := min(int64(len(p)), br.start+br.length-rw.offset)
+		if _, err := rw.ResponseWriter.Write(p[:n]); err != nil {
+			return 0, err
+		}
+		p, rw.offset = p[n:], rw.offset+n
+		if rw.offset == br.start+br.length {
+			rw.next++
+		}
+	}
+	rw.offset += int64(len(p))
+	if rw.next == len(rw.ranges) && rw.parts != nil {
+		// The closing boundary follows the last range
+		err := rw.parts.Close()
+		rw.parts = nil
+		return written, err
+	}
+	return written, nil
+}
+
+// multipartRangesLength is the size of the multipart/byteranges body
+// serving ranges, so the response can carry a Content-Length
+func multipartRangesLength(ranges []byteRange, size int64, contentType, boundary string) int64 {
+	var counter countingWriter
+	parts := multipart.NewWriter(&counter)
+	parts.SetBoundary(boundary)
+	total := int64(0)
+	for _, br := range ranges {
+		part := textproto.MIMEHeader{}
+		part.Set("Content-Type", contentType)
+		part.Set("Content-Range", br.contentRange(size))
+		parts.CreatePart(part)
+		total += br.length
+	}
+	parts.Close()
+	return total + counter.n
+}
+
+type countingWriter struct{ n int64 }
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+// rangeHandlerResponse serves a byte range of a 200 handler response on a
+// route with SetRouteRanges. size is the full body length: that of a
+// buffered body, or the Content-Length a streamed response declares, -1 if
+// it declares none. The returned writer filters the body down to the
+// requested ranges; ok is false once the request has been answered with
+// 416.
+func rangeHandlerResponse(w http.ResponseWriter, r *http.Request, route RouteInfo, response HandlerResponse, size int64) (http.ResponseWriter, HandlerResponse, bool) {
+	if !route.Ranges || response.Status != http.StatusOK || size < 0 {
+		return w, response, true
+	}
+	h := w.Header().Clone()
+	for name, value := range response.Headers {
+		h.Set(name, value)
+	}
+	headers := make(map[string]string, len(response.Headers)+3)
+	for name, value := range response.Headers {
+		headers[http.CanonicalHeaderKey(name)] = value
+	}
+	headers["Accept-Ranges"] = "bytes"
+	response.Headers = headers
+	if r.Method != http.MethodGet || r.Header.Get("Range") == "" || !ifRangeMatches(r, h.Get("ETag"), h.Get("Last-Modified")) {
+		return w, response, true
+	}
+	ranges, err := parseByteRanges(r.Header.Get("Range"), size)
+	if err != nil {
+		slog.Debug("Range not satisfiable", "method", route.Method, "route", route.Path, "error", err)
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		http.Error(w, `{"error": "Range not satisfiable"}`, http.StatusRequestedRangeNotSatisfiable)
+		return w, response, false
+	}
+	if ranges == nil {
+		return w, response, true
+	}
+
+	contentType := h.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	rw := &rangeWriter{ResponseWriter: w, ranges: ranges, size: size, contentType: contentType}
+	response.Status = http.StatusPartialContent
+	if len(ranges) == 1 {
+		headers["Content-Range"] = ranges[0].contentRange(size)
+		headers["Content-Length"] = strconv.FormatInt(ranges[0].length, 10)
+		return rw, response, true
+	}
+	rw.parts = multipart.NewWriter(w)
+	headers["Content-Type"] = "multipart/byteranges; boundary=" + rw.parts.Boundary()
+	headers["Content-Length"] = strconv.FormatInt(multipartRangesLength(ranges, size, contentType, rw.parts.Boundary()), 10)
+	return rw, response, true
+}
+
+// handlerBodySize is the full length of a handler response's body, -1 for
+// a stream that does not declare its Content-Length
+func handlerBodySize(response HandlerResponse) int64 {
+	if !response.Stream {
+		if response.BodyBase64 != nil {
+			return int64(len(response.BodyBase64))
+		}
+		return int64(len(response.Body))
+	}
+	for name, value := range response.Headers {
+		if strings.EqualFold(name, "Content-Length") {
+			if size, err := strconv.ParseInt(value, 10, 64); err == nil && size >= 0 {
+				return size
+			}
+		}
+	}
+	return -1
+}
+
+// SetRouteRanges lets clients fetch byte ranges of a handler route's 200
+// responses, e.g. to resume a large download. Responses advertise
+// Accept-Ranges, and GET requests with a Range header, whose If-Range if
+// any still matches, are answered with 206 and the requested bytes, as
+// multipart/byteranges for several ranges, or with 416 when none is
+// satisfiable. Streamed responses must declare their Content-Length to be
+// served in ranges. Ranges that are not ascending and disjoint are ignored
+// and the whole body is sent. Static files always support ranges.
+//
+//export SetRouteRanges
+func SetRouteRanges(cPath *C.char, cMethod *C.char, enabled int) int {
+	if cPath == nil || cMethod == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in SetRouteRanges")
+	}
+	path := C.GoString(cPath)
+	method := strings.ToUpper(C.GoString(cMethod))
+	ranged := enabled != 0
+
+	routesMu.Lock()
+	key := path + method
+	route, exists := routes[key]
+	if !exists {
+		routesMu.Unlock()
+		return exportError(exportNotFound, "Cannot set ranges, route not found", "key", key)
+	}
+	route.Ranges = ranged
+	if ranged {
+		if _, ok := route.Responses[http.StatusPartialContent]; !ok {
+			route.Responses[http.StatusPartialContent] = "Partial content"
+		}
+	} else if route.Responses[http.StatusPartialContent] == "Partial content" {
+		delete(route.Responses, http.StatusPartialContent)
+	}
+	routes[key] = route
+	routesMu.Unlock()
+	invalidateOpenAPICache()
+
+	slog.Info("Route ranges set", "key", key, "enabled", ranged)
+	auditConfigChange("SetRouteRanges", map[string]string{"path": path, "method": method, "enabled": fmt.Sprint(ranged)})
+	return 0
+}
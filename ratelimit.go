@@ -0,0 +1,233 @@
+package main
+
+import (
+	"C"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimit is a token bucket: Rate tokens are added per second up to Burst,
+// and each request takes one
+type RateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+func (l RateLimit) valid() bool {
+	return l.Rate > 0 && l.Burst >= 1
+}
+
+// rateLimitOptions are the "ratelimit" middleware options. Redis is used as
+// the bucket store when Redis or RedisAddr is set so limits hold across
+// processes.
+type rateLimitOptions struct {
+	RateLimit
+	Redis         string `json:"redis"` // name of a client registered with RegisterRedis
+	RedisAddr     string `json:"redis_addr"`
+	RedisPassword string `json:"redis_password"`
+	RedisDB       int    `json:"redis_db"`
+	KeyPrefix     string `json:"key_prefix"`
+}
+
+// rateLimitStore takes a token from the bucket at key. When no token is
+// available it reports how long until one is.
+type rateLimitStore interface {
+	take(key string, limit RateLimit, now time.Time) (allowed bool, retryAfter time.Duration, err error)
+}
+
+// memoryBucket is one bucket in the in-memory store
+type memoryBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// memoryRateLimitStore keeps buckets in process memory
+type memoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*memoryBucket
+	lastSweep time.Time
+}
+
+const rateLimitSweepInterval = time.Minute
+
+func newMemoryRateLimitStore() *memoryRateLimitStore {
+	return &memoryRateLimitStore{buckets: make(map[string]*memoryBucket)}
+}
+
+func (s *memoryRateLimitStore) take(key string, limit RateLimit, now time.Time) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(now)
+
+	burst := float64(limit.Burst)
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &memoryBucket{tokens: burst, last: now}
+		s.buckets[key] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, nil
+	}
+	wait := time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+	return false, wait, nil
+}
+
+// sweep drops buckets idle long enough to have refilled. Must hold s.mu.
+func (s *memoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	s.lastSweep = now
+	for key, b := range s.buckets {
+		if now.Sub(b.last) > rateLimitSweepInterval {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// redisTokenBucket atomically refills and takes from a bucket stored as a
+// hash. It returns {allowed, milliseconds until the next token}.
+const redisTokenBucket = `
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local data = redis.call('HMGET', KEYS[1], 't', 'ts')
+local tokens = tonumber(data[1]) or burst
+local ts = tonumber(data[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) / 1000 * rate)
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) / rate * 1000)
+end
+redis.call('HSET', KEYS[1], 't', tostring(tokens), 'ts', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+return {allowed, wait}
+`
+
+// redisRateLimitStore keeps buckets in Redis
+type redisRateLimitStore struct {
+	client *redisClient
+	prefix string
+}
+
+func (s *redisRateLimitStore) take(key string, limit RateLimit, now time.Time) (bool, time.Duration, error) {
+	reply, err := s.client.do("EVAL", redisTokenBucket, "1", s.prefix+key,
+		strconv.FormatFloat(limit.Rate, 'f', -1, 64), strconv.Itoa(limit.Burst), strconv.FormatInt(now.UnixMilli(), 10))
+	if err != nil {
+		return true, 0, err
+	}
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != 2 {
+		return true, 0, fmt.Errorf("unexpected redis reply %v", reply)
+	}
+	allowed, _ := values[0].(int64)
+	waitMs, _ := values[1].(int64)
+	return allowed == 1, time.Duration(waitMs) * time.Millisecond, nil
+}
+
+// newRateLimitMiddleware builds the "ratelimit" middleware. Each client IP
+// gets a bucket under the global limit; routes configured with
+// SetRouteRateLimit get a separate per-IP bucket with their own limit.
+func newRateLimitMiddleware(options []byte) (func(http.Handler) http.Handler, error) {
+	opts := rateLimitOptions{RateLimit: RateLimit{Rate: 10, Burst: 20}, KeyPrefix: "goserver:ratelimit:"}
+	if len(options) > 0 {
+		if err := json.Unmarshal(options, &opts); err != nil {
+			return nil, err
+		}
+	}
+	if !opts.valid() {
+		return nil, fmt.Errorf("rate must be positive and burst at least 1")
+	}
+	client, err := middlewareRedis(opts.Redis, opts.RedisAddr, opts.RedisPassword, opts.RedisDB)
+	if err != nil {
+		return nil, err
+	}
+	var store rateLimitStore = newMemoryRateLimitStore()
+	if client != nil {
+		store = &redisRateLimitStore{client: client, prefix: opts.KeyPrefix}
+	}
+	global := opts.RateLimit
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			routesMu.RLock()
+			route, _, _, found := findRoute(r.Host, r.URL.Path, r.Method)
+			routesMu.RUnlock()
+
+			limit := global
+			bucket := "ip:" + clientKey(r)
+			if found && route.RateLimit != nil {
+				limit = *route.RateLimit
+				bucket = "route:" + route.Method + " " + route.Path + ":" + clientKey(r)
+			}
+			allowed, retryAfter, err := store.take(bucket, limit, time.Now())
+			if err != nil {
+				// Fail open so a store outage does not take the API down
+				slog.Warn("Rate limit store error", "error", err)
+			}
+			if !allowed {
+				seconds := int(math.Ceil(retryAfter.Seconds()))
+				if seconds < 1 {
+					seconds = 1
+				}
+				slog.Debug("Rate limit exceeded", "bucket", bucket, "retry_after", seconds)
+				w.Header().Set("Retry-After", strconv.Itoa(seconds))
+				http.Error(w, `{"error": "Rate limit exceeded"}`, http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+// SetRouteRateLimit gives a route its own per-client token bucket of burst
+// requests refilled at rate per second. The "ratelimit" middleware must be
+// registered for the limit to take effect.
+//
+//export SetRouteRateLimit
+func SetRouteRateLimit(cPath *C.char, cMethod *C.char, rate float64, burst int) int {
+	if cPath == nil || cMethod == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in SetRouteRateLimit")
+	}
+	path := C.GoString(cPath)
+	method := strings.ToUpper(C.GoString(cMethod))
+	limit := RateLimit{Rate: rate, Burst: burst}
+	if !limit.valid() {
+		return exportError(exportInvalid, "Invalid rate limit", "rate", rate, "burst", burst)
+	}
+
+	routesMu.Lock()
+	key := path + method
+	route, exists := routes[key]
+	if !exists {
+		routesMu.Unlock()
+		return exportError(exportNotFound, "Cannot set rate limit, route not found", "key", key)
+	}
+	route.RateLimit = &limit
+	routes[key] = route
+	routesMu.Unlock()
+
+	slog.Info("Route rate limit set", "key", key, "rate", rate, "burst", burst)
+	auditConfigChange("SetRouteRateLimit", map[string]string{
+		"path":   path,
+		"method": method,
+		"rate":   strconv.FormatFloat(rate, 'f', -1, 64),
+		"burst":  strconv.Itoa(burst),
+	})
+
+	return 0
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"C"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"sync/atomic"
+)
+
+// PanicReport describes a recovered handler panic for the host error callback
+type PanicReport struct {
+	RequestID string `json:"request_id"`
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	Error     string `json:"error"`
+	Stack     string `json:"stack"`
+}
+
+// errorCallback is the host callback for recovered panics; 0 when unset
+var errorCallback atomic.Uintptr
+
+// recoveryMiddleware turns a panic in any later handler into a logged stack
+// trace and a JSON 500, instead of a dropped connection
+func recoveryMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				// Deliberate aborts keep net/http's semantics
+				panic(p)
+			}
+			report := PanicReport{
+				Method: r.Method,
+				Path:   r.URL.Path,
+				Error:  fmt.Sprint(p),
+				Stack:  string(debug.Stack()),
+			}
+			if info := requestInfoFrom(r); info != nil {
+				report.RequestID = info.ID
+			}
+			slog.Error("Recovered from panic",
+				"request_id", report.RequestID,
+				"method", report.Method,
+				"path", report.Path,
+				"error", report.Error,
+				"stack", report.Stack,
+			)
+
+			// Once the response has started the status can no longer change
+			if rec.status == 0 {
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Del("Content-Length")
+				w.Header().Del("Content-Encoding")
+				w.WriteHeader(http.StatusInternalServerError)
+				writeJSON(w, ErrorResponse{Error: "Internal server error", RequestID: report.RequestID})
+			}
+
+			if fn := errorCallback.Load(); fn != 0 {
+				if data, err := json.Marshal(report); err == nil {
+					callReportHandler(fn, data)
+				}
+			}
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// RegisterErrorCallback sets a host callback invoked with a JSON report for
+// every recovered handler panic. Passing 0 removes it.
+//
+//export RegisterErrorCallback
+func RegisterErrorCallback(cHandler uintptr) int {
+	errorCallback.Store(cHandler)
+	slog.Info("Error callback registered", "enabled", cHandler != 0)
+	auditConfigChange("RegisterErrorCallback", map[string]string{"enabled": fmt.Sprint(cHandler != 0)})
+
+	return 0
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"C"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// redirectStatuses are the statuses RegisterRedirect and SendRedirect accept
+var redirectStatuses = map[int]bool{
+	http.StatusMovedPermanently:  true,
+	http.StatusFound:             true,
+	http.StatusSeeOther:          true,
+	http.StatusTemporaryRedirect: true,
+	http.StatusPermanentRedirect: true,
+}
+
+// routeRedirect is the target of a RegisterRedirect route
+type routeRedirect struct {
+	target string // may name the route's path parameters as {name}
+	status int
+}
+
+// location resolves the target for a request, filling in its path
+// parameters and keeping its query string when the target has none
+func (rd *routeRedirect) location(r *http.Request) string {
+	target := rd.target
+	for name, value := range PathParams(r) {
+		target = strings.ReplaceAll(target, "{"+name+"}", value)
+	}
+	if r.URL.RawQuery != "" && !strings.Contains(target, "?") {
+		target += "?" + r.URL.RawQuery
+	}
+	return target
+}
+
+// serveRedirect answers a request to a RegisterRedirect route
+func serveRedirect(w http.ResponseWriter, r *http.Request, route RouteInfo) {
+	http.Redirect(w, r, route.Redirect.location(r), route.Redirect.status)
+}
+
+// redirectResponse documents the Location header of a redirect route's
+// response in OpenAPI
+func redirectResponse(response map[string]interface{}, rd *routeRedirect) {
+	response["headers"] = map[string]interface{}{
+		"Location": map[string]interface{}{
+			"schema": map[string]string{"type": "string", "example": rd.target},
+		},
+	}
+}
+
+// validRedirectTarget rejects targets that cannot be sent as a Location
+func validRedirectTarget(target string) bool {
+	return target != "" && !strings.ContainsAny(target, "\r\n\x00")
+}
+
+// RegisterRedirect serves cPath with a redirect to cTarget, answered in Go
+// without calling the host. statusCode is 301, 302, 303, 307 or 308; 0
+// means 308. Redirects are registered for GET, and with 307 and 308, which
+// keep the method, also for POST, PUT, PATCH and DELETE. cTarget may be an
+// absolute URL or a path and may use the route's parameters, e.g.
+// /old/{id} to /new/{id}; the request's query string is kept when the
+// target has none. Returns 0, or a negative status on invalid input.
+//
+//export RegisterRedirect
+func RegisterRedirect(cPath *C.char, cTarget *C.char, statusCode int) int {
+	if cPath == nil || cTarget == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in RegisterRedirect")
+	}
+	path := C.GoString(cPath)
+	target := C.GoString(cTarget)
+	if statusCode == 0 {
+		statusCode = http.StatusPermanentRedirect
+	}
+	if !redirectStatuses[statusCode] {
+		return exportError(exportInvalid, "Invalid redirect status", "path", path, "status", statusCode)
+	}
+	if !strings.HasPrefix(path, "/") || !validRedirectTarget(target) {
+		return exportError(exportInvalid, "Invalid redirect", "path", path, "target", target)
+	}
+	methods := []string{http.MethodGet}
+	if statusCode == http.StatusTemporaryRedirect || statusCode == http.StatusPermanentRedirect {
+		methods = append(methods, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete)
+	}
+
+	rd := &routeRedirect{target: target, status: statusCode}
+	routesMu.Lock()
+	for _, method := range methods {
+		key := path + method
+		routes[key] = RouteInfo{
+			Path:        path,
+			Method:      method,
+			Description: "Redirect to " + target,
+			Parameters:  pathParameters(path),
+			Responses:   map[int]string{statusCode: http.StatusText(statusCode)},
+			Redirect:    rd,
+		}
+		routeTree.insert(path, method, key)
+	}
+	routesMu.Unlock()
+	invalidateOpenAPICache()
+
+	slog.Info("Redirect registered", "path", path, "target", target, "status", statusCode)
+	auditConfigChange("RegisterRedirect", map[string]string{"path": path, "target": target, "status": strconv.Itoa(statusCode)})
+	return 0
+}
+
+// SendRedirect makes the request cRequestID answer with a redirect to cURL
+// in place of the response its handler returns. statusCode is 301, 302,
+// 303, 307 or 308; 0 means 302. Like SetResponseHeader, it may be called
+// while the request's handler callback runs, from any thread. Returns 0,
+// or a negative status if the handler is not running or the input is
+// invalid.
+//
+//export SendRedirect
+func SendRedirect(cRequestID *C.char, cURL *C.char, statusCode int) int {
+	if cRequestID == nil || cURL == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in SendRedirect")
+	}
+	requestID := C.GoString(cRequestID)
+	target := C.GoString(cURL)
+	if statusCode == 0 {
+		statusCode = http.StatusFound
+	}
+	if !redirectStatuses[statusCode] || !validRedirectTarget(target) {
+		return exportError(exportInvalid, "Invalid redirect", "request_id", requestID, "url", target, "status", statusCode)
+	}
+	h, ok := handlerHeadersFor(requestID)
+	if !ok {
+		return exportError(exportNotFound, "Cannot send redirect, handler not running", "request_id", requestID)
+	}
+	h.mu.Lock()
+	h.redirect, h.location = statusCode, target
+	h.mu.Unlock()
+	slog.Debug("Redirect requested by handler", "request_id", requestID, "status", statusCode, "url", target)
+	return 0
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"C"
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const redisDialTimeout = 2 * time.Second
+
+// redisClient talks to Redis over a single lazily dialed connection
+// speaking RESP. Commands are serialized.
+type redisClient struct {
+	addr     string
+	username string // ACL user; empty authenticates with the password alone
+	password string
+	db       int
+	tls      *tls.Config   // set for rediss:// URLs
+	timeout  time.Duration // per dial and command; defaults to redisDialTimeout
+
+	mu   sync.Mutex
+	conn net.Conn
+	rw   *bufio.ReadWriter
+}
+
+// redisError is an error reply from the server. The connection stays
+// usable after one.
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// parseRedisURL reads a redis://[[user]:password@]host[:port][/db] URL, or
+// rediss:// for TLS
+func parseRedisURL(dsn string) (*redisClient, error) {
+	u, err := url.Parse(dsn)
+	if err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") || u.Host == "" {
+		return nil, fmt.Errorf("invalid redis URL, want redis://host:port")
+	}
+	client := &redisClient{addr: u.Host}
+	if u.Port() == "" {
+		client.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.Scheme == "rediss" {
+		client.tls = &tls.Config{ServerName: u.Hostname()}
+	}
+	if u.User != nil {
+		client.username = u.User.Username()
+		if password, ok := u.User.Password(); ok {
+			client.password = password
+		} else {
+			// redis://password@host, as accepted by most clients
+			client.username, client.password = "", client.username
+		}
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if client.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid redis database %q", db)
+		}
+	}
+	return client, nil
+}
+
+func (s *redisClient) commandTimeout() time.Duration {
+	if s.timeout > 0 {
+		return s.timeout
+	}
+	return redisDialTimeout
+}
+
+// do sends a command, dialing first if needed. The connection is dropped on
+// any error but an error reply so the next call reconnects. A command that
+// fails on a reused connection, which the server or a proxy may have closed
+// while it sat idle, is retried once on a fresh one.
+func (s *redisClient) do(args ...string) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reused := s.conn != nil
+	if !reused {
+		if err := s.dial(); err != nil {
+			return nil, err
+		}
+	}
+	reply, err := s.roundTrip(args)
+	var replyErr redisError
+	if err == nil || errors.As(err, &replyErr) {
+		return reply, err
+	}
+	s.drop()
+	var netErr net.Error
+	if !reused || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return nil, err
+	}
+	slog.Debug("Redis connection lost, reconnecting", "addr", s.addr, "error", err)
+	if err := s.dial(); err != nil {
+		return nil, err
+	}
+	if reply, err = s.roundTrip(args); err != nil && !errors.As(err, &replyErr) {
+		s.drop()
+	}
+	return reply, err
+}
+
+func (s *redisClient) drop() {
+	s.conn.Close()
+	s.conn = nil
+}
+
+func (s *redisClient) dial() error {
+	dialer := &net.Dialer{Timeout: s.commandTimeout()}
+	var conn net.Conn
+	var err error
+	if s.tls != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", s.addr, s.tls)
+	} else {
+		conn, err = dialer.Dial("tcp", s.addr)
+	}
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	s.rw = bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	if s.password != "" {
+		auth := []string{"AUTH", s.password}
+		if s.username != "" {
+			auth = []string{"AUTH", s.username, s.password}
+		}
+		if _, err := s.roundTrip(auth); err != nil {
+			s.drop()
+			return err
+		}
+	}
+	if s.db != 0 {
+		if _, err := s.roundTrip([]string{"SELECT", strconv.Itoa(s.db)}); err != nil {
+			s.drop()
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *redisClient) roundTrip(args []string) (interface{}, error) {
+	s.conn.SetDeadline(time.Now().Add(s.commandTimeout()))
+	fmt.Fprintf(s.rw, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(s.rw, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if err := s.rw.Flush(); err != nil {
+		return nil, err
+	}
+	return readRESP(s.rw.Reader)
+}
+
+// readRESP parses one RESP2 reply
+func readRESP(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("empty redis reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		values := make([]interface{}, n)
+		for i := range values {
+			if values[i], err = readRESP(r); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("unknown redis reply %q", line)
+}
+
+// close drops the connection
+func (s *redisClient) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// RedisOptions configures a registered Redis client
+type RedisOptions struct {
+	TimeoutMs   int   `json:"timeout_ms"`   // per dial and command; defaults to 2000
+	HealthCheck *bool `json:"health_check"` // PING in /readyz; on by default
+}
+
+var (
+	redisClients   = make(map[string]*redisClient)
+	redisClientsMu sync.RWMutex
+)
+
+func redisClientFor(name string) (*redisClient, bool) {
+	redisClientsMu.RLock()
+	defer redisClientsMu.RUnlock()
+	client, ok := redisClients[name]
+	return client, ok
+}
+
+// middlewareRedis picks the Redis client of a middleware's options: the
+// client registered as name, a new one for addr, or nil for neither
+func middlewareRedis(name, addr, password string, db int) (*redisClient, error) {
+	if name != "" {
+		client, ok := redisClientFor(name)
+		if !ok {
+			return nil, fmt.Errorf("redis client %q is not registered", name)
+		}
+		return client, nil
+	}
+	if addr != "" {
+		return &redisClient{addr: addr, password: password, db: db}, nil
+	}
+	return nil, nil
+}
+
+// registeredRedis reports whether a client is shared through
+// RegisterRedis, so its users must not close it
+func registeredRedis(client *redisClient) bool {
+	redisClientsMu.RLock()
+	defer redisClientsMu.RUnlock()
+	for _, c := range redisClients {
+		if c == client {
+			return true
+		}
+	}
+	return false
+}
+
+// redisCheckName is the /readyz check of a Redis client
+func redisCheckName(name string) string {
+	return "redis:" + name
+}
+
+// pingRedis is the readiness check of a registered Redis client
+func pingRedis(name string) CheckResult {
+	client, ok := redisClientFor(name)
+	if !ok {
+		return CheckResult{Status: "fail", Message: "redis client not registered"}
+	}
+	if _, err := client.do("PING"); err != nil {
+		return CheckResult{Status: "fail", Message: err.Error()}
+	}
+	return CheckResult{Status: "ok"}
+}
+
+// RegisterRedis registers a managed Redis client named cName for a
+// redis://[[user]:password@]host[:port][/db] or rediss:// URL. cOptions is
+// a JSON RedisOptions object. The client reconnects on demand, is pinged in
+// /readyz, serves the ratelimit, session and cache middleware configured
+// with {"redis": cName}, and backs RedisGet, RedisSet and RedisDel.
+// Re-registering a name reconfigures the client in place, so middleware
+// already using it follows.
+//
+//export RegisterRedis
+func RegisterRedis(cName *C.char, cURL *C.char, cOptions *C.char) int {
+	if cName == nil || cURL == nil || cOptions == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in RegisterRedis")
+	}
+	name := C.GoString(cName)
+	options := C.GoString(cOptions)
+	if name == "" || reservedCheckNames[redisCheckName(name)] {
+		return exportError(exportInvalid, "Invalid redis client name", "name", name)
+	}
+	var opts RedisOptions
+	if options != "" {
+		if err := json.Unmarshal([]byte(options), &opts); err != nil {
+			return exportError(exportInvalid, "Invalid redis options", "name", name, "error", err)
+		}
+	}
+	// The URL may carry a password, so it is neither logged nor audited
+	client, err := parseRedisURL(C.GoString(cURL))
+	if err != nil {
+		return exportError(exportFailed, "Cannot register redis client", "name", name, "error", err)
+	}
+	client.timeout = time.Duration(opts.TimeoutMs) * time.Millisecond
+
+	redisClientsMu.Lock()
+	if existing, ok := redisClients[name]; ok {
+		existing.mu.Lock()
+		if existing.conn != nil {
+			existing.drop()
+		}
+		existing.addr, existing.username, existing.password = client.addr, client.username, client.password
+		existing.db, existing.tls, existing.timeout = client.db, client.tls, client.timeout
+		existing.mu.Unlock()
+		client = existing
+	} else {
+		redisClients[name] = client
+	}
+	redisClientsMu.Unlock()
+
+	checkName := redisCheckName(name)
+	if opts.HealthCheck == nil || *opts.HealthCheck {
+		addHealthCheck(healthCheck{name: checkName, probe: func(time.Duration) CheckResult {
+			return pingRedis(name)
+		}}, "redis")
+	} else {
+		removeHealthCheck(checkName)
+	}
+	if result := pingRedis(name); result.Status != "ok" {
+		slog.Warn("Redis is not reachable yet", "name", name, "error", result.Message)
+	}
+
+	slog.Info("Redis client registered", "name", name, "addr", client.addr, "db", client.db, "tls", client.tls != nil)
+	auditConfigChange("RegisterRedis", map[string]string{"name": name, "addr": client.addr, "options": options})
+
+	return 0
+}
+
+// redisCommand runs a command on a registered client
+func redisCommand(export string, name string, args ...string) (interface{}, error) {
+	client, ok := redisClientFor(name)
+	if !ok {
+		return nil, fmt.Errorf("redis client %q is not registered", name)
+	}
+	reply, err := client.do(args...)
+	if err != nil {
+		slog.Debug("Redis command failed", "export", export, "name", name, "error", err)
+	}
+	return reply, err
+}
+
+// RedisGet returns {"value": ...} with the string stored at cKey, or null
+// when the key does not exist, through the client registered as cName.
+// Failures return {"error": ...}. The caller must release the result with
+// FreeString.
+//
+//export RedisGet
+func RedisGet(cName *C.char, cKey *C.char) *C.char {
+	if cName == nil || cKey == nil {
+		recordError("One or more parameters are nil in RedisGet")
+		return C.CString(`{"error": "missing parameters"}`)
+	}
+	reply, err := redisCommand("RedisGet", C.GoString(cName), "GET", C.GoString(cKey))
+	var data []byte
+	if err != nil {
+		data, _ = json.Marshal(ErrorResponse{Error: err.Error()})
+	} else {
+		data, _ = json.Marshal(map[string]interface{}{"value": reply})
+	}
+	return C.CString(string(data))
+}
+
+// RedisSet stores cValue at cKey through the client registered as cName,
+// expiring after ttlMs milliseconds, or never for 0. Returns 0, or a
+// negative status on error.
+//
+//export RedisSet
+func RedisSet(cName *C.char, cKey *C.char, cValue *C.char, ttlMs int) int {
+	if cName == nil || cKey == nil || cValue == nil || ttlMs < 0 {
+		return exportError(exportInvalid, "One or more parameters are invalid in RedisSet")
+	}
+	args := []string{"SET", C.GoString(cKey), C.GoString(cValue)}
+	if ttlMs > 0 {
+		args = append(args, "PX", strconv.Itoa(ttlMs))
+	}
+	if _, err := redisCommand("RedisSet", C.GoString(cName), args...); err != nil {
+		setLastError("Redis command failed", "export", "RedisSet", "error", err)
+		return exportFailed
+	}
+	return 0
+}
+
+// RedisDel removes cKey through the client registered as cName. Returns
+// the number of keys removed, or a negative status on error.
+//
+//export RedisDel
+func RedisDel(cName *C.char, cKey *C.char) int {
+	if cName == nil || cKey == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in RedisDel")
+	}
+	reply, err := redisCommand("RedisDel", C.GoString(cName), "DEL", C.GoString(cKey))
+	if err != nil {
+		setLastError("Redis command failed", "export", "RedisDel", "error", err)
+		return exportFailed
+	}
+	n, _ := reply.(int64)
+	return int(n)
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"C"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Environment variables a reloading server passes to its successor
+const (
+	inheritedSocketsEnv = "GOSERVER_INHERITED_SOCKETS" // JSON array of socket tags, one per descriptor from 3
+	reloadReadyFDEnv    = "GOSERVER_RELOAD_READY_FD"   // pipe written once the successor is serving
+	defaultReloadWait   = 30 * time.Second
+)
+
+// reloadSocket is a bound socket handed to the successor process on
+// reload. The tag names what it was bound for (a listener spec, or the
+// HTTP/3 and redirect addresses) so the successor uses it instead of
+// binding again.
+type reloadSocket struct {
+	tag  string
+	conn interface{ File() (*os.File, error) }
+}
+
+var (
+	inheritedFiles   map[string][]*os.File // by tag, consumed as they are used
+	inheritedReady   *os.File
+	inheritedMu      sync.Mutex
+	inheritedSockets sync.Once
+)
+
+// loadInheritedSockets picks up the descriptors passed by a reloading
+// predecessor. The variables are cleared so later children do not see
+// them.
+func loadInheritedSockets() {
+	inheritedSockets.Do(func() {
+		inheritedFiles = make(map[string][]*os.File)
+		if raw := os.Getenv(inheritedSocketsEnv); raw != "" {
+			var tags []string
+			if err := json.Unmarshal([]byte(raw), &tags); err != nil {
+				slog.Error("Invalid inherited sockets", "error", err)
+			}
+			for i, tag := range tags {
+				fd := systemdListenFDsStart + i
+				syscall.CloseOnExec(fd)
+				f := os.NewFile(uintptr(fd), tag)
+				inheritedFiles[tag] = append(inheritedFiles[tag], f)
+			}
+		}
+		if fd, err := strconv.Atoi(os.Getenv(reloadReadyFDEnv)); err == nil {
+			syscall.CloseOnExec(fd)
+			inheritedReady = os.NewFile(uintptr(fd), "reload-ready")
+		}
+		os.Unsetenv(inheritedSocketsEnv)
+		os.Unsetenv(reloadReadyFDEnv)
+	})
+}
+
+// takeInherited returns the inherited descriptors for tag, if any, and
+// forgets them
+func takeInherited(tag string) []*os.File {
+	loadInheritedSockets()
+	inheritedMu.Lock()
+	defer inheritedMu.Unlock()
+	files := inheritedFiles[tag]
+	delete(inheritedFiles, tag)
+	return files
+}
+
+// inheritedListeners returns listeners on the inherited sockets for tag,
+// if there are any
+func inheritedListeners(tag string) ([]net.Listener, error) {
+	files := takeInherited(tag)
+	var listeners []net.Listener
+	for _, f := range files {
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, opened := range listeners {
+				opened.Close()
+			}
+			return nil, fmt.Errorf("inherited socket %s: %w", tag, err)
+		}
+		listeners = append(listeners, l)
+	}
+	if len(listeners) > 0 {
+		slog.Info("Using inherited socket", "tag", tag, "count", len(listeners))
+	}
+	return listeners, nil
+}
+
+// listenTCP binds addr, or adopts the socket a predecessor passed for it
+func listenTCP(tag, addr string) (net.Listener, error) {
+	inherited, err := inheritedListeners(tag)
+	if err != nil {
+		return nil, err
+	}
+	if len(inherited) == 0 {
+		return net.Listen("tcp", addr)
+	}
+	for _, extra := range inherited[1:] {
+		extra.Close()
+	}
+	return inherited[0], nil
+}
+
+// listenUDP binds addr, or adopts the socket a predecessor passed for it
+func listenUDP(tag, addr string) (net.PacketConn, error) {
+	files := takeInherited(tag)
+	if len(files) == 0 {
+		return net.ListenPacket("udp", addr)
+	}
+	for _, f := range files[1:] {
+		f.Close()
+	}
+	defer files[0].Close()
+	conn, err := net.FilePacketConn(files[0])
+	if err != nil {
+		return nil, fmt.Errorf("inherited socket %s: %w", tag, err)
+	}
+	slog.Info("Using inherited socket", "tag", tag, "count", 1)
+	return conn, nil
+}
+
+// socketFor wraps a bound listener or packet conn for handing over on
+// reload; wrapped types that cannot expose their descriptor are skipped
+func socketFor(tag string, conn any) []reloadSocket {
+	if c, ok := conn.(interface{ File() (*os.File, error) }); ok {
+		return []reloadSocket{{tag: tag, conn: c}}
+	}
+	return nil
+}
+
+// signalReloadReady tells the predecessor that this process is serving so
+// it can drain and exit
+func signalReloadReady() {
+	loadInheritedSockets()
+	inheritedMu.Lock()
+	defer inheritedMu.Unlock()
+	if inheritedReady == nil {
+		return
+	}
+	inheritedReady.Write([]byte{1})
+	inheritedReady.Close()
+	inheritedReady = nil
+	// Sockets the predecessor passed but this configuration does not use
+	for tag, files := range inheritedFiles {
+		for _, f := range files {
+			f.Close()
+		}
+		delete(inheritedFiles, tag)
+	}
+}
+
+// reloadEnv is the successor's environment: this process's, without the
+// socket activation variables that name this process's descriptors
+func reloadEnv(tags []string, readyFD int) []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		switch name {
+		case "LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES", inheritedSocketsEnv, reloadReadyFDEnv:
+			continue
+		}
+		env = append(env, kv)
+	}
+	encoded, _ := json.Marshal(tags)
+	return append(env, inheritedSocketsEnv+"="+string(encoded), reloadReadyFDEnv+"="+strconv.Itoa(readyFD))
+}
+
+// reloadServer starts argv with the running server's sockets, waits for it
+// to report it is serving, then drains and stops this server
+func reloadServer(argv []string, wait time.Duration) (int, error) {
+	currentMu.Lock()
+	state := current
+	var files []*os.File
+	var tags []string
+	var err error
+	if state != nil {
+		for _, s := range state.sockets {
+			var f *os.File
+			if f, err = s.conn.File(); err != nil {
+				err = fmt.Errorf("socket %s: %w", s.tag, err)
+				break
+			}
+			files = append(files, f)
+			tags = append(tags, s.tag)
+		}
+	}
+	currentMu.Unlock()
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	if state == nil {
+		return 0, fmt.Errorf("server is not running")
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	ready, readyW, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer ready.Close()
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = append(files, readyW)
+	cmd.Env = reloadEnv(tags, systemdListenFDsStart+len(files))
+	err = cmd.Start()
+	readyW.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	// The successor writes one byte once serving, or the pipe closes when
+	// it exits
+	result := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		if n, _ := ready.Read(buf); n == 1 {
+			result <- nil
+			return
+		}
+		result <- fmt.Errorf("new process exited before serving")
+	}()
+	select {
+	case err = <-result:
+	case <-time.After(wait):
+		err = fmt.Errorf("new process not serving after %s", wait)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		go cmd.Wait()
+		return 0, err
+	}
+
+	// The successor owns the unix socket paths now
+	for _, s := range state.sockets {
+		if ul, ok := s.conn.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+	pid := cmd.Process.Pid
+	cmd.Process.Release()
+	stopServer()
+	return pid, nil
+}
+
+// ReloadServer hands the listening sockets to a new process and then
+// drains and stops this server, without refusing connections in between.
+// cCommand is a JSON array with the command line to start (empty runs this
+// process's executable with the same arguments); the new process must start
+// the server with the same listener configuration to pick the sockets up.
+// This server stops once the new one is serving or gives up after
+// waitSeconds (0 waits 30 seconds), leaving this one running. Returns the
+// new process ID, or a negative status on failure. The host should exit after a
+// successful reload.
+//
+//export ReloadServer
+func ReloadServer(cCommand *C.char, waitSeconds int) int {
+	if cCommand == nil {
+		return exportError(exportInvalid, "cCommand is nil in ReloadServer")
+	}
+	var argv []string
+	if raw := C.GoString(cCommand); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &argv); err != nil {
+			return exportError(exportInvalid, "Invalid reload command", "error", err)
+		}
+	}
+	if len(argv) == 0 {
+		exe, err := os.Executable()
+		if err != nil {
+			return exportError(exportFailed, "Cannot find executable for reload", "error", err)
+		}
+		argv = append([]string{exe}, os.Args[1:]...)
+	}
+	wait := defaultReloadWait
+	if waitSeconds > 0 {
+		wait = time.Duration(waitSeconds) * time.Second
+	}
+
+	slog.Info("Reloading server", "command", strings.Join(argv, " "))
+	pid, err := reloadServer(argv, wait)
+	if err != nil {
+		return exportError(exportFailed, "Server reload failed", "error", err)
+	}
+	slog.Info("Server handed over to new process", "pid", pid)
+	return pid
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+type requestInfoKey struct{}
+
+// requestInfo is per-request state shared between outer middleware and the
+// dispatcher. Outer layers create it; the dispatcher fills in what it learns.
+type requestInfo struct {
+	ID    string // request ID shared by access logs and handler callbacks
+	Route string // registered route pattern, e.g. /users/{id}
+
+	// Claims holds the verified JWT claims when the jwt middleware is enabled
+	Claims map[string]interface{}
+
+	// Values is the key/value bag shared by middleware, host callbacks,
+	// and tasks submitted from the request
+	Values *requestValues
+
+	// ReleaseAdmission gives up the request's admission slot early, for
+	// long-lived streams. Safe to call more than once.
+	ReleaseAdmission func()
+}
+
+// requestInfoMiddleware attaches a fresh requestInfo to every request and
+// keeps its request-scoped dependencies for as long as it is served. The
+// request ID is propagated from the client when trusted and echoed in the
+// response so errors can be correlated end to end.
+func requestInfoMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := &requestInfo{ID: beginRequestScope(requestIDFor(r)), Values: &requestValues{}}
+		defer endRequestScope(info.ID)
+		defer trackRequestValues(info.ID, info.Values)()
+		w.Header().Set(requestIDConfig.Load().header, info.ID)
+		ctx := context.WithValue(r.Context(), requestInfoKey{}, info)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requestInfoFrom returns the request's shared info, or nil outside the
+// server's handler chain
+func requestInfoFrom(r *http.Request) *requestInfo {
+	info, _ := r.Context().Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+// recordMuxPattern labels requests served by built-in mux endpoints (such as
+// /openapi.json) with their mux pattern. ServeMux sets r.Pattern on the
+// request it is handed, so it is read after the mux has run.
+func recordMuxPattern(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.ServeHTTP(w, r)
+		if info := requestInfoFrom(r); info != nil && info.Route == "" && r.Pattern != "/" {
+			info.Route = r.Pattern
+		}
+	})
+}
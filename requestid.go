@@ -0,0 +1,75 @@
+package main
+
+import (
+	"C"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+)
+
+const (
+	defaultRequestIDHeader = "X-Request-ID"
+	maxRequestIDLength     = 128
+)
+
+// requestIDSettings controls how request IDs are exchanged with clients
+type requestIDSettings struct {
+	header        string
+	trustIncoming bool // reuse a valid ID sent by the client or a proxy
+}
+
+var requestIDConfig atomic.Pointer[requestIDSettings]
+
+func init() {
+	requestIDConfig.Store(&requestIDSettings{header: defaultRequestIDHeader, trustIncoming: true})
+}
+
+// validRequestID accepts short IDs of URL-safe and common separator
+// characters, so client-supplied values are safe to log and echo back
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':', c == '/', c == '+', c == '=':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// requestIDFor returns the incoming request ID when it is trusted and
+// valid, or a freshly generated one
+func requestIDFor(r *http.Request) string {
+	cfg := requestIDConfig.Load()
+	if cfg.trustIncoming {
+		if id := r.Header.Get(cfg.header); validRequestID(id) {
+			return id
+		}
+	}
+	return newRequestID()
+}
+
+// ConfigureRequestID sets the header request IDs are read from and echoed
+// in (default X-Request-ID), and whether incoming IDs are reused. Untrusted
+// or invalid incoming IDs are replaced with generated ones.
+//
+//export ConfigureRequestID
+func ConfigureRequestID(cHeader *C.char, trustIncoming int) int {
+	if cHeader == nil {
+		return exportError(exportInvalid, "cHeader is nil in ConfigureRequestID")
+	}
+	header := http.CanonicalHeaderKey(C.GoString(cHeader))
+	if header == "" {
+		header = defaultRequestIDHeader
+	}
+	requestIDConfig.Store(&requestIDSettings{header: header, trustIncoming: trustIncoming != 0})
+	slog.Info("Request ID configured", "header", header, "trust_incoming", trustIncoming != 0)
+	auditConfigChange("ConfigureRequestID", map[string]string{"header": header, "trust_incoming": strconv.FormatBool(trustIncoming != 0)})
+
+	return 0
+}
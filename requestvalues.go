@@ -0,0 +1,154 @@
+package main
+
+import (
+	"C"
+	"context"
+	"encoding/json"
+	"maps"
+	"sync"
+)
+
+// requestValues is a request's key/value bag. Middleware, host callbacks,
+// and the handler share it for the lifetime of the request, e.g. to pass an
+// auth result on; tasks submitted from the request get a copy.
+type requestValues struct {
+	mu     sync.Mutex
+	values map[string]json.RawMessage
+}
+
+func (v *requestValues) get(key string) (json.RawMessage, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	value, ok := v.values[key]
+	return value, ok
+}
+
+func (v *requestValues) set(key string, value json.RawMessage) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.values == nil {
+		v.values = make(map[string]json.RawMessage)
+	}
+	v.values[key] = value
+}
+
+func (v *requestValues) delete(key string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.values, key)
+}
+
+// snapshot copies the values, or returns nil if there are none
+func (v *requestValues) snapshot() map[string]json.RawMessage {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if len(v.values) == 0 {
+		return nil
+	}
+	return maps.Clone(v.values)
+}
+
+var (
+	activeRequestValues   = make(map[string]*requestValues) // by request ID
+	activeRequestValuesMu sync.Mutex
+)
+
+// trackRequestValues makes a request's bag reachable by its ID for the
+// host exports; the returned func forgets it
+func trackRequestValues(requestID string, values *requestValues) func() {
+	activeRequestValuesMu.Lock()
+	activeRequestValues[requestID] = values
+	activeRequestValuesMu.Unlock()
+	return func() {
+		activeRequestValuesMu.Lock()
+		delete(activeRequestValues, requestID)
+		activeRequestValuesMu.Unlock()
+	}
+}
+
+func activeValues(requestID string) (*requestValues, bool) {
+	activeRequestValuesMu.Lock()
+	defer activeRequestValuesMu.Unlock()
+	v, ok := activeRequestValues[requestID]
+	return v, ok
+}
+
+// requestValuesSnapshot copies a request's values for a host callback
+func requestValuesSnapshot(requestID string) map[string]json.RawMessage {
+	v, ok := activeValues(requestID)
+	if !ok {
+		return nil
+	}
+	return v.snapshot()
+}
+
+// taskOriginFor records the request a task is submitted from, or returns
+// nil outside a request
+func taskOriginFor(ctx context.Context) *taskOrigin {
+	info, _ := ctx.Value(requestInfoKey{}).(*requestInfo)
+	if info == nil {
+		return nil
+	}
+	return &taskOrigin{RequestID: info.ID, Context: info.Values.snapshot()}
+}
+
+// GetRequestValue returns the JSON value stored under cKey for the request
+// cRequestID, or NULL if there is none or the request has finished. The
+// caller must release the result with FreeString.
+//
+//export GetRequestValue
+func GetRequestValue(cRequestID *C.char, cKey *C.char) *C.char {
+	if cRequestID == nil || cKey == nil {
+		recordError("One or more parameters are nil in GetRequestValue")
+		return nil
+	}
+	v, ok := activeValues(C.GoString(cRequestID))
+	if !ok {
+		return nil
+	}
+	value, ok := v.get(C.GoString(cKey))
+	if !ok {
+		return nil
+	}
+	return C.CString(string(value))
+}
+
+// SetRequestValue stores cValue, a JSON value, under cKey for the request
+// cRequestID. Middleware and handlers running later in the request see it
+// in the "context" of their request snapshot, and tasks submitted from the
+// request receive a copy.
+//
+//export SetRequestValue
+func SetRequestValue(cRequestID *C.char, cKey *C.char, cValue *C.char) int {
+	if cRequestID == nil || cKey == nil || cValue == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in SetRequestValue")
+	}
+	requestID := C.GoString(cRequestID)
+	key := C.GoString(cKey)
+	value := C.GoString(cValue)
+	if !json.Valid([]byte(value)) {
+		return exportError(exportInvalid, "Request value is not JSON", "key", key)
+	}
+	v, ok := activeValues(requestID)
+	if !ok {
+		return exportError(exportNotFound, "Cannot set request value, request not active", "request_id", requestID, "key", key)
+	}
+	v.set(key, json.RawMessage(value))
+
+	return 0
+}
+
+// DeleteRequestValue removes cKey from the values of the request
+// cRequestID
+//
+//export DeleteRequestValue
+func DeleteRequestValue(cRequestID *C.char, cKey *C.char) int {
+	if cRequestID == nil || cKey == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in DeleteRequestValue")
+	}
+	if v, ok := activeValues(C.GoString(cRequestID)); ok {
+		v.delete(C.GoString(cKey))
+	}
+
+	return 0
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"C"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// documentedResponse is one entry of SetRouteResponses: a description,
+// optionally with the model the response body follows, registered or built
+// in like ErrorResponse. The JSON form may also be a bare description.
+type documentedResponse struct {
+	Description string `json:"description"`
+	Model       string `json:"model"`
+}
+
+func (d *documentedResponse) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(`"`)) {
+		return json.Unmarshal(data, &d.Description)
+	}
+	type plain documentedResponse
+	return json.Unmarshal(data, (*plain)(d))
+}
+
+// responseDocs is the JSON object SetRouteResponses takes, keyed by status
+// code
+type responseDocs map[string]documentedResponse
+
+var errResponseModelNotFound = errors.New("response model not found")
+
+// parseDocumentedResponses checks SetRouteResponses entries and keys them by
+// status code, filling in missing descriptions
+func parseDocumentedResponses(raw responseDocs) (map[int]documentedResponse, error) {
+	documented := make(map[int]documentedResponse, len(raw))
+	for codeText, response := range raw {
+		code, err := strconv.Atoi(codeText)
+		if err != nil || code < 100 || code > 599 {
+			return nil, fmt.Errorf("invalid documented response status %q", codeText)
+		}
+		if _, builtin := builtinSchemas[response.Model]; response.Model != "" && !builtin && !modelExists(response.Model) {
+			return nil, fmt.Errorf("%w: %s", errResponseModelNotFound, response.Model)
+		}
+		if response.Description == "" {
+			response.Description = http.StatusText(code)
+		}
+		documented[code] = response
+	}
+	return documented, nil
+}
+
+// setSuccessStatus makes status the route's success status; the generic
+// success entry of its documented responses follows it
+func setSuccessStatus(route *RouteInfo, status int) {
+	previous := route.successStatus()
+	if status == previous {
+		return
+	}
+	if desc, ok := route.Responses[previous]; ok && desc == "Successful response" {
+		delete(route.Responses, previous)
+	}
+	route.Status = status
+	if _, ok := route.Responses[status]; !ok {
+		route.Responses[status] = "Successful response"
+	}
+}
+
+// documentResponses adds parsed SetRouteResponses entries to a route
+func documentResponses(route *RouteInfo, documented map[int]documentedResponse) {
+	for code, response := range documented {
+		route.Responses[code] = response.Description
+		if response.Model != "" {
+			if route.ResponseModels == nil {
+				route.ResponseModels = make(map[int]string)
+			}
+			route.ResponseModels[code] = response.Model
+		}
+	}
+}
+
+// SetRouteResponses documents the responses a route may send and sets the
+// status it answers with on success. cResponses is a JSON object of status
+// codes to a description or {"description", "model"}, where model names a
+// registered model documenting the body, e.g. {"201": "Created", "404":
+// {"description": "No such user", "model": "ErrorResponse"}}; entries are
+// added to those already documented. A successStatus between 200 and 299
+// becomes the status of static responses and of handler responses that do
+// not set one; 0 keeps the current one. Returns 0, or a negative status if
+// the route or a model is unknown or the input is invalid.
+//
+//export SetRouteResponses
+func SetRouteResponses(cPath *C.char, cMethod *C.char, successStatus int, cResponses *C.char) int {
+	if cPath == nil || cMethod == nil || cResponses == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in SetRouteResponses")
+	}
+	path := C.GoString(cPath)
+	method := strings.ToUpper(C.GoString(cMethod))
+	if successStatus != 0 && (successStatus < 200 || successStatus > 299) {
+		return exportError(exportInvalid, "Invalid route success status", "path", path, "method", method, "status", successStatus)
+	}
+	var raw responseDocs
+	if text := C.GoString(cResponses); text != "" {
+		if err := json.Unmarshal([]byte(text), &raw); err != nil {
+			return exportError(exportInvalid, "Invalid route responses", "path", path, "method", method, "error", err)
+		}
+	}
+	documented, err := parseDocumentedResponses(raw)
+	if errors.Is(err, errResponseModelNotFound) {
+		return exportError(exportNotFound, "Cannot document response, model not found", "path", path, "method", method, "error", err)
+	}
+	if err != nil {
+		return exportError(exportInvalid, "Invalid route responses", "path", path, "method", method, "error", err)
+	}
+
+	routesMu.Lock()
+	key := path + method
+	route, exists := routes[key]
+	if !exists {
+		routesMu.Unlock()
+		return exportError(exportNotFound, "Cannot set responses, route not found", "key", key)
+	}
+	if successStatus != 0 {
+		setSuccessStatus(&route, successStatus)
+	}
+	documentResponses(&route, documented)
+	routes[key] = route
+	routesMu.Unlock()
+	invalidateOpenAPICache()
+
+	slog.Info("Route responses set", "key", key, "status", route.successStatus(), "responses", len(documented))
+	auditConfigChange("SetRouteResponses", map[string]string{
+		"path":      path,
+		"method":    method,
+		"status":    strconv.Itoa(route.successStatus()),
+		"responses": fmt.Sprint(raw),
+	})
+	return 0
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+type pathParamsKey struct{}
+
+// routeNode is one path segment in the routing tree. Children are matched
+// static first, then {param}, then *wildcard, so lookups cost O(segments).
+type routeNode struct {
+	static   map[string]*routeNode
+	param    *routeNode
+	wildcard *routeNode
+	methods  map[string]string // method -> key into routes
+}
+
+func newRouteNode() *routeNode {
+	return &routeNode{static: make(map[string]*routeNode)}
+}
+
+// routeTree indexes the default host's routes by path; virtual hosts have
+// their own in hostTrees. Guarded by routesMu.
+var routeTree = newRouteNode()
+
+// splitPath splits a URL path into its non-empty segments
+func splitPath(path string) []string {
+	return strings.FieldsFunc(path, func(r rune) bool { return r == '/' })
+}
+
+// treeSegments splits a path for the routing tree. Empty segments are kept
+// so that /foo and /foo/ remain distinct routes; ConfigureRouter can map
+// one onto the other before lookup.
+func treeSegments(path string) []string {
+	return strings.Split(strings.TrimPrefix(path, "/"), "/")
+}
+
+// paramName returns the name of a {param} segment
+func paramName(segment string) (string, bool) {
+	if len(segment) > 2 && segment[0] == '{' && segment[len(segment)-1] == '}' {
+		return segment[1 : len(segment)-1], true
+	}
+	return "", false
+}
+
+// wildcardName returns the name of a trailing *name segment. A bare *
+// makes a prefix route whose remainder is captured as "path".
+func wildcardName(segment string) (string, bool) {
+	if segment == "*" {
+		return "path", true
+	}
+	if len(segment) > 1 && segment[0] == '*' {
+		return segment[1:], true
+	}
+	return "", false
+}
+
+// catchAllPrefix returns the part of a route pattern before its trailing
+// *wildcard, or false for patterns without one
+func catchAllPrefix(pattern string) (string, bool) {
+	i := strings.LastIndexByte(pattern, '/')
+	if _, ok := wildcardName(pattern[i+1:]); !ok {
+		return "", false
+	}
+	return pattern[:i+1], true
+}
+
+// openAPIPath renders a route pattern as an OpenAPI path template. The
+// catch-all segment becomes a {name} parameter, although its value may
+// span several segments.
+func openAPIPath(pattern string) string {
+	prefix, ok := catchAllPrefix(pattern)
+	if !ok {
+		return pattern
+	}
+	name, _ := wildcardName(pattern[len(prefix):])
+	return prefix + "{" + name + "}"
+}
+
+// insert adds a route key to the tree under path and method
+func (n *routeNode) insert(path, method, key string) {
+	node := n
+	segments := treeSegments(path)
+	for i, seg := range segments {
+		if _, ok := wildcardName(seg); ok && i == len(segments)-1 {
+			if node.wildcard == nil {
+				node.wildcard = newRouteNode()
+			}
+			node = node.wildcard
+			break
+		}
+		if _, ok := paramName(seg); ok {
+			if node.param == nil {
+				node.param = newRouteNode()
+			}
+			node = node.param
+			continue
+		}
+		child, exists := node.static[seg]
+		if !exists {
+			child = newRouteNode()
+			node.static[seg] = child
+		}
+		node = child
+	}
+	if node.methods == nil {
+		node.methods = make(map[string]string)
+	}
+	node.methods[method] = key
+}
+
+// rebuildRouteTree replaces the trees with ones indexing the current
+// routes. Used after removals, since nodes are shared between routes.
+// Lookups see either the old or new tree. Must hold routesMu for writing.
+func rebuildRouteTree() {
+	tree := newRouteNode()
+	trees := make(map[string]*routeNode, len(hostTrees))
+	for host := range hostTrees {
+		trees[host] = newRouteNode()
+	}
+	for key, route := range routes {
+		if route.Host != "" {
+			trees[route.Host].insert(route.Path, route.Method, key)
+			continue
+		}
+		tree.insert(route.Path, route.Method, key)
+	}
+	routeTree, hostTrees = tree, trees
+}
+
+// lookup finds the node matching path. Static children take priority and
+// the search backtracks to parameter and wildcard children when a more
+// specific branch dead-ends. Captured segments are named by routeParams.
+func (n *routeNode) lookup(segments []string) *routeNode {
+	if len(segments) == 0 {
+		if n.methods != nil {
+			return n
+		}
+		return nil
+	}
+	seg, rest := segments[0], segments[1:]
+	if child, ok := n.static[seg]; ok {
+		if found := child.lookup(rest); found != nil {
+			return found
+		}
+	}
+	if n.param != nil && seg != "" {
+		if found := n.param.lookup(rest); found != nil {
+			return found
+		}
+	}
+	if n.wildcard != nil && n.wildcard.methods != nil {
+		return n.wildcard
+	}
+	return nil
+}
+
+// allowedMethods returns the sorted methods a node answers: those
+// registered, plus HEAD for GET routes and OPTIONS, which the dispatcher
+// answers itself
+func (n *routeNode) allowedMethods() []string {
+	methods := make([]string, 0, len(n.methods)+2)
+	for method := range n.methods {
+		methods = append(methods, method)
+	}
+	if _, ok := n.methods[http.MethodHead]; !ok {
+		if _, ok := n.methods[http.MethodGet]; ok {
+			methods = append(methods, http.MethodHead)
+		}
+	}
+	if _, ok := n.methods[http.MethodOptions]; !ok {
+		methods = append(methods, http.MethodOptions)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// findRoute looks up the route for a request's Host header, path, and
+// method. HEAD requests match a path's GET route unless HEAD is registered
+// itself; the server discards the body. If the path is registered only
+// under other methods, allowed lists them. Must be called with routesMu
+// held.
+func findRoute(host, path, method string) (route RouteInfo, params map[string]string, allowed []string, found bool) {
+	segments := treeSegments(path)
+	node := routeTreeFor(host).lookup(segments)
+	if node == nil {
+		return RouteInfo{}, nil, nil, false
+	}
+	key, exists := node.methods[method]
+	if !exists && method == http.MethodHead {
+		key, exists = node.methods[http.MethodGet]
+	}
+	if !exists {
+		return RouteInfo{}, nil, node.allowedMethods(), false
+	}
+	route = routes[key]
+	return route, routeParams(route.Path, segments), nil, true
+}
+
+// routeParams names the segments a route pattern captures. Routes sharing a
+// tree node may name its parameter differently, so the names come from the
+// matched route rather than the node. Patterns without parameters get nil.
+func routeParams(pattern string, segments []string) map[string]string {
+	if !strings.ContainsAny(pattern, "{*") {
+		return nil
+	}
+	params := make(map[string]string)
+	patternSegments := treeSegments(pattern)
+	for i, seg := range patternSegments {
+		if i >= len(segments) {
+			break
+		}
+		if name, ok := wildcardName(seg); ok && i == len(patternSegments)-1 {
+			params[name] = strings.Join(segments[i:], "/")
+			break
+		}
+		if name, ok := paramName(seg); ok {
+			params[name] = segments[i]
+		}
+	}
+	return params
+}
+
+// pathParameters builds the OpenAPI parameter list for a route pattern
+func pathParameters(path string) []ParameterInfo {
+	params := []ParameterInfo{}
+	segments := splitPath(path)
+	for i, seg := range segments {
+		if name, ok := paramName(seg); ok {
+			params = append(params, ParameterInfo{
+				Name:     name,
+				In:       "path",
+				Required: true,
+				Type:     "string",
+			})
+		} else if name, ok := wildcardName(seg); ok && i == len(segments)-1 {
+			params = append(params, ParameterInfo{
+				Name:        name,
+				In:          "path",
+				Description: "Remainder of the path; may contain slashes and be empty",
+				Required:    true,
+				Type:        "string",
+			})
+		}
+	}
+	return params
+}
+
+// withPathParams stores captured path parameters in the request context
+func withPathParams(r *http.Request, params map[string]string) *http.Request {
+	if len(params) == 0 {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), pathParamsKey{}, params))
+}
+
+// PathParams returns the path parameters captured for the request
+func PathParams(r *http.Request) map[string]string {
+	params, _ := r.Context().Value(pathParamsKey{}).(map[string]string)
+	return params
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"C"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// JSON-RPC 2.0 error codes
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+)
+
+var (
+	rpcMethods   = make(map[string]uintptr)
+	rpcMethodsMu sync.RWMutex
+)
+
+// RPCError is a JSON-RPC error object
+type RPCError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// RPCCall is the JSON sent to a method callback
+type RPCCall struct {
+	Method       string          `json:"method"`
+	Params       json.RawMessage `json:"params,omitempty"`
+	ID           json.RawMessage `json:"id,omitempty"`
+	Notification bool            `json:"notification"`
+	RequestID    string          `json:"request_id,omitempty"`
+	RemoteAddr   string          `json:"remote_addr"`
+}
+
+// RPCResult is a method callback's reply: a result, or an error object.
+// Callbacks report bad params with code -32602; application errors should
+// use codes outside the reserved -32768 to -32000 range.
+type RPCResult struct {
+	Result json.RawMessage `json:"result"`
+	Error  *RPCError       `json:"error"`
+}
+
+// rpcResponse is one JSON-RPC response object
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+var rpcNull = json.RawMessage("null")
+
+func rpcErrorResponse(id json.RawMessage, code int, message string) *rpcResponse {
+	if id == nil {
+		id = rpcNull
+	}
+	return &rpcResponse{JSONRPC: "2.0", Error: &RPCError{Code: code, Message: message}, ID: id}
+}
+
+// validRPCID reports whether a request id is a string, number, or null
+func validRPCID(id json.RawMessage) bool {
+	var v interface{}
+	if json.Unmarshal(id, &v) != nil {
+		return false
+	}
+	switch v.(type) {
+	case string, float64, nil:
+		return true
+	}
+	return false
+}
+
+// rpcCall runs one request object. It returns nil for notifications, which
+// get no response even when they fail.
+func rpcCall(r *http.Request, raw json.RawMessage) *rpcResponse {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return rpcErrorResponse(nil, rpcInvalidRequest, "Invalid Request")
+	}
+	id, hasID := fields["id"]
+	if hasID && !validRPCID(id) {
+		return rpcErrorResponse(nil, rpcInvalidRequest, "Invalid Request")
+	}
+	var version, method string
+	params := bytes.TrimSpace(fields["params"])
+	if json.Unmarshal(fields["jsonrpc"], &version) != nil || version != "2.0" ||
+		json.Unmarshal(fields["method"], &method) != nil ||
+		(len(params) > 0 && params[0] != '[' && params[0] != '{') {
+		return rpcErrorResponse(id, rpcInvalidRequest, "Invalid Request")
+	}
+	notification := !hasID
+	respond := func(resp *rpcResponse) *rpcResponse {
+		if notification {
+			return nil
+		}
+		return resp
+	}
+
+	rpcMethodsMu.RLock()
+	handler, ok := rpcMethods[method]
+	rpcMethodsMu.RUnlock()
+	if !ok {
+		return respond(rpcErrorResponse(id, rpcMethodNotFound, "Method not found"))
+	}
+	call := RPCCall{
+		Method:       method,
+		Params:       params,
+		ID:           id,
+		Notification: notification,
+		RemoteAddr:   r.RemoteAddr,
+	}
+	if info := requestInfoFrom(r); info != nil {
+		call.RequestID = info.ID
+	}
+	request, err := json.Marshal(call)
+	if err != nil {
+		return respond(rpcErrorResponse(id, rpcInternalError, "Internal error"))
+	}
+	out, ok := callRouteHandler(handler, request)
+	if !ok {
+		slog.Error("RPC method returned no response", "method", call.Method)
+		return respond(rpcErrorResponse(id, rpcInternalError, "Internal error"))
+	}
+	var result RPCResult
+	if err := json.Unmarshal(out, &result); err != nil {
+		slog.Error("Error decoding RPC method response", "method", call.Method, "error", err)
+		return respond(rpcErrorResponse(id, rpcInternalError, "Internal error"))
+	}
+	if result.Error != nil {
+		return respond(&rpcResponse{JSONRPC: "2.0", Error: result.Error, ID: id})
+	}
+	if result.Result == nil {
+		result.Result = rpcNull
+	}
+	return respond(&rpcResponse{JSONRPC: "2.0", Result: result.Result, ID: id})
+}
+
+// ServeRPC answers JSON-RPC 2.0 requests, single or batched, with the
+// registered methods. Requests that are all notifications get 204.
+func ServeRPC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		http.Error(w, `{"error": "Content-Type must be application/json"}`, http.StatusUnsupportedMediaType)
+		return
+	}
+	if !limitRequestBody(w, r, 0) {
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeBodyReadError(w, err)
+		return
+	}
+	body = bytes.TrimSpace(body)
+
+	var response interface{}
+	switch {
+	case !json.Valid(body):
+		response = rpcErrorResponse(nil, rpcParseError, "Parse error")
+	case len(body) > 0 && body[0] == '[':
+		var batch []json.RawMessage
+		json.Unmarshal(body, &batch)
+		if len(batch) == 0 {
+			response = rpcErrorResponse(nil, rpcInvalidRequest, "Invalid Request")
+			break
+		}
+		responses := make([]*rpcResponse, 0, len(batch))
+		for _, raw := range batch {
+			if resp := rpcCall(r, raw); resp != nil {
+				responses = append(responses, resp)
+			}
+		}
+		if len(responses) > 0 {
+			response = responses
+		}
+	default:
+		if resp := rpcCall(r, body); resp != nil {
+			response = resp
+		}
+	}
+	if response == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := writeJSON(w, response); err != nil {
+		slog.Error("Error writing RPC response", "error", err)
+	}
+}
+
+// rpcHandler serves /rpc once a method is registered, and otherwise leaves
+// the path to registered routes
+func rpcHandler(dispatch http.Handler) http.Handler {
+	endpoint := admissionMiddleware(http.HandlerFunc(ServeRPC))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rpcMethodsMu.RLock()
+		registered := len(rpcMethods) > 0
+		rpcMethodsMu.RUnlock()
+		if !registered {
+			dispatch.ServeHTTP(w, r)
+			return
+		}
+		endpoint.ServeHTTP(w, r)
+	})
+}
+
+// RegisterRPCMethod serves a JSON-RPC method at /rpc through a host
+// callback. The callback gets an RPCCall JSON object and returns an
+// RPCResult; for notifications the reply is discarded. Names starting with
+// "rpc." are reserved by JSON-RPC.
+//
+//export RegisterRPCMethod
+func RegisterRPCMethod(cName *C.char, cHandler uintptr) int {
+	if cName == nil || cHandler == 0 {
+		return exportError(exportInvalid, "One or more parameters are nil in RegisterRPCMethod")
+	}
+	name := C.GoString(cName)
+	if name == "" || strings.HasPrefix(name, "rpc.") {
+		return exportError(exportInvalid, "Invalid method name in RegisterRPCMethod", "method", name)
+	}
+	rpcMethodsMu.Lock()
+	rpcMethods[name] = cHandler
+	rpcMethodsMu.Unlock()
+
+	slog.Info("RPC method registered", "method", name)
+	auditConfigChange("RegisterRPCMethod", map[string]string{"method": name})
+
+	return 0
+}
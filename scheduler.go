@@ -0,0 +1,416 @@
+package main
+
+import (
+	"C"
+	"context"
+	"encoding/json"
+	"log/slog"
+	mathrand "math/rand/v2"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Overlap policies for a schedule that fires while its previous run is
+// still queued or running
+const (
+	OverlapSkip     = "skip"     // drop the firing
+	OverlapQueue    = "queue"    // run once more after the current run finishes
+	OverlapParallel = "parallel" // run alongside it
+)
+
+// ScheduleOptions configures a cron schedule
+type ScheduleOptions struct {
+	Overlap  string `json:"overlap"`   // skip (default), queue, or parallel
+	JitterMs int    `json:"jitter_ms"` // random delay added to each firing
+	Timezone string `json:"timezone"`  // IANA name the expression is read in; defaults to UTC
+}
+
+// taskSchedule fires a registered task on a cron expression
+type taskSchedule struct {
+	name string
+	expr string
+	cron *cronSchedule
+	opts ScheduleOptions
+
+	mu       sync.Mutex
+	ctx      context.Context // task context of the running server; nil while stopped
+	next     time.Time       // next firing, jitter included
+	active   int             // runs queued or running
+	queued   bool            // a firing is waiting for the active run (OverlapQueue)
+	lastTask string
+	lastRun  time.Time
+	skipped  int
+}
+
+// ScheduleStatus is the /tasks/schedules view of a schedule
+type ScheduleStatus struct {
+	Task     string     `json:"task"`
+	Cron     string     `json:"cron"`
+	Overlap  string     `json:"overlap"`
+	JitterMs int        `json:"jitter_ms,omitempty"`
+	Timezone string     `json:"timezone"`
+	NextRun  *time.Time `json:"next_run,omitempty"` // unset while the server is stopped
+	LastRun  *time.Time `json:"last_run,omitempty"`
+	LastTask string     `json:"last_task,omitempty"`
+	Active   int        `json:"active"`
+	Skipped  int        `json:"skipped"`
+}
+
+// oneShotTask is a RunTaskAt task waiting for its time
+type oneShotTask struct {
+	id   string
+	name string
+	at   time.Time
+}
+
+var (
+	taskSchedules = make(map[string]*taskSchedule)
+	oneShotTasks  []oneShotTask
+	schedulerMu   sync.Mutex
+	schedulerWake = make(chan struct{}, 1)
+	schedulerCtx  context.Context // set while the server runs
+)
+
+// wakeScheduler makes the scheduler recompute its next deadline
+func wakeScheduler() {
+	select {
+	case schedulerWake <- struct{}{}:
+	default:
+	}
+}
+
+// jitter returns a random delay up to the schedule's jitter
+func (s *taskSchedule) jitter() time.Duration {
+	if s.opts.JitterMs <= 0 {
+		return 0
+	}
+	return time.Duration(mathrand.Int64N(int64(s.opts.JitterMs)+1)) * time.Millisecond
+}
+
+// fire runs the schedule's task unless the overlap policy holds it back
+func (s *taskSchedule) fire(now time.Time) {
+	s.mu.Lock()
+	if s.active > 0 {
+		switch s.opts.Overlap {
+		case OverlapSkip:
+			s.skipped++
+			s.mu.Unlock()
+			slog.Info("Scheduled task skipped, previous run still active", "task", s.name)
+			return
+		case OverlapQueue:
+			s.queued = true
+			s.mu.Unlock()
+			return
+		}
+	}
+	s.active++
+	s.lastRun = now
+	ctx := s.ctx
+	s.mu.Unlock()
+	s.submit(ctx, now)
+}
+
+// submit queues one run without blocking the scheduler
+func (s *taskSchedule) submit(ctx context.Context, scheduledAt time.Time) {
+	id := tasks.create(s.name, nil, s.runFinished)
+	s.mu.Lock()
+	s.lastTask = id
+	s.mu.Unlock()
+	run, ok := namedTaskRun(id, s.name, scheduledAt)
+	if !ok {
+		tasks.finish(id, "", errTaskNotRegistered)
+		return
+	}
+	go enqueueTask(ctx, BackpressureQueue, id, run)
+}
+
+// runFinished starts a queued firing once the active run is done
+func (s *taskSchedule) runFinished() {
+	s.mu.Lock()
+	s.active--
+	ctx := s.ctx
+	again := s.queued && s.active == 0 && ctx != nil && ctx.Err() == nil
+	if again {
+		s.queued = false
+		s.active++
+		s.lastRun = time.Now()
+	}
+	s.mu.Unlock()
+	if again {
+		s.submit(ctx, time.Now())
+	}
+}
+
+// status snapshots the schedule
+func (s *taskSchedule) status() ScheduleStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := ScheduleStatus{
+		Task:     s.name,
+		Cron:     s.expr,
+		Overlap:  s.opts.Overlap,
+		JitterMs: s.opts.JitterMs,
+		Timezone: s.cron.loc.String(),
+		LastTask: s.lastTask,
+		Active:   s.active,
+		Skipped:  s.skipped,
+	}
+	if !s.next.IsZero() && s.ctx != nil {
+		next := s.next.UTC()
+		st.NextRun = &next
+	}
+	if !s.lastRun.IsZero() {
+		last := s.lastRun.UTC()
+		st.LastRun = &last
+	}
+	return st
+}
+
+// planNext sets the schedule's next firing after now
+func (s *taskSchedule) planNext(now time.Time) {
+	next := s.cron.next(now)
+	if !next.IsZero() {
+		next = next.Add(s.jitter())
+	}
+	s.mu.Lock()
+	s.next = next
+	s.mu.Unlock()
+}
+
+// attach binds the schedule to the running server's task context, or
+// detaches it when ctx is nil
+func (s *taskSchedule) attach(ctx context.Context, now time.Time) {
+	s.mu.Lock()
+	s.ctx = ctx
+	s.mu.Unlock()
+	if ctx != nil {
+		s.planNext(now)
+	}
+}
+
+// runDue fires every schedule and one-shot task that is due and returns
+// the time of the next one
+func runDue(now time.Time) time.Time {
+	schedulerMu.Lock()
+	defer schedulerMu.Unlock()
+	var earliest time.Time
+	consider := func(t time.Time) {
+		if !t.IsZero() && (earliest.IsZero() || t.Before(earliest)) {
+			earliest = t
+		}
+	}
+	for _, s := range taskSchedules {
+		s.mu.Lock()
+		due := !s.next.IsZero() && !s.next.After(now)
+		s.mu.Unlock()
+		if due {
+			s.fire(now)
+			s.planNext(now)
+		}
+		consider(s.next)
+	}
+	pending := oneShotTasks[:0]
+	for _, task := range oneShotTasks {
+		if task.at.After(now) {
+			pending = append(pending, task)
+			consider(task.at)
+			continue
+		}
+		run, ok := namedTaskRun(task.id, task.name, task.at)
+		if !ok {
+			tasks.finish(task.id, "", errTaskNotRegistered)
+			continue
+		}
+		go enqueueTask(schedulerCtx, BackpressureQueue, task.id, run)
+	}
+	oneShotTasks = pending
+	return earliest
+}
+
+// startScheduler runs schedules until ctx, the task context of the running
+// server, ends. Firings missed while the server was stopped are not caught
+// up; overdue one-shot tasks run on start.
+func startScheduler(ctx context.Context) {
+	now := time.Now()
+	schedulerMu.Lock()
+	schedulerCtx = ctx
+	for _, s := range taskSchedules {
+		s.attach(ctx, now)
+	}
+	schedulerMu.Unlock()
+
+	go func() {
+		timer := time.NewTimer(0)
+		defer timer.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				schedulerMu.Lock()
+				if schedulerCtx == ctx {
+					schedulerCtx = nil
+					for _, s := range taskSchedules {
+						s.attach(nil, time.Time{})
+					}
+				}
+				schedulerMu.Unlock()
+				return
+			case <-schedulerWake:
+			case <-timer.C:
+			}
+			next := runDue(time.Now())
+			timer.Stop()
+			if next.IsZero() {
+				// Nothing scheduled; wait to be woken
+				timer.Reset(time.Hour)
+			} else {
+				timer.Reset(time.Until(next))
+			}
+		}
+	}()
+}
+
+// scheduleStatuses lists schedules by task name
+func scheduleStatuses() []ScheduleStatus {
+	schedulerMu.Lock()
+	defer schedulerMu.Unlock()
+	result := make([]ScheduleStatus, 0, len(taskSchedules))
+	for _, s := range taskSchedules {
+		result = append(result, s.status())
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Task < result[j].Task })
+	return result
+}
+
+// ServeTaskSchedules handles GET /tasks/schedules
+func ServeTaskSchedules(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := writeJSON(w, scheduleStatuses()); err != nil {
+		slog.Error("Error encoding task schedules", "error", err)
+	}
+}
+
+// ScheduleTask runs a registered task on a cron expression, e.g.
+// "*/5 * * * *", "@daily", or "@every 30s". A task has one schedule;
+// scheduling it again replaces it and an empty expression removes it.
+// cOptions is a JSON ScheduleOptions object and may be empty.
+//
+//export ScheduleTask
+func ScheduleTask(cCronExpr *C.char, cTaskName *C.char, cOptions *C.char) int {
+	if cCronExpr == nil || cTaskName == nil || cOptions == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in ScheduleTask")
+	}
+	expr := strings.TrimSpace(C.GoString(cCronExpr))
+	name := C.GoString(cTaskName)
+	options := C.GoString(cOptions)
+
+	if expr == "" {
+		schedulerMu.Lock()
+		if s, ok := taskSchedules[name]; ok {
+			s.attach(nil, time.Time{})
+			delete(taskSchedules, name)
+		}
+		schedulerMu.Unlock()
+		wakeScheduler()
+		slog.Info("Task schedule removed", "task", name)
+		auditConfigChange("ScheduleTask", map[string]string{"task": name, "cron": ""})
+		return 0
+	}
+
+	if !taskRegistered(name) {
+		return exportError(exportNotFound, "Cannot schedule task, task not registered", "task", name)
+	}
+	opts := ScheduleOptions{Overlap: OverlapSkip}
+	if options != "" {
+		if err := json.Unmarshal([]byte(options), &opts); err != nil {
+			return exportError(exportInvalid, "Invalid schedule options", "task", name, "error", err)
+		}
+	}
+	opts.Overlap = strings.ToLower(opts.Overlap)
+	if opts.Overlap == "" {
+		opts.Overlap = OverlapSkip
+	}
+	if opts.Overlap != OverlapSkip && opts.Overlap != OverlapQueue && opts.Overlap != OverlapParallel {
+		return exportError(exportInvalid, "Unknown overlap policy", "task", name, "overlap", opts.Overlap)
+	}
+	if opts.JitterMs < 0 {
+		return exportError(exportInvalid, "Invalid schedule jitter", "task", name, "jitter_ms", opts.JitterMs)
+	}
+	loc := time.UTC
+	if opts.Timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(opts.Timezone); err != nil {
+			return exportError(exportInvalid, "Unknown schedule timezone", "task", name, "timezone", opts.Timezone, "error", err)
+		}
+	}
+	cron, err := parseCron(expr, loc)
+	if err != nil {
+		return exportError(exportInvalid, "Invalid cron expression", "task", name, "cron", expr, "error", err)
+	}
+
+	schedulerMu.Lock()
+	s, ok := taskSchedules[name]
+	if ok {
+		// Keep the run state so runs started by the old expression still
+		// count against the overlap policy
+		s.mu.Lock()
+		s.expr, s.cron, s.opts = expr, cron, opts
+		s.mu.Unlock()
+	} else {
+		s = &taskSchedule{name: name, expr: expr, cron: cron, opts: opts}
+		taskSchedules[name] = s
+	}
+	s.attach(schedulerCtx, time.Now())
+	schedulerMu.Unlock()
+	wakeScheduler()
+
+	slog.Info("Task scheduled", "task", name, "cron", expr, "overlap", opts.Overlap, "timezone", loc.String())
+	auditConfigChange("ScheduleTask", map[string]string{"task": name, "cron": expr, "options": options})
+
+	return 0
+}
+
+// RunTaskAt queues a registered task once at a Unix time in milliseconds
+// and returns its task ID, which /tasks/{id} reports as "scheduled" until
+// then. A time in the past runs it right away while the server is running,
+// or at the next start. Returns NULL on error; the caller must release the
+// ID with FreeString.
+//
+//export RunTaskAt
+func RunTaskAt(timestampMs int64, cTaskName *C.char) *C.char {
+	if cTaskName == nil {
+		recordError("cTaskName is nil in RunTaskAt")
+		return nil
+	}
+	name := C.GoString(cTaskName)
+	if !taskRegistered(name) {
+		recordError("Cannot run task, task not registered", "task", name)
+		return nil
+	}
+	at := time.UnixMilli(timestampMs)
+	id := tasks.schedule(name, at, nil, nil)
+
+	schedulerMu.Lock()
+	oneShotTasks = append(oneShotTasks, oneShotTask{id: id, name: name, at: at})
+	schedulerMu.Unlock()
+	wakeScheduler()
+
+	slog.Info("Task scheduled once", "task", name, "task_id", id, "at", at.UTC())
+	auditConfigChange("RunTaskAt", map[string]string{"task": name, "at": at.UTC().Format(time.RFC3339), "task_id": id})
+	return C.CString(id)
+}
+
+// GetTaskSchedules returns the cron schedules as a JSON array, as served at
+// /tasks/schedules. The caller must release it with FreeString.
+//
+//export GetTaskSchedules
+func GetTaskSchedules() *C.char {
+	data, err := json.Marshal(scheduleStatuses())
+	if err != nil {
+		recordError("Error encoding task schedules", "error", err)
+		return C.CString("[]")
+	}
+	return C.CString(string(data))
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"C"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// SecureHeadersOptions configures the "secureheaders" middleware, globally
+// through its options or per route through SetRouteSecureHeaders. Setting a
+// header's option to "" (or hsts_max_age to 0) leaves that header out.
+type SecureHeadersOptions struct {
+	HSTSMaxAge            int    `json:"hsts_max_age"`            // seconds; defaults to one year
+	HSTSIncludeSubdomains bool   `json:"hsts_include_subdomains"` // defaults to true
+	HSTSPreload           bool   `json:"hsts_preload"`
+	ContentTypeOptions    string `json:"content_type_options"`    // defaults to nosniff
+	FrameOptions          string `json:"frame_options"`           // defaults to DENY
+	ReferrerPolicy        string `json:"referrer_policy"`         // defaults to strict-origin-when-cross-origin
+	ContentSecurityPolicy string `json:"content_security_policy"` // not sent unless set
+	CSPReportOnly         bool   `json:"csp_report_only"`         // send the policy as Content-Security-Policy-Report-Only
+}
+
+// parseSecureHeadersOptions decodes options JSON over the defaults
+func parseSecureHeadersOptions(options []byte) (*SecureHeadersOptions, error) {
+	opts := &SecureHeadersOptions{
+		HSTSMaxAge:            31536000,
+		HSTSIncludeSubdomains: true,
+		ContentTypeOptions:    "nosniff",
+		FrameOptions:          "DENY",
+		ReferrerPolicy:        "strict-origin-when-cross-origin",
+	}
+	if len(options) > 0 {
+		if err := json.Unmarshal(options, opts); err != nil {
+			return nil, err
+		}
+	}
+	if opts.HSTSMaxAge < 0 {
+		return nil, fmt.Errorf("hsts_max_age must not be negative")
+	}
+	return opts, nil
+}
+
+// hsts returns the Strict-Transport-Security value, or "" when disabled
+func (opts *SecureHeadersOptions) hsts() string {
+	if opts.HSTSMaxAge == 0 {
+		return ""
+	}
+	value := "max-age=" + strconv.Itoa(opts.HSTSMaxAge)
+	if opts.HSTSIncludeSubdomains {
+		value += "; includeSubDomains"
+	}
+	if opts.HSTSPreload {
+		value += "; preload"
+	}
+	return value
+}
+
+// apply sets the configured headers. HSTS is only sent over HTTPS, where
+// browsers honor it, including behind a TLS-terminating proxy.
+func (opts *SecureHeadersOptions) apply(h http.Header, r *http.Request) {
+	if hsts := opts.hsts(); hsts != "" && (r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")) {
+		h.Set("Strict-Transport-Security", hsts)
+	}
+	if opts.ContentTypeOptions != "" {
+		h.Set("X-Content-Type-Options", opts.ContentTypeOptions)
+	}
+	if opts.FrameOptions != "" {
+		h.Set("X-Frame-Options", opts.FrameOptions)
+	}
+	if opts.ReferrerPolicy != "" {
+		h.Set("Referrer-Policy", opts.ReferrerPolicy)
+	}
+	if opts.ContentSecurityPolicy != "" {
+		name := "Content-Security-Policy"
+		if opts.CSPReportOnly {
+			name += "-Report-Only"
+		}
+		h.Set(name, opts.ContentSecurityPolicy)
+	}
+}
+
+// newSecureHeadersMiddleware builds the "secureheaders" middleware. Headers
+// are set before the route runs, so handlers can still override them;
+// routes configured with SetRouteSecureHeaders use their own options.
+func newSecureHeadersMiddleware(options []byte) (func(http.Handler) http.Handler, error) {
+	defaults, err := parseSecureHeadersOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			routesMu.RLock()
+			route, _, _, found := findRoute(r.Host, r.URL.Path, r.Method)
+			routesMu.RUnlock()
+			opts := defaults
+			if found && route.SecureHeaders != nil {
+				opts = route.SecureHeaders
+			}
+			opts.apply(w.Header(), r)
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+// SetRouteSecureHeaders overrides the secureheaders middleware options for
+// one route, e.g. to allow framing or relax the Content-Security-Policy.
+// Options not given take the defaults, not the middleware's options.
+//
+//export SetRouteSecureHeaders
+func SetRouteSecureHeaders(cPath *C.char, cMethod *C.char, cOptions *C.char) int {
+	if cPath == nil || cMethod == nil || cOptions == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in SetRouteSecureHeaders")
+	}
+	path := C.GoString(cPath)
+	method := strings.ToUpper(C.GoString(cMethod))
+	options := C.GoString(cOptions)
+	opts, err := parseSecureHeadersOptions([]byte(options))
+	if err != nil {
+		return exportError(exportInvalid, "Invalid secure headers options", "path", path, "method", method, "error", err)
+	}
+
+	routesMu.Lock()
+	key := path + method
+	route, exists := routes[key]
+	if !exists {
+		routesMu.Unlock()
+		return exportError(exportNotFound, "Cannot set secure headers options, route not found", "key", key)
+	}
+	route.SecureHeaders = opts
+	routes[key] = route
+	routesMu.Unlock()
+
+	slog.Info("Route secure headers options set", "key", key)
+	auditConfigChange("SetRouteSecureHeaders", map[string]string{"path": path, "method": method, "options": options})
+
+	return 0
+}
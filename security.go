@@ -0,0 +1,57 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Security schemes advertised in the OpenAPI document by auth middleware
+var (
+	securitySchemes   = make(map[string]interface{})
+	securitySchemesMu sync.RWMutex
+)
+
+// authExemptPrefixes are served without authentication so the API docs and
+// health probes stay reachable
+var authExemptPrefixes = []string{"/openapi.json", "/swagger/", "/redoc", "/healthz", "/readyz"}
+
+// registerSecurityScheme adds an OpenAPI security scheme, e.g. bearerAuth
+func registerSecurityScheme(name string, scheme map[string]interface{}) {
+	securitySchemesMu.Lock()
+	securitySchemes[name] = scheme
+	securitySchemesMu.Unlock()
+	invalidateOpenAPICache()
+}
+
+// openAPISecurity returns the registered schemes and a top-level security
+// requirement accepting any of them
+func openAPISecurity() (map[string]interface{}, []map[string][]string) {
+	securitySchemesMu.RLock()
+	defer securitySchemesMu.RUnlock()
+	if len(securitySchemes) == 0 {
+		return nil, nil
+	}
+	schemes := make(map[string]interface{}, len(securitySchemes))
+	names := make([]string, 0, len(securitySchemes))
+	for name, scheme := range securitySchemes {
+		schemes[name] = scheme
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	requirements := make([]map[string][]string, 0, len(names))
+	for _, name := range names {
+		requirements = append(requirements, map[string][]string{name: {}})
+	}
+	return schemes, requirements
+}
+
+// authExempt reports whether path skips authentication middleware
+func authExempt(path string, exempt []string) bool {
+	for _, prefix := range exempt {
+		if path == prefix || (strings.HasSuffix(prefix, "/") && strings.HasPrefix(path, prefix)) {
+			return true
+		}
+	}
+	return false
+}
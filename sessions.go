@@ -0,0 +1,473 @@
+package main
+
+import (
+	"C"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxSessionCookie is the largest cookie value browsers reliably keep
+const maxSessionCookie = 4000
+
+var errSessionSent = errors.New("session cookie was already sent with the response headers")
+
+// sessionOptions configures the "session" middleware. Sessions live in a
+// signed, by default encrypted, cookie unless redis or redis_addr is set, in
+// which case the cookie only carries the session ID.
+type sessionOptions struct {
+	Secret        string `json:"secret"`      // signs and encrypts cookies; required for cookie sessions
+	CookieName    string `json:"cookie_name"` // defaults to goserver_session
+	MaxAge        int    `json:"max_age"`     // seconds; defaults to one day
+	Path          string `json:"path"`        // defaults to /
+	Domain        string `json:"domain"`
+	Secure        *bool  `json:"secure"`    // defaults to true on TLS connections
+	HTTPOnly      *bool  `json:"http_only"` // defaults to true
+	SameSite      string `json:"same_site"` // lax (default), strict or none
+	Encrypt       *bool  `json:"encrypt"`   // cookie sessions: encrypt as well as sign; defaults to true
+	Redis         string `json:"redis"`     // name of a client registered with RegisterRedis
+	RedisAddr     string `json:"redis_addr"`
+	RedisPassword string `json:"redis_password"`
+	RedisDB       int    `json:"redis_db"`
+	KeyPrefix     string `json:"key_prefix"` // defaults to goserver:session:
+}
+
+// session is one request's view of its session values, which are JSON
+type session struct {
+	mu        sync.Mutex
+	id        string // server-side session ID; empty for cookie sessions and new ones
+	values    map[string]json.RawMessage
+	dirty     bool
+	cleared   bool // the previous session was dropped by ClearSession
+	committed bool // the cookie has been written
+}
+
+// cookieSession is the payload of a cookie session
+type cookieSession struct {
+	Values  map[string]json.RawMessage `json:"v"`
+	Expires int64                      `json:"e"`
+}
+
+// sessionManager loads and saves sessions for the middleware
+type sessionManager struct {
+	opts   sessionOptions
+	macKey []byte
+	aead   cipher.AEAD // nil when cookies are only signed
+	redis  *redisClient
+}
+
+var (
+	activeSessions   = make(map[string]*session) // by request ID
+	activeSessionsMu sync.Mutex
+)
+
+func newSessionMiddleware(options []byte) (func(http.Handler) http.Handler, error) {
+	opts := sessionOptions{CookieName: "goserver_session", MaxAge: 86400, Path: "/", SameSite: "lax", KeyPrefix: "goserver:session:"}
+	if len(options) > 0 {
+		if err := json.Unmarshal(options, &opts); err != nil {
+			return nil, err
+		}
+	}
+	if opts.CookieName == "" || opts.MaxAge <= 0 {
+		return nil, fmt.Errorf("cookie_name must be set and max_age positive")
+	}
+	switch strings.ToLower(opts.SameSite) {
+	case "lax", "strict", "none":
+	default:
+		return nil, fmt.Errorf("unknown same_site %q", opts.SameSite)
+	}
+	client, err := middlewareRedis(opts.Redis, opts.RedisAddr, opts.RedisPassword, opts.RedisDB)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil && opts.Secret == "" {
+		return nil, fmt.Errorf("cookie sessions need a secret")
+	}
+	m := &sessionManager{opts: opts, redis: client}
+	if opts.Secret != "" {
+		mac := sha256.Sum256([]byte("goserver-session-mac:" + opts.Secret))
+		m.macKey = mac[:]
+	}
+	if client == nil && (opts.Encrypt == nil || *opts.Encrypt) {
+		key := sha256.Sum256([]byte("goserver-session-enc:" + opts.Secret))
+		block, err := aes.NewCipher(key[:])
+		if err != nil {
+			return nil, err
+		}
+		if m.aead, err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			info := requestInfoFrom(r)
+			if info == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			s := m.load(r)
+			activeSessionsMu.Lock()
+			activeSessions[info.ID] = s
+			activeSessionsMu.Unlock()
+			defer func() {
+				activeSessionsMu.Lock()
+				delete(activeSessions, info.ID)
+				activeSessionsMu.Unlock()
+			}()
+
+			sw := &sessionWriter{ResponseWriter: w, commit: func() { m.save(w, r, s) }}
+			next.ServeHTTP(sw, r)
+			sw.fire()
+		})
+	}, nil
+}
+
+func (m *sessionManager) sign(value string) string {
+	mac := hmac.New(sha256.New, m.macKey)
+	mac.Write([]byte(m.opts.CookieName + "|" + value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify returns the signed part of a "value.signature" cookie
+func (m *sessionManager) verify(cookie string) (string, bool) {
+	value, sig, ok := strings.Cut(cookie, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(m.sign(value))) {
+		return "", false
+	}
+	return value, true
+}
+
+// load reads the request's session, starting an empty one when the cookie
+// is missing, invalid, or expired
+func (m *sessionManager) load(r *http.Request) *session {
+	s := &session{values: make(map[string]json.RawMessage)}
+	cookie, err := r.Cookie(m.opts.CookieName)
+	if err != nil || cookie.Value == "" {
+		return s
+	}
+	if m.redis != nil {
+		id := cookie.Value
+		if m.macKey != nil {
+			var ok bool
+			if id, ok = m.verify(cookie.Value); !ok {
+				slog.Debug("Invalid session cookie signature", "path", r.URL.Path)
+				return s
+			}
+		}
+		reply, err := m.redis.do("GET", m.opts.KeyPrefix+id)
+		if err != nil {
+			slog.Error("Error loading session from redis", "error", err)
+			return s
+		}
+		data, ok := reply.(string)
+		if !ok {
+			return s // expired or unknown
+		}
+		if err := json.Unmarshal([]byte(data), &s.values); err != nil {
+			slog.Error("Error decoding session", "error", err)
+			s.values = make(map[string]json.RawMessage)
+			return s
+		}
+		s.id = id
+		return s
+	}
+
+	var payload []byte
+	if m.aead != nil {
+		sealed, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+		if err != nil || len(sealed) < m.aead.NonceSize() {
+			return s
+		}
+		nonce, ciphertext := sealed[:m.aead.NonceSize()], sealed[m.aead.NonceSize():]
+		if payload, err = m.aead.Open(nil, nonce, ciphertext, []byte(m.opts.CookieName)); err != nil {
+			slog.Debug("Invalid session cookie", "path", r.URL.Path)
+			return s
+		}
+	} else {
+		value, ok := m.verify(cookie.Value)
+		if !ok {
+			slog.Debug("Invalid session cookie signature", "path", r.URL.Path)
+			return s
+		}
+		if payload, err = base64.RawURLEncoding.DecodeString(value); err != nil {
+			return s
+		}
+	}
+	var stored cookieSession
+	if err := json.Unmarshal(payload, &stored); err != nil || time.Now().Unix() > stored.Expires {
+		return s
+	}
+	if stored.Values != nil {
+		s.values = stored.Values
+	}
+	return s
+}
+
+// save writes a changed session and its cookie. It runs once, just before
+// the response headers are sent.
+func (m *sessionManager) save(w http.ResponseWriter, r *http.Request, s *session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.committed = true
+	if !s.dirty {
+		return
+	}
+	if len(s.values) == 0 {
+		if m.redis != nil && s.id != "" {
+			if _, err := m.redis.do("DEL", m.opts.KeyPrefix+s.id); err != nil {
+				slog.Error("Error deleting session from redis", "error", err)
+			}
+		}
+		m.setCookie(w, r, "", -1)
+		return
+	}
+	data, err := json.Marshal(s.values)
+	if err != nil {
+		slog.Error("Error encoding session", "error", err)
+		return
+	}
+
+	if m.redis != nil {
+		if s.id == "" || s.cleared {
+			if s.id != "" {
+				m.redis.do("DEL", m.opts.KeyPrefix+s.id)
+			}
+			// A fresh ID whenever the session starts over prevents fixation
+			id := make([]byte, 32)
+			rand.Read(id)
+			s.id = base64.RawURLEncoding.EncodeToString(id)
+		}
+		if _, err := m.redis.do("SET", m.opts.KeyPrefix+s.id, string(data), "EX", fmt.Sprint(m.opts.MaxAge)); err != nil {
+			slog.Error("Error saving session to redis", "error", err)
+			return
+		}
+		value := s.id
+		if m.macKey != nil {
+			value += "." + m.sign(s.id)
+		}
+		m.setCookie(w, r, value, m.opts.MaxAge)
+		return
+	}
+
+	payload, err := json.Marshal(cookieSession{Values: s.values, Expires: time.Now().Add(time.Duration(m.opts.MaxAge) * time.Second).Unix()})
+	if err != nil {
+		slog.Error("Error encoding session", "error", err)
+		return
+	}
+	var value string
+	if m.aead != nil {
+		nonce := make([]byte, m.aead.NonceSize())
+		rand.Read(nonce)
+		value = base64.RawURLEncoding.EncodeToString(m.aead.Seal(nonce, nonce, payload, []byte(m.opts.CookieName)))
+	} else {
+		value = base64.RawURLEncoding.EncodeToString(payload)
+		value += "." + m.sign(value)
+	}
+	if len(value) > maxSessionCookie {
+		slog.Error("Session too large for a cookie, not saved", "bytes", len(value), "path", r.URL.Path)
+		return
+	}
+	m.setCookie(w, r, value, m.opts.MaxAge)
+}
+
+func (m *sessionManager) setCookie(w http.ResponseWriter, r *http.Request, value string, maxAge int) {
+	secure := r.TLS != nil
+	if m.opts.Secure != nil {
+		secure = *m.opts.Secure
+	}
+	cookie := &http.Cookie{
+		Name:     m.opts.CookieName,
+		Value:    value,
+		Path:     m.opts.Path,
+		Domain:   m.opts.Domain,
+		MaxAge:   maxAge,
+		Secure:   secure,
+		HttpOnly: m.opts.HTTPOnly == nil || *m.opts.HTTPOnly,
+	}
+	switch strings.ToLower(m.opts.SameSite) {
+	case "strict":
+		cookie.SameSite = http.SameSiteStrictMode
+	case "none":
+		cookie.SameSite = http.SameSiteNoneMode
+		cookie.Secure = true // browsers reject SameSite=None without Secure
+	default:
+		cookie.SameSite = http.SameSiteLaxMode
+	}
+	http.SetCookie(w, cookie)
+	w.Header().Add("Vary", "Cookie")
+	w.Header().Set("Cache-Control", "private")
+}
+
+// sessionWriter saves the session right before the response headers go out
+type sessionWriter struct {
+	http.ResponseWriter
+	commit func()
+	fired  bool
+}
+
+func (w *sessionWriter) fire() {
+	if !w.fired {
+		w.fired = true
+		w.commit()
+	}
+}
+
+func (w *sessionWriter) WriteHeader(code int) {
+	if code >= 200 {
+		w.fire()
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *sessionWriter) Write(b []byte) (int, error) {
+	w.fire()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *sessionWriter) Flush() {
+	w.fire()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *sessionWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func activeSession(requestID string) (*session, bool) {
+	activeSessionsMu.Lock()
+	defer activeSessionsMu.Unlock()
+	s, ok := activeSessions[requestID]
+	return s, ok
+}
+
+// requestHasSession reports whether a request carries session values or
+// its handler set some
+func requestHasSession(requestID string) bool {
+	s, ok := activeSession(requestID)
+	if !ok {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.values) > 0 || s.dirty
+}
+
+// sessionSnapshot copies a request's session values for its handler
+func sessionSnapshot(requestID string) map[string]json.RawMessage {
+	s, ok := activeSession(requestID)
+	if !ok {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return maps.Clone(s.values)
+}
+
+// updateSession applies change to a request's session
+func updateSession(requestID string, change func(s *session)) error {
+	s, ok := activeSession(requestID)
+	if !ok {
+		return fmt.Errorf("no session for request %q; is the session middleware enabled?", requestID)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.committed {
+		return errSessionSent
+	}
+	change(s)
+	s.dirty = true
+	return nil
+}
+
+// GetSessionValue returns the JSON value stored under cKey in the session
+// of the request cRequestID, or NULL if there is none. The caller must
+// release the result with FreeString.
+//
+//export GetSessionValue
+func GetSessionValue(cRequestID *C.char, cKey *C.char) *C.char {
+	if cRequestID == nil || cKey == nil {
+		recordError("One or more parameters are nil in GetSessionValue")
+		return nil
+	}
+	s, ok := activeSession(C.GoString(cRequestID))
+	if !ok {
+		return nil
+	}
+	s.mu.Lock()
+	value, ok := s.values[C.GoString(cKey)]
+	s.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return C.CString(string(value))
+}
+
+// SetSessionValue stores cValue, a JSON value, under cKey in the session of
+// the request cRequestID. Changes are saved when the response starts, so
+// they must be made before the handler returns.
+//
+//export SetSessionValue
+func SetSessionValue(cRequestID *C.char, cKey *C.char, cValue *C.char) int {
+	if cRequestID == nil || cKey == nil || cValue == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in SetSessionValue")
+	}
+	key := C.GoString(cKey)
+	value := C.GoString(cValue)
+	if !json.Valid([]byte(value)) {
+		return exportError(exportInvalid, "Session value is not JSON", "key", key)
+	}
+	if err := updateSession(C.GoString(cRequestID), func(s *session) { s.values[key] = json.RawMessage(value) }); err != nil {
+		return exportError(exportFailed, "Cannot set session value", "key", key, "error", err)
+	}
+
+	return 0
+}
+
+// DeleteSessionValue removes cKey from the session of the request
+// cRequestID
+//
+//export DeleteSessionValue
+func DeleteSessionValue(cRequestID *C.char, cKey *C.char) int {
+	if cRequestID == nil || cKey == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in DeleteSessionValue")
+	}
+	key := C.GoString(cKey)
+	if err := updateSession(C.GoString(cRequestID), func(s *session) { delete(s.values, key) }); err != nil {
+		return exportError(exportFailed, "Cannot delete session value", "key", key, "error", err)
+	}
+
+	return 0
+}
+
+// ClearSession drops every value of the session of the request cRequestID,
+// e.g. on logout. Values set afterwards start a new session with a new ID.
+//
+//export ClearSession
+func ClearSession(cRequestID *C.char) int {
+	if cRequestID == nil {
+		return exportError(exportInvalid, "cRequestID is nil in ClearSession")
+	}
+	if err := updateSession(C.GoString(cRequestID), func(s *session) {
+		s.values = make(map[string]json.RawMessage)
+		s.cleared = true
+	}); err != nil {
+		return exportError(exportFailed, "Cannot clear session", "error", err)
+	}
+
+	return 0
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"C"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const defaultDrainTimeout = 5 * time.Second
+
+var (
+	drainTimeout     atomic.Int64 // nanoseconds
+	shutdownCallback atomic.Uintptr
+	activeRequests   atomic.Int64
+)
+
+func init() {
+	drainTimeout.Store(int64(defaultDrainTimeout))
+}
+
+// ShutdownReport summarizes a graceful shutdown's drain phase
+type ShutdownReport struct {
+	DrainSeconds    float64 `json:"drain_seconds"`
+	DrainedRequests int64   `json:"drained_requests"`
+	AbortedRequests int64   `json:"aborted_requests"`
+	DrainedTasks    int     `json:"drained_tasks"`
+	AbortedTasks    int     `json:"aborted_tasks"`
+}
+
+// activeRequestsMiddleware counts requests being served so shutdown can
+// report how many were drained or cut off
+func activeRequestsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		activeRequests.Add(1)
+		defer activeRequests.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// drainServer stops accepting connections and waits up to the drain timeout
+// for in-flight requests, then for queued and running tasks. Whatever is
+// left at the deadline is aborted.
+func drainServer(state *serverState) ShutdownReport {
+	timeout := time.Duration(drainTimeout.Load())
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var report ShutdownReport
+	inFlight := activeRequests.Load()
+	// The QUIC and gRPC listeners drain alongside the TCP one
+	var h3Done, grpcDone chan error
+	if state.h3 != nil {
+		h3Done = make(chan error, 1)
+		go func() { h3Done <- shutdownHTTP3(ctx, state.h3, state.h3Conn) }()
+	}
+	if state.grpc != nil {
+		grpcDone = make(chan error, 1)
+		go func() { grpcDone <- state.grpc.Shutdown(ctx) }()
+	}
+	err := state.server.Shutdown(ctx)
+	if h3Done != nil {
+		if h3Err := <-h3Done; err == nil {
+			err = h3Err
+		}
+	}
+	if grpcDone != nil {
+		if grpcErr := <-grpcDone; grpcErr != nil {
+			state.grpc.Close()
+			if err == nil {
+				err = grpcErr
+			}
+		}
+	}
+	if err != nil {
+		report.AbortedRequests = activeRequests.Load()
+		slog.Warn("Drain timeout reached with requests in flight", "aborted", report.AbortedRequests, "error", err)
+		state.server.Close()
+	}
+	report.DrainedRequests = max(inFlight-report.AbortedRequests, 0)
+
+	report.DrainedTasks, report.AbortedTasks = drainTaskPool(ctx)
+	// Cancelling the task context stops anything still running
+	taskCancel()
+	report.DrainSeconds = time.Since(start).Seconds()
+
+	slog.Info("Shutdown drain complete",
+		"drain_seconds", report.DrainSeconds,
+		"drained_requests", report.DrainedRequests,
+		"aborted_requests", report.AbortedRequests,
+		"drained_tasks", report.DrainedTasks,
+		"aborted_tasks", report.AbortedTasks,
+	)
+	if fn := shutdownCallback.Load(); fn != 0 {
+		if data, err := json.Marshal(report); err == nil {
+			callReportHandler(fn, data)
+		}
+	}
+	return report
+}
+
+// ConfigureShutdownDrain sets how long shutdown waits for in-flight requests
+// and background tasks before aborting them. 0 aborts immediately.
+//
+//export ConfigureShutdownDrain
+func ConfigureShutdownDrain(seconds int) int {
+	if seconds < 0 {
+		return exportError(exportInvalid, "Invalid shutdown drain timeout", "seconds", seconds)
+	}
+	drainTimeout.Store(int64(time.Duration(seconds) * time.Second))
+	slog.Info("Shutdown drain timeout set", "seconds", seconds)
+	auditConfigChange("ConfigureShutdownDrain", map[string]string{"seconds": strconv.Itoa(seconds)})
+
+	return 0
+}
+
+// RegisterShutdownCallback sets a host callback invoked with a JSON
+// ShutdownReport after each shutdown drain. Passing 0 removes it.
+//
+//export RegisterShutdownCallback
+func RegisterShutdownCallback(cHandler uintptr) int {
+	shutdownCallback.Store(cHandler)
+	slog.Info("Shutdown callback registered", "enabled", cHandler != 0)
+	auditConfigChange("RegisterShutdownCallback", map[string]string{"enabled": fmt.Sprint(cHandler != 0)})
+
+	return 0
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"C"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	sseKeepAliveInterval = 15 * time.Second
+	sseEventBuffer       = 64 // events queued per connection before drops
+)
+
+// sseConn is one open event stream
+type sseConn struct {
+	id     string
+	route  string
+	events chan []byte
+	done   chan struct{}
+	once   sync.Once
+}
+
+var (
+	sseConns     = make(map[string]*sseConn)
+	sseConnsMu   sync.Mutex
+	sseConnCount atomic.Uint64
+)
+
+// stop ends the stream; the serving handler then returns
+func (c *sseConn) stop() {
+	c.once.Do(func() { close(c.done) })
+}
+
+// formatSSEEvent frames data as an SSE message, one data field per line
+func formatSSEEvent(data string) []byte {
+	var b strings.Builder
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: ")
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	b.WriteByte('\n')
+	return []byte(b.String())
+}
+
+// serveSSE holds the request open and streams pushed events until the
+// client disconnects or the server stops
+func serveSSE(w http.ResponseWriter, r *http.Request, route RouteInfo) {
+	rc := http.NewResponseController(w)
+	// Streams outlive the server's write timeout
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		slog.Debug("Cannot clear write deadline for SSE stream", "error", err)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		slog.Error("SSE requires a flushable response writer", "route", route.Path, "error", err)
+		return
+	}
+	if info := requestInfoFrom(r); info != nil && info.ReleaseAdmission != nil {
+		info.ReleaseAdmission()
+	}
+
+	c := &sseConn{
+		id:     fmt.Sprintf("sse-%d", sseConnCount.Add(1)),
+		route:  route.Path,
+		events: make(chan []byte, sseEventBuffer),
+		done:   make(chan struct{}),
+	}
+	sseConnsMu.Lock()
+	sseConns[c.id] = c
+	sseConnsMu.Unlock()
+	slog.Info("SSE stream opened", "conn_id", c.id, "route", route.Path, "remote_addr", r.RemoteAddr)
+	callConnHandler(route.SSE, c.id, connEventOpen, nil)
+
+	defer func() {
+		sseConnsMu.Lock()
+		delete(sseConns, c.id)
+		sseConnsMu.Unlock()
+		callConnHandler(route.SSE, c.id, connEventClose, nil)
+		slog.Info("SSE stream closed", "conn_id", c.id)
+	}()
+
+	// Tell the client its ID so it can be targeted by PushEvent
+	if _, err := fmt.Fprintf(w, "event: connected\ndata: %s\n\n", c.id); err != nil {
+		return
+	}
+	rc.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		var chunk []byte
+		select {
+		case chunk = <-c.events:
+		case <-keepAlive.C:
+			chunk = []byte(": keep-alive\n\n")
+		case <-r.Context().Done():
+			return
+		case <-c.done:
+			return
+		}
+		if _, err := w.Write(chunk); err != nil {
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// closeSSEStreams ends every open stream so shutdown is not held up by them
+func closeSSEStreams() {
+	sseConnsMu.Lock()
+	defer sseConnsMu.Unlock()
+	for _, c := range sseConns {
+		c.stop()
+	}
+}
+
+// RegisterSSERoute registers a GET route that streams Server-Sent Events.
+// The host callback is called with the connection ID when a client connects
+// and disconnects; events are sent with PushEvent.
+//
+//export RegisterSSERoute
+func RegisterSSERoute(cPath *C.char, cDesc *C.char, cHandler uintptr) int {
+	if cPath == nil || cDesc == nil || cHandler == 0 {
+		return exportError(exportInvalid, "One or more parameters are nil in RegisterSSERoute")
+	}
+	path := C.GoString(cPath)
+	desc := C.GoString(cDesc)
+	method := http.MethodGet
+
+	routesMu.Lock()
+	key := path + method
+	routes[key] = RouteInfo{
+		Path:        path,
+		Method:      method,
+		Description: desc,
+		Parameters:  pathParameters(path),
+		Responses: map[int]string{
+			200: "A text/event-stream of events",
+		},
+		SSE: cHandler,
+	}
+	routeTree.insert(path, method, key)
+	routesMu.Unlock()
+	slog.Info("SSE route registered", "key", key)
+	invalidateOpenAPICache()
+	auditConfigChange("RegisterSSERoute", map[string]string{"path": path, "description": desc})
+
+	return 0
+}
+
+// PushEvent sends data to an open stream on the route registered at
+// routeID (its path). An empty connID broadcasts to every stream on the
+// route. Events for a client that is not keeping up are dropped.
+//
+//export PushEvent
+func PushEvent(cRouteID *C.char, cConnID *C.char, cData *C.char) int {
+	if cRouteID == nil || cConnID == nil || cData == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in PushEvent")
+	}
+	route := C.GoString(cRouteID)
+	connID := C.GoString(cConnID)
+	event := formatSSEEvent(C.GoString(cData))
+
+	sseConnsMu.Lock()
+	var targets []*sseConn
+	if connID != "" {
+		if c, ok := sseConns[connID]; ok && c.route == route {
+			targets = append(targets, c)
+		}
+	} else {
+		for _, c := range sseConns {
+			if c.route == route {
+				targets = append(targets, c)
+			}
+		}
+	}
+	sseConnsMu.Unlock()
+
+	if len(targets) == 0 {
+		if connID != "" {
+			return exportError(exportNotFound, "Cannot push event, stream not found", "route", route, "conn_id", connID)
+		}
+		return 0
+	}
+	for _, c := range targets {
+		select {
+		case c.events <- event:
+		default:
+			slog.Warn("Dropping SSE event for slow client", "conn_id", c.id)
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"C"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// StaticOptions configures a directory registered with RegisterStaticDir
+type StaticOptions struct {
+	CacheControl      string `json:"cache_control"`       // defaults to public, max-age=3600
+	IndexCacheControl string `json:"index_cache_control"` // for index.html and SPA fallbacks; defaults to no-cache
+	Index             string `json:"index"`               // defaults to index.html
+	Listing           bool   `json:"listing"`             // list directories without an index
+	SPA               bool   `json:"spa"`                 // serve the root index for unknown paths
+	Precompressed     *bool  `json:"precompressed"`       // serve .br/.gz siblings; defaults to true
+}
+
+// staticDir is a directory mounted under a URL prefix
+type staticDir struct {
+	prefix string // always ends in /
+	root   string
+	opts   StaticOptions
+}
+
+var (
+	staticDirs   []staticDir // longest prefix first
+	staticDirsMu sync.RWMutex
+)
+
+// precompressedSuffixes maps content codings to the sibling file extension
+var precompressedSuffixes = map[string]string{encodingBrotli: ".br", encodingGzip: ".gz"}
+
+// findStaticDir returns the mount serving urlPath, if any
+func findStaticDir(urlPath string) (staticDir, bool) {
+	staticDirsMu.RLock()
+	defer staticDirsMu.RUnlock()
+	for _, dir := range staticDirs {
+		if strings.HasPrefix(urlPath, dir.prefix) || urlPath+"/" == dir.prefix {
+			return dir, true
+		}
+	}
+	return staticDir{}, false
+}
+
+// staticETag derives a validator from size and modification time, tagged
+// with the content coding so encoded variants do not share an ETag
+func staticETag(info os.FileInfo, encoding string) string {
+	tag := strconv.FormatInt(info.Size(), 36) + "-" + strconv.FormatInt(info.ModTime().UnixNano(), 36)
+	if encoding != "" {
+		tag += "-" + encoding
+	}
+	return `"` + tag + `"`
+}
+
+// serveStatic serves r from a registered static directory. It returns false
+// if no directory is mounted at the path, so the caller can 404.
+func serveStatic(w http.ResponseWriter, r *http.Request) bool {
+	dir, ok := findStaticDir(r.URL.Path)
+	if !ok {
+		return false
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, fmt.Sprintf(`{"error": "Method %s not allowed for %s - Try using method GET"}`, r.Method, r.URL.Path), http.StatusMethodNotAllowed)
+		return true
+	}
+	if info := requestInfoFrom(r); info != nil {
+		info.Route = dir.prefix
+	}
+	if r.URL.Path+"/" == dir.prefix {
+		http.Redirect(w, r, dir.prefix, http.StatusMovedPermanently)
+		return true
+	}
+
+	// Cleaning a rooted path drops any .. segments before joining
+	rel := path.Clean("/" + strings.TrimPrefix(r.URL.Path, dir.prefix))
+	name := filepath.Join(dir.root, filepath.FromSlash(rel))
+	info, err := os.Stat(name)
+	if err == nil && info.IsDir() {
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+			return true
+		}
+		index := filepath.Join(name, dir.opts.Index)
+		if indexInfo, err := os.Stat(index); err == nil && !indexInfo.IsDir() {
+			serveStaticFile(w, r, dir, index, indexInfo, dir.opts.IndexCacheControl)
+			return true
+		}
+		if dir.opts.Listing {
+			serveDirListing(w, r, name)
+			return true
+		}
+		err = os.ErrNotExist
+	}
+	if err != nil {
+		if dir.opts.SPA {
+			index := filepath.Join(dir.root, dir.opts.Index)
+			if indexInfo, err := os.Stat(index); err == nil && !indexInfo.IsDir() {
+				serveStaticFile(w, r, dir, index, indexInfo, dir.opts.IndexCacheControl)
+				return true
+			}
+		}
+		slog.Debug("Static file not found", "path", r.URL.Path, "file", name)
+		http.Error(w, fmt.Sprintf(`{"error": "Route not found for %s %s"}`, r.Method, r.URL.Path), http.StatusNotFound)
+		return true
+	}
+	cacheControl := dir.opts.CacheControl
+	if filepath.Base(name) == dir.opts.Index {
+		cacheControl = dir.opts.IndexCacheControl
+	}
+	serveStaticFile(w, r, dir, name, info, cacheControl)
+	return true
+}
+
+// serveStaticFile writes one file, preferring a precompressed sibling the
+// client accepts. http.ServeContent handles conditional and range requests.
+func serveStaticFile(w http.ResponseWriter, r *http.Request, dir staticDir, name string, info os.FileInfo, cacheControl string) {
+	h := w.Header()
+	contentType := mime.TypeByExtension(filepath.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	h.Set("Content-Type", contentType)
+	if cacheControl != "" {
+		h.Set("Cache-Control", cacheControl)
+	}
+
+	servePath, encoding := name, ""
+	if dir.opts.Precompressed == nil || *dir.opts.Precompressed {
+		h.Add("Vary", "Accept-Encoding")
+		_, brErr := os.Stat(name + precompressedSuffixes[encodingBrotli])
+		if enc := negotiateEncoding(r.Header.Get("Accept-Encoding"), brErr == nil); enc != "" {
+			candidate := name + precompressedSuffixes[enc]
+			if encInfo, err := os.Stat(candidate); err == nil && !encInfo.IsDir() {
+				servePath, encoding, info = candidate, enc, encInfo
+			}
+		}
+	}
+
+	f, err := os.Open(servePath)
+	if err != nil {
+		slog.Error("Cannot open static file", "file", servePath, "error", err)
+		http.Error(w, `{"error": "Internal server error"}`, http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	if encoding != "" {
+		h.Set("Content-Encoding", encoding)
+	}
+	h.Set("ETag", staticETag(info, encoding))
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
+
+// serveDirListing writes a minimal HTML index of a directory
+func serveDirListing(w http.ResponseWriter, r *http.Request, name string) {
+	entries, err := os.ReadDir(name)
+	if err != nil {
+		slog.Error("Cannot list static directory", "dir", name, "error", err)
+		http.Error(w, `{"error": "Internal server error"}`, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	var b strings.Builder
+	fmt.Fprintf(&b, "<!doctype html>\n<title>Index of %s</title>\n<h1>Index of %s</h1>\n<ul>\n", html.EscapeString(r.URL.Path), html.EscapeString(r.URL.Path))
+	for _, entry := range entries {
+		entryName := entry.Name()
+		if entry.IsDir() {
+			entryName += "/"
+		}
+		link := url.URL{Path: entryName}
+		fmt.Fprintf(&b, "<li><a href=\"%s\">%s</a></li>\n", link.String(), html.EscapeString(entryName))
+	}
+	b.WriteString("</ul>\n")
+	if r.Method != http.MethodHead {
+		w.Write([]byte(b.String()))
+	}
+}
+
+// RegisterStaticDir serves files from cDir under the URL prefix cPrefix.
+// cOptions is a JSON StaticOptions object and may be empty. Registered
+// routes take precedence over files, except catch-all routes with a
+// shorter prefix, such as an SPA fallback at /*path. Re-registering a
+// prefix replaces it.
+//
+//export RegisterStaticDir
+func RegisterStaticDir(cPrefix *C.char, cDir *C.char, cOptions *C.char) int {
+	if cPrefix == nil || cDir == nil || cOptions == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in RegisterStaticDir")
+	}
+	prefix := C.GoString(cPrefix)
+	dirName := C.GoString(cDir)
+	options := C.GoString(cOptions)
+	if !strings.HasPrefix(prefix, "/") {
+		return exportError(exportInvalid, "Static prefix must start with /", "prefix", prefix)
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	root, err := filepath.Abs(dirName)
+	if err != nil {
+		return exportError(exportInvalid, "Invalid static directory", "dir", dirName, "error", err)
+	}
+	if info, err := os.Stat(root); err != nil || !info.IsDir() {
+		return exportError(exportNotFound, "Static directory does not exist", "dir", root)
+	}
+	opts := StaticOptions{CacheControl: "public, max-age=3600", IndexCacheControl: "no-cache", Index: "index.html"}
+	if options != "" {
+		if err := json.Unmarshal([]byte(options), &opts); err != nil {
+			return exportError(exportInvalid, "Invalid static options", "prefix", prefix, "error", err)
+		}
+	}
+	if opts.Index == "" || strings.ContainsAny(opts.Index, `/\`) {
+		return exportError(exportInvalid, "Invalid static index file name", "index", opts.Index)
+	}
+
+	staticDirsMu.Lock()
+	mounts := staticDirs[:0:0]
+	for _, dir := range staticDirs {
+		if dir.prefix != prefix {
+			mounts = append(mounts, dir)
+		}
+	}
+	mounts = append(mounts, staticDir{prefix: prefix, root: root, opts: opts})
+	sort.SliceStable(mounts, func(i, j int) bool { return len(mounts[i].prefix) > len(mounts[j].prefix) })
+	staticDirs = mounts
+	staticDirsMu.Unlock()
+
+	slog.Info("Static directory registered", "prefix", prefix, "dir", root, "spa", opts.SPA, "listing", opts.Listing)
+	auditConfigChange("RegisterStaticDir", map[string]string{"prefix": prefix, "dir": root, "options": options})
+
+	return 0
+}
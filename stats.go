@@ -0,0 +1,75 @@
+package main
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"encoding/json"
+	"sync/atomic"
+	"unsafe"
+)
+
+// ServerStats holds runtime counters reported to the host process
+type ServerStats struct {
+	ConnectionsAccepted    atomic.Int64
+	ConnectionsClosedByAge atomic.Int64
+	AdmissionQueued        atomic.Int64
+	AdmissionRejected      atomic.Int64
+	AdmissionWaitNanos     atomic.Int64
+	TasksRejected          atomic.Int64
+	TasksRetried           atomic.Int64
+	TasksDeadLettered      atomic.Int64
+	RequestsTimedOut       atomic.Int64
+	RequestsShed           atomic.Int64
+}
+
+var stats ServerStats
+
+// statsSnapshot returns a JSON-friendly copy of the current counters
+func statsSnapshot() map[string]interface{} {
+	queueDepth, inFlight := admission.snapshot()
+	taskWorkers, taskQueued := taskPoolSnapshot()
+	queued := stats.AdmissionQueued.Load()
+	var avgWaitMs float64
+	if queued > 0 {
+		avgWaitMs = float64(stats.AdmissionWaitNanos.Load()) / float64(queued) / 1e6
+	}
+	return map[string]interface{}{
+		"connections_accepted":      stats.ConnectionsAccepted.Load(),
+		"connections_closed_by_age": stats.ConnectionsClosedByAge.Load(),
+		"admission_queue_depth":     queueDepth,
+		"admission_in_flight":       inFlight,
+		"admission_queued_total":    queued,
+		"admission_rejected_total":  stats.AdmissionRejected.Load(),
+		"admission_avg_wait_ms":     avgWaitMs,
+		"task_workers":              taskWorkers,
+		"task_queue_depth":          taskQueued,
+		"tasks_rejected_total":      stats.TasksRejected.Load(),
+		"tasks_retried_total":       stats.TasksRetried.Load(),
+		"tasks_dead_lettered_total": stats.TasksDeadLettered.Load(),
+		"requests_timed_out_total":  stats.RequestsTimedOut.Load(),
+		"requests_shed_total":       stats.RequestsShed.Load(),
+		"concurrency_in_flight":     concurrencyInFlight.Load(),
+	}
+}
+
+// GetServerStats returns the server counters as a JSON C string. The caller
+// must release it with FreeString.
+//
+//export GetServerStats
+func GetServerStats() *C.char {
+	data, err := json.Marshal(statsSnapshot())
+	if err != nil {
+		recordError("Error encoding server stats", "error", err)
+		return C.CString("{}")
+	}
+	return C.CString(string(data))
+}
+
+// FreeString releases a C string previously returned by an export
+//
+//export FreeString
+func FreeString(cStr *C.char) {
+	C.free(unsafe.Pointer(cStr))
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"C"
+	"errors"
+	"log/slog"
+	"maps"
+	"net/http"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+const (
+	// responseStreamBuffer bounds the bytes queued for a streamed response
+	// once it is being sent; WriteResponseChunk blocks beyond it until the
+	// client catches up
+	responseStreamBuffer = 1 << 20
+	// responseStreamIdleTimeout aborts a streamed response the host stopped
+	// writing to without calling FinishResponse
+	responseStreamIdleTimeout = 60 * time.Second
+)
+
+var (
+	errStreamClosed      = errors.New("response stream is closed")
+	errTrailerUndeclared = errors.New("trailer was not declared with the response header")
+)
+
+// responseStream queues the body chunks a host handler writes for its
+// request. Chunks written while the handler callback is still running are
+// held until it returns with "stream": true.
+type responseStream struct {
+	mu       sync.Mutex
+	chunks   [][]byte
+	queued   int
+	sending  bool
+	finished bool
+	closed   bool // the response ended; further writes fail
+	trailers map[string]string
+	declared []string      // trailer names sent with the header; nil before it
+	notify   chan struct{} // a chunk arrived or the stream finished
+	space    chan struct{} // queued chunks were sent
+	done     chan struct{} // closed with the stream
+}
+
+var (
+	responseStreams   = make(map[string]*responseStream) // by request ID
+	responseStreamsMu sync.Mutex
+)
+
+// openResponseStream lets the handler of requestID stream its response;
+// the returned func ends the stream
+func openResponseStream(requestID string) (*responseStream, func()) {
+	s := &responseStream{notify: make(chan struct{}, 1), space: make(chan struct{}, 1), done: make(chan struct{})}
+	responseStreamsMu.Lock()
+	responseStreams[requestID] = s
+	responseStreamsMu.Unlock()
+	return s, func() {
+		responseStreamsMu.Lock()
+		delete(responseStreams, requestID)
+		responseStreamsMu.Unlock()
+		s.close()
+	}
+}
+
+func activeResponseStream(requestID string) (*responseStream, bool) {
+	responseStreamsMu.Lock()
+	defer responseStreamsMu.Unlock()
+	s, ok := responseStreams[requestID]
+	return s, ok
+}
+
+// wake signals ch without blocking
+func wake(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// write queues a chunk, waiting for the client while too much is queued
+func (s *responseStream) write(chunk []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		if s.closed || s.finished {
+			return errStreamClosed
+		}
+		if !s.sending || s.queued < responseStreamBuffer {
+			break
+		}
+		s.mu.Unlock()
+		select {
+		case <-s.space:
+		case <-s.done:
+		}
+		s.mu.Lock()
+	}
+	s.chunks = append(s.chunks, chunk)
+	s.queued += len(chunk)
+	wake(s.notify)
+	return nil
+}
+
+func (s *responseStream) finish() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || s.finished {
+		return errStreamClosed
+	}
+	s.finished = true
+	wake(s.notify)
+	return nil
+}
+
+func (s *responseStream) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.done)
+	}
+}
+
+// setTrailer records a trailer value the handler set. Once the header is
+// written only the trailers declared with it can be set.
+func (s *responseStream) setTrailer(name, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || s.finished {
+		return errStreamClosed
+	}
+	if s.declared != nil && !containsFold(s.declared, name) {
+		return errTrailerUndeclared
+	}
+	if s.trailers == nil {
+		s.trailers = make(map[string]string)
+	}
+	s.trailers[name] = value
+	return nil
+}
+
+// declareTrailers merges the trailers the handler set so far into
+// response's, to be declared with the header, and fixes the names it can
+// set after that to those of route and response
+func (s *responseStream) declareTrailers(route RouteInfo, response *HandlerResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	trailers := make(map[string]string, len(response.Trailers)+len(s.trailers))
+	for name, value := range response.Trailers {
+		trailers[http.CanonicalHeaderKey(name)] = value
+	}
+	maps.Copy(trailers, s.trailers)
+	response.Trailers = trailers
+	s.declared = append(trailerNames(route.Trailers), trailerNames(trailers)...)
+	s.trailers = nil
+}
+
+// finalTrailers returns the trailers the handler set after the header
+func (s *responseStream) finalTrailers() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.trailers
+}
+
+// take returns the queued chunks and whether the host has finished
+func (s *responseStream) take() ([][]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sending = true
+	chunks := s.chunks
+	s.chunks, s.queued = nil, 0
+	wake(s.space)
+	return chunks, s.finished
+}
+
+// pending reports whether chunks were written that nothing will send
+func (s *responseStream) pending() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.chunks) > 0
+}
+
+// sendStream writes the handler's chunks to the client, flushing each, until
+// the host calls FinishResponse. A client that goes away, or a host that
+// stops writing for responseStreamIdleTimeout, aborts the response so the
+// client does not mistake it for a complete body.
+func sendStream(w http.ResponseWriter, r *http.Request, route RouteInfo, s *responseStream) bool {
+	rc := http.NewResponseController(w)
+	// Streams may outlive the server's write timeout
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		slog.Debug("Cannot clear write deadline for streamed response", "error", err)
+	}
+	if err := rc.Flush(); err != nil {
+		slog.Error("Streamed responses require a flushable response writer", "route", route.Path, "error", err)
+		return false
+	}
+
+	idle := time.NewTimer(responseStreamIdleTimeout)
+	defer idle.Stop()
+	for {
+		chunks, finished := s.take()
+		for _, chunk := range chunks {
+			if _, err := w.Write(chunk); err != nil {
+				return false
+			}
+		}
+		if len(chunks) > 0 {
+			if err := rc.Flush(); err != nil {
+				return false
+			}
+			idle.Reset(responseStreamIdleTimeout)
+		}
+		if finished {
+			return true
+		}
+		select {
+		case <-s.notify:
+		case <-r.Context().Done():
+			return false
+		case <-idle.C:
+			slog.Warn("Streamed response idle, aborting", "method", route.Method, "route", route.Path, "timeout", responseStreamIdleTimeout)
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
+
+// WriteResponseChunk appends dataLen bytes to the streamed response of the
+// request cRequestID. The handler returns {"stream": true, ...} to stream:
+// the status and headers it returns are sent with its body, followed by the
+// chunks as they are written, until FinishResponse. Chunks may be written
+// before the handler returns or afterwards from another thread; writing
+// blocks while the client is slow to read. Returns 0, or a negative status
+// if the request is not streaming or the client has gone away.
+//
+//export WriteResponseChunk
+func WriteResponseChunk(cRequestID *C.char, cData *C.char, dataLen int) int {
+	if cRequestID == nil || (cData == nil && dataLen > 0) || dataLen < 0 {
+		return exportError(exportInvalid, "One or more parameters are nil in WriteResponseChunk")
+	}
+	requestID := C.GoString(cRequestID)
+	s, ok := activeResponseStream(requestID)
+	if !ok {
+		slog.Debug("Cannot write response chunk, request not streaming", "request_id", requestID)
+		setLastError("Cannot write response chunk, request not streaming", "request_id", requestID)
+		return exportNotFound
+	}
+	if dataLen == 0 {
+		return 0
+	}
+	if err := s.write(C.GoBytes(unsafe.Pointer(cData), C.int(dataLen))); err != nil {
+		slog.Debug("Cannot write response chunk", "request_id", requestID, "error", err)
+		setLastError("Cannot write response chunk", "request_id", requestID, "error", err)
+		return exportFailed
+	}
+	return 0
+}
+
+// FinishResponse ends the streamed response of the request cRequestID,
+// after which its trailers are sent. Returns 0, or a negative status if the
+// request is not streaming or already finished.
+//
+//export FinishResponse
+func FinishResponse(cRequestID *C.char) int {
+	if cRequestID == nil {
+		return exportError(exportInvalid, "cRequestID is nil in FinishResponse")
+	}
+	requestID := C.GoString(cRequestID)
+	s, ok := activeResponseStream(requestID)
+	if !ok || s.finish() != nil {
+		slog.Debug("Cannot finish response, request not streaming", "request_id", requestID)
+		setLastError("Cannot finish response, request not streaming", "request_id", requestID)
+		return exportNotFound
+	}
+	return 0
+}
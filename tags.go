@@ -0,0 +1,128 @@
+package main
+
+import (
+	"C"
+	"encoding/json"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// OpenAPITag is an entry of the document's top-level tags array, which
+// Swagger UI and ReDoc use to group operations into sections
+type OpenAPITag struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+var (
+	// declaredTags holds the tags described with RegisterTag, in the order
+	// they were first registered, which is the order sections are listed in
+	declaredTags   []OpenAPITag
+	declaredTagsMu sync.RWMutex
+)
+
+// openAPITags lists the tags used by operations in a document: declared
+// tags first in declaration order, then the rest by name. Tags no
+// operation uses are left out, so a virtual host only lists its own.
+func openAPITags(used map[string]bool) []OpenAPITag {
+	if len(used) == 0 {
+		return nil
+	}
+	tags := make([]OpenAPITag, 0, len(used))
+	seen := make(map[string]bool, len(used))
+	declaredTagsMu.RLock()
+	for _, tag := range declaredTags {
+		if used[tag.Name] {
+			tags = append(tags, tag)
+			seen[tag.Name] = true
+		}
+	}
+	declaredTagsMu.RUnlock()
+	var rest []string
+	for name := range used {
+		if !seen[name] {
+			rest = append(rest, name)
+		}
+	}
+	sort.Strings(rest)
+	for _, name := range rest {
+		tags = append(tags, OpenAPITag{Name: name})
+	}
+	return tags
+}
+
+// RegisterTag describes an OpenAPI tag. Operations carrying it are grouped
+// under a section with this description, and sections follow the order
+// tags are registered in. Registering a tag again updates its description
+// and keeps its place.
+//
+//export RegisterTag
+func RegisterTag(cName *C.char, cDescription *C.char) int {
+	if cName == nil || cDescription == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in RegisterTag")
+	}
+	name := strings.TrimSpace(C.GoString(cName))
+	description := C.GoString(cDescription)
+	if name == "" {
+		return exportError(exportInvalid, "Empty tag name in RegisterTag")
+	}
+
+	declaredTagsMu.Lock()
+	found := false
+	for i := range declaredTags {
+		if declaredTags[i].Name == name {
+			declaredTags[i].Description = description
+			found = true
+			break
+		}
+	}
+	if !found {
+		declaredTags = append(declaredTags, OpenAPITag{Name: name, Description: description})
+	}
+	declaredTagsMu.Unlock()
+	invalidateOpenAPICache()
+
+	slog.Info("OpenAPI tag registered", "tag", name)
+	auditConfigChange("RegisterTag", map[string]string{"tag": name, "description": description})
+	return 0
+}
+
+// SetRouteTags sets a route's OpenAPI tags from a JSON array, replacing
+// those it got from its route group. An empty array removes them, leaving
+// the operation in Swagger UI's default section.
+//
+//export SetRouteTags
+func SetRouteTags(cPath *C.char, cMethod *C.char, cTags *C.char) int {
+	if cPath == nil || cMethod == nil || cTags == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in SetRouteTags")
+	}
+	path := C.GoString(cPath)
+	method := strings.ToUpper(C.GoString(cMethod))
+	var tags []string
+	if err := json.Unmarshal([]byte(C.GoString(cTags)), &tags); err != nil {
+		return exportError(exportInvalid, "Invalid route tags", "path", path, "method", method, "error", err)
+	}
+	for _, tag := range tags {
+		if strings.TrimSpace(tag) == "" {
+			return exportError(exportInvalid, "Empty tag in SetRouteTags", "path", path, "method", method)
+		}
+	}
+
+	routesMu.Lock()
+	key := path + method
+	route, exists := routes[key]
+	if !exists {
+		routesMu.Unlock()
+		return exportError(exportNotFound, "Cannot set tags, route not found", "key", key)
+	}
+	route.Tags = tags
+	routes[key] = route
+	routesMu.Unlock()
+	invalidateOpenAPICache()
+
+	slog.Info("Route tags set", "key", key, "tags", strings.Join(tags, ","))
+	auditConfigChange("SetRouteTags", map[string]string{"path": path, "method": method, "tags": strings.Join(tags, ",")})
+	return 0
+}
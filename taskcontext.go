@@ -0,0 +1,96 @@
+package main
+
+import (
+	"C"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Task context modes for ConfigureTaskContext
+const (
+	TaskContextDetached = "detached" // the default; the task outlives the request, fire-and-forget
+	TaskContextInherit  = "inherit"  // the task keeps the request's deadline and trace context
+)
+
+var errTaskDeadline = errors.New("task passed the deadline of the request it was submitted from")
+
+// taskContextModes are keyed by task name; "" is the default
+var taskContextModes = make(map[string]string)
+
+func taskContextModeFor(name string) string {
+	namedTasksMu.RLock()
+	defer namedTasksMu.RUnlock()
+	if mode, ok := taskContextModes[name]; ok {
+		return mode
+	}
+	if mode, ok := taskContextModes[""]; ok {
+		return mode
+	}
+	return TaskContextDetached
+}
+
+// inheritRequestContext records the deadline and trace context of the
+// request in ctx on the origin of a task in inherit mode
+func inheritRequestContext(ctx context.Context, name string, origin *taskOrigin) {
+	if origin == nil || taskContextModeFor(name) != TaskContextInherit {
+		return
+	}
+	origin.Deadline = deadlineFrom(ctx)
+	origin.Traceparent = traceparentFrom(ctx)
+}
+
+// withOriginDeadline bounds a task attempt by the deadline its origin
+// inherited. A task whose deadline passed while it was queued or waiting
+// to be retried fails without running.
+func withOriginDeadline(ctx context.Context, origin *taskOrigin) (context.Context, context.CancelFunc, error) {
+	if origin == nil || origin.Deadline == nil {
+		return ctx, func() {}, nil
+	}
+	if !time.Now().Before(*origin.Deadline) {
+		return ctx, func() {}, errTaskDeadline
+	}
+	ctx, cancel := context.WithDeadline(ctx, *origin.Deadline)
+	return ctx, cancel, nil
+}
+
+// originDeadlinePassed reports whether a task attempt ended because of its
+// inherited deadline rather than its own timeout
+func originDeadlinePassed(origin *taskOrigin) bool {
+	return origin != nil && origin.Deadline != nil && !time.Now().Before(*origin.Deadline)
+}
+
+// ConfigureTaskContext sets how a named task submitted from a request,
+// through SetRouteTask or SubmitTaskForRequest, relates to it, or how every
+// named task without its own mode does when cTaskName is empty. "detached",
+// the default, lets the task run to completion after the response, with
+// only the request ID and values. "inherit" also hands the task the
+// request's deadline, under the timeout middleware, and trace context: the
+// callback receives them as "deadline" and "traceparent", an attempt still
+// running at the deadline fails, and a task that waited past it fails
+// without running and is not retried.
+//
+//export ConfigureTaskContext
+func ConfigureTaskContext(cTaskName *C.char, cMode *C.char) int {
+	if cTaskName == nil || cMode == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in ConfigureTaskContext")
+	}
+	name := C.GoString(cTaskName)
+	mode := strings.ToLower(C.GoString(cMode))
+	switch mode {
+	case "":
+		mode = TaskContextDetached
+	case TaskContextDetached, TaskContextInherit:
+	default:
+		return exportError(exportInvalid, "Unknown task context mode", "task", name, "mode", mode)
+	}
+	namedTasksMu.Lock()
+	taskContextModes[name] = mode
+	namedTasksMu.Unlock()
+
+	slog.Info("Task context configured", "task", name, "mode", mode)
+	auditConfigChange("ConfigureTaskContext", map[string]string{"task": name, "mode": mode})
+	return 0
+}
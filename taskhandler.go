@@ -0,0 +1,354 @@
+package main
+
+import (
+	"C"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// maxTaskPayload bounds the request body a static route hands to its task
+const maxTaskPayload = 1 << 20
+
+var (
+	errTaskNoResult      = errors.New("task returned no result")
+	errTaskNotRegistered = errors.New("task is not registered")
+)
+
+// namedTask is a host callback registered under a task name
+type namedTask struct {
+	handler     uintptr
+	withPayload bool // task handler ABI (RegisterTaskHandler) instead of the route handler ABI (RegisterTask)
+}
+
+var (
+	namedTasks   = make(map[string]namedTask)
+	taskTimeouts = make(map[string]time.Duration) // by task name; "" is the default
+	namedTasksMu sync.RWMutex
+)
+
+func taskRegistered(name string) bool {
+	namedTasksMu.RLock()
+	defer namedTasksMu.RUnlock()
+	_, ok := namedTasks[name]
+	return ok
+}
+
+func taskTimeoutFor(name string) time.Duration {
+	namedTasksMu.RLock()
+	defer namedTasksMu.RUnlock()
+	if timeout, ok := taskTimeouts[name]; ok {
+		return timeout
+	}
+	return taskTimeouts[""]
+}
+
+// taskOutcome is what a host task callback produced
+type taskOutcome struct {
+	result string
+	err    error
+}
+
+// namedTaskRun returns the pool job that calls a registered task. Each
+// attempt gets the task's timeout; a callback still running when it expires
+// is left to finish and its outcome discarded, since host code cannot be
+// interrupted.
+func namedTaskRun(id, name string, scheduledAt time.Time) (func(ctx context.Context) (string, error), bool) {
+	namedTasksMu.RLock()
+	task, ok := namedTasks[name]
+	namedTasksMu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	return func(ctx context.Context) (string, error) {
+		descriptor := map[string]interface{}{"task_id": id, "name": name}
+		if !scheduledAt.IsZero() {
+			descriptor["scheduled_at"] = scheduledAt.UTC()
+		}
+		if status, ok := tasks.get(id); ok {
+			descriptor["attempt"] = status.Attempts
+		}
+		origin := tasks.origin(id)
+		if origin != nil {
+			descriptor["request_id"] = origin.RequestID
+			if origin.Context != nil {
+				descriptor["context"] = origin.Context
+			}
+			if origin.Deadline != nil {
+				descriptor["deadline"] = origin.Deadline.UTC()
+			}
+			if origin.Traceparent != "" {
+				// The task's own span continues the request's trace
+				descriptor["traceparent"] = origin.Traceparent
+				if tp := traceparentFrom(ctx); tp != "" {
+					descriptor["traceparent"] = tp
+				}
+			}
+		}
+		request, err := json.Marshal(descriptor)
+		if err != nil {
+			return "", err
+		}
+		ctx, cancelDeadline, err := withOriginDeadline(ctx, origin)
+		defer cancelDeadline()
+		if err != nil {
+			slog.Warn("Task not started, request deadline passed", "task_id", id, "name", name, "request_id", origin.RequestID)
+			return "", err
+		}
+		timeout := taskTimeoutFor(name)
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		done := make(chan taskOutcome, 1)
+		go func() {
+			if !task.withPayload {
+				raw, ok := callRouteHandler(task.handler, request)
+				if !ok {
+					done <- taskOutcome{err: errTaskNoResult}
+					return
+				}
+				done <- taskOutcome{result: string(raw)}
+				return
+			}
+			raw, failed, ok := callTaskHandler(task.handler, request, tasks.payload(id))
+			switch {
+			case !ok:
+				done <- taskOutcome{err: errTaskNoResult}
+			case failed:
+				done <- taskOutcome{err: errors.New(string(raw))}
+			default:
+				done <- taskOutcome{result: string(raw)}
+			}
+		}()
+		select {
+		case outcome := <-done:
+			return outcome.result, outcome.err
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) && originDeadlinePassed(origin) {
+				slog.Warn("Task passed its request deadline, discarding its result", "task_id", id, "name", name, "request_id", origin.RequestID)
+				return "", errTaskDeadline
+			}
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				slog.Warn("Task timed out, discarding its result", "task_id", id, "name", name, "timeout", timeout)
+				return "", fmt.Errorf("task timed out after %s", timeout)
+			}
+			return "", ctx.Err()
+		}
+	}, true
+}
+
+// submitNamedTask registers and enqueues a run of a registered task. A
+// task submitted while serving a request is told the request ID and gets a
+// copy of its values, and in inherit mode its deadline and trace context.
+func submitNamedTask(ctx context.Context, mode string, name string, payload []byte) (string, error) {
+	return submitTaskFrom(ctx, mode, name, payload, taskOriginFor(ctx))
+}
+
+func submitTaskFrom(ctx context.Context, mode string, name string, payload []byte, origin *taskOrigin) (string, error) {
+	inheritRequestContext(ctx, name, origin)
+	id := tasks.createFrom(name, payload, origin)
+	run, ok := namedTaskRun(id, name, time.Time{})
+	if !ok {
+		tasks.finish(id, "", errTaskNotRegistered)
+		return id, errTaskNotRegistered
+	}
+	return id, enqueueTask(ctx, mode, id, run)
+}
+
+// readTaskPayload reads a static route's request body for its task
+func readTaskPayload(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxTaskPayload))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, `{"error": "Request body too large"}`, http.StatusRequestEntityTooLarge)
+			return nil, false
+		}
+		http.Error(w, `{"error": "Error reading request body"}`, http.StatusBadRequest)
+		return nil, false
+	}
+	return payload, true
+}
+
+// registerTask stores a host callback under a task name
+func registerTask(export string, name string, task namedTask) {
+	namedTasksMu.Lock()
+	namedTasks[name] = task
+	namedTasksMu.Unlock()
+
+	slog.Info("Task registered", "name", name, "payload", task.withPayload)
+	auditConfigChange(export, map[string]string{"name": name})
+}
+
+// RegisterTask names a host callback that ScheduleTask and RunTaskAt can
+// run on the task pool. It uses the route handler ABI: the callback
+// receives {"task_id", "name", "scheduled_at", "attempt"}, plus
+// "request_id" and the request's "context" values for tasks submitted from
+// a request, and "deadline" and "traceparent" for those inheriting its
+// context, and returns the task result; NULL marks the run failed.
+// Re-registering a name replaces it.
+//
+//export RegisterTask
+func RegisterTask(cName *C.char, cHandler uintptr) int {
+	if cName == nil || cHandler == 0 {
+		return exportError(exportInvalid, "One or more parameters are nil in RegisterTask")
+	}
+	name := C.GoString(cName)
+	if name == "" {
+		return exportError(exportInvalid, "Empty name in RegisterTask")
+	}
+	registerTask("RegisterTask", name, namedTask{handler: cHandler})
+
+	return 0
+}
+
+// RegisterTaskHandler names a host task handler that also receives the
+// payload the task was submitted with, from SubmitTask or the request body
+// of a route set up with SetRouteTask, and can report its result as an
+// error. Scheduled runs get an empty payload. Re-registering a name
+// replaces it.
+//
+//export RegisterTaskHandler
+func RegisterTaskHandler(cName *C.char, cCallback uintptr) int {
+	if cName == nil || cCallback == 0 {
+		return exportError(exportInvalid, "One or more parameters are nil in RegisterTaskHandler")
+	}
+	name := C.GoString(cName)
+	if name == "" {
+		return exportError(exportInvalid, "Empty name in RegisterTaskHandler")
+	}
+	registerTask("RegisterTaskHandler", name, namedTask{handler: cCallback, withPayload: true})
+
+	return 0
+}
+
+// ConfigureTaskTimeout limits each attempt of a named task, or of every
+// named task without its own limit when cTaskName is empty. Attempts that
+// time out fail and are retried under the task's retry policy. 0 removes
+// the limit.
+//
+//export ConfigureTaskTimeout
+func ConfigureTaskTimeout(cTaskName *C.char, timeoutMs int) int {
+	if cTaskName == nil {
+		return exportError(exportInvalid, "cTaskName is nil in ConfigureTaskTimeout")
+	}
+	if timeoutMs < 0 {
+		return exportError(exportInvalid, "Invalid task timeout", "timeout_ms", timeoutMs)
+	}
+	name := C.GoString(cTaskName)
+	namedTasksMu.Lock()
+	if timeoutMs == 0 && name != "" {
+		delete(taskTimeouts, name)
+	} else {
+		taskTimeouts[name] = time.Duration(timeoutMs) * time.Millisecond
+	}
+	namedTasksMu.Unlock()
+
+	slog.Info("Task timeout configured", "task", name, "timeout_ms", timeoutMs)
+	auditConfigChange("ConfigureTaskTimeout", map[string]string{"task": name, "timeout_ms": strconv.Itoa(timeoutMs)})
+
+	return 0
+}
+
+// SubmitTask queues a registered task with a payload of payloadLen bytes
+// and returns its task ID, or NULL if the task is unknown or the queue is
+// full. The caller must release the ID with FreeString.
+//
+//export SubmitTask
+func SubmitTask(cTaskName *C.char, cPayload *C.char, payloadLen int) *C.char {
+	if cTaskName == nil || (cPayload == nil && payloadLen > 0) || payloadLen < 0 {
+		recordError("One or more parameters are nil in SubmitTask")
+		return nil
+	}
+	name := C.GoString(cTaskName)
+	if !taskRegistered(name) {
+		recordError("Cannot submit task, task not registered", "task", name)
+		return nil
+	}
+	var payload []byte
+	if payloadLen > 0 {
+		payload = C.GoBytes(unsafe.Pointer(cPayload), C.int(payloadLen))
+	}
+	id, err := submitNamedTask(context.Background(), BackpressureReject, name, payload)
+	if err != nil {
+		slog.Warn("Task not queued", "task", name, "task_id", id, "error", err)
+		return nil
+	}
+	return C.CString(id)
+}
+
+// SubmitTaskForRequest is SubmitTask for handlers: the task is told the
+// request ID cRequestID and gets a copy of the request's values, as tasks
+// of SetRouteTask routes do. Called while the handler callback runs, the
+// task is also traced under the request and inherits its deadline if
+// ConfigureTaskContext says so.
+//
+//export SubmitTaskForRequest
+func SubmitTaskForRequest(cRequestID *C.char, cTaskName *C.char, cPayload *C.char, payloadLen int) *C.char {
+	if cRequestID == nil || cTaskName == nil || (cPayload == nil && payloadLen > 0) || payloadLen < 0 {
+		recordError("One or more parameters are nil in SubmitTaskForRequest")
+		return nil
+	}
+	requestID := C.GoString(cRequestID)
+	name := C.GoString(cTaskName)
+	if !taskRegistered(name) {
+		recordError("Cannot submit task, task not registered", "task", name)
+		return nil
+	}
+	var payload []byte
+	if payloadLen > 0 {
+		payload = C.GoBytes(unsafe.Pointer(cPayload), C.int(payloadLen))
+	}
+	ctx := context.Background()
+	if h, ok := handlerHeadersFor(requestID); ok {
+		ctx = h.ctx
+	}
+	origin := &taskOrigin{RequestID: requestID, Context: requestValuesSnapshot(requestID)}
+	id, err := submitTaskFrom(ctx, BackpressureReject, name, payload, origin)
+	if err != nil {
+		slog.Warn("Task not queued", "task", name, "task_id", id, "error", err)
+		return nil
+	}
+	return C.CString(id)
+}
+
+// SetRouteTask makes a static route run a registered task with the request
+// body as its payload, instead of the placeholder background task
+//
+//export SetRouteTask
+func SetRouteTask(cPath *C.char, cMethod *C.char, cTaskName *C.char) int {
+	if cPath == nil || cMethod == nil || cTaskName == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in SetRouteTask")
+	}
+	path := C.GoString(cPath)
+	method := strings.ToUpper(C.GoString(cMethod))
+	name := C.GoString(cTaskName)
+
+	routesMu.Lock()
+	key := path + method
+	route, exists := routes[key]
+	if !exists {
+		routesMu.Unlock()
+		return exportError(exportNotFound, "Cannot set route task, route not found", "key", key)
+	}
+	route.Task = name
+	routes[key] = route
+	routesMu.Unlock()
+
+	slog.Info("Route task set", "key", key, "task", name)
+	auditConfigChange("SetRouteTask", map[string]string{"path": path, "method": method, "task": name})
+
+	return 0
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"C"
+	"context"
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultTaskWorkers    = 10
+	defaultTaskQueueDepth = 100
+)
+
+// Backpressure modes selectable per route when the task queue is full
+const (
+	BackpressureQueue  = "queue"  // wait for queue space while the request is open
+	BackpressureReject = "reject" // fail the request with 503 immediately
+)
+
+var (
+	errTaskQueueFull  = errors.New("task queue is full")
+	errTaskPoolClosed = errors.New("task pool is closed")
+)
+
+// taskJob is one unit of background work
+type taskJob struct {
+	id     string
+	run    func(ctx context.Context) (string, error)
+	parent *spanContext // span of the request that queued the task, if traced
+}
+
+// workerPool runs queued tasks on a fixed number of goroutines
+type workerPool struct {
+	mu      sync.RWMutex // guards closed and sends on jobs
+	jobs    chan taskJob
+	closed  bool
+	workers int
+	ctx     context.Context
+	wg      sync.WaitGroup
+	running atomic.Int64 // jobs currently executing
+}
+
+var (
+	taskPoolWorkers    = defaultTaskWorkers
+	taskPoolQueueDepth = defaultTaskQueueDepth
+	taskPoolMu         sync.Mutex // guards the sizes above and activePool
+	activePool         atomic.Pointer[workerPool]
+)
+
+// newWorkerPool starts workers that stop when ctx is cancelled or the pool
+// is closed and drained
+func newWorkerPool(ctx context.Context, workers, queueDepth int) *workerPool {
+	p := &workerPool{
+		jobs:    make(chan taskJob, queueDepth),
+		workers: workers,
+		ctx:     ctx,
+	}
+	for i := 0; i < workers; i++ {
+		p.wg.Add(1)
+		go p.work()
+	}
+	return p
+}
+
+func (p *workerPool) work() {
+	defer p.wg.Done()
+	for job := range p.jobs {
+		p.running.Add(1)
+		p.runJob(job)
+		p.running.Add(-1)
+	}
+}
+
+func (p *workerPool) runJob(job taskJob) {
+	if err := p.ctx.Err(); err != nil {
+		if interruptTask(job) {
+			return
+		}
+		slog.Warn("Task not started due to shutdown", "task_id", job.id)
+		tasks.finish(job.id, "", err)
+		return
+	}
+	slog.Debug("Starting background task", "task_id", job.id)
+	tasks.start(job.id)
+	ctx, sp := startRemoteSpan(p.ctx, job.parent, "task", spanKindConsumer)
+	sp.setAttr("task.id", job.id)
+	result, err := job.run(ctx)
+	if err != nil {
+		sp.setError(err.Error())
+		slog.Error("Background task failed", "task_id", job.id, "error", err)
+	} else {
+		slog.Debug("Completed background task", "task_id", job.id)
+	}
+	sp.end()
+	if err != nil && p.ctx.Err() == nil && retryTask(p.ctx, job, err) {
+		return
+	}
+	if err != nil && p.ctx.Err() != nil && interruptTask(job) {
+		return
+	}
+	tasks.finish(job.id, result, err)
+	if err != nil && p.ctx.Err() == nil {
+		deadLetterTask(job.id)
+	}
+}
+
+// submit enqueues a job. With BackpressureReject it fails immediately when
+// the queue is full; otherwise it waits until space frees up or ctx ends.
+func (p *workerPool) submit(ctx context.Context, job taskJob, mode string) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return errTaskPoolClosed
+	}
+	if mode == BackpressureReject {
+		select {
+		case p.jobs <- job:
+			return nil
+		default:
+			return errTaskQueueFull
+		}
+	}
+	select {
+	case p.jobs <- job:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-p.ctx.Done():
+		return p.ctx.Err()
+	}
+}
+
+// close stops accepting jobs; queued jobs still run unless ctx is cancelled
+func (p *workerPool) close() {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.jobs)
+	}
+	p.mu.Unlock()
+}
+
+// startTaskPool replaces the active pool with one using the configured sizes
+func startTaskPool(ctx context.Context) {
+	taskPoolMu.Lock()
+	defer taskPoolMu.Unlock()
+	pool := newWorkerPool(ctx, taskPoolWorkers, taskPoolQueueDepth)
+	if old := activePool.Swap(pool); old != nil {
+		old.close()
+	}
+}
+
+// drainTaskPool closes the active pool and waits until its queued and
+// running tasks finish or ctx ends. It returns how many outstanding tasks
+// completed and how many were still queued or running at the deadline.
+func drainTaskPool(ctx context.Context) (drained int, aborted int) {
+	pool := activePool.Swap(nil)
+	if pool == nil {
+		return 0, 0
+	}
+	pool.close()
+	outstanding := pool.outstanding()
+
+	finished := make(chan struct{})
+	go func() {
+		pool.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return outstanding, 0
+	case <-ctx.Done():
+		aborted = pool.outstanding()
+		return outstanding - aborted, aborted
+	}
+}
+
+// outstanding counts jobs queued or executing
+func (p *workerPool) outstanding() int {
+	return len(p.jobs) + int(p.running.Load())
+}
+
+// submitTask registers and enqueues a background task on the active pool
+func submitTask(ctx context.Context, mode string, run func(ctx context.Context) (string, error)) (string, error) {
+	id := tasks.create("", nil, nil)
+	return id, enqueueTask(ctx, mode, id, run)
+}
+
+// enqueueTask hands an already registered task to the active pool. A task
+// that cannot be queued is recorded as failed.
+func enqueueTask(ctx context.Context, mode string, id string, run func(ctx context.Context) (string, error)) error {
+	job := taskJob{id: id, run: run}
+	if sp := spanFrom(ctx); sp != nil {
+		job.parent = &sp.sc
+	}
+	return enqueueJob(ctx, mode, job)
+}
+
+// enqueueJob submits a job, retrying if the pool is swapped mid-submit
+func enqueueJob(ctx context.Context, mode string, job taskJob) error {
+	id := job.id
+	tasks.queued(id)
+	for {
+		pool := activePool.Load()
+		err := errTaskPoolClosed
+		if pool != nil {
+			err = pool.submit(ctx, job, mode)
+		}
+		// Retry if the pool was swapped out by ConfigureTaskPool mid-submit
+		if err == errTaskPoolClosed && pool != nil && activePool.Load() != pool {
+			continue
+		}
+		if err != nil {
+			stats.TasksRejected.Add(1)
+			tasks.finish(id, "", err)
+			return err
+		}
+		return nil
+	}
+}
+
+// taskPoolSnapshot reports the active pool's size and queue depth
+func taskPoolSnapshot() (workers int, queued int) {
+	if pool := activePool.Load(); pool != nil {
+		return pool.workers, len(pool.jobs)
+	}
+	return 0, 0
+}
+
+// sleepTask is the placeholder work run for static routes
+func sleepTask(ctx context.Context) (string, error) {
+	select {
+	case <-time.After(2 * time.Second):
+		return "", nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// ConfigureTaskPool sets the number of background workers and the task
+// queue depth. If the server is running the pool is replaced immediately;
+// tasks already queued on the old pool still run.
+//
+//export ConfigureTaskPool
+func ConfigureTaskPool(workers int, queueDepth int) int {
+	if workers <= 0 || queueDepth < 0 {
+		return exportError(exportInvalid, "Invalid task pool settings", "workers", workers, "queue_depth", queueDepth)
+	}
+	taskPoolMu.Lock()
+	taskPoolWorkers = workers
+	taskPoolQueueDepth = queueDepth
+	running := activePool.Load()
+	taskPoolMu.Unlock()
+	if running != nil {
+		startTaskPool(running.ctx)
+	}
+
+	slog.Info("Task pool configured", "workers", workers, "queue_depth", queueDepth)
+	auditConfigChange("ConfigureTaskPool", map[string]string{"workers": strconv.Itoa(workers), "queue_depth": strconv.Itoa(queueDepth)})
+
+	return 0
+}
+
+// SetRouteTaskBackpressure selects what a route does when the task queue is
+// full: "queue" waits for space, "reject" responds 503
+//
+//export SetRouteTaskBackpressure
+func SetRouteTaskBackpressure(cPath *C.char, cMethod *C.char, cMode *C.char) int {
+	if cPath == nil || cMethod == nil || cMode == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in SetRouteTaskBackpressure")
+	}
+	path := C.GoString(cPath)
+	method := strings.ToUpper(C.GoString(cMethod))
+	mode := strings.ToLower(C.GoString(cMode))
+	if mode != BackpressureQueue && mode != BackpressureReject {
+		return exportError(exportInvalid, "Unknown backpressure mode", "mode", mode)
+	}
+
+	routesMu.Lock()
+	key := path + method
+	route, exists := routes[key]
+	if !exists {
+		routesMu.Unlock()
+		return exportError(exportNotFound, "Cannot set backpressure, route not found", "key", key)
+	}
+	route.TaskBackpressure = mode
+	routes[key] = route
+	routesMu.Unlock()
+
+	slog.Info("Route task backpressure set", "key", key, "mode", mode)
+	auditConfigChange("SetRouteTaskBackpressure", map[string]string{"path": path, "method": method, "mode": mode})
+
+	return 0
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"C"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	mathrand "math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRetryInitialDelay = time.Second
+	defaultRetryMaxDelay     = time.Minute
+	defaultRetryJitter       = 0.2
+
+	// maxDeadTasks bounds the dead-letter list; the oldest entries are dropped
+	maxDeadTasks = 1000
+)
+
+// Backoff strategies between task attempts
+const (
+	BackoffExponential = "exponential" // double the delay after each attempt
+	BackoffFixed       = "fixed"       // wait the initial delay every time
+)
+
+// TaskRetryPolicy decides whether and when a failed task runs again
+type TaskRetryPolicy struct {
+	MaxAttempts    int      `json:"max_attempts"`     // total attempts including the first; defaults to 1
+	Backoff        string   `json:"backoff"`          // exponential (default) or fixed
+	InitialDelayMs int      `json:"initial_delay_ms"` // defaults to 1000
+	MaxDelayMs     int      `json:"max_delay_ms"`     // caps exponential growth; defaults to 60000
+	Jitter         *float64 `json:"jitter"`           // extra random delay as a fraction of the delay; defaults to 0.2
+}
+
+var (
+	// taskRetryPolicies are keyed by task name; "" is the default for
+	// unnamed tasks and names without their own policy
+	taskRetryPolicies   = make(map[string]TaskRetryPolicy)
+	taskRetryPoliciesMu sync.RWMutex
+
+	deadTasks   []TaskStatus // oldest first
+	deadTasksMu sync.Mutex
+)
+
+func retryPolicyFor(name string) TaskRetryPolicy {
+	taskRetryPoliciesMu.RLock()
+	defer taskRetryPoliciesMu.RUnlock()
+	if policy, ok := taskRetryPolicies[name]; ok {
+		return policy
+	}
+	return taskRetryPolicies[""]
+}
+
+// delay returns the wait before the attempt following the given one
+func (p TaskRetryPolicy) delay(attempt int) time.Duration {
+	delay := defaultRetryInitialDelay
+	if p.InitialDelayMs > 0 {
+		delay = time.Duration(p.InitialDelayMs) * time.Millisecond
+	}
+	maxDelay := defaultRetryMaxDelay
+	if p.MaxDelayMs > 0 {
+		maxDelay = time.Duration(p.MaxDelayMs) * time.Millisecond
+	}
+	if p.Backoff != BackoffFixed {
+		for i := 1; i < attempt && delay < maxDelay; i++ {
+			delay *= 2
+		}
+		delay = min(delay, maxDelay)
+	}
+	jitter := defaultRetryJitter
+	if p.Jitter != nil {
+		jitter = *p.Jitter
+	}
+	if jitter > 0 {
+		delay += time.Duration(mathrand.Float64() * jitter * float64(delay))
+	}
+	return delay
+}
+
+// retryTask schedules another attempt of a failed job if its policy allows
+// one and reports whether it did. If ctx ends first the task is failed, or
+// kept for the next start when tasks are persisted.
+func retryTask(ctx context.Context, job taskJob, err error) bool {
+	status, ok := tasks.get(job.id)
+	if !ok || errors.Is(err, errTaskDeadline) {
+		return false
+	}
+	policy := retryPolicyFor(status.Name)
+	if status.Attempts >= policy.MaxAttempts {
+		return false
+	}
+	delay := policy.delay(status.Attempts)
+	tasks.retrying(job.id, err, time.Now().Add(delay))
+	stats.TasksRetried.Add(1)
+	slog.Warn("Retrying background task", "task_id", job.id, "attempt", status.Attempts, "max_attempts", policy.MaxAttempts, "delay", delay)
+
+	go func() {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			enqueueJob(ctx, BackpressureQueue, job)
+		case <-ctx.Done():
+			if !interruptTask(job) {
+				tasks.finish(job.id, "", ctx.Err())
+			}
+		}
+	}()
+	return true
+}
+
+// deadLetterTask copies a task that failed its last attempt to the
+// dead-letter list
+func deadLetterTask(id string) {
+	status, ok := tasks.get(id)
+	if !ok {
+		return
+	}
+	deadTasksMu.Lock()
+	deadTasks = append(deadTasks, status)
+	if len(deadTasks) > maxDeadTasks {
+		deadTasks = deadTasks[len(deadTasks)-maxDeadTasks:]
+	}
+	deadTasksMu.Unlock()
+	stats.TasksDeadLettered.Add(1)
+	slog.Error("Background task moved to dead-letter list", "task_id", id, "name", status.Name, "attempts", status.Attempts, "error", status.Error)
+}
+
+// ServeDeadTasks handles GET /tasks/dead
+func ServeDeadTasks(w http.ResponseWriter, r *http.Request) {
+	deadTasksMu.Lock()
+	data, err := json.Marshal(append([]TaskStatus{}, deadTasks...))
+	deadTasksMu.Unlock()
+	if err != nil {
+		slog.Error("Error encoding dead-letter tasks", "error", err)
+		http.Error(w, `{"error": "Internal server error"}`, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// ConfigureTaskRetry sets the retry policy for a named task, or the default
+// policy when cTaskName is empty. cOptions is a JSON TaskRetryPolicy object;
+// an empty one restores a single attempt. Tasks that fail their last
+// attempt are moved to the dead-letter list at /tasks/dead.
+//
+//export ConfigureTaskRetry
+func ConfigureTaskRetry(cTaskName *C.char, cOptions *C.char) int {
+	if cTaskName == nil || cOptions == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in ConfigureTaskRetry")
+	}
+	name := C.GoString(cTaskName)
+	options := C.GoString(cOptions)
+	policy := TaskRetryPolicy{MaxAttempts: 1, Backoff: BackoffExponential}
+	if options != "" {
+		if err := json.Unmarshal([]byte(options), &policy); err != nil {
+			return exportError(exportInvalid, "Invalid task retry options", "task", name, "error", err)
+		}
+	}
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = 1
+	}
+	if policy.Backoff == "" {
+		policy.Backoff = BackoffExponential
+	}
+	if policy.Backoff != BackoffExponential && policy.Backoff != BackoffFixed {
+		return exportError(exportInvalid, "Unknown task backoff strategy", "task", name, "backoff", policy.Backoff)
+	}
+	if policy.InitialDelayMs < 0 || policy.MaxDelayMs < 0 || (policy.Jitter != nil && *policy.Jitter < 0) {
+		return exportError(exportInvalid, "Invalid task retry options", "task", name, "initial_delay_ms", policy.InitialDelayMs, "max_delay_ms", policy.MaxDelayMs)
+	}
+	taskRetryPoliciesMu.Lock()
+	taskRetryPolicies[name] = policy
+	taskRetryPoliciesMu.Unlock()
+
+	slog.Info("Task retry policy configured", "task", name, "max_attempts", policy.MaxAttempts, "backoff", policy.Backoff)
+	auditConfigChange("ConfigureTaskRetry", map[string]string{
+		"task":         name,
+		"max_attempts": strconv.Itoa(policy.MaxAttempts),
+		"backoff":      policy.Backoff,
+		"options":      options,
+	})
+
+	return 0
+}
+
+// DrainDeadTasks returns the dead-letter list as a JSON array, oldest first,
+// and empties it. The caller must release it with FreeString.
+//
+//export DrainDeadTasks
+func DrainDeadTasks() *C.char {
+	deadTasksMu.Lock()
+	drained := deadTasks
+	deadTasks = nil
+	deadTasksMu.Unlock()
+	if drained == nil {
+		drained = []TaskStatus{}
+	}
+	data, err := json.Marshal(drained)
+	if err != nil {
+		recordError("Error encoding dead-letter tasks", "error", err)
+		return C.CString("[]")
+	}
+	return C.CString(string(data))
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"C"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TaskState is the lifecycle state of a background task
+type TaskState string
+
+const (
+	TaskScheduled TaskState = "scheduled" // waiting for its RunTaskAt time
+	TaskRetrying  TaskState = "retrying"  // failed, waiting to be attempted again
+	TaskPending   TaskState = "pending"
+	TaskRunning   TaskState = "running"
+	TaskDone      TaskState = "done"
+	TaskFailed    TaskState = "failed"
+)
+
+// maxTaskRecords bounds the registry; the oldest finished tasks are evicted
+const maxTaskRecords = 1000
+
+// TaskStatus is the externally visible record of a background task
+type TaskStatus struct {
+	ID           string     `json:"id"`
+	Name         string     `json:"name,omitempty"` // registered task name, for host tasks
+	State        TaskState  `json:"state"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ScheduledFor *time.Time `json:"scheduled_for,omitempty"`
+	StartedAt    *time.Time `json:"started_at,omitempty"`
+	Attempts     int        `json:"attempts,omitempty"`
+	NextAttempt  *time.Time `json:"next_attempt_at,omitempty"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty"`
+	Result       string     `json:"result,omitempty"`
+	Error        string     `json:"error,omitempty"`
+}
+
+// taskRegistry tracks every task the server has started
+type taskRegistry struct {
+	mu       sync.RWMutex
+	tasks    map[string]*TaskStatus
+	order    []string               // task IDs in creation order
+	onFinish map[string]func()      // called once when the task finishes
+	payloads map[string][]byte      // input of unfinished host tasks
+	origins  map[string]*taskOrigin // request each unfinished host task was submitted from
+	store    taskStore              // durable copy of the records; nil keeps them in memory only
+}
+
+var tasks = &taskRegistry{tasks: make(map[string]*TaskStatus), onFinish: make(map[string]func()), payloads: make(map[string][]byte), origins: make(map[string]*taskOrigin)}
+
+// taskOrigin is the request a task was submitted from, with a copy of its
+// values, handed to the task callback. Deadline and Traceparent are set
+// for tasks inheriting the request's context, see ConfigureTaskContext.
+type taskOrigin struct {
+	RequestID   string                     `json:"request_id"`
+	Context     map[string]json.RawMessage `json:"context,omitempty"`
+	Deadline    *time.Time                 `json:"deadline,omitempty"`
+	Traceparent string                     `json:"traceparent,omitempty"`
+}
+
+// create registers a new pending task and returns its ID. payload is handed
+// to host task handlers. onFinish may be nil; otherwise it runs once the
+// task is done or failed.
+func (t *taskRegistry) create(name string, payload []byte, onFinish func()) string {
+	return t.add(&TaskStatus{Name: name, State: TaskPending}, payload, nil, onFinish)
+}
+
+// createFrom registers a pending task submitted from a request. origin may
+// be nil.
+func (t *taskRegistry) createFrom(name string, payload []byte, origin *taskOrigin) string {
+	return t.add(&TaskStatus{Name: name, State: TaskPending}, payload, origin, nil)
+}
+
+// schedule registers a task that is queued at a later time
+func (t *taskRegistry) schedule(name string, at time.Time, payload []byte, onFinish func()) string {
+	at = at.UTC()
+	return t.add(&TaskStatus{Name: name, State: TaskScheduled, ScheduledFor: &at}, payload, nil, onFinish)
+}
+
+// taskCounter numbers tasks. It starts at the process start time so IDs
+// do not repeat those of tasks a durable store kept from earlier runs.
+var taskCounter atomic.Int64
+
+func init() {
+	taskCounter.Store(time.Now().UnixNano())
+}
+
+// newTaskID returns a process-unique task identifier
+func newTaskID() string {
+	var id [32]byte
+	return string(strconv.AppendInt(append(id[:0], "task-"...), taskCounter.Add(1), 10))
+}
+
+func (t *taskRegistry) add(task *TaskStatus, payload []byte, origin *taskOrigin, onFinish func()) string {
+	task.ID = newTaskID()
+	task.CreatedAt = time.Now().UTC()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tasks[task.ID] = task
+	t.order = append(t.order, task.ID)
+	if onFinish != nil {
+		t.onFinish[task.ID] = onFinish
+	}
+	if len(payload) > 0 {
+		t.payloads[task.ID] = payload
+	}
+	if origin != nil {
+		t.origins[task.ID] = origin
+	}
+	t.persist(task)
+	t.evict()
+	return task.ID
+}
+
+// queued moves a scheduled or retrying task to pending once it is handed
+// to the pool
+func (t *taskRegistry) queued(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if task, ok := t.tasks[id]; ok && (task.State == TaskScheduled || task.State == TaskRetrying) {
+		task.State = TaskPending
+		task.NextAttempt = nil
+		t.persist(task)
+	}
+}
+
+// retrying records a failed attempt that will run again at the given time
+func (t *taskRegistry) retrying(id string, err error, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if task, ok := t.tasks[id]; ok {
+		at = at.UTC()
+		task.State = TaskRetrying
+		task.Error = err.Error()
+		task.NextAttempt = &at
+		t.persist(task)
+	}
+}
+
+// interrupted returns a task cut off by shutdown to pending
+func (t *taskRegistry) interrupted(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if task, ok := t.tasks[id]; ok {
+		task.State = TaskPending
+		t.persist(task)
+	}
+}
+
+// evict drops the oldest finished tasks beyond maxTaskRecords. Must hold t.mu.
+func (t *taskRegistry) evict() {
+	for i := 0; len(t.tasks) > maxTaskRecords && i < len(t.order); {
+		id := t.order[i]
+		task, ok := t.tasks[id]
+		if !ok {
+			// Already dropped; only its place in the order is left
+			t.order = append(t.order[:i], t.order[i+1:]...)
+			continue
+		}
+		if task.State == TaskDone || task.State == TaskFailed {
+			delete(t.tasks, id)
+			t.order = append(t.order[:i], t.order[i+1:]...)
+			t.unpersist(id)
+			continue
+		}
+		i++
+	}
+}
+
+// start marks a task as running
+func (t *taskRegistry) start(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if task, ok := t.tasks[id]; ok {
+		now := time.Now().UTC()
+		task.State = TaskRunning
+		task.StartedAt = &now
+		task.Attempts++
+		t.persist(task)
+	}
+}
+
+// finish records a task outcome; a non-nil err marks it failed
+func (t *taskRegistry) finish(id string, result string, err error) {
+	t.mu.Lock()
+	onFinish := t.onFinish[id]
+	delete(t.onFinish, id)
+	t.record(id, result, err)
+	t.mu.Unlock()
+	if onFinish != nil {
+		onFinish()
+	}
+}
+
+// record stores a task outcome. Must hold t.mu.
+func (t *taskRegistry) record(id string, result string, err error) {
+	task, ok := t.tasks[id]
+	if !ok {
+		return
+	}
+	now := time.Now().UTC()
+	delete(t.payloads, id)
+	delete(t.origins, id)
+	task.FinishedAt = &now
+	task.Result = result
+	if err != nil {
+		task.State = TaskFailed
+		task.Error = err.Error()
+	} else {
+		task.State = TaskDone
+		task.Error = ""
+	}
+	t.persist(task)
+}
+
+// payload returns the input a task was created with
+func (t *taskRegistry) payload(id string) []byte {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.payloads[id]
+}
+
+// origin returns the request a task was submitted from, or nil
+func (t *taskRegistry) origin(id string) *taskOrigin {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.origins[id]
+}
+
+// get returns a copy of a task's status
+func (t *taskRegistry) get(id string) (TaskStatus, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	task, ok := t.tasks[id]
+	if !ok {
+		return TaskStatus{}, false
+	}
+	return *task, true
+}
+
+// list returns copies of all tracked tasks, newest first
+func (t *taskRegistry) list() []TaskStatus {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	result := make([]TaskStatus, 0, len(t.tasks))
+	for _, task := range t.tasks {
+		result = append(result, *task)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].CreatedAt.After(result[j].CreatedAt) })
+	return result
+}
+
+// ServeTaskList handles GET /tasks
+func ServeTaskList(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := writeJSON(w, tasks.list()); err != nil {
+		slog.Error("Error encoding task list", "error", err)
+	}
+}
+
+// ServeTaskStatus handles GET /tasks/{id}
+func ServeTaskStatus(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	task, ok := tasks.get(id)
+	if !ok {
+		http.Error(w, fmt.Sprintf(`{"error": "Task %s not found"}`, id), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := writeJSON(w, task); err != nil {
+		slog.Error("Error encoding task status", "error", err)
+	}
+}
+
+// GetTaskStatus returns a task's status as a JSON C string, or NULL if the
+// task is unknown. The caller must release it with FreeString.
+//
+//export GetTaskStatus
+func GetTaskStatus(cTaskID *C.char) *C.char {
+	if cTaskID == nil {
+		recordError("cTaskID is nil in GetTaskStatus")
+		return nil
+	}
+	task, ok := tasks.get(C.GoString(cTaskID))
+	if !ok {
+		return nil
+	}
+	data, err := json.Marshal(task)
+	if err != nil {
+		recordError("Error encoding task status", "error", err)
+		return nil
+	}
+	return C.CString(string(data))
+}
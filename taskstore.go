@@ -0,0 +1,213 @@
+package main
+
+import (
+	"C"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Task backend drivers selectable with ConfigureTaskBackend
+const (
+	TaskBackendMemory = "memory"
+	TaskBackendSQLite = "sqlite"
+	TaskBackendRedis  = "redis"
+)
+
+var (
+	errTaskInterrupted   = errors.New("task interrupted by restart")
+	errTaskBackendActive = errors.New("task backend cannot change while the server is running")
+)
+
+// taskRecord is the stored form of a task
+type taskRecord struct {
+	TaskStatus
+	Payload []byte      `json:"payload,omitempty"` // kept until the task finishes
+	Origin  *taskOrigin `json:"origin,omitempty"`  // likewise
+}
+
+// taskStore persists task records so they outlive the process. Every
+// registry change is written through; the registry itself stays the source
+// of truth while the process runs.
+type taskStore interface {
+	save(record taskRecord) error
+	remove(id string) error
+	load() ([]taskRecord, error)
+	close() error
+}
+
+// openTaskStore opens the driver's store. The memory driver has none.
+func openTaskStore(driver, dsn string) (taskStore, error) {
+	switch driver {
+	case TaskBackendMemory:
+		return nil, nil
+	case TaskBackendSQLite:
+		store, err := openSQLiteTaskStore(dsn)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	case TaskBackendRedis:
+		store, err := openRedisTaskStore(dsn)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	}
+	return nil, fmt.Errorf("unknown task backend %q", driver)
+}
+
+// persist writes a task through to the store. Must hold t.mu.
+func (t *taskRegistry) persist(task *TaskStatus) {
+	if t.store == nil {
+		return
+	}
+	if err := t.store.save(taskRecord{TaskStatus: *task, Payload: t.payloads[task.ID], Origin: t.origins[task.ID]}); err != nil {
+		slog.Error("Error persisting task", "task_id", task.ID, "error", err)
+	}
+}
+
+// unpersist drops an evicted task from the store. Must hold t.mu.
+func (t *taskRegistry) unpersist(id string) {
+	if t.store == nil {
+		return
+	}
+	if err := t.store.remove(id); err != nil {
+		slog.Error("Error removing persisted task", "task_id", id, "error", err)
+	}
+}
+
+// persistent reports whether tasks are kept in a durable store
+func (t *taskRegistry) persistent() bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.store != nil
+}
+
+// useStore switches the registry to store, loading the tasks it holds.
+// Unfinished named tasks are returned for resuming; unfinished unnamed tasks
+// cannot be rebuilt and are marked failed.
+func (t *taskRegistry) useStore(store taskStore) ([]oneShotTask, error) {
+	var loaded []taskRecord
+	if store != nil {
+		var err error
+		if loaded, err = store.load(); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(loaded, func(i, j int) bool { return loaded[i].CreatedAt.Before(loaded[j].CreatedAt) })
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.store != nil {
+		if err := t.store.close(); err != nil {
+			slog.Error("Error closing task backend", "error", err)
+		}
+	}
+	t.store = store
+	var resume []oneShotTask
+	now := time.Now()
+	for i := range loaded {
+		task := &loaded[i].TaskStatus
+		if _, exists := t.tasks[task.ID]; exists {
+			continue
+		}
+		t.tasks[task.ID] = task
+		t.order = append(t.order, task.ID)
+		switch task.State {
+		case TaskDone, TaskFailed:
+			continue
+		}
+		if len(loaded[i].Payload) > 0 {
+			t.payloads[task.ID] = loaded[i].Payload
+		}
+		if loaded[i].Origin != nil {
+			t.origins[task.ID] = loaded[i].Origin
+		}
+		if task.Name == "" {
+			finished := now.UTC()
+			task.State = TaskFailed
+			task.Error = errTaskInterrupted.Error()
+			task.FinishedAt = &finished
+			t.persist(task)
+			continue
+		}
+		at := now
+		switch {
+		case task.State == TaskScheduled && task.ScheduledFor != nil:
+			at = *task.ScheduledFor
+		case task.State == TaskRetrying && task.NextAttempt != nil:
+			at = *task.NextAttempt
+		case task.State == TaskRunning:
+			task.State = TaskPending
+			t.persist(task)
+		}
+		resume = append(resume, oneShotTask{id: task.ID, name: task.Name, at: at})
+	}
+	t.evict()
+	return resume, nil
+}
+
+// interruptTask keeps a named task cut off by shutdown for the next start
+// when tasks are persisted, and reports whether it did. Otherwise the
+// caller records the task as failed.
+func interruptTask(job taskJob) bool {
+	if !tasks.persistent() {
+		return false
+	}
+	status, ok := tasks.get(job.id)
+	if !ok || status.Name == "" {
+		return false
+	}
+	tasks.interrupted(job.id)
+	schedulerMu.Lock()
+	oneShotTasks = append(oneShotTasks, oneShotTask{id: job.id, name: status.Name, at: time.Now()})
+	schedulerMu.Unlock()
+	slog.Info("Task interrupted by shutdown, resuming at next start", "task_id", job.id, "name", status.Name)
+	return true
+}
+
+// ConfigureTaskBackend selects where tasks are kept: "memory" (the
+// default), "sqlite" with a database file path as cDSN, or "redis" with a
+// redis:// URL. Tasks already in the store are loaded, and unfinished named
+// tasks resume once the server starts and their host callbacks are
+// registered. It must be called while the server is stopped.
+//
+//export ConfigureTaskBackend
+func ConfigureTaskBackend(cDriver *C.char, cDSN *C.char) int {
+	if cDriver == nil || cDSN == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in ConfigureTaskBackend")
+	}
+	driver := strings.ToLower(C.GoString(cDriver))
+	dsn := C.GoString(cDSN)
+
+	currentMu.Lock()
+	running := current != nil
+	currentMu.Unlock()
+	if running {
+		return exportError(exportFailed, "Cannot configure task backend", "driver", driver, "error", errTaskBackendActive)
+	}
+	store, err := openTaskStore(driver, dsn)
+	if err != nil {
+		return exportError(exportFailed, "Cannot open task backend", "driver", driver, "error", err)
+	}
+	resume, err := tasks.useStore(store)
+	if err != nil {
+		if store != nil {
+			store.close()
+		}
+		return exportError(exportFailed, "Cannot load tasks from backend", "driver", driver, "error", err)
+	}
+	schedulerMu.Lock()
+	oneShotTasks = append(oneShotTasks, resume...)
+	schedulerMu.Unlock()
+
+	slog.Info("Task backend configured", "driver", driver, "resumable_tasks", len(resume))
+	// DSNs may embed credentials
+	auditConfigChange("ConfigureTaskBackend", map[string]string{"driver": driver})
+
+	return 0
+}
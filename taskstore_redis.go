@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// redisTaskKey is the hash of task IDs to JSON records
+const redisTaskKey = "goserver:tasks"
+
+// redisTaskStore keeps tasks as JSON fields of one Redis hash
+type redisTaskStore struct {
+	client *redisClient
+}
+
+// openRedisTaskStore connects to a redis:// or rediss:// URL
+func openRedisTaskStore(dsn string) (*redisTaskStore, error) {
+	client, err := parseRedisURL(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("redis task backend: %w", err)
+	}
+	if _, err := client.do("PING"); err != nil {
+		return nil, fmt.Errorf("redis task backend: %w", err)
+	}
+	return &redisTaskStore{client: client}, nil
+}
+
+func (s *redisTaskStore) save(record taskRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.do("HSET", redisTaskKey, record.ID, string(data))
+	return err
+}
+
+func (s *redisTaskStore) remove(id string) error {
+	_, err := s.client.do("HDEL", redisTaskKey, id)
+	return err
+}
+
+func (s *redisTaskStore) load() ([]taskRecord, error) {
+	reply, err := s.client.do("HGETALL", redisTaskKey)
+	if err != nil {
+		return nil, err
+	}
+	fields, ok := reply.([]interface{})
+	if !ok || len(fields)%2 != 0 {
+		return nil, fmt.Errorf("unexpected redis reply %v", reply)
+	}
+	result := make([]taskRecord, 0, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		id, _ := fields[i].(string)
+		data, _ := fields[i+1].(string)
+		var record taskRecord
+		if err := json.Unmarshal([]byte(data), &record); err != nil {
+			return nil, fmt.Errorf("corrupt task record %s: %w", id, err)
+		}
+		result = append(result, record)
+	}
+	return result, nil
+}
+
+func (s *redisTaskStore) close() error {
+	return s.client.close()
+}
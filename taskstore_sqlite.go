@@ -0,0 +1,77 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteTaskStore keeps tasks as JSON rows in a SQLite database file
+type sqliteTaskStore struct {
+	db *sql.DB
+}
+
+func openSQLiteTaskStore(path string) (*sqliteTaskStore, error) {
+	if path == "" {
+		return nil, fmt.Errorf("sqlite task backend needs a database path")
+	}
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// Registry writes are serialized already; one connection avoids
+	// SQLITE_BUSY between pooled connections
+	db.SetMaxOpenConns(1)
+	for _, stmt := range []string{
+		`PRAGMA journal_mode=WAL`,
+		`PRAGMA busy_timeout=5000`,
+		`CREATE TABLE IF NOT EXISTS goserver_tasks (id TEXT PRIMARY KEY, data TEXT NOT NULL)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("sqlite task backend: %w", err)
+		}
+	}
+	return &sqliteTaskStore{db: db}, nil
+}
+
+func (s *sqliteTaskStore) save(record taskRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO goserver_tasks (id, data) VALUES (?, ?) ON CONFLICT(id) DO UPDATE SET data = excluded.data`, record.ID, string(data))
+	return err
+}
+
+func (s *sqliteTaskStore) remove(id string) error {
+	_, err := s.db.Exec(`DELETE FROM goserver_tasks WHERE id = ?`, id)
+	return err
+}
+
+func (s *sqliteTaskStore) load() ([]taskRecord, error) {
+	rows, err := s.db.Query(`SELECT data FROM goserver_tasks`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []taskRecord
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var record taskRecord
+		if err := json.Unmarshal([]byte(data), &record); err != nil {
+			return nil, fmt.Errorf("corrupt task record: %w", err)
+		}
+		result = append(result, record)
+	}
+	return result, rows.Err()
+}
+
+func (s *sqliteTaskStore) close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"C"
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// TemplateOptions configures the directory registered with RegisterTemplateDir
+type TemplateOptions struct {
+	Layout    string `json:"layout"`    // page wrapping every template, e.g. layout.html; pages fill its blocks
+	Partials  string `json:"partials"`  // subdirectory of templates available to every page; defaults to partials
+	Extension string `json:"extension"` // template file extension; defaults to .html
+	Cache     *bool  `json:"cache"`     // parse once and reuse; false re-reads files on every render. Defaults to true
+}
+
+// templateDir holds the registered templates. Template names are paths
+// relative to root using forward slashes, e.g. users/show.html.
+type templateDir struct {
+	root  string
+	opts  TemplateOptions
+	cache bool
+
+	mu     sync.RWMutex
+	parsed map[string]*template.Template
+}
+
+var (
+	templates   *templateDir
+	templatesMu sync.RWMutex
+)
+
+// validTemplateName rejects names that could leave the template root
+func validTemplateName(name string) bool {
+	return name != "" && !strings.HasPrefix(name, "/") && !strings.Contains(name, `\`) && path.Clean(name) == name && !strings.HasPrefix(name, "../")
+}
+
+// shared lists the layout and partials every page is parsed with
+func (d *templateDir) shared() ([]string, error) {
+	var names []string
+	if d.opts.Layout != "" {
+		names = append(names, d.opts.Layout)
+	}
+	partials := filepath.Join(d.root, filepath.FromSlash(d.opts.Partials))
+	if _, err := os.Stat(partials); os.IsNotExist(err) {
+		return names, nil
+	}
+	err := filepath.WalkDir(partials, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || filepath.Ext(p) != d.opts.Extension {
+			return err
+		}
+		rel, err := filepath.Rel(d.root, p)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(rel))
+		return nil
+	})
+	return names, err
+}
+
+// parse builds a page's template set: the layout, the partials, then the
+// page, so the page's blocks override the layout's defaults
+func (d *templateDir) parse(name string) (*template.Template, error) {
+	names, err := d.shared()
+	if err != nil {
+		return nil, err
+	}
+	set := template.New("")
+	for _, file := range append(names, name) {
+		data, err := os.ReadFile(filepath.Join(d.root, filepath.FromSlash(file)))
+		if err != nil {
+			return nil, err
+		}
+		if _, err := set.New(file).Parse(string(data)); err != nil {
+			return nil, err
+		}
+	}
+	return set, nil
+}
+
+// pages lists every template that can be rendered, skipping the layout
+// and partials
+func (d *templateDir) pages() ([]string, error) {
+	var names []string
+	err := filepath.WalkDir(d.root, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(d.root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if entry.IsDir() {
+			if rel == d.opts.Partials {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(p) == d.opts.Extension && rel != d.opts.Layout {
+			names = append(names, rel)
+		}
+		return nil
+	})
+	return names, err
+}
+
+// lookup returns the parsed template set for a page
+func (d *templateDir) lookup(name string) (*template.Template, error) {
+	if !d.cache {
+		return d.parse(name)
+	}
+	d.mu.RLock()
+	set, ok := d.parsed[name]
+	d.mu.RUnlock()
+	if ok {
+		return set, nil
+	}
+	set, err := d.parse(name)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	d.parsed[name] = set
+	d.mu.Unlock()
+	return set, nil
+}
+
+// render executes a page with data, through the layout when one is set
+func (d *templateDir) render(name string, data interface{}) ([]byte, error) {
+	set, err := d.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	entry := name
+	if d.opts.Layout != "" {
+		entry = d.opts.Layout
+	}
+	var buf bytes.Buffer
+	if err := set.ExecuteTemplate(&buf, entry, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// acceptQuality returns the q-value an Accept header gives mediaType,
+// using the most specific matching range
+func acceptQuality(header, mediaType string) float64 {
+	kind, _, _ := strings.Cut(mediaType, "/")
+	best, specificity := 0.0, -1
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		accepted := strings.ToLower(strings.TrimSpace(fields[0]))
+		level := -1
+		switch accepted {
+		case mediaType:
+			level = 2
+		case kind + "/*":
+			level = 1
+		case "*/*":
+			level = 0
+		}
+		if level < 0 || level < specificity {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if level > specificity || q > best {
+			best, specificity = q, level
+		}
+	}
+	return best
+}
+
+// prefersJSON reports whether the client asked for JSON over HTML. Missing
+// or indifferent Accept headers get HTML.
+func prefersJSON(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return false
+	}
+	return acceptQuality(accept, "application/json") > acceptQuality(accept, "text/html")
+}
+
+// serveTemplateRoute renders the route's template with the JSON context
+// its handler returns, or with the path and query parameters when it has
+// no handler. Clients preferring JSON get the context itself.
+func serveTemplateRoute(w http.ResponseWriter, r *http.Request, route RouteInfo) {
+	response := HandlerResponse{Status: route.successStatus()}
+	var context interface{}
+	if route.Handler != 0 {
+		var ok bool
+		if response, ok = callHandlerRoute(w, r, route); !ok {
+			return
+		}
+		body := []byte(response.Body)
+		if response.BodyBase64 != nil {
+			body = response.BodyBase64
+		}
+		if len(bytes.TrimSpace(body)) > 0 {
+			if err := json.Unmarshal(body, &context); err != nil {
+				slog.Error("Template context is not JSON", "method", route.Method, "route", route.Path, "error", err)
+				http.Error(w, `{"error": "Internal server error"}`, http.StatusInternalServerError)
+				return
+			}
+		}
+	} else {
+		context = map[string]interface{}{"path_params": PathParams(r), "query": r.URL.Query()}
+	}
+	w.Header().Add("Vary", "Accept")
+
+	if prefersJSON(r) {
+		body, err := json.Marshal(context)
+		if err != nil {
+			slog.Error("Error encoding template context", "error", err)
+			http.Error(w, `{"error": "Internal server error"}`, http.StatusInternalServerError)
+			return
+		}
+		response.Body, response.BodyBase64 = string(body), nil
+		if response.Headers == nil {
+			response.Headers = make(map[string]string)
+		}
+		response.Headers["Content-Type"] = "application/json"
+		writeHandlerResponse(w, route, response)
+		return
+	}
+
+	templatesMu.RLock()
+	dir := templates
+	templatesMu.RUnlock()
+	if dir == nil {
+		slog.Error("Template route served before RegisterTemplateDir", "route", route.Path, "template", route.Template)
+		http.Error(w, `{"error": "Internal server error"}`, http.StatusInternalServerError)
+		return
+	}
+	page, err := dir.render(route.Template, context)
+	if err != nil {
+		slog.Error("Error rendering template", "route", route.Path, "template", route.Template, "error", err)
+		http.Error(w, `{"error": "Internal server error"}`, http.StatusInternalServerError)
+		return
+	}
+	response.Body, response.BodyBase64 = string(page), nil
+	if response.Headers == nil {
+		response.Headers = make(map[string]string)
+	}
+	if _, ok := response.Headers["Content-Type"]; !ok {
+		response.Headers["Content-Type"] = "text/html; charset=utf-8"
+	}
+	writeHandlerResponse(w, route, response)
+}
+
+// RegisterTemplateDir loads html/template files from cDir for template
+// routes, replacing any directory registered before. cOptions is a JSON
+// TemplateOptions object or empty. With caching on, every page is parsed
+// now so syntax errors surface at registration.
+//
+//export RegisterTemplateDir
+func RegisterTemplateDir(cDir *C.char, cOptions *C.char) int {
+	if cDir == nil || cOptions == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in RegisterTemplateDir")
+	}
+	dirName := C.GoString(cDir)
+	options := C.GoString(cOptions)
+	root, err := filepath.Abs(dirName)
+	if err != nil {
+		return exportError(exportInvalid, "Invalid template directory", "dir", dirName, "error", err)
+	}
+	if info, err := os.Stat(root); err != nil || !info.IsDir() {
+		return exportError(exportNotFound, "Template directory does not exist", "dir", root)
+	}
+	opts := TemplateOptions{Partials: "partials", Extension: ".html"}
+	if options != "" {
+		if err := json.Unmarshal([]byte(options), &opts); err != nil {
+			return exportError(exportInvalid, "Invalid template options", "dir", root, "error", err)
+		}
+	}
+	if opts.Layout != "" && !validTemplateName(opts.Layout) || !validTemplateName(opts.Partials) {
+		return exportError(exportInvalid, "Invalid template layout or partials path", "layout", opts.Layout, "partials", opts.Partials)
+	}
+	if !strings.HasPrefix(opts.Extension, ".") {
+		opts.Extension = "." + opts.Extension
+	}
+	dir := &templateDir{root: root, opts: opts, cache: opts.Cache == nil || *opts.Cache, parsed: make(map[string]*template.Template)}
+
+	pages, err := dir.pages()
+	if err != nil {
+		return exportError(exportFailed, "Error listing templates", "dir", root, "error", err)
+	}
+	if dir.cache {
+		for _, name := range pages {
+			if _, err := dir.lookup(name); err != nil {
+				return exportError(exportFailed, "Error parsing template", "template", name, "error", err)
+			}
+		}
+	}
+
+	templatesMu.Lock()
+	templates = dir
+	templatesMu.Unlock()
+
+	slog.Info("Template directory registered", "dir", root, "templates", len(pages), "layout", opts.Layout, "cache", dir.cache)
+	auditConfigChange("RegisterTemplateDir", map[string]string{"dir": root, "options": options})
+
+	return 0
+}
+
+// RegisterTemplateRoute registers a route rendering cTemplate, a name
+// relative to the template directory. The handler uses the route handler
+// ABI and returns the template context as a JSON body, along with any
+// status and headers; with a NULL handler the context is the path and
+// query parameters. Requests whose Accept header prefers application/json
+// get the context as JSON instead of the rendered page.
+//
+//export RegisterTemplateRoute
+func RegisterTemplateRoute(cPath *C.char, cMethod *C.char, cTemplate *C.char, cDesc *C.char, cHandler uintptr) int {
+	if cPath == nil || cMethod == nil || cTemplate == nil || cDesc == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in RegisterTemplateRoute")
+	}
+	path := C.GoString(cPath)
+	method := strings.ToUpper(C.GoString(cMethod))
+	name := C.GoString(cTemplate)
+	desc := C.GoString(cDesc)
+	if !validTemplateName(name) {
+		return exportError(exportInvalid, "Invalid template name", "template", name)
+	}
+
+	routesMu.Lock()
+	key := path + method
+	routes[key] = RouteInfo{
+		Path:        path,
+		Method:      method,
+		Description: desc,
+		Parameters:  pathParameters(path),
+		Responses: map[int]string{
+			200: "Successful response",
+		},
+		Handler:  cHandler,
+		Template: name,
+	}
+	routeTree.insert(path, method, key)
+	routesMu.Unlock()
+	invalidateOpenAPICache()
+
+	slog.Info("Template route registered", "key", key, "template", name)
+	auditConfigChange("RegisterTemplateRoute", map[string]string{"path": path, "method": method, "template": name, "description": desc})
+
+	return 0
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"C"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// timeoutOptions configures the "timeout" middleware
+type timeoutOptions struct {
+	TimeoutMs int `json:"timeout_ms"` // defaults to 30000
+}
+
+// timeoutWriter lets the handler goroutine write through to the client
+// until the deadline passes; from then on its writes fail. Headers are
+// kept apart until the status is written so the 504 never races with a
+// handler still setting them.
+type timeoutWriter struct {
+	w        http.ResponseWriter
+	mu       sync.Mutex
+	h        http.Header
+	started  bool
+	timedOut bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.h
+}
+
+// sendHeader copies the handler's headers to the client response; tw.mu
+// must be held
+func (tw *timeoutWriter) sendHeader(code int) {
+	dst := tw.w.Header()
+	for name := range dst {
+		if _, ok := tw.h[name]; !ok {
+			delete(dst, name)
+		}
+	}
+	for name, values := range tw.h {
+		dst[name] = values
+	}
+	tw.w.WriteHeader(code)
+	if code >= 200 {
+		tw.started = true
+	}
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.started {
+		return
+	}
+	tw.sendHeader(code)
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if !tw.started {
+		tw.sendHeader(http.StatusOK)
+	}
+	return tw.w.Write(b)
+}
+
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return
+	}
+	if !tw.started {
+		tw.sendHeader(http.StatusOK)
+	}
+	if f, ok := tw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (tw *timeoutWriter) Unwrap() http.ResponseWriter {
+	return tw.w
+}
+
+// finish copies headers set after the body, i.e. trailers, once the
+// handler has returned in time
+func (tw *timeoutWriter) finish() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	dst := tw.w.Header()
+	for name, values := range tw.h {
+		dst[name] = values
+	}
+}
+
+// expire marks the response as timed out and reports whether it had
+// already started
+func (tw *timeoutWriter) expire() bool {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.timedOut = true
+	return tw.started
+}
+
+// newTimeoutMiddleware builds the "timeout" middleware. The route runs
+// under a context deadline, so proxied requests, task queue waits, and
+// other work tied to the request are cancelled when it passes, and the
+// client gets a JSON 504. Host callbacks cannot be interrupted; one still
+// running is left to finish and its response discarded. A response that
+// had already started is cut off instead. Routes configured with
+// SetRouteTimeout use their own value; WebSocket and SSE routes have none.
+func newTimeoutMiddleware(options []byte) (func(http.Handler) http.Handler, error) {
+	opts := timeoutOptions{TimeoutMs: 30000}
+	if len(options) > 0 {
+		if err := json.Unmarshal(options, &opts); err != nil {
+			return nil, err
+		}
+	}
+	if opts.TimeoutMs <= 0 {
+		return nil, fmt.Errorf("timeout_ms must be positive")
+	}
+	defaultTimeout := time.Duration(opts.TimeoutMs) * time.Millisecond
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			timeout := defaultTimeout
+			routesMu.RLock()
+			route, _, _, found := findRoute(r.Host, r.URL.Path, r.Method)
+			routesMu.RUnlock()
+			if found {
+				if route.WebSocket != 0 || route.SSE != 0 {
+					next.ServeHTTP(w, r)
+					return
+				}
+				if route.Timeout != 0 {
+					timeout = route.Timeout
+				}
+			}
+			if timeout < 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			r = r.WithContext(ctx)
+			tw := &timeoutWriter{w: w, h: w.Header().Clone()}
+			done := make(chan struct{})
+			panicked := make(chan any, 1)
+			go func() {
+				defer func() {
+					if p := recover(); p != nil {
+						panicked <- p
+					}
+				}()
+				next.ServeHTTP(tw, r)
+				close(done)
+			}()
+
+			select {
+			case p := <-panicked:
+				// Re-raised here so recoveryMiddleware sees it
+				panic(p)
+			case <-done:
+				tw.finish()
+			case <-ctx.Done():
+				if ctx.Err() != context.DeadlineExceeded {
+					// The client went away; nobody is left to answer
+					tw.expire()
+					return
+				}
+				stats.RequestsTimedOut.Add(1)
+				started := tw.expire()
+				slog.Warn("Request timed out", "method", r.Method, "path", r.URL.Path, "timeout", timeout)
+				if started {
+					panic(http.ErrAbortHandler)
+				}
+				http.Error(w, `{"error": "Request timed out"}`, http.StatusGatewayTimeout)
+			}
+		})
+	}, nil
+}
+
+// deadlineFrom returns the request's deadline, if one is set
+func deadlineFrom(ctx context.Context) *time.Time {
+	if deadline, ok := ctx.Deadline(); ok {
+		return &deadline
+	}
+	return nil
+}
+
+// SetRouteTimeout overrides the timeout middleware's deadline for one
+// route, in milliseconds. 0 restores the default and -1 removes the limit.
+//
+//export SetRouteTimeout
+func SetRouteTimeout(cPath *C.char, cMethod *C.char, timeoutMs int) int {
+	if cPath == nil || cMethod == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in SetRouteTimeout")
+	}
+	if timeoutMs < -1 {
+		return exportError(exportInvalid, "Invalid route timeout", "timeout_ms", timeoutMs)
+	}
+	path := C.GoString(cPath)
+	method := strings.ToUpper(C.GoString(cMethod))
+
+	routesMu.Lock()
+	key := path + method
+	route, exists := routes[key]
+	if !exists {
+		routesMu.Unlock()
+		return exportError(exportNotFound, "Cannot set timeout, route not found", "key", key)
+	}
+	route.Timeout = routeTimeout(timeoutMs)
+	if timeoutMs == -1 {
+		delete(route.Responses, http.StatusGatewayTimeout)
+	} else {
+		route.Responses[http.StatusGatewayTimeout] = "Request timed out"
+	}
+	routes[key] = route
+	routesMu.Unlock()
+	invalidateOpenAPICache()
+
+	slog.Info("Route timeout set", "key", key, "timeout_ms", timeoutMs)
+	auditConfigChange("SetRouteTimeout", map[string]string{"path": path, "method": method, "timeout_ms": strconv.Itoa(timeoutMs)})
+
+	return 0
+}
+
+// routeTimeout converts a timeout in milliseconds, keeping -1 as "none"
+func routeTimeout(timeoutMs int) time.Duration {
+	if timeoutMs == -1 {
+		return -1
+	}
+	return time.Duration(timeoutMs) * time.Millisecond
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"C"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// TLSSettings describes how the server obtains its certificate
+type TLSSettings struct {
+	Enabled      bool
+	CertFile     string
+	KeyFile      string
+	CertPEM      []byte
+	KeyPEM       []byte
+	SelfSigned   bool
+	Hosts        []string // SANs for the self-signed certificate
+	RedirectHTTP bool
+	RedirectPort int // plain HTTP port redirected to HTTPS
+	ClientCAFile string
+	ClientCAPEM  []byte
+	ClientAuth   string // "", "verify" (optional client certificate), or "require"
+}
+
+var (
+	tlsSettings   TLSSettings
+	tlsSettingsMu sync.RWMutex
+)
+
+// currentTLSSettings returns a copy of the TLS configuration
+func currentTLSSettings() TLSSettings {
+	tlsSettingsMu.RLock()
+	defer tlsSettingsMu.RUnlock()
+	return tlsSettings
+}
+
+// loadCertificate resolves the configured certificate source
+func (s TLSSettings) loadCertificate() (tls.Certificate, error) {
+	switch {
+	case s.SelfSigned:
+		certPEM, keyPEM, err := generateSelfSignedCert(s.Hosts)
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+		return tls.X509KeyPair(certPEM, keyPEM)
+	case len(s.CertPEM) > 0:
+		return tls.X509KeyPair(s.CertPEM, s.KeyPEM)
+	default:
+		return tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+	}
+}
+
+// buildTLSConfig returns the server TLS config, or nil when TLS is disabled
+func buildTLSConfig(s TLSSettings) (*tls.Config, error) {
+	if !s.Enabled {
+		return nil, nil
+	}
+	cert, err := s.loadCertificate()
+	if err != nil {
+		return nil, fmt.Errorf("loading TLS certificate: %w", err)
+	}
+	config := &tls.Config{
+		Certificates:   []tls.Certificate{cert},
+		GetCertificate: virtualHostCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+	if s.ClientAuth != "" {
+		if config.ClientCAs, err = s.loadClientCAs(); err != nil {
+			return nil, fmt.Errorf("loading client CA bundle: %w", err)
+		}
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		if s.ClientAuth == "require" {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	return config, nil
+}
+
+// loadClientCAs reads the CA bundle client certificates are verified
+// against
+func (s TLSSettings) loadClientCAs() (*x509.CertPool, error) {
+	bundle := s.ClientCAPEM
+	if len(bundle) == 0 {
+		var err error
+		if bundle, err = os.ReadFile(s.ClientCAFile); err != nil {
+			return nil, err
+		}
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(bundle) {
+		return nil, fmt.Errorf("no certificates found")
+	}
+	return pool, nil
+}
+
+// ClientCertInfo describes the verified client certificate of a mutual TLS
+// connection, as passed to host handlers and middleware
+type ClientCertInfo struct {
+	Subject     string    `json:"subject"`
+	CommonName  string    `json:"common_name"`
+	Issuer      string    `json:"issuer"`
+	Serial      string    `json:"serial"`
+	DNSNames    []string  `json:"dns_names,omitempty"`
+	Emails      []string  `json:"emails,omitempty"`
+	URIs        []string  `json:"uris,omitempty"`
+	NotAfter    time.Time `json:"not_after"`
+	Fingerprint string    `json:"fingerprint"` // hex SHA-256 of the DER certificate
+}
+
+// clientCertFrom returns the client certificate of r if it was verified
+// against the client CA bundle, or nil
+func clientCertFrom(r *http.Request) *ClientCertInfo {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	cert := r.TLS.PeerCertificates[0]
+	sum := sha256.Sum256(cert.Raw)
+	info := &ClientCertInfo{
+		Subject:     cert.Subject.String(),
+		CommonName:  cert.Subject.CommonName,
+		Issuer:      cert.Issuer.String(),
+		Serial:      cert.SerialNumber.Text(16),
+		DNSNames:    cert.DNSNames,
+		Emails:      cert.EmailAddresses,
+		NotAfter:    cert.NotAfter,
+		Fingerprint: hex.EncodeToString(sum[:]),
+	}
+	for _, uri := range cert.URIs {
+		info.URIs = append(info.URIs, uri.String())
+	}
+	return info
+}
+
+// generateSelfSignedCert creates a P-256 certificate valid for one year,
+// intended for local development only
+func generateSelfSignedCert(hosts []string) (certPEM []byte, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"GoServer Development"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// httpsRedirectHandler redirects plain HTTP requests to the HTTPS port
+func httpsRedirectHandler(httpsPort int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if httpsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+		}
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	})
+}
+
+// startRedirectServer serves HTTP-to-HTTPS redirects on the configured port
+// and returns the server with its listener
+func startRedirectServer(cfg ServerConfig, s TLSSettings) (*http.Server, net.Listener, error) {
+	redirect := &http.Server{
+		Addr:         net.JoinHostPort(cfg.host(), strconv.Itoa(s.RedirectPort)),
+		Handler:      httpsRedirectHandler(cfg.Port),
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
+	}
+	listener, err := listenTCP("redirect:"+redirect.Addr, redirect.Addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	go func() {
+		slog.Info("Redirecting HTTP to HTTPS", "addr", redirect.Addr)
+		if err := redirect.Serve(listener); err != nil && err != http.ErrServerClosed {
+			slog.Error("Redirect server error", "error", err)
+		}
+	}()
+	return redirect, listener, nil
+}
+
+// EnableTLS serves HTTPS using the certificate and key files at the given paths
+//
+//export EnableTLS
+func EnableTLS(cCertPath *C.char, cKeyPath *C.char) int {
+	if cCertPath == nil || cKeyPath == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in EnableTLS")
+	}
+	certFile := C.GoString(cCertPath)
+	keyFile := C.GoString(cKeyPath)
+
+	tlsSettingsMu.Lock()
+	tlsSettings.Enabled = true
+	tlsSettings.SelfSigned = false
+	tlsSettings.CertFile, tlsSettings.KeyFile = certFile, keyFile
+	tlsSettings.CertPEM, tlsSettings.KeyPEM = nil, nil
+	tlsSettingsMu.Unlock()
+
+	slog.Info("TLS enabled", "cert_file", certFile)
+	auditConfigChange("EnableTLS", map[string]string{"cert_path": certFile, "key_path": keyFile})
+
+	return 0
+}
+
+// EnableTLSFromPEM serves HTTPS using PEM-encoded certificate and key bytes
+//
+//export EnableTLSFromPEM
+func EnableTLSFromPEM(cCertPEM *C.char, cCertLen int, cKeyPEM *C.char, cKeyLen int) int {
+	if cCertPEM == nil || cKeyPEM == nil || cCertLen <= 0 || cKeyLen <= 0 {
+		return exportError(exportInvalid, "One or more parameters are nil in EnableTLSFromPEM")
+	}
+	certPEM := C.GoBytes(unsafe.Pointer(cCertPEM), C.int(cCertLen))
+	keyPEM := C.GoBytes(unsafe.Pointer(cKeyPEM), C.int(cKeyLen))
+
+	tlsSettingsMu.Lock()
+	tlsSettings.Enabled = true
+	tlsSettings.SelfSigned = false
+	tlsSettings.CertFile, tlsSettings.KeyFile = "", ""
+	tlsSettings.CertPEM, tlsSettings.KeyPEM = certPEM, keyPEM
+	tlsSettingsMu.Unlock()
+
+	slog.Info("TLS enabled with in-memory PEM certificate")
+	auditConfigChange("EnableTLSFromPEM", map[string]string{"private_key": redactedValue})
+
+	return 0
+}
+
+// EnableSelfSignedTLS serves HTTPS with a certificate generated at startup
+// for the comma-separated hosts (default "localhost,127.0.0.1"). For
+// development only.
+//
+//export EnableSelfSignedTLS
+func EnableSelfSignedTLS(cHosts *C.char) int {
+	hosts := []string{"localhost", "127.0.0.1"}
+	if cHosts != nil {
+		if value := strings.TrimSpace(C.GoString(cHosts)); value != "" {
+			hosts = strings.Split(value, ",")
+			for i := range hosts {
+				hosts[i] = strings.TrimSpace(hosts[i])
+			}
+		}
+	}
+
+	tlsSettingsMu.Lock()
+	tlsSettings.Enabled = true
+	tlsSettings.SelfSigned = true
+	tlsSettings.Hosts = hosts
+	tlsSettingsMu.Unlock()
+
+	slog.Info("Self-signed TLS enabled", "hosts", strings.Join(hosts, ","))
+	auditConfigChange("EnableSelfSignedTLS", map[string]string{"hosts": strings.Join(hosts, ",")})
+
+	return 0
+}
+
+// EnableHTTPSRedirect starts a plain HTTP listener on httpPort alongside the
+// TLS server that permanently redirects every request to HTTPS. A port of 0
+// disables the redirect.
+//
+//export EnableHTTPSRedirect
+func EnableHTTPSRedirect(httpPort int) int {
+	if httpPort < 0 || httpPort > 65535 {
+		return exportError(exportInvalid, "Invalid HTTPS redirect port", "port", httpPort)
+	}
+	tlsSettingsMu.Lock()
+	tlsSettings.RedirectHTTP = httpPort > 0
+	tlsSettings.RedirectPort = httpPort
+	tlsSettingsMu.Unlock()
+
+	slog.Info("HTTPS redirect port set", "port", httpPort)
+	auditConfigChange("EnableHTTPSRedirect", map[string]string{"http_port": strconv.Itoa(httpPort)})
+
+	return 0
+}
+
+// EnableClientAuth turns on mutual TLS, verifying client certificates
+// against the PEM CA bundle at cCAPath. Mode "require" rejects handshakes
+// without a valid certificate; "verify" lets clients without one connect
+// but still rejects invalid ones. An empty mode disables client
+// authentication. Verified certificates reach handlers and middleware as
+// client_cert.
+//
+//export EnableClientAuth
+func EnableClientAuth(cCAPath *C.char, cMode *C.char) int {
+	if cCAPath == nil || cMode == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in EnableClientAuth")
+	}
+	caFile := C.GoString(cCAPath)
+	mode := strings.ToLower(strings.TrimSpace(C.GoString(cMode)))
+	if mode != "" && mode != "verify" && mode != "require" {
+		return exportError(exportInvalid, "Invalid client auth mode", "mode", mode)
+	}
+	if mode != "" && caFile == "" {
+		return exportError(exportInvalid, "Client auth needs a CA bundle", "mode", mode)
+	}
+
+	tlsSettingsMu.Lock()
+	tlsSettings.ClientAuth = mode
+	tlsSettings.ClientCAFile, tlsSettings.ClientCAPEM = caFile, nil
+	tlsSettingsMu.Unlock()
+
+	slog.Info("Client certificate authentication set", "mode", mode, "ca_file", caFile)
+	auditConfigChange("EnableClientAuth", map[string]string{"ca_path": caFile, "mode": mode})
+
+	return 0
+}
+
+// EnableClientAuthFromPEM is EnableClientAuth with an in-memory PEM CA
+// bundle
+//
+//export EnableClientAuthFromPEM
+func EnableClientAuthFromPEM(cCAPEM *C.char, cCALen int, cMode *C.char) int {
+	if cCAPEM == nil || cCALen <= 0 || cMode == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in EnableClientAuthFromPEM")
+	}
+	bundle := C.GoBytes(unsafe.Pointer(cCAPEM), C.int(cCALen))
+	mode := strings.ToLower(strings.TrimSpace(C.GoString(cMode)))
+	if mode != "" && mode != "verify" && mode != "require" {
+		return exportError(exportInvalid, "Invalid client auth mode", "mode", mode)
+	}
+
+	tlsSettingsMu.Lock()
+	tlsSettings.ClientAuth = mode
+	tlsSettings.ClientCAFile, tlsSettings.ClientCAPEM = "", bundle
+	tlsSettingsMu.Unlock()
+
+	slog.Info("Client certificate authentication set with in-memory CA bundle", "mode", mode)
+	auditConfigChange("EnableClientAuthFromPEM", map[string]string{"mode": mode})
+
+	return 0
+}
@@ -0,0 +1,583 @@
+package main
+
+import (
+	"C"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	mathrand "math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultTraceServiceName = "goserver"
+	defaultTraceBatchSize   = 512
+	defaultTraceFlushMs     = 5000
+	traceQueueDepth         = 4096
+	traceExportTimeout      = 10 * time.Second
+	traceScopeName          = "goserver"
+)
+
+// OTLP span kinds and status codes
+const (
+	spanKindInternal = 1
+	spanKindServer   = 2
+	spanKindClient   = 3
+	spanKindConsumer = 5
+
+	spanStatusError = 2
+)
+
+// TracingOptions configures the OTLP/HTTP trace exporter
+type TracingOptions struct {
+	ServiceName     string            `json:"service_name"`      // defaults to goserver
+	SampleRatio     *float64          `json:"sample_ratio"`      // root span sampling, 0 to 1; defaults to 1
+	Headers         map[string]string `json:"headers"`           // sent with every export, e.g. collector auth
+	BatchSize       int               `json:"batch_size"`        // spans per export; defaults to 512
+	FlushIntervalMs int               `json:"flush_interval_ms"` // defaults to 5000
+}
+
+// spanContext identifies a span across process boundaries (W3C Trace Context)
+type spanContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+	sampled bool
+}
+
+// traceparent formats the context as a W3C traceparent header value
+func (sc spanContext) traceparent() string {
+	flags := "00"
+	if sc.sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(sc.traceID[:]) + "-" + hex.EncodeToString(sc.spanID[:]) + "-" + flags
+}
+
+// parseTraceparent reads a W3C traceparent header. Unknown future versions
+// are accepted as long as the version 00 fields parse.
+func parseTraceparent(value string) (spanContext, bool) {
+	var sc spanContext
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, false
+	}
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.traceID[:], []byte(parts[1])); err != nil || sc.traceID == [16]byte{} {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.spanID[:], []byte(parts[2])); err != nil || sc.spanID == [8]byte{} {
+		return sc, false
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return sc, false
+	}
+	sc.sampled = flags&1 == 1
+	return sc, true
+}
+
+// span is one timed operation. Methods are safe on a nil span, which is
+// what startSpan returns while tracing is off.
+type span struct {
+	sc       spanContext
+	parentID [8]byte
+	name     string
+	kind     int
+	start    time.Time
+
+	mu         sync.Mutex
+	attributes map[string]interface{}
+	errMsg     string
+	ended      bool
+	endTime    time.Time
+}
+
+type spanKey struct{}
+
+// spanFrom returns the span carried by ctx, or nil
+func spanFrom(ctx context.Context) *span {
+	sp, _ := ctx.Value(spanKey{}).(*span)
+	return sp
+}
+
+// traceIDFrom returns the hex trace ID of the span in ctx, or ""
+func traceIDFrom(ctx context.Context) string {
+	if sp := spanFrom(ctx); sp != nil {
+		return hex.EncodeToString(sp.sc.traceID[:])
+	}
+	return ""
+}
+
+// traceparentFrom returns the traceparent for the span in ctx, or ""
+func traceparentFrom(ctx context.Context) string {
+	if sp := spanFrom(ctx); sp != nil {
+		return sp.sc.traceparent()
+	}
+	return ""
+}
+
+// tracer owns the exporter for one EnableTracing configuration
+type tracer struct {
+	endpoint string
+	opts     TracingOptions
+	queue    chan *span
+	flushReq chan chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+	client   *http.Client
+	dropped  atomic.Uint64
+}
+
+var activeTracer atomic.Pointer[tracer]
+
+// startSpan begins a child of the span in ctx, or a new root when ctx has
+// none. It returns ctx unchanged and a nil span while tracing is off.
+func startSpan(ctx context.Context, name string, kind int) (context.Context, *span) {
+	t := activeTracer.Load()
+	if t == nil {
+		return ctx, nil
+	}
+	parent := spanFrom(ctx)
+	var parentSC *spanContext
+	if parent != nil {
+		parentSC = &parent.sc
+	}
+	sp := t.newSpan(parentSC, name, kind)
+	return context.WithValue(ctx, spanKey{}, sp), sp
+}
+
+// startRemoteSpan begins a span continuing a remote parent, such as one
+// read from an incoming traceparent header
+func startRemoteSpan(ctx context.Context, parent *spanContext, name string, kind int) (context.Context, *span) {
+	t := activeTracer.Load()
+	if t == nil {
+		return ctx, nil
+	}
+	sp := t.newSpan(parent, name, kind)
+	return context.WithValue(ctx, spanKey{}, sp), sp
+}
+
+func (t *tracer) newSpan(parent *spanContext, name string, kind int) *span {
+	sp := &span{name: name, kind: kind, start: time.Now()}
+	rand.Read(sp.sc.spanID[:])
+	if parent != nil {
+		sp.sc.traceID = parent.traceID
+		sp.sc.sampled = parent.sampled
+		sp.parentID = parent.spanID
+	} else {
+		rand.Read(sp.sc.traceID[:])
+		ratio := 1.0
+		if t.opts.SampleRatio != nil {
+			ratio = *t.opts.SampleRatio
+		}
+		sp.sc.sampled = ratio >= 1 || mathrand.Float64() < ratio
+	}
+	return sp
+}
+
+func (sp *span) setAttr(key string, value interface{}) {
+	if sp == nil {
+		return
+	}
+	sp.mu.Lock()
+	if sp.attributes == nil {
+		sp.attributes = make(map[string]interface{})
+	}
+	sp.attributes[key] = value
+	sp.mu.Unlock()
+}
+
+func (sp *span) setName(name string) {
+	if sp == nil {
+		return
+	}
+	sp.mu.Lock()
+	sp.name = name
+	sp.mu.Unlock()
+}
+
+// setError marks the span failed
+func (sp *span) setError(msg string) {
+	if sp == nil {
+		return
+	}
+	sp.mu.Lock()
+	sp.errMsg = msg
+	sp.mu.Unlock()
+}
+
+// end finishes the span and queues it for export if it was sampled
+func (sp *span) end() {
+	if sp == nil {
+		return
+	}
+	sp.mu.Lock()
+	if sp.ended {
+		sp.mu.Unlock()
+		return
+	}
+	sp.ended, sp.endTime = true, time.Now()
+	sp.mu.Unlock()
+	t := activeTracer.Load()
+	if t == nil || !sp.sc.sampled {
+		return
+	}
+	select {
+	case t.queue <- sp:
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+// otlpSpan encodes a span in the OTLP/HTTP JSON form
+func (sp *span) otlpSpan() map[string]interface{} {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	out := map[string]interface{}{
+		"traceId":           hex.EncodeToString(sp.sc.traceID[:]),
+		"spanId":            hex.EncodeToString(sp.sc.spanID[:]),
+		"name":              sp.name,
+		"kind":              sp.kind,
+		"startTimeUnixNano": strconv.FormatInt(sp.start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(sp.endTime.UnixNano(), 10),
+		"attributes":        otlpAttributes(sp.attributes),
+	}
+	if sp.parentID != [8]byte{} {
+		out["parentSpanId"] = hex.EncodeToString(sp.parentID[:])
+	}
+	if sp.errMsg != "" {
+		out["status"] = map[string]interface{}{"code": spanStatusError, "message": sp.errMsg}
+	}
+	return out
+}
+
+// otlpAttributes encodes attributes as OTLP KeyValue objects
+func otlpAttributes(attrs map[string]interface{}) []map[string]interface{} {
+	out := make([]map[string]interface{}, 0, len(attrs))
+	for _, key := range sortedKeys(attrs) {
+		var value map[string]interface{}
+		switch v := attrs[key].(type) {
+		case int:
+			value = map[string]interface{}{"intValue": strconv.Itoa(v)}
+		case int64:
+			value = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+		case bool:
+			value = map[string]interface{}{"boolValue": v}
+		case float64:
+			value = map[string]interface{}{"doubleValue": v}
+		default:
+			value = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+		}
+		out = append(out, map[string]interface{}{"key": key, "value": value})
+	}
+	return out
+}
+
+// run batches queued spans and exports them until stopped
+func (t *tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(time.Duration(t.opts.FlushIntervalMs) * time.Millisecond)
+	defer ticker.Stop()
+	var batch []*span
+	flush := func() {
+		if len(batch) > 0 {
+			t.export(batch)
+			batch = nil
+		}
+	}
+	for {
+		select {
+		case sp := <-t.queue:
+			batch = append(batch, sp)
+			if len(batch) >= t.opts.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case ack := <-t.flushReq:
+			batch = t.drainQueue(batch)
+			flush()
+			close(ack)
+		case <-t.stop:
+			flush()
+			t.export(t.drainQueue(nil))
+			return
+		}
+	}
+}
+
+// drainQueue moves every span waiting in the queue into batch
+func (t *tracer) drainQueue(batch []*span) []*span {
+	for {
+		select {
+		case sp := <-t.queue:
+			batch = append(batch, sp)
+		default:
+			return batch
+		}
+	}
+}
+
+// export posts one batch to the collector. Failures are logged and the
+// batch is dropped so a down collector cannot back up request handling.
+func (t *tracer) export(batch []*span) {
+	if len(batch) == 0 {
+		return
+	}
+	spans := make([]map[string]interface{}, len(batch))
+	for i, sp := range batch {
+		spans[i] = sp.otlpSpan()
+	}
+	payload := map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": otlpAttributes(map[string]interface{}{"service.name": t.opts.ServiceName}),
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{"name": traceScopeName},
+				"spans": spans,
+			}},
+		}},
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		slog.Error("Error encoding trace export", "error", err)
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		slog.Error("Error building trace export", "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range t.opts.Headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		slog.Warn("Trace export failed", "endpoint", t.endpoint, "spans", len(batch), "error", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		slog.Warn("Trace export rejected", "endpoint", t.endpoint, "spans", len(batch), "status", resp.StatusCode)
+		return
+	}
+	slog.Debug("Exported spans", "spans", len(batch))
+}
+
+// flush exports every span ended so far, waiting until ctx ends at most
+func (t *tracer) flush(ctx context.Context) {
+	ack := make(chan struct{})
+	select {
+	case t.flushReq <- ack:
+	case <-t.done:
+		return
+	case <-ctx.Done():
+		return
+	}
+	select {
+	case <-ack:
+	case <-ctx.Done():
+	}
+}
+
+// shutdown exports what is left and stops the exporter
+func (t *tracer) shutdown() {
+	close(t.stop)
+	<-t.done
+	if dropped := t.dropped.Load(); dropped > 0 {
+		slog.Warn("Spans dropped because the export queue was full", "spans", dropped)
+	}
+}
+
+// flushTraces exports pending spans on the active tracer, if any
+func flushTraces(ctx context.Context) {
+	if t := activeTracer.Load(); t != nil {
+		t.flush(ctx)
+	}
+}
+
+// tracingMiddleware opens the server span for each request, continuing the
+// caller's trace when a valid traceparent header is present
+func tracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if activeTracer.Load() == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var parent *spanContext
+		if sc, ok := parseTraceparent(r.Header.Get("Traceparent")); ok {
+			parent = &sc
+		}
+		ctx, sp := startRemoteSpan(r.Context(), parent, r.Method, spanKindServer)
+		defer sp.end()
+		sp.setAttr("http.request.method", r.Method)
+		sp.setAttr("url.path", r.URL.Path)
+		sp.setAttr("network.peer.address", r.RemoteAddr)
+		if info := requestInfoFrom(r); info != nil {
+			sp.setAttr("request.id", info.ID)
+		}
+
+		rec := &statusRecorder{ResponseWriter: w}
+		r = r.WithContext(ctx)
+		next.ServeHTTP(rec, r)
+
+		status := rec.statusCode()
+		sp.setAttr("http.response.status_code", status)
+		if info := requestInfoFrom(r); info != nil && info.Route != "" {
+			sp.setName(r.Method + " " + info.Route)
+			sp.setAttr("http.route", info.Route)
+		}
+		if status >= 500 {
+			sp.setError(http.StatusText(status))
+		}
+	})
+}
+
+// traceMiddleware wraps a registered middleware in a span named after it
+func traceMiddleware(name string, mw func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		inner := mw(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, sp := startSpan(r.Context(), "middleware "+name, spanKindInternal)
+			if sp == nil {
+				inner.ServeHTTP(w, r)
+				return
+			}
+			defer sp.end()
+			inner.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// tracingTransport is an http.RoundTripper for outbound calls. It records a
+// client span and forwards the trace with a traceparent header.
+type tracingTransport struct {
+	base http.RoundTripper
+}
+
+func newTracingTransport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &tracingTransport{base: base}
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, sp := startSpan(req.Context(), req.Method, spanKindClient)
+	if sp == nil {
+		return t.base.RoundTrip(req)
+	}
+	defer sp.end()
+	req = req.Clone(ctx)
+	req.Header.Set("Traceparent", sp.sc.traceparent())
+	sp.setAttr("http.request.method", req.Method)
+	sp.setAttr("url.full", req.URL.Redacted())
+	sp.setAttr("server.address", req.URL.Host)
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		sp.setError(err.Error())
+		return nil, err
+	}
+	sp.setAttr("http.response.status_code", resp.StatusCode)
+	if resp.StatusCode >= 500 {
+		sp.setError(http.StatusText(resp.StatusCode))
+	}
+	return resp, nil
+}
+
+// otlpTracesURL appends the OTLP/HTTP traces path when the endpoint has none
+func otlpTracesURL(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return "", fmt.Errorf("endpoint must be an http or https URL")
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/traces"
+	}
+	return u.String(), nil
+}
+
+// EnableTracing exports spans to an OTLP/HTTP collector at cEndpoint, e.g.
+// http://localhost:4318 (/v1/traces is added when no path is given). An
+// empty endpoint turns tracing off. cOptions is a JSON TracingOptions
+// object and may be empty. Spans already ended are exported before the
+// previous configuration is replaced.
+//
+//export EnableTracing
+func EnableTracing(cEndpoint *C.char, cOptions *C.char) int {
+	if cEndpoint == nil || cOptions == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in EnableTracing")
+	}
+	endpoint := C.GoString(cEndpoint)
+	options := C.GoString(cOptions)
+
+	if endpoint == "" {
+		if old := activeTracer.Swap(nil); old != nil {
+			old.shutdown()
+		}
+		slog.Info("Tracing disabled")
+		auditConfigChange("EnableTracing", map[string]string{"endpoint": ""})
+		return 0
+	}
+
+	tracesURL, err := otlpTracesURL(endpoint)
+	if err != nil {
+		return exportError(exportInvalid, "Invalid tracing endpoint", "endpoint", endpoint, "error", err)
+	}
+	opts := TracingOptions{ServiceName: defaultTraceServiceName, BatchSize: defaultTraceBatchSize, FlushIntervalMs: defaultTraceFlushMs}
+	if options != "" {
+		if err := json.Unmarshal([]byte(options), &opts); err != nil {
+			return exportError(exportInvalid, "Invalid tracing options", "error", err)
+		}
+	}
+	if opts.SampleRatio != nil && (*opts.SampleRatio < 0 || *opts.SampleRatio > 1) {
+		return exportError(exportInvalid, "Invalid tracing sample ratio", "sample_ratio", *opts.SampleRatio)
+	}
+	if opts.BatchSize <= 0 || opts.FlushIntervalMs <= 0 {
+		return exportError(exportInvalid, "Invalid tracing options", "batch_size", opts.BatchSize, "flush_interval_ms", opts.FlushIntervalMs)
+	}
+	if opts.ServiceName == "" {
+		opts.ServiceName = defaultTraceServiceName
+	}
+
+	t := &tracer{
+		endpoint: tracesURL,
+		opts:     opts,
+		queue:    make(chan *span, traceQueueDepth),
+		flushReq: make(chan chan struct{}),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		client:   &http.Client{Timeout: traceExportTimeout},
+	}
+	go t.run()
+	if old := activeTracer.Swap(t); old != nil {
+		old.shutdown()
+	}
+
+	slog.Info("Tracing enabled", "endpoint", tracesURL, "service_name", opts.ServiceName)
+	// Collector headers usually carry credentials, so only their names are audited
+	auditConfigChange("EnableTracing", map[string]string{
+		"endpoint":     tracesURL,
+		"service_name": opts.ServiceName,
+		"headers":      strings.Join(sortedKeys(opts.Headers), ","),
+	})
+
+	return 0
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"C"
+	"log/slog"
+	"maps"
+	"net/http"
+	"strings"
+)
+
+// HTTP trailers are only delivered over HTTP/1.1 chunked responses and
+// HTTP/2 (or later). HTTP/1.0 clients never see them, so handlers must not
+// rely on trailers for anything a client is required to receive.
+
+// declareTrailers announces trailer names via the Trailer header. It must be
+// called before the first byte of the body is written.
+func declareTrailers(w http.ResponseWriter, names []string) {
+	if len(names) == 0 {
+		return
+	}
+	w.Header().Set("Trailer", strings.Join(names, ", "))
+}
+
+// setTrailers assigns trailer values once the body has been written. Names
+// that were not declared up front are sent using http.TrailerPrefix.
+func setTrailers(w http.ResponseWriter, declared []string, values map[string]string) {
+	for name, value := range values {
+		if containsFold(declared, name) {
+			w.Header().Set(name, value)
+		} else {
+			w.Header().Set(http.TrailerPrefix+name, value)
+		}
+	}
+}
+
+// trailerNames returns the canonical header names of a trailer map
+func trailerNames(values map[string]string) []string {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, http.CanonicalHeaderKey(name))
+	}
+	return names
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// RegisterRouteTrailer attaches a trailer to a registered route. The trailer
+// is declared before the body is written and its value is set afterwards
+// (HTTP/1.1+ only).
+//
+//export RegisterRouteTrailer
+func RegisterRouteTrailer(cPath *C.char, cMethod *C.char, cName *C.char, cValue *C.char) int {
+	if cPath == nil || cMethod == nil || cName == nil || cValue == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in RegisterRouteTrailer")
+	}
+	path := C.GoString(cPath)
+	method := strings.ToUpper(C.GoString(cMethod))
+	name := http.CanonicalHeaderKey(C.GoString(cName))
+	value := C.GoString(cValue)
+
+	routesMu.Lock()
+	defer routesMu.Unlock()
+	key := path + method
+	route, exists := routes[key]
+	if !exists {
+		return exportError(exportNotFound, "Cannot add trailer, route not found", "trailer", name, "key", key)
+	}
+	// Requests in flight hold the old map, so it is replaced, never edited
+	trailers := make(map[string]string, len(route.Trailers)+1)
+	maps.Copy(trailers, route.Trailers)
+	trailers[name] = value
+	route.Trailers = trailers
+	routes[key] = route
+	slog.Info("Registered route trailer", "trailer", name, "key", key)
+	auditConfigChange("RegisterRouteTrailer", map[string]string{"path": path, "method": method, "name": name})
+
+	return 0
+}
+
+// SetResponseTrailer sets the trailer cName of the response to the request
+// cRequestID, for values known only once the body is written, such as a
+// checksum of a streamed body. It can be called from the handler, and for
+// a streamed response until FinishResponse; names set before the handler
+// returns are declared with the header, and after it only the route's
+// trailers and those declared can be set. Returns 0, or a negative status
+// if the request is not being answered by a handler or has finished, or
+// the trailer was not declared.
+//
+//export SetResponseTrailer
+func SetResponseTrailer(cRequestID *C.char, cName *C.char, cValue *C.char) int {
+	if cRequestID == nil || cName == nil || cValue == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in SetResponseTrailer")
+	}
+	requestID := C.GoString(cRequestID)
+	name := http.CanonicalHeaderKey(C.GoString(cName))
+	if name == "" {
+		return exportError(exportInvalid, "Trailer name is empty in SetResponseTrailer", "request_id", requestID)
+	}
+	s, ok := activeResponseStream(requestID)
+	if !ok {
+		slog.Debug("Cannot set response trailer, request not active", "request_id", requestID, "trailer", name)
+		setLastError("Cannot set response trailer, request not active", "request_id", requestID, "trailer", name)
+		return exportNotFound
+	}
+	if err := s.setTrailer(name, C.GoString(cValue)); err != nil {
+		slog.Debug("Cannot set response trailer", "request_id", requestID, "trailer", name, "error", err)
+		setLastError("Cannot set response trailer", "request_id", requestID, "trailer", name, "error", err)
+		return exportFailed
+	}
+	return 0
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"C"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// ValidationError describes one field violation, mirroring FastAPI's format
+type ValidationError struct {
+	Loc  []interface{} `json:"loc"`
+	Msg  string        `json:"msg"`
+	Type string        `json:"type"`
+}
+
+// ValidationErrorResponse is the 422 body listing all violations
+type ValidationErrorResponse struct {
+	Detail []ValidationError `json:"detail"`
+}
+
+// BodySchema is a route's request body schema. It is either a JSON Schema
+// (recognised by a top-level "type" or "properties" key) or a map of field
+// names to validator tags, e.g. {"name": "required,min=3"}, which may nest.
+type BodySchema struct {
+	JSONSchema map[string]interface{}
+	Rules      map[string]interface{}
+	patterns   map[string]*regexp.Regexp // compiled JSON Schema patterns
+}
+
+// parseBodySchema decodes and classifies a schema document
+func parseBodySchema(doc []byte) (*BodySchema, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(doc, &raw); err != nil {
+		return nil, err
+	}
+	_, hasType := raw["type"]
+	_, hasProps := raw["properties"]
+	if hasType || hasProps {
+		schema := &BodySchema{JSONSchema: raw, patterns: make(map[string]*regexp.Regexp)}
+		if err := schema.compilePatterns(raw); err != nil {
+			return nil, err
+		}
+		return schema, nil
+	}
+	if err := checkRules(raw); err != nil {
+		return nil, err
+	}
+	return &BodySchema{Rules: raw}, nil
+}
+
+// checkRules verifies a tag rule map only holds strings and nested maps
+func checkRules(rules map[string]interface{}) error {
+	for field, rule := range rules {
+		switch r := rule.(type) {
+		case string:
+		case map[string]interface{}:
+			if err := checkRules(r); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("rule for field %q must be a tag string or object", field)
+		}
+	}
+	return nil
+}
+
+// compilePatterns pre-compiles every "pattern" keyword in a JSON Schema
+func (s *BodySchema) compilePatterns(node map[string]interface{}) error {
+	if pattern, ok := node["pattern"].(string); ok {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		s.patterns[pattern] = re
+	}
+	if props, ok := node["properties"].(map[string]interface{}); ok {
+		for _, prop := range props {
+			if child, ok := prop.(map[string]interface{}); ok {
+				if err := s.compilePatterns(child); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if items, ok := node["items"].(map[string]interface{}); ok {
+		return s.compilePatterns(items)
+	}
+	return nil
+}
+
+// Validate checks a decoded JSON body and returns every violation found
+func (s *BodySchema) Validate(body interface{}) []ValidationError {
+	loc := []interface{}{"body"}
+	if s.JSONSchema != nil {
+		return s.validateNode(s.JSONSchema, body, loc)
+	}
+	data, ok := body.(map[string]interface{})
+	if !ok {
+		return []ValidationError{{Loc: loc, Msg: "Input should be a valid object", Type: "dict_type"}}
+	}
+	return validateRules(s.Rules, data, loc)
+}
+
+// validateRules applies validator tags field by field so violations can be
+// reported with their full location
+func validateRules(rules map[string]interface{}, data map[string]interface{}, loc []interface{}) []ValidationError {
+	var errs []ValidationError
+	for _, field := range sortedKeys(rules) {
+		fieldLoc := appendLoc(loc, field)
+		switch rule := rules[field].(type) {
+		case string:
+			err := validate.Var(data[field], rule)
+			var fieldErrs validator.ValidationErrors
+			if errors.As(err, &fieldErrs) {
+				for _, fe := range fieldErrs {
+					errs = append(errs, ValidationError{Loc: fieldLoc, Msg: ruleMessage(fe), Type: fe.Tag()})
+				}
+			} else if err != nil {
+				errs = append(errs, ValidationError{Loc: fieldLoc, Msg: err.Error(), Type: "value_error"})
+			}
+		case map[string]interface{}:
+			value, exists := data[field]
+			if !exists || value == nil {
+				errs = append(errs, ValidationError{Loc: fieldLoc, Msg: "Field required", Type: "missing"})
+				continue
+			}
+			nested, ok := value.(map[string]interface{})
+			if !ok {
+				errs = append(errs, ValidationError{Loc: fieldLoc, Msg: "Input should be a valid object", Type: "dict_type"})
+				continue
+			}
+			errs = append(errs, validateRules(rule, nested, fieldLoc)...)
+		}
+	}
+	return errs
+}
+
+// ruleMessage renders a validator field error in plain words
+func ruleMessage(fe validator.FieldError) string {
+	if fe.Tag() == "required" {
+		return "Field required"
+	}
+	if fe.Param() != "" {
+		return fmt.Sprintf("Value failed the '%s=%s' rule", fe.Tag(), fe.Param())
+	}
+	return fmt.Sprintf("Value failed the '%s' rule", fe.Tag())
+}
+
+// validateNode checks value against a JSON Schema node
+func (s *BodySchema) validateNode(node map[string]interface{}, value interface{}, loc []interface{}) []ValidationError {
+	if enum, ok := node["enum"].([]interface{}); ok && !containsValue(enum, value) {
+		return []ValidationError{{Loc: loc, Msg: fmt.Sprintf("Input should be one of %s", formatEnum(enum)), Type: "enum"}}
+	}
+
+	typ, _ := node["type"].(string)
+	if typ == "" && node["properties"] != nil {
+		typ = "object"
+	}
+	if typ != "" && !matchesType(typ, value) {
+		return []ValidationError{{Loc: loc, Msg: fmt.Sprintf("Input should be a valid %s", typ), Type: typ + "_type"}}
+	}
+
+	var errs []ValidationError
+	switch v := value.(type) {
+	case map[string]interface{}:
+		errs = append(errs, s.validateObject(node, v, loc)...)
+	case []interface{}:
+		if min, ok := number(node["minItems"]); ok && float64(len(v)) < min {
+			errs = append(errs, ValidationError{Loc: loc, Msg: fmt.Sprintf("List should have at least %v items", min), Type: "too_short"})
+		}
+		if max, ok := number(node["maxItems"]); ok && float64(len(v)) > max {
+			errs = append(errs, ValidationError{Loc: loc, Msg: fmt.Sprintf("List should have at most %v items", max), Type: "too_long"})
+		}
+		if items, ok := node["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				errs = append(errs, s.validateNode(items, item, appendLoc(loc, i))...)
+			}
+		}
+	case string:
+		length := float64(len([]rune(v)))
+		if min, ok := number(node["minLength"]); ok && length < min {
+			errs = append(errs, ValidationError{Loc: loc, Msg: fmt.Sprintf("String should have at least %v characters", min), Type: "string_too_short"})
+		}
+		if max, ok := number(node["maxLength"]); ok && length > max {
+			errs = append(errs, ValidationError{Loc: loc, Msg: fmt.Sprintf("String should have at most %v characters", max), Type: "string_too_long"})
+		}
+		if pattern, ok := node["pattern"].(string); ok && !s.patterns[pattern].MatchString(v) {
+			errs = append(errs, ValidationError{Loc: loc, Msg: fmt.Sprintf("String should match pattern '%s'", pattern), Type: "string_pattern_mismatch"})
+		}
+	case float64:
+		if min, ok := number(node["minimum"]); ok && v < min {
+			errs = append(errs, ValidationError{Loc: loc, Msg: fmt.Sprintf("Input should be greater than or equal to %v", min), Type: "greater_than_equal"})
+		}
+		if max, ok := number(node["maximum"]); ok && v > max {
+			errs = append(errs, ValidationError{Loc: loc, Msg: fmt.Sprintf("Input should be less than or equal to %v", max), Type: "less_than_equal"})
+		}
+	}
+	return errs
+}
+
+// validateObject checks required, declared, and additional properties
+func (s *BodySchema) validateObject(node map[string]interface{}, obj map[string]interface{}, loc []interface{}) []ValidationError {
+	var errs []ValidationError
+	if required, ok := node["required"].([]interface{}); ok {
+		for _, name := range required {
+			field, _ := name.(string)
+			if _, exists := obj[field]; !exists {
+				errs = append(errs, ValidationError{Loc: appendLoc(loc, field), Msg: "Field required", Type: "missing"})
+			}
+		}
+	}
+	props, _ := node["properties"].(map[string]interface{})
+	for _, field := range sortedKeys(obj) {
+		propLoc := appendLoc(loc, field)
+		if prop, ok := props[field].(map[string]interface{}); ok {
+			errs = append(errs, s.validateNode(prop, obj[field], propLoc)...)
+		} else if allowed, ok := node["additionalProperties"].(bool); ok && !allowed {
+			errs = append(errs, ValidationError{Loc: propLoc, Msg: "Extra inputs are not permitted", Type: "extra_forbidden"})
+		}
+	}
+	return errs
+}
+
+// matchesType reports whether a decoded JSON value has the given schema type
+func matchesType(typ string, value interface{}) bool {
+	switch typ {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		f, ok := value.(float64)
+		return ok && f == math.Trunc(f)
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	}
+	return true
+}
+
+func number(v interface{}) (float64, bool) {
+	f, ok := v.(float64)
+	return f, ok
+}
+
+func containsValue(list []interface{}, value interface{}) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func formatEnum(enum []interface{}) string {
+	parts := make([]string, len(enum))
+	for i, item := range enum {
+		parts[i] = fmt.Sprintf("%v", item)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func appendLoc(loc []interface{}, part interface{}) []interface{} {
+	next := make([]interface{}, len(loc), len(loc)+1)
+	copy(next, loc)
+	return append(next, part)
+}
+
+// writeValidationErrors sends a 422 response listing the violations, in the
+// format chosen with SetValidationErrorFormat
+func writeValidationErrors(w http.ResponseWriter, r *http.Request, errs []ValidationError) {
+	if f := currentValidationFormat(); f != nil {
+		if body, ok := f.render(r, errs); ok {
+			w.Header().Set("Content-Type", f.contentType)
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			w.Write(body)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	if err := writeJSON(w, ValidationErrorResponse{Detail: errs}); err != nil {
+		slog.Error("Error encoding validation errors", "error", err)
+	}
+}
+
+// validateRequestBody checks the request body against the route schema. It
+// writes a 422 and returns false on failure; on success the body is left
+// readable for the handler.
+func validateRequestBody(w http.ResponseWriter, r *http.Request, schema *BodySchema) bool {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeBodyReadError(w, err)
+		return false
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	var decoded interface{}
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		writeValidationErrors(w, r, []ValidationError{{
+			Loc:  []interface{}{"body"},
+			Msg:  fmt.Sprintf("JSON decode error: %v", err),
+			Type: "json_invalid",
+		}})
+		return false
+	}
+	if errs := schema.Validate(decoded); len(errs) > 0 {
+		writeValidationErrors(w, r, errs)
+		return false
+	}
+	return true
+}
+
+// RegisterRouteSchema attaches a request body schema to a registered route.
+// Requests whose JSON body does not satisfy it are rejected with 422.
+//
+//export RegisterRouteSchema
+func RegisterRouteSchema(cPath *C.char, cMethod *C.char, cSchema *C.char) int {
+	if cPath == nil || cMethod == nil || cSchema == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in RegisterRouteSchema")
+	}
+	path := C.GoString(cPath)
+	method := strings.ToUpper(C.GoString(cMethod))
+	schema, err := parseBodySchema([]byte(C.GoString(cSchema)))
+	if err != nil {
+		return exportError(exportInvalid, "Invalid body schema", "method", method, "path", path, "error", err)
+	}
+
+	routesMu.Lock()
+	key := path + method
+	route, exists := routes[key]
+	if !exists {
+		routesMu.Unlock()
+		return exportError(exportNotFound, "Cannot set schema, route not found", "key", key)
+	}
+	route.BodySchema = schema
+	route.Responses[http.StatusUnprocessableEntity] = "Validation error"
+	routes[key] = route
+	routesMu.Unlock()
+
+	invalidateOpenAPICache()
+	slog.Info("Registered body schema", "key", key)
+	auditConfigChange("RegisterRouteSchema", map[string]string{"path": path, "method": method})
+
+	return 0
+}
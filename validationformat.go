@@ -0,0 +1,213 @@
+package main
+
+import (
+	"C"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"text/template"
+)
+
+// Validation error formats for SetValidationErrorFormat
+const (
+	ValidationFormatDetail   = "detail"   // FastAPI's {"detail": [...]}, the default
+	ValidationFormatProblem  = "problem"  // RFC 7807 application/problem+json
+	ValidationFormatTemplate = "template" // a host text/template
+)
+
+// ValidationFormatOptions configures the problem and template formats
+type ValidationFormatOptions struct {
+	Type        string `json:"type"`         // problem type URI; defaults to about:blank
+	Title       string `json:"title"`        // problem title; defaults to "Validation failed"
+	Template    string `json:"template"`     // text/template rendering the body, see validationTemplateData
+	ContentType string `json:"content_type"` // template body content type; defaults to application/json
+}
+
+// validationFormat is the active SetValidationErrorFormat configuration
+type validationFormat struct {
+	problemType string
+	title       string
+	tmpl        *template.Template
+	contentType string
+}
+
+// activeValidationFormat is nil while the default detail format is used
+var activeValidationFormat atomic.Pointer[validationFormat]
+
+// validationProblem is the problem format's body. Violations are listed
+// under the errors extension member in the detail format's shape.
+type validationProblem struct {
+	ProblemDetails
+	Errors []ValidationError `json:"errors"`
+}
+
+// currentValidationFormat returns the format chosen with
+// SetValidationErrorFormat, else the problem format under SetErrorFormat,
+// or nil for the detail format
+func currentValidationFormat() *validationFormat {
+	if f := activeValidationFormat.Load(); f != nil {
+		return f
+	}
+	if p := activeProblemFormat.Load(); p != nil {
+		return p.validation
+	}
+	return nil
+}
+
+// validationTemplateData is what a custom template renders. The json
+// function encodes a value, e.g. {"ok": false, "errors": {{json .Errors}}}.
+type validationTemplateData struct {
+	Status    int
+	Count     int
+	Method    string
+	Path      string
+	RequestID string
+	Errors    []ValidationError
+}
+
+var validationTemplateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// validationErrorsDetail summarizes a list of violations for humans
+func validationErrorsDetail(count int) string {
+	if count == 1 {
+		return "1 validation error"
+	}
+	return fmt.Sprintf("%d validation errors", count)
+}
+
+// render encodes errs in the format. It returns false when a template
+// fails, so the caller can fall back to the detail format.
+func (f *validationFormat) render(r *http.Request, errs []ValidationError) ([]byte, bool) {
+	ctx := newErrorContext(r, http.StatusUnprocessableEntity)
+	if f.tmpl != nil {
+		var buf bytes.Buffer
+		data := validationTemplateData{
+			Status:    http.StatusUnprocessableEntity,
+			Count:     len(errs),
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			RequestID: ctx.RequestID,
+			Errors:    errs,
+		}
+		if err := f.tmpl.Execute(&buf, data); err != nil {
+			slog.Error("Error rendering validation error template", "error", err)
+			return nil, false
+		}
+		return buf.Bytes(), true
+	}
+	ctx.Error = validationErrorsDetail(len(errs))
+	problem := validationProblem{ProblemDetails: ctx.problem(f.problemType), Errors: errs}
+	problem.Title = f.title
+	data, err := currentJSON().marshal(problem)
+	if err != nil {
+		slog.Error("Error encoding validation errors", "error", err)
+		return nil, false
+	}
+	return append(data, '\n'), true
+}
+
+// validationErrorContentType is the media type 422 responses are sent as
+func validationErrorContentType() string {
+	if f := currentValidationFormat(); f != nil {
+		return f.contentType
+	}
+	return "application/json"
+}
+
+// validationErrorSchema documents the 422 body of the active format
+func validationErrorSchema() map[string]interface{} {
+	f := currentValidationFormat()
+	switch {
+	case f == nil:
+		return builtinSchemas["HTTPValidationError"].(map[string]interface{})
+	case f.tmpl != nil && !strings.Contains(f.contentType, "json"):
+		return map[string]interface{}{"type": "string"}
+	case f.tmpl != nil:
+		return map[string]interface{}{"type": "object"}
+	}
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"type":       map[string]interface{}{"type": "string"},
+			"title":      map[string]interface{}{"type": "string"},
+			"status":     map[string]interface{}{"type": "integer"},
+			"detail":     map[string]interface{}{"type": "string"},
+			"instance":   map[string]interface{}{"type": "string"},
+			"request_id": map[string]interface{}{"type": "string"},
+			"trace_id":   map[string]interface{}{"type": "string"},
+			"errors":     map[string]interface{}{"type": "array", "items": schemaRef("ValidationError")},
+		},
+		"required": []string{"type", "title", "status", "errors"},
+	}
+}
+
+// SetValidationErrorFormat chooses the body of 422 responses for request
+// body, form, query, and path parameter validation failures: "detail" for
+// FastAPI's {"detail": [...]}, "problem" for RFC 7807 problem+json, or
+// "template" for a host text/template given in cOptions. cOptions is a JSON
+// ValidationFormatOptions object and may be empty. A template that fails
+// at request time falls back to the detail format. Returns 0, or a
+// negative status if the format is unknown or the template is invalid.
+//
+//export SetValidationErrorFormat
+func SetValidationErrorFormat(cFormat *C.char, cOptions *C.char) int {
+	if cFormat == nil || cOptions == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in SetValidationErrorFormat")
+	}
+	name := strings.ToLower(C.GoString(cFormat))
+	var opts ValidationFormatOptions
+	if text := C.GoString(cOptions); text != "" {
+		if err := json.Unmarshal([]byte(text), &opts); err != nil {
+			return exportError(exportInvalid, "Invalid validation error format options", "format", name, "error", err)
+		}
+	}
+
+	var f *validationFormat
+	switch name {
+	case "", ValidationFormatDetail:
+		name = ValidationFormatDetail
+	case ValidationFormatProblem:
+		f = &validationFormat{problemType: opts.Type, title: opts.Title, contentType: "application/problem+json"}
+		if f.problemType == "" {
+			f.problemType = "about:blank"
+		}
+		if f.title == "" {
+			f.title = "Validation failed"
+		}
+	case ValidationFormatTemplate:
+		tmpl, err := template.New("validation").Funcs(validationTemplateFuncs).Parse(opts.Template)
+		if err != nil || opts.Template == "" {
+			return exportError(exportInvalid, "Invalid validation error template", "error", err)
+		}
+		f = &validationFormat{tmpl: tmpl, contentType: opts.ContentType}
+		if f.contentType == "" {
+			f.contentType = "application/json"
+		}
+		// Render a sample so broken templates fail here rather than per request
+		var buf bytes.Buffer
+		sample := validationTemplateData{Status: http.StatusUnprocessableEntity, Count: 1, Errors: []ValidationError{{Loc: []interface{}{"body", "name"}, Msg: "Field required", Type: "missing"}}}
+		if err := tmpl.Execute(&buf, sample); err != nil {
+			return exportError(exportInvalid, "Invalid validation error template", "error", err)
+		}
+		if strings.Contains(f.contentType, "json") && !json.Valid(buf.Bytes()) {
+			return exportError(exportInvalid, "Validation error template does not render JSON", "content_type", f.contentType)
+		}
+	default:
+		return exportError(exportInvalid, "Unknown validation error format", "format", name)
+	}
+	activeValidationFormat.Store(f)
+	invalidateOpenAPICache()
+
+	slog.Info("Validation error format set", "format", name)
+	auditConfigChange("SetValidationErrorFormat", map[string]string{"format": name, "type": opts.Type, "content_type": opts.ContentType})
+	return 0
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"C"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Version selection strategies for ConfigureVersioning
+const (
+	VersionByPath      = "path"
+	VersionByHeader    = "header"
+	VersionByMediaType = "media_type"
+)
+
+// VersioningOptions configures how requests select an API version
+type VersioningOptions struct {
+	Strategy string `json:"strategy"` // path (default), header or media_type
+	Header   string `json:"header"`   // header naming the version under the header strategy; defaults to API-Version
+	Default  string `json:"default"`  // version of requests naming none under header and media_type; defaults to the earliest
+	Fallback *bool  `json:"fallback"` // serve the closest earlier version of a route a version lacks; defaults to true
+}
+
+// apiVersioning is the active VersioningOptions, resolved
+type apiVersioning struct {
+	strategy       string
+	header         string
+	defaultVersion int // 0 for the earliest registered version
+	fallback       bool
+}
+
+var (
+	activeVersioning atomic.Pointer[apiVersioning]
+
+	// apiVersions holds the versions routes were registered under, in
+	// ascending order. It is replaced rather than changed so requests read
+	// it without locking.
+	apiVersions   atomic.Pointer[[]int]
+	apiVersionsMu sync.Mutex
+)
+
+// currentVersioning returns the active options, path-based with fallback
+// until ConfigureVersioning is called
+func currentVersioning() *apiVersioning {
+	if v := activeVersioning.Load(); v != nil {
+		return v
+	}
+	return &apiVersioning{strategy: VersionByPath, header: "API-Version", fallback: true}
+}
+
+// registeredVersions lists the versions routes were registered under
+func registeredVersions() []int {
+	if v := apiVersions.Load(); v != nil {
+		return *v
+	}
+	return nil
+}
+
+// hasVersion reports whether routes were registered under version
+func hasVersion(version int) bool {
+	versions := registeredVersions()
+	i := sort.SearchInts(versions, version)
+	return i < len(versions) && versions[i] == version
+}
+
+// parseVersion reads a version name such as v2, V2 or 2
+func parseVersion(name string) (int, bool) {
+	name = strings.TrimSpace(name)
+	if len(name) > 0 && (name[0] == 'v' || name[0] == 'V') {
+		name = name[1:]
+	}
+	n, err := strconv.Atoi(name)
+	if err != nil || n <= 0 || strconv.Itoa(n) != name {
+		return 0, false
+	}
+	return n, true
+}
+
+// versionPrefix is the path prefix routes of a version are stored under
+func versionPrefix(version int) string {
+	return "/v" + strconv.Itoa(version)
+}
+
+// splitVersionPrefix splits a leading /v{n} segment off a request path
+func splitVersionPrefix(path string) (version int, rest string, ok bool) {
+	if !strings.HasPrefix(path, "/v") {
+		return 0, path, false
+	}
+	segment, rest := path[1:], "/"
+	if i := strings.IndexByte(segment, '/'); i >= 0 {
+		segment, rest = segment[:i], segment[i:]
+	}
+	version, ok = parseVersion(segment)
+	if !ok {
+		return 0, path, false
+	}
+	return version, rest, true
+}
+
+// requestedVersion reads the version a request without a path prefix asks
+// for under the header and media_type strategies. Media types name it
+// with a version parameter, application/json; version=2, or in a vendor
+// subtype, application/vnd.example.v2+json.
+func requestedVersion(r *http.Request, cfg *apiVersioning) (int, bool) {
+	switch cfg.strategy {
+	case VersionByHeader:
+		return parseVersion(r.Header.Get(cfg.header))
+	case VersionByMediaType:
+		for _, accept := range r.Header.Values("Accept") {
+			for _, part := range strings.Split(accept, ",") {
+				mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+				if err != nil {
+					continue
+				}
+				if version, ok := parseVersion(params["version"]); ok {
+					return version, true
+				}
+				subtype := mediaType[strings.IndexByte(mediaType, '/')+1:]
+				subtype, _, _ = strings.Cut(subtype, "+")
+				if i := strings.LastIndexByte(subtype, '.'); i >= 0 {
+					if version, ok := parseVersion(subtype[i+1:]); ok {
+						return version, true
+					}
+				}
+			}
+		}
+	}
+	return 0, false
+}
+
+// unversionedAccept rewrites the media ranges of an Accept header without
+// their version, so response negotiation sees application/json for
+// application/vnd.example.v2+json or application/json; version=2
+func unversionedAccept(values []string) string {
+	var ranges []string
+	for _, accept := range values {
+		for _, part := range strings.Split(accept, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err != nil {
+				continue
+			}
+			delete(params, "version")
+			if kind, subtype, ok := strings.Cut(mediaType, "/"); ok && strings.HasPrefix(subtype, "vnd.") {
+				if _, suffix, ok := strings.Cut(subtype, "+"); ok {
+					mediaType = kind + "/" + suffix
+				}
+			}
+			ranges = append(ranges, mime.FormatMediaType(mediaType, params))
+		}
+	}
+	return strings.Join(ranges, ", ")
+}
+
+// resolveVersionedPath finds the route serving rest in version: the
+// version's own route, else with fallback that of the closest earlier
+// version defining it. It returns the stored path and the version it
+// belongs to.
+func resolveVersionedPath(host, method, rest string, version int, fallback bool) (string, int, bool) {
+	versions := registeredVersions()
+	routesMu.RLock()
+	defer routesMu.RUnlock()
+	for i := len(versions) - 1; i >= 0; i-- {
+		v := versions[i]
+		if v > version || (v < version && !fallback) {
+			continue
+		}
+		path := groupPath(versionPrefix(v), rest)
+		if _, _, _, found := findRoute(host, path, method); found {
+			return path, v, true
+		}
+	}
+	return "", 0, false
+}
+
+// versionMiddleware maps requests onto versioned routes. Under the path
+// strategy the version comes from a /v{n} prefix; under header and
+// media_type from the request headers, with unprefixed paths. A version
+// lacking the route falls back to the closest earlier version that has
+// it; requests for unregistered versions or that no version serves pass
+// through unchanged, so unversioned routes keep working. The served
+// version is sent back as API-Version.
+func versionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		versions := registeredVersions()
+		if len(versions) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cfg := currentVersioning()
+		version, rest, prefixed := splitVersionPrefix(r.URL.Path)
+		if !prefixed {
+			if cfg.strategy == VersionByPath {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if cfg.strategy == VersionByHeader {
+				w.Header().Add("Vary", cfg.header)
+			} else {
+				w.Header().Add("Vary", "Accept")
+			}
+			var ok bool
+			if version, ok = requestedVersion(r, cfg); !ok {
+				version = cfg.defaultVersion
+				if version == 0 {
+					version = versions[0]
+				}
+			}
+		}
+
+		if !hasVersion(version) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		path, served, found := resolveVersionedPath(r.Host, r.Method, rest, version, cfg.fallback)
+		if !found {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("API-Version", "v"+strconv.Itoa(served))
+		if path != r.URL.Path || (!prefixed && cfg.strategy == VersionByMediaType) {
+			r2 := r.Clone(r.Context())
+			r2.URL.Path = path
+			r2.URL.RawPath = ""
+			if !prefixed && cfg.strategy == VersionByMediaType {
+				r2.Header.Set("Accept", unversionedAccept(r.Header.Values("Accept")))
+			}
+			r = r2
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// addVersion records a version routes were registered under
+func addVersion(version int) {
+	apiVersionsMu.Lock()
+	defer apiVersionsMu.Unlock()
+	if hasVersion(version) {
+		return
+	}
+	versions := registeredVersions()
+	i := sort.SearchInts(versions, version)
+	next := make([]int, 0, len(versions)+1)
+	next = append(next, versions[:i]...)
+	next = append(next, version)
+	next = append(next, versions[i:]...)
+	apiVersions.Store(&next)
+}
+
+// addVersionedRoute stores a route under its version's prefix
+func addVersionedRoute(version int, route RouteInfo, export string) {
+	rest := route.Path
+	route.Path = groupPath(versionPrefix(version), rest)
+	route.Parameters = pathParameters(route.Path)
+	route.Version = version
+
+	routesMu.Lock()
+	key := route.Path + route.Method
+	routes[key] = route
+	routeTree.insert(route.Path, route.Method, key)
+	routesMu.Unlock()
+	addVersion(version)
+	invalidateOpenAPICache()
+
+	slog.Info("Versioned route registered", "key", key, "version", version)
+	auditConfigChange(export, map[string]string{"version": "v" + strconv.Itoa(version), "path": rest, "method": route.Method, "description": route.Description})
+}
+
+// versionedOpenAPIDocument assembles the document of one version: its own
+// operations and, with fallback, those it inherits from earlier versions.
+// Paths carry the /v{n} prefix under the path strategy and none otherwise.
+func versionedOpenAPIDocument(version int, lang string) OpenAPI {
+	doc := openAPIDocument("", lang)
+	cfg := currentVersioning()
+	paths := make(map[string]map[string]interface{})
+	usedTags := make(map[string]bool)
+	versions := registeredVersions()
+	for i := len(versions) - 1; i >= 0; i-- {
+		v := versions[i]
+		if v > version || (v < version && !cfg.fallback) {
+			continue
+		}
+		for path, operations := range doc.Paths {
+			pathVersion, rest, ok := splitVersionPrefix(path)
+			if !ok || pathVersion != v {
+				continue
+			}
+			if cfg.strategy == VersionByPath {
+				rest = groupPath(versionPrefix(version), rest)
+			}
+			if paths[rest] == nil {
+				paths[rest] = make(map[string]interface{})
+			}
+			for method, operation := range operations {
+				if _, taken := paths[rest][method]; taken {
+					continue
+				}
+				paths[rest][method] = operation
+				if op, ok := operation.(map[string]interface{}); ok {
+					tags, _ := op["tags"].([]string)
+					for _, tag := range tags {
+						usedTags[tag] = true
+					}
+				}
+			}
+		}
+	}
+	doc.Paths = paths
+	doc.Tags = openAPITags(usedTags)
+	doc.Info["version"] = "v" + strconv.Itoa(version)
+	return doc
+}
+
+// ServeVersionedOpenAPI serves /openapi/v{n}.json, the OpenAPI document of
+// one API version, cached and revalidated like /openapi.json
+func ServeVersionedOpenAPI(w http.ResponseWriter, r *http.Request) {
+	name, ok := strings.CutSuffix(r.PathValue("file"), ".json")
+	version, valid := parseVersion(name)
+	if !ok || !valid || !hasVersion(version) {
+		http.NotFound(w, r)
+		return
+	}
+	lang := negotiateLanguage(r.Header.Get("Accept-Language"))
+	entry, err := cachedOpenAPI("@"+name, lang, func(_, lang string) ([]byte, error) {
+		return currentJSON().marshal(versionedOpenAPIDocument(version, lang))
+	})
+	if err != nil {
+		slog.Error("Error generating OpenAPI", "version", name, "error", err)
+		http.Error(w, `{"error": "Failed to generate OpenAPI"}`, http.StatusInternalServerError)
+		return
+	}
+	writeOpenAPIEntry(w, r, entry, lang)
+}
+
+// ConfigureVersioning sets how requests select an API version. cOptions
+// is a JSON VersioningOptions object and may be empty for the defaults:
+// path prefixes with fallback to earlier versions.
+//
+//export ConfigureVersioning
+func ConfigureVersioning(cOptions *C.char) int {
+	if cOptions == nil {
+		return exportError(exportInvalid, "cOptions is nil in ConfigureVersioning")
+	}
+	var opts VersioningOptions
+	if text := C.GoString(cOptions); text != "" {
+		if err := json.Unmarshal([]byte(text), &opts); err != nil {
+			return exportError(exportInvalid, "Invalid versioning options", "error", err)
+		}
+	}
+	cfg := &apiVersioning{strategy: strings.ToLower(opts.Strategy), header: opts.Header, fallback: true}
+	switch cfg.strategy {
+	case "":
+		cfg.strategy = VersionByPath
+	case VersionByPath, VersionByHeader, VersionByMediaType:
+	default:
+		return exportError(exportInvalid, "Unknown versioning strategy", "strategy", opts.Strategy)
+	}
+	if cfg.header == "" {
+		cfg.header = "API-Version"
+	}
+	if opts.Default != "" {
+		version, ok := parseVersion(opts.Default)
+		if !ok {
+			return exportError(exportInvalid, "Invalid default version", "version", opts.Default)
+		}
+		cfg.defaultVersion = version
+	}
+	if opts.Fallback != nil {
+		cfg.fallback = *opts.Fallback
+	}
+	activeVersioning.Store(cfg)
+	invalidateOpenAPICache()
+
+	slog.Info("API versioning configured", "strategy", cfg.strategy, "header", cfg.header, "default", cfg.defaultVersion, "fallback", cfg.fallback)
+	auditConfigChange("ConfigureVersioning", map[string]string{
+		"strategy": cfg.strategy,
+		"header":   cfg.header,
+		"default":  opts.Default,
+		"fallback": fmt.Sprint(cfg.fallback),
+	})
+	return 0
+}
+
+// RegisterVersionedRoute registers a static route under an API version
+// such as "v2", as RegisterRoute does. The route is stored under
+// /v{n}+cPath, the key the per-route setters take.
+//
+//export RegisterVersionedRoute
+func RegisterVersionedRoute(cVersion *C.char, cPath *C.char, cMethod *C.char, cMessage *C.char, cDesc *C.char) int {
+	if cVersion == nil || cPath == nil || cMethod == nil || cMessage == nil || cDesc == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in RegisterVersionedRoute")
+	}
+	version, ok := parseVersion(C.GoString(cVersion))
+	if !ok {
+		return exportError(exportInvalid, "Invalid API version", "export", "RegisterVersionedRoute", "version", C.GoString(cVersion))
+	}
+	addVersionedRoute(version, RouteInfo{
+		Path:        C.GoString(cPath),
+		Method:      strings.ToUpper(C.GoString(cMethod)),
+		Message:     C.GoString(cMessage),
+		Description: C.GoString(cDesc),
+		Responses:   map[int]string{200: "Successful response"},
+	}, "RegisterVersionedRoute")
+
+	return 0
+}
+
+// RegisterVersionedRouteHandler registers a host-handled route under an
+// API version, as RegisterRouteHandler does
+//
+//export RegisterVersionedRouteHandler
+func RegisterVersionedRouteHandler(cVersion *C.char, cPath *C.char, cMethod *C.char, cDesc *C.char, cHandler uintptr) int {
+	if cVersion == nil || cPath == nil || cMethod == nil || cDesc == nil || cHandler == 0 {
+		return exportError(exportInvalid, "One or more parameters are nil in RegisterVersionedRouteHandler")
+	}
+	version, ok := parseVersion(C.GoString(cVersion))
+	if !ok {
+		return exportError(exportInvalid, "Invalid API version", "export", "RegisterVersionedRouteHandler", "version", C.GoString(cVersion))
+	}
+	addVersionedRoute(version, RouteInfo{
+		Path:        C.GoString(cPath),
+		Method:      strings.ToUpper(C.GoString(cMethod)),
+		Description: C.GoString(cDesc),
+		Responses:   map[int]string{200: "Successful response"},
+		Handler:     cHandler,
+	}, "RegisterVersionedRouteHandler")
+
+	return 0
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"C"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// VirtualHostOptions configures a virtual host. The certificate, from
+// files or PEM, is offered by SNI to clients asking for the host once TLS
+// is enabled; other clients get the server certificate. Title, version,
+// and description head the host's OpenAPI document.
+type VirtualHostOptions struct {
+	Middleware  []groupMiddleware `json:"middleware"`
+	CertFile    string            `json:"cert_file"`
+	KeyFile     string            `json:"key_file"`
+	CertPEM     string            `json:"cert_pem"`
+	KeyPEM      string            `json:"key_pem"`
+	Title       string            `json:"title"`
+	Version     string            `json:"version"`
+	Description string            `json:"description"`
+}
+
+// virtualHost is a registered host name or *.suffix pattern with its own
+// routes, middleware, certificate, and OpenAPI document. Entries are
+// replaced, not modified, when the host is registered again.
+type virtualHost struct {
+	id    int
+	host  string
+	chain func(http.Handler) http.Handler
+	cert  *tls.Certificate
+	opts  VirtualHostOptions
+}
+
+var (
+	virtualHosts   = make(map[string]*virtualHost) // keyed by host pattern
+	virtualHostIDs = make(map[int]string)
+	virtualHostsMu sync.RWMutex
+	nextVHostID    = 1
+	hostTrees      = make(map[string]*routeNode) // route tree per host pattern; guarded by routesMu
+)
+
+// requestHostname lower-cases a Host header value and strips its port
+func requestHostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// virtualHostFor returns the virtual host serving a Host header value, or
+// nil for the default host. Exact names win over wildcards, and the longest
+// wildcard suffix wins.
+func virtualHostFor(host string) *virtualHost {
+	virtualHostsMu.RLock()
+	defer virtualHostsMu.RUnlock()
+	if len(virtualHosts) == 0 {
+		return nil
+	}
+	name := requestHostname(host)
+	if vh, ok := virtualHosts[name]; ok {
+		return vh
+	}
+	for i := strings.IndexByte(name, '.'); i >= 0; {
+		if vh, ok := virtualHosts["*"+name[i:]]; ok {
+			return vh
+		}
+		next := strings.IndexByte(name[i+1:], '.')
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+	return nil
+}
+
+// virtualHostPattern is the host pattern serving a Host header value, ""
+// for the default host
+func virtualHostPattern(host string) string {
+	if vh := virtualHostFor(host); vh != nil {
+		return vh.host
+	}
+	return ""
+}
+
+// routeTreeFor returns the routing tree for a Host header value. A virtual
+// host only serves its own routes. Must hold routesMu.
+func routeTreeFor(host string) *routeNode {
+	pattern := virtualHostPattern(host)
+	if pattern == "" {
+		return routeTree
+	}
+	if tree, ok := hostTrees[pattern]; ok {
+		return tree
+	}
+	return newRouteNode()
+}
+
+// virtualHostMiddleware runs a virtual host's middleware around the
+// dispatcher for requests addressed to it, after the global chain
+func virtualHostMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if vh := virtualHostFor(r.Host); vh != nil {
+			vh.chain(next).ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// virtualHostCertificate picks a virtual host's certificate by SNI. Nil
+// lets crypto/tls fall back to the server certificate.
+func virtualHostCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if hello.ServerName == "" {
+		return nil, nil
+	}
+	if vh := virtualHostFor(hello.ServerName); vh != nil && vh.cert != nil {
+		return vh.cert, nil
+	}
+	return nil, nil
+}
+
+// loadCertificate reads the host's certificate, if it has one
+func (o VirtualHostOptions) loadCertificate() (*tls.Certificate, error) {
+	var cert tls.Certificate
+	var err error
+	switch {
+	case o.CertPEM != "" || o.KeyPEM != "":
+		cert, err = tls.X509KeyPair([]byte(o.CertPEM), []byte(o.KeyPEM))
+	case o.CertFile != "" || o.KeyFile != "":
+		cert, err = tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// validVirtualHost reports whether pattern is a host name, optionally
+// starting with "*." to match every subdomain
+func validVirtualHost(pattern string) bool {
+	name := strings.TrimPrefix(pattern, "*.")
+	if name == "" || strings.ContainsAny(name, "*/:[] ") {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// addVirtualHostRoute stores a route in the host's routing tree. The
+// route's key is the host pattern followed by its path and method, which
+// is how the SetRoute* exports address it.
+func addVirtualHostRoute(vh *virtualHost, route RouteInfo, export string) {
+	if !strings.HasPrefix(route.Path, "/") {
+		route.Path = "/" + route.Path
+	}
+	route.Parameters = pathParameters(route.Path)
+	route.Host = vh.host
+
+	routesMu.Lock()
+	key := vh.host + route.Path + route.Method
+	routes[key] = route
+	hostTrees[vh.host].insert(route.Path, route.Method, key)
+	routesMu.Unlock()
+	invalidateOpenAPICache()
+
+	slog.Info("Virtual host route registered", "key", key, "host", vh.host)
+	auditConfigChange(export, map[string]string{"host": vh.host, "path": route.Path, "method": route.Method, "description": route.Description})
+}
+
+// lookupVirtualHost resolves a handle passed by the host
+func lookupVirtualHost(export string, handle int) (*virtualHost, bool) {
+	virtualHostsMu.RLock()
+	defer virtualHostsMu.RUnlock()
+	vh, ok := virtualHosts[virtualHostIDs[handle]]
+	if !ok {
+		recordError("Unknown virtual host", "export", export, "host", handle)
+	}
+	return vh, ok
+}
+
+// RegisterVirtualHost serves requests whose Host header is cHost, such as
+// "api.example.com" or "*.example.com", from their own set of routes.
+// cOptions is a VirtualHostOptions JSON object and may be empty: its
+// middleware list, written as for CreateRouteGroup, runs after the global
+// chain for every request to the host; its certificate is selected by SNI;
+// and its title heads the OpenAPI document served at /openapi.json under
+// the host. Routes registered with the returned handle only match
+// requests for the host, and requests for it only match those routes. The
+// SetRoute* exports address them as cHost followed by the path, e.g.
+// "api.example.com/users". Registering a host again replaces its options
+// and keeps its routes and handle. Returns 0 on error.
+//
+//export RegisterVirtualHost
+func RegisterVirtualHost(cHost *C.char, cOptions *C.char) int {
+	if cHost == nil || cOptions == nil {
+		recordError("One or more parameters are nil in RegisterVirtualHost")
+		return 0
+	}
+	host := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(C.GoString(cHost))), ".")
+	if !validVirtualHost(host) {
+		recordError("Invalid virtual host name", "host", host)
+		return 0
+	}
+	var opts VirtualHostOptions
+	if raw := C.GoString(cOptions); raw != "" {
+		dec := json.NewDecoder(strings.NewReader(raw))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&opts); err != nil {
+			recordError("Invalid virtual host options", "host", host, "error", err)
+			return 0
+		}
+	}
+	chain, names, err := buildGroupChain(opts.Middleware)
+	if err != nil {
+		recordError("Invalid virtual host middleware", "host", host, "error", err)
+		return 0
+	}
+	cert, err := opts.loadCertificate()
+	if err != nil {
+		recordError("Cannot load virtual host certificate", "host", host, "error", err)
+		return 0
+	}
+
+	routesMu.Lock()
+	if _, ok := hostTrees[host]; !ok {
+		hostTrees[host] = newRouteNode()
+	}
+	routesMu.Unlock()
+
+	virtualHostsMu.Lock()
+	vh := &virtualHost{host: host, chain: chain, cert: cert, opts: opts}
+	if existing, ok := virtualHosts[host]; ok {
+		vh.id = existing.id
+	} else {
+		vh.id = nextVHostID
+		nextVHostID++
+		virtualHostIDs[vh.id] = host
+	}
+	virtualHosts[host] = vh
+	virtualHostsMu.Unlock()
+	invalidateOpenAPICache()
+
+	slog.Info("Virtual host registered", "host", host, "id", vh.id, "middleware", names, "certificate", cert != nil)
+	auditConfigChange("RegisterVirtualHost", map[string]string{
+		"host":        host,
+		"id":          fmt.Sprint(vh.id),
+		"middleware":  strings.Join(names, ","),
+		"certificate": fmt.Sprint(cert != nil),
+	})
+	return vh.id
+}
+
+// RegisterVirtualHostRoute registers a static route served only under a
+// virtual host
+//
+//export RegisterVirtualHostRoute
+func RegisterVirtualHostRoute(handle int, cPath *C.char, cMethod *C.char, cMessage *C.char, cDesc *C.char) int {
+	if cPath == nil || cMethod == nil || cMessage == nil || cDesc == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in RegisterVirtualHostRoute")
+	}
+	vh, ok := lookupVirtualHost("RegisterVirtualHostRoute", handle)
+	if !ok {
+		return exportNotFound
+	}
+	addVirtualHostRoute(vh, RouteInfo{
+		Path:        C.GoString(cPath),
+		Method:      strings.ToUpper(C.GoString(cMethod)),
+		Message:     C.GoString(cMessage),
+		Description: C.GoString(cDesc),
+		Responses:   map[int]string{200: "Successful response"},
+	}, "RegisterVirtualHostRoute")
+
+	return 0
+}
+
+// RegisterVirtualHostRouteHandler registers a host-handled route, as
+// RegisterRouteHandler does, served only under a virtual host
+//
+//export RegisterVirtualHostRouteHandler
+func RegisterVirtualHostRouteHandler(handle int, cPath *C.char, cMethod *C.char, cDesc *C.char, cHandler uintptr) int {
+	if cPath == nil || cMethod == nil || cDesc == nil || cHandler == 0 {
+		return exportError(exportInvalid, "One or more parameters are nil in RegisterVirtualHostRouteHandler")
+	}
+	vh, ok := lookupVirtualHost("RegisterVirtualHostRouteHandler", handle)
+	if !ok {
+		return exportNotFound
+	}
+	addVirtualHostRoute(vh, RouteInfo{
+		Path:        C.GoString(cPath),
+		Method:      strings.ToUpper(C.GoString(cMethod)),
+		Description: C.GoString(cDesc),
+		Responses:   map[int]string{200: "Successful response"},
+		Handler:     cHandler,
+	}, "RegisterVirtualHostRouteHandler")
+
+	return 0
+}
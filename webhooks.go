@@ -0,0 +1,400 @@
+package main
+
+import (
+	"C"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultWebhookTimeout     = 10 * time.Second
+	defaultWebhookMaxAttempts = 5
+
+	// maxWebhookRetryAfter caps how long a receiver's Retry-After can
+	// hold a delivery back
+	maxWebhookRetryAfter = time.Hour
+
+	// maxWebhookDeliveries bounds the delivery history; the oldest entries
+	// are dropped
+	maxWebhookDeliveries = 1000
+)
+
+// Webhook delivery states
+const (
+	WebhookPending   = "pending"
+	WebhookRetrying  = "retrying"
+	WebhookDelivered = "delivered"
+	WebhookFailed    = "failed"
+)
+
+// WebhookOptions configures outbound webhook deliveries
+type WebhookOptions struct {
+	Retry     TaskRetryPolicy `json:"retry"`      // max_attempts defaults to 5
+	TimeoutMs int             `json:"timeout_ms"` // per attempt; defaults to 10000
+
+	// CircuitBreaker, when set, keeps one breaker per receiver URL.
+	// Attempts it fails fast count as failed attempts and are retried once
+	// it lets probes through.
+	CircuitBreaker *BreakerOptions `json:"circuit_breaker"`
+}
+
+// webhook is a registered subscription; event "*" receives every event
+type webhook struct {
+	event  string
+	url    string
+	secret string
+}
+
+// WebhookDelivery is one event sent to one webhook, as listed at /webhooks
+type WebhookDelivery struct {
+	ID            string     `json:"id"`
+	EventID       string     `json:"event_id"`
+	Event         string     `json:"event"`
+	URL           string     `json:"url"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	ResponseCode  int        `json:"response_code,omitempty"`
+	Error         string     `json:"error,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+}
+
+var (
+	webhooks       []webhook
+	webhookOptions WebhookOptions
+	webhookClient  = &http.Client{Transport: newTracingTransport(nil)}
+	webhooksMu     sync.RWMutex
+
+	webhookDeliveries   []*WebhookDelivery // oldest first
+	webhookDeliveriesMu sync.Mutex
+
+	webhookCounter atomic.Int64
+)
+
+// webhookSignature signs a delivery: HMAC-SHA256 over the timestamp, a dot,
+// and the body, so receivers can reject replays of old deliveries
+func webhookSignature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhooksFor returns the subscriptions an event goes to
+func webhooksFor(event string) []webhook {
+	webhooksMu.RLock()
+	defer webhooksMu.RUnlock()
+	var matched []webhook
+	for _, hook := range webhooks {
+		if hook.event == event || hook.event == "*" {
+			matched = append(matched, hook)
+		}
+	}
+	return matched
+}
+
+// recordWebhookDelivery adds a delivery to the history
+func recordWebhookDelivery(d *WebhookDelivery) {
+	webhookDeliveriesMu.Lock()
+	defer webhookDeliveriesMu.Unlock()
+	webhookDeliveries = append(webhookDeliveries, d)
+	if len(webhookDeliveries) > maxWebhookDeliveries {
+		webhookDeliveries = webhookDeliveries[len(webhookDeliveries)-maxWebhookDeliveries:]
+	}
+}
+
+// updateWebhookDelivery changes a delivery under the history lock
+func updateWebhookDelivery(d *WebhookDelivery, fn func(d *WebhookDelivery)) {
+	webhookDeliveriesMu.Lock()
+	defer webhookDeliveriesMu.Unlock()
+	fn(d)
+	d.UpdatedAt = time.Now()
+}
+
+// emitWebhookEvent queues deliveries of an event to its subscribers and
+// returns the event ID
+func emitWebhookEvent(event string, payload json.RawMessage) (string, int) {
+	id := fmt.Sprintf("evt-%d-%d", time.Now().UnixNano(), webhookCounter.Add(1))
+	body, _ := json.Marshal(map[string]interface{}{
+		"id":         id,
+		"event":      event,
+		"created_at": time.Now().UTC().Format(time.RFC3339),
+		"data":       payload,
+	})
+	hooks := webhooksFor(event)
+	for i, hook := range hooks {
+		now := time.Now()
+		d := &WebhookDelivery{
+			ID:        fmt.Sprintf("%s-%d", id, i+1),
+			EventID:   id,
+			Event:     event,
+			URL:       hook.url,
+			Status:    WebhookPending,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		recordWebhookDelivery(d)
+		go deliverWebhook(d, hook, body)
+	}
+	return id, len(hooks)
+}
+
+// deliverWebhook sends a delivery until it succeeds, fails permanently, or
+// runs out of attempts. 2xx responses succeed; network errors, 408, 429,
+// and 5xx responses are retried with the configured backoff, honouring a
+// Retry-After in seconds.
+func deliverWebhook(d *WebhookDelivery, hook webhook, body []byte) {
+	webhooksMu.RLock()
+	opts := webhookOptions
+	webhooksMu.RUnlock()
+	maxAttempts := opts.Retry.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultWebhookMaxAttempts
+	}
+	timeout := defaultWebhookTimeout
+	if opts.TimeoutMs > 0 {
+		timeout = time.Duration(opts.TimeoutMs) * time.Millisecond
+	}
+
+	var breaker *circuitBreaker
+	if opts.CircuitBreaker != nil {
+		breaker = breakerFor("webhook:"+hook.url, *opts.CircuitBreaker)
+	}
+
+	for attempt := 1; ; attempt++ {
+		var code int
+		var retryAfter time.Duration
+		var err error
+		if ok, retryIn := breaker.allow(); ok {
+			code, retryAfter, err = sendWebhook(d, hook, body, timeout)
+			breaker.done(err == nil && code < 500, false)
+		} else {
+			err, retryAfter = errCircuitOpen, retryIn
+		}
+		retryable := err != nil || code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500
+		if err == nil && code >= 200 && code < 300 {
+			updateWebhookDelivery(d, func(d *WebhookDelivery) {
+				d.Status, d.Attempts, d.ResponseCode, d.Error, d.NextAttemptAt = WebhookDelivered, attempt, code, "", nil
+			})
+			slog.Debug("Webhook delivered", "delivery_id", d.ID, "event", d.Event, "url", d.URL, "attempt", attempt)
+			return
+		}
+		var message string
+		if err != nil {
+			message = err.Error()
+		} else {
+			message = fmt.Sprintf("unexpected status %d", code)
+		}
+		if !retryable || attempt >= maxAttempts {
+			updateWebhookDelivery(d, func(d *WebhookDelivery) {
+				d.Status, d.Attempts, d.ResponseCode, d.Error, d.NextAttemptAt = WebhookFailed, attempt, code, message, nil
+			})
+			slog.Warn("Webhook delivery failed", "delivery_id", d.ID, "event", d.Event, "url", d.URL, "attempts", attempt, "error", message)
+			return
+		}
+		delay := opts.Retry.delay(attempt)
+		if retryAfter > delay {
+			delay = min(retryAfter, maxWebhookRetryAfter)
+		}
+		next := time.Now().Add(delay)
+		updateWebhookDelivery(d, func(d *WebhookDelivery) {
+			d.Status, d.Attempts, d.ResponseCode, d.Error, d.NextAttemptAt = WebhookRetrying, attempt, code, message, &next
+		})
+		slog.Debug("Webhook delivery will be retried", "delivery_id", d.ID, "url", d.URL, "attempt", attempt, "delay", delay, "error", message)
+		time.Sleep(delay)
+	}
+}
+
+// sendWebhook makes one delivery attempt, returning the response status and
+// any Retry-After it asked for
+func sendWebhook(d *WebhookDelivery, hook webhook, body []byte, timeout time.Duration) (int, time.Duration, error) {
+	req, err := http.NewRequest(http.MethodPost, hook.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "goserver-webhooks")
+	req.Header.Set("X-Webhook-Event", d.Event)
+	req.Header.Set("X-Webhook-ID", d.EventID)
+	req.Header.Set("X-Webhook-Delivery", d.ID)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	if hook.secret != "" {
+		req.Header.Set("X-Webhook-Signature", webhookSignature(hook.secret, timestamp, body))
+	}
+	client := *webhookClient
+	client.Timeout = timeout
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	var retryAfter time.Duration
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		retryAfter = time.Duration(seconds) * time.Second
+	}
+	return resp.StatusCode, retryAfter, nil
+}
+
+// webhooksHandler serves GET /webhooks once a webhook is registered, and
+// otherwise leaves the path to registered routes
+func webhooksHandler(dispatch http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		webhooksMu.RLock()
+		registered := len(webhooks) > 0
+		webhooksMu.RUnlock()
+		if !registered {
+			dispatch.ServeHTTP(w, r)
+			return
+		}
+		ServeWebhookDeliveries(w, r)
+	})
+}
+
+// ServeWebhookDeliveries lists recent webhook deliveries, newest first.
+// The event, event_id, and status query parameters filter the list and
+// limit caps it (default 100).
+func ServeWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit := 100
+	if raw := query.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			http.Error(w, `{"error": "limit must be a positive integer"}`, http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	deliveries := make([]WebhookDelivery, 0)
+	webhookDeliveriesMu.Lock()
+	for i := len(webhookDeliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		d := webhookDeliveries[i]
+		if event := query.Get("event"); event != "" && d.Event != event {
+			continue
+		}
+		if eventID := query.Get("event_id"); eventID != "" && d.EventID != eventID {
+			continue
+		}
+		if status := query.Get("status"); status != "" && d.Status != status {
+			continue
+		}
+		deliveries = append(deliveries, *d)
+	}
+	webhookDeliveriesMu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if err := writeJSON(w, deliveries); err != nil {
+		slog.Error("Error encoding webhook deliveries", "error", err)
+	}
+}
+
+// RegisterWebhook subscribes cURL to an event, or to every event when
+// cEvent is "*". Deliveries carry an X-Webhook-Signature of
+// sha256=HMAC(secret, timestamp + "." + body) when cSecret is not empty.
+// Registering the same event and URL again replaces its secret.
+//
+//export RegisterWebhook
+func RegisterWebhook(cEvent *C.char, cURL *C.char, cSecret *C.char) int {
+	if cEvent == nil || cURL == nil || cSecret == nil {
+		return exportError(exportInvalid, "One or more parameters are nil in RegisterWebhook")
+	}
+	event := C.GoString(cEvent)
+	target := C.GoString(cURL)
+	if event == "" {
+		return exportError(exportInvalid, "Empty event in RegisterWebhook")
+	}
+	if u, err := url.Parse(target); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return exportError(exportInvalid, "Invalid webhook URL", "url", target)
+	}
+	hook := webhook{event: event, url: target, secret: C.GoString(cSecret)}
+	webhooksMu.Lock()
+	replaced := false
+	for i, existing := range webhooks {
+		if existing.event == event && existing.url == target {
+			webhooks[i], replaced = hook, true
+		}
+	}
+	if !replaced {
+		webhooks = append(webhooks, hook)
+	}
+	webhooksMu.Unlock()
+
+	slog.Info("Webhook registered", "event", event, "url", target, "signed", hook.secret != "")
+	auditConfigChange("RegisterWebhook", map[string]string{"event": event, "url": target})
+
+	return 0
+}
+
+// ConfigureWebhooks sets the retry policy and per-attempt timeout of
+// webhook deliveries. cOptions is a JSON WebhookOptions object.
+//
+//export ConfigureWebhooks
+func ConfigureWebhooks(cOptions *C.char) int {
+	if cOptions == nil {
+		return exportError(exportInvalid, "cOptions is nil in ConfigureWebhooks")
+	}
+	options := C.GoString(cOptions)
+	var opts WebhookOptions
+	if options != "" {
+		if err := json.Unmarshal([]byte(options), &opts); err != nil {
+			return exportError(exportInvalid, "Invalid webhook options", "error", err)
+		}
+	}
+	if opts.TimeoutMs < 0 || opts.Retry.MaxAttempts < 0 || (opts.Retry.Backoff != "" && opts.Retry.Backoff != BackoffExponential && opts.Retry.Backoff != BackoffFixed) {
+		return exportError(exportInvalid, "Invalid webhook options", "timeout_ms", opts.TimeoutMs, "max_attempts", opts.Retry.MaxAttempts, "backoff", opts.Retry.Backoff)
+	}
+	if opts.CircuitBreaker != nil {
+		if err := opts.CircuitBreaker.validate(); err != nil {
+			return exportError(exportInvalid, "Invalid webhook options", "error", err)
+		}
+	}
+	webhooksMu.Lock()
+	webhookOptions = opts
+	webhooksMu.Unlock()
+	// Receivers get fresh breakers with the new settings on their next delivery
+	removeBreakers("webhook:")
+
+	slog.Info("Webhooks configured", "max_attempts", opts.Retry.MaxAttempts, "timeout_ms", opts.TimeoutMs)
+	auditConfigChange("ConfigureWebhooks", map[string]string{"options": options})
+
+	return 0
+}
+
+// EmitEvent delivers an event with the JSON payload cPayload to the
+// webhooks subscribed to it, in the background. Returns the event ID, which
+// is also sent to receivers for deduplication, or NULL if the payload is
+// not JSON. The caller must release the ID with FreeString.
+//
+//export EmitEvent
+func EmitEvent(cEvent *C.char, cPayload *C.char) *C.char {
+	if cEvent == nil || cPayload == nil {
+		recordError("One or more parameters are nil in EmitEvent")
+		return nil
+	}
+	event := C.GoString(cEvent)
+	payload := C.GoString(cPayload)
+	if payload == "" {
+		payload = "null"
+	}
+	if event == "" || !json.Valid([]byte(payload)) {
+		recordError("Invalid event in EmitEvent", "event", event)
+		return nil
+	}
+	id, deliveries := emitWebhookEvent(event, json.RawMessage(payload))
+	slog.Debug("Event emitted", "event", event, "event_id", id, "deliveries", deliveries)
+	return C.CString(id)
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"C"
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+	"unsafe"
+)
+
+// Connection events delivered to WebSocket and SSE host callbacks
+const (
+	connEventOpen    = 0
+	connEventMessage = 1
+	connEventClose   = 2
+)
+
+// Frame opcodes from RFC 6455 section 5.2
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+)
+
+// Close status codes from RFC 6455 section 7.4.1
+const (
+	wsCloseNormal        = 1000
+	wsCloseGoingAway     = 1001
+	wsCloseProtocolError = 1002
+	wsCloseTooBig        = 1009
+)
+
+const (
+	wsGUID           = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	maxWebSocketMsg  = 1 << 20 // largest reassembled message accepted
+	wsWriteTimeout   = 10 * time.Second
+	wsMaxControlSize = 125
+)
+
+var (
+	errWebSocketClosed      = errors.New("websocket connection is closed")
+	errWebSocketFrameTooBig = errors.New("websocket frame too large")
+	errWebSocketUnmasked    = errors.New("websocket client frame is not masked")
+	errWebSocketBadControl  = errors.New("websocket control frame is invalid")
+)
+
+// wsConn is one upgraded connection
+type wsConn struct {
+	id      string
+	conn    net.Conn
+	reader  *bufio.Reader
+	handler uintptr
+	writeMu sync.Mutex // serializes frames written by the read loop and the host
+	closed  atomic.Bool
+}
+
+var (
+	wsConns     = make(map[string]*wsConn)
+	wsConnsMu   sync.Mutex
+	wsConnCount atomic.Uint64
+)
+
+// headerContainsToken reports whether a comma-separated header has token
+func headerContainsToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// websocketAccept computes the Sec-WebSocket-Accept value for a client key
+func websocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// serveWebSocket completes the upgrade handshake and hands the connection
+// to a read loop. The handler returns once upgraded so the request does not
+// hold an admission slot for the life of the connection.
+func serveWebSocket(w http.ResponseWriter, r *http.Request, route RouteInfo) {
+	if r.Method != http.MethodGet ||
+		!headerContainsToken(r.Header, "Connection", "upgrade") ||
+		!headerContainsToken(r.Header, "Upgrade", "websocket") {
+		http.Error(w, `{"error": "WebSocket upgrade required"}`, http.StatusBadRequest)
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, `{"error": "Unsupported WebSocket version"}`, http.StatusUpgradeRequired)
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, `{"error": "Missing Sec-WebSocket-Key"}`, http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Upgrade", "websocket")
+	w.Header().Set("Connection", "Upgrade")
+	w.Header().Set("Sec-WebSocket-Accept", websocketAccept(key))
+	w.WriteHeader(http.StatusSwitchingProtocols)
+	// Hijack flushes the 101 response before handing over the connection
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		slog.Error("Error hijacking WebSocket connection", "route", route.Path, "error", err)
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	c := &wsConn{
+		id:      fmt.Sprintf("ws-%d", wsConnCount.Add(1)),
+		conn:    conn,
+		reader:  rw.Reader,
+		handler: route.WebSocket,
+	}
+	wsConnsMu.Lock()
+	wsConns[c.id] = c
+	wsConnsMu.Unlock()
+
+	slog.Info("WebSocket connection opened", "conn_id", c.id, "route", route.Path, "remote_addr", r.RemoteAddr)
+	go c.readLoop()
+}
+
+// readLoop reads frames until the connection closes, answering control
+// frames and delivering complete messages to the host
+func (c *wsConn) readLoop() {
+	callConnHandler(c.handler, c.id, connEventOpen, nil)
+	defer func() {
+		c.close(wsCloseNormal)
+		callConnHandler(c.handler, c.id, connEventClose, nil)
+		slog.Info("WebSocket connection closed", "conn_id", c.id)
+	}()
+
+	var message []byte
+	fragmented := false
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			if errors.Is(err, errWebSocketFrameTooBig) {
+				c.close(wsCloseTooBig)
+			} else if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				slog.Debug("WebSocket read error", "conn_id", c.id, "error", err)
+				c.close(wsCloseProtocolError)
+			}
+			return
+		}
+
+		switch opcode {
+		case wsOpPing:
+			if err := c.writeFrame(wsOpPong, payload); err != nil {
+				return
+			}
+		case wsOpPong:
+		case wsOpClose:
+			return
+		case wsOpText, wsOpBinary, wsOpContinuation:
+			if (opcode == wsOpContinuation) != fragmented {
+				c.close(wsCloseProtocolError)
+				return
+			}
+			if len(message)+len(payload) > maxWebSocketMsg {
+				c.close(wsCloseTooBig)
+				return
+			}
+			message = append(message, payload...)
+			fragmented = !fin
+			if fin {
+				callConnHandler(c.handler, c.id, connEventMessage, message)
+				message = nil
+			}
+		default:
+			c.close(wsCloseProtocolError)
+			return
+		}
+	}
+}
+
+// readFrame reads and unmasks one client frame
+func (c *wsConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(c.reader, head[:]); err != nil {
+		return
+	}
+	fin = head[0]&0x80 != 0
+	opcode = head[0] & 0x0F
+	if head[1]&0x80 == 0 {
+		err = errWebSocketUnmasked
+		return
+	}
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.reader, ext[:]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.reader, ext[:]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if opcode >= wsOpClose && (!fin || length > wsMaxControlSize) {
+		err = errWebSocketBadControl
+		return
+	}
+	if length > maxWebSocketMsg {
+		err = errWebSocketFrameTooBig
+		return
+	}
+	var mask [4]byte
+	if _, err = io.ReadFull(c.reader, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.reader, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return
+}
+
+// writeFrame writes one unmasked, unfragmented server frame
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	if c.closed.Load() {
+		return errWebSocketClosed
+	}
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// close sends a close frame with code and closes the connection once
+func (c *wsConn) close(code int) {
+	if c.closed.Load() {
+		return
+	}
+	c.writeFrame(wsOpClose, binary.BigEndian.AppendUint16(nil, uint16(code)))
+	if !c.closed.CompareAndSwap(false, true) {
+		return
+	}
+	c.conn.Close()
+	wsConnsMu.Lock()
+	delete(wsConns, c.id)
+	wsConnsMu.Unlock()
+}
+
+// closeWebSockets closes every open connection; hijacked connections are
+// not tracked by http.Server.Shutdown
+func closeWebSockets() {
+	wsConnsMu.Lock()
+	open := make([]*wsConn, 0, len(wsConns))
+	for _, c := range wsConns {
+		open = append(open, c)
+	}
+	wsConnsMu.Unlock()
+	for _, c := range open {
+		c.close(wsCloseGoingAway)
+	}
+}
+
+// RegisterWebSocketRoute registers a WebSocket endpoint. The host callback
+// is called with the connection ID on open, for each message, and on close.
+//
+//export RegisterWebSocketRoute
+func RegisterWebSocketRoute(cPath *C.char, cDesc *C.char, cHandler uintptr) int {
+	if cPath == nil || cDesc == nil || cHandler == 0 {
+		return exportError(exportInvalid, "One or more parameters are nil in RegisterWebSocketRoute")
+	}
+	path := C.GoString(cPath)
+	desc := C.GoString(cDesc)
+	method := http.MethodGet
+
+	routesMu.Lock()
+	key := path + method
+	routes[key] = RouteInfo{
+		Path:        path,
+		Method:      method,
+		Description: desc,
+		Parameters:  pathParameters(path),
+		Responses: map[int]string{
+			101: "Switching to the WebSocket protocol",
+			400: "Not a WebSocket upgrade request",
+		},
+		WebSocket: cHandler,
+	}
+	routeTree.insert(path, method, key)
+	routesMu.Unlock()
+	slog.Info("WebSocket route registered", "key", key)
+	invalidateOpenAPICache()
+	auditConfigChange("RegisterWebSocketRoute", map[string]string{"path": path, "description": desc})
+
+	return 0
+}
+
+// SendWebSocketMessage pushes a message to an open connection. Valid UTF-8
+// is sent as a text frame, anything else as binary.
+//
+//export SendWebSocketMessage
+func SendWebSocketMessage(cConnID *C.char, cData *C.char, dataLen int) int {
+	if cConnID == nil || (cData == nil && dataLen > 0) || dataLen < 0 {
+		return exportError(exportInvalid, "One or more parameters are nil in SendWebSocketMessage")
+	}
+	connID := C.GoString(cConnID)
+	var data []byte
+	if dataLen > 0 {
+		data = C.GoBytes(unsafe.Pointer(cData), C.int(dataLen))
+	}
+
+	wsConnsMu.Lock()
+	c, ok := wsConns[connID]
+	wsConnsMu.Unlock()
+	if !ok {
+		return exportError(exportNotFound, "Cannot send WebSocket message, connection not found", "conn_id", connID)
+	}
+	opcode := byte(wsOpText)
+	if !utf8.Valid(data) {
+		opcode = wsOpBinary
+	}
+	if err := c.writeFrame(opcode, data); err != nil {
+		return exportError(exportFailed, "Error sending WebSocket message", "conn_id", connID, "error", err)
+	}
+
+	return 0
+}